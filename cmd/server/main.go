@@ -8,12 +8,15 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"ai_gateway/internal/config"
 	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
 	"ai_gateway/internal/handlers"
 	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
 
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
@@ -62,6 +65,7 @@ func main() {
 	// Create Echo instance
 	e := echo.New()
 	e.HideBanner = true
+	e.IPExtractor = middleware.ClientIPExtractor(cfg.TrustedProxyNets())
 
 	// Setup template renderer
 	renderer := handlers.NewTemplateRenderer("templates")
@@ -70,13 +74,16 @@ func main() {
 	// Static files
 	e.Static("/static", "static")
 
-	// Middleware
-	e.Use(echomw.Logger())
+	// Middleware (logging paths rather than full URIs: Gemini clients put their
+	// API key in the query string)
+	e.Use(echomw.LoggerWithConfig(echomw.LoggerConfig{
+		Format: strings.Replace(echomw.DefaultLoggerConfig.Format, "${uri}", "${path}", 1),
+	}))
 	e.Use(echomw.Recover())
 	e.Use(echomw.CORSWithConfig(echomw.CORSConfig{
 		AllowOrigins: []string{"*"},
 		AllowMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, "X-API-Key"},
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, "X-API-Key", "X-Goog-Api-Key", middleware.HeaderIdempotencyKey, middleware.HeaderStreamFlush, middleware.HeaderStreamRate},
 	}))
 
 	// Initialize handlers
@@ -90,42 +97,252 @@ func main() {
 		return c.JSON(http.StatusOK, map[string]string{"status": "healthy"})
 	})
 
+	// Prometheus metrics, served only to holders of METRICS_TOKEN
+	e.GET("/metrics", h.Metrics)
+	if cfg.MetricsToken == "" {
+		log.Printf("Metrics are disabled: set METRICS_TOKEN to serve /metrics")
+	}
+
 	// Add DB middleware for all routes that need it
 	e.Use(middleware.DBMiddleware(db))
 
+	// Record management API mutations in the audit log
+	e.Use(middleware.Audit(h.AuditService()))
+
 	// Auth routes (public)
 	auth := e.Group("/api/auth")
 	auth.POST("/register", h.Register)
 	auth.POST("/login", h.Login)
 	auth.GET("/me", h.GetCurrentUser, middleware.JWTAuth(cfg))
+	auth.POST("/logout", h.Logout, middleware.JWTAuth(cfg))
+	auth.GET("/sessions", h.ListSessions, middleware.JWTAuth(cfg))
+	auth.DELETE("/sessions", h.RevokeOtherSessions, middleware.JWTAuth(cfg))
+	auth.DELETE("/sessions/:id", h.RevokeSession, middleware.JWTAuth(cfg))
 
 	// Config routes (JWT protected)
 	configGroup := e.Group("/api/config", middleware.JWTAuth(cfg))
 	configGroup.GET("/providers", h.GetProviderConfigs)
 	configGroup.GET("/providers/:provider", h.GetProviderConfigsByProvider)
 	configGroup.POST("/providers", h.CreateProviderConfig)
+	configGroup.PUT("/providers/by-name/:name", h.ProvisionProviderConfig)
 	configGroup.GET("/providers/id/:id", h.GetProviderConfigByID)
 	configGroup.PUT("/providers/:id", h.UpdateProviderConfig)
 	configGroup.DELETE("/providers/:id", h.DeleteProviderConfig)
 	configGroup.PUT("/providers/:id/default", h.SetDefaultProviderConfig)
 	configGroup.PUT("/providers/:id/toggle", h.ToggleProviderConfig)
+	configGroup.GET("/providers/:id/history", h.GetProviderConfigHistory)
+	configGroup.POST("/providers/:id/rollback", h.RollbackProviderConfig)
+	configGroup.GET("/providers/:id/health", h.GetProviderConfigHealth)
+	configGroup.GET("/providers/:id/slo", h.GetProviderSLO)
+	configGroup.PUT("/providers/:id/slo", h.SetProviderSLO)
+	configGroup.DELETE("/providers/:id/slo", h.DeleteProviderSLO)
+	configGroup.GET("/providers/:id/slo/status", h.GetProviderSLOStatus)
 
 	// API Key routes (JWT protected)
 	keysGroup := e.Group("/api/keys", middleware.JWTAuth(cfg))
 	keysGroup.GET("", h.ListAPIKeys)
 	keysGroup.POST("", h.CreateAPIKey)
+	keysGroup.POST("/canary", h.CreateCanaryKey)
+	keysGroup.PUT("/by-name/:name", h.ProvisionAPIKey)
+	keysGroup.POST("/bulk/deactivate", h.BulkDeactivateAPIKeys)
+	keysGroup.POST("/bulk/delete", h.BulkDeleteAPIKeys)
+	keysGroup.POST("/bulk/limits", h.BulkUpdateAPIKeyLimits)
 	keysGroup.GET("/:id", h.GetAPIKey)
 	keysGroup.PUT("/:id", h.UpdateAPIKey)
 	keysGroup.POST("/:id/rotate", h.RotateAPIKey)
+	keysGroup.POST("/:id/signing-secret", h.EnableAPIKeySigning)
+	keysGroup.DELETE("/:id/signing-secret", h.DisableAPIKeySigning)
 	keysGroup.DELETE("/:id", h.DeleteAPIKey)
 	keysGroup.GET("/:id/usage", h.GetAPIKeyUsage)
+	keysGroup.GET("/:id/canary-hits", h.ListCanaryHits)
+	keysGroup.GET("/:id/usage/tags", h.GetAPIKeyTagUsage)
+	keysGroup.GET("/:id/end-users", h.ListEndUsers)
+	keysGroup.GET("/:id/end-users/:end_user", h.GetEndUser)
+	keysGroup.PUT("/:id/end-users/:end_user", h.SetEndUser)
+	keysGroup.DELETE("/:id/end-users/:end_user", h.DeleteEndUser)
 
 	// AI Gateway routes (API Key or JWT auth)
-	v1 := e.Group("/v1", middleware.GatewayAuth(db, cfg))
+	archiveService := services.NewArchiveService(db, cfg)
+	gatewayMiddleware := []echo.MiddlewareFunc{
+		middleware.Maintenance(h.MaintenanceService()),
+		middleware.Decompress(int64(cfg.DecompressMaxBodyBytes)),
+		middleware.StreamBuffer(time.Duration(cfg.StreamFlushInterval)*time.Millisecond, cfg.StreamFlushBytes),
+		middleware.Compress(),
+		middleware.StreamPace(),
+		middleware.GatewayAuth(db, cfg, h.CredentialCache(), h.MetricsCollector(), h.CanaryService()),
+		middleware.RateLimitHeaders(cfg.UsageResetLocation()),
+		middleware.StreamReplay(services.NewStreamReplayService(cfg)),
+		middleware.Credits(services.NewCreditService(db)),
+		middleware.LatencyBudget(),
+		middleware.Archive(archiveService),
+		middleware.RequestLog(h.RequestLogService()),
+		middleware.PromptInjection(services.NewPromptInjectionService(cfg)),
+		middleware.Idempotency(services.NewIdempotencyService(db, cfg), int64(cfg.IdempotencyMaxBodyBytes)),
+		middleware.UpstreamMetrics(h.MetricsCollector()),
+		middleware.StreamTracking(h.StreamTracker()),
+		middleware.UpstreamHeaders(cfg.UpstreamHeaderAllowlist),
+		middleware.ResponseModel(),
+		middleware.ToolArguments(),
+		middleware.StreamSalvage(),
+		middleware.GatewayRecover(h.MetricsCollector()),
+	}
+	v1 := e.Group("/v1", gatewayMiddleware...)
+	v1.GET("/models", h.ListModels)
+	v1.GET("/models/:model", h.GetModel)
 	v1.POST("/chat/completions", h.OpenAIChatCompletions)
 	v1.POST("/responses", h.OpenAICodeResponses)
-	v1.POST("/messages", h.AnthropicMessages)
-	v1.POST("/models/:model", h.GeminiGenerateContent)
+	v1.GET("/responses/:id", h.GetStoredResponse)
+	v1.DELETE("/responses/:id", h.DeleteStoredResponse)
+	v1.POST("/responses/:id/cancel", h.CancelBackgroundResponse)
+
+	// Ephemeral tokens, minted from an API key for browser and mobile clients
+	e.POST("/v1/auth/ephemeral", h.CreateEphemeralToken, middleware.GatewayAuth(db, cfg, h.CredentialCache(), h.MetricsCollector(), h.CanaryService()))
+
+	// Anthropic Messages route, with errors in Anthropic's format for its SDKs
+	anthropicMiddleware := append([]echo.MiddlewareFunc{middleware.AnthropicRoute()}, gatewayMiddleware...)
+	e.Group("/v1/messages", anthropicMiddleware...).POST("", h.AnthropicMessages)
+
+	// Gemini routes, as called by Google's SDKs (?key= auth, Gemini errors)
+	geminiMiddleware := append([]echo.MiddlewareFunc{middleware.GeminiRoute()}, gatewayMiddleware...)
+	for _, prefix := range []string{"/v1/models", "/v1beta/models"} {
+		e.Group(prefix, geminiMiddleware...).POST("/:model", h.GeminiGenerateContent)
+	}
+
+	// OpenAI Files API passthrough
+	v1.POST("/files", h.UploadFile)
+	v1.GET("/files", h.ListFiles)
+	v1.GET("/files/:id", h.GetFile)
+	v1.GET("/files/:id/content", h.GetFileContent)
+	v1.DELETE("/files/:id", h.DeleteFile)
+
+	// OpenAI Assistants API v2 passthrough
+	for _, prefix := range []string{"/assistants", "/threads"} {
+		v1.GET(prefix, h.AssistantsPassthrough)
+		v1.POST(prefix, h.AssistantsPassthrough)
+		v1.GET(prefix+"/*", h.AssistantsPassthrough)
+		v1.POST(prefix+"/*", h.AssistantsPassthrough)
+		v1.DELETE(prefix+"/*", h.AssistantsPassthrough)
+	}
+
+	// Hedge policy routes (JWT protected)
+	hedging := e.Group("/api/hedging", middleware.JWTAuth(cfg))
+	hedging.GET("/policies", h.GetHedgePolicies)
+	hedging.POST("/policies", h.SetHedgePolicy)
+	hedging.DELETE("/policies/:id", h.DeleteHedgePolicy)
+
+	// Context upgrade policy routes (JWT protected)
+	contextUpgrades := e.Group("/api/context-upgrades", middleware.JWTAuth(cfg))
+	contextUpgrades.GET("/policies", h.GetContextUpgradePolicies)
+	contextUpgrades.POST("/policies", h.SetContextUpgradePolicy)
+	contextUpgrades.DELETE("/policies/:id", h.DeleteContextUpgradePolicy)
+
+	// Cascade policy routes (JWT protected)
+	cascades := e.Group("/api/cascades", middleware.JWTAuth(cfg))
+	cascades.GET("/policies", h.GetCascadePolicies)
+	cascades.POST("/policies", h.SetCascadePolicy)
+	cascades.DELETE("/policies/:id", h.DeleteCascadePolicy)
+
+	// Routing policy routes (JWT protected)
+	routing := e.Group("/api/routing", middleware.JWTAuth(cfg))
+	routing.GET("/policies", h.ListRoutingPolicies)
+	routing.POST("/policies", h.CreateRoutingPolicy)
+	routing.PUT("/policies/by-name/:name", h.ProvisionRoutingPolicy)
+	routing.PUT("/policies/:id", h.UpdateRoutingPolicy)
+	routing.DELETE("/policies/:id", h.DeleteRoutingPolicy)
+
+	// MCP server routes (JWT protected)
+	mcp := e.Group("/api/mcp", middleware.JWTAuth(cfg))
+	mcp.GET("/servers", h.ListMCPServers)
+	mcp.POST("/servers", h.CreateMCPServer)
+	mcp.PUT("/servers/:id", h.UpdateMCPServer)
+	mcp.DELETE("/servers/:id", h.DeleteMCPServer)
+	mcp.GET("/servers/:id/tools", h.ListMCPServerTools)
+
+	// Content filter routes (JWT protected)
+	contentFilters := e.Group("/api/content-filters", middleware.JWTAuth(cfg))
+	contentFilters.GET("/rules", h.ListContentFilterRules)
+	contentFilters.POST("/rules", h.CreateContentFilterRule)
+	contentFilters.PUT("/rules/:id", h.UpdateContentFilterRule)
+	contentFilters.DELETE("/rules/:id", h.DeleteContentFilterRule)
+
+	// Stripe usage-based billing routes (JWT protected)
+	stripeBilling := e.Group("/api/billing/stripe", middleware.JWTAuth(cfg))
+	stripeBilling.GET("", h.ListStripeBillings)
+	stripeBilling.POST("", h.CreateStripeBilling)
+	stripeBilling.GET("/:id", h.GetStripeBilling)
+	stripeBilling.PUT("/:id", h.UpdateStripeBilling)
+	stripeBilling.DELETE("/:id", h.DeleteStripeBilling)
+	stripeBilling.POST("/:id/sync", h.SyncStripeBilling)
+	stripeBilling.GET("/:id/reconciliation", h.GetStripeReconciliation)
+
+	// Provider usage sync routes (JWT protected)
+	providerUsage := e.Group("/api/billing/provider-usage", middleware.JWTAuth(cfg))
+	providerUsage.GET("", h.ListProviderUsageSyncs)
+	providerUsage.POST("", h.CreateProviderUsageSync)
+	providerUsage.GET("/:id", h.GetProviderUsageSync)
+	providerUsage.PUT("/:id", h.UpdateProviderUsageSync)
+	providerUsage.DELETE("/:id", h.DeleteProviderUsageSync)
+	providerUsage.POST("/:id/sync", h.SyncProviderUsage)
+	providerUsage.GET("/:id/reconciliation", h.GetProviderUsageReconciliation)
+
+	// Request log routes (JWT protected)
+	requestLogs := e.Group("/api/logs", middleware.JWTAuth(cfg))
+	requestLogs.GET("", h.ListRequestLogs)
+	requestLogs.GET("/:id", h.GetRequestLog)
+	requestLogs.POST("/:id/replay", h.ReplayRequestLog)
+
+	// Credit routes (JWT protected)
+	e.GET("/api/credits", h.GetCredits, middleware.JWTAuth(cfg))
+
+	// Live usage feed (JWT protected, server-sent events)
+	e.GET("/api/usage/live", h.LiveUsage, middleware.JWTAuth(cfg))
+
+	// OpenAPI document of the management API (public)
+	e.GET("/api/openapi.json", h.OpenAPISpec)
+
+	// Admin routes (JWT protected, administrators only)
+	admin := e.Group("/api/admin", middleware.JWTAuth(cfg), middleware.RequireAdmin())
+	admin.GET("/users/:id/credits", h.GetUserCredits)
+	admin.POST("/users/:id/credits", h.GrantCredits)
+	admin.GET("/model-prices", h.ListModelPrices)
+	admin.PUT("/model-prices", h.SetModelPrice)
+	admin.DELETE("/model-prices/:id", h.DeleteModelPrice)
+	admin.GET("/audit-log", h.ListAuditLog)
+	admin.GET("/audit-log/export", h.ExportAuditLog)
+	admin.GET("/audit-log/verify", h.VerifyAuditLog)
+	admin.GET("/maintenance", h.ListMaintenance)
+	admin.PUT("/maintenance", h.SetMaintenance)
+	admin.DELETE("/maintenance", h.ClearMaintenance)
+	admin.PUT("/maintenance/providers/:id", h.PauseProviderConfig)
+	admin.DELETE("/maintenance/providers/:id", h.ResumeProviderConfig)
+
+	// Provider statistics routes (JWT protected)
+	stats := e.Group("/api/stats", middleware.JWTAuth(cfg))
+	stats.GET("/providers", h.GetProviderStats)
+
+	// Playground routes (JWT protected)
+	playground := e.Group("/api/playground", middleware.JWTAuth(cfg))
+	playground.POST("/chat", h.PlaygroundChat)
+
+	// Account privacy routes (JWT protected)
+	account := e.Group("/api/account", middleware.JWTAuth(cfg))
+	account.GET("/export", h.ExportAccount)
+	account.POST("/delete", h.DeleteAccount)
+	account.DELETE("/delete", h.CancelAccountDeletion)
+
+	// Notification routes
+	notifications := e.Group("/api/notifications")
+	notifications.GET("/preferences", h.GetNotificationPreferences, middleware.JWTAuth(cfg))
+	notifications.PUT("/preferences", h.UpdateNotificationPreferences, middleware.JWTAuth(cfg))
+	notifications.GET("/digest/preview", h.PreviewUsageDigest, middleware.JWTAuth(cfg))
+	notifications.GET("/unsubscribe", h.UnsubscribeNotifications)
+
+	// Conversation routes (API Key or JWT auth)
+	conversations := e.Group("/api/conversations", middleware.GatewayAuth(db, cfg, h.CredentialCache(), h.MetricsCollector(), h.CanaryService()))
+	conversations.GET("", h.ListConversations)
+	conversations.POST("", h.CreateConversation)
+	conversations.GET("/:id", h.GetConversation)
+	conversations.DELETE("/:id", h.DeleteConversation)
 
 	// Page routes (public)
 	e.GET("/login", h.LoginPage)
@@ -135,6 +352,26 @@ func main() {
 	e.GET("/dashboard/keys", h.KeysPage)
 	e.GET("/logout", h.LogoutPage)
 
+	// Event bus consumers
+	h.EventBus().Handle(h.MetricsCollector().ObserveEvent)
+	h.EventBus().Handle(events.Log, events.KeyLimitReached, events.ProviderUnhealthy, events.ConfigChanged, events.KeyChanged, events.CanaryUsed)
+	h.EventBus().Handle(h.CanaryService().Handle, events.CanaryUsed)
+
+	// Background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go services.NewDigestService(db, cfg, services.NewMailer(cfg)).Run(jobsCtx)
+	go services.NewSLOService(db, cfg, h.MetricsCollector()).Run(jobsCtx)
+	go archiveService.Run(jobsCtx)
+	go h.RequestLogService().Run(jobsCtx)
+	go h.HealthService().Run(jobsCtx)
+	go h.StreamTracker().Run(jobsCtx)
+	go services.NewAccountService(db, cfg, h.EventBus(), archiveService).Run(jobsCtx)
+	go services.NewStripeBillingService(db, cfg).Run(jobsCtx)
+	go services.NewProviderUsageSyncService(db, cfg).Run(jobsCtx)
+	go services.NewUsageResetService(db, cfg).Run(jobsCtx)
+	go services.NewProviderScheduleService(db, cfg, h.EventBus(), h.CredentialCache()).Run(jobsCtx)
+
 	// Start server
 	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
 	go func() {
@@ -148,6 +385,7 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 	<-quit
+	stopJobs()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -3,6 +3,7 @@ module ai_gateway
 go 1.21
 
 require (
+	github.com/andybalholm/brotli v1.1.0
 	github.com/glebarez/sqlite v1.10.0
 	github.com/golang-jwt/jwt/v5 v5.2.1
 	github.com/joho/godotenv v1.5.1
@@ -1,12 +1,10 @@
 package adapters
 
 import (
-"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 )
@@ -24,7 +22,8 @@ func NewAnthropicAdapter(apiKey, baseURL string) *AnthropicAdapter {
 		apiKey:  apiKey,
 		baseURL: baseURL,
 		client: &http.Client{
-			Timeout: defaultTimeout,
+			Timeout:   defaultTimeout,
+			Transport: upstreamTransport,
 		},
 	}
 }
@@ -46,6 +45,7 @@ func (a *AnthropicAdapter) Messages(ctx context.Context, request interface{}) (m
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("x-api-key", a.apiKey)
 	req.Header.Set("anthropic-version", "2023-06-01")
+	acceptCompressedResponse(req)
 
 	resp, err := a.client.Do(req)
 	if err != nil {
@@ -53,6 +53,10 @@ func (a *AnthropicAdapter) Messages(ctx context.Context, request interface{}) (m
 	}
 	defer resp.Body.Close()
 
+	if err := decodeResponseBody(resp); err != nil {
+		return nil, resp.StatusCode, err
+	}
+
 	var result map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, resp.StatusCode, err
@@ -80,28 +84,12 @@ func (a *AnthropicAdapter) MessagesStream(ctx context.Context, request interface
 	req.Header.Set("anthropic-version", "2023-06-01")
 	req.Header.Set("Accept", "text/event-stream")
 
-resp, err := a.client.Do(req)
+	resp, err := a.client.Do(req)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	log.Printf("[Anthropic Stream] Request sent, Response Status: %d", resp.StatusCode)
 
-	// Read and log the response body
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, resp.StatusCode, err
-	}
-	log.Printf("[Anthropic Stream] Response Body: %s", string(bodyBytes))
-
-	// Create reader from the body bytes for streaming
-	return &StreamReader{
-		reader: bufio.NewReader(bytes.NewReader(bodyBytes)),
-		body:   io.NopCloser(bytes.NewReader(bodyBytes)),
-	}, resp.StatusCode, nil
-
-	return &StreamReader{
-		reader: bufio.NewReader(resp.Body),
-		body:   resp.Body,
-	}, resp.StatusCode, nil
+	return newStreamReader(ctx, resp), resp.StatusCode, nil
 }
@@ -0,0 +1,57 @@
+package adapters
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// acceptCompressedResponse asks the upstream for a compressed body. Setting the
+// header explicitly disables net/http's transparent gzip handling, so callers
+// must pass the response through decodeResponseBody.
+func acceptCompressedResponse(req *http.Request) {
+	req.Header.Set("Accept-Encoding", "br, gzip")
+}
+
+// decodeResponseBody replaces resp.Body with a decoder matching its Content-Encoding
+func decodeResponseBody(resp *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return nil
+	case "gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("invalid gzip response body: %w", err)
+		}
+		resp.Body = &decodedBody{Reader: gz, closers: []io.Closer{gz, resp.Body}}
+	case "br":
+		resp.Body = &decodedBody{Reader: brotli.NewReader(resp.Body), closers: []io.Closer{resp.Body}}
+	default:
+		return fmt.Errorf("unsupported response content encoding: %s", encoding)
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return nil
+}
+
+// decodedBody closes both the decoder and the underlying response body
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	var firstErr error
+	for _, c := range b.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
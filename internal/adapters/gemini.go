@@ -1,7 +1,6 @@
 package adapters
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -22,7 +21,8 @@ func NewGeminiAdapter(apiKey, baseURL string) *GeminiAdapter {
 		apiKey:  apiKey,
 		baseURL: baseURL,
 		client: &http.Client{
-			Timeout: defaultTimeout,
+			Timeout:   defaultTimeout,
+			Transport: upstreamTransport,
 		},
 	}
 }
@@ -42,6 +42,7 @@ func (a *GeminiAdapter) GenerateContent(ctx context.Context, model string, reque
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	acceptCompressedResponse(req)
 
 	resp, err := a.client.Do(req)
 	if err != nil {
@@ -49,6 +50,10 @@ func (a *GeminiAdapter) GenerateContent(ctx context.Context, model string, reque
 	}
 	defer resp.Body.Close()
 
+	if err := decodeResponseBody(resp); err != nil {
+		return nil, resp.StatusCode, err
+	}
+
 	var result map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, resp.StatusCode, err
@@ -79,8 +84,5 @@ func (a *GeminiAdapter) GenerateContentStream(ctx context.Context, model string,
 		return nil, 0, err
 	}
 
-	return &StreamReader{
-		reader: bufio.NewReader(resp.Body),
-		body:   resp.Body,
-	}, resp.StatusCode, nil
+	return newStreamReader(ctx, resp), resp.StatusCode, nil
 }
@@ -0,0 +1,48 @@
+package adapters
+
+import (
+	"context"
+	"net/http"
+)
+
+type responseHeaderSinkKey struct{}
+
+type requestIDKey struct{}
+
+// HeaderClientRequestID is the header OpenAI records alongside its own request ID
+const HeaderClientRequestID = "X-Client-Request-Id"
+
+// WithResponseHeaderSink returns a context whose upstream requests report the
+// headers of their responses to sink
+func WithResponseHeaderSink(ctx context.Context, sink func(http.Header)) context.Context {
+	return context.WithValue(ctx, responseHeaderSinkKey{}, sink)
+}
+
+// WithRequestID returns a context whose upstream requests carry the gateway
+// request ID, so upstream logs and charges can be traced back to the request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// upstreamTransport is the transport of all adapters; it tags requests with the
+// request ID and reports response headers to the sink of the request's context
+var upstreamTransport http.RoundTripper = headerReportingTransport{base: http.DefaultTransport}
+
+type headerReportingTransport struct {
+	base http.RoundTripper
+}
+
+func (t headerReportingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if id, ok := req.Context().Value(requestIDKey{}).(string); ok && id != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("X-Request-ID", id)
+		req.Header.Set(HeaderClientRequestID, id)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		if sink, ok := req.Context().Value(responseHeaderSinkKey{}).(func(http.Header)); ok {
+			sink(resp.Header)
+		}
+	}
+	return resp, err
+}
@@ -9,7 +9,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -28,7 +27,8 @@ func NewOpenAIAdapter(apiKey, baseURL string) *OpenAIAdapter {
 		apiKey:  apiKey,
 		baseURL: baseURL,
 		client: &http.Client{
-			Timeout: defaultTimeout,
+			Timeout:   defaultTimeout,
+			Transport: upstreamTransport,
 		},
 	}
 }
@@ -39,7 +39,8 @@ func NewOpenAIAdapterWithConfig(apiKey, baseURL string, timeout time.Duration) *
 		apiKey:  apiKey,
 		baseURL: baseURL,
 		client: &http.Client{
-			Timeout: timeout,
+			Timeout:   timeout,
+			Transport: upstreamTransport,
 		},
 	}
 }
@@ -69,6 +70,7 @@ func (a *OpenAIAdapter) ChatCompletions(ctx context.Context, request interface{}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.apiKey))
+	acceptCompressedResponse(req)
 
 	log.Printf("[OpenAIAdapter] ChatCompletions HeaderApiKey: %s", a.apiKey)
 	resp, err := a.client.Do(req)
@@ -79,6 +81,10 @@ func (a *OpenAIAdapter) ChatCompletions(ctx context.Context, request interface{}
 	log.Printf("[OpenAIAdapter] ChatCompletions response: statusCode=%d, elapsed=%s", resp.StatusCode, time.Since(start))
 	defer resp.Body.Close()
 
+	if err := decodeResponseBody(resp); err != nil {
+		return nil, resp.StatusCode, err
+	}
+
 	var result map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		log.Printf("[OpenAIAdapter] ChatCompletions decode error: %v", err)
@@ -131,40 +137,14 @@ func (a *OpenAIAdapter) ChatCompletionsStream(ctx context.Context, request inter
 	}
 	log.Printf("[OpenAIAdapter] ChatCompletionsStream opened: statusCode=%d, elapsed=%s", resp.StatusCode, time.Since(start))
 
-	streamReader := &StreamReader{
-		reader: bufio.NewReader(resp.Body),
-		body:   resp.Body,
-	}
-
-	// Start logging stream response in background
-	streamStart := time.Now()
-	go func() {
-		defer func() {
-			log.Printf("[OpenAIAdapter] ChatCompletionsStream completed after %s", time.Since(streamStart))
-		}()
-
-		reader := bufio.NewReader(resp.Body)
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("[OpenAIAdapter] ChatCompletionsStream read error: %v", err)
-				}
-				break
-			}
-			if strings.TrimSpace(line) != "" {
-				log.Printf("[OpenAIAdapter] ChatCompletionsStream response: %s", strings.TrimSpace(line))
-			}
-		}
-	}()
-
-	return streamReader, resp.StatusCode, nil
+	return newStreamReader(ctx, resp), resp.StatusCode, nil
 }
 
 // StreamReader wraps a streaming response
 type StreamReader struct {
-	reader *bufio.Reader
-	body   io.ReadCloser
+	reader  *bufio.Reader
+	body    io.ReadCloser
+	release func() // unregisters the stream from its tracker, if any
 }
 
 // ReadLine reads a line from the stream
@@ -179,7 +159,11 @@ func (s *StreamReader) Read(p []byte) (n int, err error) {
 
 // Close closes the stream
 func (s *StreamReader) Close() error {
-	return s.body.Close()
+	err := s.body.Close()
+	if s.release != nil {
+		s.release()
+	}
+	return err
 }
 
 // GetReader returns the underlying reader
@@ -203,6 +187,7 @@ func (a *OpenAIAdapter) Responses(ctx context.Context, request interface{}) (map
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.apiKey))
+	acceptCompressedResponse(req)
 
 	resp, err := a.client.Do(req)
 	if err != nil {
@@ -210,6 +195,10 @@ func (a *OpenAIAdapter) Responses(ctx context.Context, request interface{}) (map
 	}
 	defer resp.Body.Close()
 
+	if err := decodeResponseBody(resp); err != nil {
+		return nil, resp.StatusCode, err
+	}
+
 	var result map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, resp.StatusCode, err
@@ -261,32 +250,48 @@ func (a *OpenAIAdapter) ResponsesStream(ctx context.Context, request interface{}
 	}
 	log.Printf("[OpenAIAdapter] ResponsesStream opened: statusCode=%d, elapsed=%s", resp.StatusCode, time.Since(start))
 
-	streamReader := &StreamReader{
-		reader: bufio.NewReader(resp.Body),
-		body:   resp.Body,
+	return newStreamReader(ctx, resp), resp.StatusCode, nil
+}
+
+// ForwardRequest describes a raw request relayed to the upstream without conversion
+type ForwardRequest struct {
+	Method        string
+	Path          string // path relative to the base URL, e.g. "/files"
+	RawQuery      string
+	Body          io.Reader
+	ContentLength int64
+	Header        http.Header // extra headers such as Content-Type or OpenAI-Beta
+}
+
+// Forward relays a raw request to the upstream and returns the unread response.
+// The caller must close the response body.
+func (a *OpenAIAdapter) Forward(ctx context.Context, fr *ForwardRequest) (*http.Response, error) {
+	url := a.baseURL + fr.Path
+	if fr.RawQuery != "" {
+		url += "?" + fr.RawQuery
+	}
+
+	req, err := http.NewRequestWithContext(ctx, fr.Method, url, fr.Body)
+	if err != nil {
+		return nil, err
+	}
+	if fr.Body != nil && fr.ContentLength > 0 {
+		req.ContentLength = fr.ContentLength
 	}
 
-	// Start logging stream response in background
-	streamStart := time.Now()
-	go func() {
-		defer func() {
-			log.Printf("[OpenAIAdapter] ResponsesStream completed after %s", time.Since(streamStart))
-		}()
-
-		reader := bufio.NewReader(resp.Body)
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("[OpenAIAdapter] ResponsesStream read error: %v", err)
-				}
-				break
-			}
-			if strings.TrimSpace(line) != "" {
-				log.Printf("[OpenAIAdapter] ResponsesStream response: %s", strings.TrimSpace(line))
-			}
+	for name, values := range fr.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
 		}
-	}()
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.apiKey))
 
-	return streamReader, resp.StatusCode, nil
+	start := time.Now()
+	resp, err := a.client.Do(req)
+	if err != nil {
+		log.Printf("[OpenAIAdapter] Forward %s %s error after %s: %v", fr.Method, fr.Path, time.Since(start), err)
+		return nil, err
+	}
+	log.Printf("[OpenAIAdapter] Forward %s %s: statusCode=%d, elapsed=%s", fr.Method, fr.Path, resp.StatusCode, time.Since(start))
+	return resp, nil
 }
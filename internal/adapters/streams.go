@@ -0,0 +1,29 @@
+package adapters
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+)
+
+type streamTrackerKey struct{}
+
+// WithStreamTracker returns a context whose upstream streams are registered with
+// track when opened; the func it returns is called once the stream is closed
+func WithStreamTracker(ctx context.Context, track func(io.Closer) (release func())) context.Context {
+	return context.WithValue(ctx, streamTrackerKey{}, track)
+}
+
+// newStreamReader wraps the body of a streaming response and registers it with
+// the stream tracker of ctx
+func newStreamReader(ctx context.Context, resp *http.Response) *StreamReader {
+	s := &StreamReader{
+		reader: bufio.NewReader(resp.Body),
+		body:   resp.Body,
+	}
+	if track, ok := ctx.Value(streamTrackerKey{}).(func(io.Closer) func()); ok {
+		s.release = track(resp.Body)
+	}
+	return s
+}
@@ -4,8 +4,13 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"log"
+	"net"
 	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // USAGE_RESET_TIMEZONE must resolve in images without zoneinfo
 
 	"github.com/kelseyhightower/envconfig"
 )
@@ -33,6 +38,137 @@ type Config struct {
 	// HTTP timeout configuration
 	HTTPTimeout   int `envconfig:"HTTP_TIMEOUT_SECONDS" default:"600"`    // 10 minutes
 	StreamTimeout int `envconfig:"STREAM_TIMEOUT_SECONDS" default:"1800"` // 30 minutes for streaming
+
+	// Streamed responses are flushed every STREAM_FLUSH_INTERVAL_MS or once STREAM_FLUSH_BYTES
+	// are buffered, rather than after every event (an interval of 0 flushes every event)
+	StreamFlushInterval int `envconfig:"STREAM_FLUSH_INTERVAL_MS" default:"20"`
+	StreamFlushBytes    int `envconfig:"STREAM_FLUSH_BYTES" default:"4096"`
+
+	// Upstream streams open longer than this many seconds are force-closed (0 disables)
+	StreamMaxLifetime int `envconfig:"STREAM_MAX_LIFETIME_SECONDS" default:"3600"`
+
+	// Streamed responses are kept this many seconds after they end so that clients can
+	// resume them with Last-Event-ID (0 disables), up to STREAM_REPLAY_MAX_BYTES each
+	StreamReplayTTL      int `envconfig:"STREAM_REPLAY_TTL_SECONDS" default:"0"`
+	StreamReplayMaxBytes int `envconfig:"STREAM_REPLAY_MAX_BYTES" default:"4194304"`
+
+	// API key records and decrypted provider credentials are cached this many seconds
+	// (0 disables); changes made through the API take effect immediately
+	CredentialCacheTTL int `envconfig:"CREDENTIAL_CACHE_TTL_SECONDS" default:"30"`
+
+	// Upstream response headers passed through to clients on gateway routes (names ending in * match by prefix)
+	UpstreamHeaderAllowlist []string `envconfig:"UPSTREAM_HEADER_ALLOWLIST" default:"anthropic-ratelimit-*,x-ratelimit-*,openai-processing-ms,retry-after,retry-after-ms"`
+
+	// Maximum number of request body bytes written to the log, after redaction (0 disables)
+	LogBodyMaxBytes int `envconfig:"LOG_BODY_MAX_BYTES" default:"4096"`
+
+	// Public URL of the gateway, used in links sent by email
+	PublicBaseURL string `envconfig:"PUBLIC_BASE_URL" default:"http://localhost:8080"`
+
+	// SMTP settings for notification emails (emails are only logged when SMTP_HOST is empty)
+	SMTPHost     string `envconfig:"SMTP_HOST"`
+	SMTPPort     int    `envconfig:"SMTP_PORT" default:"587"`
+	SMTPUsername string `envconfig:"SMTP_USERNAME"`
+	SMTPPassword string `envconfig:"SMTP_PASSWORD"`
+	SMTPFrom     string `envconfig:"SMTP_FROM" default:"ai-gateway@localhost"`
+
+	// Idempotency-Key replay window in seconds
+	IdempotencyTTL int `envconfig:"IDEMPOTENCY_TTL_SECONDS" default:"86400"` // 24 hours
+	// Bytes of a request carrying an Idempotency-Key read to fingerprint it; larger requests are refused
+	IdempotencyMaxBodyBytes int `envconfig:"IDEMPOTENCY_MAX_BODY_BYTES" default:"33554432"` // 32 MB
+
+	// Seconds between health checks of upstreams used by latency-routed API keys
+	HealthCheckInterval int `envconfig:"HEALTH_CHECK_INTERVAL_SECONDS" default:"30"`
+
+	// Days between an account deletion request and the hard delete of its data
+	AccountDeletionGraceDays int `envconfig:"ACCOUNT_DELETION_GRACE_DAYS" default:"30"`
+
+	// Bearer token required to scrape /metrics (not served when empty)
+	MetricsToken string `envconfig:"METRICS_TOKEN"`
+
+	// Compliance archive of request/response bodies in S3-compatible object storage
+	// (disabled when ARCHIVE_BUCKET is empty; use https://storage.googleapis.com with HMAC keys for GCS)
+	ArchiveBucket          string `envconfig:"ARCHIVE_BUCKET"`
+	ArchiveEndpoint        string `envconfig:"ARCHIVE_ENDPOINT" default:"https://s3.amazonaws.com"`
+	ArchiveRegion          string `envconfig:"ARCHIVE_REGION" default:"us-east-1"`
+	ArchiveAccessKeyID     string `envconfig:"ARCHIVE_ACCESS_KEY_ID"`
+	ArchiveSecretAccessKey string `envconfig:"ARCHIVE_SECRET_ACCESS_KEY"`
+	ArchivePrefix          string `envconfig:"ARCHIVE_PREFIX" default:"ai-gateway/"`
+	ArchiveRetentionDays   int    `envconfig:"ARCHIVE_RETENTION_DAYS" default:"365"`
+	ArchiveMaxBodyBytes    int    `envconfig:"ARCHIVE_MAX_BODY_BYTES" default:"10485760"` // 10 MB per body
+
+	// SLO burn rate above which alerts fire (14.4 spends 2% of a 30-day budget in an hour)
+	SLOBurnAlertThreshold float64 `envconfig:"SLO_BURN_ALERT_THRESHOLD" default:"14.4"`
+
+	// Rounds of gateway-executed tool calls (MCP, web search) allowed per chat completion
+	ServerToolMaxRounds int `envconfig:"SERVER_TOOL_MAX_ROUNDS" default:"8"`
+
+	// Search API behind the gateway's web_search tool (brave, tavily or searxng; disabled when empty)
+	WebSearchProvider   string `envconfig:"WEB_SEARCH_PROVIDER"`
+	WebSearchURL        string `envconfig:"WEB_SEARCH_URL"` // overrides the provider's endpoint; required for searxng
+	WebSearchAPIKey     string `envconfig:"WEB_SEARCH_API_KEY"`
+	WebSearchMaxResults int    `envconfig:"WEB_SEARCH_MAX_RESULTS" default:"5"`
+
+	// OpenAI-compatible moderations endpoint used by content filter rules of type moderation
+	ModerationURL    string `envconfig:"MODERATION_URL"` // e.g. https://api.openai.com/v1/moderations; disabled when empty
+	ModerationAPIKey string `envconfig:"MODERATION_API_KEY"`
+	ModerationModel  string `envconfig:"MODERATION_MODEL" default:"omni-moderation-latest"`
+
+	// OpenAI-compatible chat completions endpoint of the model scoring user content for
+	// prompt injection, alongside the gateway's heuristics; heuristics only when empty
+	InjectionClassifierURL    string `envconfig:"INJECTION_CLASSIFIER_URL"`
+	InjectionClassifierAPIKey string `envconfig:"INJECTION_CLASSIFIER_API_KEY"`
+	InjectionClassifierModel  string `envconfig:"INJECTION_CLASSIFIER_MODEL" default:"gpt-4o-mini"`
+
+	// Stripe API used to export metered usage, and seconds between exports
+	StripeAPIURL       string `envconfig:"STRIPE_API_URL" default:"https://api.stripe.com"`
+	StripeSyncInterval int    `envconfig:"STRIPE_SYNC_INTERVAL_SECONDS" default:"3600"`
+
+	// Seconds between pulls of provider usage APIs, and the trailing days each pull
+	// refreshes, as providers finalize usage with a delay
+	ProviderUsageSyncInterval int `envconfig:"PROVIDER_USAGE_SYNC_INTERVAL_SECONDS" default:"3600"`
+	ProviderUsageSyncDays     int `envconfig:"PROVIDER_USAGE_SYNC_DAYS" default:"3"`
+
+	// Inline base64 images: larger ones are rejected, as are images outside the upstream's
+	// limits (size, dimensions, format) unless transcoding downscales and re-encodes them
+	ImageMaxBytes  int  `envconfig:"IMAGE_MAX_BYTES" default:"20971520"` // decoded bytes per image, 0 for no cap
+	ImageTranscode bool `envconfig:"IMAGE_TRANSCODE" default:"false"`
+
+	// Download image URLs and send them inline to upstreams that cannot fetch them (Anthropic,
+	// Gemini). Without an allowlist any public host is fetched; private addresses never are.
+	// Downloads are cached by URL for the TTL, within the cache's byte budget.
+	ImageFetchURLs         bool     `envconfig:"IMAGE_FETCH_URLS" default:"false"`
+	ImageFetchAllowedHosts []string `envconfig:"IMAGE_FETCH_ALLOWED_HOSTS"`                   // "example.com", "*.example.com"
+	ImageFetchMaxBytes     int      `envconfig:"IMAGE_FETCH_MAX_BYTES" default:"20971520"`    // per request
+	ImageFetchTimeout      int      `envconfig:"IMAGE_FETCH_TIMEOUT_MS" default:"10000"`      // per image
+	ImageFetchCacheTTL     int      `envconfig:"IMAGE_FETCH_CACHE_TTL_SECONDS" default:"600"` // 0 disables the cache
+	ImageFetchCacheBytes   int      `envconfig:"IMAGE_FETCH_CACHE_BYTES" default:"67108864"`
+
+	// Drop prose and code fences around the JSON of response_format json_object/json_schema
+	// completions served by Anthropic, which has no native JSON mode
+	JSONModeStripProse bool `envconfig:"JSON_MODE_STRIP_PROSE" default:"true"`
+
+	// IANA timezone whose midnights and month starts reset daily and monthly usage counters
+	UsageResetTimezone string `envconfig:"USAGE_RESET_TIMEZONE" default:"UTC"`
+	usageResetLocation *time.Location
+
+	// Proxies (CIDRs or IPs) whose X-Forwarded-For and X-Real-IP headers name the client;
+	// with none, client IPs are the connection's remote address
+	TrustedProxies   []string `envconfig:"TRUSTED_PROXIES"`
+	trustedProxyNets []*net.IPNet
+
+	// Seconds a signed request's timestamp may differ from the server clock; signatures
+	// are remembered for as long to reject replays
+	SignatureMaxSkew int `envconfig:"SIGNATURE_MAX_SKEW_SECONDS" default:"300"`
+	// Bytes of a signed request's body read to check its signature; larger requests are refused
+	SignatureMaxBodyBytes int `envconfig:"SIGNATURE_MAX_BODY_BYTES" default:"33554432"` // 32 MB
+	// Bytes a gzip or brotli encoded request body may decode to; larger requests are refused
+	DecompressMaxBodyBytes int `envconfig:"DECOMPRESS_MAX_BODY_BYTES" default:"33554432"` // 32 MB
+
+	// Days request log records of gateway requests whose body may be logged are kept
+	// (0 disables the request log), and the bytes kept of each body
+	RequestLogRetentionDays int `envconfig:"REQUEST_LOG_RETENTION_DAYS" default:"7"`
+	RequestLogMaxBodyBytes  int `envconfig:"REQUEST_LOG_MAX_BODY_BYTES" default:"65536"`
 }
 
 // Load loads the configuration from environment variables
@@ -53,6 +189,20 @@ func Load() (*Config, error) {
 		cfg.JWTSecret = secret
 	}
 
+	loc, err := time.LoadLocation(cfg.UsageResetTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid USAGE_RESET_TIMEZONE %q: %w", cfg.UsageResetTimezone, err)
+	}
+	cfg.usageResetLocation = loc
+
+	for _, proxy := range cfg.TrustedProxies {
+		ipNet, err := parseProxy(strings.TrimSpace(proxy))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %w", proxy, err)
+		}
+		cfg.trustedProxyNets = append(cfg.trustedProxyNets, ipNet)
+	}
+
 	// ENCRYPTION_KEY is required and must be stable across restarts
 	if cfg.EncryptionKey == "" {
 		return nil, errors.New("ENCRYPTION_KEY environment variable is required - generate with: openssl rand -base64 32")
@@ -70,6 +220,35 @@ func Load() (*Config, error) {
 	return &cfg, nil
 }
 
+// parseProxy parses a trusted proxy given as a CIDR or a single IP
+func parseProxy(proxy string) (*net.IPNet, error) {
+	if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+		return ipNet, nil
+	}
+	ip := net.ParseIP(proxy)
+	if ip == nil {
+		return nil, errors.New("not a CIDR or IP address")
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// TrustedProxyNets returns the networks of the trusted proxies
+func (c *Config) TrustedProxyNets() []*net.IPNet {
+	return c.trustedProxyNets
+}
+
+// UsageResetLocation returns the timezone of usage counter resets, UTC by default
+func (c *Config) UsageResetLocation() *time.Location {
+	if c.usageResetLocation == nil {
+		return time.UTC
+	}
+	return c.usageResetLocation
+}
+
 // generateRandomString generates a random string of the specified length
 func generateRandomString(length int) (string, error) {
 	bytes := make([]byte, length)
@@ -11,6 +11,7 @@ type normalizedAnthropicBlock struct {
 	Type      string
 	Text      string
 	Source    map[string]interface{}
+	Title     string
 	ID        string
 	Name      string
 	Input     interface{}
@@ -117,6 +118,7 @@ func normalizeBlockFromContentBlock(block models.ContentBlock) normalizedAnthrop
 			"type":       block.Source.Type,
 			"media_type": block.Source.MediaType,
 			"data":       block.Source.Data,
+			"url":        block.Source.URL,
 		}
 	}
 
@@ -130,6 +132,7 @@ func normalizeBlockFromContentBlock(block models.ContentBlock) normalizedAnthrop
 		Type:      block.Type,
 		Text:      block.Text,
 		Source:    source,
+		Title:     block.Title,
 		ID:        block.ID,
 		Name:      block.Name,
 		Input:     block.Input,
@@ -149,6 +152,7 @@ func normalizeBlockFromMap(block map[string]interface{}) normalizedAnthropicBloc
 		Type:      getString(block, "type"),
 		Text:      getString(block, "text"),
 		Source:    mapValue(block, "source"),
+		Title:     getString(block, "title"),
 		ID:        getString(block, "id"),
 		Name:      getString(block, "name"),
 		Input:     block["input"],
@@ -189,12 +193,14 @@ func stringifyContent(value interface{}) string {
 	}
 }
 
-func extractOpenAIContentParts(content interface{}) (string, []models.ContentBlock) {
+// extractOpenAIContentParts splits OpenAI message content into its text and
+// Anthropic image and document blocks
+func extractOpenAIContentParts(content interface{}) (string, []models.ContentBlock, error) {
 	switch v := content.(type) {
 	case nil:
-		return "", nil
+		return "", nil, nil
 	case string:
-		return v, nil
+		return v, nil, nil
 	case []models.ContentPart:
 		var text string
 		var blocks []models.ContentBlock
@@ -212,9 +218,19 @@ func extractOpenAIContentParts(content interface{}) (string, []models.ContentBlo
 						},
 					})
 				}
+			case "input_audio":
+				return "", nil, unsupportedContentError("anthropic", contentAudio)
+			case "file":
+				if part.File != nil {
+					block, err := openAIFileToAnthropicBlock(*part.File)
+					if err != nil {
+						return "", nil, err
+					}
+					blocks = append(blocks, block)
+				}
 			}
 		}
-		return text, blocks
+		return text, blocks, nil
 	case []interface{}:
 		var text string
 		var blocks []models.ContentBlock
@@ -239,9 +255,17 @@ func extractOpenAIContentParts(content interface{}) (string, []models.ContentBlo
 						})
 					}
 				}
+			case "input_audio":
+				return "", nil, unsupportedContentError("anthropic", contentAudio)
+			case "file":
+				block, err := openAIFileToAnthropicBlock(openAIFileFromMap(partMap))
+				if err != nil {
+					return "", nil, err
+				}
+				blocks = append(blocks, block)
 			}
 		}
-		return text, blocks
+		return text, blocks, nil
 	case []map[string]interface{}:
 		var text string
 		var blocks []models.ContentBlock
@@ -262,10 +286,18 @@ func extractOpenAIContentParts(content interface{}) (string, []models.ContentBlo
 						})
 					}
 				}
+			case "input_audio":
+				return "", nil, unsupportedContentError("anthropic", contentAudio)
+			case "file":
+				block, err := openAIFileToAnthropicBlock(openAIFileFromMap(partMap))
+				if err != nil {
+					return "", nil, err
+				}
+				blocks = append(blocks, block)
 			}
 		}
-		return text, blocks
+		return text, blocks, nil
 	default:
-		return "", nil
+		return "", nil, nil
 	}
 }
@@ -108,6 +108,20 @@ func AnthropicToGeminiRequest(req *models.MessagesRequest) (*models.GenerateCont
 								},
 							})
 						}
+					case "document":
+						part, err := anthropicDocumentToGeminiPart(mapValue(blockMap, "source"))
+						if err != nil {
+							return nil, err
+						}
+						geminiContent.Parts = append(geminiContent.Parts, part)
+					default:
+						if isAnthropicServerToolBlock(blockType) {
+							text, err := anthropicServerToolText(normalizeBlockFromMap(blockMap), "gemini")
+							if err != nil {
+								return nil, err
+							}
+							geminiContent.Parts = append(geminiContent.Parts, models.GeminiPart{Text: text})
+						}
 					}
 				}
 			}
@@ -126,7 +140,7 @@ func AnthropicToGeminiRequest(req *models.MessagesRequest) (*models.GenerateCont
 			declarations = append(declarations, models.FunctionDeclaration{
 				Name:        tool.Name,
 				Description: tool.Description,
-				Parameters:  tool.InputSchema,
+				Parameters:  TranslateToolSchema(tool.InputSchema, "anthropic", "gemini", false),
 			})
 		}
 		geminiReq.Tools = []models.GeminiTool{{
@@ -151,7 +165,7 @@ func GeminiToAnthropicResponse(resp map[string]interface{}, model string) (*mode
 		return anthropicResp, nil
 	}
 
-	candidate := candidates[0].(map[string]interface{})
+	candidate, _ := candidates[0].(map[string]interface{})
 	content, ok := candidate["content"].(map[string]interface{})
 	if !ok {
 		return anthropicResp, nil
@@ -164,7 +178,10 @@ func GeminiToAnthropicResponse(resp map[string]interface{}, model string) (*mode
 
 	var contentBlocks []models.ContentBlock
 	for _, part := range parts {
-		partMap := part.(map[string]interface{})
+		partMap, ok := part.(map[string]interface{})
+		if !ok {
+			continue
+		}
 		if text, ok := partMap["text"].(string); ok {
 			contentBlocks = append(contentBlocks, models.ContentBlock{
 				Type: "text",
@@ -217,28 +234,91 @@ func GeminiToAnthropicResponse(resp map[string]interface{}, model string) (*mode
 	return anthropicResp, nil
 }
 
-// GeminiStreamToAnthropicStream converts a Gemini stream event to Anthropic format
-func GeminiStreamToAnthropicStream(data map[string]interface{}, isFirst bool, model string) ([][]byte, error) {
-	var events [][]byte
+// GeminiToAnthropicStreamState stores Gemini to Anthropic stream conversion state.
+type GeminiToAnthropicStreamState struct {
+	model             string
+	contentBlockIndex int
+	blockStarted      bool
+	currentBlockType  string
+	toolCalls         int
+	startSent         bool
+	finished          bool
+}
 
-	candidates, ok := data["candidates"].([]interface{})
-	if !ok || len(candidates) == 0 {
-		return nil, nil
+// NewGeminiToAnthropicStreamState creates a new stream state for a model.
+func NewGeminiToAnthropicStreamState(model string) *GeminiToAnthropicStreamState {
+	return &GeminiToAnthropicStreamState{model: model}
+}
+
+// startBlock stops the open content block, if any, and starts a new one
+func (s *GeminiToAnthropicStreamState) startBlock(events [][]byte, block map[string]interface{}) [][]byte {
+	if s.blockStarted {
+		events = s.stopBlock(events)
+		s.contentBlockIndex++
 	}
+	startBytes, _ := json.Marshal(map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         s.contentBlockIndex,
+		"content_block": block,
+	})
+	s.blockStarted = true
+	s.currentBlockType = getString(block, "type")
+	return append(events, startBytes)
+}
 
-	candidate := candidates[0].(map[string]interface{})
-	content, ok := candidate["content"].(map[string]interface{})
-	if !ok {
-		return nil, nil
+// stopBlock stops the open content block
+func (s *GeminiToAnthropicStreamState) stopBlock(events [][]byte) [][]byte {
+	if !s.blockStarted {
+		return events
 	}
+	stopBytes, _ := json.Marshal(map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": s.contentBlockIndex,
+	})
+	s.blockStarted = false
+	s.currentBlockType = ""
+	return append(events, stopBytes)
+}
 
-	parts, ok := content["parts"].([]interface{})
-	if !ok || len(parts) == 0 {
+// finish stops the open block and ends the message
+func (s *GeminiToAnthropicStreamState) finish(events [][]byte, stopReason string, outputTokens int) [][]byte {
+	events = s.stopBlock(events)
+	messageDeltaBytes, _ := json.Marshal(map[string]interface{}{
+		"type": "message_delta",
+		"delta": map[string]interface{}{
+			"stop_reason": stopReason,
+		},
+		"usage": map[string]interface{}{
+			"output_tokens": outputTokens,
+		},
+	})
+	messageStopBytes, _ := json.Marshal(map[string]interface{}{"type": "message_stop"})
+	s.finished = true
+	return append(events, messageDeltaBytes, messageStopBytes)
+}
+
+// Close ends a stream that stopped without a finish reason, so that clients
+// still receive the closing events. It returns nothing once the stream has finished.
+func (s *GeminiToAnthropicStreamState) Close() [][]byte {
+	if !s.startSent || s.finished {
+		return nil
+	}
+	return s.finish(nil, "end_turn", 0)
+}
+
+// GeminiStreamToAnthropicStream converts a Gemini stream event to Anthropic format
+func GeminiStreamToAnthropicStream(data map[string]interface{}, state *GeminiToAnthropicStreamState) ([][]byte, error) {
+	if state == nil {
+		state = NewGeminiToAnthropicStreamState("")
+	}
+	if state.finished {
 		return nil, nil
 	}
 
-	if isFirst {
-		// Send message_start event
+	var events [][]byte
+	usage, _ := data["usageMetadata"].(map[string]interface{})
+
+	if !state.startSent {
 		startEvent := map[string]interface{}{
 			"type": "message_start",
 			"message": map[string]interface{}{
@@ -246,80 +326,78 @@ func GeminiStreamToAnthropicStream(data map[string]interface{}, isFirst bool, mo
 				"type":        "message",
 				"role":        "assistant",
 				"content":     []interface{}{},
-				"model":       model,
+				"model":       state.model,
 				"stop_reason": nil,
-				"usage":       map[string]interface{}{"input_tokens": 0, "output_tokens": 0},
+				"usage":       map[string]interface{}{"input_tokens": getInt(usage, "promptTokenCount"), "output_tokens": 0},
 			},
 		}
 		startBytes, _ := json.Marshal(startEvent)
 		events = append(events, startBytes)
-
-		// Send content_block_start
-		blockStartEvent := map[string]interface{}{
-			"type":  "content_block_start",
-			"index": 0,
-			"content_block": map[string]interface{}{
-				"type": "text",
-				"text": "",
-			},
-		}
-		blockStartBytes, _ := json.Marshal(blockStartEvent)
-		events = append(events, blockStartBytes)
+		state.startSent = true
 	}
 
-	part := parts[0].(map[string]interface{})
-	if text, ok := part["text"].(string); ok {
-		deltaEvent := map[string]interface{}{
-			"type":  "content_block_delta",
-			"index": 0,
-			"delta": map[string]interface{}{
-				"type": "text_delta",
-				"text": text,
-			},
-		}
-		deltaBytes, _ := json.Marshal(deltaEvent)
-		events = append(events, deltaBytes)
+	candidates, _ := data["candidates"].([]interface{})
+	if len(candidates) == 0 {
+		return events, nil
 	}
+	candidate, _ := candidates[0].(map[string]interface{})
+	parts, _ := mapValue(candidate, "content")["parts"].([]interface{})
 
-	// Handle finish
-	if fr, ok := candidate["finishReason"].(string); ok && fr != "" {
-		// content_block_stop
-		stopBlockEvent := map[string]interface{}{
-			"type":  "content_block_stop",
-			"index": 0,
+	for _, part := range parts {
+		partMap, ok := part.(map[string]interface{})
+		if !ok {
+			continue
 		}
-		stopBlockBytes, _ := json.Marshal(stopBlockEvent)
-		events = append(events, stopBlockBytes)
-
-		// message_delta
-		var stopReason string
-		switch fr {
-		case "STOP":
-			stopReason = "end_turn"
-		case "MAX_TOKENS":
-			stopReason = "max_tokens"
-		default:
-			stopReason = "end_turn"
+		if text, ok := partMap["text"].(string); ok && text != "" {
+			if state.currentBlockType != "text" {
+				events = state.startBlock(events, map[string]interface{}{"type": "text", "text": ""})
+			}
+			deltaBytes, _ := json.Marshal(map[string]interface{}{
+				"type":  "content_block_delta",
+				"index": state.contentBlockIndex,
+				"delta": map[string]interface{}{
+					"type": "text_delta",
+					"text": text,
+				},
+			})
+			events = append(events, deltaBytes)
 		}
-
-		messageDeltaEvent := map[string]interface{}{
-			"type": "message_delta",
-			"delta": map[string]interface{}{
-				"stop_reason": stopReason,
-			},
-			"usage": map[string]interface{}{
-				"output_tokens": 0,
-			},
+		if fc, ok := partMap["functionCall"].(map[string]interface{}); ok {
+			// Gemini streams each function call whole, so its block is complete at once
+			events = state.startBlock(events, map[string]interface{}{
+				"type":  "tool_use",
+				"id":    generateToolCallID(state.toolCalls),
+				"name":  getString(fc, "name"),
+				"input": map[string]interface{}{},
+			})
+			state.toolCalls++
+			args, _ := json.Marshal(fc["args"])
+			if fc["args"] == nil {
+				args = []byte("{}")
+			}
+			deltaBytes, _ := json.Marshal(map[string]interface{}{
+				"type":  "content_block_delta",
+				"index": state.contentBlockIndex,
+				"delta": map[string]interface{}{
+					"type":         "input_json_delta",
+					"partial_json": string(args),
+				},
+			})
+			events = append(events, deltaBytes)
+			events = state.stopBlock(events)
+			state.contentBlockIndex++
 		}
-		messageDeltaBytes, _ := json.Marshal(messageDeltaEvent)
-		events = append(events, messageDeltaBytes)
+	}
 
-		// message_stop
-		messageStopEvent := map[string]interface{}{
-			"type": "message_stop",
+	if fr := getString(candidate, "finishReason"); fr != "" {
+		stopReason := "end_turn"
+		switch {
+		case state.toolCalls > 0:
+			stopReason = "tool_use"
+		case fr == "MAX_TOKENS":
+			stopReason = "max_tokens"
 		}
-		messageStopBytes, _ := json.Marshal(messageStopEvent)
-		events = append(events, messageStopBytes)
+		events = state.finish(events, stopReason, getInt(usage, "candidatesTokenCount"))
 	}
 
 	return events, nil
@@ -37,6 +37,9 @@ func AnthropicToOpenAIRequest(req *models.MessagesRequest) (*models.ChatCompleti
 	if len(req.StopSequences) > 0 {
 		openaiReq.Stop = req.StopSequences
 	}
+	if req.Metadata != nil && req.Metadata.UserID != "" {
+		openaiReq.User = req.Metadata.UserID
+	}
 
 	// Convert messages
 	var messages []models.ChatMessage
@@ -83,6 +86,12 @@ func AnthropicToOpenAIRequest(req *models.MessagesRequest) (*models.ChatCompleti
 							})
 						}
 					}
+				case "document":
+					part, err := anthropicDocumentToOpenAIPart(block)
+					if err != nil {
+						return nil, err
+					}
+					contentParts = append(contentParts, part)
 				case "tool_use":
 					toolCallID := block.ID
 					if toolCallID == "" {
@@ -114,6 +123,17 @@ func AnthropicToOpenAIRequest(req *models.MessagesRequest) (*models.ChatCompleti
 							})
 						}
 					}
+				default:
+					if isAnthropicServerToolBlock(block.Type) {
+						text, err := anthropicServerToolText(block, "openai_chat")
+						if err != nil {
+							return nil, err
+						}
+						contentParts = append(contentParts, map[string]interface{}{
+							"type": "text",
+							"text": text,
+						})
+					}
 				}
 			}
 		}
@@ -156,7 +176,8 @@ func AnthropicToOpenAIRequest(req *models.MessagesRequest) (*models.ChatCompleti
 				Function: models.Function{
 					Name:        tool.Name,
 					Description: tool.Description,
-					Parameters:  tool.InputSchema,
+					Parameters:  TranslateToolSchema(tool.InputSchema, "anthropic", "openai_chat", tool.Strict != nil && *tool.Strict),
+					Strict:      tool.Strict,
 				},
 			})
 		}
@@ -177,15 +198,18 @@ func AnthropicToOpenAIRequest(req *models.MessagesRequest) (*models.ChatCompleti
 
 // convertToolChoice converts Anthropic tool choice to OpenAI format
 func convertToolChoice(choice interface{}, req *models.ChatCompletionRequest) error {
+	disableParallel := false
 	switch toolChoice := choice.(type) {
 	case models.ToolChoiceAuto:
 		if toolChoice.Type == "auto" {
 			req.ToolChoice = "auto"
 		}
+		disableParallel = toolChoice.DisableParallelToolUse
 	case models.ToolChoiceAny:
 		if toolChoice.Type == "any" {
 			req.ToolChoice = "required"
 		}
+		disableParallel = toolChoice.DisableParallelToolUse
 	case models.ToolChoiceTool:
 		if toolChoice.Type == "tool" {
 			req.ToolChoice = models.ToolChoiceObject{
@@ -195,6 +219,11 @@ func convertToolChoice(choice interface{}, req *models.ChatCompletionRequest) er
 				},
 			}
 		}
+		disableParallel = toolChoice.DisableParallelToolUse
+	case models.ToolChoiceNone:
+		if toolChoice.Type == "none" {
+			req.ToolChoice = "none"
+		}
 	case map[string]interface{}:
 		// Handle JSON unmarshaled tool choice
 		if choiceType, ok := toolChoice["type"].(string); ok {
@@ -203,6 +232,8 @@ func convertToolChoice(choice interface{}, req *models.ChatCompletionRequest) er
 				req.ToolChoice = "auto"
 			case "any":
 				req.ToolChoice = "required"
+			case "none":
+				req.ToolChoice = "none"
 			case "tool":
 				if name, ok := toolChoice["name"].(string); ok && name != "" {
 					req.ToolChoice = models.ToolChoiceObject{
@@ -214,9 +245,16 @@ func convertToolChoice(choice interface{}, req *models.ChatCompletionRequest) er
 				}
 			}
 		}
+		disableParallel, _ = toolChoice["disable_parallel_tool_use"].(bool)
 	default:
 		return fmt.Errorf("unsupported tool choice type: %T", choice)
 	}
+
+	// OpenAI expresses disable_parallel_tool_use as parallel_tool_calls
+	if disableParallel && len(req.Tools) > 0 {
+		parallel := false
+		req.ParallelToolCalls = &parallel
+	}
 	return nil
 }
 
@@ -234,7 +272,7 @@ func OpenAIToAnthropicResponse(resp map[string]interface{}, model string) (*mode
 		return anthropicResp, nil
 	}
 
-	choice := choices[0].(map[string]interface{})
+	choice, _ := choices[0].(map[string]interface{})
 	message, ok := choice["message"].(map[string]interface{})
 	if !ok {
 		return anthropicResp, nil
@@ -346,7 +384,7 @@ func OpenAIToAnthropicResponse(resp map[string]interface{}, model string) (*mode
 	// Handle tool calls
 	if toolCalls, ok := message["tool_calls"].([]interface{}); ok {
 		for _, tc := range toolCalls {
-			tcMap := tc.(map[string]interface{})
+			tcMap, _ := tc.(map[string]interface{})
 			function, _ := tcMap["function"].(map[string]interface{})
 			var input interface{}
 			if function != nil {
@@ -365,6 +403,15 @@ func OpenAIToAnthropicResponse(resp map[string]interface{}, model string) (*mode
 		}
 	}
 
+	// Anthropic has no refusal field, so the refusal is sent as text
+	refusal := getString(message, "refusal")
+	if refusal != "" {
+		contentBlocks = append(contentBlocks, models.ContentBlock{
+			Type: "text",
+			Text: refusal,
+		})
+	}
+
 	anthropicResp.Content = contentBlocks
 
 	// Convert finish reason
@@ -382,6 +429,10 @@ func OpenAIToAnthropicResponse(resp map[string]interface{}, model string) (*mode
 		}
 		anthropicResp.StopReason = &stopReason
 	}
+	if refusal != "" {
+		stopReason := "refusal"
+		anthropicResp.StopReason = &stopReason
+	}
 
 	// Convert usage
 	if usage, ok := resp["usage"].(map[string]interface{}); ok {
@@ -400,6 +451,7 @@ type OpenAIToAnthropicStreamState struct {
 	contentBlockStarted bool
 	currentBlockType    string
 	finishReason        string
+	refused             bool
 	finished            bool
 	startSent           bool
 }
@@ -462,7 +514,7 @@ func OpenAIStreamToAnthropicStream(data map[string]interface{}, state *OpenAIToA
 			state.currentBlockType = ""
 		}
 
-		stopReason := mapFinishReason(state.finishReason)
+		stopReason := state.stopReason()
 		messageDelta := map[string]interface{}{
 			"type": "message_delta",
 			"delta": map[string]interface{}{
@@ -484,14 +536,19 @@ func OpenAIStreamToAnthropicStream(data map[string]interface{}, state *OpenAIToA
 		return events, nil
 	}
 
-	choice := choices[0].(map[string]interface{})
+	choice, _ := choices[0].(map[string]interface{})
 	if finishReason, ok := choice["finish_reason"].(string); ok && finishReason != "" {
 		state.finishReason = finishReason
 	}
 
 	delta, _ := choice["delta"].(map[string]interface{})
 	if delta != nil {
-		if content, ok := delta["content"].(string); ok && content != "" {
+		content, _ := delta["content"].(string)
+		if refusal := getString(delta, "refusal"); refusal != "" {
+			state.refused = true
+			content += refusal
+		}
+		if content != "" {
 			if !state.contentBlockStarted || state.currentBlockType != "text" {
 				if state.contentBlockStarted {
 					stopEvent := map[string]interface{}{
@@ -609,7 +666,7 @@ func OpenAIStreamToAnthropicStream(data map[string]interface{}, state *OpenAIToA
 		messageDelta := map[string]interface{}{
 			"type": "message_delta",
 			"delta": map[string]interface{}{
-				"stop_reason": mapFinishReason(state.finishReason),
+				"stop_reason": state.stopReason(),
 			},
 		}
 		if usageMap, ok := data["usage"].(map[string]interface{}); ok {
@@ -628,6 +685,15 @@ func OpenAIStreamToAnthropicStream(data map[string]interface{}, state *OpenAIToA
 	return events, nil
 }
 
+// stopReason returns the Anthropic stop reason of the stream, which is refusal
+// once the model sent refusal text
+func (s *OpenAIToAnthropicStreamState) stopReason() string {
+	if s.refused {
+		return "refusal"
+	}
+	return mapFinishReason(s.finishReason)
+}
+
 func mapFinishReason(finishReason string) string {
 	switch finishReason {
 	case "stop":
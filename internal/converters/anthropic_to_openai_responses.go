@@ -3,6 +3,7 @@ package converters
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"ai_gateway/internal/models"
 )
@@ -32,6 +33,9 @@ func AnthropicToOpenAIResponsesRequest(req *models.MessagesRequest) (map[string]
 	if req.MaxTokens > 0 {
 		result["max_output_tokens"] = req.MaxTokens
 	}
+	if req.Metadata != nil && req.Metadata.UserID != "" {
+		result["user"] = req.Metadata.UserID
+	}
 
 	// Convert system to instructions
 	if instructions := extractSystemText(req.System); instructions != "" {
@@ -76,6 +80,12 @@ func AnthropicToOpenAIResponsesRequest(req *models.MessagesRequest) (map[string]
 							})
 						}
 					}
+				case "document":
+					part, err := anthropicDocumentToResponsesPart(block)
+					if err != nil {
+						return nil, err
+					}
+					contentParts = append(contentParts, part)
 				case "tool_use":
 					argsBytes, _ := json.Marshal(block.Input)
 					toolCalls = append(toolCalls, map[string]interface{}{
@@ -92,6 +102,17 @@ func AnthropicToOpenAIResponsesRequest(req *models.MessagesRequest) (map[string]
 						"call_id": blockToolResultID(block),
 						"output":  stringifyContent(block.Content),
 					})
+				default:
+					if isAnthropicServerToolBlock(block.Type) {
+						text, err := anthropicServerToolText(block, "openai_code")
+						if err != nil {
+							return nil, err
+						}
+						contentParts = append(contentParts, map[string]interface{}{
+							"type": "input_text",
+							"text": text,
+						})
+					}
 				}
 			}
 		}
@@ -121,13 +142,17 @@ func AnthropicToOpenAIResponsesRequest(req *models.MessagesRequest) (map[string]
 	if len(req.Tools) > 0 {
 		var tools []map[string]interface{}
 		for _, tool := range req.Tools {
+			function := map[string]interface{}{
+				"name":        tool.Name,
+				"description": tool.Description,
+				"parameters":  TranslateToolSchema(tool.InputSchema, "anthropic", "openai_code", tool.Strict != nil && *tool.Strict),
+			}
+			if tool.Strict != nil {
+				function["strict"] = *tool.Strict
+			}
 			tools = append(tools, map[string]interface{}{
-				"type": "function",
-				"function": map[string]interface{}{
-					"name":        tool.Name,
-					"description": tool.Description,
-					"parameters":  tool.InputSchema,
-				},
+				"type":     "function",
+				"function": function,
 			})
 		}
 		result["tools"] = tools
@@ -152,6 +177,7 @@ func OpenAIResponsesToAnthropicResponse(resp map[string]interface{}, model strin
 	}
 
 	var contentBlocks []models.ContentBlock
+	refused := false
 
 	for _, item := range output {
 		itemMap, ok := item.(map[string]interface{})
@@ -167,14 +193,20 @@ func OpenAIResponsesToAnthropicResponse(resp map[string]interface{}, model strin
 				for _, contentItem := range contentArr {
 					if contentMap, ok := contentItem.(map[string]interface{}); ok {
 						contentType := getString(contentMap, "type")
-						if contentType == "output_text" || contentType == "text" {
-							text := getString(contentMap, "text")
-							if text != "" {
-								contentBlocks = append(contentBlocks, models.ContentBlock{
-									Type: "text",
-									Text: text,
-								})
-							}
+						text := ""
+						switch contentType {
+						case "output_text", "text":
+							text = getString(contentMap, "text")
+						case "refusal":
+							// Anthropic has no refusal block, so the refusal is sent as text
+							text = getString(contentMap, "refusal")
+							refused = refused || text != ""
+						}
+						if text != "" {
+							contentBlocks = append(contentBlocks, models.ContentBlock{
+								Type: "text",
+								Text: text,
+							})
 						}
 					}
 				}
@@ -220,6 +252,9 @@ func OpenAIResponsesToAnthropicResponse(resp map[string]interface{}, model strin
 				break
 			}
 		}
+		if refused {
+			stopReason = "refusal"
+		}
 		anthropicResp.StopReason = &stopReason
 	}
 
@@ -234,169 +269,215 @@ func OpenAIResponsesToAnthropicResponse(resp map[string]interface{}, model strin
 	return anthropicResp, nil
 }
 
+// OpenAIResponsesToAnthropicStreamState stores OpenAI Responses to Anthropic
+// stream conversion state. Responses identify content by output item and
+// content part; each becomes one Anthropic content block.
+type OpenAIResponsesToAnthropicStreamState struct {
+	blocks     map[string]int // Responses part key -> Anthropic block index
+	openBlocks []string       // keys of started blocks not yet stopped
+	nextIndex  int
+	toolUse    bool
+	refused    bool
+	startSent  bool
+	finished   bool
+}
+
+// NewOpenAIResponsesToAnthropicStreamState creates a new stream state.
+func NewOpenAIResponsesToAnthropicStreamState() *OpenAIResponsesToAnthropicStreamState {
+	return &OpenAIResponsesToAnthropicStreamState{blocks: make(map[string]int)}
+}
+
+// responsesPartKey identifies a text part of an output item, or a whole
+// function call item when contentIndex is negative
+func responsesPartKey(outputIndex, contentIndex int) string {
+	if contentIndex < 0 {
+		return fmt.Sprintf("%d", outputIndex)
+	}
+	return fmt.Sprintf("%d:%d", outputIndex, contentIndex)
+}
+
+// startBlock starts the content block of a part, stopping any other open block first
+func (s *OpenAIResponsesToAnthropicStreamState) startBlock(events [][]byte, key string, block map[string]interface{}) [][]byte {
+	if _, ok := s.blocks[key]; ok {
+		return events
+	}
+	for len(s.openBlocks) > 0 {
+		events = s.stopBlock(events, s.openBlocks[0])
+	}
+	s.blocks[key] = s.nextIndex
+	s.openBlocks = append(s.openBlocks, key)
+	s.nextIndex++
+	return append(events, marshalEvent(map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         s.blocks[key],
+		"content_block": block,
+	}))
+}
+
+// stopBlock stops the content block of a part if it is open
+func (s *OpenAIResponsesToAnthropicStreamState) stopBlock(events [][]byte, key string) [][]byte {
+	for i, open := range s.openBlocks {
+		if open != key {
+			continue
+		}
+		s.openBlocks = append(s.openBlocks[:i], s.openBlocks[i+1:]...)
+		return append(events, marshalEvent(map[string]interface{}{
+			"type":  "content_block_stop",
+			"index": s.blocks[key],
+		}))
+	}
+	return events
+}
+
+// stopItemBlocks stops every open block of an output item
+func (s *OpenAIResponsesToAnthropicStreamState) stopItemBlocks(events [][]byte, outputIndex int) [][]byte {
+	item := responsesPartKey(outputIndex, -1)
+	for _, key := range append([]string(nil), s.openBlocks...) {
+		if key == item || strings.HasPrefix(key, item+":") {
+			events = s.stopBlock(events, key)
+		}
+	}
+	return events
+}
+
+// finish stops the open blocks and ends the message
+func (s *OpenAIResponsesToAnthropicStreamState) finish(events [][]byte, stopReason string, outputTokens int) [][]byte {
+	for len(s.openBlocks) > 0 {
+		events = s.stopBlock(events, s.openBlocks[0])
+	}
+	if s.toolUse {
+		stopReason = "tool_use"
+	}
+	if s.refused {
+		stopReason = "refusal"
+	}
+	messageDelta := marshalEvent(map[string]interface{}{
+		"type": "message_delta",
+		"delta": map[string]interface{}{
+			"stop_reason": stopReason,
+		},
+		"usage": map[string]interface{}{
+			"output_tokens": outputTokens,
+		},
+	})
+	messageStop := marshalEvent(map[string]interface{}{"type": "message_stop"})
+	s.finished = true
+	return append(events, messageDelta, messageStop)
+}
+
+// Close ends a stream that stopped before response.completed, so that clients
+// still receive the closing events. It returns nothing once the stream has finished.
+func (s *OpenAIResponsesToAnthropicStreamState) Close() [][]byte {
+	if !s.startSent || s.finished {
+		return nil
+	}
+	return s.finish(nil, "end_turn", 0)
+}
+
 // OpenAIResponsesStreamToAnthropicStream converts an OpenAI Responses API stream event to Anthropic format
-func OpenAIResponsesStreamToAnthropicStream(data map[string]interface{}, isFirst bool) ([][]byte, error) {
-	var events [][]byte
+func OpenAIResponsesStreamToAnthropicStream(event *models.ResponsesStreamEvent, state *OpenAIResponsesToAnthropicStreamState) ([][]byte, error) {
+	if state == nil {
+		state = NewOpenAIResponsesToAnthropicStreamState()
+	}
+	if state.finished {
+		return nil, nil
+	}
 
-	eventType := getString(data, "type")
+	var events [][]byte
 
-	switch eventType {
-	case "response.created":
-		// Send message_start event
-		response, _ := data["response"].(map[string]interface{})
+	if !state.startSent {
+		response := event.Response
+		if response == nil {
+			response = &models.ResponsesResponse{}
+		}
 		startEvent := map[string]interface{}{
 			"type": "message_start",
 			"message": map[string]interface{}{
-				"id":          getString(response, "id"),
+				"id":          response.ID,
 				"type":        "message",
 				"role":        "assistant",
 				"content":     []interface{}{},
-				"model":       getString(response, "model"),
+				"model":       response.Model,
 				"stop_reason": nil,
 				"usage":       map[string]interface{}{"input_tokens": 0, "output_tokens": 0},
 			},
 		}
-		startBytes, _ := json.Marshal(startEvent)
-		events = append(events, startBytes)
+		events = append(events, marshalEvent(startEvent))
+		state.startSent = true
+	}
+
+	outputIndex := event.Index()
 
+	switch event.Type {
 	case "response.output_item.added":
-		// Send content_block_start event
-		index := getInt(data, "output_index")
-		item, _ := data["item"].(map[string]interface{})
-		itemType := getString(item, "type")
-
-		var contentBlock map[string]interface{}
-		if itemType == "message" {
-			contentBlock = map[string]interface{}{
-				"type": "text",
-				"text": "",
-			}
-		} else if itemType == "function_call" {
-			contentBlock = map[string]interface{}{
+		// Text blocks start with their content part; function calls start here
+		if item := event.Item; item != nil && item.Type == "function_call" {
+			state.toolUse = true
+			events = state.startBlock(events, responsesPartKey(outputIndex, -1), map[string]interface{}{
 				"type":  "tool_use",
-				"id":    getString(item, "call_id"),
-				"name":  getString(item, "name"),
+				"id":    item.CallID,
+				"name":  item.Name,
 				"input": map[string]interface{}{},
-			}
-		}
-
-		if contentBlock != nil {
-			blockStartEvent := map[string]interface{}{
-				"type":          "content_block_start",
-				"index":         index,
-				"content_block": contentBlock,
-			}
-			blockStartBytes, _ := json.Marshal(blockStartEvent)
-			events = append(events, blockStartBytes)
+			})
 		}
 
 	case "response.content_part.added":
-		// Content part started
-		index := getInt(data, "output_index")
-		blockStartEvent := map[string]interface{}{
-			"type":  "content_block_start",
-			"index": index,
-			"content_block": map[string]interface{}{
+		if event.Part != nil && (event.Part.Type == "output_text" || event.Part.Type == "refusal") {
+			events = state.startBlock(events, responsesPartKey(outputIndex, event.PartIndex()), map[string]interface{}{
 				"type": "text",
 				"text": "",
-			},
+			})
 		}
-		blockStartBytes, _ := json.Marshal(blockStartEvent)
-		events = append(events, blockStartBytes)
-
-	case "response.output_text.delta":
-		// Text delta
-		index := getInt(data, "output_index")
-		delta := getString(data, "delta")
-		if delta != "" {
-			deltaEvent := map[string]interface{}{
-				"type":  "content_block_delta",
-				"index": index,
-				"delta": map[string]interface{}{
-					"type": "text_delta",
-					"text": delta,
-				},
-			}
-			deltaBytes, _ := json.Marshal(deltaEvent)
-			events = append(events, deltaBytes)
+
+	case "response.output_text.delta", "response.refusal.delta":
+		if event.Delta == "" {
+			break
+		}
+		if event.Type == "response.refusal.delta" {
+			state.refused = true
 		}
+		key := responsesPartKey(outputIndex, event.PartIndex())
+		events = state.startBlock(events, key, map[string]interface{}{"type": "text", "text": ""})
+		events = append(events, marshalEvent(map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": state.blocks[key],
+			"delta": map[string]interface{}{
+				"type": "text_delta",
+				"text": event.Delta,
+			},
+		}))
 
 	case "response.function_call_arguments.delta":
-		// Function call arguments delta
-		index := getInt(data, "output_index")
-		delta := getString(data, "delta")
-		if delta != "" {
-			deltaEvent := map[string]interface{}{
-				"type":  "content_block_delta",
-				"index": index,
-				"delta": map[string]interface{}{
-					"type":         "input_json_delta",
-					"partial_json": delta,
-				},
-			}
-			deltaBytes, _ := json.Marshal(deltaEvent)
-			events = append(events, deltaBytes)
+		index, ok := state.blocks[responsesPartKey(outputIndex, -1)]
+		if event.Delta == "" || !ok {
+			break
 		}
-
-	case "response.output_item.done":
-		// Content block done
-		index := getInt(data, "output_index")
-		stopBlockEvent := map[string]interface{}{
-			"type":  "content_block_stop",
+		events = append(events, marshalEvent(map[string]interface{}{
+			"type":  "content_block_delta",
 			"index": index,
-		}
-		stopBlockBytes, _ := json.Marshal(stopBlockEvent)
-		events = append(events, stopBlockBytes)
+			"delta": map[string]interface{}{
+				"type":         "input_json_delta",
+				"partial_json": event.Delta,
+			},
+		}))
 
-	case "response.completed":
-		// Response completed
-		response, _ := data["response"].(map[string]interface{})
-		status := getString(response, "status")
+	case "response.content_part.done":
+		events = state.stopBlock(events, responsesPartKey(outputIndex, event.PartIndex()))
 
-		var stopReason string
-		switch status {
-		case "completed":
-			stopReason = "end_turn"
-		case "incomplete":
-			stopReason = "max_tokens"
-		default:
-			stopReason = "end_turn"
-		}
+	case "response.output_item.done":
+		events = state.stopItemBlocks(events, outputIndex)
 
-		// Check for tool use
-		if output, ok := response["output"].([]interface{}); ok {
-			for _, item := range output {
-				if itemMap, ok := item.(map[string]interface{}); ok {
-					if getString(itemMap, "type") == "function_call" {
-						stopReason = "tool_use"
-						break
-					}
-				}
+	case "response.completed", "response.incomplete", "response.failed":
+		stopReason, outputTokens := "end_turn", 0
+		if response := event.Response; response != nil {
+			if response.Status == "incomplete" {
+				stopReason = "max_tokens"
 			}
-		}
-
-		messageDeltaEvent := map[string]interface{}{
-			"type": "message_delta",
-			"delta": map[string]interface{}{
-				"stop_reason": stopReason,
-			},
-			"usage": map[string]interface{}{
-				"output_tokens": 0,
-			},
-		}
-		if usage, ok := response["usage"].(map[string]interface{}); ok {
-			messageDeltaEvent["usage"] = map[string]interface{}{
-				"output_tokens": getInt(usage, "output_tokens"),
+			if response.Usage != nil {
+				outputTokens = response.Usage.OutputTokens
 			}
 		}
-		messageDeltaBytes, _ := json.Marshal(messageDeltaEvent)
-		events = append(events, messageDeltaBytes)
-
-		messageStopEvent := map[string]interface{}{
-			"type": "message_stop",
-		}
-		messageStopBytes, _ := json.Marshal(messageStopEvent)
-		events = append(events, messageStopBytes)
+		events = state.finish(events, stopReason, outputTokens)
 	}
 
 	return events, nil
@@ -0,0 +1,53 @@
+package converters
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of content a request message can carry
+const (
+	contentText     = "text"
+	contentImage    = "image"
+	contentDocument = "document"
+	contentAudio    = "audio"
+)
+
+// protocolContent lists the content kinds each upstream protocol accepts in requests
+var protocolContent = map[string][]string{
+	"openai_chat": {contentText, contentImage, contentDocument, contentAudio},
+	"openai_code": {contentText, contentImage, contentDocument},
+	"anthropic":   {contentText, contentImage, contentDocument},
+	"gemini":      {contentText, contentImage, contentDocument, contentAudio},
+}
+
+// protocolNames names the upstream protocols in error messages
+var protocolNames = map[string]string{
+	"openai_chat": "OpenAI chat completions",
+	"openai_code": "the OpenAI Responses API",
+	"anthropic":   "Anthropic",
+	"gemini":      "Gemini",
+}
+
+// openAIAudioFormats maps the audio formats OpenAI accepts to their MIME types
+var openAIAudioFormats = map[string]string{
+	"wav": "audio/wav",
+	"mp3": "audio/mp3",
+}
+
+// unsupportedContentError explains that an upstream protocol cannot carry a kind of content
+func unsupportedContentError(protocol, kind string) error {
+	return fmt.Errorf("%w: %s does not accept %s input (supported: %s)",
+		ErrUnsupportedContent, protocolNames[protocol], kind, strings.Join(protocolContent[protocol], ", "))
+}
+
+// openAIAudioFormat returns the OpenAI input_audio format of an audio MIME type
+func openAIAudioFormat(mimeType string) (string, bool) {
+	switch mimeType {
+	case "audio/wav", "audio/x-wav", "audio/wave":
+		return "wav", true
+	case "audio/mp3", "audio/mpeg":
+		return "mp3", true
+	}
+	return "", false
+}
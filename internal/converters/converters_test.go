@@ -1,7 +1,18 @@
 package converters
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"reflect"
+	"strings"
 	"testing"
 
 	"ai_gateway/internal/models"
@@ -203,6 +214,66 @@ func TestOpenAIToAnthropicRequest_ToolUseAndResult(t *testing.T) {
 	}
 }
 
+func TestAnthropicRequest_RoundTripThroughOpenAI(t *testing.T) {
+	cases := []struct {
+		name       string
+		toolChoice string
+	}{
+		{"tool", `{"type":"tool","name":"lookup","disable_parallel_tool_use":true}`},
+		{"auto", `{"type":"auto","disable_parallel_tool_use":true}`},
+		{"any", `{"type":"any"}`},
+		{"none", `{"type":"none"}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := `{
+				"model": "claude-3",
+				"max_tokens": 256,
+				"top_k": 5,
+				"stop_sequences": ["END", "STOP"],
+				"metadata": {"user_id": "user-42"},
+				"messages": [{"role": "user", "content": "hi"}],
+				"tools": [{"name": "lookup", "input_schema": {"type": "object"}}],
+				"tool_choice": ` + tc.toolChoice + `
+			}`
+			var original models.MessagesRequest
+			if err := json.Unmarshal([]byte(body), &original); err != nil {
+				t.Fatalf("unmarshal error: %v", err)
+			}
+
+			openaiReq, err := AnthropicToOpenAIRequest(&original)
+			if err != nil {
+				t.Fatalf("AnthropicToOpenAIRequest error: %v", err)
+			}
+
+			// Decode the OpenAI request as the OpenAI route would receive it
+			openaiJSON, _ := json.Marshal(openaiReq)
+			var inbound models.ChatCompletionRequest
+			if err := json.Unmarshal(openaiJSON, &inbound); err != nil {
+				t.Fatalf("unmarshal error: %v", err)
+			}
+
+			back, err := OpenAIToAnthropicRequest(&inbound)
+			if err != nil {
+				t.Fatalf("OpenAIToAnthropicRequest error: %v", err)
+			}
+
+			var got, want map[string]interface{}
+			backJSON, _ := json.Marshal(back)
+			json.Unmarshal(backJSON, &got)
+			json.Unmarshal([]byte(body), &want)
+			for _, key := range []string{"top_k", "stop_sequences", "metadata", "tool_choice"} {
+				gotJSON, _ := json.Marshal(got[key])
+				wantJSON, _ := json.Marshal(want[key])
+				if string(gotJSON) != string(wantJSON) {
+					t.Fatalf("%s mismatch: got %s, want %s", key, gotJSON, wantJSON)
+				}
+			}
+		})
+	}
+}
+
 func TestOpenAIToAnthropicResponse_ToolCallsUsageStopReason(t *testing.T) {
 	resp := map[string]interface{}{
 		"id": "resp1",
@@ -540,6 +611,34 @@ func TestOpenAIChatToOpenAIResponsesRequest_MessagesAndTools(t *testing.T) {
 	}
 }
 
+func TestOpenAIChatToOpenAIResponsesRequest_ReasoningAndVerbosity(t *testing.T) {
+	req := &models.ChatCompletionRequest{
+		Model:           "gpt-5",
+		Messages:        []models.ChatMessage{{Role: "user", Content: "hi"}},
+		ReasoningEffort: "low",
+		Verbosity:       "high",
+	}
+
+	result, err := OpenAIChatToOpenAIResponsesRequest(req)
+	if err != nil {
+		t.Fatalf("OpenAIChatToOpenAIResponsesRequest error: %v", err)
+	}
+	if reasoning, _ := result["reasoning"].(map[string]interface{}); getString(reasoning, "effort") != "low" {
+		t.Fatalf("reasoning mismatch: %#v", result["reasoning"])
+	}
+	if text, _ := result["text"].(map[string]interface{}); getString(text, "verbosity") != "high" {
+		t.Fatalf("text mismatch: %#v", result["text"])
+	}
+
+	back, err := OpenAIResponsesToOpenAIChatRequest(result)
+	if err != nil {
+		t.Fatalf("OpenAIResponsesToOpenAIChatRequest error: %v", err)
+	}
+	if back.ReasoningEffort != "low" || back.Verbosity != "high" {
+		t.Fatalf("round trip mismatch: effort=%q verbosity=%q", back.ReasoningEffort, back.Verbosity)
+	}
+}
+
 func TestOpenAIResponsesToOpenAIChatRequest_MessagesAndTools(t *testing.T) {
 	req := map[string]interface{}{
 		"model":       "gpt-4",
@@ -619,6 +718,35 @@ func TestOpenAIResponsesToOpenAIChatRequest_MessagesAndTools(t *testing.T) {
 	}
 }
 
+func TestOpenAIResponsesToOpenAIChatRequest_BuiltInTools(t *testing.T) {
+	req := map[string]interface{}{
+		"model": "gpt-4o",
+		"input": "hi",
+		"tools": []interface{}{
+			map[string]interface{}{
+				"type":       "function",
+				"name":       "lookup",
+				"parameters": map[string]interface{}{"type": "object"},
+			},
+		},
+	}
+	chatReq, err := OpenAIResponsesToOpenAIChatRequest(req)
+	if err != nil {
+		t.Fatalf("OpenAIResponsesToOpenAIChatRequest error: %v", err)
+	}
+	if len(chatReq.Tools) != 1 || chatReq.Tools[0].Function.Name != "lookup" || chatReq.Tools[0].Function.Parameters == nil {
+		t.Fatalf("flat function tool mismatch: %#v", chatReq.Tools)
+	}
+
+	for _, toolType := range []string{"web_search_preview", "file_search", "computer_use_preview"} {
+		req["tools"] = []interface{}{map[string]interface{}{"type": toolType}}
+		_, err := OpenAIResponsesToOpenAIChatRequest(req)
+		if !errors.Is(err, ErrUnsupportedTool) || !strings.Contains(err.Error(), toolType) {
+			t.Fatalf("expected unsupported tool error for %s, got %v", toolType, err)
+		}
+	}
+}
+
 func TestOpenAIResponsesToOpenAIChatResponse_ToolCallsUsage(t *testing.T) {
 	resp := map[string]interface{}{
 		"id":    "resp1",
@@ -703,13 +831,13 @@ func TestOpenAIChatResponseToOpenAIResponsesResponse_Length(t *testing.T) {
 func TestOpenAIResponsesStreamToOpenAIChatStream_Text(t *testing.T) {
 	state := NewOpenAIResponsesToChatStreamState("gpt-4")
 
-	createdEvents, err := OpenAIResponsesStreamToOpenAIChatStream(map[string]interface{}{
+	createdEvents, err := OpenAIResponsesStreamToOpenAIChatStream(responsesEvent(t, map[string]interface{}{
 		"type": "response.created",
 		"response": map[string]interface{}{
 			"id":    "resp1",
 			"model": "gpt-4",
 		},
-	}, state)
+	}), state)
 	if err != nil {
 		t.Fatalf("response.created error: %v", err)
 	}
@@ -724,11 +852,11 @@ func TestOpenAIResponsesStreamToOpenAIChatStream_Text(t *testing.T) {
 		t.Fatalf("start chunk mismatch: %#v", chunk.Choices[0].Delta)
 	}
 
-	deltaEvents, err := OpenAIResponsesStreamToOpenAIChatStream(map[string]interface{}{
+	deltaEvents, err := OpenAIResponsesStreamToOpenAIChatStream(responsesEvent(t, map[string]interface{}{
 		"type":         "response.output_text.delta",
 		"output_index": float64(0),
 		"delta":        "hi",
-	}, state)
+	}), state)
 	if err != nil {
 		t.Fatalf("output_text.delta error: %v", err)
 	}
@@ -742,12 +870,12 @@ func TestOpenAIResponsesStreamToOpenAIChatStream_Text(t *testing.T) {
 		t.Fatalf("delta content mismatch: %#v", chunk.Choices[0].Delta)
 	}
 
-	completedEvents, err := OpenAIResponsesStreamToOpenAIChatStream(map[string]interface{}{
+	completedEvents, err := OpenAIResponsesStreamToOpenAIChatStream(responsesEvent(t, map[string]interface{}{
 		"type": "response.completed",
 		"response": map[string]interface{}{
 			"status": "completed",
 		},
-	}, state)
+	}), state)
 	if err != nil {
 		t.Fatalf("response.completed error: %v", err)
 	}
@@ -762,6 +890,252 @@ func TestOpenAIResponsesStreamToOpenAIChatStream_Text(t *testing.T) {
 	}
 }
 
+func TestOpenAIResponsesToOpenAIChat_Annotations(t *testing.T) {
+	resp := map[string]interface{}{
+		"id":     "resp1",
+		"status": "completed",
+		"output": []interface{}{
+			map[string]interface{}{
+				"type": "message",
+				"content": []interface{}{
+					map[string]interface{}{"type": "output_text", "text": "Héllo. ", "annotations": []interface{}{}},
+					map[string]interface{}{
+						"type": "output_text",
+						"text": "Go is fast.",
+						"annotations": []interface{}{
+							map[string]interface{}{"type": "url_citation", "start_index": float64(0), "end_index": float64(11), "url": "https://go.dev", "title": "Go"},
+							map[string]interface{}{"type": "file_citation", "file_id": "file-1", "filename": "notes.txt", "index": float64(11)},
+							map[string]interface{}{"type": "file_path", "file_id": "file-2", "index": float64(0)},
+						},
+					},
+				},
+			},
+		},
+	}
+	chatResp, err := OpenAIResponsesToOpenAIChatResponse(resp, "gpt-4o")
+	if err != nil {
+		t.Fatalf("OpenAIResponsesToOpenAIChatResponse error: %v", err)
+	}
+	annotations := chatResp.Choices[0].Message.Annotations
+	if len(annotations) != 2 {
+		t.Fatalf("annotations mismatch: %#v", annotations)
+	}
+	url := annotations[0].URLCitation
+	if annotations[0].Type != "url_citation" || url == nil || url.StartIndex != 7 || url.EndIndex != 18 || url.URL != "https://go.dev" || url.Title != "Go" {
+		t.Fatalf("url citation mismatch: %#v", url)
+	}
+	file := annotations[1].FileCitation
+	if annotations[1].Type != "file_citation" || file == nil || file.FileID != "file-1" || file.Filename != "notes.txt" || file.Index != 18 {
+		t.Fatalf("file citation mismatch: %#v", file)
+	}
+
+	state := NewOpenAIResponsesToChatStreamState("gpt-4o")
+	events := []map[string]interface{}{
+		{"type": "response.created", "response": map[string]interface{}{"id": "resp1"}},
+		{"type": "response.output_text.delta", "output_index": float64(0), "content_index": float64(0), "delta": "Héllo. "},
+		{"type": "response.output_text.delta", "output_index": float64(0), "content_index": float64(1), "delta": "Go is "},
+		{"type": "response.output_text.delta", "output_index": float64(0), "content_index": float64(1), "delta": "fast."},
+		{
+			"type": "response.output_text.annotation.added", "output_index": float64(0), "content_index": float64(1),
+			"annotation": map[string]interface{}{"type": "url_citation", "start_index": float64(0), "end_index": float64(11), "url": "https://go.dev"},
+		},
+	}
+	var last [][]byte
+	for _, event := range events {
+		if last, err = OpenAIResponsesStreamToOpenAIChatStream(responsesEvent(t, event), state); err != nil {
+			t.Fatalf("%s error: %v", event["type"], err)
+		}
+	}
+	if len(last) != 1 {
+		t.Fatalf("expected 1 annotation chunk, got %d", len(last))
+	}
+	var chunk models.ChatCompletionChunk
+	if err := json.Unmarshal(last[0], &chunk); err != nil {
+		t.Fatalf("unmarshal annotation chunk: %v", err)
+	}
+	delta := chunk.Choices[0].Delta
+	if delta == nil || len(delta.Annotations) != 1 || delta.Annotations[0].URLCitation == nil ||
+		delta.Annotations[0].URLCitation.StartIndex != 7 || delta.Annotations[0].URLCitation.EndIndex != 18 {
+		t.Fatalf("annotation delta mismatch: %s", last[0])
+	}
+}
+
+func TestRefusal_AcrossFormats(t *testing.T) {
+	chatResp := map[string]interface{}{
+		"id": "chatcmpl-1",
+		"choices": []interface{}{
+			map[string]interface{}{
+				"message":       map[string]interface{}{"role": "assistant", "content": nil, "refusal": "I can't help with that."},
+				"finish_reason": "stop",
+			},
+		},
+	}
+	anthropicResp, err := OpenAIToAnthropicResponse(chatResp, "claude-3")
+	if err != nil {
+		t.Fatalf("OpenAIToAnthropicResponse error: %v", err)
+	}
+	if len(anthropicResp.Content) != 1 || anthropicResp.Content[0].Text != "I can't help with that." {
+		t.Fatalf("anthropic content mismatch: %#v", anthropicResp.Content)
+	}
+	if anthropicResp.StopReason == nil || *anthropicResp.StopReason != "refusal" {
+		t.Fatalf("anthropic stop reason mismatch: %#v", anthropicResp.StopReason)
+	}
+
+	geminiResp, err := OpenAIToGeminiResponse(chatResp)
+	if err != nil {
+		t.Fatalf("OpenAIToGeminiResponse error: %v", err)
+	}
+	candidate := geminiResp.Candidates[0]
+	if len(candidate.Content.Parts) != 1 || candidate.Content.Parts[0].Text != "I can't help with that." || candidate.FinishReason != "SAFETY" {
+		t.Fatalf("gemini candidate mismatch: %#v", candidate)
+	}
+
+	// OpenAI formats keep the refusal in its own field
+	var parsed models.ChatCompletionResponse
+	data, _ := json.Marshal(chatResp)
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("decode chat response: %v", err)
+	}
+	responsesResp, err := OpenAIChatResponseToOpenAIResponsesResponse(&parsed)
+	if err != nil {
+		t.Fatalf("OpenAIChatResponseToOpenAIResponsesResponse error: %v", err)
+	}
+	output, _ := responsesResp["output"].([]map[string]interface{})
+	parts, _ := output[0]["content"].([]map[string]interface{})
+	if len(parts) != 1 || getString(parts[0], "type") != "refusal" || getString(parts[0], "refusal") != "I can't help with that." {
+		t.Fatalf("responses output mismatch: %#v", output)
+	}
+	data, _ = json.Marshal(responsesResp)
+	var responsesMap map[string]interface{}
+	json.Unmarshal(data, &responsesMap)
+	back, err := OpenAIResponsesToOpenAIChatResponse(responsesMap, "gpt-4o")
+	if err != nil {
+		t.Fatalf("OpenAIResponsesToOpenAIChatResponse error: %v", err)
+	}
+	if back.Choices[0].Message.Refusal != "I can't help with that." || back.Choices[0].Message.Content != nil {
+		t.Fatalf("chat message mismatch: %#v", back.Choices[0].Message)
+	}
+	fromResponses, err := OpenAIResponsesToAnthropicResponse(responsesMap, "claude-3")
+	if err != nil {
+		t.Fatalf("OpenAIResponsesToAnthropicResponse error: %v", err)
+	}
+	if len(fromResponses.Content) != 1 || fromResponses.StopReason == nil || *fromResponses.StopReason != "refusal" {
+		t.Fatalf("anthropic response mismatch: %#v", fromResponses)
+	}
+
+	// Streams
+	anthropicState := NewOpenAIToAnthropicStreamState()
+	var events [][]byte
+	for _, chunk := range []map[string]interface{}{
+		{"id": "c1", "choices": []interface{}{map[string]interface{}{"delta": map[string]interface{}{"role": "assistant", "refusal": "I can't"}}}},
+		{"id": "c1", "choices": []interface{}{map[string]interface{}{"delta": map[string]interface{}{"refusal": " help."}, "finish_reason": "stop"}}},
+	} {
+		out, err := OpenAIStreamToAnthropicStream(chunk, anthropicState)
+		if err != nil {
+			t.Fatalf("OpenAIStreamToAnthropicStream error: %v", err)
+		}
+		events = append(events, out...)
+	}
+	blocks, stopReason := anthropicStreamBlocks(t, events)
+	if len(blocks) != 1 || blocks[0] != "text" || stopReason != "refusal" {
+		t.Fatalf("anthropic stream mismatch: %v %q", blocks, stopReason)
+	}
+
+	geminiChunk, err := OpenAIStreamToGeminiStream(map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{"delta": map[string]interface{}{"refusal": "No."}}},
+	})
+	if err != nil || !strings.Contains(string(geminiChunk), `"text":"No."`) {
+		t.Fatalf("gemini stream chunk mismatch: %s %v", geminiChunk, err)
+	}
+
+	chatState := NewOpenAIChatToResponsesStreamState("gpt-4o")
+	responsesEvents, err := OpenAIChatStreamToOpenAIResponsesStream(&models.ChatCompletionChunk{
+		ID:      "c1",
+		Choices: []models.Choice{{Delta: &models.ChatMessage{Refusal: "No."}}},
+	}, chatState)
+	if err != nil {
+		t.Fatalf("OpenAIChatStreamToOpenAIResponsesStream error: %v", err)
+	}
+	last := &models.ResponsesStreamEvent{}
+	if err := json.Unmarshal(responsesEvents[len(responsesEvents)-1], last); err != nil {
+		t.Fatalf("unmarshal event: %v", err)
+	}
+	if last.Type != "response.refusal.delta" || last.Delta != "No." || last.PartIndex() != 1 {
+		t.Fatalf("responses refusal event mismatch: %s", responsesEvents[len(responsesEvents)-1])
+	}
+
+	chunks, err := OpenAIResponsesStreamToOpenAIChatStream(last, NewOpenAIResponsesToChatStreamState("gpt-4o"))
+	if err != nil {
+		t.Fatalf("OpenAIResponsesStreamToOpenAIChatStream error: %v", err)
+	}
+	var chunk models.ChatCompletionChunk
+	if err := json.Unmarshal(chunks[len(chunks)-1], &chunk); err != nil || chunk.Choices[0].Delta.Refusal != "No." {
+		t.Fatalf("chat refusal chunk mismatch: %s", chunks[len(chunks)-1])
+	}
+}
+
+func TestNamedMessages_CrossProvider(t *testing.T) {
+	chatReq := &models.ChatCompletionRequest{
+		Model: "gpt-4o",
+		User:  "user-1",
+		Messages: []models.ChatMessage{
+			{Role: "system", Name: "ignored", Content: "You moderate a debate."},
+			{Role: "user", Name: "alice", Content: "Tabs."},
+			{Role: "assistant", Name: "bob", Content: []interface{}{
+				map[string]interface{}{"type": "text", "text": "Spaces."},
+			}},
+			{Role: "user", Content: "Who is right?"},
+		},
+	}
+
+	anthropicReq, err := OpenAIToAnthropicRequest(chatReq)
+	if err != nil {
+		t.Fatalf("OpenAIToAnthropicRequest error: %v", err)
+	}
+	if anthropicReq.Messages[0].Content != "alice: Tabs." || anthropicReq.Messages[1].Content != "bob: Spaces." || anthropicReq.Messages[2].Content != "Who is right?" {
+		t.Fatalf("anthropic messages mismatch: %#v", anthropicReq.Messages)
+	}
+	if anthropicReq.Metadata == nil || anthropicReq.Metadata.UserID != "user-1" {
+		t.Fatalf("anthropic metadata mismatch: %#v", anthropicReq.Metadata)
+	}
+	if getString(mapSlice(chatReq.Messages[2].Content)[0], "text") != "Spaces." {
+		t.Fatalf("request content was modified: %#v", chatReq.Messages[2].Content)
+	}
+
+	geminiReq, err := OpenAIToGeminiRequest(chatReq)
+	if err != nil {
+		t.Fatalf("OpenAIToGeminiRequest error: %v", err)
+	}
+	if geminiReq.Contents[0].Parts[0].Text != "alice: Tabs." || geminiReq.Contents[1].Parts[0].Text != "bob: Spaces." {
+		t.Fatalf("gemini contents mismatch: %#v", geminiReq.Contents)
+	}
+
+	responsesReq, err := OpenAIChatToOpenAIResponsesRequest(chatReq)
+	if err != nil {
+		t.Fatalf("OpenAIChatToOpenAIResponsesRequest error: %v", err)
+	}
+	input, _ := responsesReq["input"].([]map[string]interface{})
+	userParts, _ := input[0]["content"].(string)
+	assistantParts := mapSlice(input[1]["content"])
+	if userParts != "alice: Tabs." || len(assistantParts) != 1 || getString(assistantParts[0], "text") != "bob: Spaces." {
+		t.Fatalf("responses input mismatch: %#v", input)
+	}
+
+	// Anthropic metadata reaches the Responses API as the end user
+	fromAnthropic, err := AnthropicToOpenAIResponsesRequest(&models.MessagesRequest{
+		Model:     "gpt-4o",
+		MaxTokens: 64,
+		Metadata:  &models.Metadata{UserID: "user-2"},
+		Messages:  []models.AnthropicMessage{{Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatalf("AnthropicToOpenAIResponsesRequest error: %v", err)
+	}
+	if fromAnthropic["user"] != "user-2" {
+		t.Fatalf("responses user mismatch: %#v", fromAnthropic["user"])
+	}
+}
+
 func TestOpenAIChatStreamToOpenAIResponsesStream_TextAndFinish(t *testing.T) {
 	state := NewOpenAIChatToResponsesStreamState("gpt-4")
 	chunk := &models.ChatCompletionChunk{
@@ -827,6 +1201,745 @@ func TestOpenAIChatStreamToOpenAIResponsesStream_TextAndFinish(t *testing.T) {
 	}
 }
 
+func TestOpenAIToGeminiRequest_ImageParts(t *testing.T) {
+	req := &models.ChatCompletionRequest{
+		Model: "gemini-pro",
+		Messages: []models.ChatMessage{
+			{
+				Role: "user",
+				Content: []interface{}{
+					map[string]interface{}{"type": "text", "text": "compare "},
+					map[string]interface{}{"type": "text", "text": "these"},
+					map[string]interface{}{
+						"type":      "image_url",
+						"image_url": map[string]interface{}{"url": "data:image/png;base64,iVBORw0KGgo="},
+					},
+					map[string]interface{}{
+						"type":      "image_url",
+						"image_url": map[string]interface{}{"url": "https://example.com/cat.jpg?size=large"},
+					},
+				},
+			},
+		},
+	}
+
+	geminiReq, err := OpenAIToGeminiRequest(req)
+	if err != nil {
+		t.Fatalf("OpenAIToGeminiRequest error: %v", err)
+	}
+	if len(geminiReq.Contents) != 1 {
+		t.Fatalf("contents length mismatch: %d", len(geminiReq.Contents))
+	}
+	parts := geminiReq.Contents[0].Parts
+	if len(parts) != 3 {
+		t.Fatalf("parts length mismatch: %d", len(parts))
+	}
+	if parts[0].Text != "compare these" {
+		t.Fatalf("text part mismatch: %q", parts[0].Text)
+	}
+	if parts[1].InlineData == nil || parts[1].InlineData.MimeType != "image/png" || parts[1].InlineData.Data != "iVBORw0KGgo=" {
+		t.Fatalf("inline data mismatch: %#v", parts[1].InlineData)
+	}
+	if parts[2].FileData == nil || parts[2].FileData.MimeType != "image/jpeg" || parts[2].FileData.FileURI != "https://example.com/cat.jpg?size=large" {
+		t.Fatalf("file data mismatch: %#v", parts[2].FileData)
+	}
+}
+
+func TestDocumentContent_CrossProvider(t *testing.T) {
+	anthropicReq := &models.MessagesRequest{
+		Model:     "claude-3",
+		MaxTokens: 64,
+		Messages: []models.AnthropicMessage{
+			{
+				Role: "user",
+				Content: []interface{}{
+					map[string]interface{}{
+						"type":   "document",
+						"title":  "report.pdf",
+						"source": map[string]interface{}{"type": "base64", "media_type": "application/pdf", "data": "JVBERi0="},
+					},
+					map[string]interface{}{"type": "text", "text": "summarize"},
+				},
+			},
+		},
+	}
+
+	openaiReq, err := AnthropicToOpenAIRequest(anthropicReq)
+	if err != nil {
+		t.Fatalf("AnthropicToOpenAIRequest error: %v", err)
+	}
+	parts := mapSlice(openaiReq.Messages[0].Content)
+	if len(parts) != 2 || getString(parts[0], "type") != "file" {
+		t.Fatalf("content parts mismatch: %#v", openaiReq.Messages[0].Content)
+	}
+	file := mapValue(parts[0], "file")
+	if getString(file, "file_data") != "data:application/pdf;base64,JVBERi0=" || getString(file, "filename") != "report.pdf" {
+		t.Fatalf("file part mismatch: %#v", file)
+	}
+
+	geminiReq, err := AnthropicToGeminiRequest(anthropicReq)
+	if err != nil {
+		t.Fatalf("AnthropicToGeminiRequest error: %v", err)
+	}
+	inline := geminiReq.Contents[0].Parts[0].InlineData
+	if inline == nil || inline.MimeType != "application/pdf" || inline.Data != "JVBERi0=" {
+		t.Fatalf("inline data mismatch: %#v", inline)
+	}
+
+	back, err := OpenAIToAnthropicRequest(&models.ChatCompletionRequest{
+		Model: "gpt-4o",
+		Messages: []models.ChatMessage{{
+			Role:    "user",
+			Content: []interface{}{parts[0]},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("OpenAIToAnthropicRequest error: %v", err)
+	}
+	blocks, ok := back.Messages[0].Content.([]models.ContentBlock)
+	if !ok || len(blocks) != 1 || blocks[0].Type != "document" || blocks[0].Source.Data != "JVBERi0=" {
+		t.Fatalf("document block mismatch: %#v", back.Messages[0].Content)
+	}
+
+	_, err = OpenAIToAnthropicRequest(&models.ChatCompletionRequest{
+		Model: "gpt-4o",
+		Messages: []models.ChatMessage{{
+			Role: "user",
+			Content: []interface{}{
+				map[string]interface{}{"type": "file", "file": map[string]interface{}{"file_id": "file-abc"}},
+			},
+		}},
+	})
+	if !errors.Is(err, ErrUnsupportedContent) {
+		t.Fatalf("expected ErrUnsupportedContent, got %v", err)
+	}
+}
+
+func TestAnthropicServerToolBlocks_CrossProvider(t *testing.T) {
+	body := `{"model":"claude-3","max_tokens":64,"messages":[
+		{"role":"user","content":"what changed?"},
+		{"role":"assistant","content":[
+			{"type":"server_tool_use","id":"srvtoolu_1","name":"web_search","input":{"query":"go 1.21"}},
+			{"type":"web_search_tool_result","tool_use_id":"srvtoolu_1","content":[{"type":"web_search_result","title":"Go 1.21","url":"https://go.dev/doc/go1.21","encrypted_content":"abc"}]},
+			{"type":"web_search_tool_result","tool_use_id":"srvtoolu_2","content":{"type":"web_search_tool_result_error","error_code":"max_uses_exceeded"}},
+			{"type":"web_fetch_tool_result","tool_use_id":"srvtoolu_3","content":{"type":"web_fetch_result","url":"https://go.dev","content":{"type":"document","source":{"type":"text","media_type":"text/plain","data":"Build simple software"}}}},
+			{"type":"code_execution_tool_result","tool_use_id":"srvtoolu_4","content":{"type":"code_execution_result","stdout":"42\n","stderr":"","return_code":0,"content":[{"type":"code_execution_output","file_id":"file_1"}]}},
+			{"type":"bash_code_execution_tool_result","tool_use_id":"srvtoolu_5","content":{"type":"bash_code_execution_result","stdout":"","stderr":"not found","return_code":127}},
+			{"type":"text_editor_code_execution_tool_result","tool_use_id":"srvtoolu_6","content":{"type":"text_editor_code_execution_view_result","content":"line 1"}},
+			{"type":"mcp_tool_use","id":"mcptoolu_1","name":"lookup","server_name":"docs","input":{"id":7}},
+			{"type":"mcp_tool_result","tool_use_id":"mcptoolu_1","is_error":true,"content":[{"type":"text","text":"no such id"}]},
+			{"type":"text","text":"Go 1.21 added min and max."}
+		]}
+	]}`
+	var req models.MessagesRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("decode request: %v", err)
+	}
+
+	// Anthropic upstreams receive the blocks untouched
+	encoded, err := json.Marshal(req.Messages[1].Content)
+	if err != nil {
+		t.Fatalf("encode content: %v", err)
+	}
+	var original struct {
+		Messages []struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	json.Unmarshal([]byte(body), &original)
+	var want, got interface{}
+	json.Unmarshal(original.Messages[1].Content, &want)
+	json.Unmarshal(encoded, &got)
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("anthropic passthrough changed blocks:\n%s", encoded)
+	}
+
+	wantTexts := []string{
+		`[web_search call: {"query":"go 1.21"}]`,
+		"[web_search result]\n- Go 1.21 (https://go.dev/doc/go1.21)",
+		"[web_search error: max_uses_exceeded]",
+		"[web_fetch result]\nhttps://go.dev\nBuild simple software",
+		"[code_execution result]\nreturn code: 0\nstdout:\n42\n\noutput file: file_1",
+		"[bash_code_execution result]\nreturn code: 127\nstderr:\nnot found",
+		`[text_editor_code_execution result]` + "\n" + `{"content":"line 1","type":"text_editor_code_execution_view_result"}`,
+		`[lookup call: {"id":7}]`,
+		"[mcp error]\nno such id",
+		"Go 1.21 added min and max.",
+	}
+
+	openaiReq, err := AnthropicToOpenAIRequest(&req)
+	if err != nil {
+		t.Fatalf("AnthropicToOpenAIRequest error: %v", err)
+	}
+	parts := mapSlice(openaiReq.Messages[1].Content)
+	if len(parts) != len(wantTexts) {
+		t.Fatalf("chat content parts mismatch: %#v", openaiReq.Messages[1].Content)
+	}
+	for i, text := range wantTexts {
+		if getString(parts[i], "text") != text {
+			t.Fatalf("chat part %d = %q, want %q", i, getString(parts[i], "text"), text)
+		}
+	}
+
+	responsesReq, err := AnthropicToOpenAIResponsesRequest(&req)
+	if err != nil {
+		t.Fatalf("AnthropicToOpenAIResponsesRequest error: %v", err)
+	}
+	input, _ := responsesReq["input"].([]map[string]interface{})
+	items, _ := input[1]["content"].([]map[string]interface{})
+	if len(items) != len(wantTexts) || getString(items[4], "text") != wantTexts[4] {
+		t.Fatalf("responses content mismatch: %#v", input[1]["content"])
+	}
+
+	geminiReq, err := AnthropicToGeminiRequest(&req)
+	if err != nil {
+		t.Fatalf("AnthropicToGeminiRequest error: %v", err)
+	}
+	geminiParts := geminiReq.Contents[1].Parts
+	if len(geminiParts) != len(wantTexts) || geminiParts[0].Text != wantTexts[0] || geminiParts[8].Text != wantTexts[8] {
+		t.Fatalf("gemini parts mismatch: %#v", geminiParts)
+	}
+
+	upload := &models.MessagesRequest{
+		Model:     "claude-3",
+		MaxTokens: 64,
+		Messages: []models.AnthropicMessage{{
+			Role: "user",
+			Content: []interface{}{
+				map[string]interface{}{"type": "container_upload", "file_id": "file_2"},
+			},
+		}},
+	}
+	if _, err := AnthropicToOpenAIRequest(upload); !errors.Is(err, ErrUnsupportedContent) {
+		t.Fatalf("expected ErrUnsupportedContent for chat, got %v", err)
+	}
+	if _, err := AnthropicToOpenAIResponsesRequest(upload); !errors.Is(err, ErrUnsupportedContent) {
+		t.Fatalf("expected ErrUnsupportedContent for responses, got %v", err)
+	}
+	if _, err := AnthropicToGeminiRequest(upload); !errors.Is(err, ErrUnsupportedContent) {
+		t.Fatalf("expected ErrUnsupportedContent for gemini, got %v", err)
+	}
+
+	resp := map[string]interface{}{
+		"id":   "msg_1",
+		"type": "message",
+		"content": []interface{}{
+			map[string]interface{}{"type": "server_tool_use", "id": "srvtoolu_1", "name": "web_search", "input": map[string]interface{}{"query": "go"}},
+			map[string]interface{}{"type": "web_search_tool_result", "tool_use_id": "srvtoolu_1", "content": []interface{}{
+				map[string]interface{}{"type": "web_search_result", "title": "Go", "url": "https://go.dev"},
+			}},
+			map[string]interface{}{"type": "text", "text": "Go is a language."},
+		},
+		"stop_reason": "end_turn",
+	}
+	openaiResp, err := AnthropicToOpenAIResponse(resp, "gpt")
+	if err != nil {
+		t.Fatalf("AnthropicToOpenAIResponse error: %v", err)
+	}
+	wantContent := "[web_search call: {\"query\":\"go\"}]\n[web_search result]\n- Go (https://go.dev)\nGo is a language."
+	if openaiResp.Choices[0].Message.Content != wantContent {
+		t.Fatalf("response content = %q", openaiResp.Choices[0].Message.Content)
+	}
+}
+
+func TestAudioContent_OpenAIAndGemini(t *testing.T) {
+	geminiReq, err := OpenAIToGeminiRequest(&models.ChatCompletionRequest{
+		Model: "gemini-pro",
+		Messages: []models.ChatMessage{{
+			Role: "user",
+			Content: []interface{}{
+				map[string]interface{}{"type": "text", "text": "transcribe"},
+				map[string]interface{}{
+					"type":        "input_audio",
+					"input_audio": map[string]interface{}{"data": "UklGRg==", "format": "wav"},
+				},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("OpenAIToGeminiRequest error: %v", err)
+	}
+	parts := geminiReq.Contents[0].Parts
+	if len(parts) != 2 || parts[1].InlineData == nil || parts[1].InlineData.MimeType != "audio/wav" {
+		t.Fatalf("audio part mismatch: %#v", parts)
+	}
+
+	openaiReq, err := GeminiToOpenAIRequest(geminiReq, "gpt-4o-audio-preview")
+	if err != nil {
+		t.Fatalf("GeminiToOpenAIRequest error: %v", err)
+	}
+	contentParts, ok := openaiReq.Messages[0].Content.([]models.ContentPart)
+	if !ok || len(contentParts) != 2 || contentParts[1].InputAudio == nil {
+		t.Fatalf("content parts mismatch: %#v", openaiReq.Messages[0].Content)
+	}
+	if contentParts[1].InputAudio.Format != "wav" || contentParts[1].InputAudio.Data != "UklGRg==" {
+		t.Fatalf("input audio mismatch: %#v", contentParts[1].InputAudio)
+	}
+
+	if _, err := OpenAIToAnthropicRequest(openaiReq); !errors.Is(err, ErrUnsupportedContent) {
+		t.Fatalf("expected ErrUnsupportedContent, got %v", err)
+	}
+}
+
+func TestJSONMode_GeminiAndAnthropic(t *testing.T) {
+	req := &models.ChatCompletionRequest{
+		Model:          "gemini-pro",
+		ResponseFormat: &models.ResponseFormat{Type: "json_object"},
+		Messages:       []models.ChatMessage{{Role: "user", Content: "list three colors"}},
+	}
+
+	geminiReq, err := OpenAIToGeminiRequest(req)
+	if err != nil {
+		t.Fatalf("OpenAIToGeminiRequest error: %v", err)
+	}
+	if geminiReq.GenerationConfig.ResponseMimeType != "application/json" {
+		t.Fatalf("responseMimeType mismatch: %q", geminiReq.GenerationConfig.ResponseMimeType)
+	}
+
+	anthropicReq, err := OpenAIToAnthropicRequest(req)
+	if err != nil {
+		t.Fatalf("OpenAIToAnthropicRequest error: %v", err)
+	}
+	if system, _ := anthropicReq.System.(string); system != jsonModeInstruction {
+		t.Fatalf("system prompt mismatch: %#v", anthropicReq.System)
+	}
+
+	var filter JSONStreamFilter
+	var out string
+	for _, delta := range []string{"Here is the JSON:\n```json\n{\"colors\": [\"re", "d}\", \"blue\"]", "}\n```\nDone."} {
+		out += filter.Filter(delta)
+	}
+	if out != `{"colors": ["red}", "blue"]}` {
+		t.Fatalf("filtered stream mismatch: %q", out)
+	}
+
+	if got := ExtractJSON("Sure! [1, 2] hope that helps"); got != "[1, 2]" {
+		t.Fatalf("ExtractJSON mismatch: %q", got)
+	}
+	if got := ExtractJSON("no json here"); got != "no json here" {
+		t.Fatalf("ExtractJSON should keep non-JSON text: %q", got)
+	}
+}
+
+func TestGeminiStreamToAnthropicStream_TextAndToolCalls(t *testing.T) {
+	state := NewGeminiToAnthropicStreamState("gemini-pro")
+	chunks := []map[string]interface{}{
+		{"candidates": []interface{}{map[string]interface{}{"content": map[string]interface{}{"parts": []interface{}{
+			map[string]interface{}{"text": "Let me check."},
+		}}}}},
+		{"candidates": []interface{}{map[string]interface{}{"content": map[string]interface{}{"parts": []interface{}{
+			map[string]interface{}{"functionCall": map[string]interface{}{"name": "weather", "args": map[string]interface{}{"city": "Paris"}}},
+			map[string]interface{}{"functionCall": map[string]interface{}{"name": "time", "args": map[string]interface{}{"tz": "CET"}}},
+		}}}}},
+		{
+			"candidates":    []interface{}{map[string]interface{}{"finishReason": "STOP"}},
+			"usageMetadata": map[string]interface{}{"promptTokenCount": 10.0, "candidatesTokenCount": 7.0},
+		},
+	}
+
+	var events [][]byte
+	for _, chunk := range chunks {
+		chunkEvents, err := GeminiStreamToAnthropicStream(chunk, state)
+		if err != nil {
+			t.Fatalf("GeminiStreamToAnthropicStream error: %v", err)
+		}
+		events = append(events, chunkEvents...)
+	}
+	if extra := state.Close(); extra != nil {
+		t.Fatalf("Close after finish should be empty: %d events", len(extra))
+	}
+
+	blocks, stopReason := anthropicStreamBlocks(t, events)
+	if strings.Join(blocks, ",") != "text,tool_use,tool_use" {
+		t.Fatalf("blocks mismatch: %v", blocks)
+	}
+	if stopReason != "tool_use" {
+		t.Fatalf("stop reason mismatch: %s", stopReason)
+	}
+}
+
+func TestOpenAIResponsesStreamToAnthropicStream_MultipleBlocks(t *testing.T) {
+	state := NewOpenAIResponsesToAnthropicStreamState()
+	chunks := []map[string]interface{}{
+		{"type": "response.created", "response": map[string]interface{}{"id": "resp_1", "model": "gpt-5"}},
+		{"type": "response.output_item.added", "output_index": 0.0, "item": map[string]interface{}{"type": "reasoning"}},
+		{"type": "response.output_item.done", "output_index": 0.0},
+		{"type": "response.output_item.added", "output_index": 1.0, "item": map[string]interface{}{"type": "message"}},
+		{"type": "response.content_part.added", "output_index": 1.0, "content_index": 0.0, "part": map[string]interface{}{"type": "output_text"}},
+		{"type": "response.output_text.delta", "output_index": 1.0, "content_index": 0.0, "delta": "Checking"},
+		{"type": "response.content_part.done", "output_index": 1.0, "content_index": 0.0},
+		{"type": "response.output_item.done", "output_index": 1.0},
+		{"type": "response.output_item.added", "output_index": 2.0, "item": map[string]interface{}{"type": "function_call", "call_id": "call_1", "name": "weather"}},
+		{"type": "response.function_call_arguments.delta", "output_index": 2.0, "delta": `{"city":"Paris"}`},
+		{"type": "response.output_item.done", "output_index": 2.0},
+		{"type": "response.output_item.added", "output_index": 3.0, "item": map[string]interface{}{"type": "function_call", "call_id": "call_2", "name": "time"}},
+		{"type": "response.function_call_arguments.delta", "output_index": 3.0, "delta": `{}`},
+	}
+
+	var events [][]byte
+	for _, chunk := range chunks {
+		chunkEvents, err := OpenAIResponsesStreamToAnthropicStream(responsesEvent(t, chunk), state)
+		if err != nil {
+			t.Fatalf("OpenAIResponsesStreamToAnthropicStream error: %v", err)
+		}
+		events = append(events, chunkEvents...)
+	}
+	// The stream is cut off before response.completed
+	events = append(events, state.Close()...)
+
+	blocks, stopReason := anthropicStreamBlocks(t, events)
+	if strings.Join(blocks, ",") != "text,tool_use,tool_use" {
+		t.Fatalf("blocks mismatch: %v", blocks)
+	}
+	if stopReason != "tool_use" {
+		t.Fatalf("stop reason mismatch: %s", stopReason)
+	}
+}
+
+// benchmarkResponsesStream is a Responses text stream of 100 deltas
+var benchmarkResponsesStream = append([]string{
+	`{"type":"response.created","response":{"id":"resp_1","model":"gpt-5","status":"in_progress"}}`,
+	`{"type":"response.output_item.added","output_index":0,"item":{"type":"message","role":"assistant","content":[]}}`,
+	`{"type":"response.content_part.added","output_index":0,"content_index":0,"part":{"type":"output_text","text":""}}`,
+}, repeatString(`{"type":"response.output_text.delta","item_id":"msg_1","output_index":0,"content_index":0,"delta":"Hello there, world"}`, 100)...)
+
+func TestUnsupportedFields_StrictConversion(t *testing.T) {
+	topK, seed, n := 40, 7, 1
+	penalty := 0.5
+	chatReq := &models.ChatCompletionRequest{
+		Model:           "gpt-4o",
+		TopK:            &topK,
+		N:               &n,
+		Seed:            &seed,
+		PresencePenalty: &penalty,
+		LogitBias:       map[string]float64{"50256": -100},
+		ToolChoice:      "auto",
+	}
+	for protocol, want := range map[string][]string{
+		"openai_chat": nil,
+		"openai_code": {"top_k", "presence_penalty", "logit_bias"},
+		"anthropic":   {"presence_penalty", "logit_bias", "seed"},
+		"gemini":      {"top_k", "logit_bias", "seed", "tool_choice"},
+	} {
+		if got := OpenAIChatUnsupportedFields(chatReq, protocol); !reflect.DeepEqual(got, want) {
+			t.Fatalf("chat fields for %s = %v, want %v", protocol, got, want)
+		}
+	}
+
+	body := map[string]interface{}{"model": "gpt-4o", "input": "hi", "previous_response_id": "resp_1", "reasoning": map[string]interface{}{"effort": "high"}}
+	converted, err := OpenAIResponsesToOpenAIChatRequest(body)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if got := OpenAIResponsesUnsupportedFields(body, converted, "anthropic"); !reflect.DeepEqual(got, []string{"previous_response_id", "reasoning.effort"}) {
+		t.Fatalf("responses fields = %v", got)
+	}
+	if got := OpenAIResponsesUnsupportedFields(body, converted, "openai_code"); got != nil {
+		t.Fatalf("responses passthrough reported %v", got)
+	}
+
+	anthropicReq := &models.MessagesRequest{Model: "claude", MaxTokens: 10, TopK: &topK, StopSequences: []string{"END"}}
+	if got := AnthropicUnsupportedFields(anthropicReq, "openai_code"); !reflect.DeepEqual(got, []string{"top_k", "stop_sequences"}) {
+		t.Fatalf("anthropic fields = %v", got)
+	}
+	if got := AnthropicUnsupportedFields(anthropicReq, "gemini"); got != nil {
+		t.Fatalf("anthropic to gemini reported %v", got)
+	}
+
+	geminiReq := &models.GenerateContentRequest{
+		GenerationConfig: &models.GenerationConfig{TopK: &topK},
+		SafetySettings:   []models.SafetySetting{{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_NONE"}},
+	}
+	if got := GeminiUnsupportedFields(geminiReq, "anthropic"); !reflect.DeepEqual(got, []string{"safetySettings"}) {
+		t.Fatalf("gemini fields = %v", got)
+	}
+
+	err = UnsupportedFieldsError("openai_code", []string{"top_k"})
+	if !errors.Is(err, ErrUnsupportedFields) || !strings.Contains(err.Error(), "top_k") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if UnsupportedFieldsError("gemini", nil) != nil {
+		t.Fatal("no fields should not be an error")
+	}
+}
+
+func TestLogitBias_PassthroughAndStrict(t *testing.T) {
+	body := map[string]interface{}{"model": "gpt-4o", "input": "hi", "logit_bias": map[string]interface{}{"50256": -100.0}}
+	chatReq, err := OpenAIResponsesToOpenAIChatRequest(body)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if !reflect.DeepEqual(chatReq.LogitBias, map[string]float64{"50256": -100}) {
+		t.Fatalf("logit_bias not passed through: %v", chatReq.LogitBias)
+	}
+	if got := OpenAIResponsesUnsupportedFields(body, chatReq, "openai_chat"); got != nil {
+		t.Fatalf("chat upstream reported %v", got)
+	}
+	for _, protocol := range []string{"anthropic", "gemini"} {
+		if SupportsLogitBias(protocol) || !containsString(OpenAIChatUnsupportedFields(chatReq, protocol), "logit_bias") {
+			t.Fatalf("logit_bias should be unsupported for %s", protocol)
+		}
+	}
+}
+
+func TestPenalties_OpenAIAndGemini(t *testing.T) {
+	presence, frequency := 0.4, -0.2
+	geminiReq, err := OpenAIToGeminiRequest(&models.ChatCompletionRequest{
+		Model:            "gemini-2.5-pro",
+		Messages:         []models.ChatMessage{{Role: "user", Content: "hi"}},
+		PresencePenalty:  &presence,
+		FrequencyPenalty: &frequency,
+	})
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	config := geminiReq.GenerationConfig
+	if config.PresencePenalty == nil || *config.PresencePenalty != presence || config.FrequencyPenalty == nil || *config.FrequencyPenalty != frequency {
+		t.Fatalf("penalties not mapped to Gemini: %+v", config)
+	}
+
+	var inbound models.GenerateContentRequest
+	if err := json.Unmarshal([]byte(`{"contents":[{"role":"user","parts":[{"text":"hi"}]}],"generationConfig":{"presencePenalty":0.4,"frequencyPenalty":-0.2}}`), &inbound); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	chatReq, err := GeminiToOpenAIRequest(&inbound, "gpt-4o")
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if chatReq.PresencePenalty == nil || *chatReq.PresencePenalty != presence || chatReq.FrequencyPenalty == nil || *chatReq.FrequencyPenalty != frequency {
+		t.Fatalf("penalties not mapped to OpenAI: %+v", chatReq)
+	}
+	if got := GeminiUnsupportedFields(&inbound, "openai_chat"); got != nil {
+		t.Fatalf("chat upstream reported %v", got)
+	}
+	if got := GeminiUnsupportedFields(&inbound, "anthropic"); !reflect.DeepEqual(got, []string{"generationConfig.presencePenalty", "generationConfig.frequencyPenalty"}) {
+		t.Fatalf("anthropic upstream reported %v", got)
+	}
+}
+
+func TestCandidates_OpenAIAndGemini(t *testing.T) {
+	n := 2
+	geminiReq, err := OpenAIToGeminiRequest(&models.ChatCompletionRequest{
+		Model:    "gemini-2.5-pro",
+		Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+		N:        &n,
+	})
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if count := geminiReq.GenerationConfig.CandidateCount; count == nil || *count != 2 {
+		t.Fatalf("n not mapped to candidateCount: %v", count)
+	}
+	chatReq, err := GeminiToOpenAIRequest(geminiReq, "gpt-4o")
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if chatReq.N == nil || *chatReq.N != 2 {
+		t.Fatalf("candidateCount not mapped to n: %v", chatReq.N)
+	}
+
+	var geminiResp map[string]interface{}
+	json.Unmarshal([]byte(`{"candidates":[
+		{"content":{"role":"model","parts":[{"text":"first"}]},"finishReason":"STOP"},
+		{"content":{"role":"model","parts":[{"text":"second"}]},"finishReason":"MAX_TOKENS","index":1}
+	]}`), &geminiResp)
+	chatResp, err := GeminiToOpenAIResponse(geminiResp, "gemini-2.5-pro")
+	if err != nil {
+		t.Fatalf("convert response: %v", err)
+	}
+	if len(chatResp.Choices) != 2 || chatResp.Choices[1].Index != 1 || chatResp.Choices[1].Message.Content != "second" || *chatResp.Choices[1].FinishReason != "length" {
+		t.Fatalf("candidates not converted to choices: %+v", chatResp.Choices)
+	}
+
+	chunk, err := GeminiStreamToOpenAIStream(geminiResp, "gemini-2.5-pro", "chatcmpl-1")
+	if err != nil {
+		t.Fatalf("convert chunk: %v", err)
+	}
+	var streamed models.ChatCompletionChunk
+	json.Unmarshal(chunk, &streamed)
+	if len(streamed.Choices) != 2 || streamed.Choices[1].Index != 1 || streamed.Choices[1].Delta.Content != "second" {
+		t.Fatalf("stream candidates not converted to choices: %s", chunk)
+	}
+
+	respMap, _ := ChatCompletionResponseToMap(chatResp)
+	back, err := OpenAIToGeminiResponse(respMap)
+	if err != nil {
+		t.Fatalf("convert back: %v", err)
+	}
+	if len(back.Candidates) != 2 || back.Candidates[1].Index != 1 || back.Candidates[1].Content.Parts[0].Text != "second" || back.Candidates[1].FinishReason != "MAX_TOKENS" {
+		t.Fatalf("choices not converted to candidates: %+v", back.Candidates)
+	}
+
+	geminiChunk, err := OpenAIStreamToGeminiStream(map[string]interface{}{"choices": []interface{}{
+		map[string]interface{}{"index": 0.0, "delta": map[string]interface{}{"content": "a"}},
+		map[string]interface{}{"index": 1.0, "delta": map[string]interface{}{"content": "b"}},
+	}})
+	if err != nil {
+		t.Fatalf("convert stream chunk: %v", err)
+	}
+	var streamedGemini models.GenerateContentResponse
+	json.Unmarshal(geminiChunk, &streamedGemini)
+	if len(streamedGemini.Candidates) != 2 || streamedGemini.Candidates[1].Index != 1 || streamedGemini.Candidates[1].Content.Parts[0].Text != "b" {
+		t.Fatalf("stream choices not converted to candidates: %s", geminiChunk)
+	}
+}
+
+func TestSanitizeGeminiTools(t *testing.T) {
+	var params map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type": "object",
+		"additionalProperties": false,
+		"$defs": {"unit": {"type": "string", "enum": ["c", "f"]}},
+		"properties": {
+			"unit": {"$ref": "#/$defs/unit"},
+			"city": {"type": ["string", "null"]},
+			"when": {"oneOf": [{"type": "string"}, {"type": "integer"}]},
+			"mode": {"const": "fast"}
+		},
+		"allOf": [{"properties": {"days": {"type": "integer"}}, "required": ["days"]}]
+	}`), &params)
+	original, _ := json.Marshal(params)
+
+	tools := []models.GeminiTool{{FunctionDeclarations: []models.FunctionDeclaration{
+		{Name: "weather", Description: strings.Repeat("a", 1500), Parameters: params},
+		{Name: "plain", Description: "ok", Parameters: map[string]interface{}{"type": "object"}},
+	}}}
+	warnings := SanitizeGeminiTools(tools)
+
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "weather: description truncated from 1500 to 1024 characters; converted $ref, allOf, const, oneOf, type arrays; removed $defs, $schema, additionalProperties") {
+		t.Fatalf("unexpected warnings: %q", warnings)
+	}
+	decl := tools[0].FunctionDeclarations[0]
+	if len(decl.Description) != 1024 {
+		t.Fatalf("description not truncated: %d", len(decl.Description))
+	}
+	got, _ := json.Marshal(decl.Parameters)
+	want := `{"properties":{"city":{"nullable":true,"type":"string"},"days":{"type":"integer"},` +
+		`"mode":{"enum":["fast"]},"unit":{"enum":["c","f"],"type":"string"},"when":{"anyOf":[{"type":"string"},{"type":"integer"}]}},` +
+		`"required":["days"],"type":"object"}`
+	if string(got) != want {
+		t.Fatalf("unexpected schema:\n got %s\nwant %s", got, want)
+	}
+	if after, _ := json.Marshal(params); string(after) != string(original) {
+		t.Fatalf("client schema modified: %s", after)
+	}
+
+	chatTools := []models.Tool{{Type: "function", Function: models.Function{Name: "long", Description: strings.Repeat("b", 2000)}}}
+	if warnings := SanitizeOpenAIChatTools(chatTools); len(warnings) != 1 || len(chatTools[0].Function.Description) != 1024 {
+		t.Fatalf("chat description not truncated: %q", warnings)
+	}
+}
+
+func TestTranslateToolSchema(t *testing.T) {
+	schemaJSON := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	var geminiParams, anthropicParams map[string]interface{}
+	json.Unmarshal([]byte(`{"type":"OBJECT","propertyOrdering":["unit"],
+		"properties":{"unit":{"type":"STRING","format":"enum","enum":["c","f"],"nullable":true}}}`), &geminiParams)
+	json.Unmarshal([]byte(`{"type":"object","required":["a"],
+		"properties":{"a":{"type":"string"},"b":{"type":"integer"},"c":{"type":"string","enum":["x"]}}}`), &anthropicParams)
+
+	anthropicReq, err := GeminiToAnthropicRequest(&models.GenerateContentRequest{
+		Contents: []models.GeminiContent{{Role: "user", Parts: []models.GeminiPart{{Text: "hi"}}}},
+		Tools:    []models.GeminiTool{{FunctionDeclarations: []models.FunctionDeclaration{{Name: "convert", Parameters: geminiParams}}}},
+	}, "claude-sonnet-4")
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if got := schemaJSON(anthropicReq.Tools[0].InputSchema); got != `{"properties":{"unit":{"enum":["c","f",null],"type":["string","null"]}},"type":"object"}` {
+		t.Fatalf("gemini schema not translated: %s", got)
+	}
+
+	strict := true
+	openaiReq, err := AnthropicToOpenAIRequest(&models.MessagesRequest{
+		Model:     "gpt-4o",
+		MaxTokens: 100,
+		Messages:  []models.AnthropicMessage{{Role: "user", Content: "hi"}},
+		Tools:     []models.AnthropicTool{{Name: "pick", InputSchema: anthropicParams, Strict: &strict}},
+	})
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	fn := openaiReq.Tools[0].Function
+	want := `{"additionalProperties":false,"properties":{"a":{"type":"string"},"b":{"type":["integer","null"]},` +
+		`"c":{"enum":["x",null],"type":["string","null"]}},"required":["a","b","c"],"type":"object"}`
+	if fn.Strict == nil || !*fn.Strict || schemaJSON(fn.Parameters) != want {
+		t.Fatalf("strict schema not translated: strict=%v %s", fn.Strict, schemaJSON(fn.Parameters))
+	}
+	if got := schemaJSON(anthropicParams); strings.Contains(got, "additionalProperties") {
+		t.Fatalf("client schema modified: %s", got)
+	}
+
+	backReq, err := OpenAIToAnthropicRequest(&models.ChatCompletionRequest{
+		Model:    "claude-sonnet-4",
+		Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+		Tools:    []models.Tool{{Type: "function", Function: models.Function{Name: "ping"}}},
+	})
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if got := schemaJSON(backReq.Tools[0].InputSchema); got != `{"properties":{},"type":"object"}` {
+		t.Fatalf("missing parameters not given an object schema: %s", got)
+	}
+
+	geminiReq, err := AnthropicToGeminiRequest(&models.MessagesRequest{
+		Model:     "gemini-2.5-pro",
+		MaxTokens: 100,
+		Messages:  []models.AnthropicMessage{{Role: "user", Content: "hi"}},
+		Tools:     []models.AnthropicTool{{Name: "ping", InputSchema: map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}}},
+	})
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if params := geminiReq.Tools[0].FunctionDeclarations[0].Parameters; params != nil {
+		t.Fatalf("empty object parameters sent to gemini: %v", params)
+	}
+
+	chatReq, err := OpenAIResponsesToOpenAIChatRequest(map[string]interface{}{
+		"model": "gpt-4o",
+		"input": "hi",
+		"tools": []interface{}{map[string]interface{}{"type": "function", "name": "pick", "parameters": anthropicParams, "strict": true}},
+	})
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if s := chatReq.Tools[0].Function.Strict; s == nil || !*s {
+		t.Fatalf("strict not carried to chat: %v", s)
+	}
+}
+
+func BenchmarkOpenAIResponsesStreamToOpenAIChatStream(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		state := NewOpenAIResponsesToChatStreamState("gpt-5")
+		for _, line := range benchmarkResponsesStream {
+			var event models.ResponsesStreamEvent
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				b.Fatal(err)
+			}
+			OpenAIResponsesStreamToOpenAIChatStream(&event, state)
+		}
+	}
+}
+
+func BenchmarkOpenAIChatStreamToOpenAIResponsesStream(b *testing.B) {
+	b.ReportAllocs()
+	line := []byte(`{"id":"chatcmpl-1","object":"chat.completion.chunk","model":"gpt-4","choices":[{"index":0,"delta":{"content":"Hello there, world"}}]}`)
+	for i := 0; i < b.N; i++ {
+		state := NewOpenAIChatToResponsesStreamState("gpt-4")
+		for j := 0; j < 100; j++ {
+			var chunk models.ChatCompletionChunk
+			if err := json.Unmarshal(line, &chunk); err != nil {
+				b.Fatal(err)
+			}
+			OpenAIChatStreamToOpenAIResponsesStream(&chunk, state)
+		}
+	}
+}
+
 func mapSlice(value interface{}) []map[string]interface{} {
 	switch v := value.(type) {
 	case []map[string]interface{}:
@@ -859,6 +1972,64 @@ func eventTypes(t *testing.T, events [][]byte) []string {
 	return types
 }
 
+// responsesEvent decodes a Responses stream event given as a map
+func responsesEvent(t *testing.T, data map[string]interface{}) *models.ResponsesStreamEvent {
+	t.Helper()
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("marshal event: %v", err)
+	}
+	var event models.ResponsesStreamEvent
+	if err := json.Unmarshal(raw, &event); err != nil {
+		t.Fatalf("unmarshal event: %v", err)
+	}
+	return &event
+}
+
+// anthropicStreamBlocks checks that an Anthropic event stream is well formed,
+// with sequential block indexes and every block stopped, and returns the block
+// types and stop reason
+func anthropicStreamBlocks(t *testing.T, events [][]byte) ([]string, string) {
+	t.Helper()
+	var blocks []string
+	var stopReason string
+	open := -1
+	for i, event := range events {
+		var eventMap map[string]interface{}
+		if err := json.Unmarshal(event, &eventMap); err != nil {
+			t.Fatalf("unmarshal event: %v", err)
+		}
+		index := getInt(eventMap, "index")
+		switch eventMap["type"] {
+		case "content_block_start":
+			if open >= 0 || index != len(blocks) {
+				t.Fatalf("event %d starts block %d while block %d is open, %d started", i, index, open, len(blocks))
+			}
+			open = index
+			blocks = append(blocks, getString(mapValue(eventMap, "content_block"), "type"))
+		case "content_block_delta":
+			if index != open {
+				t.Fatalf("event %d is a delta for block %d, open block is %d", i, index, open)
+			}
+		case "content_block_stop":
+			if index != open {
+				t.Fatalf("event %d stops block %d, open block is %d", i, index, open)
+			}
+			open = -1
+		case "message_delta":
+			stopReason = getString(mapValue(eventMap, "delta"), "stop_reason")
+		}
+	}
+	if open >= 0 {
+		t.Fatalf("block %d was never stopped", open)
+	}
+	var last map[string]interface{}
+	if err := json.Unmarshal(events[len(events)-1], &last); err != nil || last["type"] != "message_stop" {
+		t.Fatalf("stream does not end with message_stop: %s", events[len(events)-1])
+	}
+	return blocks, stopReason
+}
+
 func containsString(values []string, target string) bool {
 	for _, value := range values {
 		if value == target {
@@ -867,3 +2038,184 @@ func containsString(values []string, target string) bool {
 	}
 	return false
 }
+
+func repeatString(value string, n int) []string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = value
+	}
+	return values
+}
+
+// encodedImage returns a w by h image, noisy when noise is set, as base64
+func encodedImage(t *testing.T, format string, w, h int, noise bool) string {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255}
+			if noise {
+				c = color.RGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("encode %s: %v", format, err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestFitInlineImages_AnthropicLimits(t *testing.T) {
+	oversized := encodedImage(t, "png", 1500, 1500, true)
+	if len(oversized) <= 5<<20 {
+		t.Fatalf("test image is only %d base64 bytes", len(oversized))
+	}
+	source := func(data string) map[string]interface{} {
+		return map[string]interface{}{"type": "base64", "media_type": "image/png", "data": data}
+	}
+	content := func(src map[string]interface{}) []interface{} {
+		return []interface{}{
+			map[string]interface{}{"type": "text", "text": "describe"},
+			map[string]interface{}{"type": "image", "source": src},
+		}
+	}
+
+	if _, err := FitInlineImages([]interface{}{content(source(oversized))}, "anthropic", ImageOptions{}); !errors.Is(err, ErrInvalidImage) {
+		t.Fatalf("expected oversized image to be rejected without transcoding, got %v", err)
+	}
+
+	src := source(oversized)
+	notes, err := FitInlineImages([]interface{}{content(src)}, "anthropic", ImageOptions{Transcode: true})
+	if err != nil {
+		t.Fatalf("transcode failed: %v", err)
+	}
+	if src["media_type"] != "image/jpeg" || len(getString(src, "data")) > 5<<20 {
+		t.Fatalf("expected a JPEG within 5 MB, got %v of %d bytes", src["media_type"], len(getString(src, "data")))
+	}
+	if len(notes) != 1 || !strings.HasPrefix(notes[0], "image 1: transcoded image/png 1500x1500") {
+		t.Fatalf("unexpected notes %q", notes)
+	}
+
+	wide := source(encodedImage(t, "png", 9000, 4, false))
+	if _, err := FitInlineImages([]interface{}{content(wide)}, "anthropic", ImageOptions{Transcode: true}); err != nil {
+		t.Fatalf("transcode failed: %v", err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(getString(wide, "data"))
+	config, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil || config.Width != 8000 {
+		t.Fatalf("expected the image to be downscaled to 8000 pixels wide, got %d (%v)", config.Width, err)
+	}
+}
+
+func TestFitInlineImages_DataURIs(t *testing.T) {
+	jpegData := encodedImage(t, "jpeg", 8, 8, false)
+	part := map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:image/png;base64," + jpegData}}
+	notes, err := FitInlineImages([]interface{}{[]interface{}{part}}, "openai_chat", ImageOptions{})
+	if err != nil {
+		t.Fatalf("fit failed: %v", err)
+	}
+	if url := getString(mapValue(part, "image_url"), "url"); url != "data:image/jpeg;base64,"+jpegData {
+		t.Fatalf("expected the MIME type to be corrected, got %.40s", url)
+	}
+	if len(notes) != 1 || notes[0] != "image 1: declared image/png but is image/jpeg" {
+		t.Fatalf("unexpected notes %q", notes)
+	}
+
+	input := []interface{}{map[string]interface{}{"role": "user", "content": []interface{}{
+		map[string]interface{}{"type": "input_image", "image_url": "data:image/jpeg;base64,not base64!"},
+	}}}
+	if _, err := FitInlineImages([]interface{}{input}, "openai_code", ImageOptions{}); !errors.Is(err, ErrInvalidImage) {
+		t.Fatalf("expected invalid base64 to be rejected, got %v", err)
+	}
+	if _, err := FitInlineImages([]interface{}{[]interface{}{part}}, "openai_chat", ImageOptions{MaxBytes: 16}); !errors.Is(err, ErrInvalidImage) {
+		t.Fatalf("expected an image over the gateway cap to be rejected, got %v", err)
+	}
+}
+
+func TestFitGeminiInlineImages_TranscodesUnsupportedFormat(t *testing.T) {
+	req := &models.GenerateContentRequest{Contents: []models.GeminiContent{{
+		Role: "user",
+		Parts: []models.GeminiPart{
+			{Text: "describe"},
+			{InlineData: &models.InlineData{MimeType: "image/gif", Data: encodedImage(t, "gif", 16, 16, false)}},
+		},
+	}}}
+	if _, err := FitGeminiInlineImages(req, "gemini", ImageOptions{}); !errors.Is(err, ErrInvalidImage) {
+		t.Fatalf("expected GIF to be rejected for Gemini without transcoding, got %v", err)
+	}
+	if _, err := FitGeminiInlineImages(req, "gemini", ImageOptions{Transcode: true}); err != nil {
+		t.Fatalf("transcode failed: %v", err)
+	}
+	if got := req.Contents[0].Parts[1].InlineData.MimeType; got != "image/jpeg" {
+		t.Fatalf("expected GIF to be transcoded to JPEG, got %s", got)
+	}
+}
+
+func TestInlineImageURLs(t *testing.T) {
+	var fetched []string
+	fetch := func(rawURL string) (string, []byte, error) {
+		fetched = append(fetched, rawURL)
+		if strings.Contains(rawURL, "missing") {
+			return "", nil, errors.New("status 404")
+		}
+		return "image/png", []byte("png"), nil
+	}
+
+	chatPart := map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/a.png"}}
+	chatString := map[string]interface{}{"type": "image_url", "image_url": "https://example.com/b.png"}
+	plainHTTP := map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "http://example.com/c.png"}}
+	inputImage := map[string]interface{}{"type": "input_image", "image_url": "https://example.com/d.png"}
+	anthropicImage := map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "url", "url": "https://example.com/e.png"}}
+	document := map[string]interface{}{"type": "document", "source": map[string]interface{}{"type": "url", "url": "https://example.com/f.pdf"}}
+	values := []interface{}{
+		"plain text",
+		[]interface{}{chatPart, chatString, plainHTTP},
+		[]interface{}{map[string]interface{}{"role": "user", "content": []interface{}{inputImage}}},
+		[]interface{}{map[string]interface{}{"type": "tool_result", "content": []interface{}{anthropicImage}}, document},
+	}
+
+	inlined, err := InlineImageURLs(values, fetch)
+	if err != nil {
+		t.Fatalf("inline failed: %v", err)
+	}
+	want := dataURI("image/png", base64.StdEncoding.EncodeToString([]byte("png")))
+	if inlined != 4 || len(fetched) != 4 {
+		t.Fatalf("expected 4 images inlined, got %d (fetched %q)", inlined, fetched)
+	}
+	if getString(mapValue(chatPart, "image_url"), "url") != want || chatString["image_url"] != want || inputImage["image_url"] != want {
+		t.Fatalf("image URLs not replaced: %v %v %v", chatPart, chatString, inputImage)
+	}
+	if source := mapValue(anthropicImage, "source"); getString(source, "type") != "base64" || getString(source, "media_type") != "image/png" {
+		t.Fatalf("Anthropic image source not inlined: %v", source)
+	}
+	if getString(mapValue(plainHTTP, "image_url"), "url") != "http://example.com/c.png" || getString(mapValue(document, "source"), "type") != "url" {
+		t.Fatal("plain http images and documents should be left alone")
+	}
+
+	missing := []interface{}{[]interface{}{map[string]interface{}{"type": "input_image", "image_url": "https://example.com/missing.png"}}}
+	if _, err := InlineImageURLs(missing, fetch); err == nil {
+		t.Fatal("expected a failed download to be returned")
+	}
+
+	req := &models.GenerateContentRequest{Contents: []models.GeminiContent{{Role: "user", Parts: []models.GeminiPart{
+		{FileData: &models.FileData{MimeType: "image/jpeg", FileURI: "https://example.com/g.jpg"}},
+		{FileData: &models.FileData{MimeType: "application/pdf", FileURI: "https://example.com/h.pdf"}},
+	}}}}
+	if inlined, err := InlineGeminiImageURLs(req, fetch); err != nil || inlined != 1 {
+		t.Fatalf("expected one Gemini image inlined, got %d (%v)", inlined, err)
+	}
+	if parts := req.Contents[0].Parts; parts[0].InlineData == nil || parts[0].FileData != nil || parts[1].FileData == nil {
+		t.Fatalf("unexpected Gemini parts %+v", parts)
+	}
+}
@@ -0,0 +1,268 @@
+package converters
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+
+	"ai_gateway/internal/models"
+)
+
+// ErrUnsupportedContent is returned when a request holds content that the
+// target protocol cannot carry, such as a document URL sent to OpenAI
+var ErrUnsupportedContent = errors.New("unsupported content")
+
+// parseDataURI splits a base64 data URI into its MIME type and data
+func parseDataURI(uri string) (mimeType, data string, ok bool) {
+	if !strings.HasPrefix(uri, "data:") {
+		return "", "", false
+	}
+	meta, data, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok || !strings.HasSuffix(meta, ";base64") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(meta, ";base64"), data, true
+}
+
+// dataURI builds a base64 data URI
+func dataURI(mimeType, data string) string {
+	return "data:" + mimeType + ";base64," + data
+}
+
+// mimeTypeFromURL guesses the MIME type of a URL from its file extension
+func mimeTypeFromURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return mime.TypeByExtension(strings.ToLower(path.Ext(parsed.Path)))
+}
+
+// documentFilename names a document for protocols that require a filename
+func documentFilename(title, mimeType string) string {
+	if title != "" {
+		return title
+	}
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+		return "document" + exts[0]
+	}
+	return "document"
+}
+
+// anthropicDocumentToOpenAIPart converts an Anthropic document block into an
+// OpenAI chat content part
+func anthropicDocumentToOpenAIPart(block normalizedAnthropicBlock) (map[string]interface{}, error) {
+	switch getString(block.Source, "type") {
+	case "base64":
+		mediaType := getString(block.Source, "media_type")
+		return map[string]interface{}{
+			"type": "file",
+			"file": map[string]interface{}{
+				"filename":  documentFilename(block.Title, mediaType),
+				"file_data": dataURI(mediaType, getString(block.Source, "data")),
+			},
+		}, nil
+	case "text":
+		return map[string]interface{}{
+			"type": "text",
+			"text": getString(block.Source, "data"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: documents with a %q source cannot be sent to OpenAI chat completions; send them as base64",
+			ErrUnsupportedContent, getString(block.Source, "type"))
+	}
+}
+
+// anthropicDocumentToResponsesPart converts an Anthropic document block into an
+// OpenAI Responses input part
+func anthropicDocumentToResponsesPart(block normalizedAnthropicBlock) (map[string]interface{}, error) {
+	switch getString(block.Source, "type") {
+	case "base64":
+		mediaType := getString(block.Source, "media_type")
+		return map[string]interface{}{
+			"type":      "input_file",
+			"filename":  documentFilename(block.Title, mediaType),
+			"file_data": dataURI(mediaType, getString(block.Source, "data")),
+		}, nil
+	case "url":
+		return map[string]interface{}{
+			"type":     "input_file",
+			"file_url": getString(block.Source, "url"),
+		}, nil
+	case "text":
+		return map[string]interface{}{
+			"type": "input_text",
+			"text": getString(block.Source, "data"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: documents with a %q source cannot be sent to the OpenAI Responses API",
+			ErrUnsupportedContent, getString(block.Source, "type"))
+	}
+}
+
+// anthropicDocumentToGeminiPart converts the source of an Anthropic document
+// block into a Gemini part
+func anthropicDocumentToGeminiPart(source map[string]interface{}) (models.GeminiPart, error) {
+	switch getString(source, "type") {
+	case "base64":
+		return models.GeminiPart{InlineData: &models.InlineData{
+			MimeType: getString(source, "media_type"),
+			Data:     getString(source, "data"),
+		}}, nil
+	case "url":
+		documentURL := getString(source, "url")
+		mimeType := mimeTypeFromURL(documentURL)
+		if mimeType == "" {
+			mimeType = "application/pdf" // URL documents are always PDFs in the Messages API
+		}
+		return models.GeminiPart{FileData: &models.FileData{MimeType: mimeType, FileURI: documentURL}}, nil
+	case "text":
+		return models.GeminiPart{Text: getString(source, "data")}, nil
+	default:
+		return models.GeminiPart{}, fmt.Errorf("%w: documents with a %q source cannot be sent to Gemini",
+			ErrUnsupportedContent, getString(source, "type"))
+	}
+}
+
+// openAIFileToAnthropicBlock converts an OpenAI file content part into an
+// Anthropic document block. Only inline PDFs and plain text are supported.
+func openAIFileToAnthropicBlock(file models.FilePart) (models.ContentBlock, error) {
+	if file.FileData == "" {
+		return models.ContentBlock{}, fmt.Errorf("%w: uploaded OpenAI files cannot be sent to Anthropic; send the file inline as file_data", ErrUnsupportedContent)
+	}
+	mimeType, data, ok := parseDataURI(file.FileData)
+	if !ok {
+		return models.ContentBlock{}, fmt.Errorf("%w: file_data must be a base64 data URI", ErrUnsupportedContent)
+	}
+
+	switch {
+	case mimeType == "application/pdf":
+		return models.ContentBlock{
+			Type:   "document",
+			Title:  file.Filename,
+			Source: &models.ImageSource{Type: "base64", MediaType: mimeType, Data: data},
+		}, nil
+	case mimeType == "text/plain":
+		text, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return models.ContentBlock{}, fmt.Errorf("%w: file_data is not valid base64", ErrUnsupportedContent)
+		}
+		return models.ContentBlock{
+			Type:   "document",
+			Title:  file.Filename,
+			Source: &models.ImageSource{Type: "text", MediaType: mimeType, Data: string(text)},
+		}, nil
+	default:
+		return models.ContentBlock{}, fmt.Errorf("%w: Anthropic only accepts PDF and plain text documents, not %s", ErrUnsupportedContent, mimeType)
+	}
+}
+
+// openAIFileToGeminiPart converts an OpenAI file content part into a Gemini
+// inlineData part
+func openAIFileToGeminiPart(file models.FilePart) (models.GeminiPart, error) {
+	if file.FileData == "" {
+		return models.GeminiPart{}, fmt.Errorf("%w: uploaded OpenAI files cannot be sent to Gemini; send the file inline as file_data", ErrUnsupportedContent)
+	}
+	mimeType, data, ok := parseDataURI(file.FileData)
+	if !ok {
+		return models.GeminiPart{}, fmt.Errorf("%w: file_data must be a base64 data URI", ErrUnsupportedContent)
+	}
+	return models.GeminiPart{InlineData: &models.InlineData{MimeType: mimeType, Data: data}}, nil
+}
+
+// openAIFileFromMap reads the file of an OpenAI file content part given as a map
+func openAIFileFromMap(partMap map[string]interface{}) models.FilePart {
+	file := mapValue(partMap, "file")
+	return models.FilePart{
+		FileData: getString(file, "file_data"),
+		FileID:   getString(file, "file_id"),
+		Filename: getString(file, "filename"),
+	}
+}
+
+// geminiInlineDataToAnthropicBlock converts Gemini inline data into an
+// Anthropic image or document block; Anthropic has no audio input
+func geminiInlineDataToAnthropicBlock(data *models.InlineData) (models.ContentBlock, error) {
+	switch {
+	case strings.HasPrefix(data.MimeType, "image/"):
+		return models.ContentBlock{
+			Type:   "image",
+			Source: &models.ImageSource{Type: "base64", MediaType: data.MimeType, Data: data.Data},
+		}, nil
+	case data.MimeType == "application/pdf":
+		return models.ContentBlock{
+			Type:   "document",
+			Source: &models.ImageSource{Type: "base64", MediaType: data.MimeType, Data: data.Data},
+		}, nil
+	case strings.HasPrefix(data.MimeType, "audio/"):
+		return models.ContentBlock{}, unsupportedContentError("anthropic", contentAudio)
+	case data.MimeType == "text/plain":
+		text, err := base64.StdEncoding.DecodeString(data.Data)
+		if err != nil {
+			return models.ContentBlock{}, fmt.Errorf("%w: inlineData is not valid base64", ErrUnsupportedContent)
+		}
+		return models.ContentBlock{
+			Type:   "document",
+			Source: &models.ImageSource{Type: "text", MediaType: data.MimeType, Data: string(text)},
+		}, nil
+	default:
+		return models.ContentBlock{}, fmt.Errorf("%w: Anthropic does not accept %s inline data", ErrUnsupportedContent, data.MimeType)
+	}
+}
+
+// geminiFileDataToAnthropicBlock converts a Gemini file reference into an
+// Anthropic image or document block with a URL source
+func geminiFileDataToAnthropicBlock(data *models.FileData) (models.ContentBlock, error) {
+	mimeType := data.MimeType
+	if mimeType == "" {
+		mimeType = mimeTypeFromURL(data.FileURI)
+	}
+	source := &models.ImageSource{Type: "url", URL: data.FileURI}
+	switch {
+	case !strings.HasPrefix(data.FileURI, "https://"):
+		return models.ContentBlock{}, fmt.Errorf("%w: Anthropic can only fetch https file URIs, not %s", ErrUnsupportedContent, data.FileURI)
+	case strings.HasPrefix(mimeType, "image/"):
+		return models.ContentBlock{Type: "image", Source: source}, nil
+	case mimeType == "application/pdf":
+		return models.ContentBlock{Type: "document", Source: source}, nil
+	default:
+		return models.ContentBlock{}, fmt.Errorf("%w: Anthropic does not accept %s files", ErrUnsupportedContent, mimeType)
+	}
+}
+
+// geminiDataToOpenAIPart converts Gemini inline data (images, audio, documents)
+// or a file reference into an OpenAI chat content part
+func geminiDataToOpenAIPart(part models.GeminiPart) (models.ContentPart, error) {
+	if part.InlineData != nil {
+		mimeType := part.InlineData.MimeType
+		uri := dataURI(mimeType, part.InlineData.Data)
+		if strings.HasPrefix(mimeType, "image/") {
+			return models.ContentPart{Type: "image_url", ImageURL: &models.ImageURL{URL: uri}}, nil
+		}
+		if strings.HasPrefix(mimeType, "audio/") {
+			format, ok := openAIAudioFormat(mimeType)
+			if !ok {
+				return models.ContentPart{}, fmt.Errorf("%w: OpenAI only accepts wav and mp3 audio, not %s", ErrUnsupportedContent, mimeType)
+			}
+			return models.ContentPart{Type: "input_audio", InputAudio: &models.InputAudio{Data: part.InlineData.Data, Format: format}}, nil
+		}
+		return models.ContentPart{Type: "file", File: &models.FilePart{
+			FileData: uri,
+			Filename: documentFilename("", mimeType),
+		}}, nil
+	}
+
+	mimeType := part.FileData.MimeType
+	if mimeType == "" {
+		mimeType = mimeTypeFromURL(part.FileData.FileURI)
+	}
+	if strings.HasPrefix(mimeType, "image/") && strings.HasPrefix(part.FileData.FileURI, "https://") {
+		return models.ContentPart{Type: "image_url", ImageURL: &models.ImageURL{URL: part.FileData.FileURI}}, nil
+	}
+	return models.ContentPart{}, fmt.Errorf("%w: OpenAI chat completions cannot read file URIs such as %s; send the file as inlineData",
+		ErrUnsupportedContent, part.FileData.FileURI)
+}
@@ -0,0 +1,35 @@
+package converters
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// jsonEncoder is a reusable JSON encoder with its output buffer
+type jsonEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+// encoderPool reuses encoders across stream events, which are encoded at a
+// high rate while streaming
+var encoderPool = sync.Pool{
+	New: func() interface{} {
+		e := &jsonEncoder{}
+		e.enc = json.NewEncoder(&e.buf)
+		return e
+	},
+}
+
+// marshalEvent encodes a stream event like json.Marshal, with a pooled encoder
+func marshalEvent(v interface{}) []byte {
+	e := encoderPool.Get().(*jsonEncoder)
+	defer encoderPool.Put(e)
+	e.buf.Reset()
+	if err := e.enc.Encode(v); err != nil {
+		return nil
+	}
+	// Encode terminates the value with a newline, which json.Marshal does not
+	return append([]byte(nil), bytes.TrimSuffix(e.buf.Bytes(), []byte("\n"))...)
+}
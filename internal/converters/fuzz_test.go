@@ -0,0 +1,192 @@
+package converters
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"ai_gateway/internal/models"
+)
+
+// Real request, response and stream payloads of each provider seed the fuzz
+// targets below, which check that converters return errors rather than panic
+// on malformed upstream data.
+
+var fuzzResponseSeeds = []string{
+	// OpenAI chat completion
+	`{"id":"chatcmpl-1","object":"chat.completion","created":1700000000,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":12,"completion_tokens":7,"total_tokens":19,"prompt_tokens_details":{"cached_tokens":0}}}`,
+	// Anthropic message
+	`{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"thinking","thinking":"Let me check","signature":"sig"},{"type":"text","text":"Checking."},{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{"city":"Paris"}}],"stop_reason":"tool_use","usage":{"input_tokens":20,"output_tokens":9,"cache_read_input_tokens":4}}`,
+	// Gemini response
+	`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hello"},{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]},"finishReason":"STOP","index":0}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":3,"totalTokenCount":8}}`,
+	// OpenAI Responses response
+	`{"id":"resp_1","object":"response","status":"completed","model":"gpt-5","output":[{"type":"reasoning","id":"rs_1","summary":[{"type":"summary_text","text":"Thinking"}]},{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"Hi"}]},{"type":"function_call","id":"fc_1","call_id":"call_1","name":"get_weather","arguments":"{}"}],"usage":{"input_tokens":10,"output_tokens":4,"total_tokens":14}}`,
+	// Shapes that type assertions trip on
+	`{"choices":[1],"content":"text","candidates":[null],"output":"x","usage":[]}`,
+	`{"choices":[{"message":"hi","delta":[]}],"content":[{"type":"tool_use","input":"x"}],"candidates":[{"content":{"parts":{}}}],"output":[{"type":"message","content":"x"}]}`,
+}
+
+var fuzzStreamSeeds = []string{
+	// OpenAI chat chunks
+	`{"id":"chatcmpl-1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}
+{"id":"chatcmpl-1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":"{\"a\""}}]}}]}
+{"id":"chatcmpl-1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`,
+	// Anthropic events
+	`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"usage":{"input_tokens":10,"output_tokens":1}}}
+{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}
+{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"f","input":{}}}
+{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"a\":1}"}}
+{"type":"content_block_stop","index":1}
+{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":12}}
+{"type":"message_stop"}`,
+	// Gemini chunks
+	`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hel"}]},"index":0}]}
+{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"f","args":{"a":1}}}]},"finishReason":"STOP","index":0}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":3,"totalTokenCount":8}}`,
+	// OpenAI Responses events
+	`{"type":"response.created","response":{"id":"resp_1","object":"response","status":"in_progress","model":"gpt-5","output":[]}}
+{"type":"response.output_item.added","output_index":0,"item":{"type":"message","id":"msg_1","role":"assistant","content":[]}}
+{"type":"response.output_text.delta","output_index":0,"content_index":0,"delta":"Hi"}
+{"type":"response.output_item.added","output_index":1,"item":{"type":"function_call","id":"fc_1","call_id":"call_1","name":"f","arguments":""}}
+{"type":"response.function_call_arguments.delta","output_index":1,"delta":"{}"}
+{"type":"response.completed","response":{"id":"resp_1","object":"response","status":"completed","model":"gpt-5","usage":{"input_tokens":10,"output_tokens":4,"total_tokens":14}}}`,
+	// Events with unexpected shapes
+	`{"type":"content_block_delta","delta":"x"}
+{"type":"content_block_start","content_block":[]}
+{"type":"message_start","message":null}
+{"type":"message_delta","delta":1,"usage":"x"}
+{"choices":[{"delta":{"tool_calls":[{"function":null}]}}]}
+{"candidates":[{"content":null}]}`,
+}
+
+var fuzzRequestSeeds = []string{
+	`{"model":"gpt-4o","messages":[{"role":"system","content":"Be brief"},{"role":"user","content":[{"type":"text","text":"What is this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBORw0KGgo="}}]},{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"f","arguments":"{}"}}]},{"role":"tool","tool_call_id":"call_1","content":"42"}],"tools":[{"type":"function","function":{"name":"f","parameters":{"type":"object"}}}],"tool_choice":"auto","stream":true,"max_tokens":100}`,
+	`{"model":"claude-3-5-sonnet-20241022","max_tokens":1024,"system":[{"type":"text","text":"Be brief"}],"messages":[{"role":"user","content":[{"type":"text","text":"hi"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0KGgo="}}]},{"role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"f","input":{}}]},{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"42"}]}]}],"tools":[{"name":"f","input_schema":{"type":"object"}}],"tool_choice":{"type":"any"}}`,
+	`{"contents":[{"role":"user","parts":[{"text":"hi"},{"inlineData":{"mimeType":"image/png","data":"iVBORw0KGgo="}}]},{"role":"model","parts":[{"functionCall":{"name":"f","args":{}}}]},{"role":"user","parts":[{"functionResponse":{"name":"f","response":{"result":42}}}]}],"systemInstruction":{"parts":[{"text":"Be brief"}]},"tools":[{"functionDeclarations":[{"name":"f","parameters":{"type":"object"}}]}],"generationConfig":{"maxOutputTokens":100}}`,
+	`{"model":"gpt-5","input":[{"role":"user","content":[{"type":"input_text","text":"hi"}]},{"type":"function_call","call_id":"call_1","name":"f","arguments":"{}"},{"type":"function_call_output","call_id":"call_1","output":"42"}],"instructions":"Be brief","tools":[{"type":"function","name":"f","parameters":{"type":"object"}},{"type":"web_search"}],"reasoning":{"effort":"low"}}`,
+	`{"messages":[{"role":"user","content":[1,null,{"type":"image_url","image_url":"x"}]}],"system":{"a":1},"contents":[{"parts":[{}]}],"input":[1,{"type":"message","content":7}],"tools":[null]}`,
+}
+
+// FuzzResponseConverters feeds arbitrary upstream response bodies to every
+// non-streaming response converter
+func FuzzResponseConverters(f *testing.F) {
+	for _, seed := range fuzzResponseSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		decode := func() map[string]interface{} {
+			var resp map[string]interface{}
+			if json.Unmarshal(body, &resp) != nil {
+				return nil
+			}
+			return resp
+		}
+		if decode() == nil {
+			t.Skip()
+		}
+
+		// Converters may modify the map, so each gets a fresh copy
+		OpenAIToAnthropicResponse(decode(), "model")
+		OpenAIToGeminiResponse(decode())
+		OpenAIChatMapToOpenAIResponsesResponse(decode(), "model")
+		AnthropicToOpenAIResponse(decode(), "model")
+		AnthropicToGeminiResponse(decode())
+		GeminiToAnthropicResponse(decode(), "model")
+		GeminiToOpenAIResponse(decode(), "model")
+		OpenAIResponsesToAnthropicResponse(decode(), "model")
+		OpenAIResponsesToOpenAIChatResponse(decode(), "model")
+	})
+}
+
+// FuzzStreamConverters feeds arbitrary sequences of SSE event payloads, one per
+// line, to every streaming converter
+func FuzzStreamConverters(f *testing.F) {
+	for _, seed := range fuzzStreamSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		lines := bytes.Split(stream, []byte("\n"))
+
+		openAIToAnthropic := NewOpenAIToAnthropicStreamState()
+		geminiToAnthropic := NewGeminiToAnthropicStreamState("model")
+		responsesToAnthropic := NewOpenAIResponsesToAnthropicStreamState()
+		responsesToChat := NewOpenAIResponsesToChatStreamState("model")
+		chatToResponses := NewOpenAIChatToResponsesStreamState("model")
+
+		for _, line := range lines {
+			var data map[string]interface{}
+			if json.Unmarshal(line, &data) == nil {
+				eventType, _ := data["type"].(string)
+				OpenAIStreamToAnthropicStream(data, openAIToAnthropic)
+				GeminiStreamToAnthropicStream(data, geminiToAnthropic)
+				AnthropicStreamToGeminiStream(eventType, data)
+				AnthropicStreamToOpenAIStream(eventType, data, "model", "id")
+				OpenAIStreamToGeminiStream(data)
+				GeminiStreamToOpenAIStream(data, "model", "id")
+			}
+
+			var event models.ResponsesStreamEvent
+			if json.Unmarshal(line, &event) == nil {
+				OpenAIResponsesStreamToAnthropicStream(&event, responsesToAnthropic)
+				OpenAIResponsesStreamToOpenAIChatStream(&event, responsesToChat)
+			}
+
+			var chunk models.ChatCompletionChunk
+			if json.Unmarshal(line, &chunk) == nil {
+				OpenAIChatStreamToOpenAIResponsesStream(&chunk, chatToResponses)
+			}
+		}
+	})
+}
+
+// FuzzRequestConverters feeds arbitrary client request bodies to every request
+// converter of the protocols they decode as
+func FuzzRequestConverters(f *testing.F) {
+	for _, seed := range fuzzRequestSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		var chat models.ChatCompletionRequest
+		if json.Unmarshal(body, &chat) == nil {
+			for _, convert := range []func(*models.ChatCompletionRequest){
+				func(r *models.ChatCompletionRequest) { OpenAIToAnthropicRequest(r) },
+				func(r *models.ChatCompletionRequest) { OpenAIToGeminiRequest(r) },
+				func(r *models.ChatCompletionRequest) { OpenAIChatToOpenAIResponsesRequest(r) },
+			} {
+				var req models.ChatCompletionRequest
+				json.Unmarshal(body, &req)
+				convert(&req)
+			}
+		}
+
+		var messages models.MessagesRequest
+		if json.Unmarshal(body, &messages) == nil {
+			for _, convert := range []func(*models.MessagesRequest){
+				func(r *models.MessagesRequest) { AnthropicToOpenAIRequest(r) },
+				func(r *models.MessagesRequest) { AnthropicToGeminiRequest(r) },
+				func(r *models.MessagesRequest) { AnthropicToOpenAIResponsesRequest(r) },
+			} {
+				var req models.MessagesRequest
+				json.Unmarshal(body, &req)
+				convert(&req)
+			}
+		}
+
+		var gemini models.GenerateContentRequest
+		if json.Unmarshal(body, &gemini) == nil {
+			for _, convert := range []func(*models.GenerateContentRequest){
+				func(r *models.GenerateContentRequest) { GeminiToAnthropicRequest(r, "model") },
+				func(r *models.GenerateContentRequest) { GeminiToOpenAIRequest(r, "model") },
+			} {
+				var req models.GenerateContentRequest
+				json.Unmarshal(body, &req)
+				convert(&req)
+			}
+		}
+
+		var responses map[string]interface{}
+		if json.Unmarshal(body, &responses) == nil {
+			OpenAIResponsesToOpenAIChatRequest(responses)
+		}
+	})
+}
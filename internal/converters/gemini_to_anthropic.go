@@ -72,14 +72,18 @@ func GeminiToAnthropicRequest(req *models.GenerateContentRequest, model string)
 				})
 			}
 			if part.InlineData != nil {
-				contentBlocks = append(contentBlocks, models.ContentBlock{
-					Type: "image",
-					Source: &models.ImageSource{
-						Type:      "base64",
-						MediaType: part.InlineData.MimeType,
-						Data:      part.InlineData.Data,
-					},
-				})
+				block, err := geminiInlineDataToAnthropicBlock(part.InlineData)
+				if err != nil {
+					return nil, err
+				}
+				contentBlocks = append(contentBlocks, block)
+			}
+			if part.FileData != nil {
+				block, err := geminiFileDataToAnthropicBlock(part.FileData)
+				if err != nil {
+					return nil, err
+				}
+				contentBlocks = append(contentBlocks, block)
 			}
 		}
 
@@ -98,7 +102,7 @@ func GeminiToAnthropicRequest(req *models.GenerateContentRequest, model string)
 				tools = append(tools, models.AnthropicTool{
 					Name:        decl.Name,
 					Description: decl.Description,
-					InputSchema: decl.Parameters,
+					InputSchema: TranslateToolSchema(decl.Parameters, "gemini", "anthropic", false),
 				})
 			}
 		}
@@ -119,7 +123,10 @@ func AnthropicToGeminiResponse(resp map[string]interface{}) (*models.GenerateCon
 
 	var parts []models.GeminiPart
 	for _, block := range content {
-		blockMap := block.(map[string]interface{})
+		blockMap, ok := block.(map[string]interface{})
+		if !ok {
+			continue
+		}
 		blockType := getString(blockMap, "type")
 
 		switch blockType {
@@ -148,6 +155,8 @@ func AnthropicToGeminiResponse(resp map[string]interface{}) (*models.GenerateCon
 			finishReason = "MAX_TOKENS"
 		case "tool_use":
 			finishReason = "STOP"
+		case "refusal":
+			finishReason = "SAFETY"
 		default:
 			finishReason = "STOP"
 		}
@@ -180,7 +189,7 @@ func AnthropicToGeminiResponse(resp map[string]interface{}) (*models.GenerateCon
 func AnthropicStreamToGeminiStream(eventType string, data map[string]interface{}) ([]byte, error) {
 	switch eventType {
 	case "content_block_delta":
-		delta := data["delta"].(map[string]interface{})
+		delta, _ := data["delta"].(map[string]interface{})
 		deltaType := getString(delta, "type")
 
 		if deltaType == "text_delta" {
@@ -198,7 +207,7 @@ func AnthropicStreamToGeminiStream(eventType string, data map[string]interface{}
 		}
 
 	case "message_delta":
-		delta := data["delta"].(map[string]interface{})
+		delta, _ := data["delta"].(map[string]interface{})
 		stopReason := getString(delta, "stop_reason")
 
 		var finishReason string
@@ -207,6 +216,8 @@ func AnthropicStreamToGeminiStream(eventType string, data map[string]interface{}
 			finishReason = "STOP"
 		case "max_tokens":
 			finishReason = "MAX_TOKENS"
+		case "refusal":
+			finishReason = "SAFETY"
 		default:
 			finishReason = "STOP"
 		}
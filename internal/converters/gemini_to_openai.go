@@ -17,6 +17,9 @@ func GeminiToOpenAIRequest(req *models.GenerateContentRequest, model string) (*m
 		openaiReq.Temperature = req.GenerationConfig.Temperature
 		openaiReq.TopP = req.GenerationConfig.TopP
 		openaiReq.MaxTokens = req.GenerationConfig.MaxOutputTokens
+		openaiReq.N = req.GenerationConfig.CandidateCount
+		openaiReq.PresencePenalty = req.GenerationConfig.PresencePenalty
+		openaiReq.FrequencyPenalty = req.GenerationConfig.FrequencyPenalty
 		if len(req.GenerationConfig.StopSequences) > 0 {
 			openaiReq.Stop = req.GenerationConfig.StopSequences
 		}
@@ -52,6 +55,7 @@ func GeminiToOpenAIRequest(req *models.GenerateContentRequest, model string) (*m
 		}
 
 		var textContent string
+		var mediaParts []models.ContentPart
 		var toolCalls []models.ToolCall
 		var hasFunctionResponse bool
 		var functionResponseName string
@@ -61,6 +65,13 @@ func GeminiToOpenAIRequest(req *models.GenerateContentRequest, model string) (*m
 			if part.Text != "" {
 				textContent += part.Text
 			}
+			if part.InlineData != nil || part.FileData != nil {
+				mediaPart, err := geminiDataToOpenAIPart(part)
+				if err != nil {
+					return nil, err
+				}
+				mediaParts = append(mediaParts, mediaPart)
+			}
 			if part.FunctionCall != nil {
 				args, _ := json.Marshal(part.FunctionCall.Args)
 				toolCalls = append(toolCalls, models.ToolCall{
@@ -89,7 +100,13 @@ func GeminiToOpenAIRequest(req *models.GenerateContentRequest, model string) (*m
 				Content:    string(contentBytes),
 			})
 		} else {
-			if textContent != "" {
+			if len(mediaParts) > 0 {
+				var parts []models.ContentPart
+				if textContent != "" {
+					parts = append(parts, models.ContentPart{Type: "text", Text: textContent})
+				}
+				msg.Content = append(parts, mediaParts...)
+			} else if textContent != "" {
 				msg.Content = textContent
 			}
 			if len(toolCalls) > 0 {
@@ -112,7 +129,7 @@ func GeminiToOpenAIRequest(req *models.GenerateContentRequest, model string) (*m
 					Function: models.Function{
 						Name:        decl.Name,
 						Description: decl.Description,
-						Parameters:  decl.Parameters,
+						Parameters:  TranslateToolSchema(decl.Parameters, "gemini", "openai_chat", false),
 					},
 				})
 			}
@@ -123,19 +140,37 @@ func GeminiToOpenAIRequest(req *models.GenerateContentRequest, model string) (*m
 	return openaiReq, nil
 }
 
-// OpenAIToGeminiResponse converts an OpenAI response to Gemini format
+// OpenAIToGeminiResponse converts an OpenAI response to Gemini format, each
+// choice becoming a candidate
 func OpenAIToGeminiResponse(resp map[string]interface{}) (*models.GenerateContentResponse, error) {
 	geminiResp := &models.GenerateContentResponse{}
 
-	choices, ok := resp["choices"].([]interface{})
-	if !ok || len(choices) == 0 {
-		return geminiResp, nil
+	choices, _ := resp["choices"].([]interface{})
+	for i, item := range choices {
+		choice, _ := item.(map[string]interface{})
+		if candidate, ok := openAIChoiceToGeminiCandidate(choice, i); ok {
+			geminiResp.Candidates = append(geminiResp.Candidates, candidate)
+		}
+	}
+
+	// Convert usage
+	if usage, ok := resp["usage"].(map[string]interface{}); ok {
+		geminiResp.UsageMetadata = &models.UsageMetadata{
+			PromptTokenCount:     getInt(usage, "prompt_tokens"),
+			CandidatesTokenCount: getInt(usage, "completion_tokens"),
+			TotalTokenCount:      getInt(usage, "total_tokens"),
+		}
 	}
 
-	choice := choices[0].(map[string]interface{})
+	return geminiResp, nil
+}
+
+// openAIChoiceToGeminiCandidate converts a choice of an OpenAI response to a
+// Gemini candidate, or reports false when it has no message
+func openAIChoiceToGeminiCandidate(choice map[string]interface{}, position int) (models.Candidate, bool) {
 	message, ok := choice["message"].(map[string]interface{})
 	if !ok {
-		return geminiResp, nil
+		return models.Candidate{}, false
 	}
 
 	var parts []models.GeminiPart
@@ -144,12 +179,20 @@ func OpenAIToGeminiResponse(resp map[string]interface{}) (*models.GenerateConten
 	if content, ok := message["content"].(string); ok && content != "" {
 		parts = append(parts, models.GeminiPart{Text: content})
 	}
+	// Gemini has no refusal field, so the refusal is sent as text
+	refusal := getString(message, "refusal")
+	if refusal != "" {
+		parts = append(parts, models.GeminiPart{Text: refusal})
+	}
 
 	// Handle tool calls
 	if toolCalls, ok := message["tool_calls"].([]interface{}); ok {
 		for _, tc := range toolCalls {
-			tcMap := tc.(map[string]interface{})
-			function := tcMap["function"].(map[string]interface{})
+			tcMap, _ := tc.(map[string]interface{})
+			function, ok := tcMap["function"].(map[string]interface{})
+			if !ok {
+				continue
+			}
 			var args map[string]interface{}
 			if argsStr, ok := function["arguments"].(string); ok {
 				json.Unmarshal([]byte(argsStr), &args)
@@ -177,39 +220,53 @@ func OpenAIToGeminiResponse(resp map[string]interface{}) (*models.GenerateConten
 			finishReason = "STOP"
 		}
 	}
+	if refusal != "" {
+		finishReason = "SAFETY"
+	}
 
-	geminiResp.Candidates = []models.Candidate{{
+	index := position
+	if _, ok := choice["index"]; ok {
+		index = getInt(choice, "index")
+	}
+	return models.Candidate{
 		Content: &models.GeminiContent{
 			Role:  "model",
 			Parts: parts,
 		},
 		FinishReason: finishReason,
-		Index:        0,
-	}}
-
-	// Convert usage
-	if usage, ok := resp["usage"].(map[string]interface{}); ok {
-		geminiResp.UsageMetadata = &models.UsageMetadata{
-			PromptTokenCount:     getInt(usage, "prompt_tokens"),
-			CandidatesTokenCount: getInt(usage, "completion_tokens"),
-			TotalTokenCount:      getInt(usage, "total_tokens"),
-		}
-	}
-
-	return geminiResp, nil
+		Index:        index,
+	}, true
 }
 
-// OpenAIStreamToGeminiStream converts an OpenAI stream chunk to Gemini format
+// OpenAIStreamToGeminiStream converts an OpenAI stream chunk to Gemini format,
+// each choice becoming a candidate
 func OpenAIStreamToGeminiStream(data map[string]interface{}) ([]byte, error) {
-	choices, ok := data["choices"].([]interface{})
-	if !ok || len(choices) == 0 {
+	choices, _ := data["choices"].([]interface{})
+
+	var resp models.GenerateContentResponse
+	for i, item := range choices {
+		choice, _ := item.(map[string]interface{})
+		if candidate, ok := openAIDeltaToGeminiCandidate(choice, i); ok {
+			resp.Candidates = append(resp.Candidates, candidate)
+		}
+	}
+	if len(resp.Candidates) == 0 {
 		return nil, nil
 	}
 
-	choice := choices[0].(map[string]interface{})
+	return json.Marshal(resp)
+}
+
+// openAIDeltaToGeminiCandidate converts a choice of an OpenAI stream chunk to
+// a Gemini candidate, or reports false when it carries nothing
+func openAIDeltaToGeminiCandidate(choice map[string]interface{}, position int) (models.Candidate, bool) {
 	delta, ok := choice["delta"].(map[string]interface{})
 	if !ok {
-		return nil, nil
+		return models.Candidate{}, false
+	}
+	index := position
+	if _, ok := choice["index"]; ok {
+		index = getInt(choice, "index")
 	}
 
 	var parts []models.GeminiPart
@@ -217,10 +274,13 @@ func OpenAIStreamToGeminiStream(data map[string]interface{}) ([]byte, error) {
 	if content, ok := delta["content"].(string); ok && content != "" {
 		parts = append(parts, models.GeminiPart{Text: content})
 	}
+	if refusal := getString(delta, "refusal"); refusal != "" {
+		parts = append(parts, models.GeminiPart{Text: refusal})
+	}
 
 	if toolCalls, ok := delta["tool_calls"].([]interface{}); ok {
 		for _, tc := range toolCalls {
-			tcMap := tc.(map[string]interface{})
+			tcMap, _ := tc.(map[string]interface{})
 			if function, ok := tcMap["function"].(map[string]interface{}); ok {
 				var args map[string]interface{}
 				if argsStr, ok := function["arguments"].(string); ok {
@@ -252,30 +312,23 @@ func OpenAIStreamToGeminiStream(data map[string]interface{}) ([]byte, error) {
 				geminiFinishReason = "STOP"
 			}
 
-			resp := models.GenerateContentResponse{
-				Candidates: []models.Candidate{{
-					Content: &models.GeminiContent{
-						Role:  "model",
-						Parts: []models.GeminiPart{},
-					},
-					FinishReason: geminiFinishReason,
-					Index:        0,
-				}},
-			}
-			return json.Marshal(resp)
+			return models.Candidate{
+				Content: &models.GeminiContent{
+					Role:  "model",
+					Parts: []models.GeminiPart{},
+				},
+				FinishReason: geminiFinishReason,
+				Index:        index,
+			}, true
 		}
-		return nil, nil
-	}
-
-	resp := models.GenerateContentResponse{
-		Candidates: []models.Candidate{{
-			Content: &models.GeminiContent{
-				Role:  "model",
-				Parts: parts,
-			},
-			Index: 0,
-		}},
+		return models.Candidate{}, false
 	}
 
-	return json.Marshal(resp)
+	return models.Candidate{
+		Content: &models.GeminiContent{
+			Role:  "model",
+			Parts: parts,
+		},
+		Index: index,
+	}, true
 }
@@ -0,0 +1,445 @@
+package converters
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	// Registers the GIF decoder, so GIF images can be transcoded
+	_ "image/gif"
+
+	"ai_gateway/internal/models"
+)
+
+// ErrInvalidImage is returned when an inline image of a request is malformed,
+// over the gateway's size cap, or outside the upstream's limits without
+// transcoding to bring it within them
+var ErrInvalidImage = errors.New("invalid image")
+
+// ImageLimits are the constraints an upstream protocol puts on inline images
+type ImageLimits struct {
+	MaxBytes     int      // base64-encoded size of one image
+	MaxDimension int      // pixels of the longer side, 0 when unbounded
+	MIMETypes    []string // formats accepted
+	FetchesURLs  bool     // the upstream downloads image URLs itself
+}
+
+// accepts reports whether the upstream takes images of a MIME type
+func (l ImageLimits) accepts(mimeType string) bool {
+	for _, accepted := range l.MIMETypes {
+		if accepted == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// imageLimits are the inline image limits of each upstream protocol
+var imageLimits = map[string]ImageLimits{
+	"openai_chat": {MaxBytes: 20 << 20, MIMETypes: []string{"image/png", "image/jpeg", "image/gif", "image/webp"}, FetchesURLs: true},
+	"openai_code": {MaxBytes: 20 << 20, MIMETypes: []string{"image/png", "image/jpeg", "image/gif", "image/webp"}, FetchesURLs: true},
+	"anthropic":   {MaxBytes: 5 << 20, MaxDimension: 8000, MIMETypes: []string{"image/png", "image/jpeg", "image/gif", "image/webp"}},
+	"gemini":      {MaxBytes: 20 << 20, MIMETypes: []string{"image/png", "image/jpeg", "image/webp", "image/heic", "image/heif"}},
+}
+
+// FetchesImageURLs reports whether an upstream protocol downloads image URLs
+// itself; the others need images sent inline
+func FetchesImageURLs(protocol string) bool {
+	return imageLimits[protocol].FetchesURLs
+}
+
+// ImageURLFetcher downloads an image URL, returning its MIME type and data
+type ImageURLFetcher func(rawURL string) (mimeType string, data []byte, err error)
+
+// InlineImageURLs replaces the https image URLs in the content of an OpenAI
+// chat completions, Responses or Anthropic request with the images downloaded
+// by fetch, as base64. Values are as for FitInlineImages. It returns the
+// number of images inlined.
+func InlineImageURLs(values []interface{}, fetch ImageURLFetcher) (int, error) {
+	inlined := 0
+	var walk func(value interface{}) error
+	walk = func(value interface{}) error {
+		switch v := value.(type) {
+		case []interface{}:
+			for _, item := range v {
+				if err := walk(item); err != nil {
+					return err
+				}
+			}
+		case map[string]interface{}:
+			switch v["type"] {
+			case "image_url":
+				// Chat completions part: image_url is an object with the URL, or the URL
+				target, key := v, "image_url"
+				if imageURL, ok := v["image_url"].(map[string]interface{}); ok {
+					target, key = imageURL, "url"
+				}
+				uri, ok, err := fetchDataURI(target, key, fetch)
+				if err != nil {
+					return err
+				}
+				if ok {
+					target[key] = uri
+					inlined++
+				}
+				return nil
+			case "input_image":
+				uri, ok, err := fetchDataURI(v, "image_url", fetch)
+				if err != nil {
+					return err
+				}
+				if ok {
+					v["image_url"] = uri
+					inlined++
+				}
+				return nil
+			case "image":
+				source := mapValue(v, "source")
+				if getString(source, "type") != "url" {
+					return nil
+				}
+				rawURL := getString(source, "url")
+				if !isHTTPSURL(rawURL) {
+					return nil
+				}
+				mimeType, data, err := fetch(rawURL)
+				if err != nil {
+					return err
+				}
+				v["source"] = map[string]interface{}{"type": "base64", "media_type": mimeType, "data": base64.StdEncoding.EncodeToString(data)}
+				inlined++
+				return nil
+			}
+			for _, item := range v {
+				if err := walk(item); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, value := range values {
+		if err := walk(value); err != nil {
+			return inlined, err
+		}
+	}
+	return inlined, nil
+}
+
+// InlineGeminiImageURLs is InlineImageURLs for the fileData parts of a Gemini
+// request holding image URLs
+func InlineGeminiImageURLs(req *models.GenerateContentRequest, fetch ImageURLFetcher) (int, error) {
+	inlined := 0
+	for i := range req.Contents {
+		for j := range req.Contents[i].Parts {
+			part := &req.Contents[i].Parts[j]
+			if part.FileData == nil || !isHTTPSURL(part.FileData.FileURI) ||
+				part.FileData.MimeType != "" && !strings.HasPrefix(part.FileData.MimeType, "image/") {
+				continue
+			}
+			mimeType, data, err := fetch(part.FileData.FileURI)
+			if err != nil {
+				return inlined, err
+			}
+			part.InlineData = &models.InlineData{MimeType: mimeType, Data: base64.StdEncoding.EncodeToString(data)}
+			part.FileData = nil
+			inlined++
+		}
+	}
+	return inlined, nil
+}
+
+// fetchDataURI downloads the image URL held in m[key] as a data URI; ok is
+// false when m[key] is not an https URL
+func fetchDataURI(m map[string]interface{}, key string, fetch ImageURLFetcher) (string, bool, error) {
+	rawURL := getString(m, key)
+	if !isHTTPSURL(rawURL) {
+		return "", false, nil
+	}
+	mimeType, data, err := fetch(rawURL)
+	if err != nil {
+		return "", false, err
+	}
+	return dataURI(mimeType, base64.StdEncoding.EncodeToString(data)), true, nil
+}
+
+// isHTTPSURL reports whether a string is an https URL
+func isHTTPSURL(s string) bool {
+	return strings.HasPrefix(s, "https://")
+}
+
+// ImageOptions are the gateway's own rules for inline images
+type ImageOptions struct {
+	MaxBytes  int  // decoded size of one image, 0 for no cap
+	Transcode bool // downscale and re-encode images the upstream would reject
+}
+
+// maxTranscodeAttempts bounds how many times an image is shrunk to fit
+const maxTranscodeAttempts = 8
+
+// transcodeJPEGQuality is the quality transcoded opaque images are encoded at
+const transcodeJPEGQuality = 85
+
+// FitInlineImages checks the base64 images in the content of an OpenAI chat
+// completions, Responses or Anthropic request against opts and the limits of
+// the upstream protocol. Each value is a message content or Responses input
+// as decoded from JSON; images are data URIs (image_url) and Anthropic base64
+// sources, replaced in place when transcoded. A declared MIME type that does
+// not match the image data is corrected. It returns a note per image changed.
+func FitInlineImages(values []interface{}, protocol string, opts ImageOptions) ([]string, error) {
+	f := &imageFitter{protocol: protocol, opts: opts}
+	f.limits, f.known = imageLimits[protocol]
+	for _, value := range values {
+		if err := f.walk(value); err != nil {
+			return nil, err
+		}
+	}
+	return f.notes, nil
+}
+
+// FitGeminiInlineImages is FitInlineImages for the inlineData parts of a
+// Gemini request
+func FitGeminiInlineImages(req *models.GenerateContentRequest, protocol string, opts ImageOptions) ([]string, error) {
+	f := &imageFitter{protocol: protocol, opts: opts}
+	f.limits, f.known = imageLimits[protocol]
+	for i := range req.Contents {
+		for j := range req.Contents[i].Parts {
+			data := req.Contents[i].Parts[j].InlineData
+			if data == nil || !strings.HasPrefix(data.MimeType, "image/") {
+				continue
+			}
+			mimeType, encoded, err := f.fit(data.MimeType, data.Data)
+			if err != nil {
+				return nil, err
+			}
+			data.MimeType, data.Data = mimeType, encoded
+		}
+	}
+	return f.notes, nil
+}
+
+// imageFitter fits the images of one request, numbering them in order
+type imageFitter struct {
+	protocol string
+	limits   ImageLimits
+	known    bool // the protocol has limits
+	opts     ImageOptions
+	count    int
+	notes    []string
+}
+
+// walk fits the images found in a decoded JSON value
+func (f *imageFitter) walk(value interface{}) error {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if err := f.walk(item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		// Anthropic base64 source
+		if v["type"] == "base64" && strings.HasPrefix(getString(v, "media_type"), "image/") {
+			mimeType, data, err := f.fit(getString(v, "media_type"), getString(v, "data"))
+			if err != nil {
+				return err
+			}
+			v["media_type"], v["data"] = mimeType, data
+			return nil
+		}
+		// Data URIs of chat image_url parts and Responses input_image items
+		for _, key := range []string{"url", "image_url"} {
+			uri, _ := v[key].(string)
+			mimeType, data, ok := parseDataURI(uri)
+			if !ok || !strings.HasPrefix(mimeType, "image/") {
+				continue
+			}
+			mimeType, data, err := f.fit(mimeType, data)
+			if err != nil {
+				return err
+			}
+			v[key] = dataURI(mimeType, data)
+		}
+		for _, item := range v {
+			if err := f.walk(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fit returns an image as the upstream accepts it
+func (f *imageFitter) fit(mimeType, data string) (string, string, error) {
+	f.count++
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: image %d is not valid base64", ErrInvalidImage, f.count)
+	}
+	if f.opts.MaxBytes > 0 && len(raw) > f.opts.MaxBytes {
+		return "", "", fmt.Errorf("%w: image %d is %d bytes, over the limit of %d", ErrInvalidImage, f.count, len(raw), f.opts.MaxBytes)
+	}
+
+	var notes []string
+	if actual := sniffImageType(raw); actual != "" && actual != mimeType {
+		notes = append(notes, fmt.Sprintf("declared %s but is %s", mimeType, actual))
+		mimeType = actual
+	}
+	if !f.known {
+		f.note(notes)
+		return mimeType, data, nil
+	}
+
+	var problems []string
+	if !f.limits.accepts(mimeType) {
+		problems = append(problems, fmt.Sprintf("%s does not accept %s", protocolNames[f.protocol], mimeType))
+	}
+	if len(data) > f.limits.MaxBytes {
+		problems = append(problems, fmt.Sprintf("%d base64 bytes is over the limit of %d for %s", len(data), f.limits.MaxBytes, protocolNames[f.protocol]))
+	}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(raw)); err == nil && f.limits.MaxDimension > 0 &&
+		(config.Width > f.limits.MaxDimension || config.Height > f.limits.MaxDimension) {
+		problems = append(problems, fmt.Sprintf("%dx%d pixels is over the limit of %d for %s", config.Width, config.Height, f.limits.MaxDimension, protocolNames[f.protocol]))
+	}
+	if len(problems) == 0 {
+		f.note(notes)
+		return mimeType, data, nil
+	}
+	if !f.opts.Transcode {
+		return "", "", fmt.Errorf("%w: image %d: %s", ErrInvalidImage, f.count, strings.Join(problems, "; "))
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: image %d: %s, and %s images cannot be transcoded", ErrInvalidImage, f.count, strings.Join(problems, "; "), mimeType)
+	}
+	out, outType, size, err := transcodeImage(img, f.limits)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: image %d: %s, and transcoding failed: %v", ErrInvalidImage, f.count, strings.Join(problems, "; "), err)
+	}
+	bounds := img.Bounds()
+	notes = append(notes, fmt.Sprintf("transcoded %s %dx%d (%d bytes) to %s %dx%d (%d bytes)",
+		"image/"+format, bounds.Dx(), bounds.Dy(), len(raw), outType, size.X, size.Y, len(out)))
+	f.note(notes)
+	return outType, base64.StdEncoding.EncodeToString(out), nil
+}
+
+// note records the changes made to the current image
+func (f *imageFitter) note(notes []string) {
+	if len(notes) > 0 {
+		f.notes = append(f.notes, fmt.Sprintf("image %d: %s", f.count, strings.Join(notes, "; ")))
+	}
+}
+
+// transcodeImage re-encodes an image within limits, shrinking it until it
+// fits: opaque images become JPEG, images with transparency PNG. It returns
+// the encoded image, its MIME type and its size in pixels.
+func transcodeImage(img image.Image, limits ImageLimits) ([]byte, string, image.Point, error) {
+	src := image.NewRGBA(img.Bounds())
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	opaque := src.Opaque()
+
+	size := src.Bounds().Size()
+	if longest := maxInt(size.X, size.Y); limits.MaxDimension > 0 && longest > limits.MaxDimension {
+		size = scaleSize(size, float64(limits.MaxDimension)/float64(longest))
+	}
+	for attempt := 0; attempt < maxTranscodeAttempts; attempt++ {
+		scaled := src
+		if size != src.Bounds().Size() {
+			scaled = resizeImage(src, size)
+		}
+		var buf bytes.Buffer
+		var err error
+		mimeType := "image/jpeg"
+		if opaque {
+			err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: transcodeJPEGQuality})
+		} else {
+			mimeType = "image/png"
+			err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, scaled)
+		}
+		if err != nil {
+			return nil, "", image.Point{}, err
+		}
+		if !limits.accepts(mimeType) {
+			return nil, "", image.Point{}, fmt.Errorf("the upstream does not accept %s", mimeType)
+		}
+		if base64.StdEncoding.EncodedLen(buf.Len()) <= limits.MaxBytes {
+			return buf.Bytes(), mimeType, size, nil
+		}
+		size = scaleSize(size, 0.75)
+	}
+	return nil, "", image.Point{}, errors.New("the image does not fit within the size limit")
+}
+
+// scaleSize scales an image size by factor, keeping at least one pixel
+func scaleSize(size image.Point, factor float64) image.Point {
+	return image.Point{X: maxInt(1, int(float64(size.X)*factor)), Y: maxInt(1, int(float64(size.Y)*factor))}
+}
+
+// resizeImage downscales src to size, averaging the source pixels each
+// destination pixel covers
+func resizeImage(src *image.RGBA, size image.Point) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	for y := 0; y < size.Y; y++ {
+		y0, y1 := y*srcH/size.Y, maxInt((y+1)*srcH/size.Y, y*srcH/size.Y+1)
+		for x := 0; x < size.X; x++ {
+			x0, x1 := x*srcW/size.X, maxInt((x+1)*srcW/size.X, x*srcW/size.X+1)
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				offset := src.PixOffset(bounds.Min.X+x0, bounds.Min.Y+sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[offset])
+					g += int(src.Pix[offset+1])
+					b += int(src.Pix[offset+2])
+					a += int(src.Pix[offset+3])
+					offset += 4
+					n++
+				}
+			}
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r / n)
+			dst.Pix[offset+1] = uint8(g / n)
+			dst.Pix[offset+2] = uint8(b / n)
+			dst.Pix[offset+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// sniffImageType returns the MIME type of image data from its signature, or
+// "" when it is not a recognized image format
+func sniffImageType(raw []byte) string {
+	switch {
+	case bytes.HasPrefix(raw, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(raw, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case bytes.HasPrefix(raw, []byte("GIF87a")), bytes.HasPrefix(raw, []byte("GIF89a")):
+		return "image/gif"
+	case len(raw) >= 12 && string(raw[:4]) == "RIFF" && string(raw[8:12]) == "WEBP":
+		return "image/webp"
+	case len(raw) >= 12 && string(raw[4:8]) == "ftyp":
+		switch string(raw[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis":
+			return "image/heic"
+		case "mif1", "msf1":
+			return "image/heif"
+		}
+	}
+	return ""
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
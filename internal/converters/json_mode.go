@@ -0,0 +1,98 @@
+package converters
+
+import (
+	"encoding/json"
+	"strings"
+
+	"ai_gateway/internal/models"
+)
+
+// jsonModeInstruction asks models without a native JSON mode for bare JSON output
+const jsonModeInstruction = "Respond with only a single valid JSON value. Do not write any text, explanation or Markdown code fence before or after it."
+
+// WantsJSON reports whether a chat request asks for JSON output via response_format
+func WantsJSON(req *models.ChatCompletionRequest) bool {
+	rf := req.ResponseFormat
+	return rf != nil && (rf.Type == "json_object" || rf.Type == "json_schema")
+}
+
+// jsonModeSystemPrompt returns the instruction enforcing a request's JSON
+// response format, including its schema when one is given
+func jsonModeSystemPrompt(req *models.ChatCompletionRequest) string {
+	rf := req.ResponseFormat
+	if rf.Type != "json_schema" || rf.JSONSchema == nil || rf.JSONSchema.Schema == nil {
+		return jsonModeInstruction
+	}
+	schema, err := json.Marshal(rf.JSONSchema.Schema)
+	if err != nil {
+		return jsonModeInstruction
+	}
+	return jsonModeInstruction + " The JSON must conform to this JSON schema:\n" + string(schema)
+}
+
+// JSONStreamFilter keeps only the JSON value of streamed text, dropping any
+// prose or code fence a model writes before or after it
+type JSONStreamFilter struct {
+	started  bool
+	done     bool
+	depth    int
+	inString bool
+	escaped  bool
+}
+
+// Filter returns the part of a text delta that belongs to the JSON value
+func (f *JSONStreamFilter) Filter(text string) string {
+	if f.done {
+		return ""
+	}
+	start := 0
+	if !f.started {
+		start = strings.IndexAny(text, "{[")
+		if start < 0 {
+			return ""
+		}
+		f.started = true
+	}
+
+	for i := start; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case f.escaped:
+			f.escaped = false
+		case f.inString:
+			switch ch {
+			case '\\':
+				f.escaped = true
+			case '"':
+				f.inString = false
+			}
+		case ch == '"':
+			f.inString = true
+		case ch == '{' || ch == '[':
+			f.depth++
+		case ch == '}' || ch == ']':
+			f.depth--
+			if f.depth == 0 {
+				f.done = true
+				return text[start : i+1]
+			}
+		}
+	}
+	return text[start:]
+}
+
+// Started reports whether the filter has seen the start of a JSON value
+func (f *JSONStreamFilter) Started() bool {
+	return f.started
+}
+
+// ExtractJSON returns the JSON value inside text, without surrounding prose or
+// code fences, or text unchanged when it holds no valid JSON value
+func ExtractJSON(text string) string {
+	var f JSONStreamFilter
+	extracted := f.Filter(text)
+	if !json.Valid([]byte(extracted)) {
+		return text
+	}
+	return extracted
+}
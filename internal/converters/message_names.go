@@ -0,0 +1,48 @@
+package converters
+
+import "ai_gateway/internal/models"
+
+// speakerContent returns the content of a chat message with the name of its
+// author put before its text, for protocols whose messages have no name field.
+// Multi-agent frameworks tell speakers apart by name, so dropping it would
+// merge their turns into one voice.
+func speakerContent(msg models.ChatMessage) interface{} {
+	if msg.Name == "" || (msg.Role != "user" && msg.Role != "assistant") {
+		return msg.Content
+	}
+	prefix := msg.Name + ": "
+
+	switch content := msg.Content.(type) {
+	case string:
+		if content == "" {
+			return content
+		}
+		return prefix + content
+	case []models.ContentPart:
+		parts := append([]models.ContentPart(nil), content...)
+		for i, part := range parts {
+			if part.Type == "text" {
+				parts[i].Text = prefix + part.Text
+				return parts
+			}
+		}
+		return append([]models.ContentPart{{Type: "text", Text: prefix}}, parts...)
+	case []interface{}:
+		parts := append([]interface{}(nil), content...)
+		for i, item := range parts {
+			part, ok := item.(map[string]interface{})
+			if !ok || getString(part, "type") != "text" {
+				continue
+			}
+			named := make(map[string]interface{}, len(part))
+			for key, value := range part {
+				named[key] = value
+			}
+			named["text"] = prefix + getString(part, "text")
+			parts[i] = named
+			return parts
+		}
+		return append([]interface{}{map[string]interface{}{"type": "text", "text": prefix}}, parts...)
+	}
+	return msg.Content
+}
@@ -5,10 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"time"
+	"unicode/utf8"
 
 	"ai_gateway/internal/models"
 )
 
+// ErrUnsupportedTool is returned when a Responses request declares a built-in
+// tool, such as web_search, that only the Responses API can run
+var ErrUnsupportedTool = errors.New("unsupported tool")
+
 // OpenAIChatToOpenAIResponsesRequest converts OpenAI chat request to Responses API format.
 func OpenAIChatToOpenAIResponsesRequest(req *models.ChatCompletionRequest) (map[string]interface{}, error) {
 	if req == nil {
@@ -49,6 +54,15 @@ func OpenAIChatToOpenAIResponsesRequest(req *models.ChatCompletionRequest) (map[
 	if req.ToolChoice != nil {
 		result["tool_choice"] = req.ToolChoice
 	}
+	if req.ParallelToolCalls != nil {
+		result["parallel_tool_calls"] = *req.ParallelToolCalls
+	}
+	if req.ReasoningEffort != "" {
+		result["reasoning"] = map[string]interface{}{"effort": req.ReasoningEffort}
+	}
+	if req.Verbosity != "" {
+		result["text"] = map[string]interface{}{"verbosity": req.Verbosity}
+	}
 	if req.ResponseFormat != nil {
 		result["response_format"] = map[string]interface{}{
 			"type": req.ResponseFormat.Type,
@@ -59,13 +73,17 @@ func OpenAIChatToOpenAIResponsesRequest(req *models.ChatCompletionRequest) (map[
 	if len(req.Tools) > 0 {
 		var tools []map[string]interface{}
 		for _, tool := range req.Tools {
+			function := map[string]interface{}{
+				"name":        tool.Function.Name,
+				"description": tool.Function.Description,
+				"parameters":  tool.Function.Parameters,
+			}
+			if tool.Function.Strict != nil {
+				function["strict"] = *tool.Function.Strict
+			}
 			tools = append(tools, map[string]interface{}{
-				"type": "function",
-				"function": map[string]interface{}{
-					"name":        tool.Function.Name,
-					"description": tool.Function.Description,
-					"parameters":  tool.Function.Parameters,
-				},
+				"type":     "function",
+				"function": function,
 			})
 		}
 		result["tools"] = tools
@@ -89,7 +107,14 @@ func OpenAIChatToOpenAIResponsesRequest(req *models.ChatCompletionRequest) (map[
 			item["output"] = msg.Content
 		} else {
 			item["role"] = msg.Role
-			item["content"] = msg.Content
+			item["content"] = speakerContent(msg)
+			if msg.Role == "user" {
+				content, err := chatContentToResponsesInput(item["content"])
+				if err != nil {
+					return nil, err
+				}
+				item["content"] = content
+			}
 			if len(msg.ToolCalls) > 0 {
 				var toolCalls []map[string]interface{}
 				for _, tc := range msg.ToolCalls {
@@ -117,6 +142,65 @@ func OpenAIChatToOpenAIResponsesRequest(req *models.ChatCompletionRequest) (map[
 	return result, nil
 }
 
+// chatContentToResponsesInput converts the content parts of a chat user message
+// (text, image_url, file) into Responses input parts; string content is kept
+func chatContentToResponsesInput(content interface{}) (interface{}, error) {
+	var parts []map[string]interface{}
+	switch v := content.(type) {
+	case []models.ContentPart:
+		for _, part := range v {
+			partMap := map[string]interface{}{"type": part.Type, "text": part.Text}
+			if part.ImageURL != nil {
+				partMap["image_url"] = map[string]interface{}{"url": part.ImageURL.URL}
+			}
+			if part.File != nil {
+				partMap["file"] = map[string]interface{}{
+					"file_data": part.File.FileData,
+					"file_id":   part.File.FileID,
+					"filename":  part.File.Filename,
+				}
+			}
+			parts = append(parts, partMap)
+		}
+	case []interface{}:
+		for _, item := range v {
+			if partMap, ok := item.(map[string]interface{}); ok {
+				parts = append(parts, partMap)
+			}
+		}
+	default:
+		return content, nil
+	}
+
+	input := make([]map[string]interface{}, 0, len(parts))
+	for _, part := range parts {
+		switch getString(part, "type") {
+		case "text":
+			input = append(input, map[string]interface{}{"type": "input_text", "text": getString(part, "text")})
+		case "image_url":
+			imageURL := getString(mapValue(part, "image_url"), "url")
+			if imageURL == "" {
+				imageURL = getString(part, "image_url")
+			}
+			input = append(input, map[string]interface{}{"type": "input_image", "image_url": imageURL})
+		case "file":
+			file := mapValue(part, "file")
+			inputFile := map[string]interface{}{"type": "input_file"}
+			for _, key := range []string{"file_data", "file_id", "filename"} {
+				if value := getString(file, key); value != "" {
+					inputFile[key] = value
+				}
+			}
+			input = append(input, inputFile)
+		case "input_audio":
+			return nil, unsupportedContentError("openai_code", contentAudio)
+		default:
+			input = append(input, part)
+		}
+	}
+	return input, nil
+}
+
 // OpenAIResponsesToOpenAIChatRequest converts a Responses API request to OpenAI chat request.
 func OpenAIResponsesToOpenAIChatRequest(req map[string]interface{}) (*models.ChatCompletionRequest, error) {
 	if req == nil {
@@ -147,6 +231,15 @@ func OpenAIResponsesToOpenAIChatRequest(req map[string]interface{}) (*models.Cha
 	if toolChoice, ok := req["tool_choice"]; ok {
 		chatReq.ToolChoice = toolChoice
 	}
+	if parallel, ok := req["parallel_tool_calls"].(bool); ok {
+		chatReq.ParallelToolCalls = &parallel
+	}
+	if reasoning, ok := req["reasoning"].(map[string]interface{}); ok {
+		chatReq.ReasoningEffort = getString(reasoning, "effort")
+	}
+	if text, ok := req["text"].(map[string]interface{}); ok {
+		chatReq.Verbosity = getString(text, "verbosity")
+	}
 	if responseFormat, ok := req["response_format"].(map[string]interface{}); ok {
 		if formatType, ok := responseFormat["type"].(string); ok {
 			chatReq.ResponseFormat = &models.ResponseFormat{Type: formatType}
@@ -166,6 +259,14 @@ func OpenAIResponsesToOpenAIChatRequest(req map[string]interface{}) (*models.Cha
 		topLogprobsInt := int(topLogprobs)
 		chatReq.TopLogProbs = &topLogprobsInt
 	}
+	if logitBias, ok := req["logit_bias"].(map[string]interface{}); ok {
+		chatReq.LogitBias = make(map[string]float64, len(logitBias))
+		for token, bias := range logitBias {
+			if value, ok := bias.(float64); ok {
+				chatReq.LogitBias[token] = value
+			}
+		}
+	}
 
 	// Convert tools
 	if tools, ok := req["tools"].([]interface{}); ok {
@@ -175,13 +276,26 @@ func OpenAIResponsesToOpenAIChatRequest(req map[string]interface{}) (*models.Cha
 			if !ok {
 				continue
 			}
-			functionMap, _ := toolMap["function"].(map[string]interface{})
+			if toolType := getString(toolMap, "type"); toolType != "" && toolType != "function" {
+				return nil, fmt.Errorf("%w: built-in tool %s is only available on the OpenAI Responses API; route this model to an openai_code provider or declare a function tool instead",
+					ErrUnsupportedTool, toolType)
+			}
+			// Responses declares function tools flat, chat completions nests them under function
+			functionMap, ok := toolMap["function"].(map[string]interface{})
+			if !ok {
+				functionMap = toolMap
+			}
+			var strict *bool
+			if value, ok := functionMap["strict"].(bool); ok {
+				strict = &value
+			}
 			result = append(result, models.Tool{
 				Type: "function",
 				Function: models.Function{
 					Name:        getString(functionMap, "name"),
 					Description: getString(functionMap, "description"),
 					Parameters:  functionMap["parameters"],
+					Strict:      strict,
 				},
 			})
 		}
@@ -284,7 +398,8 @@ func OpenAIResponsesToOpenAIChatResponse(resp map[string]interface{}, model stri
 		response.Model = modelValue
 	}
 
-	var contentText string
+	var contentText, refusal string
+	var annotations []models.Annotation
 	var toolCalls []models.ToolCall
 
 	if output, ok := resp["output"].([]interface{}); ok {
@@ -303,7 +418,18 @@ func OpenAIResponsesToOpenAIChatResponse(resp map[string]interface{}, model stri
 						}
 						contentType := getString(contentMap, "type")
 						if contentType == "output_text" || contentType == "text" {
+							offset := utf8.RuneCountInString(contentText)
 							contentText += getString(contentMap, "text")
+							items, _ := contentMap["annotations"].([]interface{})
+							for _, item := range items {
+								annMap, _ := item.(map[string]interface{})
+								if ann, ok := responsesAnnotationToChat(responsesAnnotationFromMap(annMap), offset); ok {
+									annotations = append(annotations, ann)
+								}
+							}
+						}
+						if contentType == "refusal" {
+							refusal += getString(contentMap, "refusal")
 						}
 					}
 				}
@@ -324,6 +450,8 @@ func OpenAIResponsesToOpenAIChatResponse(resp map[string]interface{}, model stri
 	if contentText != "" {
 		message.Content = contentText
 	}
+	message.Refusal = refusal
+	message.Annotations = annotations
 	if len(toolCalls) > 0 {
 		message.ToolCalls = toolCalls
 	}
@@ -397,14 +525,24 @@ func OpenAIChatResponseToOpenAIResponsesResponse(resp *models.ChatCompletionResp
 			} else {
 				contentText = getTextContent(choice.Message.Content)
 			}
+			var parts []map[string]interface{}
 			if contentText != "" {
+				parts = append(parts, map[string]interface{}{
+					"type": "output_text",
+					"text": contentText,
+				})
+			}
+			if choice.Message.Refusal != "" {
+				parts = append(parts, map[string]interface{}{
+					"type":    "refusal",
+					"refusal": choice.Message.Refusal,
+				})
+			}
+			if len(parts) > 0 {
 				output = append(output, map[string]interface{}{
-					"type": "message",
-					"role": "assistant",
-					"content": []map[string]interface{}{{
-						"type": "output_text",
-						"text": contentText,
-					}},
+					"type":    "message",
+					"role":    "assistant",
+					"content": parts,
 				})
 			}
 			for _, tc := range choice.Message.ToolCalls {
@@ -488,24 +626,26 @@ type OpenAIResponsesToChatStreamState struct {
 	started    bool
 	sawToolCall bool
 	toolCalls  map[int]toolCallMeta
+	textChars  int            // characters of text content sent so far
+	partStarts map[[2]int]int // where each output_text part starts in the content
 }
 
 // NewOpenAIResponsesToChatStreamState creates a new stream state.
 func NewOpenAIResponsesToChatStreamState(model string) *OpenAIResponsesToChatStreamState {
 	return &OpenAIResponsesToChatStreamState{
-		model:     model,
-		toolCalls: map[int]toolCallMeta{},
+		model:      model,
+		toolCalls:  map[int]toolCallMeta{},
+		partStarts: map[[2]int]int{},
 	}
 }
 
 // OpenAIResponsesStreamToOpenAIChatStream converts a Responses stream event to chat completion chunks.
-func OpenAIResponsesStreamToOpenAIChatStream(data map[string]interface{}, state *OpenAIResponsesToChatStreamState) ([][]byte, error) {
+func OpenAIResponsesStreamToOpenAIChatStream(event *models.ResponsesStreamEvent, state *OpenAIResponsesToChatStreamState) ([][]byte, error) {
 	if state == nil {
 		state = NewOpenAIResponsesToChatStreamState("")
 	}
 
 	var chunks [][]byte
-	eventType := getString(data, "type")
 
 	startChunk := func() {
 		if state.started {
@@ -513,33 +653,29 @@ func OpenAIResponsesStreamToOpenAIChatStream(data map[string]interface{}, state
 		}
 		chunk := state.newChunk()
 		chunk.Choices[0].Delta = &models.ChatMessage{Role: "assistant"}
-		chunkBytes, _ := json.Marshal(chunk)
-		chunks = append(chunks, chunkBytes)
+		chunks = append(chunks, marshalEvent(chunk))
 		state.started = true
 	}
 
-	switch eventType {
+	switch event.Type {
 	case "response.created":
-		response, _ := data["response"].(map[string]interface{})
-		if state.id == "" {
-			state.id = getString(response, "id")
+		if state.id == "" && event.Response != nil {
+			state.id = event.Response.ID
 		}
 		if state.id == "" {
 			state.id = generateID()
 		}
-		if state.model == "" {
-			state.model = getString(response, "model")
+		if state.model == "" && event.Response != nil {
+			state.model = event.Response.Model
 		}
 		startChunk()
 
 	case "response.output_item.added":
 		startChunk()
-		index := getInt(data, "output_index")
-		item, _ := data["item"].(map[string]interface{})
-		if getString(item, "type") == "function_call" {
-			callID := getString(item, "call_id")
-			name := getString(item, "name")
-			state.toolCalls[index] = toolCallMeta{id: callID, name: name}
+		if event.Item != nil && event.Item.Type == "function_call" {
+			callID := event.Item.CallID
+			name := event.Item.Name
+			state.toolCalls[event.Index()] = toolCallMeta{id: callID, name: name}
 			state.sawToolCall = true
 
 			chunk := state.newChunk()
@@ -553,26 +689,41 @@ func OpenAIResponsesStreamToOpenAIChatStream(data map[string]interface{}, state
 					},
 				}},
 			}
-			chunkBytes, _ := json.Marshal(chunk)
-			chunks = append(chunks, chunkBytes)
+			chunks = append(chunks, marshalEvent(chunk))
 		}
 
 	case "response.output_text.delta":
 		startChunk()
-		delta := getString(data, "delta")
-		if delta != "" {
+		state.partStart(event)
+		state.textChars += utf8.RuneCountInString(event.Delta)
+		if event.Delta != "" {
 			chunk := state.newChunk()
-			chunk.Choices[0].Delta = &models.ChatMessage{Content: delta}
-			chunkBytes, _ := json.Marshal(chunk)
-			chunks = append(chunks, chunkBytes)
+			chunk.Choices[0].Delta = &models.ChatMessage{Content: event.Delta}
+			chunks = append(chunks, marshalEvent(chunk))
+		}
+
+	case "response.output_text.annotation.added":
+		startChunk()
+		if event.Annotation != nil {
+			if ann, ok := responsesAnnotationToChat(*event.Annotation, state.partStart(event)); ok {
+				chunk := state.newChunk()
+				chunk.Choices[0].Delta = &models.ChatMessage{Annotations: []models.Annotation{ann}}
+				chunks = append(chunks, marshalEvent(chunk))
+			}
+		}
+
+	case "response.refusal.delta":
+		startChunk()
+		if event.Delta != "" {
+			chunk := state.newChunk()
+			chunk.Choices[0].Delta = &models.ChatMessage{Refusal: event.Delta}
+			chunks = append(chunks, marshalEvent(chunk))
 		}
 
 	case "response.function_call_arguments.delta":
 		startChunk()
-		index := getInt(data, "output_index")
-		meta := state.toolCalls[index]
-		delta := getString(data, "delta")
-		if delta != "" {
+		meta := state.toolCalls[event.Index()]
+		if event.Delta != "" {
 			chunk := state.newChunk()
 			chunk.Choices[0].Delta = &models.ChatMessage{
 				ToolCalls: []models.ToolCall{{
@@ -580,25 +731,21 @@ func OpenAIResponsesStreamToOpenAIChatStream(data map[string]interface{}, state
 					Type: "function",
 					Function: models.FunctionCall{
 						Name:      meta.name,
-						Arguments: delta,
+						Arguments: event.Delta,
 					},
 				}},
 			}
-			chunkBytes, _ := json.Marshal(chunk)
-			chunks = append(chunks, chunkBytes)
+			chunks = append(chunks, marshalEvent(chunk))
 		}
 
 	case "response.completed":
 		startChunk()
-		response, _ := data["response"].(map[string]interface{})
-		status := getString(response, "status")
-
 		finishReason := "stop"
-		if status == "incomplete" {
-			finishReason = "length"
-		}
-		if details, ok := response["incomplete_details"].(map[string]interface{}); ok {
-			if getString(details, "reason") == "max_output_tokens" {
+		if response := event.Response; response != nil {
+			if response.Status == "incomplete" {
+				finishReason = "length"
+			}
+			if response.IncompleteDetails != nil && response.IncompleteDetails.Reason == "max_output_tokens" {
 				finishReason = "length"
 			}
 		}
@@ -608,13 +755,60 @@ func OpenAIResponsesStreamToOpenAIChatStream(data map[string]interface{}, state
 
 		chunk := state.newChunk()
 		chunk.Choices[0].FinishReason = &finishReason
-		chunkBytes, _ := json.Marshal(chunk)
-		chunks = append(chunks, chunkBytes)
+		chunks = append(chunks, marshalEvent(chunk))
 	}
 
 	return chunks, nil
 }
 
+// partStart returns where the text part of an event starts in the chat
+// content, which is where the text sent so far ends for a part not seen before
+func (s *OpenAIResponsesToChatStreamState) partStart(event *models.ResponsesStreamEvent) int {
+	key := [2]int{event.Index(), event.PartIndex()}
+	start, ok := s.partStarts[key]
+	if !ok {
+		start = s.textChars
+		s.partStarts[key] = start
+	}
+	return start
+}
+
+// responsesAnnotationFromMap reads a Responses citation from decoded JSON
+func responsesAnnotationFromMap(m map[string]interface{}) models.ResponsesAnnotation {
+	return models.ResponsesAnnotation{
+		Type:       getString(m, "type"),
+		StartIndex: getInt(m, "start_index"),
+		EndIndex:   getInt(m, "end_index"),
+		URL:        getString(m, "url"),
+		Title:      getString(m, "title"),
+		FileID:     getString(m, "file_id"),
+		Filename:   getString(m, "filename"),
+		Index:      getInt(m, "index"),
+	}
+}
+
+// responsesAnnotationToChat converts a Responses citation to a chat annotation
+// whose indices are shifted by offset, where its text part starts in the chat
+// content. Other annotation types have no chat form and report false.
+func responsesAnnotationToChat(ann models.ResponsesAnnotation, offset int) (models.Annotation, bool) {
+	switch ann.Type {
+	case "url_citation":
+		return models.Annotation{Type: ann.Type, URLCitation: &models.URLCitation{
+			StartIndex: ann.StartIndex + offset,
+			EndIndex:   ann.EndIndex + offset,
+			URL:        ann.URL,
+			Title:      ann.Title,
+		}}, true
+	case "file_citation":
+		return models.Annotation{Type: ann.Type, FileCitation: &models.FileCitation{
+			FileID:   ann.FileID,
+			Filename: ann.Filename,
+			Index:    ann.Index + offset,
+		}}, true
+	}
+	return models.Annotation{}, false
+}
+
 func (s *OpenAIResponsesToChatStreamState) newChunk() models.ChatCompletionChunk {
 	if s.id == "" {
 		s.id = generateID()
@@ -636,6 +830,7 @@ type OpenAIChatToResponsesStreamState struct {
 	model           string
 	created         bool
 	messageStarted  bool
+	refusalStarted  bool
 	nextOutputIndex int
 	toolCallIndices map[string]int
 }
@@ -672,97 +867,99 @@ func OpenAIChatStreamToOpenAIResponsesStream(chunk *models.ChatCompletionChunk,
 	}
 
 	var events [][]byte
+	messageIndex, textIndex, refusalIndex := 0, 0, 1
 
 	if !state.created {
-		createdEvent := map[string]interface{}{
-			"type": "response.created",
-			"response": map[string]interface{}{
-				"id":     state.responseID,
-				"model":  state.model,
-				"status": "in_progress",
+		events = append(events, marshalEvent(models.ResponsesStreamEvent{
+			Type: "response.created",
+			Response: &models.ResponsesResponse{
+				ID:     state.responseID,
+				Model:  state.model,
+				Status: "in_progress",
 			},
-		}
-		createdBytes, _ := json.Marshal(createdEvent)
-		events = append(events, createdBytes)
+		}))
 		state.created = true
 	}
 
 	if !state.messageStarted {
-		messageStartEvent := map[string]interface{}{
-			"type":         "response.output_item.added",
-			"output_index": 0,
-			"item": map[string]interface{}{
-				"id":      fmt.Sprintf("msg_%s", state.responseID),
-				"type":    "message",
-				"role":    "assistant",
-				"content": []interface{}{},
-			},
-		}
-		messageStartBytes, _ := json.Marshal(messageStartEvent)
-		events = append(events, messageStartBytes)
-
-		contentPartEvent := map[string]interface{}{
-			"type":         "response.content_part.added",
-			"output_index": 0,
-			"content_index": 0,
-			"part": map[string]interface{}{
-				"type": "output_text",
-				"text": "",
+		events = append(events, marshalEvent(models.ResponsesStreamEvent{
+			Type:        "response.output_item.added",
+			OutputIndex: &messageIndex,
+			Item: &models.ResponsesOutputItem{
+				ID:      fmt.Sprintf("msg_%s", state.responseID),
+				Type:    "message",
+				Role:    "assistant",
+				Content: &[]models.ResponsesContentPart{},
 			},
-		}
-		contentPartBytes, _ := json.Marshal(contentPartEvent)
-		events = append(events, contentPartBytes)
-
+		}))
+		events = append(events, marshalEvent(models.ResponsesStreamEvent{
+			Type:         "response.content_part.added",
+			OutputIndex:  &messageIndex,
+			ContentIndex: &textIndex,
+			Part:         &models.ResponsesContentPart{Type: "output_text"},
+		}))
 		state.messageStarted = true
 	}
 
 	if choice.Delta != nil {
 		if content, ok := choice.Delta.Content.(string); ok && content != "" {
-			textDeltaEvent := map[string]interface{}{
-				"type":         "response.output_text.delta",
-				"output_index": 0,
-				"content_index": 0,
-				"delta":        content,
+			events = append(events, marshalEvent(models.ResponsesStreamEvent{
+				Type:         "response.output_text.delta",
+				OutputIndex:  &messageIndex,
+				ContentIndex: &textIndex,
+				Delta:        content,
+			}))
+		}
+
+		// A refusal is a second content part of the message
+		if choice.Delta.Refusal != "" {
+			if !state.refusalStarted {
+				events = append(events, marshalEvent(models.ResponsesStreamEvent{
+					Type:         "response.content_part.added",
+					OutputIndex:  &messageIndex,
+					ContentIndex: &refusalIndex,
+					Part:         &models.ResponsesContentPart{Type: "refusal"},
+				}))
+				state.refusalStarted = true
+			}
+			events = append(events, marshalEvent(models.ResponsesStreamEvent{
+				Type:         "response.refusal.delta",
+				OutputIndex:  &messageIndex,
+				ContentIndex: &refusalIndex,
+				Delta:        choice.Delta.Refusal,
+			}))
+		}
+
+		for _, tc := range choice.Delta.ToolCalls {
+			callID := tc.ID
+			if callID == "" {
+				callID = fmt.Sprintf("call_%d", state.nextOutputIndex)
+			}
+			index, ok := state.toolCallIndices[callID]
+			if !ok {
+				index = state.nextOutputIndex
+				state.toolCallIndices[callID] = index
+				state.nextOutputIndex++
+
+				arguments := ""
+				events = append(events, marshalEvent(models.ResponsesStreamEvent{
+					Type:        "response.output_item.added",
+					OutputIndex: &index,
+					Item: &models.ResponsesOutputItem{
+						Type:      "function_call",
+						CallID:    callID,
+						Name:      tc.Function.Name,
+						Arguments: &arguments,
+					},
+				}))
 			}
-			textDeltaBytes, _ := json.Marshal(textDeltaEvent)
-			events = append(events, textDeltaBytes)
-		}
-
-		if len(choice.Delta.ToolCalls) > 0 {
-			for _, tc := range choice.Delta.ToolCalls {
-				callID := tc.ID
-				if callID == "" {
-					callID = fmt.Sprintf("call_%d", state.nextOutputIndex)
-				}
-				index, ok := state.toolCallIndices[callID]
-				if !ok {
-					index = state.nextOutputIndex
-					state.toolCallIndices[callID] = index
-					state.nextOutputIndex++
-
-					itemAddedEvent := map[string]interface{}{
-						"type":         "response.output_item.added",
-						"output_index": index,
-						"item": map[string]interface{}{
-							"type":      "function_call",
-							"call_id":   callID,
-							"name":      tc.Function.Name,
-							"arguments": "",
-						},
-					}
-					itemAddedBytes, _ := json.Marshal(itemAddedEvent)
-					events = append(events, itemAddedBytes)
-				}
 
-				if tc.Function.Arguments != "" {
-					argsDeltaEvent := map[string]interface{}{
-						"type":         "response.function_call_arguments.delta",
-						"output_index": index,
-						"delta":        tc.Function.Arguments,
-					}
-					argsDeltaBytes, _ := json.Marshal(argsDeltaEvent)
-					events = append(events, argsDeltaBytes)
-				}
+			if tc.Function.Arguments != "" {
+				events = append(events, marshalEvent(models.ResponsesStreamEvent{
+					Type:        "response.function_call_arguments.delta",
+					OutputIndex: &index,
+					Delta:       tc.Function.Arguments,
+				}))
 			}
 		}
 	}
@@ -771,44 +968,34 @@ func OpenAIChatStreamToOpenAIResponsesStream(chunk *models.ChatCompletionChunk,
 		finishReason := *choice.FinishReason
 
 		if state.messageStarted {
-			doneEvent := map[string]interface{}{
-				"type":         "response.output_item.done",
-				"output_index": 0,
-			}
-			doneBytes, _ := json.Marshal(doneEvent)
-			events = append(events, doneBytes)
+			events = append(events, marshalEvent(models.ResponsesStreamEvent{
+				Type:        "response.output_item.done",
+				OutputIndex: &messageIndex,
+			}))
 		}
 
 		for _, index := range state.toolCallIndices {
-			doneEvent := map[string]interface{}{
-				"type":         "response.output_item.done",
-				"output_index": index,
-			}
-			doneBytes, _ := json.Marshal(doneEvent)
-			events = append(events, doneBytes)
+			index := index
+			events = append(events, marshalEvent(models.ResponsesStreamEvent{
+				Type:        "response.output_item.done",
+				OutputIndex: &index,
+			}))
 		}
 
-		status := "completed"
-		response := map[string]interface{}{
-			"id":     state.responseID,
-			"model":  state.model,
-			"status": status,
+		response := &models.ResponsesResponse{
+			ID:     state.responseID,
+			Model:  state.model,
+			Status: "completed",
 		}
-
 		if finishReason == "length" {
-			status = "incomplete"
-			response["status"] = status
-			response["incomplete_details"] = map[string]interface{}{
-				"reason": "max_output_tokens",
-			}
+			response.Status = "incomplete"
+			response.IncompleteDetails = &models.ResponsesIncompleteDetails{Reason: "max_output_tokens"}
 		}
 
-		completedEvent := map[string]interface{}{
-			"type":     "response.completed",
-			"response": response,
-		}
-		completedBytes, _ := json.Marshal(completedEvent)
-		events = append(events, completedBytes)
+		events = append(events, marshalEvent(models.ResponsesStreamEvent{
+			Type:     "response.completed",
+			Response: response,
+		}))
 	}
 
 	return events, nil
@@ -29,6 +29,9 @@ func OpenAIToAnthropicRequest(req *models.ChatCompletionRequest) (*models.Messag
 	if req.TopK != nil {
 		anthropicReq.TopK = req.TopK
 	}
+	if req.User != "" {
+		anthropicReq.Metadata = &models.Metadata{UserID: req.User}
+	}
 
 	// Convert stop sequences
 	if req.Stop != nil {
@@ -69,7 +72,10 @@ func OpenAIToAnthropicRequest(req *models.ChatCompletionRequest) (*models.Messag
 				Content: msg.Content,
 			}}
 		} else {
-			textContent, imageBlocks := extractOpenAIContentParts(msg.Content)
+			textContent, mediaBlocks, err := extractOpenAIContentParts(speakerContent(msg))
+			if err != nil {
+				return nil, err
+			}
 			var blocks []models.ContentBlock
 
 			if textContent != "" {
@@ -79,8 +85,8 @@ func OpenAIToAnthropicRequest(req *models.ChatCompletionRequest) (*models.Messag
 				})
 			}
 
-			if len(imageBlocks) > 0 {
-				blocks = append(blocks, imageBlocks...)
+			if len(mediaBlocks) > 0 {
+				blocks = append(blocks, mediaBlocks...)
 			}
 
 			// Add tool use blocks if present
@@ -100,7 +106,7 @@ func OpenAIToAnthropicRequest(req *models.ChatCompletionRequest) (*models.Messag
 			}
 
 			if len(blocks) > 0 {
-				if len(blocks) == 1 && blocks[0].Type == "text" && len(msg.ToolCalls) == 0 && len(imageBlocks) == 0 {
+				if len(blocks) == 1 && blocks[0].Type == "text" && len(msg.ToolCalls) == 0 && len(mediaBlocks) == 0 {
 					anthropicMsg.Content = blocks[0].Text
 				} else {
 					anthropicMsg.Content = blocks
@@ -114,6 +120,14 @@ func OpenAIToAnthropicRequest(req *models.ChatCompletionRequest) (*models.Messag
 	}
 	anthropicReq.Messages = messages
 
+	// Anthropic has no JSON mode, so ask for bare JSON in the system prompt
+	if WantsJSON(req) {
+		if systemText != "" {
+			systemText += "\n\n"
+		}
+		systemText += jsonModeSystemPrompt(req)
+	}
+
 	if systemText != "" {
 		anthropicReq.System = systemText
 	}
@@ -125,7 +139,8 @@ func OpenAIToAnthropicRequest(req *models.ChatCompletionRequest) (*models.Messag
 			tools = append(tools, models.AnthropicTool{
 				Name:        tool.Function.Name,
 				Description: tool.Function.Description,
-				InputSchema: tool.Function.Parameters,
+				InputSchema: TranslateToolSchema(tool.Function.Parameters, "openai_chat", "anthropic", false),
+				Strict:      tool.Function.Strict,
 			})
 		}
 		anthropicReq.Tools = tools
@@ -140,6 +155,8 @@ func OpenAIToAnthropicRequest(req *models.ChatCompletionRequest) (*models.Messag
 				anthropicReq.ToolChoice = models.ToolChoiceAuto{Type: "auto"}
 			case "required":
 				anthropicReq.ToolChoice = models.ToolChoiceAny{Type: "any"}
+			case "none":
+				anthropicReq.ToolChoice = models.ToolChoiceNone{Type: "none"}
 			}
 		case models.ToolChoiceObject:
 			anthropicReq.ToolChoice = models.ToolChoiceTool{
@@ -153,6 +170,8 @@ func OpenAIToAnthropicRequest(req *models.ChatCompletionRequest) (*models.Messag
 					anthropicReq.ToolChoice = models.ToolChoiceAuto{Type: "auto"}
 				case "required":
 					anthropicReq.ToolChoice = models.ToolChoiceAny{Type: "any"}
+				case "none":
+					anthropicReq.ToolChoice = models.ToolChoiceNone{Type: "none"}
 				case "function":
 					if fn, ok := choice["function"].(map[string]interface{}); ok {
 						name := getString(fn, "name")
@@ -168,6 +187,23 @@ func OpenAIToAnthropicRequest(req *models.ChatCompletionRequest) (*models.Messag
 		}
 	}
 
+	// Anthropic expresses parallel_tool_calls=false on the tool choice
+	if req.ParallelToolCalls != nil && !*req.ParallelToolCalls && len(anthropicReq.Tools) > 0 {
+		switch choice := anthropicReq.ToolChoice.(type) {
+		case nil:
+			anthropicReq.ToolChoice = models.ToolChoiceAuto{Type: "auto", DisableParallelToolUse: true}
+		case models.ToolChoiceAuto:
+			choice.DisableParallelToolUse = true
+			anthropicReq.ToolChoice = choice
+		case models.ToolChoiceAny:
+			choice.DisableParallelToolUse = true
+			anthropicReq.ToolChoice = choice
+		case models.ToolChoiceTool:
+			choice.DisableParallelToolUse = true
+			anthropicReq.ToolChoice = choice
+		}
+	}
+
 	return anthropicReq, nil
 }
 
@@ -234,6 +270,18 @@ func AnthropicToOpenAIResponse(resp map[string]interface{}, model string) (*mode
 						Arguments: string(argsBytes),
 					},
 				})
+			default:
+				// Server tools already ran upstream, so the client only sees what they did
+				if !isAnthropicServerToolBlock(block.Type) {
+					continue
+				}
+				if text, err := anthropicServerToolText(block, "openai_chat"); err == nil {
+					textBuilder.WriteString(text + "\n")
+					contentParts = append(contentParts, map[string]interface{}{
+						"type": "text",
+						"text": text + "\n",
+					})
+				}
 			}
 		}
 	}
@@ -262,6 +310,8 @@ func AnthropicToOpenAIResponse(resp map[string]interface{}, model string) (*mode
 			mapped = "stop"
 		case "tool_use":
 			mapped = "tool_calls"
+		case "refusal":
+			mapped = "content_filter"
 		}
 		if mapped != "" {
 			finishReason = &mapped
@@ -306,7 +356,7 @@ func AnthropicStreamToOpenAIStream(eventType string, data map[string]interface{}
 		return json.Marshal(chunk)
 
 	case "content_block_delta":
-		delta := data["delta"].(map[string]interface{})
+		delta, _ := data["delta"].(map[string]interface{})
 		deltaType := getString(delta, "type")
 
 		chunk := models.ChatCompletionChunk{
@@ -369,7 +419,7 @@ func AnthropicStreamToOpenAIStream(eventType string, data map[string]interface{}
 		return json.Marshal(chunk)
 
 	case "message_delta":
-		delta := data["delta"].(map[string]interface{})
+		delta, _ := data["delta"].(map[string]interface{})
 		stopReason := getString(delta, "stop_reason")
 
 		var finishReason string
@@ -382,6 +432,8 @@ func AnthropicStreamToOpenAIStream(eventType string, data map[string]interface{}
 			finishReason = "stop"
 		case "tool_use":
 			finishReason = "tool_calls"
+		case "refusal":
+			finishReason = "content_filter"
 		default:
 			finishReason = stopReason
 		}
@@ -2,6 +2,7 @@ package converters
 
 import (
 	"encoding/json"
+	"strings"
 	"time"
 
 	"ai_gateway/internal/models"
@@ -22,6 +23,9 @@ func OpenAIToGeminiRequest(req *models.ChatCompletionRequest) (*models.GenerateC
 	if req.MaxTokens != nil {
 		geminiReq.GenerationConfig.MaxOutputTokens = req.MaxTokens
 	}
+	geminiReq.GenerationConfig.PresencePenalty = req.PresencePenalty
+	geminiReq.GenerationConfig.FrequencyPenalty = req.FrequencyPenalty
+	geminiReq.GenerationConfig.CandidateCount = req.N
 
 	// Convert stop sequences
 	if req.Stop != nil {
@@ -37,6 +41,11 @@ func OpenAIToGeminiRequest(req *models.ChatCompletionRequest) (*models.GenerateC
 		}
 	}
 
+	// Ask Gemini for bare JSON in JSON mode
+	if WantsJSON(req) {
+		geminiReq.GenerationConfig.ResponseMimeType = "application/json"
+	}
+
 	// Convert messages
 	var contents []models.GeminiContent
 	for _, msg := range req.Messages {
@@ -91,11 +100,12 @@ func OpenAIToGeminiRequest(req *models.ChatCompletionRequest) (*models.GenerateC
 			}
 		}
 
-		// Handle regular content
-		content := getTextContent(msg.Content)
-		if content != "" {
-			geminiContent.Parts = append(geminiContent.Parts, models.GeminiPart{Text: content})
+		// Handle regular content, including images and files
+		parts, err := openAIContentToGeminiParts(speakerContent(msg))
+		if err != nil {
+			return nil, err
 		}
+		geminiContent.Parts = append(geminiContent.Parts, parts...)
 
 		if len(geminiContent.Parts) > 0 {
 			contents = append(contents, geminiContent)
@@ -110,7 +120,7 @@ func OpenAIToGeminiRequest(req *models.ChatCompletionRequest) (*models.GenerateC
 			declarations = append(declarations, models.FunctionDeclaration{
 				Name:        tool.Function.Name,
 				Description: tool.Function.Description,
-				Parameters:  tool.Function.Parameters,
+				Parameters:  TranslateToolSchema(tool.Function.Parameters, "openai_chat", "gemini", false),
 			})
 		}
 		geminiReq.Tools = []models.GeminiTool{{
@@ -121,7 +131,119 @@ func OpenAIToGeminiRequest(req *models.ChatCompletionRequest) (*models.GenerateC
 	return geminiReq, nil
 }
 
-// GeminiToOpenAIResponse converts a Gemini response to OpenAI format
+// openAIContentToGeminiParts converts OpenAI message content into Gemini parts,
+// keeping text, images, files and audio in their original order
+func openAIContentToGeminiParts(content interface{}) ([]models.GeminiPart, error) {
+	var parts []models.GeminiPart
+	var err error
+	addText := func(text string) {
+		if text == "" {
+			return
+		}
+		if n := len(parts); n > 0 && parts[n-1].Text != "" {
+			parts[n-1].Text += text
+			return
+		}
+		parts = append(parts, models.GeminiPart{Text: text})
+	}
+	addImage := func(imageURL string) {
+		if part, ok := imageURLToGeminiPart(imageURL); ok {
+			parts = append(parts, part)
+		}
+	}
+	addFile := func(file models.FilePart) {
+		part, fileErr := openAIFileToGeminiPart(file)
+		if fileErr != nil {
+			err = fileErr
+			return
+		}
+		parts = append(parts, part)
+	}
+	addAudio := func(audio models.InputAudio) {
+		mimeType, ok := openAIAudioFormats[audio.Format]
+		if !ok {
+			mimeType = "audio/" + audio.Format
+		}
+		parts = append(parts, models.GeminiPart{InlineData: &models.InlineData{MimeType: mimeType, Data: audio.Data}})
+	}
+	addMapPart := func(partMap map[string]interface{}) {
+		switch getString(partMap, "type") {
+		case "text":
+			addText(getString(partMap, "text"))
+		case "image_url":
+			switch imageURL := partMap["image_url"].(type) {
+			case string:
+				addImage(imageURL)
+			case map[string]interface{}:
+				addImage(getString(imageURL, "url"))
+			}
+		case "file":
+			addFile(openAIFileFromMap(partMap))
+		case "input_audio":
+			audio := mapValue(partMap, "input_audio")
+			addAudio(models.InputAudio{Data: getString(audio, "data"), Format: getString(audio, "format")})
+		}
+	}
+
+	switch v := content.(type) {
+	case string:
+		addText(v)
+	case []models.ContentPart:
+		for _, part := range v {
+			switch part.Type {
+			case "text":
+				addText(part.Text)
+			case "image_url":
+				if part.ImageURL != nil {
+					addImage(part.ImageURL.URL)
+				}
+			case "file":
+				if part.File != nil {
+					addFile(*part.File)
+				}
+			case "input_audio":
+				if part.InputAudio != nil {
+					addAudio(*part.InputAudio)
+				}
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if partMap, ok := item.(map[string]interface{}); ok {
+				addMapPart(partMap)
+			}
+		}
+	case []map[string]interface{}:
+		for _, partMap := range v {
+			addMapPart(partMap)
+		}
+	}
+	return parts, err
+}
+
+// imageURLToGeminiPart converts an OpenAI image URL into a Gemini part: data
+// URIs become inlineData, other URLs fileData
+func imageURLToGeminiPart(imageURL string) (models.GeminiPart, bool) {
+	if imageURL == "" {
+		return models.GeminiPart{}, false
+	}
+
+	if strings.HasPrefix(imageURL, "data:") {
+		mimeType, data, ok := parseDataURI(imageURL)
+		if !ok {
+			return models.GeminiPart{}, false
+		}
+		return models.GeminiPart{InlineData: &models.InlineData{MimeType: mimeType, Data: data}}, true
+	}
+
+	return models.GeminiPart{FileData: &models.FileData{
+		MimeType: mimeTypeFromURL(imageURL),
+		FileURI:  imageURL,
+	}}, true
+}
+
+// GeminiToOpenAIResponse converts a Gemini response to OpenAI format, each
+// candidate becoming a choice
 func GeminiToOpenAIResponse(resp map[string]interface{}, model string) (*models.ChatCompletionResponse, error) {
 	openaiResp := &models.ChatCompletionResponse{
 		ID:      generateID(),
@@ -130,14 +252,42 @@ func GeminiToOpenAIResponse(resp map[string]interface{}, model string) (*models.
 		Model:   model,
 	}
 
-	candidates, ok := resp["candidates"].([]interface{})
-	if !ok || len(candidates) == 0 {
+	candidates, _ := resp["candidates"].([]interface{})
+	for i, item := range candidates {
+		candidate, _ := item.(map[string]interface{})
+		openaiResp.Choices = append(openaiResp.Choices, geminiCandidateToChoice(candidate, i))
+	}
+	if len(openaiResp.Choices) == 0 {
 		return openaiResp, nil
 	}
 
-	candidate := candidates[0].(map[string]interface{})
-	content := candidate["content"].(map[string]interface{})
-	parts := content["parts"].([]interface{})
+	// Convert usage
+	if usage, ok := resp["usageMetadata"].(map[string]interface{}); ok {
+		promptTokens := getInt(usage, "promptTokenCount")
+		completionTokens := getInt(usage, "candidatesTokenCount")
+		openaiResp.Usage = &models.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		}
+	}
+
+	return openaiResp, nil
+}
+
+// geminiCandidateIndex returns the index of a Gemini candidate, which Gemini
+// omits for the first one
+func geminiCandidateIndex(candidate map[string]interface{}, position int) int {
+	if _, ok := candidate["index"]; ok {
+		return getInt(candidate, "index")
+	}
+	return position
+}
+
+// geminiCandidateToChoice converts a Gemini response candidate to an OpenAI choice
+func geminiCandidateToChoice(candidate map[string]interface{}, position int) models.Choice {
+	content, _ := candidate["content"].(map[string]interface{})
+	parts, _ := content["parts"].([]interface{})
 
 	var message models.ChatMessage
 	message.Role = "assistant"
@@ -147,7 +297,10 @@ func GeminiToOpenAIResponse(resp map[string]interface{}, model string) (*models.
 	toolCallIndex := 0
 
 	for _, part := range parts {
-		partMap := part.(map[string]interface{})
+		partMap, ok := part.(map[string]interface{})
+		if !ok {
+			continue
+		}
 		if text, ok := partMap["text"].(string); ok {
 			textContent += text
 		}
@@ -189,43 +342,17 @@ func GeminiToOpenAIResponse(resp map[string]interface{}, model string) (*models.
 		}
 	}
 
-	openaiResp.Choices = []models.Choice{{
-		Index:        0,
+	return models.Choice{
+		Index:        geminiCandidateIndex(candidate, position),
 		Message:      &message,
 		FinishReason: &finishReason,
-	}}
-
-	// Convert usage
-	if usage, ok := resp["usageMetadata"].(map[string]interface{}); ok {
-		promptTokens := getInt(usage, "promptTokenCount")
-		completionTokens := getInt(usage, "candidatesTokenCount")
-		openaiResp.Usage = &models.Usage{
-			PromptTokens:     promptTokens,
-			CompletionTokens: completionTokens,
-			TotalTokens:      promptTokens + completionTokens,
-		}
 	}
-
-	return openaiResp, nil
 }
 
-// GeminiStreamToOpenAIStream converts a Gemini stream event to OpenAI format
+// GeminiStreamToOpenAIStream converts a Gemini stream event to OpenAI format,
+// each candidate becoming a choice
 func GeminiStreamToOpenAIStream(data map[string]interface{}, model string, id string) ([]byte, error) {
-	candidates, ok := data["candidates"].([]interface{})
-	if !ok || len(candidates) == 0 {
-		return nil, nil
-	}
-
-	candidate := candidates[0].(map[string]interface{})
-	content, ok := candidate["content"].(map[string]interface{})
-	if !ok {
-		return nil, nil
-	}
-
-	parts, ok := content["parts"].([]interface{})
-	if !ok || len(parts) == 0 {
-		return nil, nil
-	}
+	candidates, _ := data["candidates"].([]interface{})
 
 	chunk := models.ChatCompletionChunk{
 		ID:      id,
@@ -233,17 +360,41 @@ func GeminiStreamToOpenAIStream(data map[string]interface{}, model string, id st
 		Created: time.Now().Unix(),
 		Model:   model,
 	}
+	hasParts := false
+	for i, item := range candidates {
+		candidate, _ := item.(map[string]interface{})
+		content, _ := candidate["content"].(map[string]interface{})
+		parts, _ := content["parts"].([]interface{})
+		if len(parts) == 0 {
+			continue
+		}
+		hasParts = true
+		if choice, ok := geminiStreamPartToChoice(candidate, parts[0], geminiCandidateIndex(candidate, i)); ok {
+			chunk.Choices = append(chunk.Choices, choice)
+		}
+	}
+	if !hasParts {
+		return nil, nil
+	}
+
+	return json.Marshal(chunk)
+}
 
-	part := parts[0].(map[string]interface{})
+// geminiStreamPartToChoice converts the part of a streamed Gemini candidate to
+// an OpenAI chunk choice, or reports false when the part is neither text nor a
+// function call
+func geminiStreamPartToChoice(candidate map[string]interface{}, item interface{}, index int) (models.Choice, bool) {
+	var choice models.Choice
+	part, _ := item.(map[string]interface{})
 	if text, ok := part["text"].(string); ok {
-		chunk.Choices = []models.Choice{{
-			Index: 0,
+		choice = models.Choice{
+			Index: index,
 			Delta: &models.ChatMessage{Content: text},
-		}}
+		}
 	} else if fc, ok := part["functionCall"].(map[string]interface{}); ok {
 		args, _ := json.Marshal(fc["args"])
-		chunk.Choices = []models.Choice{{
-			Index: 0,
+		choice = models.Choice{
+			Index: index,
 			Delta: &models.ChatMessage{
 				ToolCalls: []models.ToolCall{{
 					ID:   generateToolCallID(0),
@@ -254,7 +405,9 @@ func GeminiStreamToOpenAIStream(data map[string]interface{}, model string, id st
 					},
 				}},
 			},
-		}}
+		}
+	} else {
+		return choice, false
 	}
 
 	// Check for finish reason
@@ -268,12 +421,9 @@ func GeminiStreamToOpenAIStream(data map[string]interface{}, model string, id st
 		default:
 			finishReason = "stop"
 		}
-		if len(chunk.Choices) > 0 {
-			chunk.Choices[0].FinishReason = &finishReason
-		}
+		choice.FinishReason = &finishReason
 	}
-
-	return json.Marshal(chunk)
+	return choice, true
 }
 
 func generateToolCallID(index int) string {
@@ -0,0 +1,95 @@
+package converters
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// anthropicServerToolResults are the blocks holding the results of tools that
+// Anthropic runs itself; their calls are server_tool_use or mcp_tool_use blocks
+var anthropicServerToolResults = map[string]bool{
+	"web_search_tool_result":                 true,
+	"web_fetch_tool_result":                  true,
+	"code_execution_tool_result":             true,
+	"bash_code_execution_tool_result":        true,
+	"text_editor_code_execution_tool_result": true,
+	"mcp_tool_result":                        true,
+}
+
+// isAnthropicServerToolBlock reports whether a block belongs to a tool run by
+// Anthropic, which other protocols cannot replay as tool calls
+func isAnthropicServerToolBlock(blockType string) bool {
+	switch blockType {
+	case "server_tool_use", "mcp_tool_use", "container_upload":
+		return true
+	}
+	return anthropicServerToolResults[blockType]
+}
+
+// anthropicServerToolText summarizes an Anthropic server tool block as text for
+// a protocol without Anthropic's server tools, so that a conversation keeps what
+// the model saw. Files uploaded to Anthropic's code execution container have no
+// text form and fail with a capability error.
+func anthropicServerToolText(block normalizedAnthropicBlock, protocol string) (string, error) {
+	switch block.Type {
+	case "server_tool_use", "mcp_tool_use":
+		input, _ := json.Marshal(block.Input)
+		return fmt.Sprintf("[%s call: %s]", block.Name, input), nil
+	case "container_upload":
+		return "", fmt.Errorf("%w: %s cannot read files uploaded to Anthropic's code execution container",
+			ErrUnsupportedContent, protocolNames[protocol])
+	}
+
+	name := strings.TrimSuffix(block.Type, "_tool_result")
+	content, _ := block.Content.(map[string]interface{})
+	if code := getString(content, "error_code"); code != "" {
+		return fmt.Sprintf("[%s error: %s]", name, code), nil
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "[%s result]", name)
+	switch block.Type {
+	case "web_search_tool_result":
+		results, _ := block.Content.([]interface{})
+		for _, item := range results {
+			result, _ := item.(map[string]interface{})
+			fmt.Fprintf(&text, "\n- %s (%s)", getString(result, "title"), getString(result, "url"))
+		}
+	case "web_fetch_tool_result":
+		text.WriteString("\n" + getString(content, "url"))
+		document := mapValue(content, "content")
+		if source := mapValue(document, "source"); getString(source, "type") == "text" {
+			text.WriteString("\n" + getString(source, "data"))
+		}
+	case "code_execution_tool_result", "bash_code_execution_tool_result":
+		fmt.Fprintf(&text, "\nreturn code: %d", getInt(content, "return_code"))
+		if stdout := getString(content, "stdout"); stdout != "" {
+			text.WriteString("\nstdout:\n" + stdout)
+		}
+		if stderr := getString(content, "stderr"); stderr != "" {
+			text.WriteString("\nstderr:\n" + stderr)
+		}
+		files, _ := content["content"].([]interface{})
+		for _, item := range files {
+			if file, ok := item.(map[string]interface{}); ok {
+				text.WriteString("\noutput file: " + getString(file, "file_id"))
+			}
+		}
+	case "mcp_tool_result":
+		if block.IsError != nil && *block.IsError {
+			text.Reset()
+			fmt.Fprintf(&text, "[%s error]", name)
+		}
+		if s := extractSystemText(block.Content); s != "" {
+			text.WriteString("\n" + s)
+		} else if s, ok := block.Content.(string); ok {
+			text.WriteString("\n" + s)
+		}
+	default:
+		if block.Content != nil {
+			text.WriteString("\n" + stringifyContent(block.Content))
+		}
+	}
+	return text.String(), nil
+}
@@ -0,0 +1,146 @@
+package converters
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"ai_gateway/internal/models"
+)
+
+// ErrUnsupportedFields is returned when strict conversion rejects a request
+// holding fields that the upstream protocol cannot represent
+var ErrUnsupportedFields = errors.New("unsupported request fields")
+
+// UnsupportedFieldsError explains which request fields an upstream protocol
+// cannot carry, or returns nil when there are none
+func UnsupportedFieldsError(protocol string, fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s cannot represent %s; remove them or disable strict conversion",
+		ErrUnsupportedFields, protocolNames[protocol], strings.Join(fields, ", "))
+}
+
+// SupportsLogitBias reports whether requests to protocol can carry logit_bias;
+// only chat completions upstreams accept it
+func SupportsLogitBias(protocol string) bool {
+	return protocol == "openai_chat"
+}
+
+// OpenAIChatUnsupportedFields lists the fields of a chat completions request
+// that converting it for protocol would silently drop
+func OpenAIChatUnsupportedFields(req *models.ChatCompletionRequest, protocol string) []string {
+	if protocol == "openai_chat" {
+		return nil
+	}
+	var fields []string
+	check := func(name string, set bool) {
+		if set {
+			fields = append(fields, name)
+		}
+	}
+
+	check("top_k", req.TopK != nil && protocol != "anthropic")
+	check("n", req.N != nil && *req.N != 1 && protocol != "gemini")
+	check("presence_penalty", req.PresencePenalty != nil && protocol != "gemini")
+	check("frequency_penalty", req.FrequencyPenalty != nil && protocol != "gemini")
+	check("logit_bias", len(req.LogitBias) > 0 && !SupportsLogitBias(protocol))
+	if protocol == "openai_code" {
+		return fields
+	}
+	check("seed", req.Seed != nil)
+	check("logprobs", req.LogProbs != nil && *req.LogProbs)
+	check("top_logprobs", req.TopLogProbs != nil)
+	check("reasoning_effort", req.ReasoningEffort != "")
+	check("verbosity", req.Verbosity != "")
+	if protocol == "gemini" {
+		check("user", req.User != "")
+		check("tool_choice", req.ToolChoice != nil)
+		check("parallel_tool_calls", req.ParallelToolCalls != nil && !*req.ParallelToolCalls)
+	}
+	return fields
+}
+
+// OpenAIResponsesUnsupportedFields lists the fields of a Responses API request
+// that converting it for protocol would silently drop. Requests reach other
+// protocols as chat completions, so chatReq is the converted request.
+func OpenAIResponsesUnsupportedFields(body map[string]interface{}, chatReq *models.ChatCompletionRequest, protocol string) []string {
+	if protocol == "openai_code" {
+		return nil
+	}
+	var fields []string
+	for _, key := range []string{"previous_response_id", "include", "truncation", "max_tool_calls", "prompt"} {
+		if value, ok := body[key]; ok && value != nil {
+			fields = append(fields, key)
+		}
+	}
+	// Report the chat fields under the names the client sent them as
+	renamed := map[string]string{"reasoning_effort": "reasoning.effort", "verbosity": "text.verbosity"}
+	for _, field := range OpenAIChatUnsupportedFields(chatReq, protocol) {
+		if name, ok := renamed[field]; ok {
+			field = name
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// AnthropicUnsupportedFields lists the fields of an Anthropic messages request
+// that converting it for protocol would silently drop
+func AnthropicUnsupportedFields(req *models.MessagesRequest, protocol string) []string {
+	var fields []string
+	switch protocol {
+	case "openai_code":
+		if req.TopK != nil {
+			fields = append(fields, "top_k")
+		}
+		if len(req.StopSequences) > 0 {
+			fields = append(fields, "stop_sequences")
+		}
+		if req.ToolChoice != nil {
+			fields = append(fields, "tool_choice")
+		}
+	case "gemini":
+		if req.ToolChoice != nil {
+			fields = append(fields, "tool_choice")
+		}
+		if req.Metadata != nil && req.Metadata.UserID != "" {
+			fields = append(fields, "metadata.user_id")
+		}
+	}
+	return fields
+}
+
+// GeminiUnsupportedFields lists the fields of a Gemini generateContent request
+// that converting it for protocol would silently drop
+func GeminiUnsupportedFields(req *models.GenerateContentRequest, protocol string) []string {
+	if protocol == "gemini" {
+		return nil
+	}
+	var fields []string
+	if config := req.GenerationConfig; config != nil {
+		if config.TopK != nil && protocol != "anthropic" {
+			fields = append(fields, "generationConfig.topK")
+		}
+		if config.PresencePenalty != nil && protocol != "openai_chat" {
+			fields = append(fields, "generationConfig.presencePenalty")
+		}
+		if config.FrequencyPenalty != nil && protocol != "openai_chat" {
+			fields = append(fields, "generationConfig.frequencyPenalty")
+		}
+		if config.CandidateCount != nil && *config.CandidateCount != 1 && protocol != "openai_chat" {
+			fields = append(fields, "generationConfig.candidateCount")
+		}
+		if config.ResponseMimeType != "" && config.ResponseMimeType != "text/plain" {
+			fields = append(fields, "generationConfig.responseMimeType")
+		}
+	}
+	if req.ToolConfig != nil {
+		fields = append(fields, "toolConfig")
+	}
+	if len(req.SafetySettings) > 0 {
+		fields = append(fields, "safetySettings")
+	}
+	return fields
+}
@@ -0,0 +1,493 @@
+package converters
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"ai_gateway/internal/models"
+)
+
+// maxToolDescriptionLength is the longest function description, in characters,
+// each upstream protocol accepts
+var maxToolDescriptionLength = map[string]int{
+	"openai_chat": 1024,
+	"gemini":      1024,
+}
+
+// geminiSchemaKeywords are the JSON Schema keywords Gemini's OpenAPI-style
+// function parameter schemas accept
+var geminiSchemaKeywords = map[string]bool{
+	"type": true, "format": true, "title": true, "description": true, "nullable": true,
+	"enum": true, "items": true, "properties": true, "required": true, "anyOf": true,
+	"propertyOrdering": true, "minItems": true, "maxItems": true, "minProperties": true,
+	"maxProperties": true, "minLength": true, "maxLength": true, "pattern": true,
+	"minimum": true, "maximum": true,
+}
+
+// maxSchemaRefDepth bounds how deeply $refs are inlined, so recursive schemas
+// terminate
+const maxSchemaRefDepth = 8
+
+// SanitizeGeminiTools fits the function declarations of a Gemini request to
+// what Gemini accepts: long descriptions are truncated and parameter schemas
+// are rewritten to its subset of JSON Schema. Local $refs are inlined, oneOf
+// becomes anyOf, allOf is merged, const becomes enum and type lists become
+// nullable types; other keywords are removed. Schemas are copied, never
+// modified in place. It returns a warning per declaration changed.
+func SanitizeGeminiTools(tools []models.GeminiTool) []string {
+	var warnings []string
+	for i := range tools {
+		for j := range tools[i].FunctionDeclarations {
+			decl := &tools[i].FunctionDeclarations[j]
+			var notes []string
+			if note, ok := truncateToolDescription(&decl.Description, "gemini"); ok {
+				notes = append(notes, note)
+			}
+			if decl.Parameters != nil {
+				s := &geminiSchemaSanitizer{root: decl.Parameters, removed: map[string]bool{}, converted: map[string]bool{}}
+				decl.Parameters = s.sanitize(decl.Parameters, 0)
+				notes = append(notes, s.notes()...)
+			}
+			if len(notes) > 0 {
+				warnings = append(warnings, decl.Name+": "+strings.Join(notes, "; "))
+			}
+		}
+	}
+	return warnings
+}
+
+// SanitizeOpenAIChatTools truncates function descriptions of a chat completions
+// request that exceed the limit of the upstream, returning a warning per tool
+// changed
+func SanitizeOpenAIChatTools(tools []models.Tool) []string {
+	var warnings []string
+	for i := range tools {
+		if note, ok := truncateToolDescription(&tools[i].Function.Description, "openai_chat"); ok {
+			warnings = append(warnings, tools[i].Function.Name+": "+note)
+		}
+	}
+	return warnings
+}
+
+// truncateToolDescription shortens description to the limit of protocol,
+// describing the change
+func truncateToolDescription(description *string, protocol string) (string, bool) {
+	limit := maxToolDescriptionLength[protocol]
+	length := utf8.RuneCountInString(*description)
+	if limit <= 0 || length <= limit {
+		return "", false
+	}
+	*description = string([]rune(*description)[:limit])
+	return fmt.Sprintf("description truncated from %d to %d characters", length, limit), true
+}
+
+// geminiSchemaSanitizer rewrites one parameter schema, recording what it did
+type geminiSchemaSanitizer struct {
+	root      interface{}
+	removed   map[string]bool // keywords dropped
+	converted map[string]bool // keywords rewritten to supported ones
+}
+
+// notes describes the changes made, in a stable order
+func (s *geminiSchemaSanitizer) notes() []string {
+	var notes []string
+	if len(s.converted) > 0 {
+		notes = append(notes, "converted "+sortedKeys(s.converted))
+	}
+	if len(s.removed) > 0 {
+		notes = append(notes, "removed "+sortedKeys(s.removed))
+	}
+	return notes
+}
+
+func (s *geminiSchemaSanitizer) sanitize(node interface{}, refDepth int) interface{} {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return node
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		s.converted["$ref"] = true
+		target, found := resolveSchemaRef(s.root, ref)
+		if !found || refDepth >= maxSchemaRefDepth {
+			// Unresolvable or recursive beyond the bound; keep a permissive object
+			return map[string]interface{}{"type": "object"}
+		}
+		return s.sanitize(target, refDepth+1)
+	}
+
+	out := make(map[string]interface{}, len(schema))
+	for key, value := range schema {
+		switch key {
+		case "properties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				s.removed[key] = true
+				continue
+			}
+			sanitized := make(map[string]interface{}, len(props))
+			for name, prop := range props {
+				sanitized[name] = s.sanitize(prop, refDepth)
+			}
+			out[key] = sanitized
+		case "items":
+			if _, ok := value.(map[string]interface{}); !ok {
+				// Tuple items have no Gemini equivalent
+				s.removed[key] = true
+				continue
+			}
+			out[key] = s.sanitize(value, refDepth)
+		case "anyOf", "oneOf":
+			if key == "oneOf" {
+				s.converted[key] = true
+			}
+			out["anyOf"] = s.sanitizeList(value, refDepth)
+		case "allOf":
+			// Merged below, once the schema's own keywords are in place
+			s.converted[key] = true
+		case "const":
+			s.converted[key] = true
+			out["enum"] = []interface{}{value}
+		case "type":
+			out[key] = value
+			if types, ok := value.([]interface{}); ok {
+				s.converted["type arrays"] = true
+				s.convertTypeList(out, types)
+			}
+		default:
+			if geminiSchemaKeywords[key] {
+				out[key] = value
+			} else {
+				s.removed[key] = true
+			}
+		}
+	}
+
+	if allOf, ok := schema["allOf"]; ok {
+		for _, sub := range s.sanitizeList(allOf, refDepth) {
+			if subSchema, ok := sub.(map[string]interface{}); ok {
+				mergeSchema(out, subSchema)
+			}
+		}
+	}
+
+	// Gemini enums hold strings only
+	if enum, ok := out["enum"].([]interface{}); ok {
+		for _, value := range enum {
+			if _, isString := value.(string); !isString {
+				delete(out, "enum")
+				s.removed["non-string enum"] = true
+				break
+			}
+		}
+	}
+	return out
+}
+
+func (s *geminiSchemaSanitizer) sanitizeList(value interface{}, refDepth int) []interface{} {
+	list, _ := value.([]interface{})
+	out := make([]interface{}, 0, len(list))
+	for _, item := range list {
+		out = append(out, s.sanitize(item, refDepth))
+	}
+	return out
+}
+
+// convertTypeList replaces a JSON Schema type list with a single nullable type
+// or, for several non-null types, an anyOf of them
+func (s *geminiSchemaSanitizer) convertTypeList(out map[string]interface{}, types []interface{}) {
+	var kinds []interface{}
+	for _, t := range types {
+		if t == "null" {
+			out["nullable"] = true
+		} else {
+			kinds = append(kinds, t)
+		}
+	}
+	switch len(kinds) {
+	case 0:
+		delete(out, "type")
+	case 1:
+		out["type"] = kinds[0]
+	default:
+		delete(out, "type")
+		anyOf := make([]interface{}, 0, len(kinds))
+		for _, kind := range kinds {
+			anyOf = append(anyOf, map[string]interface{}{"type": kind})
+		}
+		out["anyOf"] = anyOf
+	}
+}
+
+// mergeSchema folds an allOf member into out: properties and required are
+// combined, and other keywords already present are kept
+func mergeSchema(out, sub map[string]interface{}) {
+	for key, value := range sub {
+		switch key {
+		case "properties":
+			props, _ := out[key].(map[string]interface{})
+			if props == nil {
+				props = map[string]interface{}{}
+			}
+			if subProps, ok := value.(map[string]interface{}); ok {
+				for name, prop := range subProps {
+					props[name] = prop
+				}
+			}
+			out[key] = props
+		case "required":
+			existing, _ := out[key].([]interface{})
+			required := append([]interface{}{}, existing...)
+			seen := map[interface{}]bool{}
+			for _, name := range required {
+				seen[name] = true
+			}
+			if subRequired, ok := value.([]interface{}); ok {
+				for _, name := range subRequired {
+					if !seen[name] {
+						seen[name] = true
+						required = append(required, name)
+					}
+				}
+			}
+			out[key] = required
+		default:
+			if _, exists := out[key]; !exists {
+				out[key] = value
+			}
+		}
+	}
+}
+
+// resolveSchemaRef follows a local "#/..." reference within root
+func resolveSchemaRef(root interface{}, ref string) (interface{}, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+	node := root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func sortedKeys(set map[string]bool) string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
+
+// TranslateToolSchema translates the parameter schema of a tool from the
+// schema dialect of protocol from to that of protocol to. Gemini's OpenAPI
+// style (upper-case types, nullable) becomes JSON Schema, Anthropic gets the
+// object schema its input_schema requires, Gemini drops parameter objects
+// without properties, which it rejects, and strict tools reaching OpenAI get
+// the shape its strict mode requires. The schema is copied when changed.
+func TranslateToolSchema(schema interface{}, from, to string, strict bool) interface{} {
+	if from == "gemini" && to != "gemini" {
+		schema = geminiSchemaToJSONSchema(schema)
+	}
+	switch to {
+	case "anthropic":
+		object, ok := schema.(map[string]interface{})
+		if !ok {
+			return map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		if _, ok := object["type"]; !ok {
+			object = copySchema(object)
+			object["type"] = "object"
+			return object
+		}
+	case "gemini":
+		if object, ok := schema.(map[string]interface{}); ok {
+			props, _ := object["properties"].(map[string]interface{})
+			if len(props) == 0 && (object["type"] == "object" || object["type"] == "OBJECT") {
+				return nil
+			}
+		}
+	case "openai_chat", "openai_code":
+		if strict && from != "openai_chat" && from != "openai_code" {
+			return strictJSONSchema(schema)
+		}
+	}
+	return schema
+}
+
+// geminiSchemaToJSONSchema copies a Gemini parameter schema as JSON Schema
+func geminiSchemaToJSONSchema(node interface{}) interface{} {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return node
+	}
+	out := make(map[string]interface{}, len(schema))
+	for key, value := range schema {
+		switch key {
+		case "type":
+			if t, ok := value.(string); ok && t != "TYPE_UNSPECIFIED" {
+				out[key] = strings.ToLower(t)
+			}
+		case "nullable", "propertyOrdering":
+			// Folded into type below, or meaningless to JSON Schema
+		case "format":
+			// Gemini marks string enums with format "enum"
+			if value != "enum" {
+				out[key] = value
+			}
+		case "properties":
+			props, _ := value.(map[string]interface{})
+			converted := make(map[string]interface{}, len(props))
+			for name, prop := range props {
+				converted[name] = geminiSchemaToJSONSchema(prop)
+			}
+			out[key] = converted
+		case "items":
+			out[key] = geminiSchemaToJSONSchema(value)
+		case "anyOf":
+			list, _ := value.([]interface{})
+			converted := make([]interface{}, 0, len(list))
+			for _, item := range list {
+				converted = append(converted, geminiSchemaToJSONSchema(item))
+			}
+			out[key] = converted
+		default:
+			out[key] = value
+		}
+	}
+	if nullable, _ := schema["nullable"].(bool); nullable {
+		makeNullable(out)
+	}
+	return out
+}
+
+// strictJSONSchema copies a schema into the shape OpenAI's strict mode
+// requires: every object closes additionalProperties and requires all of its
+// properties, and properties that were optional become nullable instead
+func strictJSONSchema(node interface{}) interface{} {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return node
+	}
+	out := copySchema(schema)
+	for _, key := range []string{"$defs", "definitions"} {
+		if defs, ok := schema[key].(map[string]interface{}); ok {
+			converted := make(map[string]interface{}, len(defs))
+			for name, def := range defs {
+				converted[name] = strictJSONSchema(def)
+			}
+			out[key] = converted
+		}
+	}
+	if items, ok := schema["items"]; ok {
+		out["items"] = strictJSONSchema(items)
+	}
+	for _, key := range []string{"anyOf", "oneOf", "allOf"} {
+		if list, ok := schema[key].([]interface{}); ok {
+			converted := make([]interface{}, 0, len(list))
+			for _, item := range list {
+				converted = append(converted, strictJSONSchema(item))
+			}
+			out[key] = converted
+		}
+	}
+
+	props, hasProps := schema["properties"].(map[string]interface{})
+	if !hasProps && !schemaHasType(schema, "object") {
+		return out
+	}
+	required := map[string]bool{}
+	if list, ok := schema["required"].([]interface{}); ok {
+		for _, name := range list {
+			if s, ok := name.(string); ok {
+				required[s] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	converted := make(map[string]interface{}, len(props))
+	allRequired := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		prop := strictJSONSchema(props[name])
+		if propSchema, ok := prop.(map[string]interface{}); ok && !required[name] {
+			makeNullable(propSchema)
+		}
+		converted[name] = prop
+		allRequired = append(allRequired, name)
+	}
+	out["properties"] = converted
+	out["required"] = allRequired
+	out["additionalProperties"] = false
+	return out
+}
+
+// makeNullable lets a schema, which the caller owns, also accept null
+func makeNullable(schema map[string]interface{}) {
+	switch t := schema["type"].(type) {
+	case string:
+		if t != "null" {
+			schema["type"] = []interface{}{t, "null"}
+		}
+	case []interface{}:
+		if !schemaHasType(schema, "null") {
+			schema["type"] = append(append([]interface{}{}, t...), "null")
+		}
+	default:
+		if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+			schema["anyOf"] = append(append([]interface{}{}, anyOf...), map[string]interface{}{"type": "null"})
+		} else if ref, ok := schema["$ref"]; ok {
+			delete(schema, "$ref")
+			schema["anyOf"] = []interface{}{map[string]interface{}{"$ref": ref}, map[string]interface{}{"type": "null"}}
+		}
+	}
+	// An enum admits null only when it lists it
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		for _, value := range enum {
+			if value == nil {
+				return
+			}
+		}
+		schema["enum"] = append(append([]interface{}{}, enum...), nil)
+	}
+}
+
+// schemaHasType reports whether a schema's type is or includes kind
+func schemaHasType(schema map[string]interface{}, kind string) bool {
+	switch t := schema["type"].(type) {
+	case string:
+		return t == kind
+	case []interface{}:
+		for _, item := range t {
+			if item == kind {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// copySchema returns a shallow copy of a schema object
+func copySchema(schema map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(schema))
+	for key, value := range schema {
+		out[key] = value
+	}
+	return out
+}
@@ -21,8 +21,37 @@ func Init(dbPath string) (*gorm.DB, error) {
 	if err := db.AutoMigrate(
 		&User{},
 		&ProviderConfig{},
+		&ProviderConfigRevision{},
 		&APIKey{},
 		&UsageRecord{},
+		&NotificationPreference{},
+		&ProviderSLO{},
+		&ArchiveObject{},
+		&HedgePolicy{},
+		&CascadePolicy{},
+		&ContextUpgradePolicy{},
+		&RoutingPolicy{},
+		&MCPServer{},
+		&ContentFilterRule{},
+		&EndUser{},
+		&StripeBilling{},
+		&StripeUsageExport{},
+		&ProviderUsageSync{},
+		&ProviderUsageDay{},
+		&CreditAccount{},
+		&CreditLedgerEntry{},
+		&ModelPrice{},
+		&Maintenance{},
+		&AuditLogEntry{},
+		&Session{},
+		&IdempotencyRecord{},
+		&RequestSignature{},
+		&RequestLog{},
+		&CanaryHit{},
+		&AssistantObject{},
+		&StoredResponse{},
+		&Conversation{},
+		&ConversationMessage{},
 	); err != nil {
 		return nil, err
 	}
@@ -1,7 +1,10 @@
 package database
 
 import (
+	"errors"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // User represents a user account
@@ -12,6 +15,7 @@ type User struct {
 	HashedPassword  string           `gorm:"size:100;not null" json:"-"`
 	IsActive        bool             `gorm:"default:true" json:"is_active"`
 	IsAdmin         bool             `gorm:"default:false" json:"is_admin"`
+	DeletionDueAt   *time.Time       `json:"deletion_due_at"` // account and all its data are purged after this time
 	CreatedAt       time.Time        `json:"created_at"`
 	UpdatedAt       time.Time        `json:"updated_at"`
 	ProviderConfigs []ProviderConfig `gorm:"foreignKey:UserID" json:"-"`
@@ -24,6 +28,7 @@ type ProviderConfig struct {
 	UserID       uint      `gorm:"index;not null" json:"user_id"`
 	Provider     string    `gorm:"size:20;index;not null" json:"provider"` // openai, anthropic, gemini, custom
 	Protocol     string    `gorm:"size:20;default:openai_chat" json:"protocol"`
+	Region       string    `gorm:"size:50" json:"region"` // e.g. us-east, eu-west; used by latency routing
 	Name         string    `gorm:"size:100;not null" json:"name"`
 	BaseURL      string    `gorm:"size:255" json:"base_url"`
 	EncryptedKey string    `gorm:"size:500;not null" json:"-"`
@@ -35,6 +40,29 @@ type ProviderConfig struct {
 	UpdatedAt    time.Time `json:"updated_at"`
 	User         User      `gorm:"foreignKey:UserID" json:"-"`
 	APIKeys      []APIKey  `gorm:"many2many:api_key_providers;" json:"-"`
+
+	// Reasoning settings for OpenAI Responses requests; empty values leave them to the client
+	ReasoningEffort   string `gorm:"size:20" json:"reasoning_effort"`         // minimal, low, medium, high
+	ReasoningSummary  string `gorm:"size:20" json:"reasoning_summary"`        // auto, concise, detailed
+	ReasoningOverride bool   `gorm:"default:false" json:"reasoning_override"` // apply them over the client's own settings
+
+	// MaxOutputTokens overrides the output ceiling of the config's models; nil uses their published limits
+	MaxOutputTokens *int `json:"max_output_tokens"`
+
+	// Monthly spend caps across all API keys using the config; nil caps are unlimited
+	MonthlyTokenCap   *int      `json:"monthly_token_cap"`
+	MonthlyCostCap    *float64  `json:"monthly_cost_cap"` // credits, at the model prices
+	MonthlyTokensUsed int       `gorm:"default:0" json:"monthly_tokens_used"`
+	MonthlyCostMicros int64     `gorm:"default:0" json:"monthly_cost_micros"`
+	MonthlyResetAt    time.Time `json:"monthly_reset_at"`
+
+	// Activation schedule: a condition on hour, weekday and time in ScheduleTimezone.
+	// The scheduler activates the config when it starts to hold and deactivates it
+	// when it stops; in between IsActive may be toggled by hand. Empty leaves
+	// IsActive to the user.
+	ActiveSchedule   string `gorm:"size:500" json:"active_schedule"`
+	ScheduleTimezone string `gorm:"size:50" json:"schedule_timezone"`
+	ScheduledActive  *bool  `json:"-"` // schedule state last applied; nil until the next evaluation
 }
 
 // APIKey represents a gateway-issued API key
@@ -54,6 +82,24 @@ type APIKey struct {
 	MonthlyRequestsUsed int              `gorm:"default:0" json:"monthly_requests_used"`
 	DailyTokensUsed     int              `gorm:"default:0" json:"daily_tokens_used"`
 	MonthlyTokensUsed   int              `gorm:"default:0" json:"monthly_tokens_used"`
+	RoutingStrategy     string           `gorm:"size:20;default:ordered" json:"routing_strategy"` // ordered, latency
+	ArchiveEnabled      bool             `gorm:"default:false" json:"archive_enabled"`            // tee request/response bodies to the compliance archive
+	BodyLogDisabled     bool             `gorm:"default:false" json:"body_log_disabled"`          // keep request bodies out of the log
+	SchemaRepairRetries int              `gorm:"default:0" json:"schema_repair_retries"`          // repair attempts for output failing a declared JSON schema, 0 disables
+	LatencyBudgetMs     int              `gorm:"default:0" json:"latency_budget_ms"`              // end-to-end deadline for gateway requests, 0 disables
+	AllowConfigOverride bool             `gorm:"default:false" json:"allow_config_override"`      // honor the X-Provider-Config request header
+	CompressionTokens   int              `gorm:"default:0" json:"compression_tokens"`             // estimated history tokens above which older turns are summarized, 0 disables
+	CompressionModel    string           `gorm:"size:100" json:"compression_model"`               // model writing the summaries
+	KeepRequestedModel  bool             `gorm:"default:false" json:"keep_requested_model"`       // report the requested model name in responses, not the upstream one
+	StrictConversion    bool             `gorm:"default:false" json:"strict_conversion"`          // reject request fields the upstream protocol cannot represent
+	ValidateToolArgs    bool             `gorm:"default:false" json:"validate_tool_args"`         // check streamed tool-call arguments are valid JSON
+	MaxResponseTokens   int              `gorm:"default:0" json:"max_response_tokens"`            // completion tokens returned at most, 0 disables
+	ForbiddenLanguages  string           `gorm:"size:100" json:"forbidden_languages"`             // comma-separated ISO 639-1 codes responses must not be in
+	ResponseSuffix      string           `gorm:"size:1000" json:"response_suffix"`                // appended to every response, such as a disclaimer
+	InjectionDetection  bool             `gorm:"default:false" json:"injection_detection"`        // score user content for prompt injection
+	InjectionThreshold  float64          `gorm:"default:0" json:"injection_threshold"`            // score from which requests are refused, 0 only scores
+	SalvageStreams      bool             `gorm:"default:false" json:"salvage_streams"`            // complete streams an upstream cuts off with what was received, flagged partial
+	AutoContinueTokens  int              `gorm:"default:0" json:"auto_continue_tokens"`           // completion tokens responses cut off at max tokens are continued up to, 0 disables
 	DailyResetAt        time.Time        `json:"daily_reset_at"`
 	MonthlyResetAt      time.Time        `json:"monthly_reset_at"`
 	CreatedAt           time.Time        `json:"created_at"`
@@ -61,6 +107,29 @@ type APIKey struct {
 	User                User             `gorm:"foreignKey:UserID" json:"-"`
 	ProviderConfigs     []ProviderConfig `gorm:"many2many:api_key_providers;" json:"-"`
 	UsageRecords        []UsageRecord    `gorm:"foreignKey:APIKeyID" json:"-"`
+
+	// Secret of HMAC-signed requests; a key with one authenticates only with
+	// signed requests, never by sending the key itself
+	EncryptedSigningSecret string `gorm:"size:500" json:"-"`
+
+	// A canary key is never meant to be used: it is planted where leaked
+	// credentials would be found, every request with it is rejected as if the
+	// key did not exist, and its owner is alerted
+	Canary           bool   `gorm:"default:false" json:"canary"`
+	CanaryWebhookURL string `gorm:"size:500" json:"canary_webhook_url"`
+	CanaryEmail      bool   `gorm:"default:false" json:"canary_email"` // email the owner when the key is used
+}
+
+// CanaryHit records a request made with a canary API key
+type CanaryHit struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	APIKeyID  uint      `gorm:"index;not null" json:"api_key_id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	IP        string    `gorm:"size:64" json:"ip"`
+	UserAgent string    `gorm:"size:255" json:"user_agent"`
+	Method    string    `gorm:"size:10" json:"method"`
+	Path      string    `gorm:"size:255" json:"path"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
 }
 
 // UsageRecord represents an API usage record
@@ -73,10 +142,438 @@ type UsageRecord struct {
 	CompletionTokens int       `json:"completion_tokens"`
 	TotalTokens      int       `json:"total_tokens"`
 	StatusCode       int       `json:"status_code"`
+	Tags             string    `gorm:"size:700" json:"tags"` // comma-separated attribution tags supplied by the client
+	EndUserID        *uint     `gorm:"index" json:"end_user_id,omitempty"`
+	ProviderConfigID *uint     `gorm:"index" json:"provider_config_id,omitempty"`
+	RequestID        string    `gorm:"index;size:64" json:"request_id"`
+	Estimated        bool      `json:"estimated"` // token counts estimated by the gateway because the upstream reported none
 	CreatedAt        time.Time `gorm:"index" json:"created_at"`
 	APIKey           APIKey    `gorm:"foreignKey:APIKeyID" json:"-"`
 }
 
+// IdempotencyRecord stores a completed response for an Idempotency-Key
+type IdempotencyRecord struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	Scope          string    `gorm:"uniqueIndex:idx_idempotency_scope_key;size:50;not null" json:"scope"` // key:<id> or user:<id>
+	IdempotencyKey string    `gorm:"uniqueIndex:idx_idempotency_scope_key;size:255;not null" json:"idempotency_key"`
+	Method         string    `gorm:"size:10" json:"method"`
+	Path           string    `gorm:"size:255" json:"path"`
+	RequestHash    string    `gorm:"size:64;not null" json:"-"`
+	StatusCode     int       `json:"status_code"`
+	ContentType    string    `gorm:"size:100" json:"content_type"`
+	ResponseBody   []byte    `json:"-"`
+	ExpiresAt      time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// RequestSignature remembers the signature of an HMAC-signed request until its
+// timestamp leaves the accepted window, so that the request cannot be replayed
+type RequestSignature struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	APIKeyID  uint      `gorm:"uniqueIndex:idx_request_signature;not null" json:"api_key_id"`
+	Signature string    `gorm:"uniqueIndex:idx_request_signature;size:64;not null" json:"signature"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RequestLog is the debug log record of a gateway request whose body may be
+// logged. A streamed response is kept as the assistant message assembled from
+// its chunks rather than as the chunks themselves.
+type RequestLog struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"index;not null" json:"user_id"`
+	APIKeyID     *uint     `gorm:"index" json:"api_key_id,omitempty"`
+	TraceID      string    `gorm:"index;size:32" json:"trace_id"`
+	Method       string    `gorm:"size:10" json:"method"`
+	Path         string    `gorm:"size:255" json:"path"`
+	Model        string    `gorm:"size:100" json:"model"`
+	StatusCode   int       `json:"status_code"`
+	DurationMs   int64     `json:"duration_ms"`
+	RequestBody  string    `gorm:"type:text" json:"request_body"`  // redacted
+	ResponseBody string    `gorm:"type:text" json:"response_body"` // redacted; the assembled message of a streamed response
+	Streamed     bool      `json:"streamed"`
+	Chunks       int       `json:"chunks"`    // data events of a streamed response
+	Truncated    bool      `json:"truncated"` // a body exceeded REQUEST_LOG_MAX_BODY_BYTES
+	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+
+	// Prompt injection score of the user content, for keys with detection on
+	InjectionScore *float64 `json:"injection_score,omitempty"`
+}
+
+// AssistantObject records which caller created an upstream assistant or thread
+type AssistantObject struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Scope      string    `gorm:"index;size:50;not null" json:"scope"` // key:<id> or user:<id>
+	ObjectType string    `gorm:"size:20;not null" json:"object_type"` // assistant, thread
+	ObjectID   string    `gorm:"uniqueIndex;size:100;not null" json:"object_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// StoredResponse tracks a Responses API response that can be retrieved by ID:
+// one stored by an OpenAI Responses upstream, which is then read from the
+// config it was created on, or a background response the gateway ran itself.
+type StoredResponse struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	ResponseID       string    `gorm:"uniqueIndex;size:100;not null" json:"response_id"`
+	Scope            string    `gorm:"index;size:50;not null" json:"scope"` // key:<id> or user:<id>
+	ProviderConfigID *uint     `json:"provider_config_id"`                  // upstream config of a passthrough response
+	Background       bool      `gorm:"default:false" json:"background"`
+	Emulated         bool      `gorm:"default:false" json:"emulated"` // run by the gateway
+	Model            string    `gorm:"size:100" json:"model"`
+	Status           string    `gorm:"size:20;index" json:"status"` // queued, in_progress, completed, incomplete, failed, cancelled
+	Response         string    `gorm:"type:text" json:"response"`   // final response object of an emulated response
+	Error            string    `gorm:"size:500" json:"error"`
+	UsageRecorded    bool      `gorm:"default:false" json:"usage_recorded"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Conversation is a server-side chat history owned by an API key or user
+type Conversation struct {
+	ID        uint                  `gorm:"primaryKey" json:"-"`
+	PublicID  string                `gorm:"uniqueIndex;size:40;not null" json:"id"`
+	Scope     string                `gorm:"index;size:50;not null" json:"-"` // key:<id> or user:<id>
+	Title     string                `gorm:"size:200" json:"title"`
+	Model     string                `gorm:"size:100" json:"model"`
+	CreatedAt time.Time             `json:"created_at"`
+	UpdatedAt time.Time             `json:"updated_at"`
+	Messages  []ConversationMessage `gorm:"foreignKey:ConversationID" json:"messages,omitempty"`
+}
+
+// ConversationMessage is one stored turn; Message holds the JSON-encoded OpenAI chat message
+type ConversationMessage struct {
+	ID             uint      `gorm:"primaryKey" json:"-"`
+	ConversationID uint      `gorm:"index;not null" json:"-"`
+	Role           string    `gorm:"size:20;not null" json:"role"`
+	Message        string    `gorm:"type:text;not null" json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ProviderConfigRevision is a snapshot of a provider config taken after each change
+type ProviderConfigRevision struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	ProviderConfigID uint      `gorm:"index;not null" json:"provider_config_id"`
+	ChangedBy        uint      `gorm:"not null" json:"changed_by"`     // user ID
+	Action           string    `gorm:"size:20;not null" json:"action"` // create, update, toggle, schedule, rollback
+	ChangedFields    string    `gorm:"size:255" json:"changed_fields"` // comma-separated field names
+	Name             string    `gorm:"size:100" json:"name"`
+	BaseURL          string    `gorm:"size:255" json:"base_url"`
+	Protocol         string    `gorm:"size:20" json:"protocol"`
+	Region           string    `gorm:"size:50" json:"region"`
+	EncryptedKey     string    `gorm:"size:500" json:"-"`
+	KeyHint          string    `gorm:"size:20" json:"key_hint"`
+	ModelCodes       string    `gorm:"type:text" json:"model_codes"`
+	IsActive         bool      `json:"is_active"`
+	CreatedAt        time.Time `gorm:"index" json:"created_at"`
+
+	ReasoningEffort   string `gorm:"size:20" json:"reasoning_effort"`
+	ReasoningSummary  string `gorm:"size:20" json:"reasoning_summary"`
+	ReasoningOverride bool   `json:"reasoning_override"`
+
+	MaxOutputTokens *int `json:"max_output_tokens"`
+
+	MonthlyTokenCap *int     `json:"monthly_token_cap"`
+	MonthlyCostCap  *float64 `json:"monthly_cost_cap"`
+
+	ActiveSchedule   string `gorm:"size:500" json:"active_schedule"`
+	ScheduleTimezone string `gorm:"size:50" json:"schedule_timezone"`
+}
+
+// NotificationPreference stores a user's email notification opt-ins
+type NotificationPreference struct {
+	ID               uint       `gorm:"primaryKey" json:"-"`
+	UserID           uint       `gorm:"uniqueIndex;not null" json:"user_id"`
+	WeeklyDigest     bool       `gorm:"default:false" json:"weekly_digest"`
+	UnsubscribeToken string     `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	LastDigestAt     *time.Time `json:"last_digest_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	User             User       `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// ProviderSLO defines availability and latency objectives for a provider config
+type ProviderSLO struct {
+	ID                 uint           `gorm:"primaryKey" json:"id"`
+	ProviderConfigID   uint           `gorm:"uniqueIndex;not null" json:"provider_config_id"`
+	AvailabilityTarget float64        `gorm:"default:0.995" json:"availability_target"` // fraction of non-5xx responses
+	LatencyP95Ms       int            `gorm:"default:0" json:"latency_p95_ms"`          // 0 disables the latency SLO
+	WebhookURL         string         `gorm:"size:500" json:"webhook_url"`
+	AvailabilityFiring bool           `gorm:"default:false" json:"availability_firing"`
+	LatencyFiring      bool           `gorm:"default:false" json:"latency_firing"`
+	CreatedAt          time.Time      `json:"created_at"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+	ProviderConfig     ProviderConfig `gorm:"foreignKey:ProviderConfigID" json:"-"`
+}
+
+// ArchiveObject tracks a request/response archive uploaded to object storage
+type ArchiveObject struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	APIKeyID  uint      `gorm:"index;not null" json:"api_key_id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	ObjectKey string    `gorm:"uniqueIndex;size:500;not null" json:"object_key"`
+	TraceID   string    `gorm:"size:32" json:"trace_id"`
+	SizeBytes int64     `json:"size_bytes"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HedgePolicy enables request hedging for one model requested through a user's API keys
+type HedgePolicy struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	UserID          uint      `gorm:"uniqueIndex:idx_hedge_user_model;not null" json:"user_id"`
+	Model           string    `gorm:"uniqueIndex:idx_hedge_user_model;size:100;not null" json:"model"`
+	DelayMs         int       `gorm:"not null" json:"delay_ms"`            // wait for first bytes before hedging
+	MaxHedgePercent int       `gorm:"default:10" json:"max_hedge_percent"` // cap on hedged requests, % of traffic
+	IsActive        bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// CascadePolicy routes a model alias to a cheap draft model, escalating to an
+// expensive model only when the draft fails the configured checks
+type CascadePolicy struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	UserID        uint      `gorm:"uniqueIndex:idx_cascade_user_model;not null" json:"user_id"`
+	Model         string    `gorm:"uniqueIndex:idx_cascade_user_model;size:100;not null" json:"model"` // alias clients request
+	DraftModel    string    `gorm:"size:100;not null" json:"draft_model"`
+	VerifyModel   string    `gorm:"size:100;not null" json:"verify_model"`
+	MinLength     int       `gorm:"default:0" json:"min_length"`       // minimum draft length in characters, 0 disables
+	RequireJSON   bool      `gorm:"default:false" json:"require_json"` // draft must be valid JSON
+	MinConfidence float64   `gorm:"default:0" json:"min_confidence"`   // minimum self-rated confidence (0-1), 0 disables
+	IsActive      bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// ContextUpgradePolicy switches requests for a model whose estimated prompt
+// exceeds its context window to a larger-context variant instead of failing
+type ContextUpgradePolicy struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	UserID        uint      `gorm:"uniqueIndex:idx_context_upgrade_user_model;not null" json:"user_id"`
+	Model         string    `gorm:"uniqueIndex:idx_context_upgrade_user_model;size:100;not null" json:"model"`
+	UpgradeModel  string    `gorm:"size:100;not null" json:"upgrade_model"` // larger-context variant or alias
+	ContextWindow int       `gorm:"default:0" json:"context_window"`        // prompt tokens above which to upgrade, 0 for the model's known window
+	IsActive      bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// RoutingPolicy is a rule evaluated on each gateway request of a user: the
+// first active policy, by priority, whose condition matches routes the request
+// to a provider config or model, or rejects it
+type RoutingPolicy struct {
+	ID                 uint      `gorm:"primaryKey" json:"id"`
+	UserID             uint      `gorm:"index;not null" json:"user_id"`
+	Name               string    `gorm:"size:64;not null" json:"name"`
+	Priority           int       `gorm:"default:0" json:"priority"`                 // lower is evaluated first
+	Condition          string    `gorm:"type:text" json:"condition"`                // empty matches every request
+	Timezone           string    `gorm:"size:64" json:"timezone"`                   // of hour, weekday and time, UTC by default
+	Action             string    `gorm:"size:20;not null" json:"action"`            // route, reject
+	ProviderConfigID   *uint     `json:"provider_config_id"`                        // route: config to use
+	Model              string    `gorm:"size:100" json:"model"`                     // route: model to request instead
+	KeepRequestedModel bool      `gorm:"default:false" json:"keep_requested_model"` // route: report the requested model name in responses
+	Message            string    `gorm:"size:500" json:"message"`                   // reject: error returned to the client
+	IsActive           bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// MCPServer is a Model Context Protocol server whose tools are offered to the
+// models a user calls through the gateway
+type MCPServer struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	UserID         uint      `gorm:"uniqueIndex:idx_mcp_user_name;not null" json:"user_id"`
+	Name           string    `gorm:"uniqueIndex:idx_mcp_user_name;size:32;not null" json:"name"` // prefix of the tool names
+	URL            string    `gorm:"size:500;not null" json:"url"`                               // Streamable HTTP endpoint
+	EncryptedToken string    `gorm:"size:1000" json:"-"`                                         // bearer token sent to the server
+	IsActive       bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ContentFilterRule filters a user's model output before it is returned to the client
+type ContentFilterRule struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	Name      string    `gorm:"size:64;not null" json:"name"`
+	Type      string    `gorm:"size:20;not null" json:"type"`   // regex, keywords, moderation
+	Pattern   string    `gorm:"type:text" json:"pattern"`       // regex source, or one keyword per line
+	Action    string    `gorm:"size:20;not null" json:"action"` // redact, block, annotate
+	IsActive  bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// EndUser is a customer of an API key's owner, identified by the ID clients send with requests
+type EndUser struct {
+	ID                  uint      `gorm:"primaryKey" json:"id"`
+	APIKeyID            uint      `gorm:"uniqueIndex:idx_end_user_key_external;not null" json:"api_key_id"`
+	ExternalID          string    `gorm:"uniqueIndex:idx_end_user_key_external;size:255;not null" json:"external_id"`
+	IsBlocked           bool      `gorm:"default:false" json:"is_blocked"`
+	DailyRequestLimit   *int      `json:"daily_request_limit"`
+	MonthlyRequestLimit *int      `json:"monthly_request_limit"`
+	DailyTokenLimit     *int      `json:"daily_token_limit"`
+	MonthlyTokenLimit   *int      `json:"monthly_token_limit"`
+	DailyRequestsUsed   int       `gorm:"default:0" json:"daily_requests_used"`
+	MonthlyRequestsUsed int       `gorm:"default:0" json:"monthly_requests_used"`
+	DailyTokensUsed     int       `gorm:"default:0" json:"daily_tokens_used"`
+	MonthlyTokensUsed   int       `gorm:"default:0" json:"monthly_tokens_used"`
+	DailyResetAt        time.Time `json:"daily_reset_at"`
+	MonthlyResetAt      time.Time `json:"monthly_reset_at"`
+	LastSeenAt          time.Time `json:"last_seen_at"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// StripeBilling exports the usage of an API key, or of one of its end users, to
+// the Stripe meter behind a metered price
+type StripeBilling struct {
+	ID                 uint      `gorm:"primaryKey" json:"id"`
+	UserID             uint      `gorm:"index;not null" json:"user_id"`
+	APIKeyID           uint      `gorm:"index;not null" json:"api_key_id"`
+	EndUserID          *uint     `gorm:"index" json:"end_user_id"` // nil exports all usage of the key
+	EncryptedSecretKey string    `gorm:"size:1000;not null" json:"-"`
+	CustomerID         string    `gorm:"size:100;not null" json:"customer_id"`
+	PriceID            string    `gorm:"size:100;not null" json:"price_id"`
+	MeterID            string    `gorm:"size:100" json:"meter_id"`   // resolved from the price
+	EventName          string    `gorm:"size:100" json:"event_name"` // meter event name
+	CustomerPayloadKey string    `gorm:"size:100" json:"-"`
+	ValuePayloadKey    string    `gorm:"size:100" json:"-"`
+	Metric             string    `gorm:"size:30;not null" json:"metric"` // requests, prompt_tokens, completion_tokens, total_tokens
+	UnitSize           int       `gorm:"default:1" json:"unit_size"`     // usage per billed unit, e.g. 1000 tokens
+	CarryUsage         int64     `gorm:"default:0" json:"carry_usage"`   // usage short of a whole unit, exported later
+	SyncedThrough      time.Time `json:"synced_through"`
+	IsActive           bool      `gorm:"default:true" json:"is_active"`
+	LastError          string    `gorm:"size:500" json:"last_error"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// StripeUsageExport is one batch of usage sent to Stripe as a meter event
+type StripeUsageExport struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	BillingID   uint      `gorm:"index;not null" json:"billing_id"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `gorm:"index" json:"period_end"`
+	Usage       int64     `json:"usage"`                                // metric total of the period
+	Quantity    int64     `json:"quantity"`                             // billed units sent
+	Status      string    `gorm:"size:20;not null;index" json:"status"` // pending, sent, failed
+	Attempts    int       `gorm:"default:0" json:"attempts"`
+	Error       string    `gorm:"size:500" json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ProviderUsageSync pulls the usage a provider reports for the upstream key of
+// a provider config from the provider's admin usage API, for reconciliation
+// with the usage the gateway recorded
+type ProviderUsageSync struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	UserID            uint       `gorm:"index;not null" json:"user_id"`
+	ProviderConfigID  uint       `gorm:"uniqueIndex;not null" json:"provider_config_id"`
+	EncryptedAdminKey string     `gorm:"size:1000;not null" json:"-"`
+	UpstreamKeyID     string     `gorm:"size:100" json:"upstream_key_id"` // provider's ID of the config's key; empty counts the whole organization
+	IsActive          bool       `gorm:"default:true" json:"is_active"`
+	LastSyncedAt      *time.Time `json:"last_synced_at"`
+	LastError         string     `gorm:"size:500" json:"last_error"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// ProviderUsageDay is the usage a provider reported for a sync on one UTC day
+type ProviderUsageDay struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	SyncID       uint      `gorm:"uniqueIndex:idx_provider_usage_day;not null" json:"sync_id"`
+	Date         time.Time `gorm:"uniqueIndex:idx_provider_usage_day;not null" json:"date"` // UTC midnight
+	InputTokens  int64     `json:"input_tokens"`                                            // including cached and cache-write tokens
+	OutputTokens int64     `json:"output_tokens"`
+	Requests     *int64    `json:"requests"` // nil when the provider does not report request counts
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// CreditAccount holds the prepaid credit balance of a user. Users without one
+// are not metered; requests are refused while the balance is not positive.
+type CreditAccount struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	UserID        uint      `gorm:"uniqueIndex;not null" json:"user_id"`
+	BalanceMicros int64     `gorm:"not null;default:0" json:"balance_micros"` // millionths of a credit
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// CreditLedgerEntry is one change to a user's credit balance
+type CreditLedgerEntry struct {
+	ID                 uint      `gorm:"primaryKey" json:"id"`
+	UserID             uint      `gorm:"index;not null" json:"user_id"`
+	Type               string    `gorm:"size:20;not null" json:"type"` // grant, adjustment, charge
+	AmountMicros       int64     `gorm:"not null" json:"amount_micros"`
+	BalanceAfterMicros int64     `gorm:"not null" json:"balance_after_micros"`
+	Description        string    `gorm:"size:255" json:"description"`
+	UsageRecordID      *uint     `json:"usage_record_id,omitempty"` // request charged
+	GrantedBy          *uint     `json:"granted_by,omitempty"`      // admin user ID
+	CreatedAt          time.Time `gorm:"index" json:"created_at"`
+}
+
+// ModelPrice is the credit cost of a model's tokens. Model is an exact model
+// name, a prefix ending in "*", or "*" for all models.
+type ModelPrice struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	Model            string    `gorm:"uniqueIndex;size:100;not null" json:"model"`
+	InputPerMillion  float64   `gorm:"not null" json:"input_per_million"`  // credits per 1M prompt tokens
+	OutputPerMillion float64   `gorm:"not null" json:"output_per_million"` // credits per 1M completion tokens
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Maintenance takes gateway traffic offline: the whole gateway, or one provider
+// config when ProviderConfigID is set. It lasts until deleted or until Until.
+type Maintenance struct {
+	ID               uint       `gorm:"primaryKey" json:"id"`
+	ProviderConfigID *uint      `gorm:"index" json:"provider_config_id,omitempty"` // nil for the whole gateway
+	Message          string     `gorm:"size:500" json:"message"`
+	RetryAfter       int        `gorm:"default:0" json:"retry_after"`  // seconds; 0 derives it from Until
+	Failover         bool       `gorm:"default:false" json:"failover"` // route to the API key's other configs instead
+	Until            *time.Time `json:"until,omitempty"`
+	CreatedBy        uint       `json:"created_by"` // admin user ID
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// AuditLogEntry records one management-plane mutation. Entries are append-only
+// and hash-chained: Hash covers the entry and the previous entry's hash.
+type AuditLogEntry struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	ActorID      *uint     `gorm:"index" json:"actor_id"`
+	ActorName    string    `gorm:"size:50" json:"actor_name"`
+	IP           string    `gorm:"size:64" json:"ip"`
+	Action       string    `gorm:"size:150;index;not null" json:"action"` // method and route, e.g. "PUT /api/keys/:id"
+	Path         string    `gorm:"size:255" json:"path"`
+	ResourceType string    `gorm:"size:50;index" json:"resource_type"`
+	ResourceID   string    `gorm:"size:255" json:"resource_id"`
+	StatusCode   int       `json:"status_code"`
+	Request      string    `gorm:"type:text" json:"request,omitempty"` // request body, secrets redacted
+	Before       string    `gorm:"type:text" json:"before,omitempty"`  // resource before the change
+	After        string    `gorm:"type:text" json:"after,omitempty"`   // resource after the change
+	PrevHash     string    `gorm:"size:64" json:"prev_hash"`
+	Hash         string    `gorm:"size:64;not null" json:"hash"`
+	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+}
+
+// Session is a dashboard login. Its PublicID is the ID claim of the JWT issued
+// for it, so revoking the session invalidates the token.
+type Session struct {
+	ID         uint       `gorm:"primaryKey" json:"-"`
+	PublicID   string     `gorm:"uniqueIndex;size:32;not null" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"user_id"`
+	IP         string     `gorm:"size:64" json:"ip"`
+	UserAgent  string     `gorm:"size:255" json:"user_agent"`
+	Device     string     `gorm:"size:100" json:"device"` // e.g. "Chrome on macOS"
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"index" json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
 // TableName overrides the table name for User
 func (User) TableName() string {
 	return "users"
@@ -96,3 +593,148 @@ func (APIKey) TableName() string {
 func (UsageRecord) TableName() string {
 	return "usage_records"
 }
+
+// TableName overrides the table name for IdempotencyRecord
+func (IdempotencyRecord) TableName() string {
+	return "idempotency_records"
+}
+
+// TableName overrides the table name for RequestSignature
+func (RequestSignature) TableName() string {
+	return "request_signatures"
+}
+
+// TableName overrides the table name for RequestLog
+func (RequestLog) TableName() string {
+	return "request_logs"
+}
+
+// TableName overrides the table name for AssistantObject
+func (AssistantObject) TableName() string {
+	return "assistant_objects"
+}
+
+// TableName overrides the table name for StoredResponse
+func (StoredResponse) TableName() string {
+	return "stored_responses"
+}
+
+// TableName overrides the table name for Conversation
+func (Conversation) TableName() string {
+	return "conversations"
+}
+
+// TableName overrides the table name for ConversationMessage
+func (ConversationMessage) TableName() string {
+	return "conversation_messages"
+}
+
+// TableName overrides the table name for ProviderConfigRevision
+func (ProviderConfigRevision) TableName() string {
+	return "provider_config_revisions"
+}
+
+// TableName overrides the table name for NotificationPreference
+func (NotificationPreference) TableName() string {
+	return "notification_preferences"
+}
+
+// TableName overrides the table name for ProviderSLO
+func (ProviderSLO) TableName() string {
+	return "provider_slos"
+}
+
+// TableName overrides the table name for ArchiveObject
+func (ArchiveObject) TableName() string {
+	return "archive_objects"
+}
+
+// TableName overrides the table name for HedgePolicy
+func (HedgePolicy) TableName() string {
+	return "hedge_policies"
+}
+
+// TableName overrides the table name for CascadePolicy
+func (CascadePolicy) TableName() string {
+	return "cascade_policies"
+}
+
+// TableName overrides the table name for ContextUpgradePolicy
+func (ContextUpgradePolicy) TableName() string {
+	return "context_upgrade_policies"
+}
+
+// TableName overrides the table name for RoutingPolicy
+func (RoutingPolicy) TableName() string {
+	return "routing_policies"
+}
+
+// TableName overrides the table name for MCPServer
+func (MCPServer) TableName() string {
+	return "mcp_servers"
+}
+
+// TableName overrides the table name for ContentFilterRule
+func (ContentFilterRule) TableName() string {
+	return "content_filter_rules"
+}
+
+// TableName overrides the table name for EndUser
+func (EndUser) TableName() string {
+	return "end_users"
+}
+
+// TableName overrides the table name for StripeBilling
+func (StripeBilling) TableName() string {
+	return "stripe_billings"
+}
+
+// TableName overrides the table name for StripeUsageExport
+func (StripeUsageExport) TableName() string {
+	return "stripe_usage_exports"
+}
+
+// TableName overrides the table name for ProviderUsageSync
+func (ProviderUsageSync) TableName() string {
+	return "provider_usage_syncs"
+}
+
+// TableName overrides the table name for ProviderUsageDay
+func (ProviderUsageDay) TableName() string {
+	return "provider_usage_days"
+}
+
+// TableName overrides the table name for CreditAccount
+func (CreditAccount) TableName() string {
+	return "credit_accounts"
+}
+
+// TableName overrides the table name for CreditLedgerEntry
+func (CreditLedgerEntry) TableName() string {
+	return "credit_ledger_entries"
+}
+
+// TableName overrides the table name for ModelPrice
+func (ModelPrice) TableName() string {
+	return "model_prices"
+}
+
+// TableName overrides the table name for Session
+func (Session) TableName() string {
+	return "sessions"
+}
+
+// TableName overrides the table name for AuditLogEntry
+func (AuditLogEntry) TableName() string {
+	return "audit_log_entries"
+}
+
+// BeforeUpdate keeps audit log entries immutable
+func (e *AuditLogEntry) BeforeUpdate(tx *gorm.DB) error {
+	return errors.New("audit log entries are immutable")
+}
+
+// BeforeDelete keeps audit log entries immutable
+func (e *AuditLogEntry) BeforeDelete(tx *gorm.DB) error {
+	return errors.New("audit log entries are immutable")
+}
@@ -0,0 +1,199 @@
+// Package events is the gateway's in-process pub/sub bus. Services publish
+// typed lifecycle events on it, and side effects such as live feeds, metrics
+// and logging subscribe to them instead of being called from the request path.
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Event types published on the Bus
+const (
+	RequestCompleted  = "request.completed"  // a gateway request's usage was recorded; Data is a Usage
+	KeyLimitReached   = "key.limit_reached"  // an API key used up a request or token limit; Data is a KeyLimit
+	ProviderUnhealthy = "provider.unhealthy" // health checks started failing for a provider config; Data is a ProviderHealth
+	ConfigChanged     = "config.changed"     // a provider config was created, changed or deleted; Data is a ConfigChange
+	KeyChanged        = "key.changed"        // an API key was changed or deleted; Data is a KeyChange
+	CanaryUsed        = "key.canary_used"    // a request was made with a canary API key; Data is a CanaryUse
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind by before
+// further events are dropped for it
+const subscriberBuffer = 256
+
+// Event is a notification published on the Bus
+type Event struct {
+	Type   string      `json:"type"`
+	UserID uint        `json:"-"` // owner of the resource the event is about
+	Time   time.Time   `json:"time"`
+	Data   interface{} `json:"data"`
+}
+
+// Usage describes the usage of one gateway request
+type Usage struct {
+	RequestID        string `json:"request_id"`
+	APIKeyID         uint   `json:"api_key_id"`
+	APIKeyName       string `json:"api_key_name"`
+	Endpoint         string `json:"endpoint"`
+	Model            string `json:"model"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+	StatusCode       int    `json:"status_code"`
+	LatencyMs        int64  `json:"latency_ms"` // from the request's arrival until its usage was recorded
+	Estimated        bool   `json:"estimated"`
+	ProviderConfigID *uint  `json:"provider_config_id,omitempty"`
+}
+
+// KeyLimit describes a usage limit an API key has reached
+type KeyLimit struct {
+	APIKeyID   uint   `json:"api_key_id"`
+	APIKeyName string `json:"api_key_name"`
+	Limit      string `json:"limit"` // daily_requests, monthly_requests, daily_tokens or monthly_tokens
+	Value      int    `json:"value"`
+	Used       int    `json:"used"`
+}
+
+// ProviderHealth describes a provider config whose health checks fail
+type ProviderHealth struct {
+	ProviderConfigID    uint   `json:"provider_config_id"`
+	Provider            string `json:"provider"`
+	Name                string `json:"name"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LastError           string `json:"last_error"`
+}
+
+// ConfigChange describes a change to a provider config
+type ConfigChange struct {
+	ProviderConfigID uint   `json:"provider_config_id"`
+	Action           string `json:"action"` // create, update, toggle, default, rollback or delete
+}
+
+// KeyChange describes a change to an API key
+type KeyChange struct {
+	APIKeyID uint   `json:"api_key_id"`
+	Action   string `json:"action"` // update, rotate, signing or delete
+}
+
+// CanaryUse describes a request made with a canary API key
+type CanaryUse struct {
+	APIKeyID   uint   `json:"api_key_id"`
+	APIKeyName string `json:"api_key_name"`
+	KeyPrefix  string `json:"key_prefix"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+}
+
+type subscriber struct {
+	ch    chan Event
+	types map[string]bool // nil receives every type
+}
+
+func (s *subscriber) wants(eventType string) bool {
+	return s.types == nil || s.types[eventType]
+}
+
+// Bus fans events out to in-process subscribers. Publishing never blocks: a
+// subscriber that falls behind misses events rather than slowing down requests.
+// A nil *Bus is valid and drops everything published on it.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+}
+
+// NewBus creates a new Bus
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*subscriber]struct{})}
+}
+
+// Subscribe returns a channel receiving the events of the given types, or of
+// every type when none are given, published from now on, and a func that ends
+// the subscription and closes the channel
+func (b *Bus) Subscribe(types ...string) (<-chan Event, func()) {
+	sub := &subscriber{ch: make(chan Event, subscriberBuffer)}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, sub)
+			b.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+}
+
+// Handle registers fn to be called, in publishing order on a goroutine of its
+// own, with the events of the given types, or of every type when none are
+// given. The returned func ends the registration.
+func (b *Bus) Handle(fn func(Event), types ...string) func() {
+	ch, cancel := b.Subscribe(types...)
+	go func() {
+		for event := range ch {
+			fn(event)
+		}
+	}()
+	return cancel
+}
+
+// HasSubscribers reports whether anyone would receive an event of the type, so
+// publishers can skip building events nobody reads
+func (b *Bus) HasSubscribers(eventType string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscribers {
+		if sub.wants(eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish sends an event to the subscribers of its type, dropping it for those
+// whose buffer is full
+func (b *Bus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscribers {
+		if !sub.wants(event.Type) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	}
+}
+
+// Log writes an event to the server log; it is registered as a handler for the
+// events worth an audit trail
+func Log(event Event) {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		data = []byte("{}")
+	}
+	log.Printf("[EVENT] %s user=%d %s", event.Type, event.UserID, data)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AccountDeleteRequest confirms an account deletion request
+type AccountDeleteRequest struct {
+	Password string `json:"password"`
+}
+
+// AccountDeleteResponse reports when a scheduled deletion takes effect
+type AccountDeleteResponse struct {
+	DeletionDueAt time.Time `json:"deletion_due_at"`
+}
+
+// ExportAccount returns all data stored about the current user as a JSON download
+func (h *Handler) ExportAccount(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	export, err := h.accountService.Export(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	filename := fmt.Sprintf("ai-gateway-export-%s-%s.json", user.Username, time.Now().Format("20060102"))
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	return c.JSON(http.StatusOK, export)
+}
+
+// DeleteAccount schedules the current user's account and all its data for permanent deletion
+func (h *Handler) DeleteAccount(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req AccountDeleteRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if !utils.VerifyPassword(req.Password, user.HashedPassword) {
+		return echo.NewHTTPError(http.StatusForbidden, "password is incorrect")
+	}
+
+	due, err := h.accountService.ScheduleDeletion(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	middleware.LogTrace(c, "Account", "User %d scheduled account deletion for %s", user.ID, due.Format(time.RFC3339))
+
+	return c.JSON(http.StatusAccepted, AccountDeleteResponse{DeletionDueAt: due})
+}
+
+// CancelAccountDeletion withdraws the current user's pending account deletion
+func (h *Handler) CancelAccountDeletion(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	if err := h.accountService.CancelDeletion(user.ID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -32,16 +32,32 @@ func (h *Handler) AnthropicMessages(c echo.Context) error {
 	middleware.LogRequestBody(c, "Anthropic", req)
 
 	middleware.LogTrace(c, "Anthropic", "Parsed request: model=%s, messages=%d, stream=%v", req.Model, len(req.Messages), req.Stream)
+	endUser := ""
+	if req.Metadata != nil {
+		endUser = req.Metadata.UserID
+	}
+	if err := h.identifyEndUser(c, endUser); err != nil {
+		return err
+	}
+
+	// Enforce the key's response policy on the output, after the content filter
+	defer h.applyResponsePolicy(c, formatAnthropic)()
+	// Apply the user's content filter rules to the output
+	defer h.filterOutput(c, formatAnthropic)()
+
+	h.compressMessagesHistory(c, &req)
+	noteMessagesPromptEstimate(c, &req)
 
 	// Determine target provider from model name
 	provider := ""
 	resolved, err := h.resolveProviderForAPIKey(c, req.Model)
 	if err != nil {
 		middleware.LogTrace(c, "Anthropic", "Failed to resolve provider: %v", err)
-		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		return resolveProviderError(err)
 	}
 	if resolved != nil {
 		c.Set(middleware.ContextKeyProviderConfig, resolved.Config)
+		c.Set(middleware.ContextKeyUpstreamModel, resolved.Model)
 		req.Model = resolved.Model
 		provider = resolved.Provider
 	}
@@ -52,6 +68,17 @@ func (h *Handler) AnthropicMessages(c echo.Context) error {
 		middleware.LogTrace(c, "Anthropic", "Unsupported model: %s", req.Model)
 		return echo.NewHTTPError(http.StatusBadRequest, "unsupported model")
 	}
+	var maxTokens *int
+	if req.MaxTokens > 0 {
+		maxTokens = &req.MaxTokens
+	}
+	limit, err := h.applyMaxCost(c, req.Model, nil, maxTokens)
+	if err != nil {
+		return err
+	}
+	if limit != nil {
+		req.MaxTokens = *limit
+	}
 
 	middleware.LogTrace(c, "Anthropic", "Target provider: %s", provider)
 
@@ -64,20 +91,37 @@ func (h *Handler) AnthropicMessages(c echo.Context) error {
 
 	middleware.LogTrace(c, "Anthropic", "Got credentials: baseURL=%s, apiKeyLen=%d, protocol=%s", baseURL, len(apiKey), protocol)
 
-	// Route to appropriate handler
+	// Fit inline images to the limits of the upstream
+	contents := make([]interface{}, len(req.Messages))
+	for i := range req.Messages {
+		contents[i] = req.Messages[i].Content
+	}
+	if err := h.fitInlineImages(c, protocol, contents); err != nil {
+		return err
+	}
+
+	// Continue responses cut off at max tokens when the key enables it
+	if budget := autoContinueBudget(c); budget > 0 {
+		return h.autoContinuedMessages(c, &req, budget, baseURL, apiKey, protocol)
+	}
+	return h.dispatchMessages(c, &req, baseURL, apiKey, protocol)
+}
+
+// dispatchMessages routes a Messages request to the handler for the upstream protocol
+func (h *Handler) dispatchMessages(c echo.Context, req *models.MessagesRequest, baseURL, apiKey, protocol string) error {
 	switch protocol {
 	case "anthropic":
 		middleware.LogTrace(c, "Anthropic", "Routing to Anthropic handler")
-		return h.handleAnthropicToAnthropic(c, &req, baseURL, apiKey)
+		return h.handleAnthropicToAnthropic(c, req, baseURL, apiKey)
 	case "openai_chat":
 		middleware.LogTrace(c, "Anthropic", "Routing to OpenAI chat handler")
-		return h.handleAnthropicToOpenAIChat(c, &req, baseURL, apiKey)
+		return h.handleAnthropicToOpenAIChat(c, req, baseURL, apiKey)
 	case "openai_code":
 		middleware.LogTrace(c, "Anthropic", "Routing to OpenAI responses handler")
-		return h.handleAnthropicToOpenAI(c, &req, baseURL, apiKey)
+		return h.handleAnthropicToOpenAI(c, req, baseURL, apiKey)
 	case "gemini":
 		middleware.LogTrace(c, "Anthropic", "Routing to Gemini handler")
-		return h.handleAnthropicToGemini(c, &req, baseURL, apiKey)
+		return h.handleAnthropicToGemini(c, req, baseURL, apiKey)
 	default:
 		middleware.LogTrace(c, "Anthropic", "Unsupported protocol: %s", protocol)
 		return echo.NewHTTPError(http.StatusBadRequest, "unsupported protocol")
@@ -86,6 +130,7 @@ func (h *Handler) AnthropicMessages(c echo.Context) error {
 
 // handleAnthropicToAnthropic forwards request directly to Anthropic
 func (h *Handler) handleAnthropicToAnthropic(c echo.Context, req *models.MessagesRequest, baseURL, apiKey string) error {
+	applyAnthropicMaxTokens(c, req, true)
 	middleware.LogTrace(c, "Anthropic->Anthropic", "Creating adapter with baseURL=%s", baseURL)
 	adapter := adapters.NewAnthropicAdapter(apiKey, baseURL)
 
@@ -111,12 +156,16 @@ func (h *Handler) handleAnthropicToAnthropic(c echo.Context, req *models.Message
 
 // handleAnthropicToOpenAIChat converts and forwards to OpenAI chat completions
 func (h *Handler) handleAnthropicToOpenAIChat(c echo.Context, req *models.MessagesRequest, baseURL, apiKey string) error {
+	if err := checkStrictConversion(c, "openai_chat", converters.AnthropicUnsupportedFields(req, "openai_chat")); err != nil {
+		return err
+	}
 	middleware.LogTrace(c, "Anthropic->OpenAIChat", "Converting request to Chat Completions format")
 	openaiReq, err := converters.AnthropicToOpenAIRequest(req)
 	if err != nil {
 		middleware.LogTrace(c, "Anthropic->OpenAIChat", "Conversion error: %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	reportToolSchemaWarnings(c, converters.SanitizeOpenAIChatTools(openaiReq.Tools))
 
 	// Log conversion details in a structured way
 	var messageCount, maxTokens int
@@ -158,6 +207,9 @@ func (h *Handler) handleAnthropicToOpenAIChat(c echo.Context, req *models.Messag
 
 // handleAnthropicToOpenAI converts and forwards to OpenAI using /responses endpoint
 func (h *Handler) handleAnthropicToOpenAI(c echo.Context, req *models.MessagesRequest, baseURL, apiKey string) error {
+	if err := checkStrictConversion(c, "openai_code", converters.AnthropicUnsupportedFields(req, "openai_code")); err != nil {
+		return err
+	}
 	middleware.LogTrace(c, "Anthropic->OpenAI", "Converting request to Responses API format")
 	// Convert request to OpenAI Responses API format
 	openaiReq, err := converters.AnthropicToOpenAIResponsesRequest(req)
@@ -166,7 +218,7 @@ func (h *Handler) handleAnthropicToOpenAI(c echo.Context, req *models.MessagesRe
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	enforceOpenAIReasoningHigh(openaiReq)
+	applyReasoningPolicy(c, openaiReq)
 
 	middleware.LogTrace(c, "Anthropic->OpenAI", "Creating adapter with baseURL=%s, model=%s", baseURL, req.Model)
 	adapter := adapters.NewOpenAIAdapter(apiKey, baseURL)
@@ -200,6 +252,9 @@ func (h *Handler) handleAnthropicToOpenAI(c echo.Context, req *models.MessagesRe
 
 // handleAnthropicToGemini converts and forwards to Gemini
 func (h *Handler) handleAnthropicToGemini(c echo.Context, req *models.MessagesRequest, baseURL, apiKey string) error {
+	if err := checkStrictConversion(c, "gemini", converters.AnthropicUnsupportedFields(req, "gemini")); err != nil {
+		return err
+	}
 	middleware.LogTrace(c, "Anthropic->Gemini", "Converting request")
 	// Convert request
 	geminiReq, err := converters.AnthropicToGeminiRequest(req)
@@ -207,6 +262,7 @@ func (h *Handler) handleAnthropicToGemini(c echo.Context, req *models.MessagesRe
 		middleware.LogTrace(c, "Anthropic->Gemini", "Conversion error: %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	reportToolSchemaWarnings(c, converters.SanitizeGeminiTools(geminiReq.Tools))
 
 	middleware.LogTrace(c, "Anthropic->Gemini", "Creating adapter with baseURL=%s", baseURL)
 	adapter := adapters.NewGeminiAdapter(apiKey, baseURL)
@@ -280,9 +336,11 @@ func (h *Handler) streamAnthropicFromGemini(c echo.Context, adapter *adapters.Ge
 	c.Response().Header().Set("Cache-Control", "no-cache")
 	c.Response().Header().Set("Connection", "keep-alive")
 	c.Response().WriteHeader(statusCode)
+	out := newAnthropicEventWriter(c)
+	defer out.Close()
 
 	reader := stream.GetReader()
-	isFirst := true
+	state := converters.NewGeminiToAnthropicStreamState(model)
 
 	for {
 		line, err := reader.ReadString('\n')
@@ -311,22 +369,18 @@ func (h *Handler) streamAnthropicFromGemini(c echo.Context, adapter *adapters.Ge
 				continue
 			}
 
-			events, err := converters.GeminiStreamToAnthropicStream(eventData, isFirst, model)
+			events, err := converters.GeminiStreamToAnthropicStream(eventData, state)
 			if err != nil {
 				continue
 			}
 
-			for _, event := range events {
-				c.Response().Write([]byte("event: message\ndata: "))
-				c.Response().Write(event)
-				c.Response().Write([]byte("\n\n"))
-				c.Response().Flush()
-			}
-
-			isFirst = false
+			out.Write(events...)
 		}
 	}
 
+	// Close any block and the message left open by a truncated stream
+	out.Write(state.Close()...)
+
 	return nil
 }
 
@@ -345,9 +399,11 @@ func (h *Handler) streamAnthropicFromOpenAIResponses(c echo.Context, adapter *ad
 	c.Response().Header().Set("Cache-Control", "no-cache")
 	c.Response().Header().Set("Connection", "keep-alive")
 	c.Response().WriteHeader(statusCode)
+	out := newAnthropicEventWriter(c)
+	defer out.Close()
 
 	reader := stream.GetReader()
-	isFirst := true
+	state := converters.NewOpenAIResponsesToAnthropicStreamState()
 
 	for {
 		line, err := reader.ReadString('\n')
@@ -371,27 +427,23 @@ func (h *Handler) streamAnthropicFromOpenAIResponses(c echo.Context, adapter *ad
 				break
 			}
 
-			var eventData map[string]interface{}
-			if err := json.Unmarshal([]byte(data), &eventData); err != nil {
+			var event models.ResponsesStreamEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
 				continue
 			}
 
-			events, err := converters.OpenAIResponsesStreamToAnthropicStream(eventData, isFirst)
+			events, err := converters.OpenAIResponsesStreamToAnthropicStream(&event, state)
 			if err != nil {
 				continue
 			}
 
-			for _, event := range events {
-				c.Response().Write([]byte("event: message\ndata: "))
-				c.Response().Write(event)
-				c.Response().Write([]byte("\n\n"))
-				c.Response().Flush()
-			}
-
-			isFirst = false
+			out.Write(events...)
 		}
 	}
 
+	// Close any block and the message left open by a truncated stream
+	out.Write(state.Close()...)
+
 	return nil
 }
 
@@ -408,6 +460,8 @@ func (h *Handler) streamAnthropicFromOpenAIChat(c echo.Context, adapter *adapter
 	c.Response().Header().Set("Cache-Control", "no-cache")
 	c.Response().Header().Set("Connection", "keep-alive")
 	c.Response().WriteHeader(statusCode)
+	out := newAnthropicEventWriter(c)
+	defer out.Close()
 
 	reader := stream.GetReader()
 	state := converters.NewOpenAIToAnthropicStreamState()
@@ -421,7 +475,6 @@ func (h *Handler) streamAnthropicFromOpenAIChat(c echo.Context, adapter *adapter
 			return err
 		}
 
-		middleware.LogTrace(c, "Anthropic->OpenAIChat", "Read line: %s", line)
 		line = strings.TrimSpace(line)
 		if line == "" || strings.HasPrefix(line, "event:") {
 			continue
@@ -445,12 +498,7 @@ func (h *Handler) streamAnthropicFromOpenAIChat(c echo.Context, adapter *adapter
 				continue
 			}
 
-			for _, event := range events {
-				c.Response().Write([]byte("event: message\ndata: "))
-				c.Response().Write(event)
-				c.Response().Write([]byte("\n\n"))
-				c.Response().Flush()
-			}
+			out.Write(events...)
 		}
 	}
 
@@ -459,7 +507,7 @@ func (h *Handler) streamAnthropicFromOpenAIChat(c echo.Context, adapter *adapter
 
 // recordAnthropicUsage records usage from Anthropic response
 func (h *Handler) recordAnthropicUsage(c echo.Context, endpoint, model string, resp map[string]interface{}, statusCode int) {
-	apiKey := middleware.GetAPIKey(c)
+	apiKey := usageKey(c)
 	if apiKey == nil {
 		return
 	}
@@ -474,15 +522,15 @@ func (h *Handler) recordAnthropicUsage(c echo.Context, endpoint, model string, r
 		}
 	}
 
-	h.apiKeyService.RecordUsage(apiKey.ID, endpoint, model, inputTokens, outputTokens, statusCode)
+	h.apiKeyService.RecordUsage(apiKey.ID, endpoint, model, inputTokens, outputTokens, statusCode, usageAttribution(c))
 }
 
 // recordAnthropicUsageFromResp records usage from Anthropic response struct
 func (h *Handler) recordAnthropicUsageFromResp(c echo.Context, endpoint, model string, resp *models.MessagesResponse, statusCode int) {
-	apiKey := middleware.GetAPIKey(c)
+	apiKey := usageKey(c)
 	if apiKey == nil {
 		return
 	}
 
-	h.apiKeyService.RecordUsage(apiKey.ID, endpoint, model, resp.Usage.InputTokens, resp.Usage.OutputTokens, statusCode)
+	h.apiKeyService.RecordUsage(apiKey.ID, endpoint, model, resp.Usage.InputTokens, resp.Usage.OutputTokens, statusCode, usageAttribution(c))
 }
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// anthropicPingInterval is how often a converted Anthropic stream sends a ping
+// event while the upstream is silent, as the Messages API does
+var anthropicPingInterval = 15 * time.Second
+
+// anthropicEventWriter writes converted events to an Anthropic SSE stream with
+// the event name matching each event's type, and keeps the stream alive with
+// ping events
+type anthropicEventWriter struct {
+	c       echo.Context
+	mu      sync.Mutex
+	started bool // message_start sent; pings may not precede it
+	stop    chan struct{}
+	done    sync.WaitGroup
+}
+
+// newAnthropicEventWriter starts writing an Anthropic stream; Close must be
+// called when the stream ends
+func newAnthropicEventWriter(c echo.Context) *anthropicEventWriter {
+	w := &anthropicEventWriter{c: c, stop: make(chan struct{})}
+	w.done.Add(1)
+	go w.keepAlive()
+	return w
+}
+
+// Write sends events, each under the event name of its type. A ping follows
+// message_start, matching the Messages API.
+func (w *anthropicEventWriter) Write(events ...[]byte) {
+	if len(events) == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, event := range events {
+		var typed struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(event, &typed); err != nil || typed.Type == "" {
+			typed.Type = "message"
+		}
+		w.writeEvent(typed.Type, event)
+		if typed.Type == "message_start" {
+			w.started = true
+			w.writeEvent("ping", []byte(`{"type":"ping"}`))
+		}
+	}
+	w.c.Response().Flush()
+}
+
+// Close stops the ping events
+func (w *anthropicEventWriter) Close() {
+	close(w.stop)
+	w.done.Wait()
+}
+
+func (w *anthropicEventWriter) writeEvent(name string, data []byte) {
+	w.c.Response().Write([]byte("event: " + name + "\ndata: "))
+	w.c.Response().Write(data)
+	w.c.Response().Write([]byte("\n\n"))
+}
+
+func (w *anthropicEventWriter) keepAlive() {
+	defer w.done.Done()
+	ticker := time.NewTicker(anthropicPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.started {
+				w.writeEvent("ping", []byte(`{"type":"ping"}`))
+				w.c.Response().Flush()
+			}
+			w.mu.Unlock()
+		}
+	}
+}
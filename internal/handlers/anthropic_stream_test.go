@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newSlowStreamUpstream is an OpenAI-compatible upstream streaming a chat
+// completion with a pause after its first chunk
+func newSlowStreamUpstream(t *testing.T, pause time.Duration) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunk := func(choices string) {
+			fmt.Fprintf(w, `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","model":"gpt-4o","choices":%s}`+"\n\n", choices)
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunk(`[{"index":0,"delta":{"role":"assistant","content":"Hello"}}]`)
+		time.Sleep(pause)
+		chunk(`[{"index":0,"delta":{"content":" world"}}]`)
+		chunk(`[{"index":0,"delta":{},"finish_reason":"stop"}]`)
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestAnthropicEventWriter_PingsSilentUpstream(t *testing.T) {
+	interval := anthropicPingInterval
+	anthropicPingInterval = 10 * time.Millisecond
+	t.Cleanup(func() { anthropicPingInterval = interval })
+
+	upstream := newSlowStreamUpstream(t, 200*time.Millisecond)
+	h, apiKey, _ := chatTestHandler(t, upstream.URL, 0)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"gpt-4o","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set(middleware.ContextKeyAPIKey, apiKey)
+	c.Set(middleware.ContextKeyUser, &apiKey.User)
+	if err := h.AnthropicMessages(c); err != nil {
+		t.Fatal(err)
+	}
+
+	body := rec.Body.String()
+	var names []string
+	for _, event := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		names = append(names, strings.TrimPrefix(strings.SplitN(event, "\n", 2)[0], "event: "))
+	}
+	if len(names) < 3 || names[0] != "message_start" || names[1] != "ping" || names[len(names)-1] != "message_stop" {
+		t.Fatalf("events = %v, want message_start and a ping first, message_stop last", names)
+	}
+
+	// Pings keep the stream alive while the upstream pauses between the two
+	// text deltas, and none precede message_start
+	var deltas []int
+	pings := 0
+	for i, name := range names {
+		switch name {
+		case "content_block_delta":
+			deltas = append(deltas, i)
+		case "ping":
+			if len(deltas) == 1 {
+				pings++
+			}
+		}
+	}
+	if len(deltas) != 2 || pings < 3 {
+		t.Errorf("events = %v, want pings between the text deltas", names)
+	}
+
+	// The ping goroutine stops with the stream
+	time.Sleep(5 * anthropicPingInterval)
+	if rec.Body.String() != body {
+		t.Errorf("events were written after the stream closed: %q", strings.TrimPrefix(rec.Body.String(), body))
+	}
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"ai_gateway/internal/database"
@@ -28,6 +30,24 @@ type APIKeyCreateRequest struct {
 	MonthlyRequestLimit *int       `json:"monthly_request_limit"`
 	DailyTokenLimit     *int       `json:"daily_token_limit"`
 	MonthlyTokenLimit   *int       `json:"monthly_token_limit"`
+	ArchiveEnabled      bool       `json:"archive_enabled"`
+	BodyLogDisabled     bool       `json:"body_log_disabled"`
+	RoutingStrategy     string     `json:"routing_strategy"`
+	SchemaRepairRetries int        `json:"schema_repair_retries"`
+	LatencyBudgetMs     int        `json:"latency_budget_ms"`
+	AllowConfigOverride bool       `json:"allow_config_override"`
+	CompressionTokens   int        `json:"compression_tokens"`
+	CompressionModel    string     `json:"compression_model"`
+	KeepRequestedModel  bool       `json:"keep_requested_model"`
+	StrictConversion    bool       `json:"strict_conversion"`
+	ValidateToolArgs    bool       `json:"validate_tool_args"`
+	MaxResponseTokens   int        `json:"max_response_tokens"`
+	ForbiddenLanguages  []string   `json:"forbidden_languages"`
+	ResponseSuffix      string     `json:"response_suffix"`
+	InjectionDetection  bool       `json:"injection_detection"`
+	InjectionThreshold  float64    `json:"injection_threshold"`
+	SalvageStreams      bool       `json:"salvage_streams"`
+	AutoContinueTokens  int        `json:"auto_continue_tokens"`
 }
 
 // APIKeyUpdateRequest represents an API key update request
@@ -40,6 +60,26 @@ type APIKeyUpdateRequest struct {
 	MonthlyRequestLimit *int       `json:"monthly_request_limit"`
 	DailyTokenLimit     *int       `json:"daily_token_limit"`
 	MonthlyTokenLimit   *int       `json:"monthly_token_limit"`
+	ArchiveEnabled      *bool      `json:"archive_enabled"`
+	BodyLogDisabled     *bool      `json:"body_log_disabled"`
+	RoutingStrategy     *string    `json:"routing_strategy"`
+	SchemaRepairRetries *int       `json:"schema_repair_retries"`
+	LatencyBudgetMs     *int       `json:"latency_budget_ms"`
+	AllowConfigOverride *bool      `json:"allow_config_override"`
+	CompressionTokens   *int       `json:"compression_tokens"`
+	CompressionModel    *string    `json:"compression_model"`
+	KeepRequestedModel  *bool      `json:"keep_requested_model"`
+	StrictConversion    *bool      `json:"strict_conversion"`
+	ValidateToolArgs    *bool      `json:"validate_tool_args"`
+	MaxResponseTokens   *int       `json:"max_response_tokens"`
+	ForbiddenLanguages  []string   `json:"forbidden_languages"`
+	ResponseSuffix      *string    `json:"response_suffix"`
+	InjectionDetection  *bool      `json:"injection_detection"`
+	InjectionThreshold  *float64   `json:"injection_threshold"`
+	SalvageStreams      *bool      `json:"salvage_streams"`
+	AutoContinueTokens  *int       `json:"auto_continue_tokens"`
+	CanaryWebhookURL    *string    `json:"canary_webhook_url"`
+	CanaryEmail         *bool      `json:"canary_email"`
 }
 
 // APIKeyRotateRequest represents an API key rotation request
@@ -63,7 +103,31 @@ type APIKeyResponse struct {
 	MonthlyRequestsUsed int                  `json:"monthly_requests_used"`
 	DailyTokensUsed     int                  `json:"daily_tokens_used"`
 	MonthlyTokensUsed   int                  `json:"monthly_tokens_used"`
+	ArchiveEnabled      bool                 `json:"archive_enabled"`
+	BodyLogDisabled     bool                 `json:"body_log_disabled"`
+	RoutingStrategy     string               `json:"routing_strategy"`
+	SchemaRepairRetries int                  `json:"schema_repair_retries"`
+	LatencyBudgetMs     int                  `json:"latency_budget_ms"`
+	AllowConfigOverride bool                 `json:"allow_config_override"`
+	CompressionTokens   int                  `json:"compression_tokens"`
+	CompressionModel    string               `json:"compression_model"`
+	KeepRequestedModel  bool                 `json:"keep_requested_model"`
+	StrictConversion    bool                 `json:"strict_conversion"`
+	ValidateToolArgs    bool                 `json:"validate_tool_args"`
+	MaxResponseTokens   int                  `json:"max_response_tokens"`
+	ForbiddenLanguages  []string             `json:"forbidden_languages"`
+	ResponseSuffix      string               `json:"response_suffix"`
+	InjectionDetection  bool                 `json:"injection_detection"`
+	InjectionThreshold  float64              `json:"injection_threshold"`
+	SalvageStreams      bool                 `json:"salvage_streams"`
+	AutoContinueTokens  int                  `json:"auto_continue_tokens"`
 	CreatedAt           time.Time            `json:"created_at"`
+
+	SigningEnabled bool `json:"signing_enabled"` // the key only authenticates HMAC-signed requests
+
+	Canary           bool   `json:"canary"` // the key only raises alerts, see CreateCanaryKey
+	CanaryWebhookURL string `json:"canary_webhook_url,omitempty"`
+	CanaryEmail      bool   `json:"canary_email,omitempty"`
 }
 
 // APIKeyCreateResponse includes the full key (only shown once)
@@ -102,21 +166,51 @@ func toAPIKeyResponse(key *database.APIKey) APIKeyResponse {
 		MonthlyRequestsUsed: key.MonthlyRequestsUsed,
 		DailyTokensUsed:     key.DailyTokensUsed,
 		MonthlyTokensUsed:   key.MonthlyTokensUsed,
+		ArchiveEnabled:      key.ArchiveEnabled,
+		BodyLogDisabled:     key.BodyLogDisabled,
+		RoutingStrategy:     key.RoutingStrategy,
+		SchemaRepairRetries: key.SchemaRepairRetries,
+		LatencyBudgetMs:     key.LatencyBudgetMs,
+		AllowConfigOverride: key.AllowConfigOverride,
+		CompressionTokens:   key.CompressionTokens,
+		CompressionModel:    key.CompressionModel,
+		KeepRequestedModel:  key.KeepRequestedModel,
+		StrictConversion:    key.StrictConversion,
+		ValidateToolArgs:    key.ValidateToolArgs,
+		MaxResponseTokens:   key.MaxResponseTokens,
+		ForbiddenLanguages:  services.SplitLanguages(key.ForbiddenLanguages),
+		ResponseSuffix:      key.ResponseSuffix,
+		InjectionDetection:  key.InjectionDetection,
+		InjectionThreshold:  key.InjectionThreshold,
+		SalvageStreams:      key.SalvageStreams,
+		AutoContinueTokens:  key.AutoContinueTokens,
 		CreatedAt:           key.CreatedAt,
+
+		SigningEnabled: key.EncryptedSigningSecret != "",
+
+		Canary:           key.Canary,
+		CanaryWebhookURL: key.CanaryWebhookURL,
+		CanaryEmail:      key.CanaryEmail,
 	}
 }
 
-// ListAPIKeys returns all API keys for the current user
+// ListAPIKeys returns the API keys of the current user, all of them unless a
+// limit is given. Filters: q (name), status (active, inactive, expired), since, until.
 func (h *Handler) ListAPIKeys(c echo.Context) error {
 	user := middleware.GetUser(c)
 	if user == nil {
 		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
 	}
 
-	keys, err := h.apiKeyService.GetAPIKeys(user.ID)
+	q, err := listQuery(c, 0)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return err
 	}
+	keys, total, err := h.apiKeyService.ListAPIKeys(user.ID, q)
+	if err != nil {
+		return listError(err)
+	}
+	setListTotal(c, total)
 
 	var response []APIKeyResponse
 	for _, key := range keys {
@@ -150,6 +244,24 @@ func (h *Handler) CreateAPIKey(c echo.Context) error {
 		MonthlyRequestLimit: req.MonthlyRequestLimit,
 		DailyTokenLimit:     req.DailyTokenLimit,
 		MonthlyTokenLimit:   req.MonthlyTokenLimit,
+		ArchiveEnabled:      req.ArchiveEnabled,
+		BodyLogDisabled:     req.BodyLogDisabled,
+		RoutingStrategy:     req.RoutingStrategy,
+		SchemaRepairRetries: req.SchemaRepairRetries,
+		LatencyBudgetMs:     req.LatencyBudgetMs,
+		AllowConfigOverride: req.AllowConfigOverride,
+		CompressionTokens:   req.CompressionTokens,
+		CompressionModel:    req.CompressionModel,
+		KeepRequestedModel:  req.KeepRequestedModel,
+		StrictConversion:    req.StrictConversion,
+		ValidateToolArgs:    req.ValidateToolArgs,
+		MaxResponseTokens:   req.MaxResponseTokens,
+		ForbiddenLanguages:  req.ForbiddenLanguages,
+		ResponseSuffix:      req.ResponseSuffix,
+		InjectionDetection:  req.InjectionDetection,
+		InjectionThreshold:  req.InjectionThreshold,
+		SalvageStreams:      req.SalvageStreams,
+		AutoContinueTokens:  req.AutoContinueTokens,
 	}
 
 	key, fullKey, err := h.apiKeyService.CreateAPIKey(user.ID, serviceReq)
@@ -195,6 +307,10 @@ func (h *Handler) UpdateAPIKey(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid key ID")
 	}
 
+	if before, err := h.apiKeyService.GetAPIKeyByID(user.ID, uint(id)); err == nil {
+		middleware.AuditBefore(c, toAPIKeyResponse(before))
+	}
+
 	var req APIKeyUpdateRequest
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
@@ -209,6 +325,26 @@ func (h *Handler) UpdateAPIKey(c echo.Context) error {
 		MonthlyRequestLimit: req.MonthlyRequestLimit,
 		DailyTokenLimit:     req.DailyTokenLimit,
 		MonthlyTokenLimit:   req.MonthlyTokenLimit,
+		ArchiveEnabled:      req.ArchiveEnabled,
+		BodyLogDisabled:     req.BodyLogDisabled,
+		RoutingStrategy:     req.RoutingStrategy,
+		SchemaRepairRetries: req.SchemaRepairRetries,
+		LatencyBudgetMs:     req.LatencyBudgetMs,
+		AllowConfigOverride: req.AllowConfigOverride,
+		CompressionTokens:   req.CompressionTokens,
+		CompressionModel:    req.CompressionModel,
+		KeepRequestedModel:  req.KeepRequestedModel,
+		StrictConversion:    req.StrictConversion,
+		ValidateToolArgs:    req.ValidateToolArgs,
+		MaxResponseTokens:   req.MaxResponseTokens,
+		ForbiddenLanguages:  req.ForbiddenLanguages,
+		ResponseSuffix:      req.ResponseSuffix,
+		InjectionDetection:  req.InjectionDetection,
+		InjectionThreshold:  req.InjectionThreshold,
+		SalvageStreams:      req.SalvageStreams,
+		AutoContinueTokens:  req.AutoContinueTokens,
+		CanaryWebhookURL:    req.CanaryWebhookURL,
+		CanaryEmail:         req.CanaryEmail,
 	}
 
 	key, err := h.apiKeyService.UpdateAPIKey(user.ID, uint(id), serviceReq)
@@ -231,6 +367,10 @@ func (h *Handler) DeleteAPIKey(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid key ID")
 	}
 
+	if before, err := h.apiKeyService.GetAPIKeyByID(user.ID, uint(id)); err == nil {
+		middleware.AuditBefore(c, toAPIKeyResponse(before))
+	}
+
 	if err := h.apiKeyService.DeleteAPIKey(user.ID, uint(id)); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
@@ -238,7 +378,12 @@ func (h *Handler) DeleteAPIKey(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
-// GetAPIKeyUsage returns usage statistics for an API key
+// usageRecordsLimit is the default number of usage records returned with usage statistics
+const usageRecordsLimit = 100
+
+// GetAPIKeyUsage returns usage statistics for an API key with its latest 100
+// usage records, or a page given by limit and offset. Filters: q (model),
+// status (success, error), since, until, tag.
 func (h *Handler) GetAPIKeyUsage(c echo.Context) error {
 	user := middleware.GetUser(c)
 	if user == nil {
@@ -250,14 +395,70 @@ func (h *Handler) GetAPIKeyUsage(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid key ID")
 	}
 
-	stats, err := h.apiKeyService.GetUsageStats(user.ID, uint(id))
+	tag := ""
+	if raw := c.QueryParam("tag"); raw != "" {
+		tags := services.NormalizeUsageTags([]string{raw})
+		if len(tags) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid tag")
+		}
+		tag = tags[0]
+	}
+
+	q, err := listQuery(c, usageRecordsLimit)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return err
+	}
+	stats, err := h.apiKeyService.GetUsageStats(user.ID, uint(id), tag, q)
+	if err != nil {
+		return listError(err)
 	}
+	setListTotal(c, stats.TotalRecords)
 
 	return c.JSON(http.StatusOK, stats)
 }
 
+// GetAPIKeyTagUsage splits the usage of an API key by attribution tag
+func (h *Handler) GetAPIKeyTagUsage(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid key ID")
+	}
+
+	days := 30
+	if raw := c.QueryParam("days"); raw != "" {
+		if days, err = strconv.Atoi(raw); err != nil || days < 1 || days > 366 {
+			return echo.NewHTTPError(http.StatusBadRequest, "days must be between 1 and 366")
+		}
+	}
+
+	usage, err := h.apiKeyService.GetTagUsage(user.ID, uint(id), time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, usage)
+}
+
+// HeaderGatewayTags carries comma-separated usage attribution tags, e.g. "feature:search,customer:acme"
+const HeaderGatewayTags = "X-Gateway-Tags"
+
+// usageTags returns the attribution tags of a request: those of the
+// X-Gateway-Tags header plus user:<id> for a declared end user
+func usageTags(c echo.Context) []string {
+	var tags []string
+	if header := c.Request().Header.Get(HeaderGatewayTags); header != "" {
+		tags = strings.Split(header, ",")
+	}
+	if endUser, _ := c.Get(contextKeyEndUser).(string); endUser != "" {
+		tags = append(tags, "user:"+endUser)
+	}
+	return services.NormalizeUsageTags(tags)
+}
+
 // RotateAPIKey rotates an API key - generates a new key
 func (h *Handler) RotateAPIKey(c echo.Context) error {
 	user := middleware.GetUser(c)
@@ -270,6 +471,10 @@ func (h *Handler) RotateAPIKey(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid key ID")
 	}
 
+	if before, err := h.apiKeyService.GetAPIKeyByID(user.ID, uint(id)); err == nil {
+		middleware.AuditBefore(c, toAPIKeyResponse(before))
+	}
+
 	var req APIKeyRotateRequest
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
@@ -289,3 +494,55 @@ func (h *Handler) RotateAPIKey(c echo.Context) error {
 		Key:            fullKey,
 	})
 }
+
+// SigningSecretResponse includes the signing secret of an API key (only shown once)
+type SigningSecretResponse struct {
+	SigningSecret string `json:"signing_secret"`
+}
+
+// EnableAPIKeySigning generates a signing secret for an API key, after which the
+// key only authenticates HMAC-signed requests
+func (h *Handler) EnableAPIKeySigning(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid key ID")
+	}
+
+	secret, err := h.signingService.EnableSigning(user.ID, uint(id))
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, SigningSecretResponse{SigningSecret: secret})
+}
+
+// DisableAPIKeySigning removes the signing secret of an API key, so that it
+// authenticates by sending the key again
+func (h *Handler) DisableAPIKeySigning(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid key ID")
+	}
+
+	if err := h.signingService.DisableSigning(user.ID, uint(id)); err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// APIKeyBulkRequest selects API keys for a bulk operation by ID, by a provider
+// config they are bound to, or by both
+type APIKeyBulkRequest struct {
+	IDs              []uint `json:"ids"`
+	ProviderConfigID *uint  `json:"provider_config_id"`
+}
+
+// APIKeyBulkLimitsRequest sets usage limits on the selected API keys
+type APIKeyBulkLimitsRequest struct {
+	APIKeyBulkRequest
+	DailyRequestLimit   *int `json:"daily_request_limit"`
+	MonthlyRequestLimit *int `json:"monthly_request_limit"`
+	DailyTokenLimit     *int `json:"daily_token_limit"`
+	MonthlyTokenLimit   *int `json:"monthly_token_limit"`
+}
+
+// APIKeyBulkResponse lists the API keys a bulk operation changed
+type APIKeyBulkResponse struct {
+	Count int    `json:"count"`
+	IDs   []uint `json:"ids"`
+}
+
+// BulkDeactivateAPIKeys deactivates the selected API keys of the current user
+func (h *Handler) BulkDeactivateAPIKeys(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req APIKeyBulkRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	ids, err := h.apiKeyService.BulkDeactivateAPIKeys(user.ID, req.selection())
+	return bulkResponse(c, ids, err)
+}
+
+// BulkDeleteAPIKeys deletes the selected API keys of the current user
+func (h *Handler) BulkDeleteAPIKeys(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req APIKeyBulkRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	ids, err := h.apiKeyService.BulkDeleteAPIKeys(user.ID, req.selection())
+	return bulkResponse(c, ids, err)
+}
+
+// BulkUpdateAPIKeyLimits sets usage limits on the selected API keys of the
+// current user; limits left out of the request are unchanged
+func (h *Handler) BulkUpdateAPIKeyLimits(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req APIKeyBulkLimitsRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	ids, err := h.apiKeyService.BulkUpdateAPIKeyLimits(user.ID, req.selection(), &services.APIKeyLimits{
+		DailyRequestLimit:   req.DailyRequestLimit,
+		MonthlyRequestLimit: req.MonthlyRequestLimit,
+		DailyTokenLimit:     req.DailyTokenLimit,
+		MonthlyTokenLimit:   req.MonthlyTokenLimit,
+	})
+	return bulkResponse(c, ids, err)
+}
+
+// selection converts a bulk request to its service selection
+func (r *APIKeyBulkRequest) selection() *services.APIKeySelection {
+	return &services.APIKeySelection{IDs: r.IDs, ProviderConfigID: r.ProviderConfigID}
+}
+
+// bulkResponse renders the outcome of a bulk API key operation
+func bulkResponse(c echo.Context, ids []uint, err error) error {
+	if errors.Is(err, services.ErrEmptyKeySelection) || errors.Is(err, services.ErrNoLimitsToUpdate) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, APIKeyBulkResponse{Count: len(ids), IDs: ids})
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"ai_gateway/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AssistantsPassthrough handles /v1/assistants* and /v1/threads* (Assistants API v2).
+// Assistants and threads are scoped to the API key (or user) that created them.
+func (h *Handler) AssistantsPassthrough(c echo.Context) error {
+	upstreamPath := strings.TrimPrefix(c.Request().URL.Path, "/v1")
+	parts := strings.Split(strings.Trim(upstreamPath, "/"), "/")
+	method := c.Request().Method
+	scope := middleware.AuthScope(c)
+
+	objectType := "assistant"
+	if parts[0] == "threads" {
+		objectType = "thread"
+	}
+	// POST /threads/runs creates a thread and runs it in one call
+	createAndRun := parts[0] == "threads" && len(parts) == 2 && parts[1] == "runs"
+	isCreate := method == http.MethodPost && (len(parts) == 1 || createAndRun)
+	createsRun := method == http.MethodPost && (createAndRun || (parts[0] == "threads" && len(parts) == 3 && parts[2] == "runs"))
+
+	if len(parts) >= 2 && !createAndRun {
+		owned, err := h.assistantService.Owns(scope, parts[1])
+		if err != nil {
+			middleware.LogTrace(c, "Assistants", "Ownership lookup failed: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to check object ownership")
+		}
+		if !owned {
+			middleware.LogTrace(c, "Assistants", "Rejecting access to %s %s not owned by %s", objectType, parts[1], scope)
+			return echo.NewHTTPError(http.StatusNotFound, "no such "+objectType+": "+parts[1])
+		}
+	}
+	if createsRun {
+		if err := h.checkRunAssistant(c, scope); err != nil {
+			return err
+		}
+	}
+
+	resp, err := h.forwardOpenAI(c, upstreamPath, http.Header{"OpenAI-Beta": []string{"assistants=v2"}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	succeeded := resp.StatusCode < http.StatusMultipleChoices
+	return h.relayUpstreamResponse(c, resp, "/v1/"+parts[0], func(payload map[string]interface{}) {
+		if !succeeded {
+			return
+		}
+		object, _ := payload["object"].(string)
+		switch {
+		case isCreate && (object == "assistant" || object == "thread"):
+			if id, ok := payload["id"].(string); ok {
+				h.trackAssistantObject(c, scope, object, id)
+			}
+		case createAndRun && object == "thread.run":
+			if threadID, ok := payload["thread_id"].(string); ok {
+				h.trackAssistantObject(c, scope, "thread", threadID)
+			}
+		case method == http.MethodDelete && len(parts) == 2:
+			if err := h.assistantService.Untrack(parts[1]); err != nil {
+				middleware.LogTrace(c, "Assistants", "Failed to untrack %s: %v", parts[1], err)
+			}
+		case method == http.MethodGet && len(parts) == 1 && object == "list":
+			h.filterOwnedAssistantObjects(c, scope, objectType, payload)
+		}
+	})
+}
+
+// checkRunAssistant rejects a request creating a run of an assistant the
+// caller did not create. The body is read and restored for forwarding.
+func (h *Handler) checkRunAssistant(c echo.Context, scope string) error {
+	req := c.Request()
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to read request body")
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	var run struct {
+		AssistantID string `json:"assistant_id"`
+	}
+	// A body that is not a run request is left for the upstream to reject
+	if json.Unmarshal(body, &run) != nil || run.AssistantID == "" {
+		return nil
+	}
+	owned, err := h.assistantService.Owns(scope, run.AssistantID)
+	if err != nil {
+		middleware.LogTrace(c, "Assistants", "Ownership lookup failed: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to check object ownership")
+	}
+	if !owned {
+		middleware.LogTrace(c, "Assistants", "Rejecting run of assistant %s not owned by %s", run.AssistantID, scope)
+		return echo.NewHTTPError(http.StatusNotFound, "no such assistant: "+run.AssistantID)
+	}
+	return nil
+}
+
+// trackAssistantObject records ownership of a newly created assistant or thread
+func (h *Handler) trackAssistantObject(c echo.Context, scope, objectType, objectID string) {
+	if err := h.assistantService.Track(scope, objectType, objectID); err != nil {
+		middleware.LogTrace(c, "Assistants", "Failed to track %s %s: %v", objectType, objectID, err)
+	}
+}
+
+// filterOwnedAssistantObjects drops list entries the caller did not create
+func (h *Handler) filterOwnedAssistantObjects(c echo.Context, scope, objectType string, payload map[string]interface{}) {
+	data, ok := payload["data"].([]interface{})
+	if !ok {
+		return
+	}
+	owned, err := h.assistantService.OwnedIDs(scope, objectType)
+	if err != nil {
+		middleware.LogTrace(c, "Assistants", "Failed to load owned %s IDs: %v", objectType, err)
+		payload["data"] = []interface{}{}
+		return
+	}
+
+	filtered := make([]interface{}, 0, len(data))
+	for _, item := range data {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if id, _ := obj["id"].(string); owned[id] {
+			filtered = append(filtered, item)
+		}
+	}
+	payload["data"] = filtered
+	if len(filtered) > 0 {
+		payload["first_id"] = filtered[0].(map[string]interface{})["id"]
+		payload["last_id"] = filtered[len(filtered)-1].(map[string]interface{})["id"]
+	} else {
+		payload["first_id"] = nil
+		payload["last_id"] = nil
+	}
+}
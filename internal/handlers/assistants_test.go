@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ai_gateway/internal/database"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// newAssistantsUpstream is an Assistants API upstream shared by several callers
+func newAssistantsUpstream(t *testing.T) *passthroughUpstream {
+	return newPassthroughUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/assistants":
+			w.Write([]byte(`{"id":"asst_1","object":"assistant","model":"gpt-4o"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/assistants":
+			w.Write([]byte(`{"object":"list","data":[{"id":"asst_1","object":"assistant"},{"id":"asst_other","object":"assistant"}],"first_id":"asst_1","last_id":"asst_other"}`))
+		case r.Method == http.MethodDelete:
+			w.Write([]byte(`{"id":"asst_1","object":"assistant.deleted","deleted":true}`))
+		case r.URL.Path == "/threads/runs":
+			w.Write([]byte(`{"id":"run_1","object":"thread.run","thread_id":"thread_1","status":"queued"}`))
+		case r.URL.Path == "/threads/thread_1/runs":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("event: thread.run.created\ndata: {\"id\":\"run_2\",\"object\":\"thread.run\",\"status\":\"queued\"}\n\n" +
+				"event: thread.run.completed\ndata: {\"id\":\"run_2\",\"object\":\"thread.run\",\"model\":\"gpt-4o\",\"status\":\"completed\",\"usage\":{\"prompt_tokens\":7,\"completion_tokens\":5,\"total_tokens\":12}}\n\n" +
+				"event: done\ndata: [DONE]\n\n"))
+		default:
+			w.Write([]byte(`{"id":"asst_1","object":"assistant","model":"gpt-4o"}`))
+		}
+	})
+}
+
+// addAPIKey creates another API key of the owner of apiKey using the same provider configs
+func addAPIKey(t *testing.T, db *gorm.DB, apiKey *database.APIKey, keyHash string) *database.APIKey {
+	other := &database.APIKey{
+		UserID:          apiKey.UserID,
+		Name:            keyHash,
+		KeyHash:         keyHash,
+		IsActive:        true,
+		ProviderConfigs: apiKey.ProviderConfigs,
+	}
+	if err := db.Create(other).Error; err != nil {
+		t.Fatal(err)
+	}
+	other.User = apiKey.User
+	return other
+}
+
+func TestAssistants_ScopedToCreatingKey(t *testing.T) {
+	upstream := newAssistantsUpstream(t)
+	h, owner, db := chatTestHandler(t, upstream.URL, 0)
+	other := addAPIKey(t, db, owner, "other-hash")
+	ownerAPI, otherAPI := passthroughRouter(h, owner), passthroughRouter(h, other)
+
+	if rec := serveJSON(ownerAPI, http.MethodPost, "/v1/assistants", `{"model":"gpt-4o"}`); rec.Code != http.StatusOK {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := upstream.last(t).Header.Get("OpenAI-Beta"); got != "assistants=v2" {
+		t.Errorf("OpenAI-Beta = %q, want assistants=v2", got)
+	}
+
+	tests := []struct {
+		name     string
+		api      *echo.Echo
+		method   string
+		path     string
+		wantCode int
+		want     string // substring of the response body
+		reaches  bool   // whether the request reaches the upstream
+	}{
+		{"owner retrieves", ownerAPI, http.MethodGet, "/v1/assistants/asst_1", http.StatusOK, `"id":"asst_1"`, true},
+		{"other key retrieves", otherAPI, http.MethodGet, "/v1/assistants/asst_1", http.StatusNotFound, "no such assistant", false},
+		{"unknown assistant", ownerAPI, http.MethodGet, "/v1/assistants/asst_other", http.StatusNotFound, "no such assistant", false},
+		{"owner lists", ownerAPI, http.MethodGet, "/v1/assistants", http.StatusOK, `"data":[{"id":"asst_1","object":"assistant"}]`, true},
+		{"other key lists", otherAPI, http.MethodGet, "/v1/assistants", http.StatusOK, `"data":[]`, true},
+		{"other key deletes", otherAPI, http.MethodDelete, "/v1/assistants/asst_1", http.StatusNotFound, "no such assistant", false},
+		{"owner deletes", ownerAPI, http.MethodDelete, "/v1/assistants/asst_1", http.StatusOK, `"deleted":true`, true},
+		{"deleted assistant", ownerAPI, http.MethodGet, "/v1/assistants/asst_1", http.StatusNotFound, "no such assistant", false},
+	}
+	for _, tt := range tests {
+		before := upstream.count()
+		rec := serveJSON(tt.api, tt.method, tt.path, "")
+		if rec.Code != tt.wantCode || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("%s: status %d, body %s, want %d with %q", tt.name, rec.Code, rec.Body.String(), tt.wantCode, tt.want)
+		}
+		if reached := upstream.count() > before; reached != tt.reaches {
+			t.Errorf("%s: reached the upstream = %v, want %v", tt.name, reached, tt.reaches)
+		}
+	}
+}
+
+func TestAssistants_RunsTrackThreadsAndRecordUsage(t *testing.T) {
+	upstream := newAssistantsUpstream(t)
+	h, owner, db := chatTestHandler(t, upstream.URL, 0)
+	other := addAPIKey(t, db, owner, "other-hash")
+	ownerAPI, otherAPI := passthroughRouter(h, owner), passthroughRouter(h, other)
+
+	if rec := serveJSON(ownerAPI, http.MethodPost, "/v1/assistants", `{"model":"gpt-4o"}`); rec.Code != http.StatusOK {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body.String())
+	}
+	// Creating and running a thread in one call makes the thread the caller's
+	if rec := serveJSON(ownerAPI, http.MethodPost, "/v1/threads/runs", `{"assistant_id":"asst_1"}`); rec.Code != http.StatusOK {
+		t.Fatalf("create and run: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serveJSON(otherAPI, http.MethodPost, "/v1/threads/thread_1/runs", `{"assistant_id":"asst_1","stream":true}`); rec.Code != http.StatusNotFound {
+		t.Errorf("run on another key's thread: status %d, want 404", rec.Code)
+	}
+
+	rec := serveJSON(ownerAPI, http.MethodPost, "/v1/threads/thread_1/runs", `{"assistant_id":"asst_1","stream":true}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "event: thread.run.completed") {
+		t.Fatalf("streamed run: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get(echo.HeaderContentType); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	var run *database.UsageRecord
+	records := usageRecords(t, db, owner)
+	for i := range records {
+		if records[i].TotalTokens > 0 {
+			run = &records[i]
+		}
+	}
+	if run == nil || run.PromptTokens != 7 || run.CompletionTokens != 5 || run.Endpoint != "/v1/threads" {
+		t.Errorf("usage of the streamed run not recorded: %+v", records)
+	}
+}
+
+func TestAssistants_RunsRequireOwnedAssistant(t *testing.T) {
+	upstream := newAssistantsUpstream(t)
+	h, owner, db := chatTestHandler(t, upstream.URL, 0)
+	other := addAPIKey(t, db, owner, "other-hash")
+	ownerAPI, otherAPI := passthroughRouter(h, owner), passthroughRouter(h, other)
+
+	if rec := serveJSON(ownerAPI, http.MethodPost, "/v1/assistants", `{"model":"gpt-4o"}`); rec.Code != http.StatusOK {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := h.assistantService.Track(fmt.Sprintf("key:%d", other.ID), "thread", "thread_2"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		api      *echo.Echo
+		path     string
+		body     string
+		wantCode int
+	}{
+		{"other key creates and runs", otherAPI, "/v1/threads/runs", `{"assistant_id":"asst_1"}`, http.StatusNotFound},
+		{"other key runs on its own thread", otherAPI, "/v1/threads/thread_2/runs", `{"assistant_id":"asst_1"}`, http.StatusNotFound},
+		{"unknown assistant", ownerAPI, "/v1/threads/runs", `{"assistant_id":"asst_other"}`, http.StatusNotFound},
+		{"owner creates and runs", ownerAPI, "/v1/threads/runs", `{"assistant_id":"asst_1"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		before := upstream.count()
+		rec := serveJSON(tt.api, http.MethodPost, tt.path, tt.body)
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.wantCode, rec.Body.String())
+		}
+		if tt.wantCode == http.StatusNotFound && !strings.Contains(rec.Body.String(), "no such assistant") {
+			t.Errorf("%s: body %s, want the assistant reported missing", tt.name, rec.Body.String())
+		}
+		reached := upstream.count() > before
+		if reached != (tt.wantCode == http.StatusOK) {
+			t.Errorf("%s: reached the upstream = %v", tt.name, reached)
+		}
+		if reached && !strings.Contains(upstream.last(t).Body, `"assistant_id":"asst_1"`) {
+			t.Errorf("%s: forwarded body %s, want the original body", tt.name, upstream.last(t).Body)
+		}
+	}
+}
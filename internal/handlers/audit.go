@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// auditLogLimit is the default number of audit log entries returned
+const auditLogLimit = 100
+
+// auditLogFilter parses the audit log query parameters: actor_id, action,
+// resource_type, since and until (RFC 3339), before_id and limit
+func auditLogFilter(c echo.Context) (*services.AuditLogFilter, error) {
+	filter := &services.AuditLogFilter{
+		Action:       c.QueryParam("action"),
+		ResourceType: c.QueryParam("resource_type"),
+		Limit:        auditLogLimit,
+	}
+	if raw := c.QueryParam("actor_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid actor_id")
+		}
+		actorID := uint(id)
+		filter.ActorID = &actorID
+	}
+	for name, target := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if raw := c.QueryParam(name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be an RFC 3339 time", name))
+			}
+			*target = &t
+		}
+	}
+	if raw := c.QueryParam("before_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid before_id")
+		}
+		filter.BeforeID = uint(id)
+	}
+	if raw := c.QueryParam("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > 1000 {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 1000")
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// ListAuditLog returns the newest audit log entries matching the query (admin only)
+func (h *Handler) ListAuditLog(c echo.Context) error {
+	filter, err := auditLogFilter(c)
+	if err != nil {
+		return err
+	}
+
+	entries, err := h.auditService.List(filter)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get audit log")
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// ExportAuditLog downloads all audit log entries matching the query, oldest
+// first, as JSON lines or with ?format=csv (admin only)
+func (h *Handler) ExportAuditLog(c echo.Context) error {
+	filter, err := auditLogFilter(c)
+	if err != nil {
+		return err
+	}
+
+	format := c.QueryParam("format")
+	contentType := "application/x-ndjson"
+	switch format {
+	case "", services.AuditExportJSONL:
+		format = services.AuditExportJSONL
+	case services.AuditExportCSV:
+		contentType = "text/csv"
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "format must be jsonl or csv")
+	}
+
+	filename := fmt.Sprintf("audit-log-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	c.Response().WriteHeader(http.StatusOK)
+	return h.auditService.Export(c.Response(), filter, format)
+}
+
+// VerifyAuditLog checks the hash chain of the audit log for tampering (admin only)
+func (h *Handler) VerifyAuditLog(c echo.Context) error {
+	result, err := h.auditService.Verify()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to verify audit log")
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// AuditService returns the audit log fed by the Audit middleware
+func (h *Handler) AuditService() *services.AuditService {
+	return h.auditService
+}
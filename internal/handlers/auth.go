@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"ai_gateway/internal/middleware"
@@ -54,6 +55,7 @@ func (h *Handler) Register(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	middleware.AuditActor(c, user)
 
 	return c.JSON(http.StatusCreated, UserResponse{
 		ID:       user.ID,
@@ -75,8 +77,9 @@ func (h *Handler) Login(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 	}
+	middleware.AuditActor(c, user)
 
-	token, err := h.authService.CreateToken(user)
+	token, err := h.authService.CreateToken(user, c.RealIP(), c.Request().UserAgent())
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create token")
 	}
@@ -102,3 +105,28 @@ func (h *Handler) GetCurrentUser(c echo.Context) error {
 		IsAdmin:  user.IsAdmin,
 	})
 }
+
+// CreateEphemeralToken exchanges the API key authenticating the request for a
+// short-lived ek- token, optionally limited to some models and endpoints, that
+// browser and mobile clients can hold instead of the key
+func (h *Handler) CreateEphemeralToken(c echo.Context) error {
+	apiKey := middleware.GetAPIKey(c)
+	if apiKey == nil || middleware.GetTokenScope(c) != nil {
+		return echo.NewHTTPError(http.StatusForbidden, "ephemeral tokens can only be created with an API key")
+	}
+
+	var req services.EphemeralTokenRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	token, err := h.authService.CreateEphemeralToken(apiKey, &req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidEphemeralToken) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, token)
+}
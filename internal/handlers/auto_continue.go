@@ -0,0 +1,982 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/models"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderAutoContinue reports how many continuation requests were stitched into
+// a non-streaming response
+const HeaderAutoContinue = "X-Auto-Continue"
+
+// maxAutoContinuations bounds the continuation requests of one response
+const maxAutoContinuations = 8
+
+// autoContinuePrompt asks the model to go on with a response cut off at max tokens
+const autoContinuePrompt = "Your previous response was cut off. Continue exactly where it stopped, without repeating any of it or adding commentary."
+
+// autoContinueBudget returns the completion tokens an auto-continued response
+// of the request's API key may reach, 0 when the key does not continue
+// responses. Requests with a cost ceiling are never continued, as it bounds
+// a single request.
+func autoContinueBudget(c echo.Context) int {
+	apiKey := middleware.GetAPIKey(c)
+	if apiKey == nil || c.Request().Header.Get(HeaderMaxCost) != "" {
+		return 0
+	}
+	return apiKey.AutoContinueTokens
+}
+
+// continuationSegment is what one request of an auto-continued response produced
+type continuationSegment struct {
+	text      string // generated text
+	truncated bool   // stopped at max tokens after text only
+	usage     models.Usage
+	reported  bool // the upstream reported the usage
+}
+
+// continuationTotal sums the segments of an auto-continued response
+type continuationTotal struct {
+	usage     models.Usage
+	estimated bool
+	requests  int
+}
+
+// add counts a segment, estimating the usage the upstream did not report
+func (t *continuationTotal) add(c echo.Context, seg continuationSegment) {
+	t.requests++
+	if seg.reported {
+		addUsage(&t.usage, &seg.usage)
+		return
+	}
+	t.estimated = true
+	promptTokens, _ := promptEstimate(c)
+	addUsage(&t.usage, &models.Usage{PromptTokens: promptTokens, CompletionTokens: services.EstimateTokens(len(seg.text))})
+}
+
+// autoContinuation sends the requests of one auto-continued response in an
+// inbound protocol and stitches their output
+type autoContinuation struct {
+	endpoint  string
+	model     string
+	stream    bool
+	maxTokens int // max tokens of each request, 0 when unset
+
+	dispatch func(sc echo.Context) error                           // sends the current request
+	extend   func(text string, maxTokens int)                      // asks for the continuation of text
+	parse    func(resp map[string]interface{}) continuationSegment // non-streaming
+	stitch   func(resp, next map[string]interface{})               // non-streaming: appends next to resp
+	stitcher streamStitcher                                        // streaming
+}
+
+// next returns the max tokens of the request continuing seg, or false when the
+// response ends with it
+func (ac *autoContinuation) next(seg continuationSegment, total *continuationTotal, budget int) (int, bool) {
+	remaining := budget - total.usage.CompletionTokens
+	if !seg.truncated || remaining <= 0 || total.requests > maxAutoContinuations {
+		return 0, false
+	}
+	if ac.maxTokens > 0 && ac.maxTokens < remaining {
+		return ac.maxTokens, true
+	}
+	return remaining, true
+}
+
+// autoContinue sends the request of ac and, while its response is cut off at
+// max tokens and the key's budget allows, follow-up requests asking the model
+// to continue, returning their output to the client as one response. All the
+// requests are recorded as one.
+func (h *Handler) autoContinue(c echo.Context, ac *autoContinuation, budget int) error {
+	if ac.stream {
+		return h.autoContinueStream(c, ac, budget)
+	}
+
+	var resp map[string]interface{}
+	var total continuationTotal
+	for {
+		rec := newCompletionRecorder()
+		rec.err = ac.dispatch(h.continuationContext(c, rec))
+		body, ok := rec.object()
+		if !ok {
+			if resp == nil {
+				return rec.replay(c)
+			}
+			middleware.LogTrace(c, "AutoContinue", "Continuation failed with status %d; returning the response so far", rec.statusCode())
+			break
+		}
+
+		seg := ac.parse(body)
+		total.add(c, seg)
+		if resp == nil {
+			resp = body
+		} else {
+			ac.stitch(resp, body)
+		}
+		maxTokens, ok := ac.next(seg, &total, budget)
+		if !ok {
+			break
+		}
+		middleware.LogTrace(c, "AutoContinue", "Response cut off at max tokens after %d completion tokens; continuing with max tokens %d", total.usage.CompletionTokens, maxTokens)
+		ac.extend(seg.text, maxTokens)
+	}
+
+	h.recordContinuation(c, ac, &total)
+	c.Response().Header().Set(HeaderAutoContinue, strconv.Itoa(total.requests-1))
+	return c.JSON(http.StatusOK, resp)
+}
+
+// autoContinueStream is autoContinue for streaming requests: the streams of
+// the requests are joined into one by the stitcher of ac
+func (h *Handler) autoContinueStream(c echo.Context, ac *autoContinuation, budget int) error {
+	w := &continuationWriter{client: c.Response(), stitcher: ac.stitcher}
+	var total continuationTotal
+	var streamErr error
+	for {
+		err := ac.dispatch(h.continuationContext(c, w))
+		w.endSegment()
+		if total.requests == 0 && (w.status == 0 || w.status >= 300) {
+			// The first request failed before streaming and was passed through
+			return err
+		}
+		if w.status == 0 || w.status >= 300 {
+			middleware.LogTrace(c, "AutoContinue", "Continuation failed with status %d; ending the stream", w.status)
+			break
+		}
+
+		seg := ac.stitcher.segment()
+		total.add(c, seg)
+		if err != nil {
+			streamErr = err
+			break
+		}
+		maxTokens, ok := ac.next(seg, &total, budget)
+		if !ok {
+			break
+		}
+		middleware.LogTrace(c, "AutoContinue", "Stream cut off at max tokens after %d completion tokens; continuing with max tokens %d", total.usage.CompletionTokens, maxTokens)
+		ac.extend(seg.text, maxTokens)
+		w.nextSegment()
+	}
+
+	w.write(ac.stitcher.finish(total.usage))
+	c.Response().Flush()
+	h.recordContinuation(c, ac, &total)
+	return streamErr
+}
+
+// continuationContext returns the context of one request of an auto-continued
+// response. The request keeps the key's settings but is left out of its usage,
+// so that the response is recorded once.
+func (h *Handler) continuationContext(c echo.Context, w http.ResponseWriter) echo.Context {
+	sc := subContext(c, c.Request(), w)
+	sc.Set(middleware.ContextKeyProviderConfig, middleware.GetProviderConfig(c))
+	sc.Set(contextKeyUsageUnrecorded, true)
+	return sc
+}
+
+// recordContinuation records the combined usage of an auto-continued response
+func (h *Handler) recordContinuation(c echo.Context, ac *autoContinuation, total *continuationTotal) {
+	apiKey := middleware.GetAPIKey(c)
+	if apiKey == nil || total.requests == 0 {
+		return
+	}
+	if total.requests > 1 {
+		middleware.LogTrace(c, "AutoContinue", "Stitched %d requests: prompt=%d completion=%d", total.requests, total.usage.PromptTokens, total.usage.CompletionTokens)
+	}
+	if total.estimated {
+		h.apiKeyService.RecordEstimatedUsage(apiKey.ID, ac.endpoint, ac.model, total.usage.PromptTokens, total.usage.CompletionTokens, http.StatusOK, usageAttribution(c))
+		return
+	}
+	h.apiKeyService.RecordUsage(apiKey.ID, ac.endpoint, ac.model, total.usage.PromptTokens, total.usage.CompletionTokens, http.StatusOK, usageAttribution(c))
+}
+
+// streamStitcher joins the event streams of the requests of an auto-continued
+// response, holding back the events closing each until it is known to be the
+// last
+type streamStitcher interface {
+	// event returns what to send in place of an event of the segment-th request
+	event(segment int, event string) []string
+	// segment returns what the request that just ended produced
+	segment() continuationSegment
+	// finish returns the held closing events, reporting the combined usage
+	finish(usage models.Usage) []string
+}
+
+// continuationWriter writes the streams of an auto-continued response to the
+// client as one, passing each event through the stitcher. Only the first
+// request's status and headers reach the client; the output of a failed
+// continuation is dropped.
+type continuationWriter struct {
+	client   *echo.Response
+	stitcher streamStitcher
+	mu       sync.Mutex
+	header   http.Header
+	segment  int
+	status   int
+	buf      bytes.Buffer // incomplete last event
+}
+
+func (w *continuationWriter) Header() http.Header {
+	if w.segment == 0 {
+		return w.client.Header()
+	}
+	if w.header == nil {
+		w.header = http.Header{}
+	}
+	return w.header
+}
+
+func (w *continuationWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	if w.segment == 0 {
+		w.client.WriteHeader(code)
+	}
+}
+
+func (w *continuationWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status >= 300 {
+		if w.segment == 0 {
+			return w.client.Write(b)
+		}
+		return len(b), nil
+	}
+
+	w.buf.Write(bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n")))
+	for {
+		data := w.buf.Bytes()
+		idx := bytes.Index(data, []byte("\n\n"))
+		if idx < 0 {
+			return len(b), nil
+		}
+		event := string(data[:idx])
+		w.buf.Next(idx + 2)
+		w.write(w.stitcher.event(w.segment, event))
+	}
+}
+
+func (w *continuationWriter) Flush() {
+	w.client.Flush()
+}
+
+// write sends events to the client
+func (w *continuationWriter) write(events []string) {
+	for _, event := range events {
+		w.client.Write([]byte(event + "\n\n"))
+	}
+}
+
+// endSegment passes on the last event of a request left without its blank line
+func (w *continuationWriter) endSegment() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if event := strings.TrimSpace(w.buf.String()); event != "" && w.status < 300 {
+		w.write(w.stitcher.event(w.segment, event))
+	}
+	w.buf.Reset()
+}
+
+// nextSegment prepares for the stream of the next request
+func (w *continuationWriter) nextSegment() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.segment++
+	w.status = 0
+	w.header = nil
+}
+
+// usageCount returns a token count of a decoded JSON usage object
+func usageCount(v interface{}) int {
+	n, _ := v.(float64)
+	return int(n)
+}
+
+// sumUsage adds the token counts of next's usage object to those of resp
+func sumUsage(resp, next map[string]interface{}, field string, counts ...string) {
+	usage, _ := resp[field].(map[string]interface{})
+	nextUsage, _ := next[field].(map[string]interface{})
+	if usage == nil || nextUsage == nil {
+		return
+	}
+	for _, count := range counts {
+		if _, ok := usage[count]; ok {
+			usage[count] = float64(usageCount(usage[count]) + usageCount(nextUsage[count]))
+		}
+	}
+}
+
+// copyRequest deep-copies a request model into dst
+func copyRequest(src, dst interface{}) error {
+	body, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, dst)
+}
+
+// autoContinuedChatCompletion sends a chat completion, continuing it while it
+// is cut off at max tokens
+func (h *Handler) autoContinuedChatCompletion(c echo.Context, req *models.ChatCompletionRequest, budget int, baseURL, apiKey, protocol string) error {
+	if req.N != nil && *req.N > 1 {
+		return h.dispatchChatCompletion(c, req, baseURL, apiKey, protocol)
+	}
+	ac := &autoContinuation{
+		endpoint: "/v1/chat/completions",
+		model:    req.Model,
+		stream:   req.Stream,
+		dispatch: func(sc echo.Context) error {
+			turn, err := cloneChatRequest(req)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to copy request")
+			}
+			return h.dispatchChatCompletion(sc, turn, baseURL, apiKey, protocol)
+		},
+		extend: func(text string, maxTokens int) {
+			req.Messages = append(req.Messages,
+				models.ChatMessage{Role: "assistant", Content: text},
+				models.ChatMessage{Role: "user", Content: autoContinuePrompt})
+			req.MaxTokens = &maxTokens
+		},
+		parse:    parseChatSegment,
+		stitch:   stitchChatCompletion,
+		stitcher: &chatStitcher{},
+	}
+	if req.MaxTokens != nil {
+		ac.maxTokens = *req.MaxTokens
+	}
+	return h.autoContinue(c, ac, budget)
+}
+
+// parseChatSegment inspects a chat completion of an auto-continued response
+func parseChatSegment(resp map[string]interface{}) continuationSegment {
+	var seg continuationSegment
+	if usage, ok := resp["usage"].(map[string]interface{}); ok {
+		seg.usage = models.Usage{PromptTokens: usageCount(usage["prompt_tokens"]), CompletionTokens: usageCount(usage["completion_tokens"])}
+		seg.reported = true
+	}
+	choices, _ := resp["choices"].([]interface{})
+	if len(choices) != 1 {
+		return seg
+	}
+	choice, _ := choices[0].(map[string]interface{})
+	message, _ := choice["message"].(map[string]interface{})
+	seg.text, _ = message["content"].(string)
+	seg.truncated = choice["finish_reason"] == "length" && message["tool_calls"] == nil && seg.text != ""
+	return seg
+}
+
+// stitchChatCompletion appends the continuation next to the chat completion resp
+func stitchChatCompletion(resp, next map[string]interface{}) {
+	choices, _ := resp["choices"].([]interface{})
+	nextChoices, _ := next["choices"].([]interface{})
+	if len(choices) != 1 || len(nextChoices) != 1 {
+		return
+	}
+	choice, _ := choices[0].(map[string]interface{})
+	nextChoice, _ := nextChoices[0].(map[string]interface{})
+	message, _ := choice["message"].(map[string]interface{})
+	nextMessage, _ := nextChoice["message"].(map[string]interface{})
+	text, _ := message["content"].(string)
+	nextText, _ := nextMessage["content"].(string)
+	message["content"] = text + nextText
+	if toolCalls, ok := nextMessage["tool_calls"]; ok {
+		message["tool_calls"] = toolCalls
+	}
+	choice["finish_reason"] = nextChoice["finish_reason"]
+	sumUsage(resp, next, "usage", "prompt_tokens", "completion_tokens", "total_tokens")
+}
+
+// chatStitcher joins chat completion streams: continuation chunks take the
+// first chunk's ID, and the finish chunk, usage and [DONE] of each request are
+// held back
+type chatStitcher struct {
+	id        interface{}
+	fields    map[string]interface{} // chunk fields repeated in the usage chunk
+	closing   string                 // held finish chunk
+	text      strings.Builder
+	reason    string
+	toolCalls bool
+	usage     *models.Usage
+	reported  bool // every request reported usage
+}
+
+func (s *chatStitcher) event(segment int, event string) []string {
+	payload, ok := eventData(event)
+	if !ok || payload == "[DONE]" {
+		if payload == "[DONE]" {
+			return nil
+		}
+		return []string{event}
+	}
+	var chunk map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &chunk); err != nil || chunk["error"] != nil {
+		return []string{event}
+	}
+	if s.id == nil {
+		s.id = chunk["id"]
+	} else if _, ok := chunk["id"]; ok {
+		chunk["id"] = s.id
+	}
+	if s.fields == nil {
+		s.fields = make(map[string]interface{})
+	}
+	for _, field := range []string{"object", "created", "model", "system_fingerprint"} {
+		if value, ok := chunk[field]; ok {
+			s.fields[field] = value
+		}
+	}
+
+	hadUsage := false
+	if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+		s.usage = &models.Usage{PromptTokens: usageCount(usage["prompt_tokens"]), CompletionTokens: usageCount(usage["completion_tokens"])}
+		delete(chunk, "usage")
+		hadUsage = true
+	}
+	choices, _ := chunk["choices"].([]interface{})
+	if len(choices) == 0 && hadUsage {
+		return nil
+	}
+
+	finished := false
+	for _, raw := range choices {
+		choice, _ := raw.(map[string]interface{})
+		delta, _ := choice["delta"].(map[string]interface{})
+		if content, ok := delta["content"].(string); ok {
+			s.text.WriteString(content)
+		}
+		if delta["tool_calls"] != nil {
+			s.toolCalls = true
+		}
+		if reason, _ := choice["finish_reason"].(string); reason != "" {
+			s.reason = reason
+			finished = true
+		}
+	}
+	out, err := json.Marshal(chunk)
+	if err != nil {
+		return []string{event}
+	}
+	if finished {
+		s.closing = "data: " + string(out)
+		return nil
+	}
+	return []string{"data: " + string(out)}
+}
+
+func (s *chatStitcher) segment() continuationSegment {
+	seg := continuationSegment{
+		text:      s.text.String(),
+		truncated: s.reason == "length" && !s.toolCalls && s.text.Len() > 0,
+	}
+	if s.usage != nil {
+		seg.usage = *s.usage
+		seg.reported = true
+	}
+	s.reported = s.reported || seg.reported
+	s.text.Reset()
+	s.reason = ""
+	s.toolCalls = false
+	s.usage = nil
+	return seg
+}
+
+func (s *chatStitcher) finish(usage models.Usage) []string {
+	if s.closing == "" {
+		return nil
+	}
+	events := []string{s.closing}
+	if s.reported {
+		chunk := map[string]interface{}{
+			"id":      s.id,
+			"choices": []interface{}{},
+			"usage": map[string]interface{}{
+				"prompt_tokens":     usage.PromptTokens,
+				"completion_tokens": usage.CompletionTokens,
+				"total_tokens":      usage.PromptTokens + usage.CompletionTokens,
+			},
+		}
+		for field, value := range s.fields {
+			chunk[field] = value
+		}
+		if out, err := json.Marshal(chunk); err == nil {
+			events = append(events, "data: "+string(out))
+		}
+	}
+	return append(events, "data: [DONE]")
+}
+
+// autoContinuedMessages sends a Messages request, continuing the response
+// while it is cut off at max tokens
+func (h *Handler) autoContinuedMessages(c echo.Context, req *models.MessagesRequest, budget int, baseURL, apiKey, protocol string) error {
+	ac := &autoContinuation{
+		endpoint:  "/v1/messages",
+		model:     req.Model,
+		stream:    req.Stream,
+		maxTokens: req.MaxTokens,
+		dispatch: func(sc echo.Context) error {
+			var turn models.MessagesRequest
+			if err := copyRequest(req, &turn); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to copy request")
+			}
+			return h.dispatchMessages(sc, &turn, baseURL, apiKey, protocol)
+		},
+		extend: func(text string, maxTokens int) {
+			req.Messages = append(req.Messages,
+				models.AnthropicMessage{Role: "assistant", Content: text},
+				models.AnthropicMessage{Role: "user", Content: autoContinuePrompt})
+			req.MaxTokens = maxTokens
+		},
+		parse:    parseMessagesSegment,
+		stitch:   stitchMessages,
+		stitcher: &anthropicStitcher{},
+	}
+	return h.autoContinue(c, ac, budget)
+}
+
+// isThinkingBlock reports whether an Anthropic content block type is a thinking block
+func isThinkingBlock(blockType interface{}) bool {
+	return blockType == "thinking" || blockType == "redacted_thinking"
+}
+
+// parseMessagesSegment inspects a Messages response of an auto-continued response
+func parseMessagesSegment(resp map[string]interface{}) continuationSegment {
+	var seg continuationSegment
+	if usage, ok := resp["usage"].(map[string]interface{}); ok {
+		seg.usage = models.Usage{PromptTokens: usageCount(usage["input_tokens"]), CompletionTokens: usageCount(usage["output_tokens"])}
+		seg.reported = true
+	}
+	var text strings.Builder
+	endsInText, toolUse := false, false
+	content, _ := resp["content"].([]interface{})
+	for _, raw := range content {
+		block, _ := raw.(map[string]interface{})
+		if isThinkingBlock(block["type"]) {
+			continue
+		}
+		endsInText = block["type"] == "text"
+		if endsInText {
+			blockText, _ := block["text"].(string)
+			text.WriteString(blockText)
+		} else {
+			toolUse = true
+		}
+	}
+	seg.text = text.String()
+	seg.truncated = resp["stop_reason"] == "max_tokens" && endsInText && !toolUse && seg.text != ""
+	return seg
+}
+
+// stitchMessages appends the continuation next to the Messages response resp:
+// its first text block goes on in the last text block of resp
+func stitchMessages(resp, next map[string]interface{}) {
+	content, _ := resp["content"].([]interface{})
+	var last map[string]interface{}
+	if len(content) > 0 {
+		last, _ = content[len(content)-1].(map[string]interface{})
+	}
+	nextContent, _ := next["content"].([]interface{})
+	for _, raw := range nextContent {
+		block, _ := raw.(map[string]interface{})
+		if isThinkingBlock(block["type"]) {
+			continue
+		}
+		if block["type"] == "text" && last != nil && last["type"] == "text" {
+			text, _ := last["text"].(string)
+			nextText, _ := block["text"].(string)
+			last["text"] = text + nextText
+			last = nil
+			continue
+		}
+		content = append(content, block)
+	}
+	resp["content"] = content
+	resp["stop_reason"] = next["stop_reason"]
+	resp["stop_sequence"] = next["stop_sequence"]
+	sumUsage(resp, next, "usage", "input_tokens", "output_tokens")
+}
+
+// anthropicStitcher joins Messages streams: a continuation's message_start and
+// thinking blocks are dropped, its first text block goes on in the text block
+// the previous request stopped in and its other blocks are renumbered. The
+// stop of the last text block, message_delta and message_stop of each request
+// are held back.
+type anthropicStitcher struct {
+	blocks    map[int]int  // this request's block indexes to sent ones, -1 for dropped blocks
+	texts     map[int]bool // sent indexes of text blocks
+	next      int          // sent index of the next block
+	merged    bool         // this request's first text block went on in the held one
+	stop      string       // held content_block_stop of the last text block
+	stopIndex int
+	delta     map[string]interface{} // held message_delta
+	text      strings.Builder
+	reason    string
+	endsText  bool
+	toolUse   bool
+	usage     models.Usage
+	reported  bool
+}
+
+// sse formats an Anthropic event
+func (s *anthropicStitcher) sse(body map[string]interface{}) string {
+	name, _ := body["type"].(string)
+	out, _ := json.Marshal(body)
+	return "event: " + name + "\ndata: " + string(out)
+}
+
+// release returns the held stop of a text block that another block follows
+func (s *anthropicStitcher) release() []string {
+	if s.stop == "" {
+		return nil
+	}
+	stop := s.stop
+	s.stop = ""
+	return []string{stop}
+}
+
+func (s *anthropicStitcher) event(segment int, event string) []string {
+	payload, ok := eventData(event)
+	if !ok {
+		return []string{event}
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &body); err != nil {
+		return []string{event}
+	}
+	if s.blocks == nil {
+		s.blocks = make(map[int]int)
+	}
+	if s.texts == nil {
+		s.texts = make(map[int]bool)
+	}
+
+	switch body["type"] {
+	case "message_start":
+		message, _ := body["message"].(map[string]interface{})
+		if usage, ok := message["usage"].(map[string]interface{}); ok {
+			s.usage = models.Usage{PromptTokens: usageCount(usage["input_tokens"]), CompletionTokens: usageCount(usage["output_tokens"])}
+			s.reported = true
+		}
+		if segment > 0 {
+			return nil
+		}
+	case "content_block_start":
+		index := usageCount(body["index"])
+		block, _ := body["content_block"].(map[string]interface{})
+		s.endsText = false
+		switch {
+		case segment > 0 && isThinkingBlock(block["type"]):
+			s.blocks[index] = -1
+			return nil
+		case segment > 0 && block["type"] == "text" && !s.merged && s.stop != "":
+			s.merged = true
+			s.blocks[index] = s.stopIndex
+			return nil
+		}
+		if block["type"] == "tool_use" {
+			s.toolUse = true
+		}
+		events := s.release()
+		s.blocks[index] = s.next
+		s.texts[s.next] = block["type"] == "text"
+		s.next++
+		body["index"] = s.blocks[index]
+		return append(events, s.sse(body))
+	case "content_block_delta", "content_block_stop":
+		sent, ok := s.blocks[usageCount(body["index"])]
+		if ok && sent < 0 {
+			return nil
+		}
+		if !ok {
+			return []string{event}
+		}
+		body["index"] = sent
+		if body["type"] == "content_block_delta" {
+			if delta, _ := body["delta"].(map[string]interface{}); delta["type"] == "text_delta" {
+				text, _ := delta["text"].(string)
+				s.text.WriteString(text)
+			}
+			return []string{s.sse(body)}
+		}
+		if s.texts[sent] {
+			s.stop = s.sse(body)
+			s.stopIndex = sent
+			s.endsText = true
+			return nil
+		}
+		return []string{s.sse(body)}
+	case "message_delta":
+		if delta, _ := body["delta"].(map[string]interface{}); delta != nil {
+			s.reason, _ = delta["stop_reason"].(string)
+		}
+		if usage, ok := body["usage"].(map[string]interface{}); ok {
+			s.usage.CompletionTokens = usageCount(usage["output_tokens"])
+			if _, ok := usage["input_tokens"]; ok {
+				s.usage.PromptTokens = usageCount(usage["input_tokens"])
+			}
+		}
+		s.delta = body
+		return nil
+	case "message_stop":
+		return nil
+	}
+	return []string{event}
+}
+
+func (s *anthropicStitcher) segment() continuationSegment {
+	seg := continuationSegment{
+		text:      s.text.String(),
+		truncated: s.reason == "max_tokens" && s.endsText && !s.toolUse && s.text.Len() > 0,
+		usage:     s.usage,
+		reported:  s.reported,
+	}
+	s.blocks = nil
+	s.merged = false
+	s.text.Reset()
+	s.reason = ""
+	s.endsText = false
+	s.toolUse = false
+	s.usage = models.Usage{}
+	s.reported = false
+	return seg
+}
+
+func (s *anthropicStitcher) finish(usage models.Usage) []string {
+	if s.delta == nil {
+		return nil
+	}
+	events := s.release()
+	if deltaUsage, ok := s.delta["usage"].(map[string]interface{}); ok {
+		deltaUsage["output_tokens"] = usage.CompletionTokens
+		if _, ok := deltaUsage["input_tokens"]; ok {
+			deltaUsage["input_tokens"] = usage.PromptTokens
+		}
+	}
+	return append(events, s.sse(s.delta), s.sse(map[string]interface{}{"type": "message_stop"}))
+}
+
+// autoContinuedGenerateContent sends a generateContent request, continuing the
+// response while it is cut off at max tokens
+func (h *Handler) autoContinuedGenerateContent(c echo.Context, req *models.GenerateContentRequest, model string, budget int, baseURL, apiKey, protocol string, isStream bool) error {
+	if gc := req.GenerationConfig; gc != nil && gc.CandidateCount != nil && *gc.CandidateCount > 1 {
+		return h.dispatchGenerateContent(c, req, model, baseURL, apiKey, protocol, isStream)
+	}
+	ac := &autoContinuation{
+		endpoint: "/v1/models/" + model,
+		model:    model,
+		stream:   isStream,
+		dispatch: func(sc echo.Context) error {
+			var turn models.GenerateContentRequest
+			if err := copyRequest(req, &turn); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to copy request")
+			}
+			return h.dispatchGenerateContent(sc, &turn, model, baseURL, apiKey, protocol, isStream)
+		},
+		extend: func(text string, maxTokens int) {
+			req.Contents = append(req.Contents,
+				models.GeminiContent{Role: "model", Parts: []models.GeminiPart{{Text: text}}},
+				models.GeminiContent{Role: "user", Parts: []models.GeminiPart{{Text: autoContinuePrompt}}})
+			if req.GenerationConfig == nil {
+				req.GenerationConfig = &models.GenerationConfig{}
+			}
+			req.GenerationConfig.MaxOutputTokens = &maxTokens
+		},
+		parse:    parseGeminiSegment,
+		stitch:   stitchGenerateContent,
+		stitcher: &geminiStitcher{},
+	}
+	if gc := req.GenerationConfig; gc != nil && gc.MaxOutputTokens != nil {
+		ac.maxTokens = *gc.MaxOutputTokens
+	}
+	return h.autoContinue(c, ac, budget)
+}
+
+// geminiCandidate returns the only candidate of a Gemini response and its parts
+func geminiCandidate(resp map[string]interface{}) (map[string]interface{}, []interface{}) {
+	candidates, _ := resp["candidates"].([]interface{})
+	if len(candidates) != 1 {
+		return nil, nil
+	}
+	candidate, _ := candidates[0].(map[string]interface{})
+	content, _ := candidate["content"].(map[string]interface{})
+	parts, _ := content["parts"].([]interface{})
+	return candidate, parts
+}
+
+// parseGeminiSegment inspects a Gemini response of an auto-continued response
+func parseGeminiSegment(resp map[string]interface{}) continuationSegment {
+	var seg continuationSegment
+	if usage, ok := resp["usageMetadata"].(map[string]interface{}); ok {
+		seg.usage = models.Usage{PromptTokens: usageCount(usage["promptTokenCount"]), CompletionTokens: usageCount(usage["candidatesTokenCount"])}
+		seg.reported = true
+	}
+	candidate, parts := geminiCandidate(resp)
+	if candidate == nil {
+		return seg
+	}
+	var text strings.Builder
+	calls := false
+	for _, raw := range parts {
+		part, _ := raw.(map[string]interface{})
+		if partText, ok := part["text"].(string); ok && part["thought"] != true {
+			text.WriteString(partText)
+		}
+		if part["functionCall"] != nil {
+			calls = true
+		}
+	}
+	seg.text = text.String()
+	seg.truncated = candidate["finishReason"] == "MAX_TOKENS" && !calls && seg.text != ""
+	return seg
+}
+
+// stitchGenerateContent appends the continuation next to the Gemini response
+// resp: its first text part goes on in the last text part of resp
+func stitchGenerateContent(resp, next map[string]interface{}) {
+	candidate, parts := geminiCandidate(resp)
+	nextCandidate, nextParts := geminiCandidate(next)
+	if candidate == nil || nextCandidate == nil {
+		return
+	}
+	var last map[string]interface{}
+	if len(parts) > 0 {
+		last, _ = parts[len(parts)-1].(map[string]interface{})
+	}
+	for _, raw := range nextParts {
+		part, _ := raw.(map[string]interface{})
+		if part["thought"] == true {
+			continue
+		}
+		if nextText, ok := part["text"].(string); ok && last != nil {
+			if text, ok := last["text"].(string); ok {
+				last["text"] = text + nextText
+				last = nil
+				continue
+			}
+		}
+		parts = append(parts, part)
+	}
+	if content, ok := candidate["content"].(map[string]interface{}); ok {
+		content["parts"] = parts
+	}
+	candidate["finishReason"] = nextCandidate["finishReason"]
+	sumUsage(resp, next, "usageMetadata", "promptTokenCount", "candidatesTokenCount", "totalTokenCount")
+}
+
+// geminiStitcher joins generateContent streams: the finish reason and usage
+// of each request are split off its last chunk and held back
+type geminiStitcher struct {
+	closing map[string]interface{} // held chunk with the finish reason
+	text    strings.Builder
+	reason  string
+	calls   bool
+	usage   *models.Usage
+}
+
+func (s *geminiStitcher) event(segment int, event string) []string {
+	payload, ok := eventData(event)
+	if !ok {
+		return []string{event}
+	}
+	var chunk map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &chunk); err != nil || chunk["error"] != nil {
+		return []string{event}
+	}
+	if usage, ok := chunk["usageMetadata"].(map[string]interface{}); ok {
+		s.usage = &models.Usage{PromptTokens: usageCount(usage["promptTokenCount"]), CompletionTokens: usageCount(usage["candidatesTokenCount"])}
+		delete(chunk, "usageMetadata")
+	}
+
+	reason := ""
+	hasParts := false
+	candidate, parts := geminiCandidate(chunk)
+	for _, raw := range parts {
+		part, _ := raw.(map[string]interface{})
+		if text, ok := part["text"].(string); ok && part["thought"] != true {
+			s.text.WriteString(text)
+		}
+		if part["functionCall"] != nil {
+			s.calls = true
+		}
+		hasParts = true
+	}
+	if candidate != nil {
+		reason, _ = candidate["finishReason"].(string)
+		delete(candidate, "finishReason")
+	}
+
+	var events []string
+	if reason == "" || hasParts {
+		out, err := json.Marshal(chunk)
+		if err != nil {
+			return []string{event}
+		}
+		events = append(events, "data: "+string(out))
+	}
+	if reason != "" {
+		s.reason = reason
+		s.closing = map[string]interface{}{
+			"candidates": []interface{}{map[string]interface{}{
+				"content":      map[string]interface{}{"role": "model", "parts": []interface{}{map[string]interface{}{"text": ""}}},
+				"finishReason": reason,
+				"index":        0,
+			}},
+		}
+		for _, field := range []string{"modelVersion", "responseId"} {
+			if value, ok := chunk[field]; ok {
+				s.closing[field] = value
+			}
+		}
+	}
+	return events
+}
+
+func (s *geminiStitcher) segment() continuationSegment {
+	seg := continuationSegment{
+		text:      s.text.String(),
+		truncated: s.reason == "MAX_TOKENS" && !s.calls && s.text.Len() > 0,
+	}
+	if s.usage != nil {
+		seg.usage = *s.usage
+		seg.reported = true
+	}
+	s.text.Reset()
+	s.reason = ""
+	s.calls = false
+	s.usage = nil
+	return seg
+}
+
+func (s *geminiStitcher) finish(usage models.Usage) []string {
+	if s.closing == nil {
+		return nil
+	}
+	s.closing["usageMetadata"] = map[string]interface{}{
+		"promptTokenCount":     usage.PromptTokens,
+		"candidatesTokenCount": usage.CompletionTokens,
+		"totalTokenCount":      usage.PromptTokens + usage.CompletionTokens,
+	}
+	out, err := json.Marshal(s.closing)
+	if err != nil {
+		return nil
+	}
+	return []string{"data: " + string(out)}
+}
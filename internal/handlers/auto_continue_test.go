@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// chatUpstream is an OpenAI-compatible upstream answering each chat
+// completion with the next of its replies
+type chatUpstream struct {
+	*httptest.Server
+	mu       sync.Mutex
+	replies  []string // response bodies, SSE streams for streaming requests
+	requests []map[string]interface{}
+}
+
+func newChatUpstream(t *testing.T, replies ...string) *chatUpstream {
+	u := &chatUpstream{replies: replies}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		u.mu.Lock()
+		n := len(u.requests)
+		u.requests = append(u.requests, body)
+		u.mu.Unlock()
+		if n >= len(u.replies) {
+			t.Errorf("unexpected upstream request %d: %v", n+1, body)
+			http.Error(w, `{"error":{"message":"no more replies"}}`, http.StatusInternalServerError)
+			return
+		}
+		if body["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.Write([]byte(u.replies[n]))
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// received returns the requests the upstream received
+func (u *chatUpstream) received() []map[string]interface{} {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]map[string]interface{}(nil), u.requests...)
+}
+
+// chatReply is a chat completion with one choice
+func chatReply(content, finishReason string, promptTokens, completionTokens int) string {
+	return fmt.Sprintf(`{"id":"chatcmpl-%d","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":%q}],"usage":{"prompt_tokens":%d,"completion_tokens":%d,"total_tokens":%d}}`,
+		completionTokens, content, finishReason, promptTokens, completionTokens, promptTokens+completionTokens)
+}
+
+// chatStreamReply is a chat completion stream sending content in two chunks
+func chatStreamReply(id, content, finishReason string, promptTokens, completionTokens int) string {
+	half := len(content) / 2
+	chunk := func(choices string) string {
+		return fmt.Sprintf(`data: {"id":%q,"object":"chat.completion.chunk","model":"gpt-4o","choices":%s}`+"\n\n", id, choices)
+	}
+	return chunk(fmt.Sprintf(`[{"index":0,"delta":{"role":"assistant","content":%q}}]`, content[:half])) +
+		chunk(fmt.Sprintf(`[{"index":0,"delta":{"content":%q}}]`, content[half:])) +
+		chunk(fmt.Sprintf(`[{"index":0,"delta":{},"finish_reason":%q}]`, finishReason)) +
+		fmt.Sprintf(`data: {"id":%q,"object":"chat.completion.chunk","model":"gpt-4o","choices":[],"usage":{"prompt_tokens":%d,"completion_tokens":%d,"total_tokens":%d}}`+"\n\n",
+			id, promptTokens, completionTokens, promptTokens+completionTokens) +
+		"data: [DONE]\n\n"
+}
+
+// chatTestHandler returns a handler and an API key routed to the upstream at
+// baseURL, continuing responses up to autoContinueTokens
+func chatTestHandler(t *testing.T, baseURL string, autoContinueTokens int) (*Handler, *database.APIKey, *gorm.DB) {
+	db, err := database.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		JWTSecret:           "test-secret",
+		EncryptionKey:       base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")),
+		ServerToolMaxRounds: 8,
+	}
+	encKey, _ := cfg.GetEncryptionKeyBytes()
+	encrypted, err := utils.EncryptAPIKey("sk-upstream", encKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &database.User{Username: "alice", Email: "alice@example.com", HashedPassword: "x", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	providerConfig := database.ProviderConfig{
+		UserID:       user.ID,
+		Provider:     "openai",
+		Protocol:     "openai_chat",
+		Name:         "upstream",
+		BaseURL:      baseURL,
+		EncryptedKey: encrypted,
+		ModelCodes:   `["gpt-4o"]`,
+		IsActive:     true,
+	}
+	if err := db.Create(&providerConfig).Error; err != nil {
+		t.Fatal(err)
+	}
+	apiKey := &database.APIKey{
+		UserID:             user.ID,
+		Name:               "test",
+		KeyHash:            "hash",
+		IsActive:           true,
+		AutoContinueTokens: autoContinueTokens,
+		ProviderConfigs:    []database.ProviderConfig{providerConfig},
+	}
+	if err := db.Create(apiKey).Error; err != nil {
+		t.Fatal(err)
+	}
+	apiKey.User = *user
+	return New(db, cfg), apiKey, db
+}
+
+// postChat sends a chat completion request to h with apiKey
+func postChat(h *Handler, apiKey *database.APIKey, body string) *httptest.ResponseRecorder {
+	return postChatWithHeader(h, apiKey, http.Header{}, body)
+}
+
+// postChatWithHeader sends a chat completion request with extra headers to h with apiKey
+func postChatWithHeader(h *Handler, apiKey *database.APIKey, header http.Header, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set(middleware.ContextKeyAPIKey, apiKey)
+	c.Set(middleware.ContextKeyUser, &apiKey.User)
+	if err := h.OpenAIChatCompletions(c); err != nil {
+		c.Echo().HTTPErrorHandler(err, c)
+	}
+	return rec
+}
+
+// usageRecords returns the usage recorded for apiKey
+func usageRecords(t *testing.T, db *gorm.DB, apiKey *database.APIKey) []database.UsageRecord {
+	var records []database.UsageRecord
+	if err := db.Where("api_key_id = ?", apiKey.ID).Find(&records).Error; err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestAutoContinue_ChatCompletionCutOffAtMaxTokens(t *testing.T) {
+	upstream := newChatUpstream(t,
+		chatReply("Hello", "length", 10, 5),
+		chatReply(" world", "stop", 20, 3))
+	h, apiKey, db := chatTestHandler(t, upstream.URL, 100)
+
+	rec := postChat(h, apiKey, `{"model":"gpt-4o","max_tokens":5,"messages":[{"role":"user","content":"Say hello world"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get(HeaderAutoContinue); got != "1" {
+		t.Errorf("%s = %q, want 1", HeaderAutoContinue, got)
+	}
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+			TotalTokens      int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Choices) != 1 || resp.Choices[0].Message.Content != "Hello world" || resp.Choices[0].FinishReason != "stop" {
+		t.Errorf("stitched response = %s", rec.Body.String())
+	}
+	if resp.Usage.PromptTokens != 30 || resp.Usage.CompletionTokens != 8 || resp.Usage.TotalTokens != 38 {
+		t.Errorf("usage = %+v, want the sum of both requests", resp.Usage)
+	}
+
+	requests := upstream.received()
+	if len(requests) != 2 {
+		t.Fatalf("upstream received %d requests, want 2", len(requests))
+	}
+	messages, _ := requests[1]["messages"].([]interface{})
+	if len(messages) != 3 {
+		t.Fatalf("continuation messages = %v", messages)
+	}
+	assistant, _ := messages[1].(map[string]interface{})
+	prompt, _ := messages[2].(map[string]interface{})
+	if assistant["role"] != "assistant" || assistant["content"] != "Hello" || prompt["role"] != "user" || prompt["content"] != autoContinuePrompt {
+		t.Errorf("continuation messages = %v", messages)
+	}
+	if requests[1]["max_tokens"] != float64(5) {
+		t.Errorf("continuation max_tokens = %v, want the request's 5", requests[1]["max_tokens"])
+	}
+
+	records := usageRecords(t, db, apiKey)
+	if len(records) != 1 || records[0].PromptTokens != 30 || records[0].CompletionTokens != 8 {
+		t.Errorf("usage records = %+v, want one with the summed usage", records)
+	}
+}
+
+func TestAutoContinue_StopsAtKeyLimit(t *testing.T) {
+	upstream := newChatUpstream(t,
+		chatReply("Hello", "length", 10, 5),
+		chatReply(" w", "length", 20, 1))
+	h, apiKey, db := chatTestHandler(t, upstream.URL, 6)
+
+	rec := postChat(h, apiKey, `{"model":"gpt-4o","max_tokens":5,"messages":[{"role":"user","content":"Say hello world"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	requests := upstream.received()
+	if len(requests) != 2 {
+		t.Fatalf("upstream received %d requests, want 2", len(requests))
+	}
+	if requests[1]["max_tokens"] != float64(1) {
+		t.Errorf("continuation max_tokens = %v, want the 1 token left of the key's limit", requests[1]["max_tokens"])
+	}
+	if !strings.Contains(rec.Body.String(), `"content":"Hello w"`) || !strings.Contains(rec.Body.String(), `"finish_reason":"length"`) {
+		t.Errorf("response = %s, want the text so far cut off at max tokens", rec.Body.String())
+	}
+	if records := usageRecords(t, db, apiKey); len(records) != 1 || records[0].CompletionTokens != 6 {
+		t.Errorf("usage records = %+v", records)
+	}
+}
+
+func TestAutoContinue_DisabledForKey(t *testing.T) {
+	upstream := newChatUpstream(t, chatReply("Hello", "length", 10, 5))
+	h, apiKey, _ := chatTestHandler(t, upstream.URL, 0)
+
+	rec := postChat(h, apiKey, `{"model":"gpt-4o","max_tokens":5,"messages":[{"role":"user","content":"Say hello world"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(upstream.received()) != 1 || rec.Header().Get(HeaderAutoContinue) != "" {
+		t.Errorf("a key without auto-continue tokens must not continue responses")
+	}
+}
+
+func TestAutoContinue_StreamedChatCompletion(t *testing.T) {
+	upstream := newChatUpstream(t,
+		chatStreamReply("chatcmpl-a", "Hello", "length", 10, 5),
+		chatStreamReply("chatcmpl-b", " world", "stop", 20, 3))
+	h, apiKey, db := chatTestHandler(t, upstream.URL, 100)
+
+	rec := postChat(h, apiKey, `{"model":"gpt-4o","stream":true,"max_tokens":5,"messages":[{"role":"user","content":"Say hello world"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if n := len(upstream.received()); n != 2 {
+		t.Fatalf("upstream received %d requests, want 2", n)
+	}
+
+	var content strings.Builder
+	var finishReasons []string
+	var usages []map[string]interface{}
+	done := 0
+	for _, event := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+		payload := strings.TrimPrefix(event, "data: ")
+		if payload == "[DONE]" {
+			done++
+			continue
+		}
+		var chunk struct {
+			ID      string `json:"id"`
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage map[string]interface{} `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			t.Fatalf("event %q: %v", event, err)
+		}
+		if chunk.ID != "chatcmpl-a" {
+			t.Errorf("chunk id = %q, want the first request's", chunk.ID)
+		}
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				finishReasons = append(finishReasons, *choice.FinishReason)
+			}
+		}
+		if chunk.Usage != nil {
+			usages = append(usages, chunk.Usage)
+		}
+	}
+	if content.String() != "Hello world" {
+		t.Errorf("streamed content = %q", content.String())
+	}
+	if len(finishReasons) != 1 || finishReasons[0] != "stop" {
+		t.Errorf("finish reasons = %v, want only the last request's", finishReasons)
+	}
+	if len(usages) != 1 || usages[0]["prompt_tokens"] != float64(30) || usages[0]["completion_tokens"] != float64(8) {
+		t.Errorf("usage chunks = %v, want one with the summed usage", usages)
+	}
+	if done != 1 {
+		t.Errorf("stream has %d [DONE] events, want 1", done)
+	}
+	if records := usageRecords(t, db, apiKey); len(records) != 1 || records[0].PromptTokens != 30 || records[0].CompletionTokens != 8 {
+		t.Errorf("usage records = %+v, want one with the summed usage", records)
+	}
+}
+
+func TestAutoContinue_KeepsKeySettings(t *testing.T) {
+	upstream := newChatUpstream(t)
+	h, apiKey, _ := chatTestHandler(t, upstream.URL, 100)
+	apiKey.StrictConversion = true
+	apiKey.ProviderConfigs[0].Protocol = "anthropic"
+
+	// The continued request is still checked against the key's strict conversion
+	rec := postChat(h, apiKey, `{"model":"gpt-4o","max_tokens":5,"logit_bias":{"50256":-100},"messages":[{"role":"user","content":"Say hello world"}]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "logit_bias") {
+		t.Errorf("status %d: %s, want logit_bias refused for the Anthropic upstream", rec.Code, rec.Body.String())
+	}
+	if n := len(upstream.received()); n != 0 {
+		t.Errorf("upstream received %d requests, want none", n)
+	}
+}
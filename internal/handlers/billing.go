@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// stripeBillingError maps Stripe billing service errors to HTTP errors
+func stripeBillingError(err error) error {
+	if errors.Is(err, services.ErrStripeBillingNotFound) || errors.Is(err, services.ErrAPIKeyNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+}
+
+// ListStripeBillings lists the user's Stripe billing exports
+func (h *Handler) ListStripeBillings(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	billings, err := h.stripeBillingService.ListBillings(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get Stripe billing exports")
+	}
+	return c.JSON(http.StatusOK, billings)
+}
+
+// GetStripeBilling returns a Stripe billing export
+func (h *Handler) GetStripeBilling(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid billing ID")
+	}
+
+	billing, err := h.stripeBillingService.GetBilling(user.ID, uint(id))
+	if err != nil {
+		return stripeBillingError(err)
+	}
+	return c.JSON(http.StatusOK, billing)
+}
+
+// CreateStripeBilling starts exporting the usage of an API key to a Stripe metered price
+func (h *Handler) CreateStripeBilling(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req services.StripeBillingRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	billing, err := h.stripeBillingService.CreateBilling(c.Request().Context(), user.ID, &req)
+	if err != nil {
+		return stripeBillingError(err)
+	}
+	return c.JSON(http.StatusCreated, billing)
+}
+
+// UpdateStripeBilling changes a Stripe billing export
+func (h *Handler) UpdateStripeBilling(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid billing ID")
+	}
+
+	var req services.StripeBillingRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	billing, err := h.stripeBillingService.UpdateBilling(c.Request().Context(), user.ID, uint(id), &req)
+	if err != nil {
+		return stripeBillingError(err)
+	}
+	return c.JSON(http.StatusOK, billing)
+}
+
+// DeleteStripeBilling stops a Stripe billing export
+func (h *Handler) DeleteStripeBilling(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid billing ID")
+	}
+
+	if err := h.stripeBillingService.DeleteBilling(user.ID, uint(id)); err != nil {
+		return stripeBillingError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Stripe billing export deleted"})
+}
+
+// SyncStripeBilling exports the usage of a Stripe billing export now instead of
+// waiting for the next scheduled export
+func (h *Handler) SyncStripeBilling(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid billing ID")
+	}
+
+	billing, err := h.stripeBillingService.GetBilling(user.ID, uint(id))
+	if err != nil {
+		return stripeBillingError(err)
+	}
+	if !billing.IsActive {
+		return echo.NewHTTPError(http.StatusBadRequest, "Stripe billing export is paused")
+	}
+	if err := h.stripeBillingService.Sync(c.Request().Context(), billing); err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+	}
+
+	billing, err = h.stripeBillingService.GetBilling(user.ID, uint(id))
+	if err != nil {
+		return stripeBillingError(err)
+	}
+	return c.JSON(http.StatusOK, billing)
+}
+
+// GetStripeReconciliation compares the gateway's usage with what was exported to
+// and aggregated by Stripe over the last ?days= days (default 30)
+func (h *Handler) GetStripeReconciliation(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid billing ID")
+	}
+
+	days := 30
+	if raw := c.QueryParam("days"); raw != "" {
+		if days, err = strconv.Atoi(raw); err != nil || days < 1 || days > 366 {
+			return echo.NewHTTPError(http.StatusBadRequest, "days must be between 1 and 366")
+		}
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	report, err := h.stripeBillingService.Reconcile(c.Request().Context(), user.ID, uint(id), since)
+	if err != nil {
+		return stripeBillingError(err)
+	}
+	return c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// subContextKeys are the context values a sub-context carries over from the
+// client's request: its authentication, trace ID and what its usage is
+// attributed to
+var subContextKeys = []string{
+	"db",
+	middleware.ContextKeyUser,
+	middleware.ContextKeyAPIKey,
+	middleware.ContextKeyTokenScope,
+	middleware.ContextKeyTraceID,
+	middleware.ContextKeyRequestStart,
+	contextKeyEndUser,
+	contextKeyEndUserID,
+	contextKeyPromptEstimate,
+}
+
+// subContext returns a context for running a handler on req with output to w,
+// carrying the caller's authentication, trace ID and usage attribution
+func subContext(c echo.Context, req *http.Request, w http.ResponseWriter) echo.Context {
+	sc := c.Echo().NewContext(req, w)
+	for _, key := range subContextKeys {
+		if value := c.Get(key); value != nil {
+			sc.Set(key, value)
+		}
+	}
+	return sc
+}
+
+// runBufferedCompletion sends req as a non-streaming request to the config
+// serving req.Model, buffering the response instead of writing it to the client.
+// Unless recordUsage is set, the call is left out of the key's usage so that the
+// caller can record it as part of the client's request.
+func (h *Handler) runBufferedCompletion(c echo.Context, req *models.ChatCompletionRequest, recordUsage bool) *completionRecorder {
+	rec := newCompletionRecorder()
+	sc := subContext(c, c.Request(), rec)
+	req.Stream = false
+
+	provider := ""
+	resolved, err := h.resolveProviderForAPIKey(sc, req.Model)
+	if err != nil {
+		rec.err = resolveProviderError(err)
+		return rec
+	}
+	if resolved != nil {
+		sc.Set(middleware.ContextKeyProviderConfig, resolved.Config)
+		rec.cfg = resolved.Config
+		req.Model = resolved.Model
+		provider = resolved.Provider
+	}
+	if provider == "" {
+		provider = h.getTargetProvider(sc, req.Model)
+	}
+	if provider == "" {
+		rec.err = echo.NewHTTPError(http.StatusBadRequest, "unsupported model: "+req.Model)
+		return rec
+	}
+
+	baseURL, apiKey, protocol, err := h.getCredentials(sc, provider, req.Model)
+	if err != nil {
+		rec.err = echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		return rec
+	}
+
+	if !recordUsage {
+		sc.Set(middleware.ContextKeyAPIKey, nil)
+	}
+	rec.err = h.dispatchChatCompletion(sc, req, baseURL, apiKey, protocol)
+	return rec
+}
+
+// completionRecorder buffers the response of a chat completion made on behalf of
+// the client, such as one stage of a cascade or a history summary
+type completionRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	cfg    *database.ProviderConfig
+	err    error
+}
+
+func newCompletionRecorder() *completionRecorder {
+	return &completionRecorder{header: http.Header{}}
+}
+
+func (r *completionRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *completionRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *completionRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *completionRecorder) Flush() {}
+
+// response parses a successful non-streaming chat completion
+func (r *completionRecorder) response() (*models.ChatCompletionResponse, bool) {
+	if r.err != nil || r.status < 200 || r.status >= 300 {
+		return nil, false
+	}
+	var resp models.ChatCompletionResponse
+	if err := json.Unmarshal(r.body.Bytes(), &resp); err != nil || len(resp.Choices) == 0 || resp.Choices[0].Message == nil {
+		return nil, false
+	}
+	return &resp, true
+}
+
+// object parses a successful non-streaming response of any protocol
+func (r *completionRecorder) object() (map[string]interface{}, bool) {
+	if r.err != nil || r.status < 200 || r.status >= 300 {
+		return nil, false
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(r.body.Bytes(), &resp); err != nil {
+		return nil, false
+	}
+	return resp, true
+}
+
+// statusCode is the status of the recorded failure
+func (r *completionRecorder) statusCode() int {
+	var httpErr *echo.HTTPError
+	if errors.As(r.err, &httpErr) {
+		return httpErr.Code
+	}
+	return r.status
+}
+
+// replay writes a recorded failure to the client
+func (r *completionRecorder) replay(c echo.Context) error {
+	if r.err != nil {
+		return r.err
+	}
+	return c.Blob(r.status, r.header.Get(echo.HeaderContentType), r.body.Bytes())
+}
+
+// addUsage adds the token counts of u to total
+func addUsage(total *models.Usage, u *models.Usage) {
+	if u == nil {
+		return
+	}
+	total.PromptTokens += u.PromptTokens
+	total.CompletionTokens += u.CompletionTokens
+	total.TotalTokens = total.PromptTokens + total.CompletionTokens
+}
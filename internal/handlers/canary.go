@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CanaryService returns the service recording and alerting on canary key use
+func (h *Handler) CanaryService() *services.CanaryService {
+	return h.canaryService
+}
+
+// CreateCanaryKey creates a canary API key: a key to plant where leaked
+// credentials would be found, which the gateway rejects as if it did not exist
+// while alerting the owner. The full key is only shown once.
+func (h *Handler) CreateCanaryKey(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req services.CanaryKeyCreate
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	key, fullKey, err := h.canaryService.CreateCanaryKey(user.ID, &req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusCreated, APIKeyCreateResponse{
+		APIKeyResponse: toAPIKeyResponse(key),
+		Key:            fullKey,
+	})
+}
+
+// ListCanaryHits returns the latest requests made with a canary API key
+func (h *Handler) ListCanaryHits(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid key ID")
+	}
+
+	hits, err := h.canaryService.ListHits(user.ID, uint(id))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "API key not found")
+	}
+	return c.JSON(http.StatusOK, hits)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/models"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderCascadeStage reports whether the draft or the verify model answered a cascade request
+const HeaderCascadeStage = "X-Cascade-Stage"
+
+// GetCascadePolicies lists the user's cascade policies
+func (h *Handler) GetCascadePolicies(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	policies, err := h.cascadeService.GetPolicies(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get cascade policies")
+	}
+	return c.JSON(http.StatusOK, policies)
+}
+
+// SetCascadePolicy creates or replaces the cascade policy of a model alias
+func (h *Handler) SetCascadePolicy(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req services.CascadePolicyUpdate
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	policy, err := h.cascadeService.SetPolicy(user.ID, &req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, policy)
+}
+
+// DeleteCascadePolicy removes a cascade policy
+func (h *Handler) DeleteCascadePolicy(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid policy ID")
+	}
+
+	if err := h.cascadeService.DeletePolicy(user.ID, uint(id)); err != nil {
+		if errors.Is(err, services.ErrCascadePolicyNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete cascade policy")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "cascade policy deleted"})
+}
+
+// cascadePolicyFor returns the cascade policy for a requested model alias, or nil
+func (h *Handler) cascadePolicyFor(c echo.Context, model string) *database.CascadePolicy {
+	if apiKey := middleware.GetAPIKey(c); apiKey != nil {
+		return h.cascadeService.PolicyFor(apiKey.UserID, model)
+	}
+	if user := middleware.GetUser(c); user != nil {
+		return h.cascadeService.PolicyFor(user.ID, model)
+	}
+	return nil
+}
+
+// cascadeChatCompletion answers a request for a cascade alias with the draft
+// model, escalating to the verify model when the draft fails the policy's checks.
+// Both calls are recorded as one request with their combined usage.
+func (h *Handler) cascadeChatCompletion(c echo.Context, req *models.ChatCompletionRequest, policy *database.CascadePolicy) error {
+	if req.Stream {
+		return echo.NewHTTPError(http.StatusBadRequest, "streaming is not supported for cascade model "+policy.Model)
+	}
+
+	if apiKey := middleware.GetAPIKey(c); apiKey != nil && apiKey.KeepRequestedModel {
+		c.Set(middleware.ContextKeyResponseModel, policy.Model)
+	}
+
+	var usage models.Usage
+
+	draftReq, err := cloneChatRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to copy request")
+	}
+	draftReq.Model = policy.DraftModel
+	if policy.MinConfidence > 0 {
+		draftReq.Messages = append(draftReq.Messages, models.ChatMessage{Role: "system", Content: services.CascadeConfidencePrompt})
+	}
+
+	draft := h.runBufferedCompletion(c, draftReq, false)
+	reason := "request failed"
+	if resp, ok := draft.response(); ok {
+		addUsage(&usage, resp.Usage)
+		msg := resp.Choices[0].Message
+		if len(msg.ToolCalls) > 0 {
+			reason = ""
+		} else {
+			answer, confidence, _ := services.SplitConfidence(msg.GetTextContent())
+			if reason = services.CheckDraft(policy, answer, confidence); reason == "" && policy.MinConfidence > 0 {
+				msg.Content = answer
+			}
+		}
+		if reason == "" {
+			middleware.LogTrace(c, "Cascade", "Draft from %s accepted for %s", policy.DraftModel, policy.Model)
+			return h.finishCascade(c, policy, "draft", draft, resp, usage)
+		}
+	}
+
+	middleware.LogTrace(c, "Cascade", "Escalating %s to %s: draft %s", policy.Model, policy.VerifyModel, reason)
+	verifyReq, err := cloneChatRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to copy request")
+	}
+	verifyReq.Model = policy.VerifyModel
+
+	verify := h.runBufferedCompletion(c, verifyReq, false)
+	resp, ok := verify.response()
+	if !ok {
+		if verify.cfg != nil {
+			c.Set(middleware.ContextKeyProviderConfig, verify.cfg)
+		}
+		if usage.TotalTokens > 0 {
+			// The draft was still billed upstream
+			h.recordUsageFromOpenAI(c, "/v1/chat/completions", policy.Model, &models.ChatCompletionResponse{Usage: &usage}, verify.statusCode())
+		}
+		c.Response().Header().Set(HeaderCascadeStage, "verify")
+		return verify.replay(c)
+	}
+	addUsage(&usage, resp.Usage)
+	return h.finishCascade(c, policy, "verify", verify, resp, usage)
+}
+
+// finishCascade records the cascade's combined usage and writes the chosen answer
+func (h *Handler) finishCascade(c echo.Context, policy *database.CascadePolicy, stageName string, stage *completionRecorder, resp *models.ChatCompletionResponse, usage models.Usage) error {
+	if stage.cfg != nil {
+		c.Set(middleware.ContextKeyProviderConfig, stage.cfg)
+	}
+	resp.Usage = &usage
+	h.recordUsageFromOpenAI(c, "/v1/chat/completions", policy.Model, resp, http.StatusOK)
+
+	c.Response().Header().Set(HeaderCascadeStage, stageName)
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/models"
+	"ai_gateway/internal/services"
+)
+
+// compressionSettings returns the history threshold and summary model of the
+// request's API key when it has context compression enabled
+func compressionSettings(c echo.Context) (int, string, bool) {
+	apiKey := middleware.GetAPIKey(c)
+	if apiKey == nil || apiKey.CompressionTokens <= 0 || apiKey.CompressionModel == "" {
+		return 0, "", false
+	}
+	return apiKey.CompressionTokens, apiKey.CompressionModel, true
+}
+
+// summarizeHistory returns how many leading messages of history to drop and the
+// summary replacing them. It reports false when the history is short enough or
+// the summary could not be made, in which case the request is sent unchanged.
+func (h *Handler) summarizeHistory(c echo.Context, history []services.HistoryMessage) (int, string, bool) {
+	threshold, model, ok := compressionSettings(c)
+	if !ok {
+		return 0, "", false
+	}
+	cut := services.CompressionCut(history, threshold)
+	if cut == 0 {
+		return 0, "", false
+	}
+
+	rec := h.runBufferedCompletion(c, services.CompressionRequest(model, history[:cut]), true)
+	resp, ok := rec.response()
+	if !ok {
+		middleware.LogTrace(c, "Compression", "Summarizing %d messages with %s failed (status %d), forwarding full history", cut, model, rec.statusCode())
+		return 0, "", false
+	}
+	summary := strings.TrimSpace(resp.Choices[0].Message.GetTextContent())
+	if summary == "" {
+		return 0, "", false
+	}
+	middleware.LogTrace(c, "Compression", "Replaced %d of %d messages with a summary by %s", cut, len(history), model)
+	return cut, services.CompressionSummaryPrefix + summary, true
+}
+
+// compressChatHistory replaces older turns of a chat request with a summary
+// placed after its leading system messages
+func (h *Handler) compressChatHistory(c echo.Context, req *models.ChatCompletionRequest) {
+	if _, _, ok := compressionSettings(c); !ok {
+		return
+	}
+	lead := 0
+	for lead < len(req.Messages) && (req.Messages[lead].Role == "system" || req.Messages[lead].Role == "developer") {
+		lead++
+	}
+	turns := req.Messages[lead:]
+	history := make([]services.HistoryMessage, len(turns))
+	for i, msg := range turns {
+		history[i] = services.HistoryMessage{
+			Role:      msg.Role,
+			Text:      joinText(historyText(msg.Content), historyText(msg.ToolCalls)),
+			TurnStart: msg.Role == "user",
+		}
+	}
+
+	cut, summary, ok := h.summarizeHistory(c, history)
+	if !ok {
+		return
+	}
+	messages := make([]models.ChatMessage, 0, lead+1+len(turns)-cut)
+	messages = append(messages, req.Messages[:lead]...)
+	messages = append(messages, models.ChatMessage{Role: "system", Content: summary})
+	messages = append(messages, turns[cut:]...)
+	req.Messages = messages
+}
+
+// compressMessagesHistory replaces older turns of an Anthropic Messages request
+// with a summary appended to its system prompt
+func (h *Handler) compressMessagesHistory(c echo.Context, req *models.MessagesRequest) {
+	if _, _, ok := compressionSettings(c); !ok {
+		return
+	}
+	switch req.System.(type) {
+	case nil, string, []interface{}:
+	default:
+		return
+	}
+	history := make([]services.HistoryMessage, len(req.Messages))
+	for i, msg := range req.Messages {
+		history[i] = services.HistoryMessage{
+			Role:      msg.Role,
+			Text:      historyText(msg.Content),
+			TurnStart: msg.Role == "user" && !hasBlockType(msg.Content, "tool_result"),
+		}
+	}
+
+	cut, summary, ok := h.summarizeHistory(c, history)
+	if !ok {
+		return
+	}
+	switch system := req.System.(type) {
+	case nil:
+		req.System = summary
+	case string:
+		req.System = joinText(system, summary)
+	case []interface{}:
+		req.System = append(system, map[string]interface{}{"type": "text", "text": summary})
+	}
+	req.Messages = append([]models.AnthropicMessage(nil), req.Messages[cut:]...)
+}
+
+// compressGeminiHistory replaces older turns of a Gemini request with a summary
+// appended to its system instruction
+func (h *Handler) compressGeminiHistory(c echo.Context, req *models.GenerateContentRequest) {
+	if _, _, ok := compressionSettings(c); !ok {
+		return
+	}
+	history := make([]services.HistoryMessage, len(req.Contents))
+	for i, content := range req.Contents {
+		turnStart := content.Role != "model"
+		for _, part := range content.Parts {
+			if part.FunctionResponse != nil {
+				turnStart = false
+			}
+		}
+		history[i] = services.HistoryMessage{Role: content.Role, Text: historyText(content.Parts), TurnStart: turnStart}
+	}
+
+	cut, summary, ok := h.summarizeHistory(c, history)
+	if !ok {
+		return
+	}
+	if req.SystemInstruction == nil {
+		req.SystemInstruction = &models.GeminiContent{}
+	}
+	req.SystemInstruction.Parts = append(req.SystemInstruction.Parts, models.GeminiPart{Text: summary})
+	req.Contents = append([]models.GeminiContent(nil), req.Contents[cut:]...)
+}
+
+// compressResponsesHistory replaces older turns of a Responses request's input
+// list with a summary appended to its instructions
+func (h *Handler) compressResponsesHistory(c echo.Context, reqBody map[string]interface{}) {
+	if _, _, ok := compressionSettings(c); !ok {
+		return
+	}
+	input, ok := reqBody["input"].([]interface{})
+	if !ok {
+		return
+	}
+	instructions, ok := reqBody["instructions"].(string)
+	if !ok && reqBody["instructions"] != nil {
+		return
+	}
+	lead := 0
+	for lead < len(input) {
+		item, _ := input[lead].(map[string]interface{})
+		if role, _ := item["role"].(string); role != "system" && role != "developer" {
+			break
+		}
+		lead++
+	}
+	items := input[lead:]
+	history := make([]services.HistoryMessage, len(items))
+	for i, raw := range items {
+		item, _ := raw.(map[string]interface{})
+		role, _ := item["role"].(string)
+		if role == "" {
+			role, _ = item["type"].(string)
+		}
+		history[i] = services.HistoryMessage{Role: role, Text: historyText(item), TurnStart: role == "user"}
+	}
+
+	cut, summary, ok := h.summarizeHistory(c, history)
+	if !ok {
+		return
+	}
+	reqBody["instructions"] = joinText(instructions, summary)
+	reqBody["input"] = append(append([]interface{}(nil), input[:lead]...), items[cut:]...)
+}
+
+// hasBlockType reports whether Anthropic message content has a block of the given type
+func hasBlockType(content interface{}, blockType string) bool {
+	blocks, _ := content.([]interface{})
+	for _, block := range blocks {
+		if b, ok := block.(map[string]interface{}); ok && b["type"] == blockType {
+			return true
+		}
+	}
+	return false
+}
+
+// historyText collects the text fields of a decoded JSON value or a model
+// value, the same fields counted by textChars
+func historyText(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var parts []string
+		for _, key := range keys {
+			if s, ok := val[key].(string); ok {
+				if estimatedTextKeys[key] {
+					parts = append(parts, s)
+				}
+				continue
+			}
+			parts = append(parts, historyText(val[key]))
+		}
+		return joinText(parts...)
+	case []interface{}:
+		parts := make([]string, len(val))
+		for i, item := range val {
+			parts[i] = historyText(item)
+		}
+		return joinText(parts...)
+	case bool, float64:
+		return ""
+	default:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return ""
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			return ""
+		}
+		return historyText(decoded)
+	}
+}
+
+// joinText joins the non-empty texts with blank lines
+func joinText(texts ...string) string {
+	var nonEmpty []string
+	for _, text := range texts {
+		if text != "" {
+			nonEmpty = append(nonEmpty, text)
+		}
+	}
+	return strings.Join(nonEmpty, "\n\n")
+}
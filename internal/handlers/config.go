@@ -3,7 +3,10 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"ai_gateway/internal/database"
 	"ai_gateway/internal/middleware"
 	"ai_gateway/internal/services"
 
@@ -16,8 +19,29 @@ type ProviderConfigRequest struct {
 	Name       string   `json:"name"`
 	BaseURL    *string  `json:"base_url"`
 	Protocol   *string  `json:"protocol"`
+	Region     *string  `json:"region"`
 	APIKey     *string  `json:"api_key"`
 	ModelCodes []string `json:"model_codes"`
+
+	// Reasoning settings for OpenAI Responses requests routed to this config
+	ReasoningEffort   *string `json:"reasoning_effort"`
+	ReasoningSummary  *string `json:"reasoning_summary"`
+	ReasoningOverride *bool   `json:"reasoning_override"`
+
+	// MaxOutputTokens overrides the output ceiling of this config's models; 0 removes the override
+	MaxOutputTokens *int `json:"max_output_tokens"`
+
+	// Monthly spend caps across all API keys using this config; 0 removes a cap
+	MonthlyTokenCap *int     `json:"monthly_token_cap"`
+	MonthlyCostCap  *float64 `json:"monthly_cost_cap"`
+
+	// Activation schedule, a condition on hour, weekday and time applied by the
+	// scheduler; an empty schedule removes it
+	ActiveSchedule   *string `json:"active_schedule"`
+	ScheduleTimezone *string `json:"schedule_timezone"`
+
+	// ValidateKey additionally checks the key against the upstream before responding
+	ValidateKey bool `json:"validate_key"`
 }
 
 // ProviderConfigResponse represents a provider config response
@@ -27,23 +51,62 @@ type ProviderConfigResponse struct {
 	Name       string   `json:"name"`
 	BaseURL    string   `json:"base_url"`
 	Protocol   string   `json:"protocol"`
+	Region     string   `json:"region"`
 	KeyHint    string   `json:"key_hint"`
 	ModelCodes []string `json:"model_codes"`
 	IsDefault  bool     `json:"is_default"`
 	IsActive   bool     `json:"is_active"`
+	Warnings   []string `json:"warnings,omitempty"`
+
+	ReasoningEffort   string `json:"reasoning_effort"`
+	ReasoningSummary  string `json:"reasoning_summary"`
+	ReasoningOverride bool   `json:"reasoning_override"`
+	MaxOutputTokens   *int   `json:"max_output_tokens"`
+	ActiveSchedule    string `json:"active_schedule,omitempty"`
+	ScheduleTimezone  string `json:"schedule_timezone,omitempty"`
+
+	Spend ProviderConfigSpend `json:"spend"`
+}
+
+// ProviderConfigSpend is the monthly spend of a provider config against its caps
+type ProviderConfigSpend struct {
+	MonthlyTokenCap   *int      `json:"monthly_token_cap"`
+	MonthlyCostCap    *float64  `json:"monthly_cost_cap"`
+	MonthlyTokensUsed int       `json:"monthly_tokens_used"`
+	MonthlyCost       float64   `json:"monthly_cost"` // credits
+	MonthlyResetAt    time.Time `json:"monthly_reset_at"`
+	CapReached        bool      `json:"cap_reached"`
+}
+
+// configSpend returns the monthly spend of a provider config
+func configSpend(cfg *database.ProviderConfig) ProviderConfigSpend {
+	return ProviderConfigSpend{
+		MonthlyTokenCap:   cfg.MonthlyTokenCap,
+		MonthlyCostCap:    cfg.MonthlyCostCap,
+		MonthlyTokensUsed: cfg.MonthlyTokensUsed,
+		MonthlyCost:       float64(cfg.MonthlyCostMicros) / services.MicrosPerCredit,
+		MonthlyResetAt:    cfg.MonthlyResetAt,
+		CapReached:        services.SpendCapReached(cfg, time.Now()),
+	}
 }
 
-// GetProviderConfigs returns all provider configs for the current user
+// GetProviderConfigs returns the provider configs of the current user, all of
+// them unless a limit is given. Filters: q (name), status (active, inactive), since, until.
 func (h *Handler) GetProviderConfigs(c echo.Context) error {
 	user := middleware.GetUser(c)
 	if user == nil {
 		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
 	}
 
-	configs, err := h.configService.GetConfigs(user.ID)
+	q, err := listQuery(c, 0)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return err
+	}
+	configs, total, err := h.configService.ListConfigs(user.ID, "", q)
+	if err != nil {
+		return listError(err)
 	}
+	setListTotal(c, total)
 
 	var response []ProviderConfigResponse
 	for _, cfg := range configs {
@@ -54,28 +117,44 @@ func (h *Handler) GetProviderConfigs(c echo.Context) error {
 			Name:       cfg.Name,
 			BaseURL:    cfg.BaseURL,
 			Protocol:   normalizeProtocol(cfg.Protocol),
+			Region:     cfg.Region,
 			KeyHint:    cfg.KeyHint,
 			ModelCodes: modelCodes,
 			IsDefault:  cfg.IsDefault,
 			IsActive:   cfg.IsActive,
+
+			ReasoningEffort:   cfg.ReasoningEffort,
+			ReasoningSummary:  cfg.ReasoningSummary,
+			ReasoningOverride: cfg.ReasoningOverride,
+			MaxOutputTokens:   cfg.MaxOutputTokens,
+			ActiveSchedule:    cfg.ActiveSchedule,
+			ScheduleTimezone:  cfg.ScheduleTimezone,
+
+			Spend: configSpend(&cfg),
 		})
 	}
 
 	return c.JSON(http.StatusOK, response)
 }
 
-// GetProviderConfigsByProvider returns provider configs by provider type
+// GetProviderConfigsByProvider returns provider configs by provider type, with
+// the filters of GetProviderConfigs
 func (h *Handler) GetProviderConfigsByProvider(c echo.Context) error {
 	user := middleware.GetUser(c)
 	if user == nil {
 		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
 	}
 
+	q, err := listQuery(c, 0)
+	if err != nil {
+		return err
+	}
 	provider := c.Param("provider")
-	configs, err := h.configService.GetConfigsByProvider(user.ID, provider)
+	configs, total, err := h.configService.ListConfigs(user.ID, provider, q)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return listError(err)
 	}
+	setListTotal(c, total)
 
 	var response []ProviderConfigResponse
 	for _, cfg := range configs {
@@ -86,10 +165,20 @@ func (h *Handler) GetProviderConfigsByProvider(c echo.Context) error {
 			Name:       cfg.Name,
 			BaseURL:    cfg.BaseURL,
 			Protocol:   normalizeProtocol(cfg.Protocol),
+			Region:     cfg.Region,
 			KeyHint:    cfg.KeyHint,
 			ModelCodes: modelCodes,
 			IsDefault:  cfg.IsDefault,
 			IsActive:   cfg.IsActive,
+
+			ReasoningEffort:   cfg.ReasoningEffort,
+			ReasoningSummary:  cfg.ReasoningSummary,
+			ReasoningOverride: cfg.ReasoningOverride,
+			MaxOutputTokens:   cfg.MaxOutputTokens,
+			ActiveSchedule:    cfg.ActiveSchedule,
+			ScheduleTimezone:  cfg.ScheduleTimezone,
+
+			Spend: configSpend(&cfg),
 		})
 	}
 
@@ -120,10 +209,20 @@ func (h *Handler) GetProviderConfigByID(c echo.Context) error {
 		Name:       cfg.Name,
 		BaseURL:    cfg.BaseURL,
 		Protocol:   normalizeProtocol(cfg.Protocol),
+		Region:     cfg.Region,
 		KeyHint:    cfg.KeyHint,
 		ModelCodes: modelCodes,
 		IsDefault:  cfg.IsDefault,
 		IsActive:   cfg.IsActive,
+
+		ReasoningEffort:   cfg.ReasoningEffort,
+		ReasoningSummary:  cfg.ReasoningSummary,
+		ReasoningOverride: cfg.ReasoningOverride,
+		MaxOutputTokens:   cfg.MaxOutputTokens,
+		ActiveSchedule:    cfg.ActiveSchedule,
+		ScheduleTimezone:  cfg.ScheduleTimezone,
+
+		Spend: configSpend(cfg),
 	})
 }
 
@@ -152,14 +251,30 @@ func (h *Handler) CreateProviderConfig(c echo.Context) error {
 	if req.BaseURL != nil {
 		baseURL = *req.BaseURL
 	}
+	region := ""
+	if req.Region != nil {
+		region = strings.TrimSpace(*req.Region)
+	}
 
 	serviceReq := &services.ProviderConfigCreate{
 		Provider:   req.Provider,
 		Name:       req.Name,
 		BaseURL:    baseURL,
 		Protocol:   protocolValue(req.Protocol),
+		Region:     region,
 		APIKey:     *req.APIKey,
 		ModelCodes: req.ModelCodes,
+
+		ReasoningEffort:   stringValue(req.ReasoningEffort),
+		ReasoningSummary:  stringValue(req.ReasoningSummary),
+		ReasoningOverride: req.ReasoningOverride != nil && *req.ReasoningOverride,
+		MaxOutputTokens:   req.MaxOutputTokens,
+
+		MonthlyTokenCap: req.MonthlyTokenCap,
+		MonthlyCostCap:  req.MonthlyCostCap,
+
+		ActiveSchedule:   stringValue(req.ActiveSchedule),
+		ScheduleTimezone: stringValue(req.ScheduleTimezone),
 	}
 
 	cfg, err := h.configService.CreateConfig(user.ID, serviceReq)
@@ -167,6 +282,8 @@ func (h *Handler) CreateProviderConfig(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
+	warnings := h.providerKeyWarnings(c, cfg, *req.APIKey, req.ValidateKey)
+
 	modelCodes, _ := h.configService.GetModelCodes(cfg)
 	return c.JSON(http.StatusCreated, ProviderConfigResponse{
 		ID:         cfg.ID,
@@ -174,10 +291,21 @@ func (h *Handler) CreateProviderConfig(c echo.Context) error {
 		Name:       cfg.Name,
 		BaseURL:    cfg.BaseURL,
 		Protocol:   normalizeProtocol(cfg.Protocol),
+		Region:     cfg.Region,
 		KeyHint:    cfg.KeyHint,
 		ModelCodes: modelCodes,
 		IsDefault:  cfg.IsDefault,
 		IsActive:   cfg.IsActive,
+		Warnings:   warnings,
+
+		ReasoningEffort:   cfg.ReasoningEffort,
+		ReasoningSummary:  cfg.ReasoningSummary,
+		ReasoningOverride: cfg.ReasoningOverride,
+		MaxOutputTokens:   cfg.MaxOutputTokens,
+		ActiveSchedule:    cfg.ActiveSchedule,
+		ScheduleTimezone:  cfg.ScheduleTimezone,
+
+		Spend: configSpend(cfg),
 	})
 }
 
@@ -193,6 +321,10 @@ func (h *Handler) UpdateProviderConfig(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid config ID")
 	}
 
+	if before, err := h.configService.GetConfigByID(user.ID, uint(id)); err == nil {
+		middleware.AuditBefore(c, before)
+	}
+
 	var req ProviderConfigRequest
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
@@ -202,8 +334,20 @@ func (h *Handler) UpdateProviderConfig(c echo.Context) error {
 		Name:       &req.Name,
 		BaseURL:    req.BaseURL,
 		Protocol:   req.Protocol,
+		Region:     req.Region,
 		APIKey:     req.APIKey,
 		ModelCodes: req.ModelCodes,
+
+		ReasoningEffort:   req.ReasoningEffort,
+		ReasoningSummary:  req.ReasoningSummary,
+		ReasoningOverride: req.ReasoningOverride,
+		MaxOutputTokens:   req.MaxOutputTokens,
+
+		MonthlyTokenCap: req.MonthlyTokenCap,
+		MonthlyCostCap:  req.MonthlyCostCap,
+
+		ActiveSchedule:   req.ActiveSchedule,
+		ScheduleTimezone: req.ScheduleTimezone,
 	}
 
 	cfg, err := h.configService.UpdateConfig(user.ID, uint(id), serviceReq)
@@ -211,6 +355,19 @@ func (h *Handler) UpdateProviderConfig(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
+	var warnings []string
+	if req.APIKey != nil || req.ValidateKey {
+		apiKey := ""
+		if req.APIKey != nil {
+			apiKey = *req.APIKey
+		} else if apiKey, err = h.configService.DecryptAPIKey(cfg); err != nil {
+			warnings = append(warnings, "stored api_key could not be decrypted")
+		}
+		if apiKey != "" {
+			warnings = append(warnings, h.providerKeyWarnings(c, cfg, apiKey, req.ValidateKey)...)
+		}
+	}
+
 	modelCodes, _ := h.configService.GetModelCodes(cfg)
 	return c.JSON(http.StatusOK, ProviderConfigResponse{
 		ID:         cfg.ID,
@@ -218,10 +375,21 @@ func (h *Handler) UpdateProviderConfig(c echo.Context) error {
 		Name:       cfg.Name,
 		BaseURL:    cfg.BaseURL,
 		Protocol:   normalizeProtocol(cfg.Protocol),
+		Region:     cfg.Region,
 		KeyHint:    cfg.KeyHint,
 		ModelCodes: modelCodes,
 		IsDefault:  cfg.IsDefault,
 		IsActive:   cfg.IsActive,
+		Warnings:   warnings,
+
+		ReasoningEffort:   cfg.ReasoningEffort,
+		ReasoningSummary:  cfg.ReasoningSummary,
+		ReasoningOverride: cfg.ReasoningOverride,
+		MaxOutputTokens:   cfg.MaxOutputTokens,
+		ActiveSchedule:    cfg.ActiveSchedule,
+		ScheduleTimezone:  cfg.ScheduleTimezone,
+
+		Spend: configSpend(cfg),
 	})
 }
 
@@ -237,6 +405,10 @@ func (h *Handler) DeleteProviderConfig(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid config ID")
 	}
 
+	if before, err := h.configService.GetConfigByID(user.ID, uint(id)); err == nil {
+		middleware.AuditBefore(c, before)
+	}
+
 	if err := h.configService.DeleteConfig(user.ID, uint(id)); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
@@ -256,6 +428,10 @@ func (h *Handler) SetDefaultProviderConfig(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid config ID")
 	}
 
+	if before, err := h.configService.GetConfigByID(user.ID, uint(id)); err == nil {
+		middleware.AuditBefore(c, before)
+	}
+
 	cfg, err := h.configService.SetDefault(user.ID, uint(id))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
@@ -268,10 +444,20 @@ func (h *Handler) SetDefaultProviderConfig(c echo.Context) error {
 		Name:       cfg.Name,
 		BaseURL:    cfg.BaseURL,
 		Protocol:   normalizeProtocol(cfg.Protocol),
+		Region:     cfg.Region,
 		KeyHint:    cfg.KeyHint,
 		ModelCodes: modelCodes,
 		IsDefault:  cfg.IsDefault,
 		IsActive:   cfg.IsActive,
+
+		ReasoningEffort:   cfg.ReasoningEffort,
+		ReasoningSummary:  cfg.ReasoningSummary,
+		ReasoningOverride: cfg.ReasoningOverride,
+		MaxOutputTokens:   cfg.MaxOutputTokens,
+		ActiveSchedule:    cfg.ActiveSchedule,
+		ScheduleTimezone:  cfg.ScheduleTimezone,
+
+		Spend: configSpend(cfg),
 	})
 }
 
@@ -287,6 +473,10 @@ func (h *Handler) ToggleProviderConfig(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid config ID")
 	}
 
+	if before, err := h.configService.GetConfigByID(user.ID, uint(id)); err == nil {
+		middleware.AuditBefore(c, before)
+	}
+
 	cfg, err := h.configService.ToggleActive(user.ID, uint(id))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
@@ -299,9 +489,139 @@ func (h *Handler) ToggleProviderConfig(c echo.Context) error {
 		Name:       cfg.Name,
 		BaseURL:    cfg.BaseURL,
 		Protocol:   normalizeProtocol(cfg.Protocol),
+		Region:     cfg.Region,
 		KeyHint:    cfg.KeyHint,
 		ModelCodes: modelCodes,
 		IsDefault:  cfg.IsDefault,
 		IsActive:   cfg.IsActive,
+
+		ReasoningEffort:   cfg.ReasoningEffort,
+		ReasoningSummary:  cfg.ReasoningSummary,
+		ReasoningOverride: cfg.ReasoningOverride,
+		MaxOutputTokens:   cfg.MaxOutputTokens,
+		ActiveSchedule:    cfg.ActiveSchedule,
+		ScheduleTimezone:  cfg.ScheduleTimezone,
+
+		Spend: configSpend(cfg),
 	})
 }
+
+// providerKeyWarnings collects format warnings for apiKey and, when checkUpstream is set,
+// the result of an authenticated call against the config's upstream
+func (h *Handler) providerKeyWarnings(c echo.Context, cfg *database.ProviderConfig, apiKey string, checkUpstream bool) []string {
+	warnings := services.ValidateKeyFormat(cfg.Provider, apiKey)
+	if checkUpstream {
+		if warning := h.configService.CheckUpstreamAuth(c.Request().Context(), normalizeProtocol(cfg.Protocol), cfg.BaseURL, apiKey); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings
+}
+
+// ProviderConfigRollbackRequest represents a rollback request
+type ProviderConfigRollbackRequest struct {
+	RevisionID uint `json:"revision_id"`
+}
+
+// GetProviderConfigHistory returns the change history of a provider config
+func (h *Handler) GetProviderConfigHistory(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid config ID")
+	}
+
+	revisions, err := h.configService.GetConfigHistory(user.ID, uint(id))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "config not found")
+	}
+
+	return c.JSON(http.StatusOK, revisions)
+}
+
+// RollbackProviderConfig restores a provider config to a previous revision
+func (h *Handler) RollbackProviderConfig(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid config ID")
+	}
+
+	if before, err := h.configService.GetConfigByID(user.ID, uint(id)); err == nil {
+		middleware.AuditBefore(c, before)
+	}
+
+	var req ProviderConfigRollbackRequest
+	if err := c.Bind(&req); err != nil || req.RevisionID == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "revision_id is required")
+	}
+
+	cfg, err := h.configService.RollbackConfig(user.ID, uint(id), req.RevisionID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	modelCodes, _ := h.configService.GetModelCodes(cfg)
+	return c.JSON(http.StatusOK, ProviderConfigResponse{
+		ID:         cfg.ID,
+		Provider:   cfg.Provider,
+		Name:       cfg.Name,
+		BaseURL:    cfg.BaseURL,
+		Protocol:   normalizeProtocol(cfg.Protocol),
+		Region:     cfg.Region,
+		KeyHint:    cfg.KeyHint,
+		ModelCodes: modelCodes,
+		IsDefault:  cfg.IsDefault,
+		IsActive:   cfg.IsActive,
+
+		ReasoningEffort:   cfg.ReasoningEffort,
+		ReasoningSummary:  cfg.ReasoningSummary,
+		ReasoningOverride: cfg.ReasoningOverride,
+		MaxOutputTokens:   cfg.MaxOutputTokens,
+		ActiveSchedule:    cfg.ActiveSchedule,
+		ScheduleTimezone:  cfg.ScheduleTimezone,
+
+		Spend: configSpend(cfg),
+	})
+}
+
+// GetProviderConfigHealth probes a provider config and returns its health-check state
+func (h *Handler) GetProviderConfigHealth(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid config ID")
+	}
+
+	cfg, err := h.configService.GetConfigByID(user.ID, uint(id))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "config not found")
+	}
+
+	h.healthService.Probe(c.Request().Context(), cfg)
+	health, _ := h.healthService.Get(cfg.ID)
+	return c.JSON(http.StatusOK, health)
+}
+
+// CredentialCache returns the cache of API keys and decrypted credentials that
+// gateway authentication reads through
+func (h *Handler) CredentialCache() *services.CredentialCache {
+	return h.credentialCache
+}
+
+// HealthService returns the upstream health checker used for latency routing
+func (h *Handler) HealthService() *services.HealthService {
+	return h.healthService
+}
@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// streamFilterMaxHold is how much streamed text is held back while waiting for a
+// sentence boundary before it is filtered and released anyway
+const streamFilterMaxHold = 1024
+
+// contentFilterError maps content filter service errors to HTTP errors
+func contentFilterError(err error) error {
+	if errors.Is(err, services.ErrContentFilterRuleNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+}
+
+// ListContentFilterRules lists the user's content filter rules
+func (h *Handler) ListContentFilterRules(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	rules, err := h.contentFilterService.ListRules(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get content filter rules")
+	}
+	return c.JSON(http.StatusOK, rules)
+}
+
+// CreateContentFilterRule adds a rule applied to the user's model output
+func (h *Handler) CreateContentFilterRule(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req services.ContentFilterRuleRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	rule, err := h.contentFilterService.CreateRule(user.ID, &req)
+	if err != nil {
+		return contentFilterError(err)
+	}
+	return c.JSON(http.StatusCreated, rule)
+}
+
+// UpdateContentFilterRule changes a content filter rule
+func (h *Handler) UpdateContentFilterRule(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid rule ID")
+	}
+
+	var req services.ContentFilterRuleRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	rule, err := h.contentFilterService.UpdateRule(user.ID, uint(id), &req)
+	if err != nil {
+		return contentFilterError(err)
+	}
+	return c.JSON(http.StatusOK, rule)
+}
+
+// DeleteContentFilterRule removes a content filter rule
+func (h *Handler) DeleteContentFilterRule(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid rule ID")
+	}
+
+	if err := h.contentFilterService.DeleteRule(user.ID, uint(id)); err != nil {
+		return contentFilterError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "content filter rule deleted"})
+}
+
+// filterOutput routes the response written to c, in the given output format,
+// through the user's content filter. The returned function must be called once
+// the handler is done.
+func (h *Handler) filterOutput(c echo.Context, format string) func() {
+	userID := gatewayUserID(c)
+	if userID == 0 {
+		return func() {}
+	}
+	filter := h.contentFilterService.FilterFor(userID)
+	if filter == nil {
+		return func() {}
+	}
+
+	w := &contentFilterWriter{
+		ResponseWriter: c.Response().Writer,
+		c:              c,
+		filter:         filter,
+		format:         newOutputFormat(format),
+		pending:        make(map[int]string),
+	}
+	c.Response().Writer = w
+	return w.finish
+}
+
+// Modes of a contentFilterWriter, chosen when the response header is written
+const (
+	filterUndecided = iota
+	filterPassthrough
+	filterBuffered
+	filterStream
+)
+
+// contentFilterWriter applies a content filter to a response. JSON responses
+// are buffered and filtered whole. Event streams are filtered a sentence at a
+// time so the client still sees progress; moderation runs on the complete
+// streamed output and can only annotate or end the stream by then.
+type contentFilterWriter struct {
+	http.ResponseWriter
+	c      echo.Context
+	filter *services.ContentFilter
+	format *outputFormat
+
+	mode   int
+	status int
+	body   bytes.Buffer // buffered JSON response, or the unterminated tail of the event stream
+
+	pending  map[int]string  // streamed content held back per choice
+	released strings.Builder // streamed content sent to the client, for moderation
+	ended    bool            // the end of the stream was filtered; later events pass through
+	closed   bool            // the stream was blocked or terminated
+}
+
+func (w *contentFilterWriter) WriteHeader(status int) {
+	if w.mode != filterUndecided {
+		return
+	}
+	w.status = status
+	contentType := w.Header().Get(echo.HeaderContentType)
+	switch {
+	case status < 200 || status >= 300:
+		w.mode = filterPassthrough
+	case strings.HasPrefix(contentType, "text/event-stream"):
+		w.mode = filterStream
+	case strings.HasPrefix(contentType, echo.MIMEApplicationJSON):
+		w.mode = filterBuffered
+		return
+	default:
+		w.mode = filterPassthrough
+	}
+	if w.mode == filterStream {
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *contentFilterWriter) Write(b []byte) (int, error) {
+	if w.mode == filterUndecided {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch w.mode {
+	case filterBuffered:
+		return w.body.Write(b)
+	case filterStream:
+		w.body.Write(bytes.ReplaceAll(b, []byte("\r"), nil))
+		w.processEvents()
+		return len(b), nil
+	default:
+		return w.ResponseWriter.Write(b)
+	}
+}
+
+func (w *contentFilterWriter) Flush() {
+	if w.mode == filterBuffered {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the filtered buffered response, or the remainder of a stream
+// that ended without its terminal event, and restores the original writer
+func (w *contentFilterWriter) finish() {
+	w.c.Response().Writer = w.ResponseWriter
+	switch w.mode {
+	case filterBuffered:
+		w.writeFilteredJSON()
+	case filterStream:
+		// Relayed streams may end with a [DONE] line that has no blank line after it
+		if rest := strings.TrimSpace(w.body.String()); rest != "" && !w.closed {
+			w.body.Reset()
+			w.processEvent(rest)
+		}
+		if !w.closed && !w.ended {
+			w.endStream(nil, "")
+		}
+	}
+}
+
+// writeFilteredJSON filters the text of a buffered response
+func (w *contentFilterWriter) writeFilteredJSON() {
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.body.Bytes(), &resp); err != nil {
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.body.Bytes())
+		return
+	}
+
+	var annotations []services.FilterAnnotation
+	blocked := false
+filter:
+	for _, choice := range w.format.choices(resp) {
+		for _, part := range choice.parts {
+			filtered, anns, block := w.filter.Apply(part.text)
+			annotations = append(annotations, anns...)
+			if !block && w.filter.Moderates() {
+				moderated, modAnns, modBlock, err := w.filter.Moderate(w.c.Request().Context(), filtered)
+				if err != nil {
+					middleware.LogTrace(w.c, "ContentFilter", "Moderation failed, output not moderated: %v", err)
+				}
+				filtered, block = moderated, modBlock
+				annotations = append(annotations, modAnns...)
+			}
+			if block {
+				blocked = true
+				break filter
+			}
+			part.set(filtered)
+		}
+	}
+
+	w.Header().Del("Content-Length")
+	if blocked {
+		middleware.LogTrace(w.c, "ContentFilter", "Blocked response: %v", annotations)
+		w.writeBlocked(annotations)
+		return
+	}
+	if len(annotations) > 0 {
+		middleware.LogTrace(w.c, "ContentFilter", "Filtered response: %v", annotations)
+		resp["content_filter_results"] = annotations
+	}
+	out, err := json.Marshal(resp)
+	if err != nil {
+		out = w.body.Bytes()
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(out)
+}
+
+// writeBlocked replaces a buffered response with a content filter error
+func (w *contentFilterWriter) writeBlocked(annotations []services.FilterAnnotation) {
+	body := w.format.errorBody(contentFilterBlockedMessage, "content_filter", "content_filter", false)
+	body["content_filter_results"] = annotations
+	out, _ := json.Marshal(body)
+	w.c.Response().Status = http.StatusBadRequest
+	w.ResponseWriter.WriteHeader(http.StatusBadRequest)
+	w.ResponseWriter.Write(out)
+}
+
+// contentFilterBlockedMessage is the error message of a blocked response
+const contentFilterBlockedMessage = "the response was blocked by a content filter"
+
+// processEvents filters every complete event in the stream buffer
+func (w *contentFilterWriter) processEvents() {
+	for {
+		data := w.body.Bytes()
+		idx := bytes.Index(data, []byte("\n\n"))
+		if idx < 0 {
+			return
+		}
+		event := string(data[:idx])
+		w.body.Next(idx + 2)
+		if !w.closed {
+			w.processEvent(event)
+		}
+	}
+}
+
+// processEvent filters the text of one stream event
+func (w *contentFilterWriter) processEvent(event string) {
+	payload, ok := eventData(event)
+	if !ok || w.ended {
+		w.writeEvent(event)
+		return
+	}
+	if payload == "[DONE]" {
+		w.endStream(nil, event)
+		return
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		w.writeEvent(event)
+		return
+	}
+	ev := w.format.event(data)
+	if ev.done {
+		w.endStream(ev, "")
+		return
+	}
+
+	var annotations []services.FilterAnnotation
+	var before []map[string]interface{}
+	for _, ch := range ev.choices {
+		text, anns, blocked := w.release(ch.index, ch.text, ch.ended)
+		annotations = append(annotations, anns...)
+		if blocked {
+			w.blockStream(ch.index, annotations)
+			return
+		}
+		switch {
+		case ch.setText != nil && (ch.hasText || text != ""):
+			ch.setText(text)
+		case ch.setText == nil && text != "":
+			// The event cannot carry the text released at its end
+			before = append(before, w.format.textEvents(ch.index, text)...)
+		}
+	}
+	if len(annotations) > 0 {
+		data["content_filter_results"] = annotations
+	}
+	for _, extra := range before {
+		w.writeEvent(w.format.render(extra))
+	}
+	w.writeEvent(w.format.render(data))
+}
+
+// release adds streamed content for a choice and returns the filtered text that
+// can be sent, holding back the part after the last sentence boundary
+func (w *contentFilterWriter) release(index int, content string, final bool) (string, []services.FilterAnnotation, bool) {
+	pending := w.pending[index] + content
+	cut := len(pending)
+	if !final {
+		cut = sentenceBoundary(pending)
+	}
+	w.pending[index] = pending[cut:]
+	if cut == 0 {
+		return "", nil, false
+	}
+
+	text, annotations, blocked := w.filter.Apply(pending[:cut])
+	if !blocked {
+		w.released.WriteString(text)
+	}
+	return text, annotations, blocked
+}
+
+// sentenceBoundary returns the length of the prefix of s ending at its last
+// sentence or line break, or of a long run of text without one
+func sentenceBoundary(s string) int {
+	cut := strings.LastIndex(s, "\n") + 1
+	for _, sep := range []string{". ", "! ", "? ", "。", "！", "？"} {
+		if i := strings.LastIndex(s, sep); i >= 0 && i+len(sep) > cut {
+			cut = i + len(sep)
+		}
+	}
+	if cut == 0 && len(s) > streamFilterMaxHold {
+		if i := strings.LastIndex(s, " "); i > 0 {
+			return i + 1
+		}
+		return len(s)
+	}
+	return cut
+}
+
+// endStream releases the held-back content and moderates the streamed output
+// before the terminal event of the stream, given parsed or raw, if any
+func (w *contentFilterWriter) endStream(terminal *streamEvent, raw string) {
+	for index, pending := range w.pending {
+		if pending == "" {
+			continue
+		}
+		text, annotations, blocked := w.release(index, "", true)
+		if blocked {
+			w.blockStream(index, annotations)
+			return
+		}
+		events := w.format.textEvents(index, text)
+		if len(annotations) > 0 {
+			events[len(events)-1]["content_filter_results"] = annotations
+		}
+		for _, event := range events {
+			w.writeEvent(w.format.render(event))
+		}
+	}
+
+	if w.filter.Moderates() {
+		_, annotations, blocked, err := w.filter.Moderate(w.c.Request().Context(), w.released.String())
+		if err != nil {
+			middleware.LogTrace(w.c, "ContentFilter", "Moderation failed, stream not moderated: %v", err)
+		}
+		if len(annotations) > 0 {
+			middleware.LogTrace(w.c, "ContentFilter", "Moderation flagged stream: %v", annotations)
+			if blocked {
+				w.writeEvent(w.format.render(w.format.errorBody(contentFilterBlockedMessage, "content_filter", "content_filter", true)))
+			}
+			if notice := w.format.noticeEvent(); notice != nil {
+				notice["content_filter_results"] = annotations
+				w.writeEvent(w.format.render(notice))
+			}
+		}
+	}
+
+	w.ended = true
+	switch {
+	case terminal != nil:
+		w.writeEvent(w.format.render(terminal.data))
+	case raw != "":
+		w.writeEvent(raw)
+	}
+}
+
+// blockStream ends the stream after a choice was blocked
+func (w *contentFilterWriter) blockStream(index int, annotations []services.FilterAnnotation) {
+	middleware.LogTrace(w.c, "ContentFilter", "Blocked stream: %v", annotations)
+	events := w.format.endEvents(index, "content_filter")
+	events[0]["content_filter_results"] = annotations
+	for _, event := range events {
+		w.writeEvent(w.format.render(event))
+	}
+	for _, event := range w.format.terminator() {
+		w.writeEvent(event)
+	}
+	w.closed = true
+}
+
+func (w *contentFilterWriter) writeEvent(event string) {
+	w.ResponseWriter.Write([]byte(event + "\n\n"))
+}
+
+// eventData returns the data of a server-sent event
+func eventData(event string) (string, bool) {
+	var data []string
+	for _, line := range strings.Split(event, "\n") {
+		if strings.HasPrefix(line, "data:") {
+			data = append(data, strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		}
+	}
+	if len(data) == 0 {
+		return "", false
+	}
+	return strings.Join(data, "\n"), true
+}
@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// addFilterRule adds a content filter rule for the owner of apiKey
+func addFilterRule(t *testing.T, h *Handler, apiKey *database.APIKey, ruleType, pattern, action string) {
+	req := &services.ContentFilterRuleRequest{Name: ruleType + "-" + action, Type: ruleType, Pattern: pattern, Action: action}
+	if _, err := h.contentFilterService.CreateRule(apiKey.UserID, req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// streamedContent returns the content, finish reasons and filter results of a chat completion stream
+func streamedContent(t *testing.T, body string) (string, []string, []json.RawMessage) {
+	var content strings.Builder
+	var finishReasons []string
+	var results []json.RawMessage
+	for _, event := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		payload := strings.TrimPrefix(event, "data: ")
+		if payload == "[DONE]" {
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+			Results json.RawMessage `json:"content_filter_results"`
+		}
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			t.Fatalf("event %q: %v", event, err)
+		}
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				finishReasons = append(finishReasons, *choice.FinishReason)
+			}
+		}
+		if chunk.Results != nil {
+			results = append(results, chunk.Results)
+		}
+	}
+	return content.String(), finishReasons, results
+}
+
+func TestContentFilter_Response(t *testing.T) {
+	tests := []struct {
+		name        string
+		ruleType    string
+		pattern     string
+		action      string
+		reply       string
+		wantStatus  int
+		wantContent string
+		wantMatch   bool
+	}{
+		{"regex redact", services.FilterTypeRegex, `\d{3}-\d{4}`, services.FilterActionRedact, "Call 555-1234 or 555-9876.", http.StatusOK, "Call [REDACTED] or [REDACTED].", true},
+		{"keywords redact", services.FilterTypeKeywords, "secret\nhidden", services.FilterActionRedact, "The Secret is not secretive.", http.StatusOK, "The [REDACTED] is not secretive.", true},
+		{"annotate", services.FilterTypeKeywords, "secret", services.FilterActionAnnotate, "The secret is out.", http.StatusOK, "The secret is out.", true},
+		{"no match", services.FilterTypeKeywords, "secret", services.FilterActionBlock, "Nothing to see.", http.StatusOK, "Nothing to see.", false},
+		{"block", services.FilterTypeKeywords, "secret", services.FilterActionBlock, "The secret is out.", http.StatusBadRequest, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newChatUpstream(t, chatReply(tt.reply, "stop", 10, 5))
+			h, apiKey, _ := chatTestHandler(t, upstream.URL, 0)
+			addFilterRule(t, h, apiKey, tt.ruleType, tt.pattern, tt.action)
+
+			// Only the output is filtered; the prompt reaches the upstream as sent
+			prompt := "Repeat: " + tt.reply
+			rec := postChat(h, apiKey, mustJSON(t, map[string]interface{}{"model": "gpt-4o", "messages": []map[string]string{{"role": "user", "content": prompt}}}))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if got := upstream.received()[0]["messages"]; !strings.Contains(mustJSON(t, got), prompt) {
+				t.Errorf("forwarded messages = %v, want the prompt unchanged", got)
+			}
+
+			var resp struct {
+				Choices []struct {
+					Message struct {
+						Content string `json:"content"`
+					} `json:"message"`
+				} `json:"choices"`
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+				Results []services.FilterAnnotation `json:"content_filter_results"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if resp.Error.Code != "content_filter" || strings.Contains(rec.Body.String(), "secret is out") {
+					t.Errorf("blocked response = %s", rec.Body.String())
+				}
+			} else if len(resp.Choices) != 1 || resp.Choices[0].Message.Content != tt.wantContent {
+				t.Errorf("response = %s, want content %q", rec.Body.String(), tt.wantContent)
+			}
+			if matched := len(resp.Results) == 1 && resp.Results[0].Action == tt.action; matched != tt.wantMatch {
+				t.Errorf("content_filter_results = %+v", resp.Results)
+			}
+		})
+	}
+}
+
+func TestContentFilter_Stream(t *testing.T) {
+	tests := []struct {
+		name        string
+		action      string
+		reply       string
+		wantContent string
+		wantFinish  string
+	}{
+		// The number is split across two chunks and still redacted
+		{"redact", services.FilterActionRedact, "First. Call 555-1234 now", "First. Call [REDACTED] now", "stop"},
+		{"block", services.FilterActionBlock, "First. Call 555-1234 now", "First. ", "content_filter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newChatUpstream(t, chatStreamReply("chatcmpl-a", tt.reply, "stop", 10, 5))
+			h, apiKey, _ := chatTestHandler(t, upstream.URL, 0)
+			addFilterRule(t, h, apiKey, services.FilterTypeRegex, `\d{3}-\d{4}`, tt.action)
+
+			rec := postChat(h, apiKey, `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Call 555-1234"}]}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := upstream.received()[0]["messages"]; !strings.Contains(mustJSON(t, got), "Call 555-1234") {
+				t.Errorf("forwarded messages = %v, want the prompt unchanged", got)
+			}
+
+			content, finishReasons, results := streamedContent(t, rec.Body.String())
+			if content != tt.wantContent {
+				t.Errorf("streamed content = %q, want %q", content, tt.wantContent)
+			}
+			if len(finishReasons) != 1 || finishReasons[0] != tt.wantFinish {
+				t.Errorf("finish reasons = %v, want %q", finishReasons, tt.wantFinish)
+			}
+			if len(results) != 1 || !strings.Contains(string(results[0]), `"action":"`+tt.action+`"`) {
+				t.Errorf("content_filter_results = %s", results)
+			}
+			if strings.Contains(rec.Body.String(), "555-1234") {
+				t.Errorf("stream leaked filtered text: %s", rec.Body.String())
+			}
+			if !strings.HasSuffix(rec.Body.String(), "data: [DONE]\n\n") || strings.Count(rec.Body.String(), "[DONE]") != 1 {
+				t.Errorf("stream must end with a single [DONE]: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+// postFormat sends a request for a reply to "hi" in an output format other
+// than chat completions to h with apiKey
+func postFormat(h *Handler, apiKey *database.APIKey, format string, stream bool) *httptest.ResponseRecorder {
+	var path, body, model string
+	var handle func(echo.Context) error
+	switch format {
+	case formatAnthropic:
+		path, handle = "/v1/messages", h.AnthropicMessages
+		body = fmt.Sprintf(`{"model":"gpt-4o","max_tokens":100,"stream":%t,"messages":[{"role":"user","content":"hi"}]}`, stream)
+	case formatResponses:
+		path, handle = "/v1/responses", h.OpenAICodeResponses
+		body = fmt.Sprintf(`{"model":"gpt-4o","stream":%t,"store":false,"input":"hi"}`, stream)
+	case formatGemini:
+		model = "gpt-4o:generateContent"
+		if stream {
+			model = "gpt-4o:streamGenerateContent"
+		}
+		path, handle = "/v1beta/models/"+model, h.GeminiGenerateContent
+		body = `{"contents":[{"role":"user","parts":[{"text":"hi"}]}]}`
+	}
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	if model != "" {
+		c.SetParamNames("model")
+		c.SetParamValues(model)
+	}
+	c.Set(middleware.ContextKeyAPIKey, apiKey)
+	c.Set(middleware.ContextKeyUser, &apiKey.User)
+	if err := handle(c); err != nil {
+		c.Echo().HTTPErrorHandler(err, c)
+	}
+	return rec
+}
+
+// formatOutput returns the text of a reply sent by postFormat and how it
+// ended: the stop reason of a message, the status of a response or the
+// finish reason of a Gemini candidate
+func formatOutput(t *testing.T, format string, stream bool, body string) (string, string) {
+	var text strings.Builder
+	var end string
+	payloads := []string{body}
+	if stream {
+		payloads = nil
+		for _, event := range strings.Split(strings.TrimSpace(body), "\n\n") {
+			for _, line := range strings.Split(event, "\n") {
+				if data := strings.TrimPrefix(line, "data: "); data != line && data != "[DONE]" {
+					payloads = append(payloads, data)
+				}
+			}
+		}
+	}
+	for _, payload := range payloads {
+		var data struct {
+			Type  string          `json:"type"`
+			Delta json.RawMessage `json:"delta"`
+			// Anthropic messages
+			StopReason string `json:"stop_reason"`
+			Content    []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+			// Responses
+			Status string `json:"status"`
+			Output []struct {
+				Content []struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"output"`
+			Response struct {
+				Status string `json:"status"`
+			} `json:"response"`
+			// Gemini
+			Candidates []struct {
+				Content struct {
+					Parts []struct {
+						Text string `json:"text"`
+					} `json:"parts"`
+				} `json:"content"`
+				FinishReason string `json:"finishReason"`
+			} `json:"candidates"`
+		}
+		if err := json.Unmarshal([]byte(payload), &data); err != nil {
+			t.Fatalf("unexpected payload %q: %v", payload, err)
+		}
+		switch {
+		case format == formatAnthropic && stream:
+			var delta struct {
+				Type       string `json:"type"`
+				Text       string `json:"text"`
+				StopReason string `json:"stop_reason"`
+			}
+			json.Unmarshal(data.Delta, &delta)
+			if delta.Type == "text_delta" {
+				text.WriteString(delta.Text)
+			}
+			if delta.StopReason != "" {
+				end = delta.StopReason
+			}
+		case format == formatAnthropic:
+			for _, block := range data.Content {
+				text.WriteString(block.Text)
+			}
+			end = data.StopReason
+		case format == formatResponses && stream:
+			if data.Type == "response.output_text.delta" {
+				var delta string
+				json.Unmarshal(data.Delta, &delta)
+				text.WriteString(delta)
+			}
+			if data.Response.Status != "" {
+				end = data.Response.Status
+			}
+		case format == formatResponses:
+			for _, item := range data.Output {
+				for _, part := range item.Content {
+					text.WriteString(part.Text)
+				}
+			}
+			end = data.Status
+		case format == formatGemini:
+			for _, candidate := range data.Candidates {
+				for _, part := range candidate.Content.Parts {
+					text.WriteString(part.Text)
+				}
+				if candidate.FinishReason != "" {
+					end = candidate.FinishReason
+				}
+			}
+		}
+	}
+	return text.String(), end
+}
+
+func TestContentFilter_OtherFormats(t *testing.T) {
+	tests := []struct {
+		format   string
+		action   string
+		wantText string
+		wantEnd  string
+	}{
+		{formatAnthropic, services.FilterActionRedact, "First. Call [REDACTED] now", "end_turn"},
+		{formatResponses, services.FilterActionRedact, "First. Call [REDACTED] now", "completed"},
+		{formatGemini, services.FilterActionRedact, "First. Call [REDACTED] now", "STOP"},
+		{formatAnthropic, services.FilterActionBlock, "First. ", "refusal"},
+		{formatResponses, services.FilterActionBlock, "First. ", "incomplete"},
+		{formatGemini, services.FilterActionBlock, "First. ", "SAFETY"},
+	}
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			name := tt.format + " " + tt.action
+			if stream {
+				name += " stream"
+			}
+			t.Run(name, func(t *testing.T) {
+				reply := chatReply("First. Call 555-1234 now", "stop", 5, 10)
+				if stream {
+					reply = chatStreamReply("chatcmpl-1", "First. Call 555-1234 now", "stop", 5, 10)
+				}
+				upstream := newChatUpstream(t, reply)
+				h, apiKey, _ := chatTestHandler(t, upstream.URL, 0)
+				addFilterRule(t, h, apiKey, services.FilterTypeRegex, `\d{3}-\d{4}`, tt.action)
+
+				rec := postFormat(h, apiKey, tt.format, stream)
+				if strings.Contains(rec.Body.String(), "555-1234") {
+					t.Fatalf("filtered text leaked: %s", rec.Body.String())
+				}
+				if tt.action == services.FilterActionBlock && !stream {
+					if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "content_filter") {
+						t.Errorf("status %d: %s, want the response blocked", rec.Code, rec.Body.String())
+					}
+					return
+				}
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+				}
+				text, end := formatOutput(t, tt.format, stream, rec.Body.String())
+				if text != tt.wantText || end != tt.wantEnd {
+					t.Errorf("got %q ended by %q, want %q ended by %q: %s", text, end, tt.wantText, tt.wantEnd, rec.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderContextUpgrade reports that a request was switched to a larger-context
+// variant of the requested model, and why
+const HeaderContextUpgrade = "X-Context-Upgrade"
+
+// GetContextUpgradePolicies lists the user's context upgrade policies
+func (h *Handler) GetContextUpgradePolicies(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	policies, err := h.upgradeService.GetPolicies(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get context upgrade policies")
+	}
+	return c.JSON(http.StatusOK, policies)
+}
+
+// SetContextUpgradePolicy creates or replaces the context upgrade policy of a model
+func (h *Handler) SetContextUpgradePolicy(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req services.ContextUpgradePolicyUpdate
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	policy, err := h.upgradeService.SetPolicy(user.ID, &req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, policy)
+}
+
+// DeleteContextUpgradePolicy removes a context upgrade policy
+func (h *Handler) DeleteContextUpgradePolicy(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid policy ID")
+	}
+
+	if err := h.upgradeService.DeletePolicy(user.ID, uint(id)); err != nil {
+		if errors.Is(err, services.ErrContextUpgradePolicyNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete context upgrade policy")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "context upgrade policy deleted"})
+}
+
+// contextUpgradeFor returns the model to request in place of model when the
+// request's estimated prompt exceeds its context window under a context
+// upgrade policy of the key's owner, recording the decision in the trace log
+// and the X-Context-Upgrade header. Otherwise model is returned unchanged.
+func (h *Handler) contextUpgradeFor(c echo.Context, apiKey *database.APIKey, model string) string {
+	promptTokens, _ := promptEstimate(c)
+	policy, window := h.upgradeService.UpgradeFor(apiKey.UserID, model, promptTokens)
+	if policy == nil {
+		return model
+	}
+	decision := fmt.Sprintf("%s -> %s; prompt_tokens=%d; context_window=%d", model, policy.UpgradeModel, promptTokens, window)
+	middleware.LogTrace(c, "ResolveProvider", "Context upgrade policy ID=%d: %s", policy.ID, decision)
+	c.Response().Header().Set(HeaderContextUpgrade, decision)
+	return policy.UpgradeModel
+}
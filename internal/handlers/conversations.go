@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/models"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ConversationResponse represents a conversation in API responses
+type ConversationResponse struct {
+	ID        string               `json:"id"`
+	Title     string               `json:"title"`
+	Model     string               `json:"model"`
+	CreatedAt time.Time            `json:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+	Messages  []models.ChatMessage `json:"messages,omitempty"`
+}
+
+func toConversationResponse(conv *database.Conversation, messages []models.ChatMessage) ConversationResponse {
+	return ConversationResponse{
+		ID:        conv.PublicID,
+		Title:     conv.Title,
+		Model:     conv.Model,
+		CreatedAt: conv.CreatedAt,
+		UpdatedAt: conv.UpdatedAt,
+		Messages:  messages,
+	}
+}
+
+// ListConversations handles GET /api/conversations
+func (h *Handler) ListConversations(c echo.Context) error {
+	convs, err := h.conversationService.GetConversations(middleware.AuthScope(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	response := make([]ConversationResponse, len(convs))
+	for i := range convs {
+		response[i] = toConversationResponse(&convs[i], nil)
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// CreateConversation handles POST /api/conversations
+func (h *Handler) CreateConversation(c echo.Context) error {
+	var req services.ConversationCreate
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	conv, err := h.conversationService.CreateConversation(middleware.AuthScope(c), &req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusCreated, toConversationResponse(conv, req.Messages))
+}
+
+// GetConversation handles GET /api/conversations/:id
+func (h *Handler) GetConversation(c echo.Context) error {
+	conv, err := h.conversationService.GetConversation(middleware.AuthScope(c), c.Param("id"))
+	if errors.Is(err, services.ErrConversationNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	messages, err := h.conversationService.History(conv)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, toConversationResponse(conv, messages))
+}
+
+// DeleteConversation handles DELETE /api/conversations/:id
+func (h *Handler) DeleteConversation(c echo.Context) error {
+	err := h.conversationService.DeleteConversation(middleware.AuthScope(c), c.Param("id"))
+	if errors.Is(err, services.ErrConversationNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// conversationCaptureWriter tees the chat completion output so the assistant turn can be stored
+type conversationCaptureWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *conversationCaptureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *conversationCaptureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// attachConversation expands req with the stored history of req.ConversationID and
+// returns a func that persists the new turns once the response has been written
+func (h *Handler) attachConversation(c echo.Context, req *models.ChatCompletionRequest) (func(), error) {
+	conv, err := h.conversationService.GetConversation(middleware.AuthScope(c), req.ConversationID)
+	if errors.Is(err, services.ErrConversationNotFound) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	history, err := h.conversationService.History(conv)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	newTurns := req.Messages
+	req.Messages = append(history, newTurns...)
+	req.ConversationID = ""
+	if req.Model == "" {
+		req.Model = conv.Model
+	}
+	middleware.LogTrace(c, "Conversation", "Expanded conversation %s: history=%d, new=%d", conv.PublicID, len(history), len(newTurns))
+
+	capture := &conversationCaptureWriter{ResponseWriter: c.Response().Writer}
+	c.Response().Writer = capture
+
+	return func() {
+		c.Response().Writer = capture.ResponseWriter
+		if c.Response().Status < 200 || c.Response().Status >= 300 {
+			return
+		}
+
+		contentType := c.Response().Header().Get(echo.HeaderContentType)
+		reply, ok := assembleAssistantMessage(capture.body.Bytes(), strings.HasPrefix(contentType, "text/event-stream"))
+		if !ok {
+			middleware.LogTrace(c, "Conversation", "No assistant message found in response; storing user turns only")
+		}
+
+		turns := newTurns
+		if ok {
+			turns = append(turns, reply)
+		}
+		if err := h.conversationService.AppendMessages(conv, turns); err != nil {
+			middleware.LogTrace(c, "Conversation", "Failed to persist turns for %s: %v", conv.PublicID, err)
+		}
+	}, nil
+}
+
+// assembleAssistantMessage extracts the assistant turn from an OpenAI chat completion
+// response body, either a single JSON object or an SSE stream of chunks
+func assembleAssistantMessage(body []byte, isStream bool) (models.ChatMessage, bool) {
+	if !isStream {
+		var resp models.ChatCompletionResponse
+		if err := json.Unmarshal(body, &resp); err != nil || len(resp.Choices) == 0 || resp.Choices[0].Message == nil {
+			return models.ChatMessage{}, false
+		}
+		return *resp.Choices[0].Message, true
+	}
+
+	var content strings.Builder
+	toolCalls := map[int]*models.ToolCall{}
+	found := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content   string `json:"content"`
+					ToolCalls []struct {
+						Index    int                 `json:"index"`
+						ID       string              `json:"id"`
+						Type     string              `json:"type"`
+						Function models.FunctionCall `json:"function"`
+					} `json:"tool_calls"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil || len(chunk.Choices) == 0 {
+			continue
+		}
+		found = true
+		delta := chunk.Choices[0].Delta
+		content.WriteString(delta.Content)
+		for _, tc := range delta.ToolCalls {
+			call, exists := toolCalls[tc.Index]
+			if !exists {
+				call = &models.ToolCall{Type: "function"}
+				toolCalls[tc.Index] = call
+			}
+			if tc.ID != "" {
+				call.ID = tc.ID
+			}
+			if tc.Function.Name != "" {
+				call.Function.Name = tc.Function.Name
+			}
+			call.Function.Arguments += tc.Function.Arguments
+		}
+	}
+	if !found {
+		return models.ChatMessage{}, false
+	}
+
+	msg := models.ChatMessage{Role: "assistant"}
+	if content.Len() > 0 {
+		msg.Content = content.String()
+	}
+	indexes := make([]int, 0, len(toolCalls))
+	for idx := range toolCalls {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+	for _, idx := range indexes {
+		msg.ToolCalls = append(msg.ToolCalls, *toolCalls[idx])
+	}
+	return msg, true
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// creditLedgerLimit is the default number of ledger entries returned
+const creditLedgerLimit = 100
+
+// CreditsResponse is a user's credit balance with the most recent ledger entries
+type CreditsResponse struct {
+	*services.CreditBalance
+	Ledger []database.CreditLedgerEntry `json:"ledger"`
+}
+
+// creditsResponse builds the credit balance and ledger of a user
+func (h *Handler) creditsResponse(c echo.Context, userID uint) error {
+	limit := creditLedgerLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > 1000 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 1000")
+		}
+	}
+
+	balance, err := h.creditService.Balance(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get credit balance")
+	}
+	ledger, err := h.creditService.Ledger(userID, limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get credit ledger")
+	}
+	return c.JSON(http.StatusOK, CreditsResponse{CreditBalance: balance, Ledger: ledger})
+}
+
+// GetCredits returns the current user's credit balance and recent ledger entries
+func (h *Handler) GetCredits(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+	return h.creditsResponse(c, user.ID)
+}
+
+// GetUserCredits returns the credit balance and recent ledger entries of any user (admin only)
+func (h *Handler) GetUserCredits(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	return h.creditsResponse(c, uint(id))
+}
+
+// GrantCredits adds credits to a user's balance, or removes them with a negative amount (admin only)
+func (h *Handler) GrantCredits(c echo.Context) error {
+	admin := middleware.GetUser(c)
+	if admin == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+
+	var req services.CreditGrantRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	entry, err := h.creditService.Grant(uint(id), admin.ID, &req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusCreated, entry)
+}
+
+// ListModelPrices lists the credit prices of models (admin only)
+func (h *Handler) ListModelPrices(c echo.Context) error {
+	prices, err := h.creditService.ListModelPrices()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get model prices")
+	}
+	return c.JSON(http.StatusOK, prices)
+}
+
+// SetModelPrice creates or replaces the credit price of a model (admin only)
+func (h *Handler) SetModelPrice(c echo.Context) error {
+	var req services.ModelPriceRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	price, err := h.creditService.SetModelPrice(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, price)
+}
+
+// DeleteModelPrice removes a model price (admin only)
+func (h *Handler) DeleteModelPrice(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid price ID")
+	}
+
+	if err := h.creditService.DeleteModelPrice(uint(id)); err != nil {
+		if errors.Is(err, services.ErrModelPriceNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete model price")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "model price deleted"})
+}
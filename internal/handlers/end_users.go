@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderEndUser identifies the end user a request is made for. It takes
+// precedence over the user fields of the request body.
+const HeaderEndUser = "X-Gateway-End-User"
+
+// Context keys of the end user a request is made for
+const (
+	contextKeyEndUser   = "end_user"    // identifier sent by the client
+	contextKeyEndUserID = "end_user_id" // tracked EndUser record
+)
+
+// identifyEndUser notes the end user a request is made for, tracks it under the
+// API key and enforces its quotas. declared is the end-user ID of the request
+// body (OpenAI "user", Anthropic "metadata.user_id").
+func (h *Handler) identifyEndUser(c echo.Context, declared string) error {
+	endUser := strings.TrimSpace(c.Request().Header.Get(HeaderEndUser))
+	if endUser == "" {
+		endUser = strings.TrimSpace(declared)
+	}
+	if endUser == "" {
+		return nil
+	}
+	c.Set(contextKeyEndUser, endUser)
+
+	apiKey := middleware.GetAPIKey(c)
+	if apiKey == nil {
+		return nil
+	}
+	tracked, err := h.endUserService.Resolve(apiKey.ID, endUser)
+	if err != nil {
+		middleware.LogTrace(c, "EndUser", "Failed to track end user: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := h.endUserService.CheckLimits(tracked); err != nil {
+		middleware.LogTrace(c, "EndUser", "Rejected request for end user %s: %v", endUser, err)
+		if errors.Is(err, services.ErrEndUserBlocked) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+	}
+	c.Set(contextKeyEndUserID, tracked.ID)
+	return nil
+}
+
+// usageAttribution returns the tags, end user, provider config and request ID a
+// request's usage is recorded under
+func usageAttribution(c echo.Context) services.UsageAttribution {
+	attr := services.UsageAttribution{Tags: usageTags(c)}
+	if traceID, ok := c.Get(middleware.ContextKeyTraceID).(string); ok {
+		attr.RequestID = traceID
+	}
+	if started, ok := c.Get(middleware.ContextKeyRequestStart).(time.Time); ok {
+		attr.Started = started
+	}
+	if id, ok := c.Get(contextKeyEndUserID).(uint); ok {
+		attr.EndUserID = &id
+	}
+	if cfg := middleware.GetProviderConfig(c); cfg != nil {
+		attr.ProviderConfigID = &cfg.ID
+	}
+	return attr
+}
+
+// endUserError maps end user service errors to HTTP errors
+func endUserError(err error) error {
+	if errors.Is(err, services.ErrEndUserNotFound) || errors.Is(err, services.ErrAPIKeyNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+}
+
+// endUserParams parses the key ID and end-user ID of an end user route
+func endUserParams(c echo.Context) (uint, string, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, "", echo.NewHTTPError(http.StatusBadRequest, "invalid key ID")
+	}
+	externalID, err := url.PathUnescape(c.Param("end_user"))
+	if err != nil || externalID == "" {
+		return 0, "", echo.NewHTTPError(http.StatusBadRequest, "invalid end user ID")
+	}
+	return uint(id), externalID, nil
+}
+
+// ListEndUsers lists the end users seen on an API key
+func (h *Handler) ListEndUsers(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid key ID")
+	}
+
+	q, err := listQuery(c, 0)
+	if err != nil {
+		return err
+	}
+	endUsers, total, err := h.endUserService.ListEndUsers(user.ID, uint(id), q)
+	if err != nil {
+		return endUserError(err)
+	}
+	setListTotal(c, total)
+	return c.JSON(http.StatusOK, endUsers)
+}
+
+// GetEndUser returns the usage and quotas of an end user
+func (h *Handler) GetEndUser(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	keyID, externalID, err := endUserParams(c)
+	if err != nil {
+		return err
+	}
+
+	endUser, err := h.endUserService.GetEndUser(user.ID, keyID, externalID)
+	if err != nil {
+		return endUserError(err)
+	}
+	return c.JSON(http.StatusOK, endUser)
+}
+
+// SetEndUser sets the quotas of an end user
+func (h *Handler) SetEndUser(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	keyID, externalID, err := endUserParams(c)
+	if err != nil {
+		return err
+	}
+
+	if before, err := h.endUserService.GetEndUser(user.ID, keyID, externalID); err == nil {
+		middleware.AuditBefore(c, before)
+	}
+
+	var req services.EndUserUpdate
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	endUser, err := h.endUserService.SetEndUser(user.ID, keyID, externalID, &req)
+	if err != nil {
+		return endUserError(err)
+	}
+	return c.JSON(http.StatusOK, endUser)
+}
+
+// DeleteEndUser removes an end user and its quotas
+func (h *Handler) DeleteEndUser(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	keyID, externalID, err := endUserParams(c)
+	if err != nil {
+		return err
+	}
+
+	if before, err := h.endUserService.GetEndUser(user.ID, keyID, externalID); err == nil {
+		middleware.AuditBefore(c, before)
+	}
+
+	if err := h.endUserService.DeleteEndUser(user.ID, keyID, externalID); err != nil {
+		return endUserError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "end user deleted"})
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+)
+
+// UploadFile handles POST /v1/files - streams a multipart upload to the upstream
+func (h *Handler) UploadFile(c echo.Context) error {
+	return h.proxyOpenAI(c, "/files", "/v1/files")
+}
+
+// ListFiles handles GET /v1/files
+func (h *Handler) ListFiles(c echo.Context) error {
+	return h.proxyOpenAI(c, "/files", "/v1/files")
+}
+
+// GetFile handles GET /v1/files/:id
+func (h *Handler) GetFile(c echo.Context) error {
+	return h.proxyOpenAI(c, "/files/"+url.PathEscape(c.Param("id")), "/v1/files")
+}
+
+// GetFileContent handles GET /v1/files/:id/content
+func (h *Handler) GetFileContent(c echo.Context) error {
+	return h.proxyOpenAI(c, "/files/"+url.PathEscape(c.Param("id"))+"/content", "/v1/files")
+}
+
+// DeleteFile handles DELETE /v1/files/:id
+func (h *Handler) DeleteFile(c echo.Context) error {
+	return h.proxyOpenAI(c, "/files/"+url.PathEscape(c.Param("id")), "/v1/files")
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// upstreamRequest is a request received by a passthroughUpstream
+type upstreamRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   string
+}
+
+// passthroughUpstream is an OpenAI-compatible upstream recording the requests
+// it receives and answering them with reply
+type passthroughUpstream struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []upstreamRequest
+}
+
+func newPassthroughUpstream(t *testing.T, reply http.HandlerFunc) *passthroughUpstream {
+	u := &passthroughUpstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		u.mu.Lock()
+		u.requests = append(u.requests, upstreamRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: string(body)})
+		u.mu.Unlock()
+		reply(w, r)
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// count returns how many requests the upstream received
+func (u *passthroughUpstream) count() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.requests)
+}
+
+// last returns the last request the upstream received
+func (u *passthroughUpstream) last(t *testing.T) upstreamRequest {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.requests) == 0 {
+		t.Fatal("the upstream received no request")
+	}
+	return u.requests[len(u.requests)-1]
+}
+
+// passthroughRouter routes the passthrough endpoints of h, authenticating
+// requests with apiKey
+func passthroughRouter(h *Handler, apiKey *database.APIKey) *echo.Echo {
+	setKey := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(middleware.ContextKeyAPIKey, apiKey)
+			c.Set(middleware.ContextKeyUser, &apiKey.User)
+			return next(c)
+		}
+	}
+	e := echo.New()
+	v1 := e.Group("/v1", setKey)
+	v1.POST("/files", h.UploadFile)
+	v1.GET("/files", h.ListFiles)
+	v1.GET("/files/:id", h.GetFile)
+	v1.GET("/files/:id/content", h.GetFileContent)
+	v1.DELETE("/files/:id", h.DeleteFile)
+	for _, prefix := range []string{"/assistants", "/threads"} {
+		v1.GET(prefix, h.AssistantsPassthrough)
+		v1.POST(prefix, h.AssistantsPassthrough)
+		v1.GET(prefix+"/*", h.AssistantsPassthrough)
+		v1.POST(prefix+"/*", h.AssistantsPassthrough)
+		v1.DELETE(prefix+"/*", h.AssistantsPassthrough)
+	}
+	return e
+}
+
+func TestFiles_Passthrough(t *testing.T) {
+	var upload bytes.Buffer
+	form := multipart.NewWriter(&upload)
+	form.WriteField("purpose", "batch")
+	part, _ := form.CreateFormFile("file", "batch.jsonl")
+	part.Write([]byte(`{"custom_id":"1"}` + "\n"))
+	form.Close()
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		contentType  string
+		body         string
+		wantPath     string
+		wantBody     string // body the upstream receives
+		wantResponse string // substring of the relayed response
+	}{
+		{
+			name:         "upload",
+			method:       http.MethodPost,
+			path:         "/v1/files",
+			contentType:  form.FormDataContentType(),
+			body:         upload.String(),
+			wantPath:     "/files",
+			wantBody:     upload.String(),
+			wantResponse: `"id":"file-1"`,
+		},
+		{name: "list", method: http.MethodGet, path: "/v1/files?purpose=batch", wantPath: "/files", wantResponse: `"object":"list"`},
+		{name: "retrieve", method: http.MethodGet, path: "/v1/files/file-1", wantPath: "/files/file-1", wantResponse: `"id":"file-1"`},
+		{name: "content", method: http.MethodGet, path: "/v1/files/file-1/content", wantPath: "/files/file-1/content", wantResponse: `{"custom_id":"1"}`},
+		{name: "delete", method: http.MethodDelete, path: "/v1/files/file-1", wantPath: "/files/file-1", wantResponse: `"deleted":true`},
+	}
+
+	upstream := newPassthroughUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/content"):
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", `attachment; filename="batch.jsonl"`)
+			w.Write([]byte(`{"custom_id":"1"}` + "\n"))
+		case r.Method == http.MethodGet && r.URL.Path == "/files":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"object":"list","data":[{"id":"file-1","object":"file"}]}`))
+		case r.Method == http.MethodDelete:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"file-1","object":"file","deleted":true}`))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"file-1","object":"file","purpose":"batch"}`))
+		}
+	})
+	h, apiKey, db := chatTestHandler(t, upstream.URL, 0)
+	e := passthroughRouter(h, apiKey)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set(echo.HeaderContentType, tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), tt.wantResponse) {
+				t.Fatalf("status %d, body %q, want %q", rec.Code, rec.Body.String(), tt.wantResponse)
+			}
+
+			got := upstream.last(t)
+			if got.Method != tt.method || got.Path != tt.wantPath {
+				t.Errorf("upstream got %s %s, want %s %s", got.Method, got.Path, tt.method, tt.wantPath)
+			}
+			if auth := got.Header.Get("Authorization"); auth != "Bearer sk-upstream" {
+				t.Errorf("upstream Authorization = %q, want the provider config's key", auth)
+			}
+			if got.Body != tt.wantBody {
+				t.Errorf("upstream body = %q, want %q", got.Body, tt.wantBody)
+			}
+			if tt.contentType != "" && got.Header.Get(echo.HeaderContentType) != tt.contentType {
+				t.Errorf("upstream Content-Type = %q, want %q", got.Header.Get(echo.HeaderContentType), tt.contentType)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/files/file-1/content", nil))
+	if got := rec.Header().Get(echo.HeaderContentDisposition); got != `attachment; filename="batch.jsonl"` {
+		t.Errorf("Content-Disposition = %q, want the upstream's", got)
+	}
+	if n := len(usageRecords(t, db, apiKey)); n != len(tests)+1 {
+		t.Errorf("recorded %d usage records, want one per call", n)
+	}
+}
+
+func TestFiles_PrefersOpenAIConfigs(t *testing.T) {
+	compatible := newPassthroughUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[]}`))
+	})
+	openai := newPassthroughUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[]}`))
+	})
+	h, apiKey, db := chatTestHandler(t, compatible.URL, 0)
+	apiKey.ProviderConfigs[0].Provider = "deepseek"
+	anthropic := addProviderConfig(t, db, apiKey, "anthropic", "http://anthropic.invalid")
+	anthropic.Provider, anthropic.Protocol = "anthropic", "anthropic"
+	addProviderConfig(t, db, apiKey, "openai", openai.URL)
+	e := passthroughRouter(h, apiKey)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/files", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if openai.count() != 1 || compatible.count() != 0 {
+		t.Errorf("requests: openai=%d compatible=%d, want the openai config", openai.count(), compatible.count())
+	}
+
+	// Without an OpenAI-compatible config the call is refused
+	for i := range apiKey.ProviderConfigs {
+		apiKey.ProviderConfigs[i].Protocol = "anthropic"
+		apiKey.ProviderConfigs[i].Provider = "anthropic"
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/files", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without an OpenAI-compatible config: status %d, want 401", rec.Code)
+	}
+}
@@ -2,9 +2,11 @@ package handlers
 
 import (
 "encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"ai_gateway/internal/adapters"
@@ -17,30 +19,52 @@ import (
 
 
 
-// GeminiGenerateContent handles POST /v1/models/:model
+// GeminiGenerateContent handles POST /v1/models/:model and /v1beta/models/:model
 func (h *Handler) GeminiGenerateContent(c echo.Context) error {
-	// Get model from path (format: model:generateContent)
-	modelPath := c.Param("model")
-	model := strings.TrimSuffix(modelPath, ":generateContent")
-	model = strings.TrimSuffix(model, ":streamGenerateContent")
+	// Get model and action from path (format: model:generateContent), which
+	// some clients send with the colon URL-encoded
+	modelPath, err := url.PathUnescape(c.Param("model"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid model name")
+	}
+	model, action, _ := strings.Cut(modelPath, ":")
 
-	// Check for streaming via query param
+	// Check for streaming via the action verb or query param
 	isStream := c.QueryParam("alt") == "sse"
+	switch action {
+	case "", "generateContent":
+	case "streamGenerateContent":
+		isStream = true
+	default:
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("method %q is not supported", action))
+	}
 
 	// Parse request
 	var req models.GenerateContentRequest
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
 	}
+	if err := h.identifyEndUser(c, ""); err != nil {
+		return err
+	}
+
+	// Enforce the key's response policy on the output, after the content filter
+	defer h.applyResponsePolicy(c, formatGemini)()
+	// Apply the user's content filter rules to the output
+	defer h.filterOutput(c, formatGemini)()
+
+	h.compressGeminiHistory(c, &req)
+	noteGeminiPromptEstimate(c, &req)
 
 	// Determine target provider from model name
 	provider := ""
 	resolved, err := h.resolveProviderForAPIKey(c, model)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		return resolveProviderError(err)
 	}
 	if resolved != nil {
 		c.Set(middleware.ContextKeyProviderConfig, resolved.Config)
+		c.Set(middleware.ContextKeyUpstreamModel, resolved.Model)
 		model = resolved.Model
 		provider = resolved.Provider
 	}
@@ -48,7 +72,21 @@ func (h *Handler) GeminiGenerateContent(c echo.Context) error {
 		provider = h.getTargetProvider(c, model)
 	}
 	if provider == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "unsupported model")
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("models/%s is not found", model))
+	}
+	var maxTokens *int
+	if req.GenerationConfig != nil {
+		maxTokens = req.GenerationConfig.MaxOutputTokens
+	}
+	limit, err := h.applyMaxCost(c, model, nil, maxTokens)
+	if err != nil {
+		return err
+	}
+	if limit != maxTokens {
+		if req.GenerationConfig == nil {
+			req.GenerationConfig = &models.GenerationConfig{}
+		}
+		req.GenerationConfig.MaxOutputTokens = limit
 	}
 
 	// Get credentials
@@ -57,16 +95,29 @@ func (h *Handler) GeminiGenerateContent(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 	}
 
-	// Route to appropriate handler
+	// Fit inline images to the limits of the upstream
+	if err := h.fitGeminiInlineImages(c, protocol, &req); err != nil {
+		return err
+	}
+
+	// Continue responses cut off at max tokens when the key enables it
+	if budget := autoContinueBudget(c); budget > 0 {
+		return h.autoContinuedGenerateContent(c, &req, model, budget, baseURL, apiKey, protocol, isStream)
+	}
+	return h.dispatchGenerateContent(c, &req, model, baseURL, apiKey, protocol, isStream)
+}
+
+// dispatchGenerateContent routes a generateContent request to the handler for the upstream protocol
+func (h *Handler) dispatchGenerateContent(c echo.Context, req *models.GenerateContentRequest, model, baseURL, apiKey, protocol string, isStream bool) error {
 	switch protocol {
 	case "gemini":
-		return h.handleGeminiToGemini(c, &req, model, baseURL, apiKey, isStream)
+		return h.handleGeminiToGemini(c, req, model, baseURL, apiKey, isStream)
 	case "openai_chat":
-		return h.handleGeminiToOpenAI(c, &req, model, baseURL, apiKey, isStream)
+		return h.handleGeminiToOpenAI(c, req, model, baseURL, apiKey, isStream)
 	case "openai_code":
-		return h.handleGeminiToOpenAIResponses(c, &req, model, baseURL, apiKey, isStream)
+		return h.handleGeminiToOpenAIResponses(c, req, model, baseURL, apiKey, isStream)
 	case "anthropic":
-		return h.handleGeminiToAnthropic(c, &req, model, baseURL, apiKey, isStream)
+		return h.handleGeminiToAnthropic(c, req, model, baseURL, apiKey, isStream)
 	default:
 		return echo.NewHTTPError(http.StatusBadRequest, "unsupported protocol")
 	}
@@ -93,11 +144,16 @@ func (h *Handler) handleGeminiToGemini(c echo.Context, req *models.GenerateConte
 
 // handleGeminiToOpenAI converts and forwards to OpenAI
 func (h *Handler) handleGeminiToOpenAI(c echo.Context, req *models.GenerateContentRequest, model, baseURL, apiKey string, isStream bool) error {
+	if err := checkStrictConversion(c, "openai_chat", converters.GeminiUnsupportedFields(req, "openai_chat")); err != nil {
+		return err
+	}
+
 	// Convert request
 	openaiReq, err := converters.GeminiToOpenAIRequest(req, model)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	reportToolSchemaWarnings(c, converters.SanitizeOpenAIChatTools(openaiReq.Tools))
 
 	adapter := adapters.NewOpenAIAdapter(apiKey, baseURL)
 
@@ -109,6 +165,10 @@ func (h *Handler) handleGeminiToOpenAI(c echo.Context, req *models.GenerateConte
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
 	}
+	if statusCode >= http.StatusBadRequest {
+		h.recordGeminiUsage(c, "/v1/models/"+model, model, resp, statusCode)
+		return upstreamError(statusCode, resp)
+	}
 
 	// Convert response
 	geminiResp, err := converters.OpenAIToGeminiResponse(resp)
@@ -124,6 +184,10 @@ func (h *Handler) handleGeminiToOpenAI(c echo.Context, req *models.GenerateConte
 
 // handleGeminiToOpenAIResponses converts and forwards to OpenAI Responses API
 func (h *Handler) handleGeminiToOpenAIResponses(c echo.Context, req *models.GenerateContentRequest, model, baseURL, apiKey string, isStream bool) error {
+	if err := checkStrictConversion(c, "openai_code", converters.GeminiUnsupportedFields(req, "openai_code")); err != nil {
+		return err
+	}
+
 	openaiChatReq, err := converters.GeminiToOpenAIRequest(req, model)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -134,7 +198,7 @@ func (h *Handler) handleGeminiToOpenAIResponses(c echo.Context, req *models.Gene
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	enforceOpenAIReasoningHigh(openaiResponsesReq)
+	applyReasoningPolicy(c, openaiResponsesReq)
 
 	adapter := adapters.NewOpenAIAdapter(apiKey, baseURL)
 
@@ -146,6 +210,10 @@ func (h *Handler) handleGeminiToOpenAIResponses(c echo.Context, req *models.Gene
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
 	}
+	if statusCode >= http.StatusBadRequest {
+		h.recordGeminiUsage(c, "/v1/models/"+model, model, resp, statusCode)
+		return upstreamError(statusCode, resp)
+	}
 
 	chatResp, err := converters.OpenAIResponsesToOpenAIChatResponse(resp, model)
 	if err != nil {
@@ -169,11 +237,16 @@ func (h *Handler) handleGeminiToOpenAIResponses(c echo.Context, req *models.Gene
 
 // handleGeminiToAnthropic converts and forwards to Anthropic
 func (h *Handler) handleGeminiToAnthropic(c echo.Context, req *models.GenerateContentRequest, model, baseURL, apiKey string, isStream bool) error {
+	if err := checkStrictConversion(c, "anthropic", converters.GeminiUnsupportedFields(req, "anthropic")); err != nil {
+		return err
+	}
+
 	// Convert request
 	anthropicReq, err := converters.GeminiToAnthropicRequest(req, model)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	applyAnthropicMaxTokens(c, anthropicReq, req.GenerationConfig != nil && req.GenerationConfig.MaxOutputTokens != nil)
 
 	adapter := adapters.NewAnthropicAdapter(apiKey, baseURL)
 
@@ -185,6 +258,10 @@ func (h *Handler) handleGeminiToAnthropic(c echo.Context, req *models.GenerateCo
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
 	}
+	if statusCode >= http.StatusBadRequest {
+		h.recordGeminiUsage(c, "/v1/models/"+model, model, resp, statusCode)
+		return upstreamError(statusCode, resp)
+	}
 
 	// Convert response
 	geminiResp, err := converters.AnthropicToGeminiResponse(resp)
@@ -236,6 +313,9 @@ func (h *Handler) streamGeminiFromOpenAI(c echo.Context, adapter *adapters.OpenA
 		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
 	}
 	defer stream.Close()
+	if statusCode >= http.StatusBadRequest {
+		return upstreamStreamError(stream, statusCode)
+	}
 
 	c.Response().Header().Set("Content-Type", "text/event-stream")
 	c.Response().Header().Set("Cache-Control", "no-cache")
@@ -294,6 +374,9 @@ func (h *Handler) streamGeminiFromOpenAIResponses(c echo.Context, adapter *adapt
 		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
 	}
 	defer stream.Close()
+	if statusCode >= http.StatusBadRequest {
+		return upstreamStreamError(stream, statusCode)
+	}
 
 	c.Response().Header().Set("Content-Type", "text/event-stream")
 	c.Response().Header().Set("Cache-Control", "no-cache")
@@ -325,12 +408,12 @@ func (h *Handler) streamGeminiFromOpenAIResponses(c echo.Context, adapter *adapt
 				break
 			}
 
-			var eventData map[string]interface{}
-			if err := json.Unmarshal([]byte(data), &eventData); err != nil {
+			var event models.ResponsesStreamEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
 				continue
 			}
 
-			chunks, err := converters.OpenAIResponsesStreamToOpenAIChatStream(eventData, state)
+			chunks, err := converters.OpenAIResponsesStreamToOpenAIChatStream(&event, state)
 			if err != nil {
 				continue
 			}
@@ -368,6 +451,9 @@ func (h *Handler) streamGeminiFromAnthropic(c echo.Context, adapter *adapters.An
 		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
 	}
 	defer stream.Close()
+	if statusCode >= http.StatusBadRequest {
+		return upstreamStreamError(stream, statusCode)
+	}
 
 	c.Response().Header().Set("Content-Type", "text/event-stream")
 	c.Response().Header().Set("Cache-Control", "no-cache")
@@ -428,7 +514,7 @@ func (h *Handler) streamGeminiFromAnthropic(c echo.Context, adapter *adapters.An
 
 // recordGeminiUsage records usage from Gemini response
 func (h *Handler) recordGeminiUsage(c echo.Context, endpoint, model string, resp map[string]interface{}, statusCode int) {
-	apiKey := middleware.GetAPIKey(c)
+	apiKey := usageKey(c)
 	if apiKey == nil {
 		return
 	}
@@ -443,12 +529,12 @@ func (h *Handler) recordGeminiUsage(c echo.Context, endpoint, model string, resp
 		}
 	}
 
-	h.apiKeyService.RecordUsage(apiKey.ID, endpoint, model, promptTokens, completionTokens, statusCode)
+	h.apiKeyService.RecordUsage(apiKey.ID, endpoint, model, promptTokens, completionTokens, statusCode, usageAttribution(c))
 }
 
 // recordGeminiUsageFromResp records usage from Gemini response struct
 func (h *Handler) recordGeminiUsageFromResp(c echo.Context, endpoint, model string, resp *models.GenerateContentResponse, statusCode int) {
-	apiKey := middleware.GetAPIKey(c)
+	apiKey := usageKey(c)
 	if apiKey == nil {
 		return
 	}
@@ -459,5 +545,5 @@ func (h *Handler) recordGeminiUsageFromResp(c echo.Context, endpoint, model stri
 		completionTokens = resp.UsageMetadata.CandidatesTokenCount
 	}
 
-	h.apiKeyService.RecordUsage(apiKey.ID, endpoint, model, promptTokens, completionTokens, statusCode)
+	h.apiKeyService.RecordUsage(apiKey.ID, endpoint, model, promptTokens, completionTokens, statusCode, usageAttribution(c))
 }
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/models"
+	"ai_gateway/internal/services"
+	"ai_gateway/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderSchemaRepairs reports how many repair retries a schema-guarded completion took
+const HeaderSchemaRepairs = "X-Schema-Repairs"
+
+// responseSchema returns the JSON schema a request declares for its output, or nil
+func responseSchema(req *models.ChatCompletionRequest) interface{} {
+	rf := req.ResponseFormat
+	if rf == nil || rf.Type != "json_schema" || rf.JSONSchema == nil {
+		return nil
+	}
+	return rf.JSONSchema.Schema
+}
+
+// schemaRepairRetries returns the repair retries enabled on the request's API key
+func schemaRepairRetries(c echo.Context) int {
+	apiKey := middleware.GetAPIKey(c)
+	if apiKey == nil {
+		return 0
+	}
+	return apiKey.SchemaRepairRetries
+}
+
+// schemaViolations checks completion content against schema
+func schemaViolations(content string, schema interface{}) []string {
+	content = strings.TrimSpace(content)
+	// Tolerate a fenced code block around the JSON
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(strings.TrimPrefix(content, "```json"), "```")
+		content = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(content), "```"))
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return []string{"output is not valid JSON: " + err.Error()}
+	}
+	return utils.ValidateJSONSchema(schema, value)
+}
+
+// schemaRepairPrompt asks the model to fix output that failed validation
+func schemaRepairPrompt(violations []string) string {
+	var b strings.Builder
+	b.WriteString("Your previous response does not conform to the required JSON schema:\n")
+	for _, v := range violations {
+		fmt.Fprintf(&b, "- %s\n", v)
+	}
+	b.WriteString("Respond again with only the corrected JSON, matching the schema exactly.")
+	return b.String()
+}
+
+// schemaGuardedChatCompletion validates the model's output against the request's
+// JSON schema, asking the model to repair invalid output up to retries times
+// before returning the last answer. All attempts are recorded as one request.
+func (h *Handler) schemaGuardedChatCompletion(c echo.Context, req *models.ChatCompletionRequest, schema interface{}, retries int, baseURL, apiKey, protocol string) error {
+	var usage models.Usage
+	for attempt := 0; ; attempt++ {
+		turn, err := cloneChatRequest(req)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to copy request")
+		}
+
+		rec := newCompletionRecorder()
+		sc := subContext(c, c.Request(), rec)
+		sc.Set(middleware.ContextKeyProviderConfig, middleware.GetProviderConfig(c))
+		sc.Set(middleware.ContextKeyAPIKey, nil)
+		rec.err = h.dispatchChatCompletion(sc, turn, baseURL, apiKey, protocol)
+
+		resp, ok := rec.response()
+		if !ok {
+			if usage.TotalTokens > 0 {
+				h.recordUsageFromOpenAI(c, "/v1/chat/completions", req.Model, &models.ChatCompletionResponse{Usage: &usage}, rec.statusCode())
+				h.metrics.ObserveSchemaValidation(services.SchemaResultFailed, attempt)
+			}
+			return rec.replay(c)
+		}
+		addUsage(&usage, resp.Usage)
+
+		msg := resp.Choices[0].Message
+		var violations []string
+		if len(msg.ToolCalls) == 0 {
+			violations = schemaViolations(msg.GetTextContent(), schema)
+		}
+		if len(violations) == 0 || attempt >= retries {
+			result := services.SchemaResultValid
+			if len(violations) > 0 {
+				result = services.SchemaResultFailed
+				middleware.LogTrace(c, "Guardrails", "Output still invalid after %d repairs: %s", attempt, strings.Join(violations, "; "))
+			} else if attempt > 0 {
+				result = services.SchemaResultRepaired
+			}
+			h.metrics.ObserveSchemaValidation(result, attempt)
+
+			resp.Usage = &usage
+			h.recordUsageFromOpenAI(c, "/v1/chat/completions", req.Model, resp, http.StatusOK)
+			c.Response().Header().Set(HeaderSchemaRepairs, strconv.Itoa(attempt))
+			return c.JSON(http.StatusOK, resp)
+		}
+
+		middleware.LogTrace(c, "Guardrails", "Output failed schema validation (attempt %d): %s", attempt+1, strings.Join(violations, "; "))
+		req.Messages = append(req.Messages, *msg, models.ChatMessage{Role: "user", Content: schemaRepairPrompt(violations)})
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"ai_gateway/internal/config"
+	"ai_gateway/internal/events"
 	"ai_gateway/internal/services"
 
 	"gorm.io/gorm"
@@ -9,20 +10,80 @@ import (
 
 // Handler contains all route handlers
 type Handler struct {
-	db            *gorm.DB
-	cfg           *config.Config
-	authService   *services.AuthService
-	configService *services.ConfigService
-	apiKeyService *services.APIKeyService
+	db                   *gorm.DB
+	cfg                  *config.Config
+	authService          *services.AuthService
+	configService        *services.ConfigService
+	apiKeyService        *services.APIKeyService
+	assistantService     *services.AssistantService
+	storedResponses      *services.StoredResponseService
+	conversationService  *services.ConversationService
+	digestService        *services.DigestService
+	metrics              *services.MetricsCollector
+	events               *events.Bus
+	credentialCache      *services.CredentialCache
+	streamTracker        *services.StreamTracker
+	sloService           *services.SLOService
+	accountService       *services.AccountService
+	healthService        *services.HealthService
+	hedgeService         *services.HedgeService
+	cascadeService       *services.CascadeService
+	upgradeService       *services.ContextUpgradeService
+	routingPolicyService *services.RoutingPolicyService
+	mcpService           *services.MCPService
+	webSearchService     *services.WebSearchService
+	imageFetcher         *services.ImageFetchService
+	contentFilterService *services.ContentFilterService
+	endUserService       *services.EndUserService
+	stripeBillingService *services.StripeBillingService
+	providerUsageService *services.ProviderUsageSyncService
+	creditService        *services.CreditService
+	auditService         *services.AuditService
+	signingService       *services.RequestSigningService
+	requestLogService    *services.RequestLogService
+	canaryService        *services.CanaryService
+	maintenanceService   *services.MaintenanceService
 }
 
 // New creates a new Handler instance
 func New(db *gorm.DB, cfg *config.Config) *Handler {
+	metrics := services.NewMetricsCollector()
+	bus := events.NewBus()
+	cache := services.NewCredentialCache(cfg, bus)
+	apiKeys := services.NewAPIKeyService(db, cfg, bus, cache)
 	return &Handler{
-		db:            db,
-		cfg:           cfg,
-		authService:   services.NewAuthService(db, cfg),
-		configService: services.NewConfigService(db, cfg),
-		apiKeyService: services.NewAPIKeyService(db),
+		db:                   db,
+		cfg:                  cfg,
+		authService:          services.NewAuthService(db, cfg),
+		configService:        services.NewConfigService(db, cfg, bus, cache),
+		apiKeyService:        apiKeys,
+		assistantService:     services.NewAssistantService(db),
+		storedResponses:      services.NewStoredResponseService(db),
+		conversationService:  services.NewConversationService(db),
+		digestService:        services.NewDigestService(db, cfg, services.NewMailer(cfg)),
+		metrics:              metrics,
+		events:               bus,
+		credentialCache:      cache,
+		streamTracker:        services.NewStreamTracker(cfg),
+		sloService:           services.NewSLOService(db, cfg, metrics),
+		accountService:       services.NewAccountService(db, cfg, bus, services.NewArchiveService(db, cfg)),
+		healthService:        services.NewHealthService(db, cfg, bus, cache),
+		hedgeService:         services.NewHedgeService(db),
+		cascadeService:       services.NewCascadeService(db),
+		upgradeService:       services.NewContextUpgradeService(db),
+		routingPolicyService: services.NewRoutingPolicyService(db),
+		mcpService:           services.NewMCPService(db, cfg),
+		webSearchService:     services.NewWebSearchService(cfg),
+		imageFetcher:         services.NewImageFetchService(cfg),
+		contentFilterService: services.NewContentFilterService(db, cfg),
+		endUserService:       services.NewEndUserService(db, cfg),
+		stripeBillingService: services.NewStripeBillingService(db, cfg),
+		providerUsageService: services.NewProviderUsageSyncService(db, cfg),
+		creditService:        services.NewCreditService(db),
+		auditService:         services.NewAuditService(db),
+		signingService:       services.NewRequestSigningService(db, cfg, bus),
+		requestLogService:    services.NewRequestLogService(db, cfg),
+		canaryService:        services.NewCanaryService(db, bus, apiKeys, services.NewMailer(cfg)),
+		maintenanceService:   services.NewMaintenanceService(db),
 	}
 }
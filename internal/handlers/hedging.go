@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/models"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderHedgeWinner reports which attempt served a hedged request
+const HeaderHedgeWinner = "X-Hedge-Winner"
+
+// errHedgeLost is returned to an attempt writing after another attempt won the race
+var errHedgeLost = errors.New("hedged request lost the race")
+
+// GetHedgePolicies lists the user's hedge policies
+func (h *Handler) GetHedgePolicies(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	policies, err := h.hedgeService.GetPolicies(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get hedge policies")
+	}
+	return c.JSON(http.StatusOK, policies)
+}
+
+// SetHedgePolicy creates or replaces the hedge policy of a model
+func (h *Handler) SetHedgePolicy(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req services.HedgePolicyUpdate
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	policy, err := h.hedgeService.SetPolicy(user.ID, &req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, policy)
+}
+
+// DeleteHedgePolicy removes a hedge policy
+func (h *Handler) DeleteHedgePolicy(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid policy ID")
+	}
+
+	if err := h.hedgeService.DeletePolicy(user.ID, uint(id)); err != nil {
+		if errors.Is(err, services.ErrHedgePolicyNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete hedge policy")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "hedge policy deleted"})
+}
+
+// hedgePolicyFor returns the hedge policy that applies to a request, or nil when
+// the request can't be hedged
+func (h *Handler) hedgePolicyFor(c echo.Context, model string, resolved *resolvedProvider) *database.HedgePolicy {
+	apiKey := middleware.GetAPIKey(c)
+	if apiKey == nil || resolved == nil || len(resolved.Candidates) < 2 {
+		return nil
+	}
+	return h.hedgeService.PolicyFor(apiKey.UserID, model)
+}
+
+// hedgeRace decides which attempt of a hedged request is sent to the client
+type hedgeRace struct {
+	out *echo.Response
+
+	mu       sync.Mutex
+	attempts []*hedgeAttempt
+	winner   *hedgeAttempt
+}
+
+// add registers an attempt, unless the race is already decided
+func (r *hedgeRace) add(a *hedgeAttempt) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.winner != nil {
+		return false
+	}
+	r.attempts = append(r.attempts, a)
+	return true
+}
+
+// claim makes a the winner if no attempt has won yet, cancelling the others
+func (r *hedgeRace) claim(a *hedgeAttempt) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.winner != nil {
+		return r.winner == a
+	}
+
+	r.winner = a
+	for _, other := range r.attempts {
+		if other != a {
+			other.lose()
+		}
+	}
+
+	header := r.out.Header()
+	for k, v := range a.header {
+		header[k] = v
+	}
+	if len(r.attempts) > 1 {
+		header.Set(HeaderHedgeWinner, a.label)
+	}
+	r.out.WriteHeader(a.status)
+	return true
+}
+
+// settled reports whether an attempt has won
+func (r *hedgeRace) settled() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.winner != nil
+}
+
+// won reports whether a is the winner
+func (r *hedgeRace) won(a *hedgeAttempt) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.winner == a
+}
+
+// hedgeAttempt is one upstream attempt of a hedged request. It is the response
+// writer of its own echo context: a successful response claims the client
+// connection, a server error is kept aside in case every attempt fails.
+type hedgeAttempt struct {
+	label  string
+	cfg    *database.ProviderConfig
+	ctx    echo.Context
+	cancel context.CancelFunc
+	race   *hedgeRace
+
+	header http.Header
+	status int
+	failed bytes.Buffer
+	err    error
+}
+
+// lose cancels the attempt's upstream request and stops it from recording usage
+func (a *hedgeAttempt) lose() {
+	a.ctx.Set(middleware.ContextKeyAPIKey, nil)
+	a.cancel()
+}
+
+func (a *hedgeAttempt) Header() http.Header {
+	return a.header
+}
+
+func (a *hedgeAttempt) WriteHeader(status int) {
+	if a.status != 0 {
+		return
+	}
+	a.status = status
+	if status < http.StatusInternalServerError {
+		a.race.claim(a)
+	}
+}
+
+func (a *hedgeAttempt) Write(b []byte) (int, error) {
+	if a.status == 0 {
+		a.WriteHeader(http.StatusOK)
+	}
+	if a.status >= http.StatusInternalServerError {
+		return a.failed.Write(b)
+	}
+	if !a.race.won(a) {
+		return 0, errHedgeLost
+	}
+	return a.race.out.Write(b)
+}
+
+func (a *hedgeAttempt) Flush() {
+	if a.status < http.StatusInternalServerError && a.race.won(a) {
+		a.race.out.Flush()
+	}
+}
+
+// startHedgeAttempt runs req against cfg on a copy of c whose output goes through the race
+func (h *Handler) startHedgeAttempt(c echo.Context, race *hedgeRace, label string, cfg *database.ProviderConfig, req *models.ChatCompletionRequest, done chan<- *hedgeAttempt) bool {
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	a := &hedgeAttempt{label: label, cfg: cfg, cancel: cancel, race: race, header: http.Header{}}
+	a.ctx = subContext(c, c.Request().WithContext(ctx), a)
+	a.ctx.Set(middleware.ContextKeyProviderConfig, cfg)
+
+	if !race.add(a) {
+		cancel()
+		return false
+	}
+
+	go func() {
+		defer cancel()
+		baseURL, apiKey, protocol, err := h.getCredentials(a.ctx, cfg.Provider, req.Model)
+		if err == nil {
+			err = h.dispatchChatCompletion(a.ctx, req, baseURL, apiKey, protocol)
+		} else {
+			err = echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		a.err = err
+		done <- a
+	}()
+	return true
+}
+
+// hedgedChatCompletion sends a chat completion to the first candidate config and,
+// if it hasn't sent first bytes within the policy's delay, a duplicate to the
+// second one. The first successful response is served and the other attempt is
+// cancelled. A hedge is only sent while the policy has hedge budget and the
+// secondary isn't failing health checks, so both upstreams are billed rarely.
+func (h *Handler) hedgedChatCompletion(c echo.Context, req *models.ChatCompletionRequest, policy *database.HedgePolicy, candidates []*database.ProviderConfig) error {
+	h.hedgeService.Earn(policy)
+
+	// The secondary attempt gets its own copy since handlers may modify the request
+	secondaryReq, err := cloneChatRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to copy request")
+	}
+
+	race := &hedgeRace{out: c.Response()}
+	done := make(chan *hedgeAttempt, 2)
+	h.startHedgeAttempt(c, race, "primary", candidates[0], req, done)
+	pending := 1
+
+	timer := time.NewTimer(time.Duration(policy.DelayMs) * time.Millisecond)
+	defer timer.Stop()
+	hedgeAfter := timer.C
+
+	var finished []*hedgeAttempt
+	for pending > 0 {
+		select {
+		case <-hedgeAfter:
+			hedgeAfter = nil
+			if race.settled() {
+				continue
+			}
+			secondary := candidates[1]
+			if health, ok := h.healthService.Get(secondary.ID); ok && !health.Healthy {
+				middleware.LogTrace(c, "Hedge", "Not hedging to unhealthy config ID=%d", secondary.ID)
+				continue
+			}
+			if !h.hedgeService.Spend(policy) {
+				middleware.LogTrace(c, "Hedge", "Hedge budget of policy %d exhausted", policy.ID)
+				continue
+			}
+			if h.startHedgeAttempt(c, race, "secondary", secondary, secondaryReq, done) {
+				middleware.LogTrace(c, "Hedge", "No first bytes after %dms; hedging to config ID=%d", policy.DelayMs, secondary.ID)
+				pending++
+			}
+		case a := <-done:
+			pending--
+			if race.won(a) {
+				middleware.LogTrace(c, "Hedge", "Served by %s config ID=%d", a.label, a.cfg.ID)
+				c.Set(middleware.ContextKeyProviderConfig, a.cfg)
+				return a.err
+			}
+			finished = append(finished, a)
+		}
+	}
+
+	// Every attempt failed: answer with the primary's failure
+	primary := finished[0]
+	for _, a := range finished {
+		if a.label == "primary" {
+			primary = a
+		}
+	}
+	c.Set(middleware.ContextKeyProviderConfig, primary.cfg)
+	if primary.status == 0 {
+		return primary.err
+	}
+	header := c.Response().Header()
+	for k, v := range primary.header {
+		header[k] = v
+	}
+	c.Response().WriteHeader(primary.status)
+	_, err = c.Response().Write(primary.failed.Bytes())
+	return err
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// delayedChatUpstream answers each chat completion with reply after delay,
+// noting the requests cancelled before then
+type delayedChatUpstream struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requests  int
+	cancelled int
+}
+
+func newDelayedChatUpstream(t *testing.T, delay time.Duration, reply string) *delayedChatUpstream {
+	u := &delayedChatUpstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices a client going away once the body is read
+		io.Copy(io.Discard, r.Body)
+		u.mu.Lock()
+		u.requests++
+		u.mu.Unlock()
+		select {
+		case <-time.After(delay):
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(reply))
+		case <-r.Context().Done():
+			u.mu.Lock()
+			u.cancelled++
+			u.mu.Unlock()
+		}
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// counts returns the requests the upstream received and how many of them were cancelled
+func (u *delayedChatUpstream) counts() (int, int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.requests, u.cancelled
+}
+
+// addProviderConfig adds a config serving gpt-4o from baseURL to apiKey
+func addProviderConfig(t *testing.T, db *gorm.DB, apiKey *database.APIKey, name, baseURL string) *database.ProviderConfig {
+	cfg := database.ProviderConfig{
+		UserID:       apiKey.UserID,
+		Provider:     "openai",
+		Protocol:     "openai_chat",
+		Name:         name,
+		BaseURL:      baseURL,
+		EncryptedKey: apiKey.ProviderConfigs[0].EncryptedKey,
+		ModelCodes:   `["gpt-4o"]`,
+		IsActive:     true,
+	}
+	if err := db.Create(&cfg).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(apiKey).Association("ProviderConfigs").Append(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	return &apiKey.ProviderConfigs[len(apiKey.ProviderConfigs)-1]
+}
+
+// hedgedTestHandler returns a handler and an API key whose gpt-4o requests go
+// to primary and are hedged to secondary after delayMs
+func hedgedTestHandler(t *testing.T, primary, secondary string, delayMs int) (*Handler, *database.APIKey, *gorm.DB) {
+	h, apiKey, db := chatTestHandler(t, primary, 0)
+	addProviderConfig(t, db, apiKey, "secondary", secondary)
+	policy := &database.HedgePolicy{UserID: apiKey.UserID, Model: "gpt-4o", DelayMs: delayMs, MaxHedgePercent: 100, IsActive: true}
+	if err := db.Create(policy).Error; err != nil {
+		t.Fatal(err)
+	}
+	return h, apiKey, db
+}
+
+func TestHedging_AttributesUsageToEndUser(t *testing.T) {
+	primary := newDelayedChatUpstream(t, time.Second, chatReply("slow", "stop", 10, 2))
+	secondary := newDelayedChatUpstream(t, 0, chatReply("fast", "stop", 10, 4))
+	h, apiKey, db := hedgedTestHandler(t, primary.URL, secondary.URL, 20)
+
+	header := http.Header{HeaderEndUser: {"customer-1"}}
+	rec := postChatWithHeader(h, apiKey, header, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderHedgeWinner) != "secondary" {
+		t.Fatalf("status %d, winner %q: %s", rec.Code, rec.Header().Get(HeaderHedgeWinner), rec.Body.String())
+	}
+
+	var endUser database.EndUser
+	if err := db.Where("api_key_id = ? AND external_id = ?", apiKey.ID, "customer-1").First(&endUser).Error; err != nil {
+		t.Fatal(err)
+	}
+	if endUser.DailyRequestsUsed != 1 || endUser.MonthlyRequestsUsed != 1 || endUser.DailyTokensUsed != 14 || endUser.MonthlyTokensUsed != 14 {
+		t.Errorf("end user counters = %+v, want the hedged request counted", endUser)
+	}
+	records := usageRecords(t, db, apiKey)
+	if len(records) != 1 || records[0].EndUserID == nil || *records[0].EndUserID != endUser.ID || records[0].Tags != "user:customer-1" {
+		t.Errorf("usage records = %+v, want one attributed to the end user", records)
+	}
+}
+
+// waitCancelled waits for the upstream to see n of its requests cancelled
+func waitCancelled(t *testing.T, u *delayedChatUpstream, n int) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, cancelled := u.counts()
+		if cancelled == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("upstream saw %d requests cancelled, want %d", cancelled, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHedging_FirstResponseWins(t *testing.T) {
+	tests := []struct {
+		name             string
+		primaryDelay     time.Duration
+		secondaryDelay   time.Duration
+		wantWinner       string
+		wantContent      string
+		wantTokens       int
+		wantSecondaryReq int
+	}{
+		{"secondary answers first", 5 * time.Second, 0, "secondary", "fast", 14, 1},
+		{"primary answers after the hedge", 100 * time.Millisecond, 5 * time.Second, "primary", "slow", 12, 1},
+		{"primary answers before the hedge delay", 0, 0, "", "slow", 12, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := newDelayedChatUpstream(t, tt.primaryDelay, chatReply("slow", "stop", 10, 2))
+			secondary := newDelayedChatUpstream(t, tt.secondaryDelay, chatReply("fast", "stop", 10, 4))
+			h, apiKey, db := hedgedTestHandler(t, primary.URL, secondary.URL, 50)
+
+			rec := postChat(h, apiKey, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get(HeaderHedgeWinner); got != tt.wantWinner {
+				t.Errorf("%s = %q, want %q", HeaderHedgeWinner, got, tt.wantWinner)
+			}
+			if !strings.Contains(rec.Body.String(), `"content":"`+tt.wantContent+`"`) {
+				t.Errorf("response = %s, want the %q reply", rec.Body.String(), tt.wantContent)
+			}
+
+			// The losing attempt's upstream request is cancelled
+			switch tt.wantWinner {
+			case "primary":
+				waitCancelled(t, secondary, 1)
+			case "secondary":
+				waitCancelled(t, primary, 1)
+			}
+			if n, _ := primary.counts(); n != 1 {
+				t.Errorf("primary received %d requests, want 1", n)
+			}
+			if n, _ := secondary.counts(); n != tt.wantSecondaryReq {
+				t.Errorf("secondary received %d requests, want %d", n, tt.wantSecondaryReq)
+			}
+
+			// Only the served attempt is recorded
+			records := usageRecords(t, db, apiKey)
+			if len(records) != 1 || records[0].TotalTokens != tt.wantTokens {
+				t.Errorf("usage records = %+v, want one of %d tokens", records, tt.wantTokens)
+			}
+		})
+	}
+}
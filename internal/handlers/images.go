@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"ai_gateway/internal/converters"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderImageAdjustments reports, one value per image, how inline images were
+// changed to fit what the upstream accepts
+const HeaderImageAdjustments = "X-Image-Adjustments"
+
+// inlineGeminiImageURLs downloads the https fileData parts of a Gemini request
+// converted from OpenAI and replaces them with inlineData, when
+// IMAGE_FETCH_URLS is enabled. Downloads go through the guarded fetcher.
+func (h *Handler) inlineGeminiImageURLs(ctx context.Context, req *models.GenerateContentRequest) error {
+	if !h.imageFetcher.Enabled() {
+		return nil
+	}
+	_, err := converters.InlineGeminiImageURLs(req, h.imageURLFetcher(ctx, h.imageFetcher.MaxBytes()))
+	return err
+}
+
+// imageURLFetcher returns a fetcher downloading the image URLs of one request
+// within a byte budget
+func (h *Handler) imageURLFetcher(ctx context.Context, budget int64) converters.ImageURLFetcher {
+	return func(rawURL string) (string, []byte, error) {
+		image, err := h.imageFetcher.Fetch(ctx, rawURL, budget)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to fetch image %s: %w", rawURL, err)
+		}
+		budget -= int64(len(image.Data))
+		return image.MimeType, image.Data, nil
+	}
+}
+
+// inlineImageURLs downloads the image URLs of a request and sends them inline
+// when IMAGE_FETCH_URLS is enabled and the upstream protocol cannot fetch them
+func (h *Handler) inlineImageURLs(c echo.Context, protocol string, inline func(converters.ImageURLFetcher) (int, error)) error {
+	if !h.imageFetcher.Enabled() || converters.FetchesImageURLs(protocol) {
+		return nil
+	}
+	inlined, err := inline(h.imageURLFetcher(c.Request().Context(), h.imageFetcher.MaxBytes()))
+	if err != nil {
+		middleware.LogTrace(c, "Images", "Rejecting request: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if inlined > 0 {
+		middleware.LogTrace(c, "Images", "Inlined %d image URLs for %s", inlined, protocol)
+	}
+	return nil
+}
+
+// imageOptions returns the gateway's rules for inline images
+func (h *Handler) imageOptions() converters.ImageOptions {
+	return converters.ImageOptions{MaxBytes: h.cfg.ImageMaxBytes, Transcode: h.cfg.ImageTranscode}
+}
+
+// fitInlineImages inlines the image URLs of a chat completions, Responses or
+// Anthropic request for upstreams that need it, then checks its base64 images
+// against the gateway's cap and the limits of protocol, transcoding them when
+// IMAGE_TRANSCODE is enabled; values are the message contents or the
+// Responses input
+func (h *Handler) fitInlineImages(c echo.Context, protocol string, values []interface{}) error {
+	err := h.inlineImageURLs(c, protocol, func(fetch converters.ImageURLFetcher) (int, error) {
+		return converters.InlineImageURLs(values, fetch)
+	})
+	if err != nil {
+		return err
+	}
+	notes, err := converters.FitInlineImages(values, protocol, h.imageOptions())
+	return h.imageFitResult(c, notes, err)
+}
+
+// fitGeminiInlineImages is fitInlineImages for a Gemini request
+func (h *Handler) fitGeminiInlineImages(c echo.Context, protocol string, req *models.GenerateContentRequest) error {
+	err := h.inlineImageURLs(c, protocol, func(fetch converters.ImageURLFetcher) (int, error) {
+		return converters.InlineGeminiImageURLs(req, fetch)
+	})
+	if err != nil {
+		return err
+	}
+	notes, err := converters.FitGeminiInlineImages(req, protocol, h.imageOptions())
+	return h.imageFitResult(c, notes, err)
+}
+
+// imageFitResult rejects a request with an image that does not fit, or
+// surfaces the changes made to its images in debug headers
+func (h *Handler) imageFitResult(c echo.Context, notes []string, err error) error {
+	if err != nil {
+		middleware.LogTrace(c, "Images", "Rejecting request: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	for _, note := range notes {
+		middleware.LogTrace(c, "Images", "Adjusted %s", note)
+		c.Response().Header().Add(HeaderImageAdjustments, note)
+	}
+	return nil
+}
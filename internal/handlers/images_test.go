@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/models"
+	"ai_gateway/internal/services"
+)
+
+func imageFetchHandler(enabled bool, allowedHosts ...string) *Handler {
+	cfg := &config.Config{
+		ImageFetchURLs:         enabled,
+		ImageFetchAllowedHosts: allowedHosts,
+		ImageFetchMaxBytes:     1 << 20,
+		ImageFetchTimeout:      2000,
+	}
+	return &Handler{cfg: cfg, imageFetcher: services.NewImageFetchService(cfg)}
+}
+
+func geminiImageRequest(fileURI string) *models.GenerateContentRequest {
+	return &models.GenerateContentRequest{Contents: []models.GeminiContent{{
+		Role:  "user",
+		Parts: []models.GeminiPart{{FileData: &models.FileData{MimeType: "image/png", FileURI: fileURI}}},
+	}}}
+}
+
+func TestInlineGeminiImageURLs_RefusesUnsafeHosts(t *testing.T) {
+	var fetched bool
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	localURL := "https://localhost:" + serverURL.Port() + "/cat.png"
+
+	tests := []struct {
+		name    string
+		handler *Handler
+		uri     string
+		wantErr string
+	}{
+		{"loopback address", imageFetchHandler(true), server.URL + "/cat.png", "private address"},
+		{"allowlisted host resolving to loopback", imageFetchHandler(true, "localhost"), localURL, "private address"},
+		{"host not on the allowlist", imageFetchHandler(true, "*.example.com"), localURL, "host localhost is not allowed"},
+		{"plain http", imageFetchHandler(true), "http://169.254.169.254/latest/meta-data", ""},
+		{"fetching disabled", imageFetchHandler(false), server.URL + "/cat.png", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := geminiImageRequest(tt.uri)
+			err := tt.handler.inlineGeminiImageURLs(context.Background(), req)
+			part := req.Contents[0].Parts[0]
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+			if part.InlineData != nil || part.FileData == nil || part.FileData.FileURI != tt.uri {
+				t.Fatalf("image part should be left as a file reference: %+v", part)
+			}
+		})
+	}
+	if fetched {
+		t.Fatal("the gateway connected to a refused host")
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderTotalCount carries how many items match a list query, of which the
+// response body is a page
+const HeaderTotalCount = "X-Total-Count"
+
+// maxListLimit bounds the page size of list endpoints
+const maxListLimit = 1000
+
+// listQuery parses the query parameters of list endpoints: limit and offset, q
+// (search), status, and since and until (RFC 3339 creation times). Without a
+// limit, defaultLimit items are returned, all of them when it is 0.
+func listQuery(c echo.Context, defaultLimit int) (*services.ListQuery, error) {
+	q := &services.ListQuery{
+		Limit:  defaultLimit,
+		Search: c.QueryParam("q"),
+		Status: c.QueryParam("status"),
+	}
+	if raw := c.QueryParam("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+		}
+		q.Limit = limit
+	}
+	if raw := c.QueryParam("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "offset must not be negative")
+		}
+		q.Offset = offset
+	}
+	for name, target := range map[string]**time.Time{"since": &q.Since, "until": &q.Until} {
+		if raw := c.QueryParam(name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be an RFC 3339 time", name))
+			}
+			*target = &t
+		}
+	}
+	return q, nil
+}
+
+// setListTotal reports how many items match a list query
+func setListTotal(c echo.Context, total int64) {
+	c.Response().Header().Set(HeaderTotalCount, strconv.FormatInt(total, 10))
+}
+
+// listError maps the errors of list queries to HTTP errors
+func listError(err error) error {
+	if errors.Is(err, services.ErrInvalidListStatus) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MaintenanceService returns the service of gateway and provider maintenance windows
+func (h *Handler) MaintenanceService() *services.MaintenanceService {
+	return h.maintenanceService
+}
+
+// ListMaintenance lists the current maintenance windows (admin only)
+func (h *Handler) ListMaintenance(c echo.Context) error {
+	windows, err := h.maintenanceService.List()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get maintenance windows")
+	}
+	return c.JSON(http.StatusOK, windows)
+}
+
+// SetMaintenance puts the whole gateway into maintenance (admin only)
+func (h *Handler) SetMaintenance(c echo.Context) error {
+	admin := middleware.GetUser(c)
+	if admin == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req services.MaintenanceRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	window, err := h.maintenanceService.SetGateway(admin.ID, &req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, window)
+}
+
+// ClearMaintenance ends the maintenance of the whole gateway (admin only)
+func (h *Handler) ClearMaintenance(c echo.Context) error {
+	return maintenanceCleared(c, h.maintenanceService.ClearGateway())
+}
+
+// PauseProviderConfig pauses a provider config for maintenance (admin only)
+func (h *Handler) PauseProviderConfig(c echo.Context) error {
+	admin := middleware.GetUser(c)
+	if admin == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid config ID")
+	}
+
+	var req services.MaintenanceRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	window, err := h.maintenanceService.PauseProviderConfig(admin.ID, uint(id), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrProviderConfigNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, window)
+}
+
+// ResumeProviderConfig puts a paused provider config back into service (admin only)
+func (h *Handler) ResumeProviderConfig(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid config ID")
+	}
+	return maintenanceCleared(c, h.maintenanceService.ResumeProviderConfig(uint(id)))
+}
+
+// maintenanceCleared answers a request ending a maintenance window
+func maintenanceCleared(c echo.Context, err error) error {
+	if errors.Is(err, services.ErrMaintenanceNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to end maintenance")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
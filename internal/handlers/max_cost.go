@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderMaxCost sets the most a single request may cost, in credits at the
+// model prices. OpenAI-format requests may send it as the max_cost field too.
+const HeaderMaxCost = "X-Max-Cost"
+
+// applyMaxCost fits the max tokens of a request for the upstream model to its
+// cost ceiling: the lower of the X-Max-Cost header and bodyMaxCost. The
+// completion may cost what the estimated prompt leaves of the ceiling, so max
+// tokens are lowered to fit, or set when the client sent none. A request
+// whose prompt alone leaves no room is refused. Requests for unpriced models
+// are left alone.
+func (h *Handler) applyMaxCost(c echo.Context, model string, bodyMaxCost *float64, maxTokens *int) (*int, error) {
+	maxCost, err := requestMaxCost(c, bodyMaxCost)
+	if err != nil || maxCost == 0 {
+		return maxTokens, err
+	}
+
+	price, err := h.creditService.PriceOf(model)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get model price")
+	}
+	if price == nil {
+		middleware.LogTrace(c, "MaxCost", "Model %s is unpriced; ignoring max cost %g", model, maxCost)
+		return maxTokens, nil
+	}
+
+	promptTokens, _ := promptEstimate(c)
+	promptMicros := float64(promptTokens) * price.InputPerMillion
+	remaining := maxCost*services.MicrosPerCredit - promptMicros
+	limit := math.MaxInt32
+	if price.OutputPerMillion > 0 {
+		limit = int(math.Min(remaining/price.OutputPerMillion, math.MaxInt32))
+	}
+	if remaining < 0 || limit < 1 {
+		middleware.LogTrace(c, "MaxCost", "Refusing request: prompt of ~%d tokens costs %.6f of max cost %g", promptTokens, promptMicros/services.MicrosPerCredit, maxCost)
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf(
+			"the estimated prompt cost of %.6f credits leaves no room for a completion within the max cost of %g credits",
+			promptMicros/services.MicrosPerCredit, maxCost))
+	}
+	if price.OutputPerMillion == 0 {
+		return maxTokens, nil
+	}
+
+	if maxTokens == nil {
+		middleware.LogTrace(c, "MaxCost", "Setting max tokens to %d for max cost %g", limit, maxCost)
+		return &limit, nil
+	}
+	if *maxTokens > limit {
+		middleware.LogTrace(c, "MaxCost", "Clamping max tokens %d to %d for max cost %g", *maxTokens, limit, maxCost)
+		c.Response().Header().Set(HeaderMaxTokensClamped, fmt.Sprintf("%d; limit=%d", *maxTokens, limit))
+		return &limit, nil
+	}
+	return maxTokens, nil
+}
+
+// hasMaxCost reports whether a request sets a cost ceiling. The ceiling bounds
+// a single upstream call, so paths sending several calls per request refuse it.
+func hasMaxCost(c echo.Context, bodyMaxCost *float64) bool {
+	return bodyMaxCost != nil || c.Request().Header.Get(HeaderMaxCost) != ""
+}
+
+// requestMaxCost returns the cost ceiling of a request in credits, or 0 for none
+func requestMaxCost(c echo.Context, bodyMaxCost *float64) (float64, error) {
+	maxCost := 0.0
+	if header := strings.TrimSpace(c.Request().Header.Get(HeaderMaxCost)); header != "" {
+		value, err := strconv.ParseFloat(header, 64)
+		if err != nil || !(value > 0) || math.IsInf(value, 0) {
+			return 0, echo.NewHTTPError(http.StatusBadRequest, HeaderMaxCost+" must be a positive number of credits")
+		}
+		maxCost = value
+	}
+	if bodyMaxCost != nil {
+		value := *bodyMaxCost
+		if !(value > 0) || math.IsInf(value, 0) {
+			return 0, echo.NewHTTPError(http.StatusBadRequest, "max_cost must be a positive number of credits")
+		}
+		if maxCost == 0 || value < maxCost {
+			maxCost = value
+		}
+	}
+	return maxCost, nil
+}
+
+// applyResponsesMaxCost applies the cost ceiling of a Responses API request to
+// its max_output_tokens, taking the max_cost extension field out of the body
+func (h *Handler) applyResponsesMaxCost(c echo.Context, model string, reqBody map[string]interface{}) error {
+	var bodyMaxCost *float64
+	if value, ok := reqBody["max_cost"]; ok {
+		delete(reqBody, "max_cost")
+		number, ok := value.(float64)
+		if !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, "max_cost must be a positive number of credits")
+		}
+		bodyMaxCost = &number
+	}
+
+	var maxTokens *int
+	if value, ok := reqBody["max_output_tokens"].(float64); ok {
+		requested := int(value)
+		maxTokens = &requested
+	}
+	limit, err := h.applyMaxCost(c, model, bodyMaxCost, maxTokens)
+	if err != nil {
+		return err
+	}
+	if limit != maxTokens {
+		reqBody["max_output_tokens"] = *limit
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMaxCost_RefusedForMultiCallPaths(t *testing.T) {
+	maxCostHeader := http.Header{HeaderMaxCost: {"0.5"}}
+	chatBody := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+
+	t.Run("hedging", func(t *testing.T) {
+		primary := newDelayedChatUpstream(t, 0, chatReply("a", "stop", 10, 2))
+		secondary := newDelayedChatUpstream(t, 0, chatReply("b", "stop", 10, 2))
+		h, apiKey, _ := hedgedTestHandler(t, primary.URL, secondary.URL, 0)
+
+		for _, tc := range []struct {
+			header http.Header
+			body   string
+		}{
+			{maxCostHeader, chatBody},
+			{http.Header{}, `{"model":"gpt-4o","max_cost":0.5,"messages":[{"role":"user","content":"hi"}]}`},
+		} {
+			rec := postChatWithHeader(h, apiKey, tc.header, tc.body)
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "hedged model") {
+				t.Errorf("status %d: %s, want max cost refused for the hedged model", rec.Code, rec.Body.String())
+			}
+		}
+		if n, _ := primary.counts(); n != 0 {
+			t.Errorf("primary received %d requests, want none", n)
+		}
+		if n, _ := secondary.counts(); n != 0 {
+			t.Errorf("secondary received %d requests, want none", n)
+		}
+	})
+
+	t.Run("gateway-executed tools", func(t *testing.T) {
+		mcpServer := newMCPToolServer(t, "mcp-token")
+		upstream := newChatUpstream(t)
+		h, apiKey, _ := chatTestHandler(t, upstream.URL, 0)
+		rec := serveJSON(mcpRouter(h, &apiKey.User), http.MethodPost, "/api/mcp/servers", `{"name":"docs","url":"`+mcpServer.URL+`","token":"mcp-token"}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create: %d %s", rec.Code, rec.Body.String())
+		}
+
+		rec = postChatWithHeader(h, apiKey, maxCostHeader, chatBody)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "gateway-executed tools") {
+			t.Errorf("status %d: %s, want max cost refused with gateway-executed tools", rec.Code, rec.Body.String())
+		}
+		if n := len(upstream.received()); n != 0 {
+			t.Errorf("upstream received %d requests, want none", n)
+		}
+	})
+
+	t.Run("schema repair", func(t *testing.T) {
+		upstream := newChatUpstream(t)
+		h, apiKey, _ := chatTestHandler(t, upstream.URL, 0)
+		apiKey.SchemaRepairRetries = 2
+
+		rec := postChatWithHeader(h, apiKey, maxCostHeader, `{"model":"gpt-4o","response_format":{"type":"json_schema","json_schema":{"name":"answer","schema":{"type":"object"}}},"messages":[{"role":"user","content":"hi"}]}`)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "schema repair") {
+			t.Errorf("status %d: %s, want max cost refused with schema repair", rec.Code, rec.Body.String())
+		}
+		if n := len(upstream.received()); n != 0 {
+			t.Errorf("upstream received %d requests, want none", n)
+		}
+	})
+
+	t.Run("single call", func(t *testing.T) {
+		upstream := newChatUpstream(t, chatReply("Hello", "length", 10, 5))
+		h, apiKey, _ := chatTestHandler(t, upstream.URL, 100)
+
+		// Auto-continue is skipped, so the request makes exactly one upstream call
+		rec := postChatWithHeader(h, apiKey, http.Header{}, `{"model":"gpt-4o","max_cost":0.5,"max_tokens":5,"messages":[{"role":"user","content":"hi"}]}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		if n := len(upstream.received()); n != 1 {
+			t.Errorf("upstream received %d requests, want 1", n)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"fmt"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/models"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderMaxTokensClamped warns that a request's max tokens exceeded the
+// output ceiling of its model and were lowered to it
+const HeaderMaxTokensClamped = "X-Max-Tokens-Clamped"
+
+// applyAnthropicMaxTokens fits max_tokens of a request bound for Anthropic to
+// the output ceiling of its model. Anthropic requires max_tokens, so when the
+// client set none (requested is false) the ceiling replaces the converter's
+// default; larger values are clamped to it. Models without a known ceiling
+// are left alone.
+func applyAnthropicMaxTokens(c echo.Context, req *models.MessagesRequest, requested bool) {
+	ceiling := services.MaxOutputTokens(middleware.GetProviderConfig(c), req.Model)
+	if ceiling <= 0 {
+		return
+	}
+	if !requested {
+		req.MaxTokens = ceiling
+		return
+	}
+	if req.MaxTokens > ceiling {
+		middleware.LogTrace(c, "MaxTokens", "Clamping max_tokens %d to %d for model %s", req.MaxTokens, ceiling, req.Model)
+		c.Response().Header().Set(HeaderMaxTokensClamped, fmt.Sprintf("%d; limit=%d", req.MaxTokens, ceiling))
+		req.MaxTokens = ceiling
+	}
+}
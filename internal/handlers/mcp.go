@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/models"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// mcpError maps MCP service errors to HTTP errors
+func mcpError(err error) error {
+	if errors.Is(err, services.ErrMCPServerNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+}
+
+// ListMCPServers lists the user's MCP servers
+func (h *Handler) ListMCPServers(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	servers, err := h.mcpService.ListServers(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get MCP servers")
+	}
+	return c.JSON(http.StatusOK, servers)
+}
+
+// CreateMCPServer adds an MCP server whose tools are offered to the user's models
+func (h *Handler) CreateMCPServer(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req services.MCPServerRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	server, err := h.mcpService.CreateServer(user.ID, &req)
+	if err != nil {
+		return mcpError(err)
+	}
+	return c.JSON(http.StatusCreated, server)
+}
+
+// UpdateMCPServer changes an MCP server
+func (h *Handler) UpdateMCPServer(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid server ID")
+	}
+
+	var req services.MCPServerRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	server, err := h.mcpService.UpdateServer(user.ID, uint(id), &req)
+	if err != nil {
+		return mcpError(err)
+	}
+	return c.JSON(http.StatusOK, server)
+}
+
+// DeleteMCPServer removes an MCP server
+func (h *Handler) DeleteMCPServer(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid server ID")
+	}
+
+	if err := h.mcpService.DeleteServer(user.ID, uint(id)); err != nil {
+		return mcpError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "MCP server deleted"})
+}
+
+// ListMCPServerTools connects to an MCP server and lists its tools
+func (h *Handler) ListMCPServerTools(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid server ID")
+	}
+
+	tools, err := h.mcpService.ServerTools(c.Request().Context(), user.ID, uint(id))
+	if err != nil {
+		if errors.Is(err, services.ErrMCPServerNotFound) {
+			return mcpError(err)
+		}
+		return echo.NewHTTPError(http.StatusBadGateway, "failed to list tools: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, tools)
+}
+
+// gatewayUserID returns the user a gateway request is made on behalf of
+func gatewayUserID(c echo.Context) uint {
+	if apiKey := middleware.GetAPIKey(c); apiKey != nil {
+		return apiKey.UserID
+	}
+	if user := middleware.GetUser(c); user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+// mcpServerTools returns the tools of the user's active MCP servers
+func (h *Handler) mcpServerTools(c echo.Context) []*serverTool {
+	userID := gatewayUserID(c)
+	if userID == 0 {
+		return nil
+	}
+
+	var tools []*serverTool
+	for _, tool := range h.mcpService.Tools(c.Request().Context(), userID) {
+		tool := tool
+		var params map[string]interface{}
+		if len(tool.InputSchema) == 0 || json.Unmarshal(tool.InputSchema, &params) != nil {
+			params = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		tools = append(tools, &serverTool{
+			definition: models.Tool{
+				Type: "function",
+				Function: models.Function{
+					Name:        tool.Name,
+					Description: tool.Description,
+					Parameters:  params,
+				},
+			},
+			run: func(ctx context.Context, arguments string) (string, error) {
+				return h.mcpService.CallTool(ctx, userID, &tool, arguments)
+			},
+		})
+	}
+	return tools
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// mcpToolServer is an MCP server over Streamable HTTP offering a lookup tool
+// to clients sending its bearer token
+type mcpToolServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	calls []map[string]interface{} // tools/call params
+}
+
+func newMCPToolServer(t *testing.T, token string) *mcpToolServer {
+	s := &mcpToolServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var msg struct {
+			ID     json.RawMessage        `json:"id"`
+			Method string                 `json:"method"`
+			Params map[string]interface{} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&msg)
+
+		var result string
+		switch msg.Method {
+		case "initialize":
+			w.Header().Set("Mcp-Session-Id", "session-1")
+			result = `{"protocolVersion":"2025-03-26","capabilities":{"tools":{}},"serverInfo":{"name":"docs","version":"1"}}`
+		case "notifications/initialized":
+			w.WriteHeader(http.StatusAccepted)
+			return
+		case "tools/list":
+			result = `{"tools":[{"name":"lookup","description":"Look up documentation","inputSchema":{"type":"object","properties":{"q":{"type":"string"}}}},{"name":"get.time","description":"Current time"}]}`
+		case "tools/call":
+			s.mu.Lock()
+			s.calls = append(s.calls, msg.Params)
+			s.mu.Unlock()
+			args, _ := msg.Params["arguments"].(map[string]interface{})
+			result = fmt.Sprintf(`{"content":[{"type":"text","text":"docs for %v"}]}`, args["q"])
+		default:
+			http.Error(w, "unknown method", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Mcp-Session-Id") == "" && msg.Method != "initialize" {
+			http.Error(w, "missing session", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":%s}`, msg.ID, result)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// mcpRouter routes the MCP server management endpoints of h, authenticating
+// requests as user
+func mcpRouter(h *Handler, user *database.User) *echo.Echo {
+	setUser := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if user != nil {
+				c.Set(middleware.ContextKeyUser, user)
+			}
+			return next(c)
+		}
+	}
+	e := echo.New()
+	mcp := e.Group("/api/mcp", setUser)
+	mcp.GET("/servers", h.ListMCPServers)
+	mcp.POST("/servers", h.CreateMCPServer)
+	mcp.PUT("/servers/:id", h.UpdateMCPServer)
+	mcp.DELETE("/servers/:id", h.DeleteMCPServer)
+	mcp.GET("/servers/:id/tools", h.ListMCPServerTools)
+	return e
+}
+
+func serveJSON(e *echo.Echo, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMCPServers_Management(t *testing.T) {
+	mcpServer := newMCPToolServer(t, "mcp-token")
+	h, apiKey, _ := chatTestHandler(t, "http://upstream.invalid", 0)
+	e := mcpRouter(h, &apiKey.User)
+
+	if rec := serveJSON(mcpRouter(h, nil), http.MethodGet, "/api/mcp/servers", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated list: %d", rec.Code)
+	}
+	for _, body := range []string{
+		`{"name":"bad name","url":"` + mcpServer.URL + `"}`,
+		`{"name":"docs","url":"ftp://docs.example.com"}`,
+		`{"name":`,
+	} {
+		if rec := serveJSON(e, http.MethodPost, "/api/mcp/servers", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("create %s: %d %s", body, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := serveJSON(e, http.MethodPost, "/api/mcp/servers", `{"name":"docs","url":"`+mcpServer.URL+`","token":"mcp-token"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "mcp-token") {
+		t.Errorf("the token must not be returned: %s", rec.Body.String())
+	}
+	var server database.MCPServer
+	json.Unmarshal(rec.Body.Bytes(), &server)
+	serverPath := fmt.Sprintf("/api/mcp/servers/%d", server.ID)
+
+	rec = serveJSON(e, http.MethodGet, serverPath+"/tools", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list tools: %d %s", rec.Code, rec.Body.String())
+	}
+	var tools []struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		InputSchema json.RawMessage `json:"input_schema"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &tools)
+	if len(tools) != 2 || tools[0].Name != "docs__lookup" || tools[1].Name != "docs__get_time" || len(tools[0].InputSchema) == 0 {
+		t.Errorf("tools = %s", rec.Body.String())
+	}
+
+	// Another user sees none of the servers
+	other := &database.User{ID: apiKey.UserID + 1}
+	if rec := serveJSON(mcpRouter(h, other), http.MethodGet, serverPath+"/tools", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's tools: %d", rec.Code)
+	}
+	if rec := serveJSON(mcpRouter(h, other), http.MethodDelete, serverPath, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's delete: %d", rec.Code)
+	}
+	if rec := serveJSON(mcpRouter(h, other), http.MethodGet, "/api/mcp/servers", ""); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("another user's list: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Dropping the token makes the server refuse the gateway
+	rec = serveJSON(e, http.MethodPut, serverPath, `{"name":"docs","url":"`+mcpServer.URL+`","token":""}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", rec.Code, rec.Body.String())
+	}
+	rec = serveJSON(e, http.MethodGet, serverPath+"/tools", "")
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "status 401") {
+		t.Errorf("tools of a server refusing the gateway: %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := serveJSON(e, http.MethodPut, "/api/mcp/servers/abc", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("update with an invalid ID: %d", rec.Code)
+	}
+	if rec := serveJSON(e, http.MethodDelete, serverPath, ""); rec.Code != http.StatusOK {
+		t.Errorf("delete: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := serveJSON(e, http.MethodDelete, serverPath, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("delete of a deleted server: %d", rec.Code)
+	}
+}
+
+func TestMCPServers_ToolsOfferedToChatCompletions(t *testing.T) {
+	mcpServer := newMCPToolServer(t, "mcp-token")
+	upstream := newChatUpstream(t,
+		`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"docs__lookup","arguments":"{\"q\":\"echo\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`,
+		chatReply("Echo is a web framework.", "stop", 30, 6))
+	h, apiKey, _ := chatTestHandler(t, upstream.URL, 0)
+	rec := serveJSON(mcpRouter(h, &apiKey.User), http.MethodPost, "/api/mcp/servers", `{"name":"docs","url":"`+mcpServer.URL+`","token":"mcp-token"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = postChat(h, apiKey, `{"model":"gpt-4o","messages":[{"role":"user","content":"What is echo?"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "Echo is a web framework.") {
+		t.Errorf("response = %s", rec.Body.String())
+	}
+
+	requests := upstream.received()
+	if len(requests) != 2 {
+		t.Fatalf("upstream received %d requests, want 2", len(requests))
+	}
+	offered, _ := json.Marshal(requests[0]["tools"])
+	if !strings.Contains(string(offered), `"name":"docs__lookup"`) || !strings.Contains(string(offered), `"name":"docs__get_time"`) {
+		t.Errorf("offered tools = %s", offered)
+	}
+	messages, _ := json.Marshal(requests[1]["messages"])
+	if !strings.Contains(string(messages), `"tool_call_id":"call_1"`) || !strings.Contains(string(messages), "docs for echo") {
+		t.Errorf("follow-up messages = %s, want the MCP tool result", messages)
+	}
+
+	mcpServer.mu.Lock()
+	defer mcpServer.mu.Unlock()
+	if len(mcpServer.calls) != 1 || mcpServer.calls[0]["name"] != "lookup" {
+		t.Errorf("MCP tool calls = %v, want lookup called under its own name", mcpServer.calls)
+	}
+}
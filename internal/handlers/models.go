@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"net/http"
+
+	"ai_gateway/internal/converters"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ModelCapabilities lists the request features a model supports through the
+// gateway. Tools and vision are omitted for models the gateway does not know.
+type ModelCapabilities struct {
+	LogitBias bool  `json:"logit_bias"`
+	JSON      bool  `json:"json"` // response_format, emulated for upstreams without a JSON mode
+	Tools     *bool `json:"tools,omitempty"`
+	Vision    *bool `json:"vision,omitempty"`
+}
+
+// ModelObject is an entry of the model list, in OpenAI's format
+type ModelObject struct {
+	ID           string            `json:"id"`
+	Object       string            `json:"object"`
+	Created      int64             `json:"created"`
+	OwnedBy      string            `json:"owned_by"`
+	Capabilities ModelCapabilities `json:"capabilities"`
+}
+
+// ModelBacking is the provider config and upstream model a model name resolves to
+type ModelBacking struct {
+	Provider       string `json:"provider"`
+	ProviderConfig string `json:"provider_config"`
+	Protocol       string `json:"protocol"`
+	Model          string `json:"model"`   // requested upstream
+	Matched        bool   `json:"matched"` // false when the config's default model stands in for an unknown name
+}
+
+// ModelPricing is the credit cost of a model's tokens
+type ModelPricing struct {
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+}
+
+// ModelDetail describes what a model name resolves to for the caller
+type ModelDetail struct {
+	ModelObject
+	ContextWindow   int           `json:"context_window,omitempty"`    // tokens, omitted when unknown
+	MaxOutputTokens int           `json:"max_output_tokens,omitempty"` // tokens, omitted when unknown
+	Backing         ModelBacking  `json:"backing"`
+	Pricing         *ModelPricing `json:"pricing"` // nil for unpriced models
+}
+
+// modelCapabilities returns the capabilities of a model served over protocol
+func modelCapabilities(model, protocol string) ModelCapabilities {
+	capabilities := ModelCapabilities{
+		LogitBias: converters.SupportsLogitBias(protocol),
+		JSON:      true,
+	}
+	if spec, ok := services.LookupModelSpec(model); ok {
+		capabilities.Tools = &spec.Tools
+		capabilities.Vision = &spec.Vision
+	}
+	return capabilities
+}
+
+// ListModels handles GET /v1/models - lists the models the caller can request,
+// with the features each supports. A model served by several configs supports
+// logit_bias only when all of them do, as any of them may serve a request.
+func (h *Handler) ListModels(c echo.Context) error {
+	var configs []database.ProviderConfig
+	if apiKey := middleware.GetAPIKey(c); apiKey != nil {
+		configs = apiKey.ProviderConfigs
+	} else if user := middleware.GetUser(c); user != nil {
+		var err error
+		if configs, err = h.configService.GetConfigs(user.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to load provider configs")
+		}
+	}
+
+	scope := middleware.GetTokenScope(c)
+	data := []*ModelObject{}
+	byID := make(map[string]*ModelObject)
+	for i := range configs {
+		cfg := &configs[i]
+		if !cfg.IsActive {
+			continue
+		}
+		modelCodes, err := h.configService.GetModelCodes(cfg)
+		if err != nil {
+			middleware.LogTrace(c, "ListModels", "Failed to get model codes for config %d: %v", cfg.ID, err)
+			continue
+		}
+		protocol := normalizeProtocol(cfg.Protocol)
+		for _, code := range modelCodes {
+			if !scope.AllowsModel(code) {
+				continue
+			}
+			if model, ok := byID[code]; ok {
+				model.Capabilities.LogitBias = model.Capabilities.LogitBias && converters.SupportsLogitBias(protocol)
+				continue
+			}
+			model := &ModelObject{
+				ID:           code,
+				Object:       "model",
+				Created:      cfg.CreatedAt.Unix(),
+				OwnedBy:      cfg.Provider,
+				Capabilities: modelCapabilities(code, protocol),
+			}
+			byID[code] = model
+			data = append(data, model)
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// GetModel handles GET /v1/models/:model - resolves a model name, alias or
+// routed name as a request for it would be, and describes the backing config
+// and upstream model with their capabilities, context window and pricing
+func (h *Handler) GetModel(c echo.Context) error {
+	model := c.Param("model")
+	resolved, err := h.resolveProviderForAPIKey(c, model)
+	if err != nil {
+		return resolveProviderError(err)
+	}
+	if resolved == nil || resolved.Config == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "model not found")
+	}
+
+	cfg := resolved.Config
+	protocol := normalizeProtocol(cfg.Protocol)
+	detail := ModelDetail{
+		ModelObject: ModelObject{
+			ID:           model,
+			Object:       "model",
+			Created:      cfg.CreatedAt.Unix(),
+			OwnedBy:      cfg.Provider,
+			Capabilities: modelCapabilities(resolved.Model, protocol),
+		},
+		Backing: ModelBacking{
+			Provider:       cfg.Provider,
+			ProviderConfig: cfg.Name,
+			Protocol:       protocol,
+			Model:          resolved.Model,
+			Matched:        resolved.Matched,
+		},
+	}
+	if spec, ok := services.LookupModelSpec(resolved.Model); ok {
+		detail.ContextWindow = spec.ContextWindow
+	}
+	detail.MaxOutputTokens = services.MaxOutputTokens(cfg, resolved.Model)
+
+	// Usage is charged at the price of the upstream model
+	price, err := h.creditService.PriceOf(resolved.Model)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to load model price")
+	}
+	if price != nil {
+		detail.Pricing = &ModelPricing{InputPerMillion: price.InputPerMillion, OutputPerMillion: price.OutputPerMillion}
+	}
+
+	return c.JSON(http.StatusOK, detail)
+}
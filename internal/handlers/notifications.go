@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"ai_gateway/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// NotificationPreferencesRequest represents a notification preferences update
+type NotificationPreferencesRequest struct {
+	WeeklyDigest *bool `json:"weekly_digest"`
+}
+
+// GetNotificationPreferences returns the current user's notification preferences
+func (h *Handler) GetNotificationPreferences(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	pref, err := h.digestService.GetPreferences(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, pref)
+}
+
+// UpdateNotificationPreferences updates the current user's notification preferences
+func (h *Handler) UpdateNotificationPreferences(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req NotificationPreferencesRequest
+	if err := c.Bind(&req); err != nil || req.WeeklyDigest == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "weekly_digest is required")
+	}
+
+	pref, err := h.digestService.UpdatePreferences(user.ID, *req.WeeklyDigest)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, pref)
+}
+
+// PreviewUsageDigest returns the digest the current user would receive for the past week
+func (h *Handler) PreviewUsageDigest(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	now := time.Now()
+	digest, err := h.digestService.BuildDigest(user.ID, now.AddDate(0, 0, -7), now)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, digest)
+}
+
+// UnsubscribeNotifications handles the one-click unsubscribe link sent in digest emails
+func (h *Handler) UnsubscribeNotifications(c echo.Context) error {
+	if err := h.digestService.Unsubscribe(c.QueryParam("token")); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.String(http.StatusOK, "You have been unsubscribed from AI Gateway usage digests.")
+}
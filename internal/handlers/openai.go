@@ -38,16 +38,67 @@ func (h *Handler) OpenAIChatCompletions(c echo.Context) error {
 	middleware.LogRequestBody(c, "OpenAI", req)
 
 	middleware.LogTrace(c, "OpenAI", "Parsed request: model=%s, messages=%d, stream=%v", req.Model, len(req.Messages), req.Stream)
+	if err := h.identifyEndUser(c, req.User); err != nil {
+		return err
+	}
+
+	// Enforce the key's response policy on the output, after the content filter
+	defer h.applyResponsePolicy(c, formatChat)()
+	// Apply the user's content filter rules to the output
+	defer h.filterOutput(c, formatChat)()
+
+	// Gateway-managed web search is only available for non-streaming requests
+	webSearch := req.WebSearch
+	req.WebSearch = false
+	maxCost := req.MaxCost
+	req.MaxCost = nil
+	if webSearch {
+		if !h.webSearchService.Enabled() {
+			return echo.NewHTTPError(http.StatusBadRequest, "web search is not configured on this gateway")
+		}
+		if req.Stream {
+			return echo.NewHTTPError(http.StatusBadRequest, "web_search is not supported for streaming requests")
+		}
+		for _, tool := range req.Tools {
+			if tool.Function.Name == webSearchToolName {
+				return echo.NewHTTPError(http.StatusBadRequest, "tool name web_search is reserved when web_search is enabled")
+			}
+		}
+	}
+
+	// Expand server-side conversation history when a conversation_id is given
+	if req.ConversationID != "" {
+		persistConversation, err := h.attachConversation(c, &req)
+		if err != nil {
+			return err
+		}
+		defer persistConversation()
+	}
+	h.compressChatHistory(c, &req)
+	notePromptEstimate(c, &req)
+
+	// Model aliases with a cascade policy are answered by their draft/verify models
+	if policy := h.cascadePolicyFor(c, req.Model); policy != nil {
+		if webSearch {
+			return echo.NewHTTPError(http.StatusBadRequest, "web_search is not supported for cascade model "+policy.Model)
+		}
+		if hasMaxCost(c, maxCost) {
+			return echo.NewHTTPError(http.StatusBadRequest, "max cost is not supported for cascade model "+policy.Model)
+		}
+		middleware.LogTrace(c, "OpenAI", "Cascading %s: draft=%s verify=%s", policy.Model, policy.DraftModel, policy.VerifyModel)
+		return h.cascadeChatCompletion(c, &req, policy)
+	}
 
 	// Determine target provider from model name
 	provider := ""
 	resolved, err := h.resolveProviderForAPIKey(c, req.Model)
 	if err != nil {
 		middleware.LogTrace(c, "OpenAI", "Failed to resolve provider: %v", err)
-		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		return resolveProviderError(err)
 	}
 	if resolved != nil {
 		c.Set(middleware.ContextKeyProviderConfig, resolved.Config)
+		c.Set(middleware.ContextKeyUpstreamModel, resolved.Model)
 		req.Model = resolved.Model
 		provider = resolved.Provider
 	}
@@ -58,9 +109,21 @@ func (h *Handler) OpenAIChatCompletions(c echo.Context) error {
 		middleware.LogTrace(c, "OpenAI", "Unsupported model: %s", req.Model)
 		return echo.NewHTTPError(http.StatusBadRequest, "unsupported model")
 	}
+	if req.MaxTokens, err = h.applyMaxCost(c, req.Model, maxCost, req.MaxTokens); err != nil {
+		return err
+	}
 
 	middleware.LogTrace(c, "OpenAI", "Target provider: %s", provider)
 
+	// Race a second config when the model has a hedge policy
+	if policy := h.hedgePolicyFor(c, req.Model, resolved); policy != nil && !webSearch {
+		if hasMaxCost(c, maxCost) {
+			return echo.NewHTTPError(http.StatusBadRequest, "max cost is not supported for hedged model "+req.Model)
+		}
+		middleware.LogTrace(c, "OpenAI", "Hedging request after %dms (policy %d)", policy.DelayMs, policy.ID)
+		return h.hedgedChatCompletion(c, &req, policy, resolved.Candidates)
+	}
+
 	// Get credentials
 	baseURL, apiKey, protocol, err := h.getCredentials(c, provider, req.Model)
 	if err != nil {
@@ -70,26 +133,83 @@ func (h *Handler) OpenAIChatCompletions(c echo.Context) error {
 
 	middleware.LogTrace(c, "OpenAI", "Got credentials: baseURL=%s, apiKeyLen=%d, protocol=%s", baseURL, len(apiKey), protocol)
 
-	// Route to appropriate handler
+	// Fit inline images to the limits of the upstream
+	contents := make([]interface{}, len(req.Messages))
+	for i := range req.Messages {
+		contents[i] = req.Messages[i].Content
+	}
+	if err := h.fitInlineImages(c, protocol, contents); err != nil {
+		return err
+	}
+
+	// Offer gateway-executed tools (web search, the user's MCP servers) and run
+	// the calls the model makes to them
+	if !req.Stream {
+		var tools []*serverTool
+		if webSearch {
+			tools = append(tools, h.webSearchTool())
+		}
+		tools = append(tools, h.mcpServerTools(c)...)
+		if len(tools) > 0 {
+			if hasMaxCost(c, maxCost) {
+				return echo.NewHTTPError(http.StatusBadRequest, "max cost is not supported with gateway-executed tools")
+			}
+			middleware.LogTrace(c, "OpenAI", "Adding %d gateway-executed tools", len(tools))
+			return h.serverToolChatCompletion(c, &req, tools, baseURL, apiKey, protocol)
+		}
+
+		// Validate output against a declared JSON schema when the key enables repairs
+		if schema := responseSchema(&req); schema != nil {
+			if retries := schemaRepairRetries(c); retries > 0 {
+				if hasMaxCost(c, maxCost) {
+					return echo.NewHTTPError(http.StatusBadRequest, "max cost is not supported with schema repair")
+				}
+				return h.schemaGuardedChatCompletion(c, &req, schema, retries, baseURL, apiKey, protocol)
+			}
+		}
+	}
+
+	// Continue responses cut off at max tokens when the key enables it
+	if budget := autoContinueBudget(c); budget > 0 && !hasMaxCost(c, maxCost) {
+		return h.autoContinuedChatCompletion(c, &req, budget, baseURL, apiKey, protocol)
+	}
+	return h.dispatchChatCompletion(c, &req, baseURL, apiKey, protocol)
+}
+
+// dispatchChatCompletion routes a chat completion request to the handler for the upstream protocol
+func (h *Handler) dispatchChatCompletion(c echo.Context, req *models.ChatCompletionRequest, baseURL, apiKey, protocol string) error {
 	switch protocol {
 	case "openai_chat":
 		middleware.LogTrace(c, "OpenAI", "Routing to OpenAI chat handler")
-		return h.handleOpenAIToOpenAI(c, &req, baseURL, apiKey)
+		return h.handleOpenAIToOpenAI(c, req, baseURL, apiKey)
 	case "openai_code":
 		middleware.LogTrace(c, "OpenAI", "Routing to OpenAI responses handler")
-		return h.handleOpenAIToOpenAIResponses(c, &req, baseURL, apiKey)
+		return h.handleOpenAIToOpenAIResponses(c, req, baseURL, apiKey)
 	case "anthropic":
 		middleware.LogTrace(c, "OpenAI", "Routing to Anthropic handler")
-		return h.handleOpenAIToAnthropic(c, &req, baseURL, apiKey)
+		return h.handleOpenAIToAnthropic(c, req, baseURL, apiKey)
 	case "gemini":
 		middleware.LogTrace(c, "OpenAI", "Routing to Gemini handler")
-		return h.handleOpenAIToGemini(c, &req, baseURL, apiKey)
+		return h.handleOpenAIToGemini(c, req, baseURL, apiKey)
 	default:
 		middleware.LogTrace(c, "OpenAI", "Unsupported protocol: %s", protocol)
 		return echo.NewHTTPError(http.StatusBadRequest, "unsupported protocol")
 	}
 }
 
+// cloneChatRequest returns a deep copy of req
+func cloneChatRequest(req *models.ChatCompletionRequest) (*models.ChatCompletionRequest, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var clone models.ChatCompletionRequest
+	if err := json.Unmarshal(body, &clone); err != nil {
+		return nil, err
+	}
+	return &clone, nil
+}
+
 // OpenAICodeResponses handles POST /v1/responses - forwards directly to OpenAI
 func (h *Handler) OpenAICodeResponses(c echo.Context) error {
 	middleware.LogTrace(c, "OpenAI-Responses", "Handling responses request")
@@ -109,17 +229,35 @@ func (h *Handler) OpenAICodeResponses(c echo.Context) error {
 
 	// Get model from request
 	model, _ := reqBody["model"].(string)
+	endUser, _ := reqBody["user"].(string)
+	if err := h.identifyEndUser(c, endUser); err != nil {
+		return err
+	}
 	middleware.LogTrace(c, "OpenAI-Responses", "Parsed request: model=%s", model)
 
+	// Enforce the key's response policy on the output, after the content filter
+	defer h.applyResponsePolicy(c, formatResponses)()
+	// Apply the user's content filter rules to the output
+	defer h.filterOutput(c, formatResponses)()
+
+	background, _ := reqBody["background"].(bool)
+	if stream, _ := reqBody["stream"].(bool); background && stream {
+		return echo.NewHTTPError(http.StatusBadRequest, "stream is not supported for background responses")
+	}
+	clientModel := model
+	h.compressResponsesHistory(c, reqBody)
+	noteResponsesPromptEstimate(c, reqBody)
+
 	// Determine target provider from model name
 	provider := ""
 	resolved, err := h.resolveProviderForAPIKey(c, model)
 	if err != nil {
 		middleware.LogTrace(c, "OpenAI-Responses", "Failed to resolve provider: %v", err)
-		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		return resolveProviderError(err)
 	}
 	if resolved != nil {
 		c.Set(middleware.ContextKeyProviderConfig, resolved.Config)
+		c.Set(middleware.ContextKeyUpstreamModel, resolved.Model)
 		model = resolved.Model
 		reqBody["model"] = resolved.Model
 		provider = resolved.Provider
@@ -131,6 +269,9 @@ func (h *Handler) OpenAICodeResponses(c echo.Context) error {
 		middleware.LogTrace(c, "OpenAI-Responses", "Unsupported model: %s", model)
 		return echo.NewHTTPError(http.StatusBadRequest, "unsupported model")
 	}
+	if err := h.applyResponsesMaxCost(c, model, reqBody); err != nil {
+		return err
+	}
 
 	// Get credentials for provider
 	baseURL, apiKey, protocol, err := h.getCredentials(c, provider, model)
@@ -141,6 +282,17 @@ func (h *Handler) OpenAICodeResponses(c echo.Context) error {
 
 	middleware.LogTrace(c, "OpenAI-Responses", "Got credentials: baseURL=%s, apiKeyLen=%d, protocol=%s", baseURL, len(apiKey), protocol)
 
+	// Fit inline images to the limits of the upstream
+	if err := h.fitInlineImages(c, protocol, []interface{}{reqBody["input"]}); err != nil {
+		return err
+	}
+
+	// Upstreams without background mode get it emulated by the gateway
+	if background && protocol != "openai_code" {
+		reqBody["model"] = clientModel
+		return h.startBackgroundResponse(c, reqBody, clientModel)
+	}
+
 	// Create adapters
 	openaiAdapter := adapters.NewOpenAIAdapter(apiKey, baseURL)
 	anthropicAdapter := adapters.NewAnthropicAdapter(apiKey, baseURL)
@@ -150,7 +302,11 @@ func (h *Handler) OpenAICodeResponses(c echo.Context) error {
 	stream, _ := reqBody["stream"].(bool)
 	switch protocol {
 	case "openai_code":
-		enforceOpenAIReasoningHigh(reqBody)
+		// The Responses API stores responses unless told not to
+		if _, ok := reqBody["store"]; !ok {
+			reqBody["store"] = true
+		}
+		applyReasoningPolicy(c, reqBody)
 		if stream {
 			middleware.LogTrace(c, "OpenAI-Responses", "Starting streaming request")
 			return h.streamResponses(c, openaiAdapter, reqBody)
@@ -165,8 +321,15 @@ func (h *Handler) OpenAICodeResponses(c echo.Context) error {
 
 		middleware.LogTrace(c, "OpenAI-Responses", "Received response: statusCode=%d", statusCode)
 
-		// Record usage
-		h.recordUsage(c, "/v1/responses", model, resp, statusCode)
+		// Stored responses can be retrieved through the gateway; background ones
+		// have their usage recorded once they finish
+		succeeded := statusCode < http.StatusMultipleChoices
+		if store, ok := reqBody["store"].(bool); (!ok || store || background) && succeeded {
+			h.trackStoredResponse(c, model, resp, statusCode, background)
+		}
+		if !background || !succeeded {
+			h.recordUsage(c, "/v1/responses", model, resp, statusCode)
+		}
 
 		return c.JSON(statusCode, resp)
 	case "openai_chat":
@@ -175,6 +338,9 @@ func (h *Handler) OpenAICodeResponses(c echo.Context) error {
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
+		if err := checkStrictConversion(c, "openai_chat", converters.OpenAIResponsesUnsupportedFields(reqBody, chatReq, "openai_chat")); err != nil {
+			return err
+		}
 
 		if stream {
 			middleware.LogTrace(c, "OpenAI-Responses", "Starting streaming chat request")
@@ -203,10 +369,14 @@ func (h *Handler) OpenAICodeResponses(c echo.Context) error {
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
+		if err := checkStrictConversion(c, "anthropic", converters.OpenAIResponsesUnsupportedFields(reqBody, chatReq, "anthropic")); err != nil {
+			return err
+		}
 		anthropicReq, err := converters.OpenAIToAnthropicRequest(chatReq)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
+		applyAnthropicMaxTokens(c, anthropicReq, chatReq.MaxTokens != nil)
 
 		if stream {
 			middleware.LogTrace(c, "OpenAI-Responses", "Starting streaming Anthropic request")
@@ -237,10 +407,17 @@ func (h *Handler) OpenAICodeResponses(c echo.Context) error {
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
+		if err := checkStrictConversion(c, "gemini", converters.OpenAIResponsesUnsupportedFields(reqBody, chatReq, "gemini")); err != nil {
+			return err
+		}
 		geminiReq, err := converters.OpenAIToGeminiRequest(chatReq)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
+		reportToolSchemaWarnings(c, converters.SanitizeGeminiTools(geminiReq.Tools))
+		if err := h.inlineGeminiImageURLs(c.Request().Context(), geminiReq); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
 
 		if stream {
 			middleware.LogTrace(c, "OpenAI-Responses", "Starting streaming Gemini request")
@@ -300,6 +477,8 @@ func (h *Handler) streamResponses(c echo.Context, adapter *adapters.OpenAIAdapte
 	var dataLineCount int
 	var byteCount int
 	done := false
+	store, ok := req["store"].(bool)
+	tracked := (ok && !store) || statusCode >= http.StatusMultipleChoices
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
@@ -314,6 +493,9 @@ func (h *Handler) streamResponses(c echo.Context, adapter *adapters.OpenAIAdapte
 		byteCount += len(line)
 		if strings.HasPrefix(line, "data:") {
 			dataLineCount++
+			if !tracked {
+				tracked = h.trackStreamedResponse(c, model, line)
+			}
 		}
 
 		c.Response().Write([]byte(line))
@@ -366,6 +548,9 @@ func (h *Handler) handleOpenAIToOpenAI(c echo.Context, req *models.ChatCompletio
 
 // handleOpenAIToOpenAIResponses converts and forwards to OpenAI /responses endpoint
 func (h *Handler) handleOpenAIToOpenAIResponses(c echo.Context, req *models.ChatCompletionRequest, baseURL, apiKey string) error {
+	if err := checkStrictConversion(c, "openai_code", converters.OpenAIChatUnsupportedFields(req, "openai_code")); err != nil {
+		return err
+	}
 	middleware.LogTrace(c, "OpenAI->OpenAIResponses", "Converting request to Responses API format")
 	responsesReq, err := converters.OpenAIChatToOpenAIResponsesRequest(req)
 	if err != nil {
@@ -373,7 +558,7 @@ func (h *Handler) handleOpenAIToOpenAIResponses(c echo.Context, req *models.Chat
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	enforceOpenAIReasoningHigh(responsesReq)
+	applyReasoningPolicy(c, responsesReq)
 
 	adapter := adapters.NewOpenAIAdapter(apiKey, baseURL)
 
@@ -402,6 +587,9 @@ func (h *Handler) handleOpenAIToOpenAIResponses(c echo.Context, req *models.Chat
 
 // handleOpenAIToAnthropic converts and forwards to Anthropic
 func (h *Handler) handleOpenAIToAnthropic(c echo.Context, req *models.ChatCompletionRequest, baseURL, apiKey string) error {
+	if err := checkStrictConversion(c, "anthropic", converters.OpenAIChatUnsupportedFields(req, "anthropic")); err != nil {
+		return err
+	}
 	middleware.LogTrace(c, "OpenAI->Anthropic", "Converting request")
 	// Convert request
 	anthropicReq, err := converters.OpenAIToAnthropicRequest(req)
@@ -409,13 +597,18 @@ func (h *Handler) handleOpenAIToAnthropic(c echo.Context, req *models.ChatComple
 		middleware.LogTrace(c, "OpenAI->Anthropic", "Conversion error: %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	applyAnthropicMaxTokens(c, anthropicReq, req.MaxTokens != nil)
 
 	middleware.LogTrace(c, "OpenAI->Anthropic", "Creating adapter with baseURL=%s", baseURL)
 	adapter := adapters.NewAnthropicAdapter(apiKey, baseURL)
 
 	if req.Stream {
 		middleware.LogTrace(c, "OpenAI->Anthropic", "Starting streaming request")
-		return h.streamOpenAIFromAnthropic(c, adapter, anthropicReq, req.Model)
+		var jsonFilter *converters.JSONStreamFilter
+		if h.cfg.JSONModeStripProse && converters.WantsJSON(req) {
+			jsonFilter = &converters.JSONStreamFilter{}
+		}
+		return h.streamOpenAIFromAnthropic(c, adapter, anthropicReq, req.Model, jsonFilter)
 	}
 
 	middleware.LogTrace(c, "OpenAI->Anthropic", "Sending non-streaming request")
@@ -434,6 +627,18 @@ func (h *Handler) handleOpenAIToAnthropic(c echo.Context, req *models.ChatComple
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
+	// Keep only the JSON of JSON mode answers
+	if h.cfg.JSONModeStripProse && converters.WantsJSON(req) {
+		for _, choice := range openaiResp.Choices {
+			if choice.Message == nil {
+				continue
+			}
+			if text, ok := choice.Message.Content.(string); ok {
+				choice.Message.Content = converters.ExtractJSON(text)
+			}
+		}
+	}
+
 	// Record usage
 	h.recordUsageFromOpenAI(c, "/v1/chat/completions", req.Model, openaiResp, statusCode)
 
@@ -442,6 +647,9 @@ func (h *Handler) handleOpenAIToAnthropic(c echo.Context, req *models.ChatComple
 
 // handleOpenAIToGemini converts and forwards to Gemini
 func (h *Handler) handleOpenAIToGemini(c echo.Context, req *models.ChatCompletionRequest, baseURL, apiKey string) error {
+	if err := checkStrictConversion(c, "gemini", converters.OpenAIChatUnsupportedFields(req, "gemini")); err != nil {
+		return err
+	}
 	middleware.LogTrace(c, "OpenAI->Gemini", "Converting request")
 	// Convert request
 	geminiReq, err := converters.OpenAIToGeminiRequest(req)
@@ -449,6 +657,11 @@ func (h *Handler) handleOpenAIToGemini(c echo.Context, req *models.ChatCompletio
 		middleware.LogTrace(c, "OpenAI->Gemini", "Conversion error: %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	reportToolSchemaWarnings(c, converters.SanitizeGeminiTools(geminiReq.Tools))
+	if err := h.inlineGeminiImageURLs(c.Request().Context(), geminiReq); err != nil {
+		middleware.LogTrace(c, "OpenAI->Gemini", "Image fetch error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
 
 	middleware.LogTrace(c, "OpenAI->Gemini", "Creating adapter with baseURL=%s", baseURL)
 	adapter := adapters.NewGeminiAdapter(apiKey, baseURL)
@@ -513,6 +726,17 @@ func (h *Handler) streamOpenAI(c echo.Context, adapter *adapters.OpenAIAdapter,
 	lastActivity := startTime
 	lineCount := 0
 
+	// Count streamed text so usage can be estimated when no chunk reports it
+	var usage *models.Usage
+	completionChars := 0
+	defer func() {
+		if usage != nil {
+			h.recordUsageFromOpenAI(c, "/v1/chat/completions", req.Model, &models.ChatCompletionResponse{Usage: usage}, statusCode)
+			return
+		}
+		h.recordEstimatedUsage(c, "/v1/chat/completions", req.Model, completionChars, statusCode)
+	}()
+
 	middleware.LogTrace(c, "OpenAI-Stream", "Starting stream reading...")
 
 	for {
@@ -545,6 +769,17 @@ func (h *Handler) streamOpenAI(c echo.Context, adapter *adapters.OpenAIAdapter,
 			middleware.LogTrace(c, "OpenAI-Stream", "Stream completed with [DONE] after %s, lines=%d", time.Since(startTime), lineCount)
 			break
 		}
+		if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:"); ok {
+			var chunk models.ChatCompletionChunk
+			if json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk) == nil {
+				if chunk.Usage != nil {
+					usage = chunk.Usage
+				}
+				for _, choice := range chunk.Choices {
+					completionChars += textChars(choice.Delta)
+				}
+			}
+		}
 
 		// Log progress every 100 lines or every 30 seconds
 		if lineCount%100 == 0 || time.Since(lastActivity) > 30*time.Second {
@@ -594,12 +829,12 @@ func (h *Handler) streamOpenAIFromOpenAIResponses(c echo.Context, adapter *adapt
 				break
 			}
 
-			var eventData map[string]interface{}
-			if err := json.Unmarshal([]byte(data), &eventData); err != nil {
+			var event models.ResponsesStreamEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
 				continue
 			}
 
-			chunks, err := converters.OpenAIResponsesStreamToOpenAIChatStream(eventData, state)
+			chunks, err := converters.OpenAIResponsesStreamToOpenAIChatStream(&event, state)
 			if err != nil {
 				continue
 			}
@@ -619,8 +854,9 @@ func (h *Handler) streamOpenAIFromOpenAIResponses(c echo.Context, adapter *adapt
 	return nil
 }
 
-// streamOpenAIFromAnthropic streams and converts Anthropic response to OpenAI format
-func (h *Handler) streamOpenAIFromAnthropic(c echo.Context, adapter *adapters.AnthropicAdapter, req *models.MessagesRequest, model string) error {
+// streamOpenAIFromAnthropic streams and converts Anthropic response to OpenAI
+// format. A non-nil jsonFilter drops streamed text outside the JSON value.
+func (h *Handler) streamOpenAIFromAnthropic(c echo.Context, adapter *adapters.AnthropicAdapter, req *models.MessagesRequest, model string, jsonFilter *converters.JSONStreamFilter) error {
 	req.Stream = true
 	stream, statusCode, err := adapter.MessagesStream(c.Request().Context(), req)
 	if err != nil {
@@ -670,6 +906,15 @@ func (h *Handler) streamOpenAIFromAnthropic(c echo.Context, adapter *adapters.An
 			}
 
 			eventType, _ := eventData["type"].(string)
+			if jsonFilter != nil && eventType == "content_block_delta" {
+				if delta, ok := eventData["delta"].(map[string]interface{}); ok && delta["type"] == "text_delta" {
+					text, _ := delta["text"].(string)
+					if text = jsonFilter.Filter(text); text == "" {
+						continue
+					}
+					delta["text"] = text
+				}
+			}
 			chunk, err := converters.AnthropicStreamToOpenAIStream(eventType, eventData, model, id)
 			if err != nil || chunk == nil {
 				continue
@@ -682,6 +927,9 @@ func (h *Handler) streamOpenAIFromAnthropic(c echo.Context, adapter *adapters.An
 		}
 	}
 
+	if jsonFilter != nil && !jsonFilter.Started() {
+		middleware.LogTrace(c, "OpenAI->Anthropic", "JSON mode stream contained no JSON value")
+	}
 	return nil
 }
 
@@ -1163,13 +1411,21 @@ func (h *Handler) getCredentials(c echo.Context, provider string, model string)
 
 // recordUsage records API usage
 func (h *Handler) recordUsage(c echo.Context, endpoint, model string, resp map[string]interface{}, statusCode int) {
-	apiKey := middleware.GetAPIKey(c)
+	apiKey := usageKey(c)
 	if apiKey == nil {
 		return
 	}
 
+	usage, ok := resp["usage"].(map[string]interface{})
+	if !ok || len(usage) == 0 {
+		// Some OpenAI-compatible upstreams omit usage; estimate it instead
+		if h.recordEstimatedUsage(c, endpoint, model, textChars(resp["choices"])+textChars(resp["output"]), statusCode) {
+			return
+		}
+	}
+
 	var promptTokens, completionTokens int
-	if usage, ok := resp["usage"].(map[string]interface{}); ok {
+	if ok {
 		if pt, ok := usage["prompt_tokens"].(float64); ok {
 			promptTokens = int(pt)
 		}
@@ -1188,23 +1444,30 @@ func (h *Handler) recordUsage(c echo.Context, endpoint, model string, resp map[s
 		}
 	}
 
-	h.apiKeyService.RecordUsage(apiKey.ID, endpoint, model, promptTokens, completionTokens, statusCode)
+	h.apiKeyService.RecordUsage(apiKey.ID, endpoint, model, promptTokens, completionTokens, statusCode, usageAttribution(c))
 }
 
 // recordUsageFromOpenAI records usage from OpenAI response
 func (h *Handler) recordUsageFromOpenAI(c echo.Context, endpoint, model string, resp *models.ChatCompletionResponse, statusCode int) {
-	apiKey := middleware.GetAPIKey(c)
+	apiKey := usageKey(c)
 	if apiKey == nil {
 		return
 	}
 
+	if resp.Usage == nil {
+		// Some OpenAI-compatible upstreams omit usage; estimate it instead
+		if h.recordEstimatedUsage(c, endpoint, model, textChars(resp.Choices), statusCode) {
+			return
+		}
+	}
+
 	var promptTokens, completionTokens int
 	if resp.Usage != nil {
 		promptTokens = resp.Usage.PromptTokens
 		completionTokens = resp.Usage.CompletionTokens
 	}
 
-	h.apiKeyService.RecordUsage(apiKey.ID, endpoint, model, promptTokens, completionTokens, statusCode)
+	h.apiKeyService.RecordUsage(apiKey.ID, endpoint, model, promptTokens, completionTokens, statusCode, usageAttribution(c))
 }
 
 // Helper to read SSE stream
@@ -1222,20 +1485,3 @@ func readSSEStream(reader *bufio.Reader) <-chan string {
 	}()
 	return ch
 }
-
-func enforceOpenAIReasoningHigh(req map[string]interface{}) {
-	if req == nil {
-		return
-	}
-
-	req["tool_choice"] = "auto"
-	req["parallel_tool_calls"] = true
-	req["store"] = false
-	req["reasoning"] = map[string]interface{}{
-		"effort":  "high",
-		"summary": "auto",
-	}
-
-	return
-
-}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+	"ai_gateway/internal/openapi"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// apiVersion is the version of the management API in its OpenAPI document;
+// bump it on breaking changes
+const apiVersion = "1.0.0"
+
+// listParams are the query parameters of list endpoints, see listQuery
+var listParams = []openapi.Parameter{
+	queryParam("q", "string", "search"),
+	queryParam("status", "string", "status filter"),
+	queryParam("limit", "integer", "page size, at most 1000; the X-Total-Count header carries the number of matches"),
+	queryParam("offset", "integer", "items to skip"),
+	{Name: "since", In: "query", Description: "earliest creation time", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+	{Name: "until", In: "query", Description: "latest creation time", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+}
+
+func queryParam(name, typ, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: typ}}
+}
+
+// managementRoutes declares the /api endpoints of the OpenAPI document, with
+// the types their handlers bind and return. A route added to main.go for
+// these endpoints is added here too.
+var managementRoutes = []openapi.Route{
+	// Auth
+	{Method: http.MethodPost, Path: "/api/auth/register", OperationID: "register", Summary: "Register a dashboard account", Tag: "auth",
+		Request: RegisterRequest{}, Response: UserResponse{}, Status: http.StatusCreated, Public: true},
+	{Method: http.MethodPost, Path: "/api/auth/login", OperationID: "login", Summary: "Log in and get an access token", Tag: "auth",
+		Request: LoginRequest{}, Response: TokenResponse{}, Public: true},
+	{Method: http.MethodGet, Path: "/api/auth/me", OperationID: "getCurrentUser", Summary: "Get the current user", Tag: "auth",
+		Response: UserResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/logout", OperationID: "logout", Summary: "Revoke the current session", Tag: "auth",
+		Response: map[string]string{}},
+	{Method: http.MethodGet, Path: "/api/auth/sessions", OperationID: "listSessions", Summary: "List the sessions of the current user", Tag: "auth",
+		Response: []SessionResponse{}},
+	{Method: http.MethodDelete, Path: "/api/auth/sessions", OperationID: "revokeOtherSessions", Summary: "Revoke all sessions but the current one", Tag: "auth",
+		Response: map[string]interface{}{}},
+	{Method: http.MethodDelete, Path: "/api/auth/sessions/:id", OperationID: "revokeSession", Summary: "Revoke a session", Tag: "auth",
+		Params: []openapi.Parameter{{Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}}, Response: map[string]string{}},
+
+	// Provider configs
+	{Method: http.MethodGet, Path: "/api/config/providers", OperationID: "listProviderConfigs", Summary: "List provider configs", Tag: "configs",
+		Params: listParams, Response: []ProviderConfigResponse{}},
+	{Method: http.MethodPost, Path: "/api/config/providers", OperationID: "createProviderConfig", Summary: "Create a provider config", Tag: "configs",
+		Request: ProviderConfigRequest{}, Response: ProviderConfigResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodPut, Path: "/api/config/providers/by-name/:name", OperationID: "provisionProviderConfig", Summary: "Create or update the provider config with a name", Tag: "configs",
+		Request: ProviderConfigRequest{}, Response: ProviderConfigResponse{}},
+	{Method: http.MethodGet, Path: "/api/config/providers/:provider", OperationID: "listProviderConfigsByProvider", Summary: "List the provider configs of a provider", Tag: "configs",
+		Params: listParams, Response: []ProviderConfigResponse{}},
+	{Method: http.MethodGet, Path: "/api/config/providers/id/:id", OperationID: "getProviderConfig", Summary: "Get a provider config", Tag: "configs",
+		Response: ProviderConfigResponse{}},
+	{Method: http.MethodPut, Path: "/api/config/providers/:id", OperationID: "updateProviderConfig", Summary: "Update a provider config", Tag: "configs",
+		Request: ProviderConfigRequest{}, Response: ProviderConfigResponse{}},
+	{Method: http.MethodDelete, Path: "/api/config/providers/:id", OperationID: "deleteProviderConfig", Summary: "Delete a provider config", Tag: "configs",
+		Status: http.StatusNoContent},
+	{Method: http.MethodPut, Path: "/api/config/providers/:id/default", OperationID: "setDefaultProviderConfig", Summary: "Make a provider config the default of its provider", Tag: "configs",
+		Response: ProviderConfigResponse{}},
+	{Method: http.MethodPut, Path: "/api/config/providers/:id/toggle", OperationID: "toggleProviderConfig", Summary: "Activate or deactivate a provider config", Tag: "configs",
+		Response: ProviderConfigResponse{}},
+	{Method: http.MethodGet, Path: "/api/config/providers/:id/history", OperationID: "getProviderConfigHistory", Summary: "List the revisions of a provider config", Tag: "configs",
+		Response: []database.ProviderConfigRevision{}},
+	{Method: http.MethodPost, Path: "/api/config/providers/:id/rollback", OperationID: "rollbackProviderConfig", Summary: "Restore a revision of a provider config", Tag: "configs",
+		Request: ProviderConfigRollbackRequest{}, Response: ProviderConfigResponse{}},
+	{Method: http.MethodGet, Path: "/api/config/providers/:id/health", OperationID: "getProviderConfigHealth", Summary: "Get the upstream health of a provider config", Tag: "configs",
+		Response: services.UpstreamHealth{}},
+
+	// API keys
+	{Method: http.MethodGet, Path: "/api/keys", OperationID: "listAPIKeys", Summary: "List API keys", Tag: "keys",
+		Params: listParams, Response: []APIKeyResponse{}},
+	{Method: http.MethodPost, Path: "/api/keys", OperationID: "createAPIKey", Summary: "Create an API key", Tag: "keys",
+		Request: APIKeyCreateRequest{}, Response: APIKeyCreateResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/api/keys/canary", OperationID: "createCanaryKey", Summary: "Create a canary API key, which is always rejected and alerts when used", Tag: "keys",
+		Request: services.CanaryKeyCreate{}, Response: APIKeyCreateResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodPut, Path: "/api/keys/by-name/:name", OperationID: "provisionAPIKey", Summary: "Create or update the API key with a name; only a created key's response holds the key", Tag: "keys",
+		Request: APIKeyCreateRequest{}, Response: APIKeyCreateResponse{}},
+	{Method: http.MethodPost, Path: "/api/keys/bulk/deactivate", OperationID: "bulkDeactivateAPIKeys", Summary: "Deactivate API keys", Tag: "keys",
+		Request: APIKeyBulkRequest{}, Response: APIKeyBulkResponse{}},
+	{Method: http.MethodPost, Path: "/api/keys/bulk/delete", OperationID: "bulkDeleteAPIKeys", Summary: "Delete API keys", Tag: "keys",
+		Request: APIKeyBulkRequest{}, Response: APIKeyBulkResponse{}},
+	{Method: http.MethodPost, Path: "/api/keys/bulk/limits", OperationID: "bulkUpdateAPIKeyLimits", Summary: "Set the limits of API keys", Tag: "keys",
+		Request: APIKeyBulkLimitsRequest{}, Response: APIKeyBulkResponse{}},
+	{Method: http.MethodGet, Path: "/api/keys/:id", OperationID: "getAPIKey", Summary: "Get an API key", Tag: "keys",
+		Response: APIKeyResponse{}},
+	{Method: http.MethodPut, Path: "/api/keys/:id", OperationID: "updateAPIKey", Summary: "Update an API key", Tag: "keys",
+		Request: APIKeyUpdateRequest{}, Response: APIKeyResponse{}},
+	{Method: http.MethodDelete, Path: "/api/keys/:id", OperationID: "deleteAPIKey", Summary: "Delete an API key", Tag: "keys",
+		Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/keys/:id/rotate", OperationID: "rotateAPIKey", Summary: "Issue a replacement for an API key", Tag: "keys",
+		Request: APIKeyRotateRequest{}, Response: APIKeyCreateResponse{}},
+	{Method: http.MethodPost, Path: "/api/keys/:id/signing-secret", OperationID: "enableAPIKeySigning", Summary: "Require signed requests for an API key", Tag: "keys",
+		Response: SigningSecretResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/api/keys/:id/signing-secret", OperationID: "disableAPIKeySigning", Summary: "Stop requiring signed requests for an API key", Tag: "keys",
+		Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/keys/:id/canary-hits", OperationID: "listCanaryHits", Summary: "List the latest requests made with a canary API key", Tag: "keys",
+		Response: []database.CanaryHit{}},
+
+	// Usage
+	{Method: http.MethodGet, Path: "/api/keys/:id/usage", OperationID: "getAPIKeyUsage", Summary: "Get the usage of an API key", Tag: "usage",
+		Params: append([]openapi.Parameter{queryParam("tag", "string", "attribution tag")}, listParams...), Response: services.APIKeyUsageStats{}},
+	{Method: http.MethodGet, Path: "/api/keys/:id/usage/tags", OperationID: "getAPIKeyTagUsage", Summary: "Split the usage of an API key by attribution tag", Tag: "usage",
+		Params: []openapi.Parameter{queryParam("days", "integer", "days to cover, 30 by default")}, Response: []services.TagUsage{}},
+	{Method: http.MethodGet, Path: "/api/usage/live", OperationID: "streamLiveUsage", Summary: "Stream usage events as they happen", Tag: "usage",
+		Response: events.Usage{}, ContentType: "text/event-stream"},
+	{Method: http.MethodGet, Path: "/api/credits", OperationID: "getCredits", Summary: "Get the credit balance and ledger", Tag: "usage",
+		Params: []openapi.Parameter{queryParam("limit", "integer", "ledger entries, 100 by default")}, Response: CreditsResponse{}},
+	{Method: http.MethodGet, Path: "/api/openapi.json", OperationID: "getOpenAPISpec", Summary: "Get this document", Tag: "meta",
+		Response: map[string]interface{}{}, Public: true},
+}
+
+var (
+	managementSpecOnce sync.Once
+	managementSpec     *openapi.Document
+)
+
+// ManagementSpec returns the OpenAPI document of the management API
+func ManagementSpec() *openapi.Document {
+	managementSpecOnce.Do(func() {
+		managementSpec = openapi.New(openapi.Info{
+			Title:       "AI Gateway management API",
+			Version:     apiVersion,
+			Description: "Dashboard endpoints for accounts, provider configs, API keys and usage. Authenticate with the access token from /api/auth/login.",
+		})
+		for _, route := range managementRoutes {
+			managementSpec.Add(route)
+		}
+	})
+	return managementSpec
+}
+
+// OpenAPISpec serves the OpenAPI document of the management API
+func (h *Handler) OpenAPISpec(c echo.Context) error {
+	return c.JSON(http.StatusOK, ManagementSpec())
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateSpec = flag.Bool("update", false, "rewrite testdata/openapi.json from the route table")
+
+// TestManagementSpec compares the OpenAPI document of the management API with
+// the committed one, so that changes to the API show up in review. After an
+// intended change, run go test ./internal/handlers -run TestManagementSpec -update
+// and bump apiVersion if the change breaks clients.
+func TestManagementSpec(t *testing.T) {
+	got, err := json.MarshalIndent(ManagementSpec(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+
+	golden := filepath.Join("testdata", "openapi.json")
+	if *updateSpec {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("management API document differs from %s; rerun with -update if the change is intended", golden)
+	}
+}
+
+func TestManagementSpecOperationIDs(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, route := range managementRoutes {
+		if route.OperationID == "" || seen[route.OperationID] {
+			t.Errorf("%s %s: missing or duplicate operation ID %q", route.Method, route.Path, route.OperationID)
+		}
+		seen[route.OperationID] = true
+	}
+}
@@ -0,0 +1,744 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Formats of the responses the content filter and the response policy rewrite
+const (
+	formatChat      = "chat"
+	formatAnthropic = "anthropic"
+	formatResponses = "responses"
+	formatGemini    = "gemini"
+)
+
+// outputFormat finds the generated text in the responses of one API format and
+// synthesizes the events the content filter and the response policy add to its
+// streams. Finish reasons are named as in chat completions: stop, length,
+// tool_calls and content_filter. A format follows the events of one stream, so
+// each writer has its own.
+type outputFormat struct {
+	name string
+
+	// chat completions: identifying fields of the chunks
+	template map[string]interface{}
+
+	// Anthropic: the types of the content blocks, the blocks open and the open
+	// text block as sent to the client, the next free block index and the
+	// output tokens reported so far
+	blockTypes   map[int]string
+	openBlocks   map[int]bool
+	openText     int
+	nextBlock    int
+	outputTokens interface{}
+
+	// Responses: the response of the stream, the IDs of its message items, the
+	// text sent for each and the items whose text ended
+	response map[string]interface{}
+	messages map[int]string
+	texts    map[int]*strings.Builder
+	finished map[int]bool
+
+	// Gemini: candidates that called functions
+	calls map[int]bool
+}
+
+func newOutputFormat(name string) *outputFormat {
+	return &outputFormat{
+		name:       name,
+		blockTypes: make(map[int]string),
+		openBlocks: make(map[int]bool),
+		openText:   -1,
+		messages:   make(map[int]string),
+		texts:      make(map[int]*strings.Builder),
+		finished:   make(map[int]bool),
+		calls:      make(map[int]bool),
+	}
+}
+
+// outputChoice is a choice of a buffered response
+type outputChoice struct {
+	parts     []*outputText // text parts in order
+	finish    string
+	setFinish func(reason string)
+}
+
+// outputText is a text part of a buffered response
+type outputText struct {
+	text string
+	set  func(text string)
+}
+
+// streamChoice is what an event of a stream carries for a choice
+type streamChoice struct {
+	item      int // position in the choice list of the event
+	index     int
+	text      string
+	hasText   bool
+	setText   func(text string)   // nil when the event cannot carry text
+	ended     bool                // the text of the choice, or of its current part, ends with the event
+	finish    string              // finish reason reported by the event
+	setFinish func(reason string) // nil when the event cannot carry a finish reason
+	dropped   bool
+}
+
+// streamEvent is the JSON payload of a stream event with its choices
+type streamEvent struct {
+	data    map[string]interface{}
+	choices []*streamChoice
+	done    bool   // the event ends the stream
+	list    string // the field listing the choices, for chat completions and Gemini
+	usage   string // the usage field that keeps an event without choices
+}
+
+// keep removes the dropped choices from the event and reports whether it has
+// anything left to send
+func (e *streamEvent) keep() bool {
+	if e.list == "" {
+		for _, ch := range e.choices {
+			if ch.dropped {
+				return false
+			}
+		}
+		return true
+	}
+	dropped := make(map[int]bool)
+	for _, ch := range e.choices {
+		if ch.dropped {
+			dropped[ch.item] = true
+		}
+	}
+	if len(dropped) == 0 {
+		return true
+	}
+	items, _ := e.data[e.list].([]interface{})
+	kept := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		if !dropped[i] {
+			kept = append(kept, item)
+		}
+	}
+	if len(kept) == 0 && e.data[e.usage] == nil {
+		return false
+	}
+	e.data[e.list] = kept
+	return true
+}
+
+// choices returns the choices of a buffered response
+func (f *outputFormat) choices(resp map[string]interface{}) []*outputChoice {
+	switch f.name {
+	case formatAnthropic:
+		return f.anthropicChoices(resp)
+	case formatResponses:
+		return f.responsesChoices(resp)
+	case formatGemini:
+		return f.geminiChoices(resp)
+	default:
+		return f.chatChoices(resp)
+	}
+}
+
+// event parses the JSON payload of a stream event
+func (f *outputFormat) event(data map[string]interface{}) *streamEvent {
+	ev := &streamEvent{data: data}
+	switch f.name {
+	case formatAnthropic:
+		f.anthropicEvent(ev)
+	case formatResponses:
+		f.responsesEvent(ev)
+	case formatGemini:
+		f.geminiEvent(ev)
+	default:
+		f.chatEvent(ev)
+	}
+	return ev
+}
+
+// render returns the stream event carrying data
+func (f *outputFormat) render(data map[string]interface{}) string {
+	out := ""
+	switch f.name {
+	case formatAnthropic:
+		f.trackAnthropicBlocks(data)
+		eventType, _ := data["type"].(string)
+		out = "event: " + eventType + "\n"
+	case formatResponses:
+		f.rewriteResponsesText(data)
+		if eventType, _ := data["type"].(string); eventType != "" {
+			out = "event: " + eventType + "\n"
+		}
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+	return out + "data: " + string(payload)
+}
+
+// textEvents synthesizes the events sending text for a choice
+func (f *outputFormat) textEvents(index int, text string) []map[string]interface{} {
+	switch f.name {
+	case formatAnthropic:
+		delta := func(block int) map[string]interface{} {
+			return map[string]interface{}{"type": "content_block_delta", "index": block,
+				"delta": map[string]interface{}{"type": "text_delta", "text": text}}
+		}
+		if f.openText >= 0 {
+			return []map[string]interface{}{delta(f.openText)}
+		}
+		block := f.nextBlock
+		return []map[string]interface{}{
+			{"type": "content_block_start", "index": block, "content_block": map[string]interface{}{"type": "text", "text": ""}},
+			delta(block),
+			{"type": "content_block_stop", "index": block},
+		}
+	case formatResponses:
+		f.sentText(index).WriteString(text)
+		return []map[string]interface{}{{"type": "response.output_text.delta", "item_id": f.messages[index],
+			"output_index": index, "content_index": 0, "delta": text}}
+	case formatGemini:
+		return []map[string]interface{}{{"candidates": []interface{}{map[string]interface{}{"index": index,
+			"content": map[string]interface{}{"role": "model", "parts": []interface{}{map[string]interface{}{"text": text}}}}}}}
+	default:
+		return []map[string]interface{}{f.newChunk(index, map[string]interface{}{"content": text}, nil)}
+	}
+}
+
+// endEvents synthesizes the events ending a stream early, after a choice
+// finished with reason
+func (f *outputFormat) endEvents(index int, reason string) []map[string]interface{} {
+	switch f.name {
+	case formatAnthropic:
+		var events []map[string]interface{}
+		open := make([]int, 0, len(f.openBlocks))
+		for block := range f.openBlocks {
+			open = append(open, block)
+		}
+		sort.Ints(open)
+		for _, block := range open {
+			events = append(events, map[string]interface{}{"type": "content_block_stop", "index": block})
+		}
+		outputTokens := f.outputTokens
+		if outputTokens == nil {
+			outputTokens = 0
+		}
+		return append(events,
+			map[string]interface{}{"type": "message_delta",
+				"delta": map[string]interface{}{"stop_reason": anthropicStopReason(reason), "stop_sequence": nil},
+				"usage": map[string]interface{}{"output_tokens": outputTokens}},
+			map[string]interface{}{"type": "message_stop"})
+	case formatResponses:
+		response := make(map[string]interface{})
+		for field, value := range f.response {
+			response[field] = value
+		}
+		response["status"] = "incomplete"
+		response["incomplete_details"] = map[string]interface{}{"reason": responsesIncompleteReason(reason)}
+		output := make([]interface{}, 0, len(f.messages))
+		for _, i := range sortedKeys(f.messages) {
+			output = append(output, map[string]interface{}{"id": f.messages[i], "type": "message", "role": "assistant", "status": "incomplete",
+				"content": []interface{}{map[string]interface{}{"type": "output_text", "text": f.sentText(i).String(), "annotations": []interface{}{}}}})
+		}
+		response["output"] = output
+		return []map[string]interface{}{{"type": "response.incomplete", "response": response}}
+	case formatGemini:
+		return []map[string]interface{}{{"candidates": []interface{}{map[string]interface{}{"index": index,
+			"content":      map[string]interface{}{"role": "model", "parts": []interface{}{map[string]interface{}{"text": ""}}},
+			"finishReason": geminiFinishReason(reason)}}}}
+	default:
+		return []map[string]interface{}{f.newChunk(index, map[string]interface{}{}, reason)}
+	}
+}
+
+// terminator returns the events that follow the end events of a stream
+func (f *outputFormat) terminator() []string {
+	if f.name == formatChat {
+		return []string{"data: [DONE]"}
+	}
+	return nil
+}
+
+// noticeEvent returns an event carrying only annotations, or nil when the
+// format has none
+func (f *outputFormat) noticeEvent() map[string]interface{} {
+	switch f.name {
+	case formatChat:
+		return f.newChunk(-1, nil, nil)
+	case formatAnthropic:
+		return map[string]interface{}{"type": "ping"}
+	default:
+		return nil
+	}
+}
+
+// errorBody returns the error replacing a response, or ending a stream
+func (f *outputFormat) errorBody(message, errType, code string, stream bool) map[string]interface{} {
+	switch {
+	case f.name == formatAnthropic:
+		return map[string]interface{}{"type": "error", "error": map[string]interface{}{"type": errType, "message": message}}
+	case f.name == formatGemini:
+		return map[string]interface{}{"error": map[string]interface{}{"code": 400, "message": message, "status": "INVALID_ARGUMENT"}}
+	case f.name == formatResponses && stream:
+		return map[string]interface{}{"type": "error", "code": code, "message": message, "param": nil}
+	default:
+		return map[string]interface{}{"error": map[string]interface{}{"message": message, "type": errType, "code": code}}
+	}
+}
+
+// chatChoices returns the message of each choice of a chat completion
+func (f *outputFormat) chatChoices(resp map[string]interface{}) []*outputChoice {
+	var out []*outputChoice
+	choices, _ := resp["choices"].([]interface{})
+	for _, raw := range choices {
+		choice, _ := raw.(map[string]interface{})
+		if choice == nil {
+			continue
+		}
+		oc := &outputChoice{setFinish: func(reason string) { choice["finish_reason"] = reason }}
+		oc.finish, _ = choice["finish_reason"].(string)
+		message, _ := choice["message"].(map[string]interface{})
+		if content, ok := message["content"].(string); ok {
+			oc.parts = append(oc.parts, &outputText{text: content, set: func(text string) { message["content"] = text }})
+		}
+		out = append(out, oc)
+	}
+	return out
+}
+
+// chatEvent parses the delta content and finish reason of each choice of a
+// chat completion chunk
+func (f *outputFormat) chatEvent(ev *streamEvent) {
+	if f.template == nil {
+		f.template = chunkTemplate(ev.data)
+	}
+	ev.list, ev.usage = "choices", "usage"
+	choices, _ := ev.data["choices"].([]interface{})
+	for i, raw := range choices {
+		choice, _ := raw.(map[string]interface{})
+		if choice == nil {
+			continue
+		}
+		delta, _ := choice["delta"].(map[string]interface{})
+		content, hasContent := delta["content"].(string)
+		finish, _ := choice["finish_reason"].(string)
+		ev.choices = append(ev.choices, &streamChoice{
+			item: i, index: jsonIndex(choice["index"]), text: content, hasText: hasContent, ended: finish != "", finish: finish,
+			setText: func(text string) {
+				if delta == nil {
+					delta = map[string]interface{}{}
+					choice["delta"] = delta
+				}
+				delta["content"] = text
+			},
+			setFinish: func(reason string) { choice["finish_reason"] = reason },
+		})
+	}
+}
+
+// chunkTemplate keeps the identifying fields of a chunk for synthesized chunks
+func chunkTemplate(chunk map[string]interface{}) map[string]interface{} {
+	template := map[string]interface{}{}
+	for _, key := range []string{"id", "object", "created", "model", "system_fingerprint"} {
+		if v, ok := chunk[key]; ok {
+			template[key] = v
+		}
+	}
+	return template
+}
+
+// newChunk synthesizes a chunk for a choice; a negative index yields no choices
+func (f *outputFormat) newChunk(index int, delta map[string]interface{}, finishReason interface{}) map[string]interface{} {
+	chunk := map[string]interface{}{"object": "chat.completion.chunk"}
+	for k, v := range f.template {
+		chunk[k] = v
+	}
+	choices := []interface{}{}
+	if index >= 0 {
+		choices = append(choices, map[string]interface{}{"index": index, "delta": delta, "finish_reason": finishReason})
+	}
+	chunk["choices"] = choices
+	return chunk
+}
+
+// anthropicChoices returns the text blocks of a message as its only choice
+func (f *outputFormat) anthropicChoices(resp map[string]interface{}) []*outputChoice {
+	stopReason, _ := resp["stop_reason"].(string)
+	oc := &outputChoice{
+		finish:    anthropicFinish(stopReason),
+		setFinish: func(reason string) { resp["stop_reason"] = anthropicStopReason(reason) },
+	}
+	content, _ := resp["content"].([]interface{})
+	for _, raw := range content {
+		block, _ := raw.(map[string]interface{})
+		if text, ok := block["text"].(string); ok && block["type"] == "text" {
+			oc.parts = append(oc.parts, &outputText{text: text, set: func(text string) { block["text"] = text }})
+		}
+	}
+	return []*outputChoice{oc}
+}
+
+// anthropicEvent parses the text deltas, the end of text blocks and the stop
+// reason of a Messages stream, whose text is all one choice
+func (f *outputFormat) anthropicEvent(ev *streamEvent) {
+	eventType, _ := ev.data["type"].(string)
+	index := jsonIndex(ev.data["index"])
+	switch eventType {
+	case "message_start":
+		message, _ := ev.data["message"].(map[string]interface{})
+		if usage, _ := message["usage"].(map[string]interface{}); usage != nil {
+			f.outputTokens = usage["output_tokens"]
+		}
+	case "content_block_start":
+		block, _ := ev.data["content_block"].(map[string]interface{})
+		f.blockTypes[index], _ = block["type"].(string)
+	case "content_block_delta":
+		delta, _ := ev.data["delta"].(map[string]interface{})
+		if text, ok := delta["text"].(string); ok && delta["type"] == "text_delta" {
+			ev.choices = append(ev.choices, &streamChoice{text: text, hasText: true,
+				setText: func(text string) { delta["text"] = text }})
+		}
+	case "content_block_stop":
+		if f.blockTypes[index] == "text" {
+			ev.choices = append(ev.choices, &streamChoice{ended: true})
+		}
+	case "message_delta":
+		if usage, _ := ev.data["usage"].(map[string]interface{}); usage != nil && usage["output_tokens"] != nil {
+			f.outputTokens = usage["output_tokens"]
+		}
+		delta, _ := ev.data["delta"].(map[string]interface{})
+		if stopReason, _ := delta["stop_reason"].(string); stopReason != "" {
+			ev.choices = append(ev.choices, &streamChoice{ended: true, finish: anthropicFinish(stopReason),
+				setFinish: func(reason string) { delta["stop_reason"] = anthropicStopReason(reason) }})
+		}
+	case "message_stop":
+		ev.done = true
+	}
+}
+
+// trackAnthropicBlocks notes the content blocks a Messages event sent to the
+// client opens and closes
+func (f *outputFormat) trackAnthropicBlocks(data map[string]interface{}) {
+	index := jsonIndex(data["index"])
+	switch data["type"] {
+	case "content_block_start":
+		f.openBlocks[index] = true
+		if block, _ := data["content_block"].(map[string]interface{}); block["type"] == "text" {
+			f.openText = index
+		}
+		if index >= f.nextBlock {
+			f.nextBlock = index + 1
+		}
+	case "content_block_stop":
+		delete(f.openBlocks, index)
+		if index == f.openText {
+			f.openText = -1
+		}
+	}
+}
+
+// anthropicFinish names an Anthropic stop reason as a finish reason
+func anthropicFinish(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+// anthropicStopReason names a finish reason as an Anthropic stop reason
+func anthropicStopReason(reason string) string {
+	switch reason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+// responsesChoices returns each message item of a Responses response as a
+// choice with its output text parts
+func (f *outputFormat) responsesChoices(resp map[string]interface{}) []*outputChoice {
+	finish := "stop"
+	if resp["status"] == "incomplete" {
+		details, _ := resp["incomplete_details"].(map[string]interface{})
+		finish = "length"
+		if details["reason"] == "content_filter" {
+			finish = "content_filter"
+		}
+	}
+	setFinish := func(reason string) {
+		resp["status"] = "incomplete"
+		resp["incomplete_details"] = map[string]interface{}{"reason": responsesIncompleteReason(reason)}
+	}
+
+	var out []*outputChoice
+	output, _ := resp["output"].([]interface{})
+	for _, raw := range output {
+		item, _ := raw.(map[string]interface{})
+		if item["type"] != "message" {
+			continue
+		}
+		oc := &outputChoice{finish: finish, setFinish: setFinish}
+		content, _ := item["content"].([]interface{})
+		for _, rawPart := range content {
+			part, _ := rawPart.(map[string]interface{})
+			if text, ok := part["text"].(string); ok && part["type"] == "output_text" {
+				oc.parts = append(oc.parts, &outputText{text: text, set: func(text string) { part["text"] = text }})
+			}
+		}
+		out = append(out, oc)
+	}
+	return out
+}
+
+// responsesEvent parses the text deltas of a Responses stream and the end of
+// its message items, each of which is a choice
+func (f *outputFormat) responsesEvent(ev *streamEvent) {
+	eventType, _ := ev.data["type"].(string)
+	index := jsonIndex(ev.data["output_index"])
+	switch eventType {
+	case "response.created", "response.in_progress":
+		f.response, _ = ev.data["response"].(map[string]interface{})
+	case "response.output_item.added":
+		if item, _ := ev.data["item"].(map[string]interface{}); item["type"] == "message" {
+			f.messages[index], _ = item["id"].(string)
+		}
+	case "response.output_text.delta":
+		if _, ok := f.messages[index]; !ok {
+			f.messages[index], _ = ev.data["item_id"].(string)
+		}
+		delta, _ := ev.data["delta"].(string)
+		ev.choices = append(ev.choices, &streamChoice{index: index, text: delta, hasText: true,
+			setText: func(text string) {
+				ev.data["delta"] = text
+				f.sentText(index).WriteString(text)
+			}})
+	case "response.output_text.done", "response.output_item.done":
+		item, _ := ev.data["item"].(map[string]interface{})
+		_, message := f.messages[index]
+		if (item["type"] == "message" || (item == nil && message)) && !f.finished[index] {
+			f.finished[index] = true
+			ev.choices = append(ev.choices, &streamChoice{index: index, ended: true, finish: "stop"})
+		}
+	case "response.completed", "response.incomplete", "response.failed":
+		ev.done = true
+	}
+}
+
+// rewriteResponsesText replaces the full text repeated by the closing events of
+// a Responses stream with the text sent for each message item
+func (f *outputFormat) rewriteResponsesText(data map[string]interface{}) {
+	setParts := func(index int, content []interface{}) {
+		text, ok := f.texts[index]
+		if !ok {
+			return
+		}
+		first := true
+		for _, raw := range content {
+			if part, _ := raw.(map[string]interface{}); part["type"] == "output_text" {
+				part["text"] = ""
+				if first {
+					part["text"] = text.String()
+					first = false
+				}
+			}
+		}
+	}
+
+	index := jsonIndex(data["output_index"])
+	switch data["type"] {
+	case "response.output_text.done":
+		if text, ok := f.texts[index]; ok {
+			data["text"] = ""
+			if jsonIndex(data["content_index"]) == 0 {
+				data["text"] = text.String()
+			}
+		}
+	case "response.content_part.done":
+		if part, _ := data["part"].(map[string]interface{}); part != nil {
+			setParts(index, []interface{}{part})
+			if jsonIndex(data["content_index"]) > 0 && part["type"] == "output_text" {
+				part["text"] = ""
+			}
+		}
+	case "response.output_item.done":
+		if item, _ := data["item"].(map[string]interface{}); item != nil {
+			content, _ := item["content"].([]interface{})
+			setParts(index, content)
+		}
+	case "response.completed", "response.incomplete", "response.failed":
+		response, _ := data["response"].(map[string]interface{})
+		output, _ := response["output"].([]interface{})
+		for i, raw := range output {
+			if item, _ := raw.(map[string]interface{}); item["type"] == "message" {
+				content, _ := item["content"].([]interface{})
+				setParts(i, content)
+			}
+		}
+	}
+}
+
+// sentText returns the text sent for a message item of a Responses stream
+func (f *outputFormat) sentText(index int) *strings.Builder {
+	text, ok := f.texts[index]
+	if !ok {
+		text = &strings.Builder{}
+		f.texts[index] = text
+	}
+	return text
+}
+
+// responsesIncompleteReason names a finish reason as the reason a Responses
+// response is incomplete
+func responsesIncompleteReason(reason string) string {
+	if reason == "content_filter" {
+		return "content_filter"
+	}
+	return "max_output_tokens"
+}
+
+// geminiChoices returns the text parts of each candidate of a Gemini response
+func (f *outputFormat) geminiChoices(resp map[string]interface{}) []*outputChoice {
+	var out []*outputChoice
+	candidates, _ := resp["candidates"].([]interface{})
+	for _, raw := range candidates {
+		candidate, _ := raw.(map[string]interface{})
+		if candidate == nil {
+			continue
+		}
+		finishReason, _ := candidate["finishReason"].(string)
+		oc := &outputChoice{setFinish: func(reason string) { candidate["finishReason"] = geminiFinishReason(reason) }}
+		calls := false
+		for _, part := range geminiParts(candidate) {
+			if part["functionCall"] != nil {
+				calls = true
+			}
+			if text, ok := part["text"].(string); ok && part["thought"] != true {
+				part := part
+				oc.parts = append(oc.parts, &outputText{text: text, set: func(text string) { part["text"] = text }})
+			}
+		}
+		oc.finish = geminiFinish(finishReason, calls)
+		out = append(out, oc)
+	}
+	return out
+}
+
+// geminiEvent parses the text and finish reason of each candidate of a Gemini
+// stream chunk
+func (f *outputFormat) geminiEvent(ev *streamEvent) {
+	ev.list, ev.usage = "candidates", "usageMetadata"
+	candidates, _ := ev.data["candidates"].([]interface{})
+	for i, raw := range candidates {
+		candidate, _ := raw.(map[string]interface{})
+		if candidate == nil {
+			continue
+		}
+		index := i
+		if _, ok := candidate["index"]; ok {
+			index = jsonIndex(candidate["index"])
+		}
+		ch := &streamChoice{item: i, index: index}
+		var textParts []map[string]interface{}
+		for _, part := range geminiParts(candidate) {
+			if part["functionCall"] != nil {
+				f.calls[index] = true
+			}
+			if text, ok := part["text"].(string); ok && part["thought"] != true {
+				ch.text += text
+				ch.hasText = true
+				textParts = append(textParts, part)
+			}
+		}
+		ch.setText = func(text string) {
+			for i, part := range textParts {
+				part["text"] = ""
+				if i == 0 {
+					part["text"] = text
+				}
+			}
+			if len(textParts) == 0 {
+				content, _ := candidate["content"].(map[string]interface{})
+				if content == nil {
+					content = map[string]interface{}{"role": "model"}
+					candidate["content"] = content
+				}
+				parts, _ := content["parts"].([]interface{})
+				content["parts"] = append(parts, map[string]interface{}{"text": text})
+			}
+		}
+		if finishReason, _ := candidate["finishReason"].(string); finishReason != "" {
+			ch.finish = geminiFinish(finishReason, f.calls[index])
+			ch.ended = true
+		}
+		ch.setFinish = func(reason string) { candidate["finishReason"] = geminiFinishReason(reason) }
+		ev.choices = append(ev.choices, ch)
+	}
+}
+
+// geminiParts returns the content parts of a candidate
+func geminiParts(candidate map[string]interface{}) []map[string]interface{} {
+	content, _ := candidate["content"].(map[string]interface{})
+	raw, _ := content["parts"].([]interface{})
+	parts := make([]map[string]interface{}, 0, len(raw))
+	for _, item := range raw {
+		if part, _ := item.(map[string]interface{}); part != nil {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// geminiFinish names a Gemini finish reason as a finish reason
+func geminiFinish(finishReason string, calls bool) string {
+	switch finishReason {
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "content_filter"
+	}
+	if calls {
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+// geminiFinishReason names a finish reason as a Gemini finish reason
+func geminiFinishReason(reason string) string {
+	switch reason {
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+// jsonIndex returns a decoded JSON index, 0 when it is missing
+func jsonIndex(v interface{}) int {
+	n, _ := v.(float64)
+	return int(n)
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[int]string) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
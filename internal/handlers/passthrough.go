@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/adapters"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// passthroughRequestHeaders are client headers relayed unchanged to the upstream
+var passthroughRequestHeaders = []string{
+	echo.HeaderContentType,
+	echo.HeaderAccept,
+	"OpenAI-Beta",
+}
+
+// isOpenAICompatibleConfig reports whether a provider config speaks the OpenAI REST surface
+func isOpenAICompatibleConfig(cfg *database.ProviderConfig) bool {
+	if cfg.Provider == "openai" {
+		return true
+	}
+	protocol := normalizeProtocol(cfg.Protocol)
+	return protocol == "openai_chat" || protocol == "openai_code"
+}
+
+// resolveOpenAIPassthroughConfig picks the provider config used for non-model endpoints
+// such as files. Configs with provider "openai" win over other OpenAI-compatible ones.
+func (h *Handler) resolveOpenAIPassthroughConfig(c echo.Context) (*database.ProviderConfig, error) {
+	if cfg := middleware.GetProviderConfig(c); cfg != nil {
+		if !cfg.IsActive {
+			return nil, fmt.Errorf("provider config is inactive")
+		}
+		if !isOpenAICompatibleConfig(cfg) {
+			return nil, fmt.Errorf("provider config %d is not OpenAI-compatible", cfg.ID)
+		}
+		if pause, _ := h.maintenanceService.ProviderConfig(cfg.ID); pause != nil {
+			return nil, pausedConfigError(c, pause)
+		}
+		return cfg, nil
+	}
+
+	if apiKey := middleware.GetAPIKey(c); apiKey != nil {
+		var openai, others []*database.ProviderConfig
+		for i := range apiKey.ProviderConfigs {
+			cfg := &apiKey.ProviderConfigs[i]
+			if !cfg.IsActive || !isOpenAICompatibleConfig(cfg) {
+				continue
+			}
+			if cfg.Provider == "openai" {
+				openai = append(openai, cfg)
+			} else {
+				others = append(others, cfg)
+			}
+		}
+		if len(openai)+len(others) == 0 {
+			return nil, fmt.Errorf("API key has no active OpenAI-compatible provider config")
+		}
+		candidates, err := h.withoutPausedConfigs(c, append(openai, others...))
+		if err != nil {
+			return nil, err
+		}
+		return candidates[0], nil
+	}
+
+	user := middleware.GetUser(c)
+	if user == nil {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	cfg, err := h.configService.GetDefaultConfig(user.ID, "openai")
+	if err != nil {
+		return nil, fmt.Errorf("no openai configuration found")
+	}
+	return cfg, nil
+}
+
+// proxyOpenAI relays the current request to upstreamPath on the resolved OpenAI-compatible
+// provider and streams the upstream response back unchanged
+func (h *Handler) proxyOpenAI(c echo.Context, upstreamPath, endpoint string) error {
+	resp, err := h.forwardOpenAI(c, upstreamPath, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return h.relayUpstreamResponse(c, resp, endpoint, nil)
+}
+
+// forwardOpenAI sends the current request to upstreamPath using the resolved provider
+// config. extraHeader values are added after the client headers. The caller must close
+// the response body.
+func (h *Handler) forwardOpenAI(c echo.Context, upstreamPath string, extraHeader http.Header) (*http.Response, error) {
+	tag := "Passthrough"
+	middleware.LogTrace(c, tag, "Proxying %s %s -> %s", c.Request().Method, c.Request().URL.Path, upstreamPath)
+
+	providerCfg, err := h.resolveOpenAIPassthroughConfig(c)
+	if err != nil {
+		middleware.LogTrace(c, tag, "Failed to resolve provider config: %v", err)
+		return nil, resolveProviderError(err)
+	}
+
+	apiKey, err := h.configService.DecryptAPIKey(providerCfg)
+	if err != nil {
+		middleware.LogTrace(c, tag, "Failed to decrypt API key: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to load provider credentials")
+	}
+
+	baseURL := providerCfg.BaseURL
+	if baseURL == "" {
+		baseURL = h.cfg.OpenAIBaseURL
+	}
+
+	req := c.Request()
+	header := http.Header{}
+	for _, name := range passthroughRequestHeaders {
+		if value := req.Header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	for name, values := range extraHeader {
+		if header.Get(name) == "" {
+			header[name] = values
+		}
+	}
+
+	timeout := time.Duration(h.cfg.HTTPTimeout) * time.Second
+	adapter := adapters.NewOpenAIAdapterWithConfig(apiKey, baseURL, timeout)
+	resp, err := adapter.Forward(req.Context(), &adapters.ForwardRequest{
+		Method:        req.Method,
+		Path:          upstreamPath,
+		RawQuery:      req.URL.RawQuery,
+		Body:          req.Body,
+		ContentLength: req.ContentLength,
+		Header:        header,
+	})
+	if err != nil {
+		middleware.LogTrace(c, tag, "Upstream error: %v", err)
+		return nil, echo.NewHTTPError(http.StatusBadGateway, err.Error())
+	}
+
+	middleware.LogTrace(c, tag, "Upstream responded: statusCode=%d, configID=%d", resp.StatusCode, providerCfg.ID)
+	return resp, nil
+}
+
+// relayUpstreamResponse copies an upstream response to the client. JSON bodies are
+// decoded so usage can be recorded and, when onJSON is set, inspected or rewritten
+// before being sent. SSE bodies are relayed line by line with a flush per event;
+// onJSON then only observes each decoded event.
+func (h *Handler) relayUpstreamResponse(c echo.Context, resp *http.Response, endpoint string, onJSON func(map[string]interface{})) error {
+	contentType := resp.Header.Get(echo.HeaderContentType)
+	if disposition := resp.Header.Get(echo.HeaderContentDisposition); disposition != "" {
+		c.Response().Header().Set(echo.HeaderContentDisposition, disposition)
+	}
+
+	switch {
+	case strings.HasPrefix(contentType, "text/event-stream"):
+		return h.relayUpstreamStream(c, resp, endpoint, onJSON)
+	case strings.HasPrefix(contentType, echo.MIMEApplicationJSON):
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+		}
+		var payload map[string]interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			h.recordPassthroughUsage(c, endpoint, nil, resp.StatusCode)
+			return c.Blob(resp.StatusCode, contentType, body)
+		}
+		h.recordPassthroughUsage(c, endpoint, payload, resp.StatusCode)
+		if onJSON == nil {
+			return c.Blob(resp.StatusCode, contentType, body)
+		}
+		onJSON(payload)
+		return c.JSON(resp.StatusCode, payload)
+	default:
+		h.recordPassthroughUsage(c, endpoint, nil, resp.StatusCode)
+		if contentType == "" {
+			contentType = echo.MIMEOctetStream
+		}
+		return c.Stream(resp.StatusCode, contentType, resp.Body)
+	}
+}
+
+// relayUpstreamStream relays an SSE body and records usage from the last event carrying it
+func (h *Handler) relayUpstreamStream(c echo.Context, resp *http.Response, endpoint string, onEvent func(map[string]interface{})) error {
+	c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().Header().Set("Connection", "keep-alive")
+	c.Response().WriteHeader(resp.StatusCode)
+
+	var usagePayload map[string]interface{}
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			c.Response().Write([]byte(line))
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" {
+				c.Response().Flush()
+			} else if strings.HasPrefix(trimmed, "data:") {
+				data := strings.TrimSpace(strings.TrimPrefix(trimmed, "data:"))
+				var event map[string]interface{}
+				if json.Unmarshal([]byte(data), &event) == nil {
+					if onEvent != nil {
+						onEvent(event)
+					}
+					if _, ok := event["usage"].(map[string]interface{}); ok {
+						usagePayload = event
+					}
+				}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				middleware.LogTrace(c, "Passthrough", "Stream read error: %v", err)
+			}
+			break
+		}
+	}
+	c.Response().Flush()
+
+	h.recordPassthroughUsage(c, endpoint, usagePayload, resp.StatusCode)
+	return nil
+}
+
+// recordPassthroughUsage records a passthrough call, including token usage when the
+// upstream payload carries it (e.g. completed runs)
+func (h *Handler) recordPassthroughUsage(c echo.Context, endpoint string, payload map[string]interface{}, statusCode int) {
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	model, _ := payload["model"].(string)
+	h.recordUsage(c, endpoint, model, payload, statusCode)
+}
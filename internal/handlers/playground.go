@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"ai_gateway/internal/converters"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// PlaygroundChatRequest is a chat completion request pinned to one of the user's provider configs
+type PlaygroundChatRequest struct {
+	ProviderConfigID uint `json:"provider_config_id"`
+	models.ChatCompletionRequest
+}
+
+// PlaygroundChatResponse shows the converted upstream request next to the gateway response
+type PlaygroundChatResponse struct {
+	ProviderConfigID uint            `json:"provider_config_id"`
+	Provider         string          `json:"provider"`
+	Protocol         string          `json:"protocol"`
+	UpstreamRequest  interface{}     `json:"upstream_request"`
+	StatusCode       int             `json:"status_code"`
+	Response         json.RawMessage `json:"response"`
+}
+
+// PlaygroundChat handles POST /api/playground/chat. Non-streaming requests return a
+// PlaygroundChatResponse; streaming requests emit an "upstream_request" SSE event
+// followed by the normal chat completion chunks.
+func (h *Handler) PlaygroundChat(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req PlaygroundChatRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if req.ProviderConfigID == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "provider_config_id is required")
+	}
+
+	providerCfg, err := h.configService.GetConfigByID(user.ID, req.ProviderConfigID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "provider config not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	chatReq := &req.ChatCompletionRequest
+	chatReq.ConversationID = ""
+	if chatReq.Model == "" {
+		if modelCodes, _ := h.configService.GetModelCodes(providerCfg); len(modelCodes) > 0 {
+			chatReq.Model = modelCodes[0]
+		}
+	}
+
+	c.Set(middleware.ContextKeyProviderConfig, providerCfg)
+	baseURL, apiKey, protocol, err := h.getCredentials(c, providerCfg.Provider, chatReq.Model)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	upstreamReq, err := playgroundUpstreamRequest(chatReq, protocol)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	middleware.LogTrace(c, "Playground", "user=%d config=%d protocol=%s model=%s stream=%v", user.ID, providerCfg.ID, protocol, chatReq.Model, chatReq.Stream)
+
+	if chatReq.Stream {
+		preamble, err := json.Marshal(upstreamReq)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		writer := &playgroundStreamWriter{
+			ResponseWriter: c.Response().Writer,
+			preamble:       []byte("event: upstream_request\ndata: " + string(preamble) + "\n\n"),
+		}
+		c.Response().Writer = writer
+		defer func() { c.Response().Writer = writer.ResponseWriter }()
+		return h.dispatchChatCompletion(c, chatReq, baseURL, apiKey, protocol)
+	}
+
+	// Buffer the gateway response so it can be wrapped with the upstream request
+	original := c.Response().Writer
+	capture := &playgroundCaptureWriter{header: http.Header{}, status: http.StatusOK}
+	c.Response().Writer = capture
+	dispatchErr := h.dispatchChatCompletion(c, chatReq, baseURL, apiKey, protocol)
+	c.Response().Writer = original
+	c.Response().Committed = false
+	if dispatchErr != nil {
+		return dispatchErr
+	}
+
+	body := capture.body.Bytes()
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+	return c.JSON(http.StatusOK, PlaygroundChatResponse{
+		ProviderConfigID: providerCfg.ID,
+		Provider:         providerCfg.Provider,
+		Protocol:         protocol,
+		UpstreamRequest:  upstreamReq,
+		StatusCode:       capture.status,
+		Response:         body,
+	})
+}
+
+// playgroundUpstreamRequest converts a chat request into the body sent to the upstream protocol
+func playgroundUpstreamRequest(req *models.ChatCompletionRequest, protocol string) (interface{}, error) {
+	switch protocol {
+	case "openai_code":
+		return converters.OpenAIChatToOpenAIResponsesRequest(req)
+	case "anthropic":
+		return converters.OpenAIToAnthropicRequest(req)
+	case "gemini":
+		return converters.OpenAIToGeminiRequest(req)
+	default:
+		return req, nil
+	}
+}
+
+// playgroundStreamWriter writes the upstream_request event right after the headers
+type playgroundStreamWriter struct {
+	http.ResponseWriter
+	preamble []byte
+	sent     bool
+}
+
+func (w *playgroundStreamWriter) WriteHeader(code int) {
+	w.ResponseWriter.WriteHeader(code)
+	if !w.sent {
+		w.sent = true
+		if code == http.StatusOK {
+			w.ResponseWriter.Write(w.preamble)
+		}
+	}
+}
+
+func (w *playgroundStreamWriter) Write(b []byte) (int, error) {
+	if !w.sent {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *playgroundStreamWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// playgroundCaptureWriter buffers a response instead of sending it to the client
+type playgroundCaptureWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *playgroundCaptureWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *playgroundCaptureWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *playgroundCaptureWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// Flush is a no-op; the buffered response is sent once complete
+func (w *playgroundCaptureWriter) Flush() {}
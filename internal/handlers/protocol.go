@@ -1,5 +1,18 @@
 package handlers
 
+import (
+	"net/http"
+
+	"ai_gateway/internal/converters"
+	"ai_gateway/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderToolSchemaWarnings reports, one value per tool, how tool definitions
+// were changed to fit what the upstream accepts
+const HeaderToolSchemaWarnings = "X-Tool-Schema-Warnings"
+
 func normalizeProtocol(protocol string) string {
 	if protocol == "" {
 		return "openai_chat"
@@ -13,3 +26,35 @@ func protocolValue(protocol *string) string {
 	}
 	return *protocol
 }
+
+// stringValue returns the value of an optional string, or "" when it is unset
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// checkStrictConversion rejects a request holding fields its upstream protocol
+// cannot represent when the request's API key enables strict conversion, so
+// they are not silently dropped
+func checkStrictConversion(c echo.Context, protocol string, fields []string) error {
+	apiKey := middleware.GetAPIKey(c)
+	if apiKey == nil || !apiKey.StrictConversion {
+		return nil
+	}
+	if err := converters.UnsupportedFieldsError(protocol, fields); err != nil {
+		middleware.LogTrace(c, "StrictConversion", "Rejecting request: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return nil
+}
+
+// reportToolSchemaWarnings surfaces the changes made to a request's tools to
+// fit its upstream in debug headers
+func reportToolSchemaWarnings(c echo.Context, warnings []string) {
+	for _, warning := range warnings {
+		middleware.LogTrace(c, "ToolSchema", "Sanitized tool %s", warning)
+		c.Response().Header().Add(HeaderToolSchemaWarnings, warning)
+	}
+}
@@ -1,66 +1,196 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"ai_gateway/internal/database"
 	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
 
 	"github.com/labstack/echo/v4"
 )
 
+// HeaderProviderConfig selects the provider config of a request by ID or name,
+// overriding model-based resolution, for API keys with AllowConfigOverride
+const HeaderProviderConfig = "X-Provider-Config"
+
+// Errors of provider resolution
+var (
+	errModelOutOfScope          = errors.New("model not allowed for this token")
+	errConfigOverrideNotAllowed = errors.New(HeaderProviderConfig + " is not allowed for this API key")
+	errConfigOverrideNotFound   = errors.New(HeaderProviderConfig + " does not name an active provider config of this API key")
+	errPolicyConfigUnavailable  = errors.New("routing policy pins a provider config that is not an active config of this API key")
+)
+
 type resolvedProvider struct {
-	Provider string
-	Model    string
-	Config   *database.ProviderConfig
-	Matched  bool
+	Provider   string
+	Model      string
+	Config     *database.ProviderConfig
+	Matched    bool
+	Candidates []*database.ProviderConfig // all active configs serving Model, in routing order
 }
 
+// resolveProviderForAPIKey picks the config of the caller's API key serving
+// model. A "provider/model" name, as LiteLLM and OpenRouter clients send it,
+// picks among the configs its provider segment names and is passed upstream
+// without that segment; such names resolve for JWT callers too. The routing
+// policies of the key's owner are evaluated before anything else, then its
+// context upgrade policies.
 func (h *Handler) resolveProviderForAPIKey(c echo.Context, model string) (*resolvedProvider, error) {
 	apiKey := middleware.GetAPIKey(c)
-	if apiKey == nil {
+	var configs []database.ProviderConfig
+	if apiKey != nil {
+		configs = apiKey.ProviderConfigs
+	} else if user := middleware.GetUser(c); user != nil && strings.Contains(model, "/") {
+		var err error
+		if configs, err = h.configService.GetConfigs(user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	servesModel := func(cfg *database.ProviderConfig, model string) bool {
+		modelCodes, err := h.configService.GetModelCodes(cfg)
+		if err != nil {
+			middleware.LogTrace(c, "ResolveProvider", "Failed to get model codes for config %d: %v", cfg.ID, err)
+			return false
+		}
+		for _, modelCode := range modelCodes {
+			if modelCode == model {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Routing policies decide first: they may reject the request, pin it to a
+	// config or have it request another model
+	clientModel := model
+	if apiKey != nil {
+		policy, err := h.routingPolicyFor(c, apiKey, model)
+		if err != nil {
+			return nil, err
+		}
+		if policy != nil && policy.Model != "" {
+			middleware.LogTrace(c, "ResolveProvider", "Routing policy %q rewrites model=%s to %s", policy.Name, model, policy.Model)
+			model = policy.Model
+		}
+		// A prompt too long for the model goes to its larger-context variant
+		model = h.contextUpgradeFor(c, apiKey, model)
+		if apiKey.KeepRequestedModel || (policy != nil && policy.KeepRequestedModel) {
+			c.Set(middleware.ContextKeyResponseModel, clientModel)
+		}
+		if policy != nil && policy.ProviderConfigID != nil {
+			return h.resolvePolicyConfig(c, apiKey, policy, clientModel, model, servesModel)
+		}
+	}
+
+	if override := strings.TrimSpace(c.Request().Header.Get(HeaderProviderConfig)); override != "" {
+		return h.resolveConfigOverride(c, apiKey, override, clientModel, model, servesModel)
+	}
+
+	requested := model
+	provider, upstream, explicit := explicitProvider(configs, model, servesModel)
+	if explicit {
+		middleware.LogTrace(c, "ResolveProvider", "Model %s names provider %s; resolving model=%s among its configs", requested, provider, upstream)
+		model = upstream
+	} else if apiKey == nil {
 		return nil, nil
 	}
 
 	middleware.LogTrace(c, "ResolveProvider", "Resolving provider for API key model=%s", model)
 
-	if len(apiKey.ProviderConfigs) == 0 {
+	scope := middleware.GetTokenScope(c)
+	if !scope.AllowsModel(clientModel) && !scope.AllowsModel(requested) && !(explicit && scope.AllowsModel(model)) {
+		return nil, errModelOutOfScope
+	}
+
+	if len(configs) == 0 {
 		return nil, fmt.Errorf("API key has no provider configs")
 	}
 
-	var firstActive *database.ProviderConfig
+	var active, matches, capped []*database.ProviderConfig
+	now := time.Now()
 
-	for i := range apiKey.ProviderConfigs {
-		cfg := &apiKey.ProviderConfigs[i]
-		if !cfg.IsActive {
+	for i := range configs {
+		cfg := &configs[i]
+		if !cfg.IsActive || (explicit && !namesConfig(provider, cfg)) {
 			continue
 		}
-		if firstActive == nil {
-			firstActive = cfg
-		}
-
-		modelCodes, err := h.configService.GetModelCodes(cfg)
-		if err != nil {
-			middleware.LogTrace(c, "ResolveProvider", "Failed to get model codes for config %d: %v", cfg.ID, err)
+		if services.SpendCapReached(cfg, now) {
+			middleware.LogTrace(c, "ResolveProvider", "Skipping config ID=%d: monthly spend cap reached", cfg.ID)
+			capped = append(capped, cfg)
 			continue
 		}
+		active = append(active, cfg)
+		if servesModel(cfg, model) {
+			matches = append(matches, cfg)
+		}
+	}
 
-		for _, modelCode := range modelCodes {
-			if modelCode == model {
-				middleware.LogTrace(c, "ResolveProvider", "Matched model=%s to config ID=%d Provider=%s", model, cfg.ID, cfg.Provider)
-				return &resolvedProvider{
-					Provider: cfg.Provider,
-					Model:    model,
-					Config:   cfg,
-					Matched:  true,
-				}, nil
+	// Rather than defaulting to another model, refuse a model whose only
+	// configs are capped
+	if len(matches) == 0 {
+		for _, cfg := range capped {
+			if servesModel(cfg, model) {
+				return nil, services.ErrSpendCapReached
 			}
 		}
 	}
 
-	if firstActive == nil {
+	if len(matches) > 0 {
+		latency := len(matches) > 1 && apiKey != nil && apiKey.RoutingStrategy == services.RoutingLatency
+		if latency {
+			stickyKey := fmt.Sprintf("%d:%s", apiKey.ID, model)
+			matches = h.healthService.Rank(stickyKey, matches)
+		}
+		var err error
+		if matches, err = h.withoutPausedConfigs(c, matches); err != nil {
+			return nil, err
+		}
+		cfg := matches[0]
+		if latency {
+			health, _ := h.healthService.Get(cfg.ID)
+			middleware.LogTrace(c, "ResolveProvider", "Latency routing picked config ID=%d region=%s (healthy=%v latency=%.0fms) from %d candidates",
+				cfg.ID, cfg.Region, health.Healthy, health.LatencyMs, len(matches))
+		}
+		middleware.LogTrace(c, "ResolveProvider", "Matched model=%s to config ID=%d Provider=%s", model, cfg.ID, cfg.Provider)
+		return &resolvedProvider{
+			Provider:   cfg.Provider,
+			Model:      model,
+			Config:     cfg,
+			Matched:    true,
+			Candidates: matches,
+		}, nil
+	}
+
+	if len(active) == 0 {
+		if len(capped) > 0 {
+			return nil, services.ErrSpendCapReached
+		}
 		return nil, fmt.Errorf("API key has no active provider configs")
 	}
+	active, err := h.withoutPausedConfigs(c, active)
+	if err != nil {
+		return nil, err
+	}
+	firstActive := active[0]
+
+	// A model named with its provider is sent as named, as the config's model
+	// codes need not list every model of the provider
+	if explicit {
+		middleware.LogTrace(c, "ResolveProvider", "Passing model=%s to config ID=%d Provider=%s", model, firstActive.ID, firstActive.Provider)
+		return &resolvedProvider{
+			Provider: firstActive.Provider,
+			Model:    model,
+			Config:   firstActive,
+			Matched:  false,
+		}, nil
+	}
 
 	resolvedModel := model
 	modelCodes, err := h.configService.GetModelCodes(firstActive)
@@ -72,6 +202,9 @@ func (h *Handler) resolveProviderForAPIKey(c echo.Context, model string) (*resol
 		middleware.LogTrace(c, "ResolveProvider", "Default config %d has no model codes; keeping model=%s", firstActive.ID, model)
 	}
 
+	if !scope.AllowsModel(resolvedModel) {
+		return nil, errModelOutOfScope
+	}
 	if resolvedModel != model {
 		middleware.LogTrace(c, "ResolveProvider", "No model match for %s; defaulting to config ID=%d Provider=%s model=%s", model, firstActive.ID, firstActive.Provider, resolvedModel)
 	} else {
@@ -85,3 +218,180 @@ func (h *Handler) resolveProviderForAPIKey(c echo.Context, model string) (*resol
 		Matched:  false,
 	}, nil
 }
+
+// resolveConfigOverride resolves a request to the config of the API key the
+// X-Provider-Config header names by ID or name. The model is passed upstream as
+// sent, less a provider segment naming the config.
+func (h *Handler) resolveConfigOverride(c echo.Context, apiKey *database.APIKey, override, clientModel, model string, servesModel func(*database.ProviderConfig, string) bool) (*resolvedProvider, error) {
+	if apiKey == nil || !apiKey.AllowConfigOverride {
+		return nil, errConfigOverrideNotAllowed
+	}
+
+	var cfg *database.ProviderConfig
+	id, idErr := strconv.ParseUint(override, 10, 32)
+	for i := range apiKey.ProviderConfigs {
+		candidate := &apiKey.ProviderConfigs[i]
+		if (idErr == nil && candidate.ID == uint(id)) || strings.EqualFold(candidate.Name, override) {
+			cfg = candidate
+			break
+		}
+	}
+	if cfg == nil || !cfg.IsActive {
+		middleware.LogTrace(c, "ResolveProvider", "%s %q names no active config of API key ID=%d", HeaderProviderConfig, override, apiKey.ID)
+		return nil, errConfigOverrideNotFound
+	}
+	return h.pinConfig(c, cfg, clientModel, model, servesModel, HeaderProviderConfig)
+}
+
+// resolvePolicyConfig resolves a request to the config a routing policy pins
+// it to, which must be an active config of the API key
+func (h *Handler) resolvePolicyConfig(c echo.Context, apiKey *database.APIKey, policy *database.RoutingPolicy, clientModel, model string, servesModel func(*database.ProviderConfig, string) bool) (*resolvedProvider, error) {
+	for i := range apiKey.ProviderConfigs {
+		cfg := &apiKey.ProviderConfigs[i]
+		if cfg.ID == *policy.ProviderConfigID && cfg.IsActive {
+			return h.pinConfig(c, cfg, clientModel, model, servesModel, fmt.Sprintf("Routing policy %q", policy.Name))
+		}
+	}
+	middleware.LogTrace(c, "ResolveProvider", "Routing policy %q pins config ID=%d, not an active config of API key ID=%d", policy.Name, *policy.ProviderConfigID, apiKey.ID)
+	return nil, fmt.Errorf("%w (policy %q)", errPolicyConfigUnavailable, policy.Name)
+}
+
+// pinConfig resolves a request to cfg regardless of the configs serving the
+// model, which is passed upstream less a provider segment naming the config.
+// A paused config refuses the request, failover or not.
+func (h *Handler) pinConfig(c echo.Context, cfg *database.ProviderConfig, clientModel, model string, servesModel func(*database.ProviderConfig, string) bool, by string) (*resolvedProvider, error) {
+	if services.SpendCapReached(cfg, time.Now()) {
+		return nil, services.ErrSpendCapReached
+	}
+	if pause, _ := h.maintenanceService.ProviderConfig(cfg.ID); pause != nil {
+		middleware.LogTrace(c, "ResolveProvider", "%s pins config ID=%d, paused for maintenance", by, cfg.ID)
+		return nil, pausedConfigError(c, pause)
+	}
+
+	scope := middleware.GetTokenScope(c)
+	requested := model
+	if _, upstream, explicit := explicitProvider([]database.ProviderConfig{*cfg}, model, servesModel); explicit {
+		model = upstream
+	}
+	if !scope.AllowsModel(clientModel) && !scope.AllowsModel(requested) && !scope.AllowsModel(model) {
+		return nil, errModelOutOfScope
+	}
+
+	middleware.LogTrace(c, "ResolveProvider", "%s overrides resolution: model=%s to config ID=%d Provider=%s", by, model, cfg.ID, cfg.Provider)
+	return &resolvedProvider{
+		Provider: cfg.Provider,
+		Model:    model,
+		Config:   cfg,
+		Matched:  servesModel(cfg, model),
+	}, nil
+}
+
+// withoutPausedConfigs drops the configs paused for maintenance from
+// candidates in routing order. The request is refused when the config it would
+// go to is paused without failover, or when no candidate is left.
+func (h *Handler) withoutPausedConfigs(c echo.Context, candidates []*database.ProviderConfig) ([]*database.ProviderConfig, error) {
+	var available []*database.ProviderConfig
+	var refusal *services.MaintenanceError
+	for i, cfg := range candidates {
+		pause, failover := h.maintenanceService.ProviderConfig(cfg.ID)
+		if pause == nil {
+			available = append(available, cfg)
+			continue
+		}
+		middleware.LogTrace(c, "ResolveProvider", "Skipping config ID=%d: paused for maintenance (failover=%v)", cfg.ID, failover)
+		if i == 0 && !failover {
+			return nil, pausedConfigError(c, pause)
+		}
+		if refusal == nil {
+			refusal = pause
+		}
+	}
+	if len(available) == 0 {
+		return nil, pausedConfigError(c, refusal)
+	}
+	return available, nil
+}
+
+// pausedConfigError sets the Retry-After header of a request refused by a
+// paused provider config and returns its error
+func pausedConfigError(c echo.Context, pause *services.MaintenanceError) error {
+	c.Response().Header().Set("Retry-After", pause.RetryAfterSeconds())
+	return pause
+}
+
+// routingPolicyFor evaluates the routing policies of the API key's owner
+// against the request. A matching reject policy is returned as its error.
+func (h *Handler) routingPolicyFor(c echo.Context, apiKey *database.APIKey, model string) (*database.RoutingPolicy, error) {
+	promptTokens, _ := promptEstimate(c)
+	policy, err := h.routingPolicyService.Evaluate(apiKey.UserID, &services.RoutingFacts{
+		Model:        model,
+		Endpoint:     c.Request().URL.Path,
+		KeyID:        apiKey.ID,
+		KeyName:      apiKey.Name,
+		Tags:         usageTags(c),
+		PromptTokens: promptTokens,
+		Time:         time.Now(),
+	})
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	middleware.LogTrace(c, "ResolveProvider", "Routing policy %q (ID=%d) matched: action=%s", policy.Name, policy.ID, policy.Action)
+	if policy.Action == services.RoutingActionReject {
+		return nil, &services.RoutingRejection{Policy: policy.Name, Message: policy.Message}
+	}
+	return policy, nil
+}
+
+// explicitProvider splits a "provider/model" name, e.g. "anthropic/claude-3-5-sonnet"
+// or "openrouter/deepseek/deepseek-chat", into the provider segment and the
+// model sent upstream. The name is only split when an active config is named by
+// the segment and none serves the whole name as a model code, so that model
+// codes with slashes keep working.
+func explicitProvider(configs []database.ProviderConfig, model string, servesModel func(*database.ProviderConfig, string) bool) (provider, upstream string, ok bool) {
+	provider, upstream, found := strings.Cut(model, "/")
+	if !found || provider == "" || upstream == "" {
+		return "", "", false
+	}
+	named := false
+	for i := range configs {
+		cfg := &configs[i]
+		if !cfg.IsActive {
+			continue
+		}
+		if servesModel(cfg, model) {
+			return "", "", false
+		}
+		if namesConfig(provider, cfg) {
+			named = true
+		}
+	}
+	return provider, upstream, named
+}
+
+// namesConfig reports whether the provider segment of a model name names a
+// config, by its provider or, for custom providers, its name
+func namesConfig(provider string, cfg *database.ProviderConfig) bool {
+	return strings.EqualFold(cfg.Provider, provider) || strings.EqualFold(cfg.Name, provider)
+}
+
+// resolveProviderError maps a provider resolution error to an HTTP error
+func resolveProviderError(err error) *echo.HTTPError {
+	if errors.Is(err, services.ErrSpendCapReached) {
+		return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+	}
+	if errors.Is(err, errModelOutOfScope) || errors.Is(err, errConfigOverrideNotAllowed) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if errors.Is(err, errConfigOverrideNotFound) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	var rejection *services.RoutingRejection
+	if errors.As(err, &rejection) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	var pause *services.MaintenanceError
+	if errors.Is(err, errPolicyConfigUnavailable) || errors.As(err, &pause) {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// providerUsageSyncError maps provider usage sync service errors to HTTP errors
+func providerUsageSyncError(err error) error {
+	if errors.Is(err, services.ErrProviderUsageSyncNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+}
+
+// ListProviderUsageSyncs lists the user's provider usage syncs
+func (h *Handler) ListProviderUsageSyncs(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	syncs, err := h.providerUsageService.ListSyncs(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get provider usage syncs")
+	}
+	return c.JSON(http.StatusOK, syncs)
+}
+
+// GetProviderUsageSync returns a provider usage sync
+func (h *Handler) GetProviderUsageSync(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid sync ID")
+	}
+
+	sync, err := h.providerUsageService.GetSync(user.ID, uint(id))
+	if err != nil {
+		return providerUsageSyncError(err)
+	}
+	return c.JSON(http.StatusOK, sync)
+}
+
+// CreateProviderUsageSync starts pulling the usage a provider bills for a
+// provider config, with an admin key of the provider's organization
+func (h *Handler) CreateProviderUsageSync(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req services.ProviderUsageSyncRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	sync, err := h.providerUsageService.CreateSync(user.ID, &req)
+	if err != nil {
+		return providerUsageSyncError(err)
+	}
+	return c.JSON(http.StatusCreated, sync)
+}
+
+// UpdateProviderUsageSync changes a provider usage sync
+func (h *Handler) UpdateProviderUsageSync(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid sync ID")
+	}
+
+	var req services.ProviderUsageSyncRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	sync, err := h.providerUsageService.UpdateSync(user.ID, uint(id), &req)
+	if err != nil {
+		return providerUsageSyncError(err)
+	}
+	return c.JSON(http.StatusOK, sync)
+}
+
+// DeleteProviderUsageSync stops a provider usage sync
+func (h *Handler) DeleteProviderUsageSync(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid sync ID")
+	}
+
+	if err := h.providerUsageService.DeleteSync(user.ID, uint(id)); err != nil {
+		return providerUsageSyncError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "provider usage sync deleted"})
+}
+
+// SyncProviderUsage pulls the provider's usage now instead of waiting for the
+// next scheduled pull
+func (h *Handler) SyncProviderUsage(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid sync ID")
+	}
+
+	sync, err := h.providerUsageService.GetSync(user.ID, uint(id))
+	if err != nil {
+		return providerUsageSyncError(err)
+	}
+	if err := h.providerUsageService.Sync(c.Request().Context(), sync); err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+	}
+
+	sync, err = h.providerUsageService.GetSync(user.ID, uint(id))
+	if err != nil {
+		return providerUsageSyncError(err)
+	}
+	return c.JSON(http.StatusOK, sync)
+}
+
+// GetProviderUsageReconciliation compares the usage the provider reported with
+// the usage the gateway recorded, per UTC day, over the last ?days= days (default 30)
+func (h *Handler) GetProviderUsageReconciliation(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid sync ID")
+	}
+
+	days := 30
+	if raw := c.QueryParam("days"); raw != "" {
+		if days, err = strconv.Atoi(raw); err != nil || days < 1 || days > 366 {
+			return echo.NewHTTPError(http.StatusBadRequest, "days must be between 1 and 366")
+		}
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, 1-days)
+	report, err := h.providerUsageService.Reconcile(user.ID, uint(id), since)
+	if err != nil {
+		return providerUsageSyncError(err)
+	}
+	return c.JSON(http.StatusOK, report)
+}
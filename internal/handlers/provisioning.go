@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderProvisionResult reports what a provisioning PUT did: created, updated
+// or unchanged
+const HeaderProvisionResult = "X-Provision-Result"
+
+// provisionName returns the name a provisioning request is for, rejecting a
+// body naming another resource
+func provisionName(c echo.Context, bodyName string) (string, error) {
+	name := strings.TrimSpace(c.Param("name"))
+	if name == "" {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "name is required")
+	}
+	if bodyName != "" && strings.TrimSpace(bodyName) != name {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "name in the body must match the path")
+	}
+	return name, nil
+}
+
+// provisionError maps provisioning errors to HTTP errors, leaving the others
+// to the resource's own mapping
+func provisionError(err error, otherwise func(error) error) error {
+	if errors.Is(err, services.ErrNameNotUnique) || errors.Is(err, services.ErrProviderImmutable) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return otherwise(err)
+}
+
+// provisionResponse sends the provisioned resource, with 201 Created when it
+// is new
+func provisionResponse(c echo.Context, result string, body interface{}) error {
+	c.Response().Header().Set(HeaderProvisionResult, result)
+	if result == services.ProvisionCreated {
+		return c.JSON(http.StatusCreated, body)
+	}
+	return c.JSON(http.StatusOK, body)
+}
+
+// internalError reports an error as a server error, as the create and update
+// endpoints of provider configs and API keys do
+func internalError(err error) error {
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+// ProvisionProviderConfig creates or updates the provider config with the name
+// in the path to match the body, a full description of it as for creation
+func (h *Handler) ProvisionProviderConfig(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req ProviderConfigRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	name, err := provisionName(c, req.Name)
+	if err != nil {
+		return err
+	}
+	if req.Provider == "" || req.APIKey == nil || *req.APIKey == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "provider and api_key are required")
+	}
+
+	serviceReq := &services.ProviderConfigCreate{
+		Provider:   req.Provider,
+		Name:       name,
+		BaseURL:    stringValue(req.BaseURL),
+		Protocol:   protocolValue(req.Protocol),
+		Region:     strings.TrimSpace(stringValue(req.Region)),
+		APIKey:     *req.APIKey,
+		ModelCodes: req.ModelCodes,
+
+		ReasoningEffort:   stringValue(req.ReasoningEffort),
+		ReasoningSummary:  stringValue(req.ReasoningSummary),
+		ReasoningOverride: req.ReasoningOverride != nil && *req.ReasoningOverride,
+		MaxOutputTokens:   req.MaxOutputTokens,
+
+		MonthlyTokenCap: req.MonthlyTokenCap,
+		MonthlyCostCap:  req.MonthlyCostCap,
+
+		ActiveSchedule:   stringValue(req.ActiveSchedule),
+		ScheduleTimezone: stringValue(req.ScheduleTimezone),
+	}
+
+	cfg, result, err := h.configService.ProvisionConfig(user.ID, serviceReq)
+	if err != nil {
+		return provisionError(err, internalError)
+	}
+
+	response := h.providerConfigResponse(cfg)
+	if result != services.ProvisionUnchanged || req.ValidateKey {
+		response.Warnings = h.providerKeyWarnings(c, cfg, *req.APIKey, req.ValidateKey)
+	}
+	return provisionResponse(c, result, response)
+}
+
+// providerConfigResponse converts a database ProviderConfig to its response
+func (h *Handler) providerConfigResponse(cfg *database.ProviderConfig) ProviderConfigResponse {
+	modelCodes, _ := h.configService.GetModelCodes(cfg)
+	return ProviderConfigResponse{
+		ID:         cfg.ID,
+		Provider:   cfg.Provider,
+		Name:       cfg.Name,
+		BaseURL:    cfg.BaseURL,
+		Protocol:   normalizeProtocol(cfg.Protocol),
+		Region:     cfg.Region,
+		KeyHint:    cfg.KeyHint,
+		ModelCodes: modelCodes,
+		IsDefault:  cfg.IsDefault,
+		IsActive:   cfg.IsActive,
+
+		ReasoningEffort:   cfg.ReasoningEffort,
+		ReasoningSummary:  cfg.ReasoningSummary,
+		ReasoningOverride: cfg.ReasoningOverride,
+		MaxOutputTokens:   cfg.MaxOutputTokens,
+		ActiveSchedule:    cfg.ActiveSchedule,
+		ScheduleTimezone:  cfg.ScheduleTimezone,
+
+		Spend: configSpend(cfg),
+	}
+}
+
+// ProvisionAPIKey creates or updates the API key with the name in the path to
+// match the body, a full description of it as for creation. Only a created
+// key's response holds the key itself.
+func (h *Handler) ProvisionAPIKey(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req APIKeyCreateRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	name, err := provisionName(c, req.Name)
+	if err != nil {
+		return err
+	}
+	if len(req.ProviderConfigIDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "provider_config_ids is required")
+	}
+
+	serviceReq := &services.APIKeyCreate{
+		ProviderConfigIDs:   req.ProviderConfigIDs,
+		Name:                name,
+		ExpiresAt:           req.ExpiresAt,
+		DailyRequestLimit:   req.DailyRequestLimit,
+		MonthlyRequestLimit: req.MonthlyRequestLimit,
+		DailyTokenLimit:     req.DailyTokenLimit,
+		MonthlyTokenLimit:   req.MonthlyTokenLimit,
+		ArchiveEnabled:      req.ArchiveEnabled,
+		BodyLogDisabled:     req.BodyLogDisabled,
+		RoutingStrategy:     req.RoutingStrategy,
+		SchemaRepairRetries: req.SchemaRepairRetries,
+		LatencyBudgetMs:     req.LatencyBudgetMs,
+		AllowConfigOverride: req.AllowConfigOverride,
+		CompressionTokens:   req.CompressionTokens,
+		CompressionModel:    req.CompressionModel,
+		KeepRequestedModel:  req.KeepRequestedModel,
+		StrictConversion:    req.StrictConversion,
+		ValidateToolArgs:    req.ValidateToolArgs,
+		MaxResponseTokens:   req.MaxResponseTokens,
+		ForbiddenLanguages:  req.ForbiddenLanguages,
+		ResponseSuffix:      req.ResponseSuffix,
+		InjectionDetection:  req.InjectionDetection,
+		InjectionThreshold:  req.InjectionThreshold,
+		SalvageStreams:      req.SalvageStreams,
+		AutoContinueTokens:  req.AutoContinueTokens,
+	}
+
+	key, fullKey, result, err := h.apiKeyService.ProvisionAPIKey(user.ID, serviceReq)
+	if err != nil {
+		return provisionError(err, internalError)
+	}
+	if result == services.ProvisionCreated {
+		return provisionResponse(c, result, APIKeyCreateResponse{
+			APIKeyResponse: toAPIKeyResponse(key),
+			Key:            fullKey,
+		})
+	}
+	return provisionResponse(c, result, toAPIKeyResponse(key))
+}
+
+// ProvisionRoutingPolicy creates or updates the routing policy with the name
+// in the path to match the body
+func (h *Handler) ProvisionRoutingPolicy(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req services.RoutingPolicyRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	name, err := provisionName(c, req.Name)
+	if err != nil {
+		return err
+	}
+	req.Name = name
+
+	policy, result, err := h.routingPolicyService.ProvisionPolicy(user.ID, &req)
+	if err != nil {
+		return provisionError(err, routingPolicyError)
+	}
+	return provisionResponse(c, result, policy)
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"ai_gateway/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// applyReasoningPolicy prepares a request for the OpenAI Responses API. Requests
+// converted from other formats are not stored upstream unless the client asks
+// for it or runs them in the background, which needs them stored to be polled,
+// and the reasoning settings of the provider config the request was routed to
+// fill in those the client left unset, or replace them when the config
+// overrides the client.
+func applyReasoningPolicy(c echo.Context, req map[string]interface{}) {
+	if req == nil {
+		return
+	}
+	if _, ok := req["store"]; !ok {
+		background, _ := req["background"].(bool)
+		req["store"] = background
+	}
+
+	cfg := middleware.GetProviderConfig(c)
+	if cfg == nil {
+		return
+	}
+	reasoning, _ := req["reasoning"].(map[string]interface{})
+	if reasoning == nil {
+		reasoning = map[string]interface{}{}
+	}
+	set := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if _, ok := reasoning[key]; ok && !cfg.ReasoningOverride {
+			return
+		}
+		reasoning[key] = value
+	}
+	set("effort", cfg.ReasoningEffort)
+	set("summary", cfg.ReasoningSummary)
+	if len(reasoning) > 0 {
+		req["reasoning"] = reasoning
+	}
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ai_gateway/internal/adapters"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+	"ai_gateway/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// requestLogsLimit is the page size of request logs without a limit
+const requestLogsLimit = 50
+
+// RequestLogService returns the service storing the request log
+func (h *Handler) RequestLogService() *services.RequestLogService {
+	return h.requestLogService
+}
+
+// requestLogError maps request log service errors to HTTP errors
+func requestLogError(err error) error {
+	if errors.Is(err, services.ErrRequestLogNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return listError(err)
+}
+
+// ListRequestLogs lists the user's request log records, newest first
+func (h *Handler) ListRequestLogs(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	q, err := listQuery(c, requestLogsLimit)
+	if err != nil {
+		return err
+	}
+	logs, total, err := h.requestLogService.ListRequestLogs(user.ID, q)
+	if err != nil {
+		return requestLogError(err)
+	}
+	setListTotal(c, total)
+	return c.JSON(http.StatusOK, logs)
+}
+
+// GetRequestLog returns a request log record
+func (h *Handler) GetRequestLog(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid log ID")
+	}
+
+	entry, err := h.requestLogService.GetRequestLog(user.ID, uint(id))
+	if err != nil {
+		return requestLogError(err)
+	}
+	return c.JSON(http.StatusOK, entry)
+}
+
+// RequestLogReplayRequest optionally replaces the logged request body of a replay
+type RequestLogReplayRequest struct {
+	Body json.RawMessage `json:"body"`
+}
+
+// RequestLogReplayResponse compares the logged response of a request with the
+// response to its replay. Both are redacted, streamed ones assembled into the
+// message they carry, and JSON ones indented with sorted keys for the diff.
+type RequestLogReplayResponse struct {
+	LogID            uint   `json:"log_id"`
+	TraceID          string `json:"trace_id"` // of the replay
+	Model            string `json:"model"`
+	ProviderConfigID *uint  `json:"provider_config_id,omitempty"` // config the replay was routed to
+	Streamed         bool   `json:"streamed"`
+	OldStatusCode    int    `json:"old_status_code"`
+	NewStatusCode    int    `json:"new_status_code"`
+	OldResponse      string `json:"old_response"`
+	NewResponse      string `json:"new_response"`
+	Identical        bool   `json:"identical"`
+	Diff             string `json:"diff"`          // "-" lines of the old response, "+" lines of the new one
+	OldTruncated     bool   `json:"old_truncated"` // the logged bodies were cut short
+}
+
+// replayHandler returns the gateway handler serving a logged request path,
+// and the model of Gemini paths
+func (h *Handler) replayHandler(path string) (echo.HandlerFunc, string) {
+	switch path {
+	case "/v1/chat/completions":
+		return h.OpenAIChatCompletions, ""
+	case "/v1/responses":
+		return h.OpenAICodeResponses, ""
+	case "/v1/messages":
+		return h.AnthropicMessages, ""
+	}
+	for _, prefix := range []string{"/v1/models/", "/v1beta/models/"} {
+		if model := strings.TrimPrefix(path, prefix); model != path && model != "" {
+			return h.GeminiGenerateContent, model
+		}
+	}
+	return nil, ""
+}
+
+// ReplayRequestLog handles POST /api/logs/:id/replay. It sends a logged
+// request again, or an edited body in its place, as the API key that made it
+// and through current routing, and returns a diff of the old and new response.
+func (h *Handler) ReplayRequestLog(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid log ID")
+	}
+
+	var req RequestLogReplayRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	entry, err := h.requestLogService.GetRequestLog(user.ID, uint(id))
+	if err != nil {
+		return requestLogError(err)
+	}
+	handler, geminiModel := h.replayHandler(entry.Path)
+	if handler == nil || entry.Method != http.MethodPost {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s %s requests cannot be replayed", entry.Method, entry.Path))
+	}
+
+	body := []byte(req.Body)
+	if len(body) == 0 {
+		body = []byte(entry.RequestBody)
+	}
+	if !json.Valid(body) {
+		return echo.NewHTTPError(http.StatusBadRequest, "the logged request body is incomplete; send the body to replay")
+	}
+
+	var apiKey *database.APIKey
+	if entry.APIKeyID != nil {
+		apiKey, err = h.apiKeyService.GetAPIKeyByID(user.ID, *entry.APIKeyID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusConflict, "the API key of the logged request no longer exists")
+		}
+		if !apiKey.IsActive {
+			return echo.NewHTTPError(http.StatusConflict, "the API key of the logged request is inactive")
+		}
+		apiKey.User = *user
+	}
+
+	// The replay runs the gateway handler in a context of its own, authenticated
+	// as the original caller
+	traceID := middleware.GenerateTraceID()
+	replayReq, err := http.NewRequestWithContext(adapters.WithRequestID(c.Request().Context(), traceID), http.MethodPost, entry.Path, bytes.NewReader(body))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	replayReq.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	capture := &playgroundCaptureWriter{header: http.Header{}, status: http.StatusOK}
+	rc := c.Echo().NewContext(replayReq, capture)
+	if geminiModel != "" {
+		rc.SetParamNames("model")
+		rc.SetParamValues(geminiModel)
+	}
+	rc.Set(middleware.ContextKeyTraceID, traceID)
+	rc.Set("db", h.db)
+	rc.Set(middleware.ContextKeyUser, user)
+	if apiKey != nil {
+		rc.Set(middleware.ContextKeyAPIKey, apiKey)
+	}
+
+	middleware.LogTrace(c, "Replay", "Replaying request log %d (%s) as trace %s", entry.ID, entry.Path, traceID)
+	resp := &RequestLogReplayResponse{
+		LogID:         entry.ID,
+		TraceID:       traceID,
+		OldStatusCode: entry.StatusCode,
+		NewStatusCode: capture.status,
+		OldResponse:   entry.ResponseBody,
+		OldTruncated:  entry.Truncated,
+	}
+	if err := handler(rc); err != nil {
+		resp.NewStatusCode = http.StatusInternalServerError
+		resp.NewResponse = err.Error()
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			resp.NewStatusCode = httpErr.Code
+			resp.NewResponse = fmt.Sprint(httpErr.Message)
+		}
+	} else {
+		resp.NewStatusCode = capture.status
+		if strings.HasPrefix(capture.header.Get(echo.HeaderContentType), "text/event-stream") {
+			resp.Streamed = true
+			resp.NewResponse = middleware.Redact(services.AssembleStreamText(capture.body.Bytes()))
+		} else {
+			resp.NewResponse = middleware.Redact(strings.TrimSpace(capture.body.String()))
+		}
+	}
+	resp.Model = middleware.GetUpstreamModel(rc)
+	if cfg := middleware.GetProviderConfig(rc); cfg != nil {
+		resp.ProviderConfigID = &cfg.ID
+	}
+
+	resp.OldResponse = canonicalJSON(resp.OldResponse)
+	resp.NewResponse = canonicalJSON(resp.NewResponse)
+	resp.Identical = resp.OldStatusCode == resp.NewStatusCode && resp.OldResponse == resp.NewResponse
+	resp.Diff = utils.DiffLines(resp.OldResponse, resp.NewResponse)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// canonicalJSON indents a JSON document, with sorted object keys, for line
+// diffs and returns other text as is
+func canonicalJSON(s string) string {
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil || decoder.More() {
+		return s
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return s
+	}
+	return string(out)
+}
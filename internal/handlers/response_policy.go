@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// languageSampleSize is how much streamed text is held back to tell the
+// language of a response before any of it is sent
+const languageSampleSize = 400
+
+// applyResponsePolicy routes the response written to c, in the given output
+// format, through the response policy of the API key. The returned function
+// must be called once the handler is done.
+func (h *Handler) applyResponsePolicy(c echo.Context, format string) func() {
+	policy := services.ResponsePolicyFor(middleware.GetAPIKey(c))
+	if policy == nil {
+		return func() {}
+	}
+
+	w := &responsePolicyWriter{
+		ResponseWriter: c.Response().Writer,
+		c:              c,
+		policy:         policy,
+		format:         newOutputFormat(format),
+		checked:        len(policy.ForbiddenLanguages) == 0,
+		sent:           make(map[int]int),
+		cut:            make(map[int]bool),
+	}
+	c.Response().Writer = w
+	return w.finish
+}
+
+// responsePolicyWriter enforces the response policy of an API key on a
+// response: text beyond the token cap is cut and the choice finishes as cut
+// at its length, the suffix is appended to each choice, and a response in a
+// forbidden language is replaced by an error. Streams are held back until
+// enough text arrived to tell their language.
+type responsePolicyWriter struct {
+	http.ResponseWriter
+	c      echo.Context
+	policy *services.ResponsePolicy
+	format *outputFormat
+
+	mode   int
+	status int
+	body   bytes.Buffer // buffered JSON response, or the unterminated tail of the event stream
+
+	checked bool            // the language was checked or needs no check
+	held    []string        // events held back until the language is checked
+	sample  strings.Builder // streamed content for the language check
+	sent    map[int]int     // content bytes sent per choice
+	cut     map[int]bool    // choices cut at the token cap
+	closed  bool            // the stream was blocked or ended at the token cap
+}
+
+func (w *responsePolicyWriter) WriteHeader(status int) {
+	if w.mode != filterUndecided {
+		return
+	}
+	w.status = status
+	contentType := w.Header().Get(echo.HeaderContentType)
+	switch {
+	case status < 200 || status >= 300:
+		w.mode = filterPassthrough
+	case strings.HasPrefix(contentType, "text/event-stream"):
+		w.mode = filterStream
+	case strings.HasPrefix(contentType, echo.MIMEApplicationJSON):
+		w.mode = filterBuffered
+		return
+	default:
+		w.mode = filterPassthrough
+	}
+	if w.mode == filterStream {
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responsePolicyWriter) Write(b []byte) (int, error) {
+	if w.mode == filterUndecided {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch w.mode {
+	case filterBuffered:
+		return w.body.Write(b)
+	case filterStream:
+		w.body.Write(bytes.ReplaceAll(b, []byte("\r"), nil))
+		w.processEvents()
+		return len(b), nil
+	default:
+		return w.ResponseWriter.Write(b)
+	}
+}
+
+func (w *responsePolicyWriter) Flush() {
+	if w.mode == filterBuffered {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the buffered response, or the remainder of a stream that
+// ended without its terminal event, and restores the original writer
+func (w *responsePolicyWriter) finish() {
+	w.c.Response().Writer = w.ResponseWriter
+	switch w.mode {
+	case filterBuffered:
+		w.writeJSON()
+	case filterStream:
+		if rest := strings.TrimSpace(w.body.String()); rest != "" && !w.closed {
+			w.body.Reset()
+			w.processEvent(rest)
+		}
+		if !w.closed {
+			w.checkLanguage(true)
+		}
+	}
+}
+
+// writeJSON applies the policy to the text of a buffered response
+func (w *responsePolicyWriter) writeJSON() {
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.body.Bytes(), &resp); err != nil {
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.body.Bytes())
+		return
+	}
+
+	choices := w.format.choices(resp)
+	var text strings.Builder
+	for _, choice := range choices {
+		for _, part := range choice.parts {
+			text.WriteString(part.text)
+			text.WriteString("\n")
+		}
+	}
+	w.Header().Del("Content-Length")
+	if lang := w.policy.Forbidden(text.String()); lang != "" {
+		middleware.LogTrace(w.c, "ResponsePolicy", "Blocked response in forbidden language %s", lang)
+		out, _ := json.Marshal(w.forbiddenLanguageError(lang, false))
+		w.c.Response().Status = http.StatusBadRequest
+		w.ResponseWriter.WriteHeader(http.StatusBadRequest)
+		w.ResponseWriter.Write(out)
+		return
+	}
+
+	for _, choice := range choices {
+		remaining := w.policy.MaxChars()
+		finish := choice.finish
+		for _, part := range choice.parts {
+			content := part.text
+			if remaining >= 0 {
+				if len(content) > remaining {
+					content = services.TruncateText(content, remaining)
+					finish = "length"
+				}
+				remaining -= len(content)
+			}
+			part.set(content)
+			part.text = content
+		}
+		if finish == "length" && choice.finish != "length" {
+			choice.setFinish("length")
+			middleware.LogTrace(w.c, "ResponsePolicy", "Cut response to %d tokens", w.policy.MaxTokens)
+		}
+		if last := len(choice.parts) - 1; last >= 0 && w.policy.Suffix != "" && finish != "tool_calls" &&
+			!strings.HasSuffix(choice.parts[last].text, w.policy.Suffix) {
+			choice.parts[last].set(choice.parts[last].text + w.policy.Suffix)
+		}
+	}
+
+	out, err := json.Marshal(resp)
+	if err != nil {
+		out = w.body.Bytes()
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(out)
+}
+
+// forbiddenLanguageError is the error replacing a response in a forbidden
+// language
+func (w *responsePolicyWriter) forbiddenLanguageError(lang string, stream bool) map[string]interface{} {
+	message := fmt.Sprintf("the response is in a language this API key does not allow (%s)", lang)
+	return w.format.errorBody(message, "response_policy", "forbidden_language", stream)
+}
+
+// processEvents applies the policy to every complete event in the stream buffer
+func (w *responsePolicyWriter) processEvents() {
+	for {
+		data := w.body.Bytes()
+		idx := bytes.Index(data, []byte("\n\n"))
+		if idx < 0 {
+			return
+		}
+		event := string(data[:idx])
+		w.body.Next(idx + 2)
+		if !w.closed {
+			w.processEvent(event)
+		}
+	}
+}
+
+// processEvent cuts and suffixes the text of one stream event
+func (w *responsePolicyWriter) processEvent(event string) {
+	payload, ok := eventData(event)
+	if !ok {
+		w.send(event)
+		return
+	}
+	if payload == "[DONE]" {
+		if w.checkLanguage(true) {
+			w.writeEvent(event)
+		}
+		return
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		w.send(event)
+		return
+	}
+	ev := w.format.event(data)
+	var before, after []map[string]interface{}
+	for _, ch := range ev.choices {
+		// A choice cut at the token cap has ended for the client
+		if w.cut[ch.index] {
+			ch.dropped = true
+			continue
+		}
+		extra, end := w.applyChoice(ch)
+		before = append(before, extra...)
+		after = append(after, end...)
+	}
+	if !ev.keep() {
+		return
+	}
+
+	for _, extra := range before {
+		w.send(w.format.render(extra))
+	}
+	w.send(w.format.render(data))
+	if len(after) > 0 {
+		// The stream ends at the token cap
+		for _, end := range after {
+			w.send(w.format.render(end))
+		}
+		for _, end := range w.format.terminator() {
+			w.send(end)
+		}
+		w.checkLanguage(true)
+		w.closed = true
+		return
+	}
+	w.checkLanguage(ev.done)
+}
+
+// applyChoice cuts the text of a choice at the token cap and appends the
+// suffix once the choice finishes. It returns the events to send before the
+// event for text it cannot carry, and the events ending the stream when the
+// choice was cut and the event cannot finish it.
+func (w *responsePolicyWriter) applyChoice(ch *streamChoice) (before, end []map[string]interface{}) {
+	content := ch.text
+	if !w.checked {
+		w.sample.WriteString(content)
+	}
+
+	finish := ch.finish
+	if max := w.policy.MaxChars(); max >= 0 && w.sent[ch.index]+len(content) > max {
+		content = services.TruncateText(content, max-w.sent[ch.index])
+		finish = "length"
+		w.cut[ch.index] = true
+		middleware.LogTrace(w.c, "ResponsePolicy", "Cut stream of choice %d to %d tokens", ch.index, w.policy.MaxTokens)
+		if ch.setFinish != nil {
+			ch.setFinish("length")
+		}
+	}
+	w.sent[ch.index] += len(content)
+
+	changed := content != ch.text
+	if finish != "" && finish != "tool_calls" && w.policy.Suffix != "" {
+		content += w.policy.Suffix
+		changed = true
+	}
+	switch {
+	case ch.setText != nil && (ch.hasText || changed):
+		ch.setText(content)
+	case ch.setText == nil && content != "":
+		before = w.format.textEvents(ch.index, content)
+	}
+	if w.cut[ch.index] && ch.setFinish == nil {
+		end = w.format.endEvents(ch.index, "length")
+	}
+	return before, end
+}
+
+// send writes an event, or holds it back until the language is checked
+func (w *responsePolicyWriter) send(event string) {
+	if w.checked {
+		w.writeEvent(event)
+		return
+	}
+	w.held = append(w.held, event)
+}
+
+// checkLanguage checks the language of the held-back stream once enough of it
+// arrived, or at its end, and releases it or replaces it with an error. It
+// reports whether the stream may go on.
+func (w *responsePolicyWriter) checkLanguage(end bool) bool {
+	if w.checked {
+		return !w.closed
+	}
+	if !end && w.sample.Len() < languageSampleSize {
+		return true
+	}
+	w.checked = true
+
+	if lang := w.policy.Forbidden(w.sample.String()); lang != "" {
+		middleware.LogTrace(w.c, "ResponsePolicy", "Blocked stream in forbidden language %s", lang)
+		w.writeEvent(w.format.render(w.forbiddenLanguageError(lang, true)))
+		for _, event := range w.format.terminator() {
+			w.writeEvent(event)
+		}
+		w.held = nil
+		w.closed = true
+		return false
+	}
+	for _, event := range w.held {
+		w.writeEvent(event)
+	}
+	w.held = nil
+	return true
+}
+
+func (w *responsePolicyWriter) writeEvent(event string) {
+	w.ResponseWriter.Write([]byte(event + "\n\n"))
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ai_gateway/internal/database"
+)
+
+func TestResponsePolicy_ChatCompletions(t *testing.T) {
+	const french = "Le chat est sur la table et les enfants sont dans le jardin avec une balle."
+
+	tests := []struct {
+		name        string
+		policy      database.APIKey
+		reply       string
+		wantContent string
+		wantFinish  string
+		wantBlocked bool
+	}{
+		{name: "token cap", policy: database.APIKey{MaxResponseTokens: 2},
+			reply: "Hello world, how are you?", wantContent: "Hello wo", wantFinish: "length"},
+		{name: "suffix", policy: database.APIKey{ResponseSuffix: " [AI]"},
+			reply: "Hello world", wantContent: "Hello world [AI]", wantFinish: "stop"},
+		{name: "cap and suffix", policy: database.APIKey{MaxResponseTokens: 2, ResponseSuffix: " [AI]"},
+			reply: "Hello world, how are you?", wantContent: "Hello wo [AI]", wantFinish: "length"},
+		{name: "allowed language", policy: database.APIKey{ForbiddenLanguages: "de"},
+			reply: french, wantContent: french, wantFinish: "stop"},
+		{name: "forbidden language", policy: database.APIKey{ForbiddenLanguages: "fr"},
+			reply: french, wantBlocked: true},
+	}
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			name := tt.name
+			if stream {
+				name += " stream"
+			}
+			t.Run(name, func(t *testing.T) {
+				reply := chatReply(tt.reply, "stop", 5, 10)
+				if stream {
+					reply = chatStreamReply("chatcmpl-1", tt.reply, "stop", 5, 10)
+				}
+				upstream := newChatUpstream(t, reply)
+				h, apiKey, _ := chatTestHandler(t, upstream.URL, 0)
+				apiKey.MaxResponseTokens = tt.policy.MaxResponseTokens
+				apiKey.ResponseSuffix = tt.policy.ResponseSuffix
+				apiKey.ForbiddenLanguages = tt.policy.ForbiddenLanguages
+
+				body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+				if stream {
+					body = `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+				}
+				rec := postChat(h, apiKey, body)
+
+				var content, finish string
+				if stream {
+					var finishReasons []string
+					content, finishReasons, _ = streamedContent(t, rec.Body.String())
+					if len(finishReasons) > 0 {
+						finish = finishReasons[len(finishReasons)-1]
+					}
+				} else {
+					var resp struct {
+						Choices []struct {
+							Message struct {
+								Content string `json:"content"`
+							} `json:"message"`
+							FinishReason string `json:"finish_reason"`
+						} `json:"choices"`
+					}
+					if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+						t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+					}
+					if len(resp.Choices) == 1 {
+						content, finish = resp.Choices[0].Message.Content, resp.Choices[0].FinishReason
+					}
+					wantStatus := http.StatusOK
+					if tt.wantBlocked {
+						wantStatus = http.StatusBadRequest
+					}
+					if rec.Code != wantStatus {
+						t.Errorf("status = %d, want %d", rec.Code, wantStatus)
+					}
+				}
+
+				blocked := strings.Contains(rec.Body.String(), `"code":"forbidden_language"`)
+				if tt.wantBlocked {
+					if !blocked || strings.Contains(rec.Body.String(), "jardin") {
+						t.Errorf("response not blocked: %s", rec.Body.String())
+					}
+					return
+				}
+				if blocked || content != tt.wantContent || finish != tt.wantFinish {
+					t.Errorf("got %q finished by %q, want %q finished by %q: %s", content, finish, tt.wantContent, tt.wantFinish, rec.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestResponsePolicy_OtherFormats(t *testing.T) {
+	const french = "Le chat est sur la table et les enfants sont dans le jardin avec une balle."
+
+	// How each format reports a reply cut at the token cap and a complete one
+	capped := map[string]string{formatAnthropic: "max_tokens", formatResponses: "incomplete", formatGemini: "MAX_TOKENS"}
+	complete := map[string]string{formatAnthropic: "end_turn", formatResponses: "completed", formatGemini: "STOP"}
+
+	tests := []struct {
+		name        string
+		policy      database.APIKey
+		reply       string
+		wantText    string
+		wantCapped  bool
+		wantBlocked bool
+	}{
+		{name: "cap and suffix", policy: database.APIKey{MaxResponseTokens: 2, ResponseSuffix: " [AI]"},
+			reply: "Hello world, how are you?", wantText: "Hello wo [AI]", wantCapped: true},
+		{name: "suffix", policy: database.APIKey{ResponseSuffix: " [AI]"},
+			reply: "Hello world", wantText: "Hello world [AI]"},
+		{name: "forbidden language", policy: database.APIKey{ForbiddenLanguages: "fr"},
+			reply: french, wantBlocked: true},
+	}
+	for _, format := range []string{formatAnthropic, formatResponses, formatGemini} {
+		for _, tt := range tests {
+			for _, stream := range []bool{false, true} {
+				name := format + " " + tt.name
+				if stream {
+					name += " stream"
+				}
+				t.Run(name, func(t *testing.T) {
+					reply := chatReply(tt.reply, "stop", 5, 10)
+					if stream {
+						reply = chatStreamReply("chatcmpl-1", tt.reply, "stop", 5, 10)
+					}
+					upstream := newChatUpstream(t, reply)
+					h, apiKey, _ := chatTestHandler(t, upstream.URL, 0)
+					apiKey.MaxResponseTokens = tt.policy.MaxResponseTokens
+					apiKey.ResponseSuffix = tt.policy.ResponseSuffix
+					apiKey.ForbiddenLanguages = tt.policy.ForbiddenLanguages
+
+					rec := postFormat(h, apiKey, format, stream)
+					if tt.wantBlocked {
+						if !strings.Contains(rec.Body.String(), "does not allow") || strings.Contains(rec.Body.String(), "jardin") {
+							t.Errorf("response not blocked: %s", rec.Body.String())
+						}
+						if !stream && rec.Code != http.StatusBadRequest {
+							t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+						}
+						return
+					}
+					if rec.Code != http.StatusOK {
+						t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+					}
+					wantEnd := complete[format]
+					if tt.wantCapped {
+						wantEnd = capped[format]
+					}
+					text, end := formatOutput(t, format, stream, rec.Body.String())
+					if text != tt.wantText || end != wantEnd {
+						t.Errorf("got %q ended by %q, want %q ended by %q: %s", text, end, tt.wantText, wantEnd, rec.Body.String())
+					}
+				})
+			}
+		}
+	}
+}
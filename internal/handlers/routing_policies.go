@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// routingPolicyError maps routing policy service errors to HTTP errors
+func routingPolicyError(err error) error {
+	if errors.Is(err, services.ErrRoutingPolicyNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+}
+
+// ListRoutingPolicies lists the user's routing policies in evaluation order
+func (h *Handler) ListRoutingPolicies(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	policies, err := h.routingPolicyService.ListPolicies(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get routing policies")
+	}
+	return c.JSON(http.StatusOK, policies)
+}
+
+// CreateRoutingPolicy adds a policy evaluated on the user's gateway requests
+func (h *Handler) CreateRoutingPolicy(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	var req services.RoutingPolicyRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	policy, err := h.routingPolicyService.CreatePolicy(user.ID, &req)
+	if err != nil {
+		return routingPolicyError(err)
+	}
+	return c.JSON(http.StatusCreated, policy)
+}
+
+// UpdateRoutingPolicy changes a routing policy
+func (h *Handler) UpdateRoutingPolicy(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid policy ID")
+	}
+
+	var req services.RoutingPolicyRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	policy, err := h.routingPolicyService.UpdatePolicy(user.ID, uint(id), &req)
+	if err != nil {
+		return routingPolicyError(err)
+	}
+	return c.JSON(http.StatusOK, policy)
+}
+
+// DeleteRoutingPolicy removes a routing policy
+func (h *Handler) DeleteRoutingPolicy(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid policy ID")
+	}
+
+	if err := h.routingPolicyService.DeletePolicy(user.ID, uint(id)); err != nil {
+		return routingPolicyError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "routing policy deleted"})
+}
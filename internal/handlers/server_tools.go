@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// serverTool is a tool the gateway runs itself instead of returning its calls to the client
+type serverTool struct {
+	definition models.Tool
+	run        func(ctx context.Context, arguments string) (string, error)
+}
+
+// serverToolChatCompletion advertises tools to the model and runs the calls it
+// makes to them, sending the results back until the model answers or calls a
+// tool the client supplied. All rounds are recorded as one request.
+func (h *Handler) serverToolChatCompletion(c echo.Context, req *models.ChatCompletionRequest, tools []*serverTool, baseURL, apiKey, protocol string) error {
+	byName := make(map[string]*serverTool, len(tools))
+	for _, tool := range tools {
+		byName[tool.definition.Function.Name] = tool
+		req.Tools = append(req.Tools, tool.definition)
+	}
+
+	var usage models.Usage
+	for round := 0; ; round++ {
+		turn, err := cloneChatRequest(req)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to copy request")
+		}
+
+		rec := newCompletionRecorder()
+		sc := subContext(c, c.Request(), rec)
+		sc.Set(middleware.ContextKeyProviderConfig, middleware.GetProviderConfig(c))
+		sc.Set(middleware.ContextKeyAPIKey, nil)
+		rec.err = h.dispatchChatCompletion(sc, turn, baseURL, apiKey, protocol)
+
+		resp, ok := rec.response()
+		if !ok {
+			if usage.TotalTokens > 0 {
+				h.recordUsageFromOpenAI(c, "/v1/chat/completions", req.Model, &models.ChatCompletionResponse{Usage: &usage}, rec.statusCode())
+			}
+			return rec.replay(c)
+		}
+		addUsage(&usage, resp.Usage)
+
+		msg := resp.Choices[0].Message
+		calls := msg.ToolCalls
+		pending := len(calls) > 0
+		for _, call := range calls {
+			if byName[call.Function.Name] == nil {
+				pending = false // the client has to run its own tools
+			}
+		}
+		if pending && round >= h.cfg.ServerToolMaxRounds {
+			middleware.LogTrace(c, "ServerTools", "Stopping after %d tool rounds", round)
+			pending = false
+		}
+		if !pending {
+			resp.Usage = &usage
+			h.recordUsageFromOpenAI(c, "/v1/chat/completions", req.Model, resp, http.StatusOK)
+			return c.JSON(http.StatusOK, resp)
+		}
+
+		req.Messages = append(req.Messages, *msg)
+		for _, call := range calls {
+			middleware.LogTrace(c, "ServerTools", "Calling tool %s (round %d)", call.Function.Name, round+1)
+			output, err := byName[call.Function.Name].run(c.Request().Context(), call.Function.Arguments)
+			if err != nil {
+				middleware.LogTrace(c, "ServerTools", "Tool %s failed: %v", call.Function.Name, err)
+				output = "Error: tool call failed: " + err.Error()
+			}
+			req.Messages = append(req.Messages, models.ChatMessage{
+				Role:       "tool",
+				Content:    output,
+				ToolCallID: call.ID,
+			})
+		}
+	}
+}
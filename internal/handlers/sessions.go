@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SessionResponse is a dashboard login session
+type SessionResponse struct {
+	database.Session
+	Current bool `json:"current"` // the session making this request
+}
+
+// ListSessions lists where the user's dashboard account is logged in
+func (h *Handler) ListSessions(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	sessions, err := h.authService.ListSessions(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get sessions")
+	}
+
+	current := middleware.GetSession(c)
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			Session: session,
+			Current: current != nil && current.PublicID == session.PublicID,
+		})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// RevokeSession logs out one of the user's sessions
+func (h *Handler) RevokeSession(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	if err := h.authService.RevokeSession(user.ID, c.Param("id")); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to revoke session")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "session revoked"})
+}
+
+// RevokeOtherSessions logs out all of the user's sessions except the current one
+func (h *Handler) RevokeOtherSessions(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	keep := ""
+	if current := middleware.GetSession(c); current != nil {
+		keep = current.PublicID
+	}
+	revoked, err := h.authService.RevokeOtherSessions(user.ID, keep)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to revoke sessions")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"message": "other sessions revoked", "revoked": revoked})
+}
+
+// Logout ends the session making the request
+func (h *Handler) Logout(c echo.Context) error {
+	user := middleware.GetUser(c)
+	session := middleware.GetSession(c)
+	if user == nil || session == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	if err := h.authService.RevokeSession(user.ID, session.PublicID); err != nil && !errors.Is(err, services.ErrSessionNotFound) {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to log out")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "logged out"})
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// sloError maps SLO service errors to HTTP errors
+func sloError(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "config not found")
+	case errors.Is(err, services.ErrSLONotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+}
+
+// GetProviderSLO returns the SLO defined on a provider config
+func (h *Handler) GetProviderSLO(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid config ID")
+	}
+
+	slo, err := h.sloService.GetSLO(user.ID, uint(id))
+	if err != nil {
+		return sloError(err)
+	}
+	return c.JSON(http.StatusOK, slo)
+}
+
+// SetProviderSLO creates or replaces the SLO of a provider config
+func (h *Handler) SetProviderSLO(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid config ID")
+	}
+
+	var req services.SLOUpdate
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	slo, err := h.sloService.SetSLO(user.ID, uint(id), &req)
+	if err != nil {
+		return sloError(err)
+	}
+	return c.JSON(http.StatusOK, slo)
+}
+
+// DeleteProviderSLO removes the SLO of a provider config
+func (h *Handler) DeleteProviderSLO(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid config ID")
+	}
+
+	if err := h.sloService.DeleteSLO(user.ID, uint(id)); err != nil {
+		return sloError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetProviderSLOStatus returns the current burn rates of a provider config's SLO
+func (h *Handler) GetProviderSLOStatus(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid config ID")
+	}
+
+	status, err := h.sloService.Status(user.ID, uint(id))
+	if err != nil {
+		return sloError(err)
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// Metrics serves upstream and SLO metrics in the Prometheus text format to
+// scrapers holding the metrics token. The metrics name provider configs and
+// count auth failures per client IP, so without a token they are not served.
+func (h *Handler) Metrics(c echo.Context) error {
+	if h.cfg.MetricsToken == "" {
+		return echo.NewHTTPError(http.StatusNotFound, "metrics are disabled; set METRICS_TOKEN to enable them")
+	}
+	token := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.MetricsToken)) != 1 {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid metrics token")
+	}
+
+	var buf bytes.Buffer
+	h.metrics.WritePrometheus(&buf)
+	h.streamTracker.WritePrometheus(&buf)
+	if err := h.sloService.WritePrometheus(&buf); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.Blob(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
+
+// MetricsCollector returns the collector fed by the UpstreamMetrics middleware
+func (h *Handler) MetricsCollector() *services.MetricsCollector {
+	return h.metrics
+}
+
+// StreamTracker returns the tracker of open upstream streams fed by the
+// StreamTracking middleware
+func (h *Handler) StreamTracker() *services.StreamTracker {
+	return h.streamTracker
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMetrics_RequiresToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string // METRICS_TOKEN
+		header     string
+		wantStatus int
+	}{
+		{"no token configured", "", "", http.StatusNotFound},
+		{"no token configured, bearer sent", "", "Bearer anything", http.StatusNotFound},
+		{"missing bearer", "scrape", "", http.StatusUnauthorized},
+		{"wrong bearer", "scrape", "Bearer guess", http.StatusUnauthorized},
+		{"valid bearer", "scrape", "Bearer scrape", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _, _ := chatTestHandler(t, "http://127.0.0.1:0", 0)
+			h.cfg.MetricsToken = tt.token
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			if err := h.Metrics(c); err != nil {
+				c.Echo().HTTPErrorHandler(err, c)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if served := strings.Contains(rec.Body.String(), "# TYPE"); served != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("metrics served = %v, want %v: %s", served, tt.wantStatus == http.StatusOK, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+
+	"ai_gateway/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetProviderStats returns the measured time to first byte and output speed of
+// the user's provider configs, per model, since the gateway started
+func (h *Handler) GetProviderStats(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	configs, err := h.configService.GetConfigs(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get provider configs")
+	}
+	ids := make([]uint, 0, len(configs))
+	for _, cfg := range configs {
+		ids = append(ids, cfg.ID)
+	}
+	return c.JSON(http.StatusOK, h.metrics.Throughput(ids))
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetStoredResponse handles GET /v1/responses/:id for responses stored upstream
+// or run in the background
+func (h *Handler) GetStoredResponse(c echo.Context) error {
+	record, err := h.storedResponse(c)
+	if err != nil {
+		return err
+	}
+	// Stored output is filtered and held to the key's policy like a live response
+	defer h.applyResponsePolicy(c, formatResponses)()
+	defer h.filterOutput(c, formatResponses)()
+	if !record.Emulated {
+		return h.forwardStoredResponse(c, record, "/responses/"+record.ResponseID)
+	}
+	return c.JSON(http.StatusOK, storedResponseObject(record))
+}
+
+// DeleteStoredResponse handles DELETE /v1/responses/:id
+func (h *Handler) DeleteStoredResponse(c echo.Context) error {
+	record, err := h.storedResponse(c)
+	if err != nil {
+		return err
+	}
+	if !record.Emulated {
+		if err := h.forwardStoredResponse(c, record, "/responses/"+record.ResponseID); err != nil || c.Response().Status >= http.StatusMultipleChoices {
+			return err
+		}
+	}
+
+	if err := h.storedResponses.Delete(middleware.AuthScope(c), record.ResponseID); err != nil && !errors.Is(err, services.ErrStoredResponseNotFound) {
+		middleware.LogTrace(c, "OpenAI-Responses", "Failed to forget response %s: %v", record.ResponseID, err)
+	}
+	if c.Response().Committed {
+		return nil
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"id": record.ResponseID, "object": "response", "deleted": true})
+}
+
+// CancelBackgroundResponse handles POST /v1/responses/:id/cancel
+func (h *Handler) CancelBackgroundResponse(c echo.Context) error {
+	record, err := h.storedResponse(c)
+	if err != nil {
+		return err
+	}
+	if !record.Emulated {
+		return h.forwardStoredResponse(c, record, "/responses/"+record.ResponseID+"/cancel")
+	}
+
+	record, err = h.storedResponses.Cancel(middleware.AuthScope(c), record.ResponseID)
+	if err != nil {
+		if errors.Is(err, services.ErrBackgroundResponseFinished) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to cancel response")
+	}
+	middleware.LogTrace(c, "OpenAI-Responses", "Cancelled background response %s", record.ResponseID)
+	return c.JSON(http.StatusOK, storedResponseObject(record))
+}
+
+// storedResponse loads the stored response named in the path, which
+// must have been created by the caller
+func (h *Handler) storedResponse(c echo.Context) (*database.StoredResponse, error) {
+	id := c.Param("id")
+	record, err := h.storedResponses.Get(middleware.AuthScope(c), id)
+	if err != nil {
+		if errors.Is(err, services.ErrStoredResponseNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "no such response: "+id)
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to load response")
+	}
+	return record, nil
+}
+
+// forwardStoredResponse relays a retrieve, cancel or delete of a response
+// stored upstream to the provider config it was created on
+func (h *Handler) forwardStoredResponse(c echo.Context, record *database.StoredResponse, upstreamPath string) error {
+	if record.ProviderConfigID != nil {
+		user := middleware.GetUser(c)
+		if user == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+		}
+		cfg, err := h.configService.GetConfigByID(user.ID, *record.ProviderConfigID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "the provider config of this response is no longer available")
+		}
+		c.Set(middleware.ContextKeyProviderConfig, cfg)
+	}
+
+	resp, err := h.forwardOpenAI(c, upstreamPath, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	contentType := resp.Header.Get(echo.HeaderContentType)
+	if !strings.HasPrefix(contentType, echo.MIMEApplicationJSON) {
+		return c.Stream(resp.StatusCode, contentType, resp.Body)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+	}
+	var payload map[string]interface{}
+	if resp.StatusCode >= http.StatusMultipleChoices || json.Unmarshal(body, &payload) != nil {
+		return c.Blob(resp.StatusCode, contentType, body)
+	}
+	h.observeStoredResponse(c, record.ResponseID, record.Model, payload, resp.StatusCode)
+	return c.Blob(resp.StatusCode, contentType, body)
+}
+
+// trackStoredResponse records a response stored by an OpenAI Responses upstream
+// so that it can be retrieved through the gateway
+func (h *Handler) trackStoredResponse(c echo.Context, model string, resp map[string]interface{}, statusCode int, background bool) {
+	id, _ := resp["id"].(string)
+	status, _ := resp["status"].(string)
+	var configID *uint
+	if cfg := middleware.GetProviderConfig(c); cfg != nil {
+		configID = &cfg.ID
+	}
+	if id == "" {
+		return
+	}
+	if err := h.storedResponses.Track(middleware.AuthScope(c), id, configID, model, status, background); err != nil {
+		middleware.LogTrace(c, "OpenAI-Responses", "Failed to track response %s: %v", id, err)
+		return
+	}
+	h.observeStoredResponse(c, id, model, resp, statusCode)
+}
+
+// trackStreamedResponse tracks the response announced by a response.created
+// event of a stream, reporting whether line was that event
+func (h *Handler) trackStreamedResponse(c echo.Context, model, line string) bool {
+	var event struct {
+		Type     string                 `json:"type"`
+		Response map[string]interface{} `json:"response"`
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if json.Unmarshal([]byte(data), &event) != nil || event.Type != "response.created" {
+		return false
+	}
+	h.trackStoredResponse(c, model, event.Response, http.StatusOK, false)
+	return true
+}
+
+// observeStoredResponse stores the status of a response stored upstream,
+// recording the usage of a background response the first time it is seen finished
+func (h *Handler) observeStoredResponse(c echo.Context, id, model string, payload map[string]interface{}, statusCode int) {
+	status, _ := payload["status"].(string)
+	if status == "" {
+		return
+	}
+	finished, err := h.storedResponses.Observe(id, status)
+	if err != nil {
+		middleware.LogTrace(c, "OpenAI-Responses", "Failed to update background response %s: %v", id, err)
+		return
+	}
+	if finished {
+		h.recordUsage(c, "/v1/responses", model, payload, statusCode)
+	}
+}
+
+// startBackgroundResponse answers a background request for an upstream without
+// background mode with a queued response, and runs the request in the gateway
+func (h *Handler) startBackgroundResponse(c echo.Context, reqBody map[string]interface{}, model string) error {
+	delete(reqBody, "background")
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to copy request")
+	}
+
+	record, ctx, err := h.storedResponses.Start(middleware.AuthScope(c), model)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create background response")
+	}
+
+	req := c.Request().Clone(ctx)
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := newCompletionRecorder()
+	sc := subContext(c, req, rec)
+	go h.runBackgroundResponse(sc, rec, record.ResponseID)
+
+	middleware.LogTrace(c, "OpenAI-Responses", "Running background response %s in the gateway", record.ResponseID)
+	return c.JSON(http.StatusOK, storedResponseObject(record))
+}
+
+// runBackgroundResponse runs a gateway-side background response to completion
+// and stores its result. Usage is recorded by the run itself.
+func (h *Handler) runBackgroundResponse(c echo.Context, rec *completionRecorder, id string) {
+	defer func() {
+		if r := recover(); r != nil {
+			middleware.LogTrace(c, "OpenAI-Responses", "Background response %s panicked: %v", id, r)
+			h.storedResponses.Finish(id, services.ResponseStatusFailed, "", "internal error")
+		}
+	}()
+
+	if err := h.storedResponses.MarkInProgress(id); err != nil {
+		middleware.LogTrace(c, "OpenAI-Responses", "Failed to start background response %s: %v", id, err)
+	}
+	rec.err = h.OpenAICodeResponses(c)
+
+	status, response, errMsg := services.ResponseStatusFailed, "", ""
+	var payload map[string]interface{}
+	if rec.err == nil && rec.status < http.StatusMultipleChoices && json.Unmarshal(rec.body.Bytes(), &payload) == nil {
+		payload["id"] = id
+		payload["background"] = true
+		if status, _ = payload["status"].(string); status == "" {
+			status = services.ResponseStatusCompleted
+			payload["status"] = status
+		}
+		data, _ := json.Marshal(payload)
+		response = string(data)
+	} else {
+		errMsg = rec.errorMessage()
+	}
+
+	if err := h.storedResponses.Finish(id, status, response, errMsg); err != nil {
+		middleware.LogTrace(c, "OpenAI-Responses", "Failed to store background response %s: %v", id, err)
+		return
+	}
+	middleware.LogTrace(c, "OpenAI-Responses", "Background response %s finished: %s", id, status)
+}
+
+// storedResponseObject renders a gateway-run background response as a
+// Responses API response object
+func storedResponseObject(record *database.StoredResponse) map[string]interface{} {
+	if record.Response != "" {
+		var obj map[string]interface{}
+		if json.Unmarshal([]byte(record.Response), &obj) == nil {
+			return obj
+		}
+	}
+	obj := map[string]interface{}{
+		"id":         record.ResponseID,
+		"object":     "response",
+		"created_at": record.CreatedAt.Unix(),
+		"status":     record.Status,
+		"model":      record.Model,
+		"background": true,
+		"output":     []interface{}{},
+		"error":      nil,
+	}
+	if record.Error != "" {
+		obj["error"] = map[string]interface{}{"code": "server_error", "message": record.Error}
+	}
+	return obj
+}
+
+// errorMessage describes the recorded failure
+func (r *completionRecorder) errorMessage() string {
+	var httpErr *echo.HTTPError
+	if errors.As(r.err, &httpErr) {
+		return fmt.Sprint(httpErr.Message)
+	}
+	if r.err != nil {
+		return r.err.Error()
+	}
+	var payload struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(r.body.Bytes(), &payload) == nil && payload.Error.Message != "" {
+		return payload.Error.Message
+	}
+	return fmt.Sprintf("upstream returned status %d", r.status)
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// responsesUpstream is an OpenAI Responses upstream storing the responses it creates
+type responsesUpstream struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []map[string]interface{}
+}
+
+func newResponsesUpstream(t *testing.T) *responsesUpstream {
+	u := &responsesUpstream{}
+	const response = `{"id":"resp_1","object":"response","status":"completed","model":"gpt-4o","output":[],"usage":{"input_tokens":5,"output_tokens":3,"total_tokens":8}}`
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/responses":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			u.mu.Lock()
+			u.requests = append(u.requests, body)
+			u.mu.Unlock()
+			w.Write([]byte(response))
+		case r.Method == http.MethodGet && r.URL.Path == "/responses/resp_1":
+			w.Write([]byte(response))
+		default:
+			http.Error(w, `{"error":{"message":"not found"}}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// serveResponses runs handle for a Responses API request made with apiKey
+func serveResponses(h *Handler, apiKey *database.APIKey, method, id, body string, handle func(echo.Context) error) *httptest.ResponseRecorder {
+	path := "/v1/responses"
+	if id != "" {
+		path += "/" + id
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	if id != "" {
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+	}
+	c.Set(middleware.ContextKeyAPIKey, apiKey)
+	c.Set(middleware.ContextKeyUser, &apiKey.User)
+	if err := handle(c); err != nil {
+		c.Echo().HTTPErrorHandler(err, c)
+	}
+	return rec
+}
+
+func TestStoredResponses_StoredByDefault(t *testing.T) {
+	tests := []struct {
+		name      string
+		store     string
+		wantStore bool
+		wantFound bool
+	}{
+		{name: "store omitted", store: "", wantStore: true, wantFound: true},
+		{name: "store true", store: `,"store":true`, wantStore: true, wantFound: true},
+		{name: "store false", store: `,"store":false`, wantStore: false, wantFound: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newResponsesUpstream(t)
+			h, apiKey, db := chatTestHandler(t, upstream.URL, 0)
+			apiKey.ProviderConfigs[0].Protocol = "openai_code"
+			if err := db.Model(&apiKey.ProviderConfigs[0]).Update("protocol", "openai_code").Error; err != nil {
+				t.Fatal(err)
+			}
+
+			rec := serveResponses(h, apiKey, http.MethodPost, "", `{"model":"gpt-4o","input":"hi"`+tt.store+`}`, h.OpenAICodeResponses)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("create: status %d: %s", rec.Code, rec.Body.String())
+			}
+			upstream.mu.Lock()
+			sent := upstream.requests[0]["store"]
+			upstream.mu.Unlock()
+			if sent != tt.wantStore {
+				t.Errorf("store sent upstream = %v, want %v", sent, tt.wantStore)
+			}
+
+			rec = serveResponses(h, apiKey, http.MethodGet, "resp_1", "", h.GetStoredResponse)
+			if found := rec.Code == http.StatusOK; found != tt.wantFound {
+				t.Fatalf("retrieve: status %d, want found %v: %s", rec.Code, tt.wantFound, rec.Body.String())
+			}
+			if tt.wantFound && !strings.Contains(rec.Body.String(), `"id":"resp_1"`) {
+				t.Errorf("retrieve: unexpected body %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+// waitStoredResponse polls a background response until it finishes
+func waitStoredResponse(t *testing.T, h *Handler, apiKey *database.APIKey, id string) map[string]interface{} {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rec := serveResponses(h, apiKey, http.MethodGet, id, "", h.GetStoredResponse)
+		var resp map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("retrieve: status %d: %s", rec.Code, rec.Body.String())
+		}
+		if status, _ := resp["status"].(string); status != "queued" && status != "in_progress" {
+			return resp
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("background response %s did not finish: %v", id, resp)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStoredResponses_BackgroundEmulated(t *testing.T) {
+	tests := []struct {
+		name       string
+		delay      time.Duration
+		cancel     bool
+		wantStatus string
+		wantUsage  int
+	}{
+		{name: "completes", delay: 20 * time.Millisecond, wantStatus: "completed", wantUsage: 1},
+		{name: "cancelled", delay: 5 * time.Second, cancel: true, wantStatus: "cancelled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newDelayedChatUpstream(t, tt.delay, chatReply("hello", "stop", 5, 3))
+			h, apiKey, db := chatTestHandler(t, upstream.URL, 0)
+
+			rec := serveResponses(h, apiKey, http.MethodPost, "", `{"model":"gpt-4o","input":"hi","background":true}`, h.OpenAICodeResponses)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("create: status %d: %s", rec.Code, rec.Body.String())
+			}
+			var created map[string]interface{}
+			json.Unmarshal(rec.Body.Bytes(), &created)
+			id, _ := created["id"].(string)
+			if created["status"] != "queued" || created["background"] != true || !strings.HasPrefix(id, "resp_") {
+				t.Fatalf("create: got %v, want a queued background response", created)
+			}
+
+			if tt.cancel {
+				for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+					if requests, _ := upstream.counts(); requests == 1 {
+						break
+					}
+					if time.Now().After(deadline) {
+						t.Fatal("the background response was not sent upstream")
+					}
+				}
+				rec = serveResponses(h, apiKey, http.MethodPost, id, "", h.CancelBackgroundResponse)
+				if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"cancelled"`) {
+					t.Fatalf("cancel: status %d: %s", rec.Code, rec.Body.String())
+				}
+				waitCancelled(t, upstream, 1)
+			}
+
+			resp := waitStoredResponse(t, h, apiKey, id)
+			if resp["status"] != tt.wantStatus || resp["id"] != id {
+				t.Errorf("retrieve: got %v, want status %s", resp, tt.wantStatus)
+			}
+			if tt.wantStatus == "completed" && !strings.Contains(mustJSON(t, resp["output"]), "hello") {
+				t.Errorf("retrieve: output %v does not hold the upstream reply", resp["output"])
+			}
+			if got := len(usageRecords(t, db, apiKey)); got != tt.wantUsage {
+				t.Errorf("recorded %d usage records, want %d", got, tt.wantUsage)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"ai_gateway/internal/adapters"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxUpstreamErrorBytes bounds the error body read from a failed upstream stream
+const maxUpstreamErrorBytes = 64 << 10
+
+// upstreamError returns a failed upstream response as an HTTP error, so that the
+// error renderer of the inbound route presents it in the client's format rather
+// than the error body being converted as if it were a response. OpenAI,
+// Anthropic and Gemini all carry the message at error.message. Upstream auth
+// failures are the gateway's credentials, not the client's, and become 502.
+func upstreamError(statusCode int, body map[string]interface{}) *echo.HTTPError {
+	message := http.StatusText(statusCode)
+	switch e := body["error"].(type) {
+	case map[string]interface{}:
+		if m, ok := e["message"].(string); ok && m != "" {
+			message = m
+		}
+	case string:
+		if e != "" {
+			message = e
+		}
+	}
+	if statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden {
+		statusCode = http.StatusBadGateway
+	}
+	return echo.NewHTTPError(statusCode, "upstream error: "+message)
+}
+
+// upstreamStreamError reads the error body of a failed upstream stream
+func upstreamStreamError(stream *adapters.StreamReader, statusCode int) *echo.HTTPError {
+	var body map[string]interface{}
+	_ = json.NewDecoder(io.LimitReader(stream.GetReader(), maxUpstreamErrorBytes)).Decode(&body)
+	return upstreamError(statusCode, body)
+}
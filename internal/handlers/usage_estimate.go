@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/labstack/echo/v4"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/middleware"
+	"ai_gateway/internal/models"
+	"ai_gateway/internal/services"
+)
+
+// contextKeyPromptEstimate holds the estimated prompt tokens of a request, used
+// by routing policies and when the upstream reports no usage
+const contextKeyPromptEstimate = "prompt_token_estimate"
+
+// estimatedTextKeys are the fields whose string values count towards a
+// token estimate; base64 image, audio and file data is left out
+var estimatedTextKeys = map[string]bool{
+	"text":         true,
+	"content":      true,
+	"input":        true,
+	"instructions": true,
+	"arguments":    true,
+	"output":       true,
+	"name":         true,
+	"description":  true,
+	"refusal":      true,
+}
+
+// notePromptEstimate stores the estimated prompt tokens of a chat request
+func notePromptEstimate(c echo.Context, req *models.ChatCompletionRequest) {
+	chars := 0
+	for i := range req.Messages {
+		chars += len(req.Messages[i].GetTextContent())
+		chars += textChars(req.Messages[i].ToolCalls)
+	}
+	chars += textChars(req.Tools)
+	c.Set(contextKeyPromptEstimate, services.EstimateTokens(chars))
+}
+
+// noteResponsesPromptEstimate stores the estimated prompt tokens of a Responses request
+func noteResponsesPromptEstimate(c echo.Context, reqBody map[string]interface{}) {
+	chars := textChars(reqBody["instructions"]) + textChars(reqBody["input"]) + textChars(reqBody["tools"])
+	c.Set(contextKeyPromptEstimate, services.EstimateTokens(chars))
+}
+
+// noteMessagesPromptEstimate stores the estimated prompt tokens of an Anthropic Messages request
+func noteMessagesPromptEstimate(c echo.Context, req *models.MessagesRequest) {
+	chars := textChars(req.System) + textChars(req.Messages) + textChars(req.Tools)
+	c.Set(contextKeyPromptEstimate, services.EstimateTokens(chars))
+}
+
+// noteGeminiPromptEstimate stores the estimated prompt tokens of a Gemini request
+func noteGeminiPromptEstimate(c echo.Context, req *models.GenerateContentRequest) {
+	chars := textChars(req.SystemInstruction) + textChars(req.Contents) + textChars(req.Tools)
+	c.Set(contextKeyPromptEstimate, services.EstimateTokens(chars))
+}
+
+// promptEstimate returns the prompt estimate noted for the request, if any
+func promptEstimate(c echo.Context) (int, bool) {
+	tokens, ok := c.Get(contextKeyPromptEstimate).(int)
+	return tokens, ok
+}
+
+// textChars counts the characters of the text fields in a decoded JSON value
+// or in a model value, which is decoded through JSON first
+func textChars(v interface{}) int {
+	switch val := v.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(val)
+	case map[string]interface{}:
+		chars := 0
+		for key, field := range val {
+			if s, ok := field.(string); ok {
+				if estimatedTextKeys[key] {
+					chars += len(s)
+				}
+				continue
+			}
+			chars += textChars(field)
+		}
+		return chars
+	case []interface{}:
+		chars := 0
+		for _, item := range val {
+			chars += textChars(item)
+		}
+		return chars
+	case bool, float64:
+		return 0
+	default:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return 0
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			return 0
+		}
+		return textChars(decoded)
+	}
+}
+
+// contextKeyUsageUnrecorded marks a sub-context whose upstream call is left out
+// of the key's usage, as the caller records it as part of the client's request
+const contextKeyUsageUnrecorded = "usage_unrecorded"
+
+// usageKey returns the API key to record the usage of a request under, nil
+// when there is none or the caller records the usage itself
+func usageKey(c echo.Context) *database.APIKey {
+	if unrecorded, _ := c.Get(contextKeyUsageUnrecorded).(bool); unrecorded {
+		return nil
+	}
+	return middleware.GetAPIKey(c)
+}
+
+// recordEstimatedUsage records usage estimated from the request's noted prompt
+// and the given completion text length, for upstreams that omit usage. It
+// reports false when nothing could be estimated.
+func (h *Handler) recordEstimatedUsage(c echo.Context, endpoint, model string, completionChars, statusCode int) bool {
+	apiKey := usageKey(c)
+	promptTokens, ok := promptEstimate(c)
+	if apiKey == nil || !ok || statusCode >= 400 {
+		return false
+	}
+	completionTokens := services.EstimateTokens(completionChars)
+	middleware.LogTrace(c, "Usage", "Upstream reported no usage, estimated prompt=%d completion=%d", promptTokens, completionTokens)
+	h.apiKeyService.RecordEstimatedUsage(apiKey.ID, endpoint, model, promptTokens, completionTokens, statusCode, usageAttribution(c))
+	return true
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"ai_gateway/internal/events"
+	"ai_gateway/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// liveUsageKeepalive is how often an idle live usage feed sends a comment so
+// proxies keep the connection open
+const liveUsageKeepalive = 15 * time.Second
+
+// EventBus returns the bus on which the handler's services publish events, for
+// other subsystems to subscribe to
+func (h *Handler) EventBus() *events.Bus {
+	return h.events
+}
+
+// LiveUsage handles GET /api/usage/live - streams the usage of the user's
+// gateway requests, and the key limits they reach, as server-sent events while
+// the connection is open
+func (h *Handler) LiveUsage(c echo.Context) error {
+	user := middleware.GetUser(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "not authenticated")
+	}
+
+	feed, unsubscribe := h.events.Subscribe(events.RequestCompleted, events.KeyLimitReached)
+	defer unsubscribe()
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("Connection", "keep-alive")
+	resp.WriteHeader(http.StatusOK)
+	resp.Flush()
+
+	keepalive := time.NewTicker(liveUsageKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(resp, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+			resp.Flush()
+		case event, ok := <-feed:
+			if !ok {
+				return nil
+			}
+			if event.UserID != user.ID {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(resp, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return nil
+			}
+			resp.Flush()
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"ai_gateway/internal/models"
+	"ai_gateway/internal/services"
+)
+
+// webSearchToolName is the name of the gateway-managed search tool
+const webSearchToolName = "web_search"
+
+// webSearchTool returns the gateway-managed web search tool
+func (h *Handler) webSearchTool() *serverTool {
+	return &serverTool{
+		definition: models.Tool{
+			Type: "function",
+			Function: models.Function{
+				Name:        webSearchToolName,
+				Description: "Search the web for current information. Returns the title, URL and a snippet of the top results.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"query": map[string]interface{}{
+							"type":        "string",
+							"description": "The search query",
+						},
+					},
+					"required": []string{"query"},
+				},
+			},
+		},
+		run: func(ctx context.Context, arguments string) (string, error) {
+			var args struct {
+				Query string `json:"query"`
+			}
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			results, err := h.webSearchService.Search(ctx, args.Query)
+			if err != nil {
+				return "", err
+			}
+			return services.FormatSearchResults(results), nil
+		},
+	}
+}
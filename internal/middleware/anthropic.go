@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// contextKeyAnthropicRoute marks requests made to the Anthropic-compatible routes
+const contextKeyAnthropicRoute = "anthropic_route"
+
+// statusOverloaded is the status Anthropic's API returns when it is overloaded
+const statusOverloaded = 529
+
+// anthropicErrorTypes maps HTTP status codes to the error types of the Anthropic API
+var anthropicErrorTypes = map[int]string{
+	http.StatusBadRequest:            "invalid_request_error",
+	http.StatusUnauthorized:          "authentication_error",
+	http.StatusPaymentRequired:       "billing_error",
+	http.StatusForbidden:             "permission_error",
+	http.StatusNotFound:              "not_found_error",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusTooManyRequests:       "rate_limit_error",
+	http.StatusInternalServerError:   "api_error",
+	http.StatusServiceUnavailable:    "overloaded_error",
+	http.StatusGatewayTimeout:        "timeout_error",
+	statusOverloaded:                 "overloaded_error",
+}
+
+// AnthropicRoute renders errors the way the Anthropic API does, so Anthropic
+// SDKs raise their typed errors and apply their retry logic. It must come
+// before GatewayAuth.
+func AnthropicRoute() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(contextKeyAnthropicRoute, true)
+
+			err := next(c)
+			if err == nil || c.Response().Committed {
+				return err
+			}
+
+			code := http.StatusInternalServerError
+			message := err.Error()
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				code = httpErr.Code
+				message = fmt.Sprint(httpErr.Message)
+			}
+			return c.JSON(code, map[string]interface{}{
+				"type": "error",
+				"error": map[string]interface{}{
+					"type":    anthropicErrorType(code),
+					"message": message,
+				},
+			})
+		}
+	}
+}
+
+// anthropicErrorType returns the Anthropic error type of an HTTP status code
+func anthropicErrorType(code int) string {
+	if errorType, ok := anthropicErrorTypes[code]; ok {
+		return errorType
+	}
+	if code >= http.StatusInternalServerError {
+		return "api_error"
+	}
+	return "invalid_request_error"
+}
+
+// isAnthropicRoute reports whether a request came in through AnthropicRoute
+func isAnthropicRoute(c echo.Context) bool {
+	anthropic, _ := c.Get(contextKeyAnthropicRoute).(bool)
+	return anthropic
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Archive tees the full request and response bodies of API keys that opted in
+// to archiving and hands them to the archive service once the response is done
+func Archive(svc *services.ArchiveService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			apiKey := GetAPIKey(c)
+			if !svc.Enabled() || apiKey == nil || !apiKey.ArchiveEnabled {
+				return next(c)
+			}
+
+			start := time.Now()
+			req := c.Request()
+			reqBuf := &limitedBuffer{max: svc.MaxBodyBytes()}
+			if req.Body != nil {
+				req.Body = &teeReadCloser{Reader: io.TeeReader(req.Body, reqBuf), Closer: req.Body}
+			}
+
+			rw := c.Response().Writer
+			respBuf := &limitedBuffer{max: svc.MaxBodyBytes()}
+			c.Response().Writer = &archiveResponseWriter{ResponseWriter: rw, buf: respBuf}
+
+			err := next(c)
+			c.Response().Writer = rw
+
+			status := c.Response().Status
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			}
+
+			respContentType := c.Response().Header().Get(echo.HeaderContentType)
+			entry := &services.ArchiveEntry{
+				TraceID:             GetTraceID(c),
+				APIKeyID:            apiKey.ID,
+				UserID:              apiKey.UserID,
+				Method:              req.Method,
+				Path:                req.URL.Path,
+				Query:               redactQuery(req.URL.Query()),
+				StatusCode:          status,
+				StartedAt:           start,
+				DurationMs:          time.Since(start).Milliseconds(),
+				RequestContentType:  req.Header.Get(echo.HeaderContentType),
+				RequestTruncated:    reqBuf.truncated,
+				ResponseContentType: respContentType,
+				ResponseBody:        respBuf.String(),
+				ResponseTruncated:   respBuf.truncated,
+				Injection:           GetInjectionResult(c),
+			}
+			if isBinaryContentType(entry.RequestContentType) {
+				entry.RequestBody = "[binary content omitted]"
+			} else {
+				entry.RequestBody = reqBuf.String()
+			}
+			if strings.HasPrefix(respContentType, "text/event-stream") {
+				entry.AssembledOutput = services.AssembleStreamText(respBuf.Bytes())
+			}
+
+			svc.Submit(entry)
+			return err
+		}
+	}
+}
+
+// redactQuery renders query parameters with credentials removed
+func redactQuery(values url.Values) string {
+	values.Del("key")
+	return values.Encode()
+}
+
+// limitedBuffer keeps at most max bytes and remembers whether more were written
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Buffer.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// teeReadCloser copies a request body into a buffer as it is read downstream
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// archiveResponseWriter copies the response body into a buffer as it is written
+type archiveResponseWriter struct {
+	http.ResponseWriter
+	buf *limitedBuffer
+}
+
+func (w *archiveResponseWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *archiveResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
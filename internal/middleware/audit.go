@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// auditMaxBodyBytes bounds the request and response bodies kept in an audit entry
+const auditMaxBodyBytes = 64 * 1024
+
+// Context keys set by handlers to enrich the audit entry of a request
+const (
+	contextKeyAuditBefore = "audit_before"
+	contextKeyAuditActor  = "audit_actor"
+)
+
+// auditRedacted replaces secret values in audited bodies
+const auditRedacted = "[REDACTED]"
+
+// AuditBefore attaches the state of the resource a request is about to change
+// to the request's audit entry
+func AuditBefore(c echo.Context, resource interface{}) {
+	c.Set(contextKeyAuditBefore, resource)
+}
+
+// AuditActor names the actor of a request that is not authenticated yet, such as a login
+func AuditActor(c echo.Context, user *database.User) {
+	c.Set(contextKeyAuditActor, user)
+}
+
+// Audit records every mutating management API request (any method but GET,
+// HEAD and OPTIONS under /api/) in the audit log, with the actor, client IP,
+// redacted request body, and the resource before and after the change.
+func Audit(svc *services.AuditService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			switch req.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			if !strings.HasPrefix(req.URL.Path, "/api/") {
+				return next(c)
+			}
+
+			reqBuf := &limitedBuffer{max: auditMaxBodyBytes}
+			if req.Body != nil && !isBinaryContentType(req.Header.Get(echo.HeaderContentType)) {
+				req.Body = &teeReadCloser{Reader: io.TeeReader(req.Body, reqBuf), Closer: req.Body}
+			}
+			rw := c.Response().Writer
+			respBuf := &limitedBuffer{max: auditMaxBodyBytes}
+			c.Response().Writer = &archiveResponseWriter{ResponseWriter: rw, buf: respBuf}
+
+			err := next(c)
+			c.Response().Writer = rw
+
+			status := c.Response().Status
+			if err != nil {
+				status = http.StatusInternalServerError
+				var httpErr *echo.HTTPError
+				if errors.As(err, &httpErr) {
+					status = httpErr.Code
+				}
+			}
+
+			entry := &database.AuditLogEntry{
+				IP:           c.RealIP(),
+				Action:       req.Method + " " + c.Path(),
+				Path:         req.URL.Path,
+				ResourceType: auditResourceType(c.Path()),
+				ResourceID:   c.Param("id"),
+				StatusCode:   status,
+				Request:      redactAuditBody(reqBuf),
+			}
+			actor := GetUser(c)
+			if actor == nil {
+				actor, _ = c.Get(contextKeyAuditActor).(*database.User)
+			}
+			if actor != nil {
+				entry.ActorID = &actor.ID
+				entry.ActorName = actor.Username
+			}
+			if before := c.Get(contextKeyAuditBefore); before != nil {
+				if data, err := json.Marshal(before); err == nil {
+					entry.Before = redactAuditJSON(data)
+				}
+			}
+			if status < http.StatusBadRequest {
+				entry.After = redactAuditBody(respBuf)
+			}
+
+			if recordErr := svc.Record(entry); recordErr != nil {
+				log.Printf("[Audit] Failed to record %s by %s: %v", entry.Action, entry.ActorName, recordErr)
+			}
+			return err
+		}
+	}
+}
+
+// auditResourceType names the resource of a management route: the segment
+// after /api/, or the one after /api/admin/ (e.g. "keys", "config")
+func auditResourceType(route string) string {
+	segments := strings.Split(strings.TrimPrefix(route, "/api/"), "/")
+	if segments[0] == "admin" && len(segments) > 1 {
+		return segments[1]
+	}
+	return segments[0]
+}
+
+// redactAuditBody returns a captured JSON body with secrets redacted, or "" for
+// bodies that are empty, truncated or not JSON
+func redactAuditBody(buf *limitedBuffer) string {
+	if buf.truncated || buf.Len() == 0 {
+		return ""
+	}
+	return redactAuditJSON(buf.Bytes())
+}
+
+// redactAuditJSON replaces the values of secret fields anywhere in a JSON document
+func redactAuditJSON(data []byte) string {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if decoder.Decode(&doc) != nil {
+		return ""
+	}
+	redacted, err := json.Marshal(redactAuditValue(doc))
+	if err != nil {
+		return ""
+	}
+	return string(redacted)
+}
+
+func redactAuditValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if isSecretField(key) {
+				v[key] = auditRedacted
+				continue
+			}
+			v[key] = redactAuditValue(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactAuditValue(value)
+		}
+	}
+	return v
+}
+
+// isSecretField reports whether a JSON field holds a credential
+func isSecretField(name string) bool {
+	name = strings.ToLower(name)
+	switch {
+	case name == "key", name == "apikey", name == "token", name == "authorization":
+		return true
+	case strings.HasSuffix(name, "_key"), strings.HasSuffix(name, "_token"):
+		return true
+	}
+	return strings.Contains(name, "password") || strings.Contains(name, "secret")
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAudit_RecordsManagementMutations(t *testing.T) {
+	db, err := database.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := services.NewAuditService(db)
+	user := &database.User{ID: 7, Username: "alice"}
+	setUser := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(ContextKeyUser, user)
+			return next(c)
+		}
+	}
+	e := echo.New()
+	e.Use(setUser, Audit(svc))
+	e.PUT("/api/config/:id", func(c echo.Context) error {
+		var body map[string]interface{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&body); err != nil {
+			return err
+		}
+		AuditBefore(c, map[string]string{"name": "old", "api_key": "sk-old"})
+		return c.JSON(http.StatusOK, map[string]string{"name": "new", "key_hint": "sk-...new"})
+	})
+	e.GET("/api/config/:id", func(c echo.Context) error { return c.JSON(http.StatusOK, map[string]string{}) })
+	e.POST("/v1/chat/completions", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	e.POST("/api/keys", func(c echo.Context) error { return echo.NewHTTPError(http.StatusBadRequest, "invalid") })
+
+	tests := []struct {
+		method, path, body string
+		audited            bool
+	}{
+		{http.MethodPut, "/api/config/3", `{"name":"new","api_key":"sk-new","nested":{"client_secret":"s"}}`, true},
+		{http.MethodGet, "/api/config/3", "", false},
+		{http.MethodPost, "/v1/chat/completions", `{}`, false},
+		{http.MethodPost, "/api/keys", `{"name":"k"}`, true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	entries, err := svc.List(&services.AuditLogFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("recorded %d entries, want the two management mutations", len(entries))
+	}
+	failed, update := entries[0], entries[1]
+	if update.Action != "PUT /api/config/:id" || update.ResourceType != "config" || update.ResourceID != "3" ||
+		update.ActorID == nil || *update.ActorID != 7 || update.ActorName != "alice" || update.StatusCode != http.StatusOK {
+		t.Errorf("unexpected update entry: %+v", update)
+	}
+	for name, got := range map[string]string{"request": update.Request, "before": update.Before} {
+		if strings.Contains(got, "sk-") || strings.Contains(got, `"s"`) || !strings.Contains(got, auditRedacted) {
+			t.Errorf("%s not redacted: %s", name, got)
+		}
+	}
+	if update.After != `{"key_hint":"sk-...new","name":"new"}` {
+		t.Errorf("after = %s", update.After)
+	}
+	if failed.StatusCode != http.StatusBadRequest || failed.After != "" {
+		t.Errorf("failed request: status %d, after %q, want 400 without a snapshot", failed.StatusCode, failed.After)
+	}
+}
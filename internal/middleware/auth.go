@@ -3,16 +3,22 @@ package middleware
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strings"
 	"time"
 
+	"ai_gateway/internal/adapters"
 	"ai_gateway/internal/config"
 	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+	"ai_gateway/internal/services"
 	"ai_gateway/internal/utils"
 
 	"github.com/labstack/echo/v4"
@@ -24,6 +30,10 @@ const (
 	ContextKeyAPIKey         = "api_key"
 	ContextKeyProviderConfig = "provider_config"
 	ContextKeyTraceID        = "trace_id"
+	ContextKeyRequestStart   = "request_start"
+	ContextKeyUpstreamModel  = "upstream_model"
+	ContextKeyResponseModel  = "response_model"
+	ContextKeyTokenScope     = "token_scope"
 )
 
 // AuthResult contains the authentication result
@@ -33,6 +43,40 @@ type AuthResult struct {
 	ProviderConfig *database.ProviderConfig
 }
 
+// TokenScope limits an ephemeral token to some models and endpoints; an empty
+// list allows all
+type TokenScope struct {
+	Models    []string
+	Endpoints []string
+}
+
+// AllowsModel reports whether the scope allows a model
+func (s *TokenScope) AllowsModel(model string) bool {
+	if s == nil || len(s.Models) == 0 {
+		return true
+	}
+	for _, m := range s.Models {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsEndpoint reports whether the scope allows a request path: an endpoint
+// of the scope or a path below one
+func (s *TokenScope) AllowsEndpoint(path string) bool {
+	if s == nil || len(s.Endpoints) == 0 {
+		return true
+	}
+	for _, endpoint := range s.Endpoints {
+		if path == endpoint || strings.HasPrefix(path, strings.TrimSuffix(endpoint, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 // JWTAuth is a middleware that validates JWT tokens
 func JWTAuth(cfg *config.Config) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
@@ -50,8 +94,8 @@ func JWTAuth(cfg *config.Config) echo.MiddlewareFunc {
 
 			token := parts[1]
 
-			// Skip if it's an API key (starts with sk-)
-			if strings.HasPrefix(token, "sk-") {
+			// Skip if it's an API key (starts with sk-) or an ephemeral token
+			if isGatewayKey(token) {
 				return echo.NewHTTPError(http.StatusUnauthorized, "API key not allowed for this endpoint")
 			}
 
@@ -72,62 +116,88 @@ func JWTAuth(cfg *config.Config) echo.MiddlewareFunc {
 				return echo.NewHTTPError(http.StatusUnauthorized, "user is inactive")
 			}
 
+			if err := checkSession(c, db, cfg, claims); err != nil {
+				return err
+			}
+
 			c.Set(ContextKeyUser, &user)
 			return next(c)
 		}
 	}
 }
 
-// GatewayAuth is a middleware that validates both API keys and JWT tokens
-func GatewayAuth(db *gorm.DB, cfg *config.Config) echo.MiddlewareFunc {
+// RequireAdmin is a middleware that only lets administrators through. It must
+// follow JWTAuth.
+func RequireAdmin() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			user := GetUser(c)
+			if user == nil || !user.IsAdmin {
+				return echo.NewHTTPError(http.StatusForbidden, "administrator access required")
+			}
+			return next(c)
+		}
+	}
+}
+
+// GatewayAuth is a middleware that validates both API keys and JWT tokens. API
+// keys are looked up in cache first. Rejected credentials are counted per client
+// IP in metrics, and uses of canary keys are reported to canaries.
+func GatewayAuth(db *gorm.DB, cfg *config.Config, cache *services.CredentialCache, metrics *services.MetricsCollector, canaries *services.CanaryService) echo.MiddlewareFunc {
+	policy := NewLogPolicy(cfg.LogBodyMaxBytes)
+	signing := services.NewRequestSigningService(db, cfg, nil)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		// Bodies are logged once the request is authenticated, so that API keys
+		// can keep theirs out of the log
+		logged := func(c echo.Context) error {
+			logRequestBodyPrefix(c, "GatewayAuth")
+			return next(c)
+		}
+		return func(c echo.Context) (err error) {
+			defer func() { observeAuthFailure(c, metrics, err) }()
+
 			// Generate and set trace ID
 			traceID := GenerateTraceID()
 			c.Set(ContextKeyTraceID, traceID)
+			c.Set(ContextKeyRequestStart, time.Now())
+			c.Response().Header().Set(echo.HeaderXRequestID, traceID)
+			c.SetRequest(c.Request().WithContext(adapters.WithRequestID(c.Request().Context(), traceID)))
 
 			LogTrace(c, "GatewayAuth", "Request: %s %s", c.Request().Method, c.Request().URL.Path)
 
 			// Log headers
 			LogHeaders(c, "GatewayAuth")
 
-			// Log request body (read and restore)
-			if c.Request().Body != nil {
-				bodyBytes, err := io.ReadAll(c.Request().Body)
-				if err == nil {
-					// Restore body for later use
-					c.Request().Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-					if len(bodyBytes) > 0 {
-						LogTrace(c, "GatewayAuth", "=== Request Body ===")
-						LogTrace(c, "GatewayAuth", "%s", string(bodyBytes))
-					}
-				}
-			}
+			c.Set(contextKeyLogPolicy, policy)
 
 			// Store db in context for other middleware/handlers
 			c.Set("db", db)
 
+			// Signed requests name their API key instead of sending it
+			if c.Request().Header.Get(HeaderSignature) != "" {
+				LogTrace(c, "GatewayAuth", "Authenticating with request signature")
+				return authenticateWithSignature(c, db, cache, signing, canaries, int64(cfg.SignatureMaxBodyBytes), logged)
+			}
+
 			// Try to get API key from headers
 			apiKeyStr := extractAPIKey(c)
 			LogTrace(c, "GatewayAuth", "Extracted API key: %v (has sk- prefix: %v)", apiKeyStr != "", strings.HasPrefix(apiKeyStr, "sk-"))
 
+			if strings.HasPrefix(apiKeyStr, services.EphemeralTokenPrefix) {
+				LogTrace(c, "GatewayAuth", "Authenticating with ephemeral token")
+				return authenticateWithEphemeralToken(c, db, cache, cfg, apiKeyStr, logged)
+			}
+
 			if apiKeyStr != "" && strings.HasPrefix(apiKeyStr, "sk-") {
 				// API Key authentication
 				LogTrace(c, "GatewayAuth", "Authenticating with API key")
-				return authenticateWithAPIKey(c, db, cfg, apiKeyStr, next)
+				return authenticateWithAPIKey(c, db, cache, canaries, apiKeyStr, logged)
 			}
 
 			// Try JWT authentication
-			authHeader := c.Request().Header.Get("Authorization")
-			if authHeader != "" {
-				parts := strings.SplitN(authHeader, " ", 2)
-				if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
-					token := parts[1]
-					if !strings.HasPrefix(token, "sk-") {
-						LogTrace(c, "GatewayAuth", "Authenticating with JWT token")
-						return authenticateWithJWT(c, db, cfg, token, next)
-					}
-				}
+			if token := bearerToken(c.Request().Header.Get("Authorization")); token != "" && !isGatewayKey(token) {
+				LogTrace(c, "GatewayAuth", "Authenticating with JWT token")
+				return authenticateWithJWT(c, db, cfg, token, logged)
 			}
 
 			LogTrace(c, "GatewayAuth", "No valid authentication found")
@@ -136,43 +206,177 @@ func GatewayAuth(db *gorm.DB, cfg *config.Config) echo.MiddlewareFunc {
 	}
 }
 
-// extractAPIKey extracts the API key from request headers
+// observeAuthFailure counts a request rejected for its credentials. Errors of
+// authenticated requests come from further down the chain and are not counted.
+func observeAuthFailure(c echo.Context, metrics *services.MetricsCollector, err error) {
+	var he *echo.HTTPError
+	if errors.As(err, &he) && he.Code == http.StatusUnauthorized && GetUser(c) == nil {
+		metrics.ObserveAuthFailure(c.RealIP())
+	}
+}
+
+// extractAPIKey extracts the API key from the auth header schemes of the route:
+// X-API-Key, which Anthropic SDKs send as x-api-key, and a bearer token on all
+// routes, and on Gemini routes the x-goog-api-key header and ?key= parameter of
+// Google SDKs, which are checked first. A gateway key in any of them wins over
+// other credentials SDKs send alongside, such as an OAuth bearer token.
+// Ephemeral tokens count as gateway keys.
 func extractAPIKey(c echo.Context) string {
-	// Try X-API-Key header first
-	apiKey := c.Request().Header.Get("X-API-Key")
-	if apiKey != "" {
-		return apiKey
-	}
-
-	// Try Authorization header
-	authHeader := c.Request().Header.Get("Authorization")
-	if authHeader != "" {
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
-			return parts[1]
+	header := c.Request().Header
+	var candidates []string
+	if isGeminiRoute(c) {
+		candidates = append(candidates, header.Get("X-Goog-Api-Key"), c.QueryParam("key"))
+	}
+	candidates = append(candidates, header.Get("X-API-Key"), bearerToken(header.Get("Authorization")))
+
+	first := ""
+	for _, candidate := range candidates {
+		if isGatewayKey(candidate) {
+			return candidate
+		}
+		if first == "" {
+			first = candidate
 		}
 	}
+	return first
+}
 
+// isGatewayKey reports whether a credential is an API key or an ephemeral token
+func isGatewayKey(credential string) bool {
+	return strings.HasPrefix(credential, "sk-") || strings.HasPrefix(credential, services.EphemeralTokenPrefix)
+}
+
+// bearerToken returns the token of a "Bearer <token>" Authorization header
+func bearerToken(authHeader string) string {
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
+		return parts[1]
+	}
 	return ""
 }
 
+// loadAPIKey returns an API key with its user and provider configs, by its
+// hash or, when keyHash is empty, by its ID. Keys are served from cache when it
+// holds them and cached after being loaded from the database. Hashes matching
+// no key are cached as well. The hash of the key found is compared again in
+// constant time, so a lookup matching loosely, such as under a case-insensitive
+// collation, never authenticates another key.
+func loadAPIKey(db *gorm.DB, cache *services.CredentialCache, keyHash string, id uint) (*database.APIKey, error) {
+	var cached *database.APIKey
+	var ok bool
+	if keyHash != "" {
+		if cache.KnownInvalid(keyHash) {
+			return nil, gorm.ErrRecordNotFound
+		}
+		cached, ok = cache.KeyByHash(keyHash)
+	} else {
+		cached, ok = cache.KeyByID(id)
+	}
+	if ok {
+		return cached, checkKeyHash(cached, keyHash)
+	}
+
+	generation := cache.Generation()
+	var apiKey database.APIKey
+	query := db.Preload("User").Preload("ProviderConfigs")
+	var err error
+	if keyHash != "" {
+		err = query.Where("key_hash = ?", keyHash).First(&apiKey).Error
+	} else {
+		err = query.First(&apiKey, id).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) && keyHash != "" {
+		cache.PutInvalid(keyHash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyHash(&apiKey, keyHash); err != nil {
+		cache.PutInvalid(keyHash)
+		return nil, err
+	}
+	cache.PutKey(&apiKey, generation)
+	return &apiKey, nil
+}
+
+// checkKeyHash verifies in constant time that a key looked up by hash has it
+func checkKeyHash(apiKey *database.APIKey, keyHash string) error {
+	if keyHash != "" && subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(keyHash)) != 1 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 // authenticateWithAPIKey authenticates using an API key
-func authenticateWithAPIKey(c echo.Context, db *gorm.DB, cfg *config.Config, apiKeyStr string, next echo.HandlerFunc) error {
+func authenticateWithAPIKey(c echo.Context, db *gorm.DB, cache *services.CredentialCache, canaries *services.CanaryService, apiKeyStr string, next echo.HandlerFunc) error {
 	keyHash := utils.HashAPIKey(apiKeyStr)
 	LogTrace(c, "AuthAPIKey", "Looking up API key with hash: %s...", keyHash[:16])
 
-	var apiKey database.APIKey
-	if err := db.Preload("User").Preload("ProviderConfigs").Where("key_hash = ?", keyHash).First(&apiKey).Error; err != nil {
+	apiKey, err := loadAPIKey(db, cache, keyHash, 0)
+	if err != nil {
 		LogTrace(c, "AuthAPIKey", "API key not found: %v", err)
 		return echo.NewHTTPError(http.StatusUnauthorized, "invalid API key")
 	}
 
+	// A canary key is rejected exactly like a key that does not exist
+	if apiKey.Canary {
+		reportCanaryUse(c, canaries, apiKey)
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid API key")
+	}
+
 	LogTrace(c, "AuthAPIKey", "Found API key: ID=%d, Name=%s, IsActive=%v, UserID=%d", apiKey.ID, apiKey.Name, apiKey.IsActive, apiKey.UserID)
 	LogTrace(c, "AuthAPIKey", "Associated provider configs: %d", len(apiKey.ProviderConfigs))
 	for i, pc := range apiKey.ProviderConfigs {
 		LogTrace(c, "AuthAPIKey", "  Config[%d]: Provider=%s, Name=%s, IsActive=%v, BaseURL=%s", i, pc.Provider, pc.Name, pc.IsActive, pc.BaseURL)
 	}
 
+	if apiKey.EncryptedSigningSecret != "" {
+		LogTrace(c, "AuthAPIKey", "API key requires signed requests")
+		return echo.NewHTTPError(http.StatusUnauthorized, "API key requires signed requests")
+	}
+
+	return authorizeAPIKey(c, apiKey, next)
+}
+
+// reportCanaryUse reports a request made with a canary key
+func reportCanaryUse(c echo.Context, canaries *services.CanaryService, apiKey *database.APIKey) {
+	LogTrace(c, "Auth", "Canary API key ID=%d used from %s", apiKey.ID, c.RealIP())
+	canaries.Trigger(apiKey, events.CanaryUse{
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		Method:    c.Request().Method,
+		Path:      c.Request().URL.Path,
+	})
+}
+
+// authenticateWithEphemeralToken authenticates using an ephemeral token, which
+// acts as its API key within the token's model and endpoint scope
+func authenticateWithEphemeralToken(c echo.Context, db *gorm.DB, cache *services.CredentialCache, cfg *config.Config, token string, next echo.HandlerFunc) error {
+	claims, err := utils.DecodeEphemeralToken(strings.TrimPrefix(token, services.EphemeralTokenPrefix), cfg.JWTSecret)
+	if err != nil {
+		LogTrace(c, "AuthEphemeral", "Invalid ephemeral token: %v", err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired token")
+	}
+
+	apiKey, err := loadAPIKey(db, cache, "", claims.APIKeyID)
+	if err != nil {
+		LogTrace(c, "AuthEphemeral", "API key %d of ephemeral token not found: %v", claims.APIKeyID, err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid API key")
+	}
+
+	scope := &TokenScope{Models: claims.Models, Endpoints: claims.Endpoints}
+	if !scope.AllowsEndpoint(c.Request().URL.Path) {
+		LogTrace(c, "AuthEphemeral", "Endpoint %s is outside the token scope %v", c.Request().URL.Path, scope.Endpoints)
+		return echo.NewHTTPError(http.StatusForbidden, "endpoint not allowed for this token")
+	}
+	c.Set(ContextKeyTokenScope, scope)
+
+	LogTrace(c, "AuthEphemeral", "Ephemeral token of API key ID=%d, models=%v, endpoints=%v", apiKey.ID, scope.Models, scope.Endpoints)
+	return authorizeAPIKey(c, apiKey, next)
+}
+
+// authorizeAPIKey checks that an API key is usable and makes it the caller
+func authorizeAPIKey(c echo.Context, apiKey *database.APIKey, next echo.HandlerFunc) error {
 	if !apiKey.IsActive {
 		LogTrace(c, "AuthAPIKey", "API key is inactive")
 		return echo.NewHTTPError(http.StatusUnauthorized, "API key is inactive")
@@ -185,7 +389,7 @@ func authenticateWithAPIKey(c echo.Context, db *gorm.DB, cfg *config.Config, api
 	}
 
 	c.Set(ContextKeyUser, &apiKey.User)
-	c.Set(ContextKeyAPIKey, &apiKey)
+	c.Set(ContextKeyAPIKey, apiKey)
 
 	LogTrace(c, "AuthAPIKey", "Authentication successful, calling next handler")
 	return next(c)
@@ -207,6 +411,10 @@ func authenticateWithJWT(c echo.Context, db *gorm.DB, cfg *config.Config, token
 		return echo.NewHTTPError(http.StatusUnauthorized, "user is inactive")
 	}
 
+	if err := checkSession(c, db, cfg, claims); err != nil {
+		return err
+	}
+
 	c.Set(ContextKeyUser, &user)
 
 	return next(c)
@@ -249,6 +457,38 @@ func GetProviderConfig(c echo.Context) *database.ProviderConfig {
 	return cfg
 }
 
+// GetTokenScope gets the scope of the ephemeral token authenticating the
+// request from context, or nil for other credentials
+func GetTokenScope(c echo.Context) *TokenScope {
+	scope, _ := c.Get(ContextKeyTokenScope).(*TokenScope)
+	return scope
+}
+
+// GetUpstreamModel gets the model name sent to the provider config from context
+func GetUpstreamModel(c echo.Context) string {
+	model, _ := c.Get(ContextKeyUpstreamModel).(string)
+	return model
+}
+
+// GetResponseModel gets the model name responses report instead of the
+// upstream one from context, or "" when responses are left as they are
+func GetResponseModel(c echo.Context) string {
+	model, _ := c.Get(ContextKeyResponseModel).(string)
+	return model
+}
+
+// AuthScope returns a stable namespace for the caller: "key:<id>" for API keys,
+// "user:<id>" for JWT auth, or "" when unauthenticated
+func AuthScope(c echo.Context) string {
+	if apiKey := GetAPIKey(c); apiKey != nil {
+		return fmt.Sprintf("key:%d", apiKey.ID)
+	}
+	if user := GetUser(c); user != nil {
+		return fmt.Sprintf("user:%d", user.ID)
+	}
+	return ""
+}
+
 // GenerateTraceID generates a random trace ID
 func GenerateTraceID() string {
 	b := make([]byte, 8)
@@ -279,20 +519,81 @@ func LogHeaders(c echo.Context, tag string) {
 	log.Printf(prefix + "=== Request Headers ===")
 	for name, values := range c.Request().Header {
 		for _, value := range values {
-			log.Printf(prefix+"  %s: %s", name, value)
+			log.Printf(prefix+"  %s: %s", name, Redact(value))
 		}
 	}
 }
 
-// LogRequestBody logs the request body as JSON with trace ID
+// LogRequestBody logs the request body as JSON with trace ID, as the request's
+// LogPolicy allows
 func LogRequestBody(c echo.Context, tag string, body interface{}) {
-	traceID := GetTraceID(c)
-	prefix := "[" + traceID + "] [" + tag + "] "
-	_, err := json.MarshalIndent(body, "", "  ")
+	policy := GetLogPolicy(c)
+	if !policy.Allows(c) {
+		return
+	}
+	jsonBytes, err := json.Marshal(body)
 	if err != nil {
-		log.Printf(prefix+"Failed to marshal request body: %v", err)
+		LogTrace(c, tag, "Failed to marshal request body: %v", err)
+		return
+	}
+	LogTrace(c, tag, "=== Request AI Body ===")
+	LogTrace(c, tag, "%s", policy.Render(jsonBytes))
+}
+
+// prefixedBody replays a peeked prefix before the rest of the original body
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// isBinaryContentType reports whether a body should never be buffered or logged
+func isBinaryContentType(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	return strings.HasPrefix(ct, "multipart/") ||
+		strings.HasPrefix(ct, "application/octet-stream") ||
+		strings.HasPrefix(ct, "audio/") ||
+		strings.HasPrefix(ct, "image/") ||
+		strings.HasPrefix(ct, "video/")
+}
+
+// logRequestBodyPrefix logs a bounded prefix of a textual request body, as the
+// request's LogPolicy allows, and restores the body as a stream so large
+// payloads are never copied into memory
+func logRequestBodyPrefix(c echo.Context, tag string) {
+	req := c.Request()
+	if req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+
+	contentType := req.Header.Get(echo.HeaderContentType)
+	if isBinaryContentType(contentType) {
+		LogTrace(c, tag, "Request body not logged: content-type=%s, content-length=%d", contentType, req.ContentLength)
+		return
+	}
+	policy := GetLogPolicy(c)
+	if !policy.Allows(c) {
+		return
+	}
+
+	maxBytes := policy.MaxBytes
+	prefix := make([]byte, maxBytes)
+	n, err := io.ReadFull(req.Body, prefix)
+	prefix = prefix[:n]
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		LogTrace(c, tag, "Failed to read request body: %v", err)
+	}
+	req.Body = &prefixedBody{
+		Reader: io.MultiReader(bytes.NewReader(prefix), req.Body),
+		Closer: req.Body,
+	}
+
+	if n == 0 {
+		return
+	}
+	LogTrace(c, tag, "=== Request Body ===")
+	if n == maxBytes && err == nil {
+		LogTrace(c, tag, "%s... (truncated at %d bytes)", Redact(string(prefix)), maxBytes)
 		return
 	}
-	log.Printf(prefix + "=== Request AI Body ===")
-// 	log.Printf(prefix + string(jsonBytes))
+	LogTrace(c, tag, "%s", Redact(string(prefix)))
 }
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+	"ai_gateway/internal/services"
+	"ai_gateway/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func TestExtractAPIKey_SDKHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		gemini  bool
+		want    string
+	}{
+		{name: "openai bearer", target: "/v1/chat/completions", headers: map[string]string{"Authorization": "Bearer sk-gw"}, want: "sk-gw"},
+		{name: "anthropic x-api-key", target: "/v1/messages", headers: map[string]string{"x-api-key": "sk-gw"}, want: "sk-gw"},
+		{name: "google header", target: "/v1beta/models/gemini:generateContent", headers: map[string]string{"x-goog-api-key": "sk-gw"}, gemini: true, want: "sk-gw"},
+		{name: "google query", target: "/v1beta/models/gemini:generateContent?key=sk-gw", gemini: true, want: "sk-gw"},
+		{name: "google header off gemini routes", target: "/v1/chat/completions", headers: map[string]string{"x-goog-api-key": "sk-gw"}, want: ""},
+		{name: "gateway key beside oauth token", target: "/v1beta/models/gemini:generateContent", headers: map[string]string{"Authorization": "Bearer ya29.token", "x-goog-api-key": "sk-gw"}, gemini: true, want: "sk-gw"},
+		{name: "ephemeral token beside oauth token", target: "/v1beta/models/gemini:generateContent?key=ek-tok", headers: map[string]string{"Authorization": "Bearer ya29.token"}, gemini: true, want: "ek-tok"},
+		{name: "jwt", target: "/v1/chat/completions", headers: map[string]string{"Authorization": "Bearer eyJhbGci"}, want: "eyJhbGci"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+			if tt.gemini {
+				c.Set(contextKeyGeminiRoute, true)
+			}
+			if got := extractAPIKey(c); got != tt.want {
+				t.Fatalf("extractAPIKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenScope(t *testing.T) {
+	scope := &TokenScope{Models: []string{"gpt-4o"}, Endpoints: []string{"/v1/chat/completions", "/v1beta/models/"}}
+	if !scope.AllowsModel("gpt-4o") || scope.AllowsModel("gpt-4o-mini") {
+		t.Fatal("AllowsModel() does not match the scope's models exactly")
+	}
+	for path, want := range map[string]bool{
+		"/v1/chat/completions":                  true,
+		"/v1/chat/completions2":                 false,
+		"/v1beta/models/gemini:generateContent": true,
+		"/v1/responses":                         false,
+	} {
+		if got := scope.AllowsEndpoint(path); got != want {
+			t.Errorf("AllowsEndpoint(%q) = %v, want %v", path, got, want)
+		}
+	}
+	var unscoped *TokenScope
+	if !unscoped.AllowsModel("any") || !unscoped.AllowsEndpoint("/v1/responses") {
+		t.Fatal("a nil scope must allow everything")
+	}
+}
+
+func TestLoadAPIKey_Caches(t *testing.T) {
+	db, err := database.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := database.User{Username: "u", Email: "u@example.com", HashedPassword: "x", IsActive: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	keyHash := utils.HashAPIKey("sk-valid")
+	if err := db.Create(&database.APIKey{UserID: user.ID, Name: "k", KeyHash: keyHash, KeyPrefix: "sk-val", IsActive: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+	cache := services.NewCredentialCache(&config.Config{CredentialCacheTTL: 60}, events.NewBus())
+
+	key, err := loadAPIKey(db, cache, keyHash, 0)
+	if err != nil || key.User.ID != user.ID {
+		t.Fatalf("loadAPIKey() = %v, %v", key, err)
+	}
+	if _, ok := cache.KeyByHash(keyHash); !ok {
+		t.Fatal("a loaded key must be cached")
+	}
+
+	unknown := utils.HashAPIKey("sk-guess")
+	if _, err := loadAPIKey(db, cache, unknown, 0); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("loadAPIKey() of an unknown key = %v, want ErrRecordNotFound", err)
+	}
+	if !cache.KnownInvalid(unknown) {
+		t.Fatal("an unknown key hash must be negatively cached")
+	}
+
+	// A hash differing from the stored one, as a loose lookup could return, is rejected
+	if err := checkKeyHash(key, strings.ToUpper(keyHash)); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("checkKeyHash() of another hash = %v, want ErrRecordNotFound", err)
+	}
+	if err := checkKeyHash(key, keyHash); err != nil {
+		t.Fatalf("checkKeyHash() of the key's hash = %v", err)
+	}
+}
+
+func TestGatewayAuth_CanaryKey(t *testing.T) {
+	db, err := database.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := database.User{Username: "u", Email: "u@example.com", HashedPassword: "x", IsActive: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	alerts := make(chan map[string]interface{}, 4)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts <- alert
+	}))
+	defer webhook.Close()
+
+	cfg := &config.Config{JWTSecret: "secret"}
+	bus := events.NewBus()
+	keys := services.NewAPIKeyService(db, cfg, bus, nil)
+	canaries := services.NewCanaryService(db, bus, keys, &services.LogMailer{})
+	defer bus.Handle(canaries.Handle, events.CanaryUsed)()
+	key, fullKey, err := canaries.CreateCanaryKey(user.ID, &services.CanaryKeyCreate{Name: "leaked", WebhookURL: webhook.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := GatewayAuth(db, cfg, nil, services.NewMetricsCollector(), canaries)(func(c echo.Context) error {
+		t.Error("a canary key must not reach the handler")
+		return nil
+	})
+	call := func(apiKey string) error {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("User-Agent", "scanner/1.0")
+		return auth(echo.New().NewContext(req, httptest.NewRecorder()))
+	}
+
+	unknown, used := call("sk-unknown"), call(fullKey)
+	if used == nil || used.Error() != unknown.Error() {
+		t.Fatalf("canary key error = %v, want the unknown key error %v", used, unknown)
+	}
+	call(fullKey)
+
+	select {
+	case alert := <-alerts:
+		if alert["event"] != "canary_key_used" || alert["user_agent"] != "scanner/1.0" || alert["key_prefix"] != key.KeyPrefix {
+			t.Errorf("alert = %v", alert)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook alert")
+	}
+	// Uses are all recorded, the second within a minute without an alert
+	deadline := time.Now().Add(5 * time.Second)
+	var hits int64
+	for hits < 2 && time.Now().Before(deadline) {
+		db.Model(&database.CanaryHit{}).Where("api_key_id = ?", key.ID).Count(&hits)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hits != 2 {
+		t.Errorf("recorded hits = %d, want 2", hits)
+	}
+	if len(alerts) != 0 {
+		t.Errorf("alerts = %d more, want one per minute", len(alerts))
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ClientIPExtractor returns the IP extractor behind c.RealIP(), which logs,
+// sessions and the audit log use. X-Forwarded-For, walked from the right past
+// trusted proxies, or else X-Real-IP is only honored on requests arriving from
+// a trusted proxy. Without trusted proxies the remote address is used, so
+// clients can't spoof their IP with forwarding headers.
+func ClientIPExtractor(trusted []*net.IPNet) echo.IPExtractor {
+	if len(trusted) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	// Echo trusts loopback and private networks by default; trust only the configured ones
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, ipNet := range trusted {
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	fromXFF := echo.ExtractIPFromXFFHeader(options...)
+	direct := echo.ExtractIPDirect()
+	return func(req *http.Request) string {
+		if req.Header.Get(echo.HeaderXForwardedFor) != "" {
+			return fromXFF(req)
+		}
+		// Echo's X-Real-IP extractor checks the header's IP rather than the peer's
+		realIP := net.ParseIP(strings.Trim(req.Header.Get(echo.HeaderXRealIP), "[]"))
+		if realIP != nil && trustedPeer(trusted, direct(req)) {
+			return realIP.String()
+		}
+		return direct(req)
+	}
+}
+
+// trustedPeer reports whether ip is in one of the trusted networks
+func trustedPeer(trusted []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	for _, ipNet := range trusted {
+		if parsed != nil && ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPExtractor(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	tests := []struct {
+		name       string
+		trusted    []*net.IPNet
+		remoteAddr string
+		xff        string
+		realIP     string
+		want       string
+	}{
+		{name: "no trusted proxies ignores headers", remoteAddr: "203.0.113.7:1234", xff: "198.51.100.1", want: "203.0.113.7"},
+		{name: "forwarded by trusted proxy", trusted: []*net.IPNet{proxies}, remoteAddr: "10.1.2.3:1234", xff: "198.51.100.1, 10.0.0.5", want: "198.51.100.1"},
+		{name: "spoofed entry left of the client", trusted: []*net.IPNet{proxies}, remoteAddr: "10.1.2.3:1234", xff: "1.1.1.1, 198.51.100.1", want: "198.51.100.1"},
+		{name: "untrusted peer", trusted: []*net.IPNet{proxies}, remoteAddr: "192.168.0.9:1234", xff: "198.51.100.1", want: "192.168.0.9"},
+		{name: "x-real-ip from trusted proxy", trusted: []*net.IPNet{proxies}, remoteAddr: "10.1.2.3:1234", realIP: "198.51.100.2", want: "198.51.100.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := ClientIPExtractor(tt.trusted)(req); got != tt.want {
+				t.Fatalf("client IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
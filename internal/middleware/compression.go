@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+)
+
+// Decompress transparently decodes gzip or brotli encoded request bodies so
+// downstream middleware and handlers always see plain JSON. Bodies decoding to
+// more than maxBytes are refused with 413.
+func Decompress(maxBytes int64) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			encoding := strings.ToLower(strings.TrimSpace(req.Header.Get(echo.HeaderContentEncoding)))
+			if encoding == "" || encoding == "identity" || req.Body == nil {
+				return next(c)
+			}
+
+			var reader io.ReadCloser
+			switch encoding {
+			case encodingGzip:
+				gz, err := gzip.NewReader(req.Body)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, "invalid gzip request body")
+				}
+				reader = gz
+			case encodingBrotli:
+				reader = io.NopCloser(brotli.NewReader(req.Body))
+			default:
+				return echo.NewHTTPError(http.StatusUnsupportedMediaType, "unsupported content encoding: "+encoding)
+			}
+
+			defer req.Body.Close()
+			defer reader.Close()
+			body, err := io.ReadAll(http.MaxBytesReader(c.Response(), reader, maxBytes))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("decoded request body exceeds %d bytes", maxBytes))
+				}
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid "+encoding+" request body")
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.Header.Del(echo.HeaderContentEncoding)
+			req.Header.Del(echo.HeaderContentLength)
+			req.ContentLength = int64(len(body))
+			return next(c)
+		}
+	}
+}
+
+// Compress encodes non-streaming responses with brotli or gzip based on the
+// client's Accept-Encoding. SSE responses are always written uncompressed.
+func Compress() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			encoding := negotiateEncoding(c.Request().Header.Get(echo.HeaderAcceptEncoding))
+			c.Response().Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+			if encoding == "" {
+				return next(c)
+			}
+
+			rw := c.Response().Writer
+			cw := &compressResponseWriter{ResponseWriter: rw, encoding: encoding}
+			c.Response().Writer = cw
+			defer func() {
+				cw.close()
+				c.Response().Writer = rw
+			}()
+			return next(c)
+		}
+	}
+}
+
+// negotiateEncoding picks brotli over gzip when the client accepts both
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(fields) > 1 && strings.ReplaceAll(strings.TrimSpace(fields[1]), " ", "") == "q=0" {
+			continue
+		}
+		accepted[name] = true
+	}
+	if accepted[encodingBrotli] {
+		return encodingBrotli
+	}
+	if accepted[encodingGzip] {
+		return encodingGzip
+	}
+	return ""
+}
+
+// compressResponseWriter decides whether to compress once the response headers are known
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	encoder     io.WriteCloser
+	wroteHeader bool
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	header := w.Header()
+	contentType := header.Get(echo.HeaderContentType)
+	if code != http.StatusNoContent && code != http.StatusNotModified &&
+		header.Get(echo.HeaderContentEncoding) == "" &&
+		!strings.HasPrefix(contentType, "text/event-stream") {
+		header.Set(echo.HeaderContentEncoding, w.encoding)
+		header.Del(echo.HeaderContentLength)
+		if w.encoding == encodingBrotli {
+			w.encoder = brotli.NewWriter(w.ResponseWriter)
+		} else {
+			w.encoder = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressResponseWriter) Flush() {
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressResponseWriter) close() {
+	if w.encoder != nil {
+		w.encoder.Close()
+	}
+}
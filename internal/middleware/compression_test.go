@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo/v4"
+)
+
+func encodeBody(t *testing.T, encoding string, body []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if encoding == encodingBrotli {
+		w = brotli.NewWriter(&buf)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	if _, err := w.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeBody(t *testing.T, encoding string, body []byte) string {
+	var r io.Reader
+	if encoding == encodingBrotli {
+		r = brotli.NewReader(bytes.NewReader(body))
+	} else {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(decoded)
+}
+
+// compressionRouter echoes request bodies back, decoded and compressed by the
+// middleware, and answers /stream with an SSE stream
+func compressionRouter(maxBytes int64) *echo.Echo {
+	e := echo.New()
+	e.Use(Decompress(maxBytes), Compress())
+	e.POST("/echo", func(c echo.Context) error {
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, body)
+	})
+	e.POST("/stream", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+		c.Response().WriteHeader(http.StatusOK)
+		c.Response().Write([]byte("data: {}\n\n"))
+		return nil
+	})
+	return e
+}
+
+func TestCompression_RoundTrip(t *testing.T) {
+	e := compressionRouter(1 << 20)
+	payload := `{"model":"gpt-4o","messages":[{"role":"user","content":"` + strings.Repeat("hello ", 100) + `"}]}`
+
+	for _, encoding := range []string{encodingGzip, encodingBrotli} {
+		req := httptest.NewRequest(http.MethodPost, "/echo", bytes.NewReader(encodeBody(t, encoding, []byte(payload))))
+		req.Header.Set(echo.HeaderContentEncoding, encoding)
+		req.Header.Set(echo.HeaderAcceptEncoding, encoding)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", encoding, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get(echo.HeaderContentEncoding); got != encoding {
+			t.Errorf("%s: response Content-Encoding = %q", encoding, got)
+		}
+		if got := decodeBody(t, encoding, rec.Body.Bytes()); got != payload {
+			t.Errorf("%s: round trip = %q", encoding, got)
+		}
+	}
+}
+
+func TestDecompress_RefusesBadBodies(t *testing.T) {
+	e := compressionRouter(1024)
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		want     int
+	}{
+		{"gzip bomb", encodingGzip, encodeBody(t, encodingGzip, make([]byte, 1<<20)), http.StatusRequestEntityTooLarge},
+		{"brotli bomb", encodingBrotli, encodeBody(t, encodingBrotli, make([]byte, 1<<20)), http.StatusRequestEntityTooLarge},
+		{"at the cap", encodingGzip, encodeBody(t, encodingGzip, make([]byte, 1024)), http.StatusOK},
+		{"corrupt gzip", encodingGzip, []byte("not gzip"), http.StatusBadRequest},
+		{"unsupported encoding", "zstd", []byte("{}"), http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/echo", bytes.NewReader(tt.body))
+		req.Header.Set(echo.HeaderContentEncoding, tt.encoding)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+}
+
+func TestCompress_SkipsEventStreams(t *testing.T) {
+	e := compressionRouter(1024)
+	req := httptest.NewRequest(http.MethodPost, "/stream", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, "br, gzip")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(echo.HeaderContentEncoding); got != "" {
+		t.Errorf("Content-Encoding = %q, want an SSE stream left uncompressed", got)
+	}
+	if rec.Body.String() != "data: {}\n\n" {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Credits refuses requests of users on prepaid credits once their balance is
+// used up, with a 402 rendered in the error shape of the route's protocol.
+// Users who were never granted credits are not metered.
+func Credits(svc *services.CreditService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := GetUser(c)
+			if user == nil {
+				return next(c)
+			}
+
+			err := svc.CheckCredits(user.ID)
+			if errors.Is(err, services.ErrInsufficientCredits) {
+				LogTrace(c, "Credits", "Refused request of user %d: no credits left", user.ID)
+				return echo.NewHTTPError(http.StatusPaymentRequired, "your credit balance is used up; ask an administrator to grant more credits")
+			}
+			if err != nil {
+				LogTrace(c, "Credits", "Failed to check credits: %v", err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to check credits")
+			}
+			return next(c)
+		}
+	}
+}
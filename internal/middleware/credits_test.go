@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestCredits_RefusalUsesRouteErrorShape(t *testing.T) {
+	db, err := database.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&database.CreditAccount{UserID: 1, BalanceMicros: 0}).Error; err != nil {
+		t.Fatal(err)
+	}
+	setUser := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(ContextKeyUser, &database.User{ID: 1})
+			return next(c)
+		}
+	}
+	handler := func(c echo.Context) error {
+		t.Error("a user without credits must not reach the handler")
+		return nil
+	}
+	e := echo.New()
+	e.POST("/v1/chat/completions", handler, setUser, Credits(services.NewCreditService(db)))
+	e.POST("/v1/messages", handler, AnthropicRoute(), setUser, Credits(services.NewCreditService(db)))
+	e.POST("/v1beta/models/:model", handler, GeminiRoute(), setUser, Credits(services.NewCreditService(db)))
+
+	tests := []struct {
+		path  string
+		check func(body map[string]interface{}) bool
+	}{
+		{"/v1/chat/completions", func(body map[string]interface{}) bool {
+			return body["message"] != nil
+		}},
+		{"/v1/messages", func(body map[string]interface{}) bool {
+			errBody, _ := body["error"].(map[string]interface{})
+			return body["type"] == "error" && errBody["type"] == "billing_error"
+		}},
+		{"/v1beta/models/gemini-pro:generateContent", func(body map[string]interface{}) bool {
+			errBody, _ := body["error"].(map[string]interface{})
+			return errBody["status"] == "RESOURCE_EXHAUSTED" && errBody["code"] == float64(http.StatusPaymentRequired)
+		}},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, nil))
+		var body map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if rec.Code != http.StatusPaymentRequired || !tt.check(body) {
+			t.Errorf("%s: %d %s", tt.path, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// contextKeyGeminiRoute marks requests made to the Gemini-compatible routes
+const contextKeyGeminiRoute = "gemini_route"
+
+// geminiStatuses maps HTTP status codes to the google.rpc status names used in Gemini errors
+var geminiStatuses = map[int]string{
+	http.StatusBadRequest:          "INVALID_ARGUMENT",
+	http.StatusUnauthorized:        "UNAUTHENTICATED",
+	http.StatusPaymentRequired:     "RESOURCE_EXHAUSTED",
+	http.StatusForbidden:           "PERMISSION_DENIED",
+	http.StatusNotFound:            "NOT_FOUND",
+	http.StatusMethodNotAllowed:    "NOT_FOUND",
+	http.StatusConflict:            "ABORTED",
+	http.StatusTooManyRequests:     "RESOURCE_EXHAUSTED",
+	http.StatusInternalServerError: "INTERNAL",
+	http.StatusNotImplemented:      "UNIMPLEMENTED",
+	http.StatusBadGateway:          "UNAVAILABLE",
+	http.StatusServiceUnavailable:  "UNAVAILABLE",
+	http.StatusGatewayTimeout:      "DEADLINE_EXCEEDED",
+}
+
+// GeminiRoute adapts the gateway to Google's Gemini clients: it lets them pass
+// their API key as the ?key= query parameter or the x-goog-api-key header, and
+// renders errors the way the Gemini API does. It must come before GatewayAuth.
+func GeminiRoute() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(contextKeyGeminiRoute, true)
+
+			err := next(c)
+			if err == nil || c.Response().Committed {
+				return err
+			}
+
+			code := http.StatusInternalServerError
+			message := err.Error()
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				code = httpErr.Code
+				message = fmt.Sprint(httpErr.Message)
+			}
+			return c.JSON(code, map[string]interface{}{
+				"error": map[string]interface{}{
+					"code":    code,
+					"message": message,
+					"status":  geminiStatus(code),
+				},
+			})
+		}
+	}
+}
+
+// geminiStatus returns the google.rpc status name of an HTTP status code
+func geminiStatus(code int) string {
+	if status, ok := geminiStatuses[code]; ok {
+		return status
+	}
+	if code >= http.StatusInternalServerError {
+		return "INTERNAL"
+	}
+	return "FAILED_PRECONDITION"
+}
+
+// isGeminiRoute reports whether a request came in through GeminiRoute
+func isGeminiRoute(c echo.Context) bool {
+	gemini, _ := c.Get(contextKeyGeminiRoute).(bool)
+	return gemini
+}
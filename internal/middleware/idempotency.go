@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderIdempotencyKey is the request header carrying the client's idempotency key
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// HeaderIdempotentReplayed marks a response replayed from a stored one
+const HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLength bounds the accepted Idempotency-Key header value
+const maxIdempotencyKeyLength = 255
+
+// idempotencyCaptureWriter tees the response body so it can be stored after the handler returns
+type idempotencyCaptureWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyCaptureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyCaptureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// storableIdempotentStatus reports whether a response with status is stored for
+// replay: successes and validation errors a retry would get again. Transient
+// failures such as 408, 409 and 429 are left for the retry to try anew.
+func storableIdempotentStatus(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+		return true
+	}
+	return status >= http.StatusOK && status < http.StatusMultipleChoices
+}
+
+// Idempotency replays stored responses for repeated POST requests carrying the same
+// Idempotency-Key. Streaming requests are passed through untouched, and bodies
+// above maxBytes are refused. Must run after GatewayAuth.
+func Idempotency(svc *services.IdempotencyService, maxBytes int64) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			key := strings.TrimSpace(req.Header.Get(HeaderIdempotencyKey))
+			if key == "" || req.Method != http.MethodPost {
+				return next(c)
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return echo.NewHTTPError(http.StatusBadRequest, "Idempotency-Key is too long")
+			}
+
+			// Uploads are never buffered, so they cannot be fingerprinted or replayed
+			if isBinaryContentType(req.Header.Get(echo.HeaderContentType)) {
+				return next(c)
+			}
+
+			scope := AuthScope(c)
+			if scope == "" {
+				return next(c)
+			}
+
+			var body []byte
+			if req.Body != nil {
+				var err error
+				body, err = io.ReadAll(http.MaxBytesReader(c.Response(), req.Body, maxBytes))
+				if err != nil {
+					var tooLarge *http.MaxBytesError
+					if errors.As(err, &tooLarge) {
+						return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", maxBytes))
+					}
+					return echo.NewHTTPError(http.StatusBadRequest, "failed to read request body")
+				}
+				req.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			if isStreamingRequest(c, body) {
+				return next(c)
+			}
+
+			requestHash := services.HashRequest(req.Method, req.URL.Path, body)
+			record, err := svc.Lookup(scope, key, requestHash)
+			if errors.Is(err, services.ErrIdempotencyMismatch) {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+			}
+			if err != nil {
+				LogTrace(c, "Idempotency", "Lookup failed, bypassing: %v", err)
+				return next(c)
+			}
+			if record != nil {
+				return replayIdempotent(c, key, record)
+			}
+
+			release, err := svc.Acquire(scope, key)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusConflict, err.Error())
+			}
+			defer release()
+
+			// A request holding the key may have stored its response between the
+			// lookup and the acquire; look again so the key is never served twice
+			record, err = svc.Lookup(scope, key, requestHash)
+			if errors.Is(err, services.ErrIdempotencyMismatch) {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+			}
+			if err != nil {
+				LogTrace(c, "Idempotency", "Second lookup failed: %v", err)
+			} else if record != nil {
+				return replayIdempotent(c, key, record)
+			}
+
+			capture := &idempotencyCaptureWriter{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = capture
+			handlerErr := next(c)
+			c.Response().Writer = capture.ResponseWriter
+
+			status := c.Response().Status
+			contentType := c.Response().Header().Get(echo.HeaderContentType)
+			if handlerErr != nil || !c.Response().Committed || !storableIdempotentStatus(status) ||
+				strings.HasPrefix(contentType, "text/event-stream") {
+				return handlerErr
+			}
+
+			if err := svc.Save(&database.IdempotencyRecord{
+				Scope:          scope,
+				IdempotencyKey: key,
+				Method:         req.Method,
+				Path:           req.URL.Path,
+				RequestHash:    requestHash,
+				StatusCode:     status,
+				ContentType:    contentType,
+				ResponseBody:   capture.body.Bytes(),
+			}); err != nil {
+				LogTrace(c, "Idempotency", "Failed to store response for key %q: %v", key, err)
+			}
+			return nil
+		}
+	}
+}
+
+// replayIdempotent writes a stored response
+func replayIdempotent(c echo.Context, key string, record *database.IdempotencyRecord) error {
+	LogTrace(c, "Idempotency", "Replaying stored response for key %q (status %d)", key, record.StatusCode)
+	c.Response().Header().Set(HeaderIdempotentReplayed, "true")
+	return c.Blob(record.StatusCode, record.ContentType, record.ResponseBody)
+}
+
+// isStreamingRequest reports whether the request asks for an SSE response
+func isStreamingRequest(c echo.Context, body []byte) bool {
+	if c.QueryParam("alt") == "sse" || strings.HasSuffix(c.Request().URL.Path, ":streamGenerateContent") {
+		return true
+	}
+	var probe struct {
+		Stream bool `json:"stream"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return false
+	}
+	return probe.Stream
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func idempotencyServer(t *testing.T, key *database.APIKey, handler echo.HandlerFunc) (*echo.Echo, *gorm.DB) {
+	db, err := database.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := services.NewIdempotencyService(db, &config.Config{IdempotencyTTL: 3600})
+	setKey := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(ContextKeyAPIKey, key)
+			return next(c)
+		}
+	}
+	e := echo.New()
+	e.POST("/v1/chat/completions", handler, setKey, RateLimitHeaders(time.UTC), Idempotency(svc, 1024))
+	return e, db
+}
+
+func postIdempotent(e *echo.Echo, key string) *httptest.ResponseRecorder {
+	return postIdempotentBody(e, key, `{"model":"gpt-4o"}`)
+}
+
+func postIdempotentBody(e *echo.Echo, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(HeaderIdempotencyKey, key)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotency_ResponseStoredDuringLookupIsReplayed(t *testing.T) {
+	var calls int32
+	inHandler, finish := make(chan struct{}), make(chan struct{})
+	e, db := idempotencyServer(t, &database.APIKey{ID: 1}, func(c echo.Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(inHandler)
+			<-finish
+		}
+		return c.JSON(http.StatusOK, map[string]string{"id": "chatcmpl-1"})
+	})
+
+	// Hold the second request's first lookup until the first request has
+	// stored its response and released the key
+	var lookups, held int32
+	firstDone := make(chan struct{})
+	db.Callback().Query().After("gorm:query").Register("test:hold_lookup", func(tx *gorm.DB) {
+		if tx.Statement.Table == "idempotency_records" && atomic.AddInt32(&lookups, 1) == atomic.LoadInt32(&held) {
+			<-firstDone
+		}
+	})
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- postIdempotent(e, "abc") }()
+	<-inHandler
+	atomic.StoreInt32(&held, atomic.LoadInt32(&lookups)+1)
+	second := make(chan *httptest.ResponseRecorder)
+	go func() { second <- postIdempotent(e, "abc") }()
+	for atomic.LoadInt32(&lookups) < atomic.LoadInt32(&held) {
+		time.Sleep(time.Millisecond)
+	}
+	close(finish)
+	if rec := <-first; rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", rec.Code)
+	}
+	close(firstDone)
+
+	rec := <-second
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderIdempotentReplayed) != "true" {
+		t.Fatalf("second request: status %d, headers %v", rec.Code, rec.Header())
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("handler ran %d times for one idempotency key", n)
+	}
+}
+
+func TestIdempotency_ReplayKeepsRequestQuota(t *testing.T) {
+	limit := 10
+	key := &database.APIKey{ID: 1, DailyRequestLimit: &limit, DailyRequestsUsed: 3, DailyResetAt: time.Now().Add(time.Hour)}
+	e, _ := idempotencyServer(t, key, func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"id": "chatcmpl-1"})
+	})
+
+	first := postIdempotent(e, "abc")
+	if first.Code != http.StatusOK || first.Header().Get(HeaderIdempotentReplayed) != "" {
+		t.Fatalf("first request: status %d, headers %v", first.Code, first.Header())
+	}
+	if got := first.Header().Get("X-Ratelimit-Remaining-Requests"); got != "6" {
+		t.Fatalf("remaining requests = %q, want 6 counting the request", got)
+	}
+
+	replay := postIdempotent(e, "abc")
+	if replay.Header().Get(HeaderIdempotentReplayed) != "true" || replay.Body.String() != first.Body.String() {
+		t.Fatalf("expected a replay of the first response, got %d %q", replay.Code, replay.Body.String())
+	}
+	if got := replay.Header().Get("X-Ratelimit-Remaining-Requests"); got != "7" {
+		t.Fatalf("remaining requests of a replay = %q, want 7", got)
+	}
+}
+
+func TestIdempotency_StoredStatuses(t *testing.T) {
+	tests := []struct {
+		status   int
+		replayed bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusBadRequest, true},
+		{http.StatusUnprocessableEntity, true},
+		{http.StatusRequestTimeout, false},
+		{http.StatusConflict, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusBadGateway, false},
+	}
+	for _, tt := range tests {
+		var calls int32
+		e, _ := idempotencyServer(t, &database.APIKey{ID: 1}, func(c echo.Context) error {
+			atomic.AddInt32(&calls, 1)
+			return c.JSON(tt.status, map[string]string{"id": "chatcmpl-1"})
+		})
+
+		postIdempotent(e, "abc")
+		rec := postIdempotent(e, "abc")
+		if replayed := rec.Header().Get(HeaderIdempotentReplayed) == "true"; replayed != tt.replayed || rec.Code != tt.status {
+			t.Errorf("status %d: retry got %d, replayed=%v, want replayed=%v", tt.status, rec.Code, replayed, tt.replayed)
+		}
+		want := int32(2)
+		if tt.replayed {
+			want = 1
+		}
+		if n := atomic.LoadInt32(&calls); n != want {
+			t.Errorf("status %d: handler ran %d times, want %d", tt.status, n, want)
+		}
+	}
+}
+
+func TestIdempotency_RefusesLargeBodies(t *testing.T) {
+	var calls int32
+	e, _ := idempotencyServer(t, &database.APIKey{ID: 1}, func(c echo.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return c.JSON(http.StatusOK, map[string]string{"id": "chatcmpl-1"})
+	})
+
+	rec := postIdempotentBody(e, "abc", `{"model":"gpt-4o","input":"`+strings.Repeat("x", 2048)+`"}`)
+	if n := atomic.LoadInt32(&calls); rec.Code != http.StatusRequestEntityTooLarge || n != 0 {
+		t.Fatalf("got status %d after %d handler calls, want 413 before the handler", rec.Code, n)
+	}
+}
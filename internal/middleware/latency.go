@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// LatencyBudget bounds the end-to-end time of requests made with API keys that
+// set a latency budget. The request context carries the deadline, so upstream
+// calls and retries are cancelled when it passes, and the client receives a
+// 504 in the error shape of the route's protocol instead of waiting on a slow
+// provider.
+func LatencyBudget() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			apiKey := GetAPIKey(c)
+			if apiKey == nil || apiKey.LatencyBudgetMs <= 0 {
+				return next(c)
+			}
+
+			budget := time.Duration(apiKey.LatencyBudgetMs) * time.Millisecond
+			ctx, cancel := context.WithTimeout(c.Request().Context(), budget)
+			defer cancel()
+			c.SetRequest(c.Request().WithContext(ctx))
+
+			err := next(c)
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return err
+			}
+
+			LogTrace(c, "LatencyBudget", "Request exceeded latency budget of %s: %v", budget, err)
+			message := fmt.Sprintf("request exceeded the latency budget of %dms set on this API key", apiKey.LatencyBudgetMs)
+			if !c.Response().Committed {
+				return echo.NewHTTPError(http.StatusGatewayTimeout, message)
+			}
+
+			// A stream already started: end it with an error event
+			if strings.HasPrefix(c.Response().Header().Get(echo.HeaderContentType), "text/event-stream") {
+				data, _ := json.Marshal(map[string]interface{}{
+					"error": map[string]interface{}{
+						"type":      "latency_budget_exceeded",
+						"code":      "latency_budget_exceeded",
+						"message":   message,
+						"budget_ms": apiKey.LatencyBudgetMs,
+					},
+				})
+				fmt.Fprintf(c.Response(), "event: error\ndata: %s\n\n", data)
+				c.Response().Flush()
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ai_gateway/internal/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestLatencyBudget_TimeoutUsesRouteErrorShape(t *testing.T) {
+	setKey := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(ContextKeyAPIKey, &database.APIKey{ID: 1, LatencyBudgetMs: 10})
+			return next(c)
+		}
+	}
+	slow := func(c echo.Context) error {
+		<-c.Request().Context().Done()
+		return c.Request().Context().Err()
+	}
+	e := echo.New()
+	e.POST("/v1/messages", slow, AnthropicRoute(), setKey, LatencyBudget())
+	e.POST("/v1beta/models/:model", slow, GeminiRoute(), setKey, LatencyBudget())
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+	var anthropic struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &anthropic)
+	if rec.Code != http.StatusGatewayTimeout || anthropic.Type != "error" || anthropic.Error.Type != "timeout_error" {
+		t.Errorf("anthropic route: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-pro:generateContent", nil))
+	var gemini struct {
+		Error struct {
+			Status string `json:"status"`
+		} `json:"error"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &gemini)
+	if rec.Code != http.StatusGatewayTimeout || gemini.Error.Status != "DEADLINE_EXCEEDED" {
+		t.Errorf("gemini route: %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+// contextKeyLogPolicy holds the LogPolicy of a gateway request
+const contextKeyLogPolicy = "log_policy"
+
+// defaultLogBodyMaxBytes bounds logged bodies of requests without a policy
+const defaultLogBodyMaxBytes = 4096
+
+var (
+	// base64 data URIs, such as inline images, and long bare base64 runs
+	dataURIPattern = regexp.MustCompile(`data:([\w.+-]+/[\w.+-]+);base64,[A-Za-z0-9+/]+={0,2}`)
+	base64Pattern  = regexp.MustCompile(`[A-Za-z0-9+/]{200,}={0,2}`)
+
+	// values of credential-looking JSON fields, bearer tokens and well-known key formats
+	credentialFieldPattern = regexp.MustCompile(`(?i)("(?:api[_-]?key|x-api-key|x-goog-api-key|authorization|password|secret|client_secret|access_token|refresh_token|token)"\s*:\s*")(?:[^"\\]|\\.)*(")`)
+	bearerPattern          = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`)
+	secretKeyPattern       = regexp.MustCompile(`\b(sk-|AIza|ghp_|xox[abprs]-)[A-Za-z0-9_-]{8,}`)
+)
+
+// LogPolicy decides what of a request body reaches the log: base64 blobs and
+// credential-looking values are redacted and the result is truncated at
+// MaxBytes. API keys with BodyLogDisabled keep their bodies out of the log.
+type LogPolicy struct {
+	MaxBytes int // 0 disables body logging
+}
+
+// NewLogPolicy creates a LogPolicy truncating bodies at maxBytes
+func NewLogPolicy(maxBytes int) *LogPolicy {
+	return &LogPolicy{MaxBytes: maxBytes}
+}
+
+// GetLogPolicy returns the log policy of the request
+func GetLogPolicy(c echo.Context) *LogPolicy {
+	if policy, ok := c.Get(contextKeyLogPolicy).(*LogPolicy); ok {
+		return policy
+	}
+	return &LogPolicy{MaxBytes: defaultLogBodyMaxBytes}
+}
+
+// Allows reports whether the body of the request may be logged
+func (p *LogPolicy) Allows(c echo.Context) bool {
+	if p.MaxBytes <= 0 {
+		return false
+	}
+	apiKey := GetAPIKey(c)
+	return apiKey == nil || !apiKey.BodyLogDisabled
+}
+
+// Render redacts a body and truncates it to MaxBytes for the log
+func (p *LogPolicy) Render(body []byte) string {
+	s := Redact(string(body))
+	if len(s) <= p.MaxBytes {
+		return s
+	}
+	cut := p.MaxBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s... (truncated, %d bytes)", s[:cut], len(s))
+}
+
+// Redact replaces base64 blobs and credential-looking values in s
+func Redact(s string) string {
+	s = dataURIPattern.ReplaceAllStringFunc(s, func(uri string) string {
+		mediaType := dataURIPattern.FindStringSubmatch(uri)[1]
+		return fmt.Sprintf("data:%s;base64,[REDACTED %d bytes]", mediaType, len(uri))
+	})
+	s = base64Pattern.ReplaceAllStringFunc(s, func(blob string) string {
+		return fmt.Sprintf("[REDACTED base64, %d bytes]", len(blob))
+	})
+	s = credentialFieldPattern.ReplaceAllString(s, "${1}[REDACTED]${2}")
+	s = bearerPattern.ReplaceAllString(s, "${1}[REDACTED]")
+	return secretKeyPattern.ReplaceAllString(s, "${1}[REDACTED]")
+}
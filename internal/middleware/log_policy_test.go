@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLogPolicy_RedactsBlobsAndCredentials(t *testing.T) {
+	image := strings.Repeat("iVBORw0KGgo", 100)
+	body := `{"api_key":"abc123","messages":[{"content":[{"type":"image_url","image_url":{"url":"data:image/png;base64,` + image + `"}}]}],` +
+		`"note":"use sk-proj-abcdefghijklmnop","audio":"` + strings.Repeat("QUJD", 80) + `"}`
+
+	got := NewLogPolicy(4096).Render([]byte(body))
+	for _, secret := range []string{"abc123", image[:40], "sk-proj-abcdefghijklmnop", "QUJDQUJD"} {
+		if strings.Contains(got, secret) {
+			t.Fatalf("rendered body still contains %q: %s", secret, got)
+		}
+	}
+	for _, kept := range []string{`"api_key":"[REDACTED]"`, "data:image/png;base64,[REDACTED", `"type":"image_url"`} {
+		if !strings.Contains(got, kept) {
+			t.Fatalf("rendered body is missing %q: %s", kept, got)
+		}
+	}
+}
+
+func TestLogPolicy_Truncates(t *testing.T) {
+	got := NewLogPolicy(10).Render([]byte(`{"text":"héllo wörld, a long message"}`))
+	if !strings.HasPrefix(got, `{"text":"h`) || !strings.Contains(got, "truncated") {
+		t.Fatalf("unexpected truncation: %q", got)
+	}
+	if strings.ContainsRune(got, '�') {
+		t.Fatalf("truncation split a rune: %q", got)
+	}
+}
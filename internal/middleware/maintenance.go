@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Maintenance refuses gateway requests with 503 and a Retry-After header while
+// the whole gateway is in maintenance. Paused provider configs are handled by
+// provider resolution.
+func Maintenance(svc *services.MaintenanceService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if err := svc.Gateway(); err != nil {
+				LogTrace(c, "Maintenance", "Refused request: gateway in maintenance")
+				return MaintenanceResponse(c, err)
+			}
+			return next(c)
+		}
+	}
+}
+
+// MaintenanceResponse sets the Retry-After header of a request refused during
+// a maintenance window and returns its 503 error
+func MaintenanceResponse(c echo.Context, err *services.MaintenanceError) error {
+	c.Response().Header().Set("Retry-After", err.RetryAfterSeconds())
+	return echo.NewHTTPError(http.StatusServiceUnavailable, err.Message)
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMaintenance(t *testing.T) {
+	db, err := database.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := services.NewMaintenanceService(db)
+	handler := Maintenance(svc)(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	serve := func() (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		return rec, handler(echo.New().NewContext(req, rec))
+	}
+
+	if rec, err := serve(); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, err = %v; want the request passed on", rec.Code, err)
+	}
+
+	until := time.Now().Add(90 * time.Second)
+	if _, err := svc.SetGateway(1, &services.MaintenanceRequest{Message: "rotating keys", Until: &until}); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := serve()
+	httpErr, ok := err.(*echo.HTTPError)
+	if !ok || httpErr.Code != http.StatusServiceUnavailable || httpErr.Message != "rotating keys" {
+		t.Fatalf("err = %v, want 503 with the window's message", err)
+	}
+	if got, _ := strconv.Atoi(rec.Header().Get("Retry-After")); got < 85 || got > 90 {
+		t.Errorf("Retry-After = %d, want the seconds until the window ends", got)
+	}
+
+	if _, err := svc.SetGateway(1, &services.MaintenanceRequest{RetryAfter: 30}); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := serve(); rec.Header().Get("Retry-After") != "30" {
+		t.Errorf("Retry-After = %q, want the window's own", rec.Header().Get("Retry-After"))
+	}
+	if windows, _ := svc.List(); len(windows) != 1 {
+		t.Errorf("windows = %d, want the gateway window updated in place", len(windows))
+	}
+
+	if err := svc.ClearGateway(); err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := serve(); err != nil || rec.Code != http.StatusOK {
+		t.Errorf("status = %d, err = %v; want the request passed on after maintenance", rec.Code, err)
+	}
+}
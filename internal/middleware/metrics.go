@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxMeteredBody bounds how much of a non-streamed response is kept to read its usage
+const maxMeteredBody = 1 << 20
+
+// UpstreamMetrics records the outcome, time to first byte and output throughput
+// of every request that was routed to a provider config
+func UpstreamMetrics(collector *services.MetricsCollector) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			rw := c.Response().Writer
+			fw := &firstByteWriter{ResponseWriter: rw}
+			c.Response().Writer = fw
+
+			err := next(c)
+			c.Response().Writer = rw
+
+			providerCfg := GetProviderConfig(c)
+			if providerCfg == nil {
+				return err
+			}
+
+			latency := time.Since(start)
+			if !fw.first.IsZero() {
+				latency = fw.first.Sub(start)
+			}
+
+			status := c.Response().Status
+			if err != nil {
+				var he *echo.HTTPError
+				if errors.As(err, &he) {
+					status = he.Code
+				} else {
+					status = http.StatusInternalServerError
+				}
+			}
+
+			collector.Observe(providerCfg, status, latency)
+			if status >= 200 && status < 300 && !fw.first.IsZero() {
+				collector.ObserveThroughput(providerCfg, GetUpstreamModel(c), services.UpstreamOutput{
+					TTFB:         latency,
+					Duration:     fw.last.Sub(start),
+					Streamed:     fw.stream,
+					OutputTokens: fw.outputTokens(),
+				})
+			}
+			return err
+		}
+	}
+}
+
+// firstByteWriter notes when the response headers were first written and meters
+// the output tokens of the response
+type firstByteWriter struct {
+	http.ResponseWriter
+	first time.Time
+	last  time.Time
+
+	stream bool
+	line   []byte // unterminated tail of a stream
+	chars  int    // streamed text, for estimating tokens
+	tokens int    // largest usage count seen in the stream
+	body   limitedBuffer
+}
+
+func (w *firstByteWriter) WriteHeader(code int) {
+	if w.first.IsZero() {
+		w.first = time.Now()
+		w.stream = strings.HasPrefix(w.Header().Get(echo.HeaderContentType), "text/event-stream")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *firstByteWriter) Write(b []byte) (int, error) {
+	if w.first.IsZero() {
+		w.first = time.Now()
+		w.stream = strings.HasPrefix(w.Header().Get(echo.HeaderContentType), "text/event-stream")
+	}
+	w.last = time.Now()
+	if w.stream {
+		w.meterStream(b)
+	} else {
+		w.body.max = maxMeteredBody
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// meterStream counts the text and usage of the complete lines of a stream
+func (w *firstByteWriter) meterStream(b []byte) {
+	w.line = append(w.line, b...)
+	for {
+		idx := bytes.IndexByte(w.line, '\n')
+		if idx < 0 {
+			return
+		}
+		line := bytes.TrimSpace(w.line[:idx])
+		w.line = w.line[idx+1:]
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+		data := bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:")))
+		if tokens := services.UsageOutputTokens(data); tokens > w.tokens {
+			w.tokens = tokens
+		}
+		w.chars += len([]rune(services.AssembleStreamText(line)))
+	}
+}
+
+// outputTokens returns the reported output tokens, or an estimate from the streamed text
+func (w *firstByteWriter) outputTokens() int {
+	if !w.stream {
+		return services.UsageOutputTokens(w.body.Bytes())
+	}
+	if w.tokens > 0 {
+		return w.tokens
+	}
+	return services.EstimateTokens(w.chars)
+}
+
+func (w *firstByteWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ContextKeyInjection holds the prompt injection score of a request's user content
+const ContextKeyInjection = "prompt_injection"
+
+// PromptInjection scores the user content of requests of API keys with
+// InjectionDetection for prompt injection. The score is kept in the context for
+// the request log and archive, and requests scoring at or above the key's
+// threshold are refused before they reach an upstream.
+func PromptInjection(svc *services.PromptInjectionService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			apiKey := GetAPIKey(c)
+			req := c.Request()
+			if apiKey == nil || !apiKey.InjectionDetection || req.Method != http.MethodPost || req.Body == nil ||
+				isBinaryContentType(req.Header.Get(echo.HeaderContentType)) {
+				return next(c)
+			}
+
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "failed to read request body")
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			text := services.UserContent(body)
+			if text == "" {
+				return next(c)
+			}
+
+			result, err := svc.Score(req.Context(), text)
+			if err != nil {
+				LogTrace(c, "PromptInjection", "Classifier failed, scored by heuristics only: %v", err)
+			}
+			c.Set(ContextKeyInjection, result)
+			LogTrace(c, "PromptInjection", "Score %.2f (signals: %v)", result.Score, result.Signals)
+
+			if apiKey.InjectionThreshold > 0 && result.Score >= apiKey.InjectionThreshold {
+				LogTrace(c, "PromptInjection", "Refused request scoring %.2f, threshold %.2f", result.Score, apiKey.InjectionThreshold)
+				return c.JSON(http.StatusBadRequest, map[string]interface{}{
+					"error": map[string]interface{}{
+						"type":    "invalid_request_error",
+						"code":    "prompt_injection",
+						"message": "the request was refused as a likely prompt injection",
+						"score":   result.Score,
+					},
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+// GetInjectionResult gets the prompt injection score of the request from
+// context, or nil when it was not scored
+func GetInjectionResult(c echo.Context) *services.InjectionResult {
+	result, _ := c.Get(ContextKeyInjection).(*services.InjectionResult)
+	return result
+}
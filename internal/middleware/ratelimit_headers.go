@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RateLimitHeaders sets OpenAI-style x-ratelimit-* headers from the usage limits
+// of the request's API key, so clients can throttle themselves against gateway
+// quotas. They replace the provider's headers of the same name, which
+// UpstreamHeaders only passes through when the gateway sets none. Remaining
+// requests count the current request, unless its response is an idempotent
+// replay; reset values are the time until the limiting window resets, in the
+// same "1h2m3s" format as OpenAI. Windows reset at calendar boundaries in loc.
+func RateLimitHeaders(loc *time.Location) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			apiKey := GetAPIKey(c)
+			if apiKey == nil {
+				return next(c)
+			}
+
+			now := time.Now()
+			requests, tokens := services.TightestLimits(apiKey, now, loc)
+			header := c.Response().Header()
+			if requests != nil {
+				remaining := requests.Remaining - 1
+				if remaining < 0 {
+					remaining = 0
+				}
+				header.Set("X-Ratelimit-Limit-Requests", strconv.Itoa(requests.Limit))
+				header.Set("X-Ratelimit-Remaining-Requests", strconv.Itoa(remaining))
+				header.Set("X-Ratelimit-Reset-Requests", requests.ResetAt.Sub(now).Round(time.Second).String())
+				// Replays are served without using the quota
+				c.Response().Before(func() {
+					if header.Get(HeaderIdempotentReplayed) != "" {
+						header.Set("X-Ratelimit-Remaining-Requests", strconv.Itoa(requests.Remaining))
+					}
+				})
+			}
+			if tokens != nil {
+				header.Set("X-Ratelimit-Limit-Tokens", strconv.Itoa(tokens.Limit))
+				header.Set("X-Ratelimit-Remaining-Tokens", strconv.Itoa(tokens.Remaining))
+				header.Set("X-Ratelimit-Reset-Tokens", tokens.ResetAt.Sub(now).Round(time.Second).String())
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GatewayRecover turns a panic in a gateway handler, such as a converter
+// tripping over an unexpected upstream payload, into an error in the format of
+// the route's protocol. A stream that already started is ended with an error
+// event instead. The panic is logged with its stack under the request's trace
+// ID and counted in the metrics. It comes last, so the other gateway
+// middleware see the failed request like any other.
+func GatewayRecover(collector *services.MetricsCollector) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+				if r == http.ErrAbortHandler {
+					panic(r)
+				}
+
+				LogTrace(c, "Recover", "Panic in %s %s: %v\n%s", c.Request().Method, c.Request().URL.Path, r, debug.Stack())
+				collector.ObservePanic(c.Path())
+				err = writePanicError(c)
+			}()
+			return next(c)
+		}
+	}
+}
+
+// writePanicError sends the client the error of a recovered panic
+func writePanicError(c echo.Context) error {
+	message := fmt.Sprintf("internal error, request ID %s", GetTraceID(c))
+	resp := c.Response()
+	if !resp.Committed {
+		return c.JSON(http.StatusInternalServerError, panicErrorBody(c, message, false))
+	}
+
+	// Nothing can be added to a response other than a stream once it started
+	if !strings.HasPrefix(resp.Header().Get(echo.HeaderContentType), "text/event-stream") {
+		LogTrace(c, "Recover", "Response already sent, client receives it truncated")
+		return nil
+	}
+	data, _ := json.Marshal(panicErrorBody(c, message, true))
+	if isAnthropicRoute(c) || c.Path() == "/v1/responses" {
+		fmt.Fprintf(resp, "event: error\ndata: %s\n\n", data)
+	} else {
+		fmt.Fprintf(resp, "data: %s\n\n", data)
+	}
+	resp.Flush()
+	return nil
+}
+
+// panicErrorBody returns the error payload of the route's protocol, for a
+// response or a stream event
+func panicErrorBody(c echo.Context, message string, event bool) map[string]interface{} {
+	switch {
+	case isAnthropicRoute(c):
+		return map[string]interface{}{
+			"type":  "error",
+			"error": map[string]interface{}{"type": anthropicErrorType(http.StatusInternalServerError), "message": message},
+		}
+	case isGeminiRoute(c):
+		return map[string]interface{}{
+			"error": map[string]interface{}{
+				"code":    http.StatusInternalServerError,
+				"message": message,
+				"status":  geminiStatus(http.StatusInternalServerError),
+			},
+		}
+	case event && c.Path() == "/v1/responses":
+		// Responses API streams report errors as an event of their own type
+		return map[string]interface{}{"type": "error", "code": "server_error", "message": message}
+	default:
+		return map[string]interface{}{
+			"error": map[string]interface{}{"message": message, "type": "server_error", "code": "internal_error"},
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// panicServer routes requests to handlers that panic, after streaming an event
+// on the stream paths
+func panicServer(collector *services.MetricsCollector) *echo.Echo {
+	e := echo.New()
+	recoverMw := GatewayRecover(collector)
+	fail := func(c echo.Context) error {
+		var resp map[string]interface{}
+		_ = resp["choices"].([]interface{})
+		return nil
+	}
+	stream := func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+		c.Response().WriteHeader(http.StatusOK)
+		c.Response().Write([]byte(benchmarkEvent))
+		c.Response().Flush()
+		return fail(c)
+	}
+	e.POST("/v1/chat/completions", fail, recoverMw)
+	e.POST("/v1/chat/stream", stream, recoverMw)
+	e.POST("/v1/responses", stream, recoverMw)
+	e.POST("/v1/messages", stream, AnthropicRoute(), recoverMw)
+	e.POST("/v1/models/:model", fail, GeminiRoute(), recoverMw)
+	return e
+}
+
+func TestGatewayRecover_ProtocolErrors(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	collector := services.NewMetricsCollector()
+	e := panicServer(collector)
+
+	cases := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/v1/chat/completions", http.StatusInternalServerError, `{"error":{"code":"internal_error","message":"internal error, request ID unknown","type":"server_error"}}`},
+		{"/v1/models/gemini-pro", http.StatusInternalServerError, `{"error":{"code":500,"message":"internal error, request ID unknown","status":"INTERNAL"}}`},
+		{"/v1/chat/stream", http.StatusOK, `data: {"error":{"code":"internal_error","message":"internal error, request ID unknown","type":"server_error"}}`},
+		{"/v1/responses", http.StatusOK, "event: error\ndata: {\"code\":\"server_error\",\"message\":\"internal error, request ID unknown\",\"type\":\"error\"}"},
+		{"/v1/messages", http.StatusOK, "event: error\ndata: {\"error\":{\"message\":\"internal error, request ID unknown\",\"type\":\"api_error\"},\"type\":\"error\"}"},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tc.path, nil))
+		if rec.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.path, rec.Code, tc.status)
+		}
+		if !strings.Contains(rec.Body.String(), tc.body) {
+			t.Errorf("%s: body %q does not contain %q", tc.path, rec.Body.String(), tc.body)
+		}
+	}
+
+	var metrics strings.Builder
+	collector.WritePrometheus(&metrics)
+	if !strings.Contains(metrics.String(), `ai_gateway_panics_total{route="/v1/messages"} 1`) {
+		t.Errorf("panic not counted:\n%s", metrics.String())
+	}
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// modelFieldPattern finds the model of a JSON request body
+var modelFieldPattern = regexp.MustCompile(`"model"\s*:\s*"([^"\\]{1,100})"`)
+
+// RequestLog stores a request log record of each gateway request whose body
+// the log policy allows logging. A streamed response is assembled into the
+// message it carries as it is written, and that message is logged and stored
+// once instead of its chunks.
+func RequestLog(svc *services.RequestLogService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := GetUser(c)
+			policy := GetLogPolicy(c)
+			if !svc.Enabled() || user == nil || !policy.Allows(c) {
+				return next(c)
+			}
+
+			start := time.Now()
+			req := c.Request()
+			reqBuf := &limitedBuffer{max: svc.MaxBodyBytes()}
+			binary := isBinaryContentType(req.Header.Get(echo.HeaderContentType))
+			if req.Body != nil && !binary {
+				req.Body = &teeReadCloser{Reader: io.TeeReader(req.Body, reqBuf), Closer: req.Body}
+			}
+
+			rw := c.Response().Writer
+			lw := &requestLogWriter{
+				ResponseWriter: rw,
+				buf:            &limitedBuffer{max: svc.MaxBodyBytes()},
+				transcript:     services.NewStreamTranscript(svc.MaxBodyBytes()),
+			}
+			c.Response().Writer = lw
+
+			err := next(c)
+			c.Response().Writer = rw
+
+			entry := &database.RequestLog{
+				UserID:     user.ID,
+				TraceID:    GetTraceID(c),
+				Method:     req.Method,
+				Path:       req.URL.Path,
+				Model:      GetUpstreamModel(c),
+				StatusCode: c.Response().Status,
+				DurationMs: time.Since(start).Milliseconds(),
+				Streamed:   lw.streamed,
+				Truncated:  reqBuf.truncated || lw.buf.truncated,
+			}
+			if apiKey := GetAPIKey(c); apiKey != nil {
+				entry.APIKeyID = &apiKey.ID
+			}
+			if injection := GetInjectionResult(c); injection != nil {
+				entry.InjectionScore = &injection.Score
+			}
+			if binary {
+				entry.RequestBody = "[binary content omitted]"
+			} else {
+				entry.RequestBody = Redact(reqBuf.String())
+			}
+			if entry.Model == "" {
+				// The body may be cut short, so the field is looked up rather than decoded
+				if match := modelFieldPattern.FindSubmatch(reqBuf.Bytes()); match != nil {
+					entry.Model = string(match[1])
+				}
+			}
+
+			switch {
+			case lw.streamed:
+				text := lw.transcript.Text()
+				entry.ResponseBody = Redact(text)
+				entry.Chunks = lw.transcript.Chunks
+				entry.Truncated = entry.Truncated || lw.transcript.Truncated
+				LogTrace(c, "RequestLog", "=== Streamed Response (%d chunks) ===", entry.Chunks)
+				LogTrace(c, "RequestLog", "%s", policy.Render([]byte(text)))
+			case err != nil:
+				entry.StatusCode = http.StatusInternalServerError
+				entry.ResponseBody = err.Error()
+				var httpErr *echo.HTTPError
+				if errors.As(err, &httpErr) {
+					entry.StatusCode = httpErr.Code
+					entry.ResponseBody = fmt.Sprint(httpErr.Message)
+				}
+			case strings.HasPrefix(c.Response().Header().Get(echo.HeaderContentType), echo.MIMEApplicationJSON):
+				entry.ResponseBody = Redact(strings.TrimSpace(lw.buf.String()))
+			case lw.buf.Len() > 0:
+				entry.ResponseBody = fmt.Sprintf("[%s content omitted]", c.Response().Header().Get(echo.HeaderContentType))
+			}
+
+			svc.Record(entry)
+			return err
+		}
+	}
+}
+
+// requestLogWriter copies a JSON response body into a buffer, or assembles a
+// streamed one, as it is written
+type requestLogWriter struct {
+	http.ResponseWriter
+	buf        *limitedBuffer
+	transcript *services.StreamTranscript
+	started    bool
+	streamed   bool
+}
+
+func (w *requestLogWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.streamed = strings.HasPrefix(w.Header().Get(echo.HeaderContentType), "text/event-stream")
+	}
+	if w.streamed {
+		w.transcript.Write(b)
+	} else {
+		w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *requestLogWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// modelFields are the fields naming the model in OpenAI, Anthropic and Gemini
+// responses
+var modelFields = []string{"model", "modelVersion"}
+
+// ResponseModel reports the model name the client requested in responses, in
+// place of the upstream model a request was routed to, when GetResponseModel
+// names one. JSON bodies are rewritten once complete, event streams event by
+// event; other responses pass through unchanged.
+func ResponseModel() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			rw := c.Response().Writer
+			mw := &responseModelWriter{ResponseWriter: rw, model: func() string { return GetResponseModel(c) }}
+			c.Response().Writer = mw
+			defer func() {
+				mw.close()
+				c.Response().Writer = rw
+			}()
+			return next(c)
+		}
+	}
+}
+
+// responseModelWriter rewrites the model fields of the response it writes
+type responseModelWriter struct {
+	http.ResponseWriter
+	model       func() string
+	mu          sync.Mutex
+	name        string // model name to report, "" to pass the response through
+	stream      bool
+	buf         bytes.Buffer // JSON body, or the incomplete last line of a stream
+	wroteHeader bool
+}
+
+func (w *responseModelWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if name := w.model(); name != "" {
+		contentType := w.Header().Get(echo.HeaderContentType)
+		switch {
+		case strings.HasPrefix(contentType, "text/event-stream"):
+			w.name, w.stream = name, true
+		case strings.HasPrefix(contentType, echo.MIMEApplicationJSON):
+			w.name = name
+			w.Header().Del(echo.HeaderContentLength)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseModelWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.name == "" {
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf.Write(b)
+	if !w.stream {
+		return len(b), nil
+	}
+
+	// Rewrite the complete lines, keeping a partial one for the next write
+	data := w.buf.Bytes()
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return len(b), nil
+	}
+	out := rewriteEventLines(data[:end+1], w.name)
+	rest := append([]byte(nil), data[end+1:]...)
+	w.buf.Reset()
+	w.buf.Write(rest)
+	if _, err := w.ResponseWriter.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *responseModelWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close writes out what is still buffered once the handler has returned
+func (w *responseModelWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.name == "" || w.buf.Len() == 0 {
+		return
+	}
+	if w.stream {
+		w.ResponseWriter.Write(rewriteEventLines(w.buf.Bytes(), w.name))
+	} else {
+		w.ResponseWriter.Write(rewriteModelJSON(w.buf.Bytes(), w.name))
+	}
+	w.buf.Reset()
+}
+
+// rewriteEventLines rewrites the model fields of the data lines of server-sent events
+func rewriteEventLines(data []byte, model string) []byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	var out bytes.Buffer
+	for _, line := range lines {
+		payload, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			out.Write(line)
+			continue
+		}
+		out.WriteString("data:")
+		out.Write(rewriteModelJSON(payload, model))
+	}
+	return out.Bytes()
+}
+
+// rewriteModelJSON sets the model fields of a JSON object, and those of its
+// "message" and "response" objects as Anthropic and Responses stream events
+// carry them, to model. Data that is not a JSON object is returned unchanged,
+// as is the whitespace around it.
+func rewriteModelJSON(data []byte, model string) []byte {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return data
+	}
+	var body map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil || decoder.InputOffset() != int64(len(trimmed)) {
+		return data
+	}
+
+	changed := setModelFields(body, model)
+	for _, key := range []string{"message", "response"} {
+		if nested, ok := body[key].(map[string]interface{}); ok {
+			changed = setModelFields(nested, model) || changed
+		}
+	}
+	if !changed {
+		return data
+	}
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(body); err != nil {
+		return data
+	}
+
+	start := bytes.Index(data, trimmed)
+	var out bytes.Buffer
+	out.Write(data[:start])
+	out.Write(bytes.TrimSuffix(encoded.Bytes(), []byte("\n")))
+	out.Write(data[start+len(trimmed):])
+	return out.Bytes()
+}
+
+// setModelFields sets the model fields present in obj to model
+func setModelFields(obj map[string]interface{}, model string) bool {
+	changed := false
+	for _, field := range modelFields {
+		if current, ok := obj[field].(string); ok && current != model {
+			obj[field] = model
+			changed = true
+		}
+	}
+	return changed
+}
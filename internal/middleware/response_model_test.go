@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// serveResponseModel runs handler behind ResponseModel, reporting model when set
+func serveResponseModel(t *testing.T, model string, handler echo.HandlerFunc) string {
+	t.Helper()
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	if model != "" {
+		c.Set(ContextKeyResponseModel, model)
+	}
+	if err := ResponseModel()(handler)(c); err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+	return rec.Body.String()
+}
+
+func TestResponseModel_RewritesJSON(t *testing.T) {
+	body := serveResponseModel(t, "my-model", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]interface{}{"id": "chatcmpl-1", "model": "gpt-4o-2024-08-06", "note": "<b>"})
+	})
+	if !strings.Contains(body, `"model":"my-model"`) || strings.Contains(body, "gpt-4o") {
+		t.Fatalf("model not rewritten: %s", body)
+	}
+	if !strings.Contains(body, `"note":"<b>"`) {
+		t.Fatalf("other fields changed: %s", body)
+	}
+}
+
+func TestResponseModel_RewritesStream(t *testing.T) {
+	events := []string{
+		`data: {"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4"}}` + "\n\n",
+		`data: {"type":"response.created","response":{"id":"resp_1","mo`,
+		`del":"gpt-4o"}}` + "\n\n",
+		`data: {"candidates":[],"modelVersion":"gemini-2.5-pro"}` + "\n\n",
+		"data: [DONE]\n\n",
+	}
+	body := serveResponseModel(t, "my-model", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+		c.Response().WriteHeader(http.StatusOK)
+		for _, event := range events {
+			c.Response().Write([]byte(event))
+			c.Response().Flush()
+		}
+		return nil
+	})
+	for _, upstream := range []string{"claude-sonnet-4", "gpt-4o", "gemini-2.5-pro"} {
+		if strings.Contains(body, upstream) {
+			t.Fatalf("upstream model %s left in stream: %s", upstream, body)
+		}
+	}
+	if strings.Count(body, `"my-model"`) != 3 || !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Fatalf("stream not rewritten event by event: %s", body)
+	}
+}
+
+func TestResponseModel_PassesThroughWhenUnset(t *testing.T) {
+	const payload = `{"model":"gpt-4o"}`
+	body := serveResponseModel(t, "", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, []byte(payload))
+	})
+	if body != payload {
+		t.Fatalf("body changed without a response model: %s", body)
+	}
+}
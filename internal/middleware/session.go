@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/services"
+	"ai_gateway/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// ContextKeySession holds the login session of JWT-authenticated requests
+const ContextKeySession = "session"
+
+// checkSession rejects tokens whose login session was revoked or has expired
+func checkSession(c echo.Context, db *gorm.DB, cfg *config.Config, claims *utils.JWTClaims) error {
+	session, err := services.NewAuthService(db, cfg).ValidateSession(claims, c.RealIP())
+	if err != nil {
+		if errors.Is(err, services.ErrSessionInvalid) {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		LogTrace(c, "Session", "Failed to check session: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to check session")
+	}
+	c.Set(ContextKeySession, session)
+	return nil
+}
+
+// GetSession gets the login session from context
+func GetSession(c echo.Context) *database.Session {
+	if session, ok := c.Get(ContextKeySession).(*database.Session); ok {
+		return session
+	}
+	return nil
+}
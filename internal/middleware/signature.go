@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Headers of HMAC-signed requests, sent instead of the API key
+const (
+	HeaderSignatureKeyID     = "X-Signature-Key-Id"    // ID of the API key
+	HeaderSignatureTimestamp = "X-Signature-Timestamp" // Unix seconds
+	HeaderSignature          = "X-Signature"           // services.SignRequest of the timestamp, method, path, query and body
+)
+
+// authenticateWithSignature authenticates an HMAC-signed request as the API
+// key it names. The body is read whole, up to maxBody bytes, to check the
+// signature, which covers it as the handler sees it, i.e. after decompression.
+// Canary keys are rejected and reported like on the bearer path.
+func authenticateWithSignature(c echo.Context, db *gorm.DB, cache *services.CredentialCache, signing *services.RequestSigningService, canaries *services.CanaryService, maxBody int64, next echo.HandlerFunc) error {
+	req := c.Request()
+	keyID, err := strconv.ParseUint(req.Header.Get(HeaderSignatureKeyID), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid "+HeaderSignatureKeyID+" header")
+	}
+
+	apiKey, err := loadAPIKey(db, cache, "", uint(keyID))
+	if err != nil {
+		LogTrace(c, "AuthSignature", "API key %d not found: %v", keyID, err)
+		return echo.NewHTTPError(http.StatusUnauthorized, services.ErrSignatureInvalid.Error())
+	}
+	if apiKey.Canary {
+		reportCanaryUse(c, canaries, apiKey)
+		return echo.NewHTTPError(http.StatusUnauthorized, services.ErrSignatureInvalid.Error())
+	}
+
+	var body []byte
+	if req.Body != nil {
+		if body, err = io.ReadAll(http.MaxBytesReader(c.Response(), req.Body, maxBody)); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("signed request body exceeds %d bytes", maxBody))
+			}
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to read request body")
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	err = signing.Verify(apiKey, req.Method, req.URL.Path, req.URL.RawQuery, req.Header.Get(HeaderSignatureTimestamp), req.Header.Get(HeaderSignature), body, time.Now())
+	if err != nil {
+		LogTrace(c, "AuthSignature", "Signature of API key ID=%d rejected: %v", apiKey.ID, err)
+		if errors.Is(err, services.ErrSignatureInvalid) || errors.Is(err, services.ErrSignatureExpired) || errors.Is(err, services.ErrSignatureReplayed) {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	LogTrace(c, "AuthSignature", "Signature of API key ID=%d verified", apiKey.ID)
+	return authorizeAPIKey(c, apiKey, next)
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func signingFixture(t *testing.T) (*gorm.DB, *config.Config, *database.User) {
+	db, err := database.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &database.User{Username: "u", Email: "u@example.com", HashedPassword: "x", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		JWTSecret:             "secret",
+		EncryptionKey:         base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")),
+		SignatureMaxSkew:      300,
+		SignatureMaxBodyBytes: 64,
+	}
+	return db, cfg, user
+}
+
+// signedRequest builds a request to target signed for signMethod and
+// signTarget, each a path with an optional query
+func signedRequest(method, target, body string, keyID uint, secret, signMethod, signTarget string) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(HeaderSignatureKeyID, strconv.FormatUint(uint64(keyID), 10))
+	req.Header.Set(HeaderSignatureTimestamp, timestamp)
+	signPath, signQuery, _ := strings.Cut(signTarget, "?")
+	req.Header.Set(HeaderSignature, services.SignRequest(secret, timestamp, signMethod, signPath, signQuery, []byte(body)))
+	return req
+}
+
+func TestGatewayAuth_SignedRequests(t *testing.T) {
+	db, cfg, user := signingFixture(t)
+	key := database.APIKey{UserID: user.ID, Name: "server", KeyHash: "hash", KeyPrefix: "sk-srv", IsActive: true}
+	if err := db.Create(&key).Error; err != nil {
+		t.Fatal(err)
+	}
+	secret, err := services.NewRequestSigningService(db, cfg, events.NewBus()).EnableSigning(user.ID, key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reached bool
+	auth := GatewayAuth(db, cfg, nil, services.NewMetricsCollector(), nil)(func(c echo.Context) error {
+		reached = true
+		return c.NoContent(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		req        *http.Request
+		wantStatus int
+	}{
+		{"valid", signedRequest(http.MethodPost, "/v1/chat/completions", `{"model":"gpt-4o"}`, key.ID, secret, http.MethodPost, "/v1/chat/completions"), http.StatusOK},
+		{"other path", signedRequest(http.MethodPost, "/v1/embeddings", `{"model":"gpt-4o"}`, key.ID, secret, http.MethodPost, "/v1/chat/completions"), http.StatusUnauthorized},
+		{"other method", signedRequest(http.MethodDelete, "/v1/responses/resp_1", "", key.ID, secret, http.MethodGet, "/v1/responses/resp_1"), http.StatusUnauthorized},
+		{"query reordered", signedRequest(http.MethodPost, "/v1beta/models/gemini:streamGenerateContent?alt=sse&b=2&b=1", "{}", key.ID, secret, http.MethodPost, "/v1beta/models/gemini:streamGenerateContent?b=1&alt=sse&b=2"), http.StatusOK},
+		{"tampered query", signedRequest(http.MethodPost, "/v1beta/models/gemini:streamGenerateContent?alt=json", "{}", key.ID, secret, http.MethodPost, "/v1beta/models/gemini:streamGenerateContent?alt=sse"), http.StatusUnauthorized},
+		{"query added", signedRequest(http.MethodPost, "/v1/chat/completions?key=sk-other", "{}", key.ID, secret, http.MethodPost, "/v1/chat/completions"), http.StatusUnauthorized},
+		{"body over the cap", signedRequest(http.MethodPost, "/v1/chat/completions", strings.Repeat("x", 65), key.ID, secret, http.MethodPost, "/v1/chat/completions"), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached = false
+			err := auth(echo.New().NewContext(tt.req, httptest.NewRecorder()))
+			status := http.StatusOK
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if status != tt.wantStatus || reached != (tt.wantStatus == http.StatusOK) {
+				t.Fatalf("status = %d (handler reached: %v), want %d", status, reached, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestGatewayAuth_SignedRequestWithCanaryKey(t *testing.T) {
+	db, cfg, user := signingFixture(t)
+	bus := events.NewBus()
+	keys := services.NewAPIKeyService(db, cfg, bus, nil)
+	canaries := services.NewCanaryService(db, bus, keys, &services.LogMailer{})
+	defer bus.Handle(canaries.Handle, events.CanaryUsed)()
+	key, _, err := canaries.CreateCanaryKey(user.ID, &services.CanaryKeyCreate{Name: "leaked"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := GatewayAuth(db, cfg, nil, services.NewMetricsCollector(), canaries)(func(c echo.Context) error {
+		t.Error("a canary key must not reach the handler")
+		return nil
+	})
+	req := signedRequest(http.MethodPost, "/v1/chat/completions", "{}", key.ID, "guess", http.MethodPost, "/v1/chat/completions")
+	err = auth(echo.New().NewContext(req, httptest.NewRecorder()))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusUnauthorized || he.Message != services.ErrSignatureInvalid.Error() {
+		t.Fatalf("canary key error = %v, want the invalid signature error", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var hits int64
+	for hits == 0 && time.Now().Before(deadline) {
+		db.Model(&database.CanaryHit{}).Where("api_key_id = ?", key.ID).Count(&hits)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hits != 1 {
+		t.Fatalf("recorded hits = %d, want 1", hits)
+	}
+}
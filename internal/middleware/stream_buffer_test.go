@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const benchmarkEvent = `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"token"}}]}` + "\n\n"
+
+// streamServer serves a stream of events, each flushed as the gateway's
+// handlers do, behind the given middleware
+func streamServer(events int, mw ...echo.MiddlewareFunc) *httptest.Server {
+	e := echo.New()
+	e.GET("/stream", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+		c.Response().WriteHeader(http.StatusOK)
+		for i := 0; i < events; i++ {
+			c.Response().Write([]byte(benchmarkEvent))
+			c.Response().Flush()
+		}
+		return nil
+	}, mw...)
+	return httptest.NewServer(e)
+}
+
+func readStream(t testing.TB, server *httptest.Server, header string) string {
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/stream", nil)
+	if header != "" {
+		req.Header.Set(HeaderStreamFlush, header)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	return string(body)
+}
+
+func TestStreamBuffer_DeliversAllEvents(t *testing.T) {
+	server := streamServer(500, StreamBuffer(20*time.Millisecond, 4096))
+	defer server.Close()
+
+	for _, header := range []string{"", "immediate"} {
+		if body := readStream(t, server, header); body != strings.Repeat(benchmarkEvent, 500) {
+			t.Fatalf("stream body mismatch (X-Stream-Flush=%q): %d bytes", header, len(body))
+		}
+	}
+}
+
+func TestStreamBuffer_FlushesWithinInterval(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &bufferedStreamWriter{ResponseWriter: rec, interval: 10 * time.Millisecond, size: 4096}
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Write([]byte(benchmarkEvent))
+	w.Flush()
+	w.mu.Lock()
+	early := rec.Body.Len()
+	w.mu.Unlock()
+	if early != 0 {
+		t.Fatalf("event flushed before the interval")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	w.mu.Lock()
+	flushed := rec.Body.String()
+	w.mu.Unlock()
+	if flushed != benchmarkEvent {
+		t.Fatalf("event not flushed after the interval: %q", flushed)
+	}
+	w.close()
+}
+
+func benchmarkStream(b *testing.B, mw ...echo.MiddlewareFunc) {
+	const events = 2000
+	server := streamServer(events, mw...)
+	defer server.Close()
+
+	b.SetBytes(int64(events * len(benchmarkEvent)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		readStream(b, server, "")
+	}
+}
+
+func BenchmarkStream_FlushEveryEvent(b *testing.B) {
+	benchmarkStream(b)
+}
+
+func BenchmarkStream_Buffered(b *testing.B) {
+	benchmarkStream(b, StreamBuffer(20*time.Millisecond, 4096))
+}
@@ -0,0 +1,292 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderStreamRate asks for a streamed response to be delivered at most at
+// the given number of output tokens per second
+const HeaderStreamRate = "X-Stream-Rate"
+
+// MaxStreamRate bounds the X-Stream-Rate header
+const MaxStreamRate = 1000
+
+// paceTick is the shortest interval between the writes of a paced stream;
+// larger text deltas are split into pieces of the tokens due per tick
+const paceTick = 20 * time.Millisecond
+
+// paceCharsPerToken is the characters per token services.EstimateTokens assumes
+const paceCharsPerToken = 4
+
+// StreamPace smooths the delivery of event-stream responses for requests with
+// the X-Stream-Rate header: events are written out at the requested output
+// tokens per second however bursty the upstream is, and the text deltas of
+// chat completions and Anthropic streams are split so that a large one
+// arrives as several smaller events. Events without output text are written
+// in order without delay. Other responses pass through unchanged.
+func StreamPace() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			value := c.Request().Header.Get(HeaderStreamRate)
+			if value == "" {
+				return next(c)
+			}
+			rate, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || rate < 1 || rate > MaxStreamRate {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be an integer between 1 and %d", HeaderStreamRate, MaxStreamRate))
+			}
+
+			rw := c.Response().Writer
+			pw := &pacedWriter{ResponseWriter: rw, ctx: c.Request().Context(), rate: rate}
+			c.Response().Writer = pw
+			defer func() {
+				pw.close()
+				c.Response().Writer = rw
+			}()
+			return next(c)
+		}
+	}
+}
+
+// pacedWriter writes the events of an event stream at a token rate
+type pacedWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	rate   int // tokens per second
+	mu     sync.Mutex
+	stream bool
+	status int
+	buf    bytes.Buffer // incomplete last event
+	next   time.Time    // when the next token may be written
+}
+
+func (w *pacedWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writeHeader(code)
+}
+
+// writeHeader decides whether the response is paced; w.mu must be held
+func (w *pacedWriter) writeHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	w.stream = strings.HasPrefix(w.Header().Get(echo.HeaderContentType), "text/event-stream")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *pacedWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.writeHeader(http.StatusOK)
+	}
+	if !w.stream {
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf.Write(b)
+	for {
+		data := w.buf.Bytes()
+		idx := bytes.Index(data, []byte("\n\n"))
+		if idx < 0 {
+			return len(b), nil
+		}
+		event := append([]byte(nil), data[:idx]...)
+		w.buf.Next(idx + 2)
+		for _, piece := range w.split(event) {
+			if err := w.emit(piece); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// Flush is a no-op for event streams, whose events are flushed as they are
+// written out
+func (w *pacedWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stream {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close writes out an incomplete last event once the handler has returned
+func (w *pacedWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() == 0 {
+		return
+	}
+	w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// emit writes an event once the rate allows its output tokens; w.mu must be held
+func (w *pacedWriter) emit(event []byte) error {
+	if tokens := services.EstimateTokens(len(eventText(event))); tokens > 0 {
+		now := time.Now()
+		if wait := w.next.Sub(now); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-w.ctx.Done():
+				timer.Stop()
+				return w.ctx.Err()
+			}
+		} else {
+			w.next = now
+		}
+		w.next = w.next.Add(time.Duration(tokens) * time.Second / time.Duration(w.rate))
+	}
+	if _, err := w.ResponseWriter.Write(append(event, '\n', '\n')); err != nil {
+		return err
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// split divides the text delta of a chat completions chunk or an Anthropic
+// content_block_delta into events of the tokens due per tick; other events
+// are returned as they are
+func (w *pacedWriter) split(event []byte) [][]byte {
+	name, payload := parseEvent(event)
+	var body map[string]interface{}
+	if len(payload) == 0 || payload[0] != '{' || json.Unmarshal(payload, &body) != nil {
+		return [][]byte{event}
+	}
+
+	pieceTokens := int(time.Duration(w.rate) * paceTick / time.Second)
+	if pieceTokens < 1 {
+		pieceTokens = 1
+	}
+	pieceChars := pieceTokens * paceCharsPerToken
+
+	// The string field of body holding the delta text
+	var holder map[string]interface{}
+	var field string
+	if choices, ok := body["choices"].([]interface{}); ok {
+		if len(choices) != 1 || body["usage"] != nil {
+			return [][]byte{event}
+		}
+		choice, _ := choices[0].(map[string]interface{})
+		delta, _ := choice["delta"].(map[string]interface{})
+		if choice["finish_reason"] != nil || delta["tool_calls"] != nil {
+			return [][]byte{event}
+		}
+		holder, field = delta, "content"
+	} else if body["type"] == "content_block_delta" {
+		delta, _ := body["delta"].(map[string]interface{})
+		switch delta["type"] {
+		case "text_delta":
+			holder, field = delta, "text"
+		case "thinking_delta":
+			holder, field = delta, "thinking"
+		}
+	}
+	text, _ := holder[field].(string)
+	if holder == nil || len([]rune(text)) <= pieceChars {
+		return [][]byte{event}
+	}
+
+	var pieces [][]byte
+	runes := []rune(text)
+	for start := 0; start < len(runes); start += pieceChars {
+		end := start + pieceChars
+		if end > len(runes) {
+			end = len(runes)
+		}
+		holder[field] = string(runes[start:end])
+		out, err := json.Marshal(body)
+		if err != nil {
+			return [][]byte{event}
+		}
+		var piece []byte
+		if name != "" {
+			piece = append(piece, "event: "+name+"\n"...)
+		}
+		pieces = append(pieces, append(append(piece, "data: "...), out...))
+		if start == 0 {
+			// Only the first piece keeps the other fields of a chat delta
+			if choices, ok := body["choices"].([]interface{}); ok {
+				choice, _ := choices[0].(map[string]interface{})
+				holder = map[string]interface{}{}
+				choice["delta"] = holder
+			}
+		}
+	}
+	return pieces
+}
+
+// eventText returns the output text a server-sent event carries in any of the
+// gateway's stream formats
+func eventText(event []byte) string {
+	_, payload := parseEvent(event)
+	var body map[string]interface{}
+	if len(payload) == 0 || payload[0] != '{' || json.Unmarshal(payload, &body) != nil {
+		return ""
+	}
+
+	var text strings.Builder
+	appendString := func(v interface{}) {
+		if s, ok := v.(string); ok {
+			text.WriteString(s)
+		}
+	}
+	choices, _ := body["choices"].([]interface{})
+	for _, item := range choices {
+		choice, _ := item.(map[string]interface{})
+		delta, _ := choice["delta"].(map[string]interface{})
+		appendString(delta["content"])
+		appendString(delta["reasoning_content"])
+		toolCalls, _ := delta["tool_calls"].([]interface{})
+		for _, item := range toolCalls {
+			call, _ := item.(map[string]interface{})
+			function, _ := call["function"].(map[string]interface{})
+			appendString(function["arguments"])
+		}
+	}
+	candidates, _ := body["candidates"].([]interface{})
+	for _, item := range candidates {
+		candidate, _ := item.(map[string]interface{})
+		content, _ := candidate["content"].(map[string]interface{})
+		parts, _ := content["parts"].([]interface{})
+		for _, item := range parts {
+			part, _ := item.(map[string]interface{})
+			appendString(part["text"])
+		}
+	}
+	eventType, _ := body["type"].(string)
+	switch {
+	case eventType == "content_block_delta":
+		delta, _ := body["delta"].(map[string]interface{})
+		appendString(delta["text"])
+		appendString(delta["thinking"])
+		appendString(delta["partial_json"])
+	case strings.HasPrefix(eventType, "response.") && strings.HasSuffix(eventType, ".delta"):
+		appendString(body["delta"])
+	}
+	return text.String()
+}
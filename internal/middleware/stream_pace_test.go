@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func readPacedStream(t *testing.T, server *httptest.Server, rate string) (string, time.Duration) {
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/stream", nil)
+	req.Header.Set(HeaderStreamRate, rate)
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	return string(body), time.Since(start)
+}
+
+func TestStreamPace_LimitsTokenRate(t *testing.T) {
+	// Each event carries "token", estimated at 2 tokens
+	server := streamServer(20, StreamPace())
+	defer server.Close()
+
+	body, elapsed := readPacedStream(t, server, "100")
+	if body != strings.Repeat(benchmarkEvent, 20) {
+		t.Fatalf("stream body mismatch: %q", body)
+	}
+	// 40 tokens at 100 per second; the first event is written at once
+	if elapsed < 350*time.Millisecond {
+		t.Fatalf("expected the stream to take about 380ms, took %v", elapsed)
+	}
+}
+
+func TestStreamPace_SplitsLargeDeltas(t *testing.T) {
+	text := strings.Repeat("0123456789", 4)
+	e := echo.New()
+	e.GET("/stream", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+		c.Response().WriteHeader(http.StatusOK)
+		c.Response().Write([]byte(`data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant","content":"` + text + `"}}]}` + "\n\n"))
+		c.Response().Write([]byte("data: [DONE]\n\n"))
+		c.Response().Flush()
+		return nil
+	}, StreamPace())
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	// 50 tokens per second: one token, 4 characters, per 20ms tick
+	body, _ := readPacedStream(t, server, "50")
+	events := strings.Split(strings.TrimSuffix(body, "\n\n"), "\n\n")
+	if len(events) != 11 || events[10] != "data: [DONE]" {
+		t.Fatalf("expected 10 chunks and [DONE], got %q", body)
+	}
+	var joined strings.Builder
+	for i, event := range events[:10] {
+		var chunk struct {
+			ID      string `json:"id"`
+			Choices []struct {
+				Delta map[string]string `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk); err != nil || chunk.ID != "chatcmpl-1" || len(chunk.Choices) != 1 {
+			t.Fatalf("bad chunk %q", event)
+		}
+		if role := chunk.Choices[0].Delta["role"]; (i == 0) != (role == "assistant") {
+			t.Fatalf("role should be kept on the first chunk only: %q", event)
+		}
+		joined.WriteString(chunk.Choices[0].Delta["content"])
+	}
+	if joined.String() != text {
+		t.Fatalf("split content %q != %q", joined.String(), text)
+	}
+}
+
+func TestStreamPace_RejectsInvalidRate(t *testing.T) {
+	server := streamServer(1, StreamPace())
+	defer server.Close()
+
+	for _, rate := range []string{"0", "fast", "1001"} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/stream", nil)
+		req.Header.Set(HeaderStreamRate, rate)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected 400 for rate %q, got %d", rate, resp.StatusCode)
+		}
+	}
+}
+
+func TestStreamPace_ConcurrentWrites(t *testing.T) {
+	// Writers with a keep-alive goroutine, like the Anthropic stream writer, write
+	// to the paced writer from two goroutines
+	e := echo.New()
+	e.Use(StreamPace())
+	e.GET("/stream", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+		w := c.Response().Writer
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 5; j++ {
+					w.Write([]byte(benchmarkEvent))
+				}
+			}()
+		}
+		wg.Wait()
+		return nil
+	})
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	body, _ := readPacedStream(t, server, "1000")
+	if body != strings.Repeat(benchmarkEvent, 10) {
+		t.Fatalf("stream body mismatch: %q", body)
+	}
+}
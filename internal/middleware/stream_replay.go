@@ -0,0 +1,214 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderStreamID names the stream of a resumable streamed response; its
+// events carry SSE ids of the form "<stream id>:<sequence>"
+const HeaderStreamID = "X-Stream-Id"
+
+// HeaderLastEventID is sent by a reconnecting client with the id of the last
+// event it received, to resume the stream after it
+const HeaderLastEventID = "Last-Event-ID"
+
+// StreamReplay buffers the events of streamed responses so that a client whose
+// connection drops can resume by sending Last-Event-ID, instead of re-issuing
+// and paying for the whole request again. While the stream is buffered the
+// upstream request is detached from the client connection, so it runs to the
+// end even when the client is away. Must run after GatewayAuth.
+func StreamReplay(svc *services.StreamReplayService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !svc.Enabled() {
+				return next(c)
+			}
+			scope := AuthScope(c)
+			if scope == "" {
+				return next(c)
+			}
+
+			req := c.Request()
+			if lastEventID := strings.TrimSpace(req.Header.Get(HeaderLastEventID)); lastEventID != "" {
+				return resumeStream(c, svc, scope, lastEventID)
+			}
+
+			if req.Method != http.MethodPost || req.Body == nil || isBinaryContentType(req.Header.Get(echo.HeaderContentType)) {
+				return next(c)
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "failed to read request body")
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			if !isStreamingRequest(c, body) {
+				return next(c)
+			}
+
+			c.SetRequest(req.WithContext(context.WithoutCancel(req.Context())))
+			rw := c.Response().Writer
+			w := &replayWriter{ResponseWriter: rw, svc: svc, scope: scope, c: c}
+			c.Response().Writer = w
+			defer func() {
+				w.close()
+				c.Response().Writer = rw
+			}()
+			return next(c)
+		}
+	}
+}
+
+// resumeStream writes the events of a buffered stream following the one
+// lastEventID names, then follows the stream live until it ends
+func resumeStream(c echo.Context, svc *services.StreamReplayService, scope, lastEventID string) error {
+	id, seqText, found := strings.Cut(lastEventID, ":")
+	seq, err := strconv.Atoi(seqText)
+	if !found || err != nil || seq < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid "+HeaderLastEventID)
+	}
+	stream := svc.Get(scope, id)
+	if stream == nil {
+		return echo.NewHTTPError(http.StatusGone, "stream not found or expired; re-issue the request")
+	}
+	LogTrace(c, "StreamReplay", "Resuming stream %s after event %d", id, seq)
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set(HeaderStreamID, id)
+	resp.WriteHeader(http.StatusOK)
+
+	for {
+		events, done, changed, ok := stream.Events(seq)
+		if !ok {
+			// The stream outgrew the buffer while it was being resumed
+			return nil
+		}
+		for _, event := range events {
+			if _, err := resp.Write(event); err != nil {
+				return nil
+			}
+		}
+		seq += len(events)
+		resp.Flush()
+		if done {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+// replayWriter numbers the events of an event-stream response and buffers
+// them for replay. Once the client is gone its write errors are swallowed so
+// the handler streams on into the buffer. Other responses pass through.
+type replayWriter struct {
+	http.ResponseWriter
+	svc   *services.StreamReplayService
+	scope string
+	c     echo.Context
+
+	mu          sync.Mutex
+	stream      *services.ReplayStream
+	wroteHeader bool
+	pending     []byte
+	seq         int
+	detached    bool // the client connection failed
+}
+
+func (w *replayWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writeHeaderLocked(code)
+}
+
+func (w *replayWriter) writeHeaderLocked(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code == http.StatusOK && strings.HasPrefix(w.Header().Get(echo.HeaderContentType), "text/event-stream") {
+		stream, err := w.svc.Start(w.scope)
+		if err != nil {
+			LogTrace(w.c, "StreamReplay", "Failed to start stream buffer: %v", err)
+		} else {
+			w.stream = stream
+			w.Header().Set(HeaderStreamID, stream.ID)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *replayWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writeHeaderLocked(http.StatusOK)
+	if w.stream == nil {
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.pending = append(w.pending, b...)
+	for {
+		i := bytes.Index(w.pending, []byte("\n\n"))
+		if i < 0 {
+			break
+		}
+		w.emitLocked(w.pending[:i+2])
+		w.pending = w.pending[i+2:]
+	}
+	return len(b), nil
+}
+
+// emitLocked numbers an event, buffers it and sends it to the client if it is
+// still connected
+func (w *replayWriter) emitLocked(event []byte) {
+	w.seq++
+	framed := append([]byte(fmt.Sprintf("id: %s:%d\n", w.stream.ID, w.seq)), event...)
+	w.svc.Append(w.stream, framed)
+	if w.detached {
+		return
+	}
+	if _, err := w.ResponseWriter.Write(framed); err != nil {
+		LogTrace(w.c, "StreamReplay", "Client of stream %s gone after event %d; buffering the rest: %v", w.stream.ID, w.seq, err)
+		w.detached = true
+	}
+}
+
+func (w *replayWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.detached {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close sends a trailing partial event and completes the stream
+func (w *replayWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stream == nil {
+		return
+	}
+	if len(w.pending) > 0 {
+		w.emitLocked(w.pending)
+		w.pending = nil
+	}
+	w.svc.Finish(w.stream)
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// failingWriter stands for a client connection that drops after limit writes
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	if w.limit <= 0 {
+		return 0, errors.New("connection reset")
+	}
+	w.limit--
+	return w.ResponseRecorder.Write(b)
+}
+
+// serveStreamReplay sends a request through StreamReplay for API key 1,
+// streaming events from the handler
+func serveStreamReplay(t *testing.T, svc *services.StreamReplayService, w http.ResponseWriter, lastEventID string, events ...string) {
+	t.Helper()
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","stream":true}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if lastEventID != "" {
+		req.Header.Set(HeaderLastEventID, lastEventID)
+	}
+	c := e.NewContext(req, w)
+	c.Set(ContextKeyAPIKey, &database.APIKey{ID: 1})
+	handler := func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+		c.Response().WriteHeader(http.StatusOK)
+		for _, event := range events {
+			if _, err := c.Response().Write([]byte(event)); err != nil {
+				return err
+			}
+			c.Response().Flush()
+		}
+		return nil
+	}
+	if err := StreamReplay(svc)(handler)(c); err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+}
+
+func TestStreamReplay_ResumesAfterLastEventID(t *testing.T) {
+	svc := services.NewStreamReplayService(&config.Config{StreamReplayTTL: 60})
+	events := []string{"data: {\"n\":1}\n\n", "data: {\"n\":2}\n\n", "data: [DONE]\n\n"}
+
+	// The client is gone after the first event; the rest is still buffered
+	client := &failingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 1}
+	serveStreamReplay(t, svc, client, "", events...)
+	id := client.Header().Get(HeaderStreamID)
+	if id == "" {
+		t.Fatal("stream ID header missing")
+	}
+	if got, want := client.Body.String(), "id: "+id+":1\ndata: {\"n\":1}\n\n"; got != want {
+		t.Fatalf("unexpected first connection body:\n%q\nwant %q", got, want)
+	}
+
+	resumed := httptest.NewRecorder()
+	serveStreamReplay(t, svc, resumed, id+":1")
+	want := "id: " + id + ":2\ndata: {\"n\":2}\n\nid: " + id + ":3\ndata: [DONE]\n\n"
+	if resumed.Code != http.StatusOK || resumed.Body.String() != want {
+		t.Fatalf("unexpected resumed stream %d:\n%q\nwant %q", resumed.Code, resumed.Body.String(), want)
+	}
+
+	gone := httptest.NewRecorder()
+	serveStreamReplay(t, svc, gone, "str_unknown:1")
+	if gone.Code != http.StatusGone {
+		t.Fatalf("unknown stream: got status %d, want 410", gone.Code)
+	}
+}
+
+func TestStreamReplay_DisabledPassesThrough(t *testing.T) {
+	svc := services.NewStreamReplayService(&config.Config{})
+	rec := httptest.NewRecorder()
+	serveStreamReplay(t, svc, rec, "", "data: [DONE]\n\n")
+	if rec.Header().Get(HeaderStreamID) != "" || rec.Body.String() != "data: [DONE]\n\n" {
+		t.Fatalf("disabled replay changed the stream: %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,390 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// StreamSalvage completes the event streams an upstream cuts off, for API keys
+// with SalvageStreams: when a stream ends without its terminal event, the
+// events that would have closed it are written, so non-interactive consumers
+// get what was received as a complete response instead of a truncated stream
+// they cannot parse. The closing events stop the response as if it reached its
+// token limit and carry "partial": true. Chat completions, Anthropic,
+// Responses and Gemini streams are completed; an event left incomplete by the
+// cut is dropped.
+func StreamSalvage() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			apiKey := GetAPIKey(c)
+			if apiKey == nil || !apiKey.SalvageStreams {
+				return next(c)
+			}
+
+			rw := c.Response().Writer
+			sw := &salvageWriter{ResponseWriter: rw}
+			c.Response().Writer = sw
+			err := next(c)
+			if format := sw.salvage(); format != "" {
+				LogTrace(c, "StreamSalvage", "Completed %s stream cut off after %d events (error: %v)", format, sw.events, err)
+			}
+			c.Response().Writer = rw
+			return err
+		}
+	}
+}
+
+// salvageWriter tracks the event stream it writes well enough to close it
+type salvageWriter struct {
+	http.ResponseWriter
+	mu     sync.Mutex
+	stream bool
+	status int
+	buf    bytes.Buffer // incomplete last event
+	format string       // chat, anthropic, responses or gemini
+	events int
+	done   bool // a terminal or error event was written
+
+	// chat completions: fields of the chunks and whether each choice finished
+	chunk   map[string]interface{}
+	choices map[int]bool
+
+	// Anthropic: open content blocks, whether the stop reason was sent and
+	// the output tokens reported so far
+	blocks       map[int]bool
+	stopped      bool
+	outputTokens interface{}
+
+	// Responses: the response, its finished output items by index and the
+	// text of its unfinished message items
+	response map[string]interface{}
+	items    map[int]interface{}
+	messages map[string]*salvagedMessage
+	sequence int
+}
+
+// salvagedMessage is an unfinished message item of a Responses stream
+type salvagedMessage struct {
+	item  map[string]interface{}
+	index int
+	text  strings.Builder
+}
+
+func (w *salvageWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	w.stream = strings.HasPrefix(w.Header().Get(echo.HeaderContentType), "text/event-stream")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *salvageWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stream {
+		return w.ResponseWriter.Write(b)
+	}
+
+	// Write out complete events only, so a cut never leaves half of one
+	w.buf.Write(b)
+	data := w.buf.Bytes()
+	end := bytes.LastIndex(data, []byte("\n\n"))
+	if end < 0 {
+		return len(b), nil
+	}
+	for _, event := range bytes.SplitAfter(data[:end+2], []byte("\n\n")) {
+		if len(event) > 0 {
+			w.track(event)
+		}
+	}
+	out := append([]byte(nil), data[:end+2]...)
+	rest := append([]byte(nil), data[end+2:]...)
+	w.buf.Reset()
+	w.buf.Write(rest)
+	if _, err := w.ResponseWriter.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *salvageWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// track notes what one server-sent event contributes to the stream
+func (w *salvageWriter) track(event []byte) {
+	name, payload := parseEvent(event)
+	if string(payload) == "[DONE]" {
+		w.done = true
+		return
+	}
+	var body map[string]interface{}
+	if len(payload) == 0 || payload[0] != '{' || json.Unmarshal(payload, &body) != nil {
+		return
+	}
+	w.events++
+	eventType, _ := body["type"].(string)
+	if name == "error" || eventType == "error" || body["error"] != nil {
+		w.done = true
+		return
+	}
+
+	switch {
+	case body["choices"] != nil:
+		w.format = "chat"
+		w.trackChatChunk(body)
+	case body["candidates"] != nil:
+		w.format = "gemini"
+		candidates, _ := body["candidates"].([]interface{})
+		for _, item := range candidates {
+			if candidate, _ := item.(map[string]interface{}); candidate["finishReason"] != nil {
+				w.done = true
+			}
+		}
+	case strings.HasPrefix(eventType, "response."):
+		w.format = "responses"
+		w.trackResponsesEvent(body, eventType)
+	case strings.HasPrefix(eventType, "message_") || strings.HasPrefix(eventType, "content_block_"):
+		w.format = "anthropic"
+		w.trackAnthropicEvent(body, eventType)
+	}
+}
+
+// trackChatChunk notes the choices of a chat completions chunk
+func (w *salvageWriter) trackChatChunk(chunk map[string]interface{}) {
+	if w.chunk == nil {
+		w.chunk = make(map[string]interface{})
+		w.choices = make(map[int]bool)
+	}
+	for _, field := range []string{"id", "object", "created", "model", "system_fingerprint"} {
+		if value, ok := chunk[field]; ok {
+			w.chunk[field] = value
+		}
+	}
+	choices, _ := chunk["choices"].([]interface{})
+	for _, item := range choices {
+		choice, _ := item.(map[string]interface{})
+		index := jsonInt(choice["index"])
+		if reason, _ := choice["finish_reason"].(string); reason != "" {
+			w.choices[index] = true
+		} else if _, ok := w.choices[index]; !ok {
+			w.choices[index] = false
+		}
+	}
+}
+
+// trackAnthropicEvent notes the content blocks and stop of an Anthropic stream
+func (w *salvageWriter) trackAnthropicEvent(body map[string]interface{}, eventType string) {
+	if w.blocks == nil {
+		w.blocks = make(map[int]bool)
+	}
+	switch eventType {
+	case "message_start":
+		message, _ := body["message"].(map[string]interface{})
+		if usage, _ := message["usage"].(map[string]interface{}); usage != nil {
+			w.outputTokens = usage["output_tokens"]
+		}
+	case "content_block_start":
+		w.blocks[jsonInt(body["index"])] = true
+	case "content_block_stop":
+		delete(w.blocks, jsonInt(body["index"]))
+	case "message_delta":
+		if usage, _ := body["usage"].(map[string]interface{}); usage != nil && usage["output_tokens"] != nil {
+			w.outputTokens = usage["output_tokens"]
+		}
+		if delta, _ := body["delta"].(map[string]interface{}); delta["stop_reason"] != nil {
+			w.stopped = true
+		}
+	case "message_stop":
+		w.done = true
+	}
+}
+
+// trackResponsesEvent notes the response and output items of a Responses stream
+func (w *salvageWriter) trackResponsesEvent(body map[string]interface{}, eventType string) {
+	if w.items == nil {
+		w.items = make(map[int]interface{})
+		w.messages = make(map[string]*salvagedMessage)
+	}
+	if sequence := jsonInt(body["sequence_number"]); sequence > w.sequence {
+		w.sequence = sequence
+	}
+	switch eventType {
+	case "response.created", "response.in_progress":
+		w.response, _ = body["response"].(map[string]interface{})
+	case "response.output_item.added":
+		if item, _ := body["item"].(map[string]interface{}); item["type"] == "message" {
+			id, _ := item["id"].(string)
+			w.messages[id] = &salvagedMessage{item: item, index: jsonInt(body["output_index"])}
+		}
+	case "response.output_text.delta":
+		id, _ := body["item_id"].(string)
+		if message, ok := w.messages[id]; ok {
+			delta, _ := body["delta"].(string)
+			message.text.WriteString(delta)
+		}
+	case "response.output_item.done":
+		item, _ := body["item"].(map[string]interface{})
+		id, _ := item["id"].(string)
+		delete(w.messages, id)
+		w.items[jsonInt(body["output_index"])] = item
+	case "response.completed", "response.incomplete", "response.failed":
+		w.done = true
+	}
+}
+
+// salvage writes the events closing a stream cut off before its terminal
+// event and returns its format, or "" when the stream needs none
+func (w *salvageWriter) salvage() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stream {
+		return ""
+	}
+
+	// A last event missing only its blank line is complete
+	rest := append([]byte(nil), w.buf.Bytes()...)
+	w.buf.Reset()
+	if _, payload := parseEvent(rest); len(payload) > 0 && (string(payload) == "[DONE]" || json.Valid(payload)) {
+		event := append(bytes.TrimRight(rest, "\n"), "\n\n"...)
+		w.track(event)
+		w.ResponseWriter.Write(event)
+		rest = nil
+	}
+	if w.status >= 300 || w.done || w.events == 0 || w.format == "" {
+		if len(rest) > 0 {
+			w.ResponseWriter.Write(rest)
+		}
+		return ""
+	}
+
+	var out bytes.Buffer
+	switch w.format {
+	case "chat":
+		var unfinished []int
+		for index, finished := range w.choices {
+			if !finished {
+				unfinished = append(unfinished, index)
+			}
+		}
+		sort.Ints(unfinished)
+		for _, index := range unfinished {
+			chunk := map[string]interface{}{
+				"choices": []interface{}{map[string]interface{}{"index": index, "delta": map[string]interface{}{}, "finish_reason": "length"}},
+				"partial": true,
+			}
+			for field, value := range w.chunk {
+				chunk[field] = value
+			}
+			fmt.Fprintf(&out, "data: %s\n\n", eventJSON(chunk))
+		}
+		out.WriteString("data: [DONE]\n\n")
+	case "anthropic":
+		var open []int
+		for index := range w.blocks {
+			open = append(open, index)
+		}
+		sort.Ints(open)
+		for _, index := range open {
+			fmt.Fprintf(&out, "event: content_block_stop\ndata: %s\n\n", eventJSON(map[string]interface{}{"type": "content_block_stop", "index": index}))
+		}
+		if !w.stopped {
+			outputTokens := w.outputTokens
+			if outputTokens == nil {
+				outputTokens = 0
+			}
+			fmt.Fprintf(&out, "event: message_delta\ndata: %s\n\n", eventJSON(map[string]interface{}{
+				"type":    "message_delta",
+				"delta":   map[string]interface{}{"stop_reason": "max_tokens", "stop_sequence": nil},
+				"usage":   map[string]interface{}{"output_tokens": outputTokens},
+				"partial": true,
+			}))
+		}
+		fmt.Fprintf(&out, "event: message_stop\ndata: %s\n\n", eventJSON(map[string]interface{}{"type": "message_stop"}))
+	case "responses":
+		response := make(map[string]interface{})
+		for field, value := range w.response {
+			response[field] = value
+		}
+		response["status"] = "incomplete"
+		response["incomplete_details"] = map[string]interface{}{"reason": "max_output_tokens"}
+		response["partial"] = true
+		response["output"] = w.salvagedOutput()
+		fmt.Fprintf(&out, "event: response.incomplete\ndata: %s\n\n", eventJSON(map[string]interface{}{
+			"type":            "response.incomplete",
+			"sequence_number": w.sequence + 1,
+			"response":        response,
+		}))
+	case "gemini":
+		fmt.Fprintf(&out, "data: %s\n\n", eventJSON(map[string]interface{}{
+			"candidates": []interface{}{map[string]interface{}{"index": 0, "finishReason": "MAX_TOKENS"}},
+			"partial":    true,
+		}))
+	}
+	w.ResponseWriter.Write(out.Bytes())
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	return w.format
+}
+
+// parseEvent returns the name and data of a server-sent event
+func parseEvent(event []byte) (string, []byte) {
+	var name string
+	var payload []byte
+	for _, line := range bytes.Split(event, []byte("\n")) {
+		if value, ok := bytes.CutPrefix(line, []byte("event:")); ok {
+			name = string(bytes.TrimSpace(value))
+		}
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			payload = append(payload, bytes.TrimSpace(data)...)
+		}
+	}
+	return name, payload
+}
+
+// salvagedOutput returns the output items of a cut off Responses stream: the
+// finished ones and the unfinished messages with the text received so far
+func (w *salvageWriter) salvagedOutput() []interface{} {
+	items := make(map[int]interface{}, len(w.items)+len(w.messages))
+	for index, item := range w.items {
+		items[index] = item
+	}
+	for _, message := range w.messages {
+		item := make(map[string]interface{})
+		for field, value := range message.item {
+			item[field] = value
+		}
+		item["status"] = "incomplete"
+		item["content"] = []interface{}{map[string]interface{}{
+			"type": "output_text", "text": message.text.String(), "annotations": []interface{}{},
+		}}
+		items[message.index] = item
+	}
+
+	indexes := make([]int, 0, len(items))
+	for index := range items {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	output := make([]interface{}, 0, len(indexes))
+	for _, index := range indexes {
+		output = append(output, items[index])
+	}
+	return output
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ai_gateway/internal/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+// serveStreamSalvage streams events behind StreamSalvage, the last of them cut
+// off, for an API key with salvage on or off
+func serveStreamSalvage(t *testing.T, salvage bool, events ...string) string {
+	t.Helper()
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set(ContextKeyAPIKey, &database.APIKey{SalvageStreams: salvage})
+	upstreamErr := errors.New("unexpected EOF")
+	handler := func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+		c.Response().WriteHeader(http.StatusOK)
+		for _, event := range events {
+			c.Response().Write([]byte(event))
+		}
+		return upstreamErr
+	}
+	if err := StreamSalvage()(handler)(c); err != upstreamErr {
+		t.Fatalf("handler error = %v, want the upstream error", err)
+	}
+	return rec.Body.String()
+}
+
+func TestStreamSalvage_Chat(t *testing.T) {
+	events := []string{
+		`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"Hello"}}]}` + "\n\n",
+		`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":" wor`,
+	}
+
+	body := serveStreamSalvage(t, true, events...)
+	want := events[0] +
+		`data: {"choices":[{"delta":{},"finish_reason":"length","index":0}],"id":"chatcmpl-1","model":"gpt-4o","object":"chat.completion.chunk","partial":true}` + "\n\n" +
+		"data: [DONE]\n\n"
+	if body != want {
+		t.Fatalf("salvaged stream:\n%s\nwant:\n%s", body, want)
+	}
+
+	if body := serveStreamSalvage(t, false, events...); body != strings.Join(events, "") {
+		t.Fatalf("stream changed without salvage:\n%s", body)
+	}
+}
+
+func TestStreamSalvage_Anthropic(t *testing.T) {
+	body := serveStreamSalvage(t, true,
+		"event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"usage\":{\"input_tokens\":10,\"output_tokens\":1}}}\n\n",
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n",
+	)
+	want := "event: content_block_stop\ndata: {\"index\":0,\"type\":\"content_block_stop\"}\n\n" +
+		"event: message_delta\ndata: {\"delta\":{\"stop_reason\":\"max_tokens\",\"stop_sequence\":null},\"partial\":true,\"type\":\"message_delta\",\"usage\":{\"output_tokens\":1}}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+	if !strings.HasSuffix(body, "\"text\":\"Hi\"}}\n\n"+want) {
+		t.Fatalf("salvaged stream:\n%s", body)
+	}
+}
+
+func TestStreamSalvage_Responses(t *testing.T) {
+	body := serveStreamSalvage(t, true,
+		"event: response.created\ndata: {\"type\":\"response.created\",\"sequence_number\":0,\"response\":{\"id\":\"resp_1\",\"status\":\"in_progress\",\"output\":[]}}\n\n",
+		"event: response.output_item.added\ndata: {\"type\":\"response.output_item.added\",\"sequence_number\":1,\"output_index\":0,\"item\":{\"id\":\"msg_1\",\"type\":\"message\",\"role\":\"assistant\",\"status\":\"in_progress\",\"content\":[]}}\n\n",
+		"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"sequence_number\":2,\"item_id\":\"msg_1\",\"output_index\":0,\"content_index\":0,\"delta\":\"Hel\"}\n\n",
+		"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"sequence_number\":3,\"item_id\":\"msg_1\",\"output_index\":0,\"content_index\":0,\"delta\":\"lo\"}\n\n",
+	)
+	want := "event: response.incomplete\ndata: {\"response\":{\"id\":\"resp_1\",\"incomplete_details\":{\"reason\":\"max_output_tokens\"}," +
+		"\"output\":[{\"content\":[{\"annotations\":[],\"text\":\"Hello\",\"type\":\"output_text\"}],\"id\":\"msg_1\",\"role\":\"assistant\",\"status\":\"incomplete\",\"type\":\"message\"}]," +
+		"\"partial\":true,\"status\":\"incomplete\"},\"sequence_number\":4,\"type\":\"response.incomplete\"}\n\n"
+	if !strings.HasSuffix(body, want) {
+		t.Fatalf("salvaged stream:\n%s", body)
+	}
+}
+
+func TestStreamSalvage_CompleteStreamUnchanged(t *testing.T) {
+	events := []string{
+		"data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"Hi\"}]},\"index\":0}]}\n\n",
+		"data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"!\"}]},\"finishReason\":\"STOP\",\"index\":0}]}\n\n",
+	}
+	if body := serveStreamSalvage(t, true, events...); body != strings.Join(events, "") {
+		t.Fatalf("complete stream changed:\n%s", body)
+	}
+}
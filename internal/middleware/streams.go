@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"io"
+
+	"ai_gateway/internal/adapters"
+	"ai_gateway/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// StreamTracking registers the upstream streams opened by a request with tracker,
+// under the provider config the request was routed to when the stream opened
+func StreamTracking(tracker *services.StreamTracker) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx := adapters.WithStreamTracker(req.Context(), func(closer io.Closer) func() {
+				return tracker.Open(GetProviderConfig(c), closer)
+			})
+			c.SetRequest(req.WithContext(ctx))
+			return next(c)
+		}
+	}
+}
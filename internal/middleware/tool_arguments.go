@@ -0,0 +1,429 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ToolArguments checks the arguments of each tool call in event streams, for
+// API keys with ValidateToolArgs, once the call is complete. Arguments an
+// upstream cut short are completed with a synthesized delta closing their open
+// strings, objects and arrays; other invalid JSON ends the stream with an error
+// event, so agent frameworks fail cleanly instead of on a parse error mid-run.
+// Chat completions, Anthropic and Responses streams are checked; Gemini sends
+// function call arguments whole.
+func ToolArguments() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			apiKey := GetAPIKey(c)
+			if apiKey == nil || !apiKey.ValidateToolArgs {
+				return next(c)
+			}
+
+			rw := c.Response().Writer
+			tw := &toolArgumentsWriter{ResponseWriter: rw, trace: func(format string, args ...interface{}) {
+				LogTrace(c, "ToolArguments", format, args...)
+			}}
+			c.Response().Writer = tw
+			defer func() {
+				tw.close()
+				c.Response().Writer = rw
+			}()
+			return next(c)
+		}
+	}
+}
+
+// toolCall is the state of a streamed tool call
+type toolCall struct {
+	name      string
+	arguments strings.Builder
+	position  int // chat completions: index of the call in its choice
+}
+
+// toolArgumentsWriter checks the tool calls of the event stream it writes
+type toolArgumentsWriter struct {
+	http.ResponseWriter
+	trace       func(format string, args ...interface{})
+	mu          sync.Mutex
+	stream      bool
+	failed      bool         // an error event ended the stream
+	buf         bytes.Buffer // incomplete last event
+	calls       map[string]*toolCall
+	repaired    map[string]string // Responses: completed arguments by item ID
+	wroteHeader bool
+}
+
+func (w *toolArgumentsWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.stream = strings.HasPrefix(w.Header().Get(echo.HeaderContentType), "text/event-stream")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *toolArgumentsWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stream {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.failed {
+		return len(b), nil
+	}
+
+	// Check the complete events, keeping a partial one for the next write
+	w.buf.Write(b)
+	data := w.buf.Bytes()
+	end := bytes.LastIndex(data, []byte("\n\n"))
+	if end < 0 {
+		return len(b), nil
+	}
+	var out bytes.Buffer
+	for _, event := range bytes.SplitAfter(data[:end+2], []byte("\n\n")) {
+		if len(event) > 0 && !w.failed {
+			out.Write(w.checkEvent(event))
+		}
+	}
+	rest := append([]byte(nil), data[end+2:]...)
+	w.buf.Reset()
+	w.buf.Write(rest)
+	if _, err := w.ResponseWriter.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *toolArgumentsWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close writes out what is still buffered once the handler has returned
+func (w *toolArgumentsWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() > 0 && !w.failed {
+		w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+}
+
+// checkEvent tracks the tool calls of one server-sent event and returns what
+// to send in its place: the event, preceded by any completing delta or
+// replaced by an error
+func (w *toolArgumentsWriter) checkEvent(event []byte) []byte {
+	var payload []byte
+	for _, line := range bytes.Split(event, []byte("\n")) {
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			payload = append(payload, bytes.TrimSpace(data)...)
+		}
+	}
+	var body map[string]interface{}
+	if len(payload) == 0 || payload[0] != '{' || json.Unmarshal(payload, &body) != nil {
+		return event
+	}
+	if w.calls == nil {
+		w.calls = make(map[string]*toolCall)
+	}
+
+	if _, ok := body["choices"]; ok {
+		return w.checkChatChunk(event, body)
+	}
+	switch eventType, _ := body["type"].(string); {
+	case strings.HasPrefix(eventType, "content_block_"):
+		return w.checkAnthropicEvent(event, body, eventType)
+	case strings.HasPrefix(eventType, "response."):
+		return w.checkResponsesEvent(event, body, eventType)
+	}
+	return event
+}
+
+// call returns the state of the tool call with a key, starting it when new
+func (w *toolArgumentsWriter) call(key string) *toolCall {
+	call, ok := w.calls[key]
+	if !ok {
+		call = &toolCall{}
+		w.calls[key] = call
+	}
+	return call
+}
+
+// checkChatChunk tracks the tool calls of a chat completions chunk, checking
+// those of a choice once it has a finish reason
+func (w *toolArgumentsWriter) checkChatChunk(event []byte, chunk map[string]interface{}) []byte {
+	var prefix bytes.Buffer
+	choices, _ := chunk["choices"].([]interface{})
+	for _, item := range choices {
+		choice, _ := item.(map[string]interface{})
+		choiceIndex := jsonInt(choice["index"])
+		delta, _ := choice["delta"].(map[string]interface{})
+		toolCalls, _ := delta["tool_calls"].([]interface{})
+		for _, tc := range toolCalls {
+			tcMap, _ := tc.(map[string]interface{})
+			position := jsonInt(tcMap["index"])
+			call := w.call(fmt.Sprintf("chat:%d:%d", choiceIndex, position))
+			call.position = position
+			function, _ := tcMap["function"].(map[string]interface{})
+			if name, _ := function["name"].(string); name != "" {
+				call.name = name
+			}
+			arguments, _ := function["arguments"].(string)
+			call.arguments.WriteString(arguments)
+		}
+
+		if reason, _ := choice["finish_reason"].(string); reason == "" {
+			continue
+		}
+		keyPrefix := fmt.Sprintf("chat:%d:", choiceIndex)
+		var keys []string
+		for key := range w.calls {
+			if strings.HasPrefix(key, keyPrefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return w.calls[keys[i]].position < w.calls[keys[j]].position })
+		for _, key := range keys {
+			call := w.calls[key]
+			delete(w.calls, key)
+			suffix, ok := completeJSON(call.arguments.String())
+			if !ok {
+				return w.fail(call, fmt.Sprintf("data: %s\n\n", eventJSON(map[string]interface{}{
+					"error": map[string]interface{}{"message": invalidArgumentsMessage(call), "type": "invalid_tool_arguments", "code": "invalid_tool_arguments"},
+				})))
+			}
+			if suffix == "" {
+				continue
+			}
+			w.trace("Completed arguments of tool call %q with %q", call.name, suffix)
+			fix := map[string]interface{}{
+				"choices": []interface{}{map[string]interface{}{
+					"index": choiceIndex,
+					"delta": map[string]interface{}{"tool_calls": []interface{}{map[string]interface{}{
+						"index":    call.position,
+						"function": map[string]interface{}{"arguments": suffix},
+					}}},
+				}},
+			}
+			for _, field := range []string{"id", "object", "created", "model"} {
+				if value, ok := chunk[field]; ok {
+					fix[field] = value
+				}
+			}
+			fmt.Fprintf(&prefix, "data: %s\n\n", eventJSON(fix))
+		}
+	}
+	if prefix.Len() == 0 {
+		return event
+	}
+	return append(prefix.Bytes(), event...)
+}
+
+// checkAnthropicEvent tracks the tool_use blocks of an Anthropic stream,
+// checking each once it stops
+func (w *toolArgumentsWriter) checkAnthropicEvent(event []byte, body map[string]interface{}, eventType string) []byte {
+	index := jsonInt(body["index"])
+	key := fmt.Sprintf("block:%d", index)
+	switch eventType {
+	case "content_block_start":
+		if block, _ := body["content_block"].(map[string]interface{}); block["type"] == "tool_use" {
+			call := w.call(key)
+			call.name, _ = block["name"].(string)
+		}
+	case "content_block_delta":
+		delta, _ := body["delta"].(map[string]interface{})
+		if call, ok := w.calls[key]; ok && delta["type"] == "input_json_delta" {
+			partial, _ := delta["partial_json"].(string)
+			call.arguments.WriteString(partial)
+		}
+	case "content_block_stop":
+		call, ok := w.calls[key]
+		if !ok {
+			return event
+		}
+		delete(w.calls, key)
+		suffix, ok := completeJSON(call.arguments.String())
+		if !ok {
+			return w.fail(call, fmt.Sprintf("event: error\ndata: %s\n\n", eventJSON(map[string]interface{}{
+				"type":  "error",
+				"error": map[string]interface{}{"type": "api_error", "message": invalidArgumentsMessage(call)},
+			})))
+		}
+		if suffix != "" {
+			w.trace("Completed input of tool_use block %q with %q", call.name, suffix)
+			fix := eventJSON(map[string]interface{}{
+				"type":  "content_block_delta",
+				"index": index,
+				"delta": map[string]interface{}{"type": "input_json_delta", "partial_json": suffix},
+			})
+			return append([]byte(fmt.Sprintf("event: content_block_delta\ndata: %s\n\n", fix)), event...)
+		}
+	}
+	return event
+}
+
+// checkResponsesEvent tracks the function calls of a Responses stream,
+// checking each when its arguments are done. Completed arguments replace the
+// broken ones in the events that repeat them.
+func (w *toolArgumentsWriter) checkResponsesEvent(event []byte, body map[string]interface{}, eventType string) []byte {
+	switch eventType {
+	case "response.output_item.added":
+		if item, _ := body["item"].(map[string]interface{}); item["type"] == "function_call" {
+			id, _ := item["id"].(string)
+			call := w.call("item:" + id)
+			call.name, _ = item["name"].(string)
+		}
+	case "response.function_call_arguments.delta":
+		id, _ := body["item_id"].(string)
+		delta, _ := body["delta"].(string)
+		w.call("item:" + id).arguments.WriteString(delta)
+	case "response.function_call_arguments.done":
+		id, _ := body["item_id"].(string)
+		call := w.call("item:" + id)
+		delete(w.calls, "item:"+id)
+		arguments := call.arguments.String()
+		if done, ok := body["arguments"].(string); ok && arguments == "" {
+			arguments = done
+		}
+		suffix, ok := completeJSON(arguments)
+		if !ok {
+			return w.fail(call, fmt.Sprintf("event: error\ndata: %s\n\n", eventJSON(map[string]interface{}{
+				"type": "error", "code": "invalid_tool_arguments", "message": invalidArgumentsMessage(call),
+			})))
+		}
+		if suffix == "" {
+			return event
+		}
+		w.trace("Completed arguments of function call %q with %q", call.name, suffix)
+		if w.repaired == nil {
+			w.repaired = make(map[string]string)
+		}
+		w.repaired[id] = arguments + suffix
+		fix := eventJSON(map[string]interface{}{
+			"type":         "response.function_call_arguments.delta",
+			"item_id":      id,
+			"output_index": body["output_index"],
+			"delta":        suffix,
+		})
+		body["arguments"] = arguments + suffix
+		return []byte(fmt.Sprintf("event: response.function_call_arguments.delta\ndata: %s\n\nevent: %s\ndata: %s\n\n", fix, eventType, eventJSON(body)))
+	case "response.output_item.done":
+		if item, _ := body["item"].(map[string]interface{}); w.repairItem(item) {
+			return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, eventJSON(body)))
+		}
+	case "response.completed", "response.incomplete":
+		response, _ := body["response"].(map[string]interface{})
+		output, _ := response["output"].([]interface{})
+		changed := false
+		for _, item := range output {
+			itemMap, _ := item.(map[string]interface{})
+			changed = w.repairItem(itemMap) || changed
+		}
+		if changed {
+			return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, eventJSON(body)))
+		}
+	}
+	return event
+}
+
+// repairItem puts the completed arguments into a Responses function_call item
+func (w *toolArgumentsWriter) repairItem(item map[string]interface{}) bool {
+	id, _ := item["id"].(string)
+	arguments, ok := w.repaired[id]
+	if !ok || item["type"] != "function_call" {
+		return false
+	}
+	item["arguments"] = arguments
+	return true
+}
+
+// fail ends the stream with an error event about a tool call
+func (w *toolArgumentsWriter) fail(call *toolCall, event string) []byte {
+	w.trace("Ending stream: tool call %q has invalid arguments %q", call.name, call.arguments.String())
+	w.failed = true
+	return []byte(event)
+}
+
+func invalidArgumentsMessage(call *toolCall) string {
+	return fmt.Sprintf("the upstream sent invalid JSON arguments for tool call %q", call.name)
+}
+
+// eventJSON encodes an event payload without escaping HTML characters
+func eventJSON(v interface{}) []byte {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(v)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// jsonInt returns a decoded JSON number as an int
+func jsonInt(v interface{}) int {
+	n, _ := v.(float64)
+	return int(n)
+}
+
+// completeJSON returns what to append to streamed JSON arguments to make them
+// valid, "" when they already are, or false when closing the strings, objects
+// and arrays left open does not make them valid. Empty arguments stand for no
+// arguments and are valid.
+func completeJSON(arguments string) (string, bool) {
+	if strings.TrimSpace(arguments) == "" || json.Valid([]byte(arguments)) {
+		return "", true
+	}
+
+	var open []byte
+	inString, escaped := false, false
+	for i := 0; i < len(arguments); i++ {
+		ch := arguments[i]
+		switch {
+		case inString && escaped:
+			escaped = false
+		case inString && ch == '\\':
+			escaped = true
+		case inString && ch == '"':
+			inString = false
+		case inString:
+		case ch == '"':
+			inString = true
+		case ch == '{':
+			open = append(open, '}')
+		case ch == '[':
+			open = append(open, ']')
+		case ch == '}' || ch == ']':
+			if len(open) == 0 || open[len(open)-1] != ch {
+				return "", false
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	if escaped {
+		return "", false
+	}
+
+	var suffix []byte
+	if inString {
+		suffix = append(suffix, '"')
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		suffix = append(suffix, open[i])
+	}
+	if len(suffix) == 0 || !json.Valid([]byte(arguments+string(suffix))) {
+		return "", false
+	}
+	return string(suffix), true
+}
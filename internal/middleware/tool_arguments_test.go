@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ai_gateway/internal/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+// serveToolArguments streams events behind ToolArguments, split into writes at
+// odd places, for an API key with validation on or off
+func serveToolArguments(t *testing.T, validate bool, events ...string) string {
+	t.Helper()
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set(ContextKeyAPIKey, &database.APIKey{ValidateToolArgs: validate})
+	handler := func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+		c.Response().WriteHeader(http.StatusOK)
+		stream := strings.Join(events, "")
+		for len(stream) > 0 {
+			n := 7
+			if n > len(stream) {
+				n = len(stream)
+			}
+			c.Response().Write([]byte(stream[:n]))
+			stream = stream[n:]
+		}
+		return nil
+	}
+	if err := ToolArguments()(handler)(c); err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+	return rec.Body.String()
+}
+
+func TestCompleteJSON(t *testing.T) {
+	cases := []struct {
+		arguments string
+		suffix    string
+		ok        bool
+	}{
+		{"", "", true},
+		{`{"city":"Paris"}`, "", true},
+		{`{"city":"Par`, `"}`, true},
+		{`{"cities":["Paris",{"name":"Rome"`, "}]}", true},
+		{`{"note":"a \"quoted\" }`, `"}`, true},
+		{`{"city":`, "", false},
+		{`{"city":"Paris"]`, "", false},
+		{`{"city":"Paris\`, "", false},
+	}
+	for _, tc := range cases {
+		suffix, ok := completeJSON(tc.arguments)
+		if suffix != tc.suffix || ok != tc.ok {
+			t.Errorf("completeJSON(%q) = %q, %v; want %q, %v", tc.arguments, suffix, ok, tc.suffix, tc.ok)
+		}
+	}
+}
+
+func TestToolArguments_CompletesChatToolCall(t *testing.T) {
+	body := serveToolArguments(t, true,
+		`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Par"}}]}}]}`+"\n\n",
+		`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`+"\n\n",
+		"data: [DONE]\n\n",
+	)
+	fix := `data: {"choices":[{"delta":{"tool_calls":[{"function":{"arguments":"\"}"},"index":0}]},"index":0}],"id":"chatcmpl-1","object":"chat.completion.chunk"}`
+	if !strings.Contains(body, fix+"\n\ndata: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\"") {
+		t.Fatalf("completing delta not sent before the finish chunk:\n%s", body)
+	}
+	if !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Fatalf("stream not finished:\n%s", body)
+	}
+}
+
+func TestToolArguments_EndsAnthropicStreamOnBrokenInput(t *testing.T) {
+	body := serveToolArguments(t, true,
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"weather\",\"input\":{}}}\n\n",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"city\\\":\"}}\n\n",
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":1}\n\n",
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+	)
+	if !strings.HasSuffix(body, "event: error\ndata: {\"error\":{\"message\":\"the upstream sent invalid JSON arguments for tool call \\\"weather\\\"\",\"type\":\"api_error\"},\"type\":\"error\"}\n\n") {
+		t.Fatalf("stream not ended with an error event:\n%s", body)
+	}
+	if strings.Contains(body, "content_block_stop") || strings.Contains(body, "message_stop") {
+		t.Fatalf("events sent after the error:\n%s", body)
+	}
+}
+
+func TestToolArguments_CompletesResponsesFunctionCall(t *testing.T) {
+	body := serveToolArguments(t, true,
+		"event: response.output_item.added\ndata: {\"type\":\"response.output_item.added\",\"output_index\":0,\"item\":{\"type\":\"function_call\",\"id\":\"fc_1\",\"name\":\"weather\",\"arguments\":\"\"}}\n\n",
+		"event: response.function_call_arguments.delta\ndata: {\"type\":\"response.function_call_arguments.delta\",\"item_id\":\"fc_1\",\"output_index\":0,\"delta\":\"{\\\"days\\\":[1,2\"}\n\n",
+		"event: response.function_call_arguments.done\ndata: {\"type\":\"response.function_call_arguments.done\",\"item_id\":\"fc_1\",\"output_index\":0,\"arguments\":\"{\\\"days\\\":[1,2\"}\n\n",
+		"event: response.output_item.done\ndata: {\"type\":\"response.output_item.done\",\"output_index\":0,\"item\":{\"type\":\"function_call\",\"id\":\"fc_1\",\"name\":\"weather\",\"arguments\":\"{\\\"days\\\":[1,2\"}}\n\n",
+	)
+	for _, want := range []string{
+		`"delta":"]}"`,
+		`"arguments":"{\"days\":[1,2]}","item_id":"fc_1"`,
+		`"item":{"arguments":"{\"days\":[1,2]}"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("stream lacks %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestToolArguments_PassesThroughWhenDisabled(t *testing.T) {
+	event := `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"a\":"}}]},"finish_reason":"tool_calls"}]}` + "\n\n"
+	if body := serveToolArguments(t, false, event); body != event {
+		t.Fatalf("stream changed with validation off:\n%s", body)
+	}
+}
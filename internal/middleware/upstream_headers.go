@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"ai_gateway/internal/adapters"
+
+	"github.com/labstack/echo/v4"
+)
+
+// UpstreamHeaders passes the allowlisted headers of upstream responses, such as
+// rate limit headers, through to the client so SDK backoff logic keeps working.
+// Names ending in "*" match by prefix. Headers set by the gateway take precedence.
+func UpstreamHeaders(allowlist []string) echo.MiddlewareFunc {
+	var exact, prefixes []string
+	for _, name := range allowlist {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			prefixes = append(prefixes, prefix)
+		} else if name != "" {
+			exact = append(exact, name)
+		}
+	}
+	allowed := func(name string) bool {
+		name = strings.ToLower(name)
+		for _, candidate := range exact {
+			if name == candidate {
+				return true
+			}
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if len(exact) == 0 && len(prefixes) == 0 {
+			return next
+		}
+		return func(c echo.Context) error {
+			rw := c.Response().Writer
+			hw := &upstreamHeaderWriter{ResponseWriter: rw, upstream: http.Header{}}
+			c.Response().Writer = hw
+			defer func() { c.Response().Writer = rw }()
+
+			req := c.Request()
+			ctx := adapters.WithResponseHeaderSink(req.Context(), func(header http.Header) {
+				hw.mu.Lock()
+				defer hw.mu.Unlock()
+				for name, values := range header {
+					if allowed(name) {
+						hw.upstream[name] = append([]string(nil), values...)
+					}
+				}
+			})
+			c.SetRequest(req.WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// upstreamHeaderWriter adds the collected upstream headers to the response
+// when its header is written
+type upstreamHeaderWriter struct {
+	http.ResponseWriter
+	mu       sync.Mutex
+	upstream http.Header
+}
+
+func (w *upstreamHeaderWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	header := w.Header()
+	for name, values := range w.upstream {
+		if _, exists := header[name]; !exists {
+			header[name] = values
+		}
+	}
+	w.mu.Unlock()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *upstreamHeaderWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/adapters"
+	"ai_gateway/internal/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TestUpstreamHeaders_WithGatewayRateLimitHeaders runs a request through
+// RateLimitHeaders and UpstreamHeaders in the order of the gateway chain: a
+// header both set keeps the gateway's value, and the provider's other
+// x-ratelimit-* headers reach the client unchanged.
+func TestUpstreamHeaders_WithGatewayRateLimitHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ratelimit-limit-requests", "5000")
+		w.Header().Set("x-ratelimit-remaining-requests", "4999")
+		w.Header().Set("x-ratelimit-limit-tokens", "80000")
+		w.Header().Set("x-ratelimit-remaining-tokens", "79000")
+		w.Header().Set("x-request-id", "req_upstream")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-1","choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	limit := 10
+	key := &database.APIKey{ID: 1, DailyRequestLimit: &limit, DailyRequestsUsed: 3, DailyResetAt: time.Now().Add(time.Hour)}
+	setKey := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(ContextKeyAPIKey, key)
+			return next(c)
+		}
+	}
+	handler := func(c echo.Context) error {
+		// The gateway's request ID wins over the upstream's
+		c.Response().Header().Set("X-Request-Id", "gateway")
+		resp, status, err := adapters.NewOpenAIAdapter("sk-test", upstream.URL).ChatCompletions(c.Request().Context(), map[string]interface{}{"model": "gpt-4o"})
+		if err != nil {
+			return err
+		}
+		return c.JSON(status, resp)
+	}
+	e := echo.New()
+	e.POST("/v1/chat/completions", handler, setKey, RateLimitHeaders(time.UTC),
+		UpstreamHeaders([]string{"x-ratelimit-*", "x-request-id"}))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	want := map[string]string{
+		"X-Ratelimit-Limit-Requests":     "10",
+		"X-Ratelimit-Remaining-Requests": "6",
+		"X-Ratelimit-Limit-Tokens":       "80000",
+		"X-Ratelimit-Remaining-Tokens":   "79000",
+		"X-Request-Id":                   "gateway",
+	}
+	for name, value := range want {
+		if got := rec.Header().Values(name); len(got) != 1 || got[0] != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
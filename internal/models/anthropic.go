@@ -20,7 +20,7 @@ type MessagesRequest struct {
 	Stream        bool               `json:"stream,omitempty"`
 	Metadata      *Metadata          `json:"metadata,omitempty"`
 	Tools         []AnthropicTool    `json:"tools,omitempty"`
-	ToolChoice    interface{}        `json:"tool_choice,omitempty"` // ToolChoiceAuto or ToolChoiceAny or ToolChoiceTool
+	ToolChoice    interface{}        `json:"tool_choice,omitempty"` // ToolChoiceAuto, ToolChoiceAny, ToolChoiceTool or ToolChoiceNone
 }
 
 // Validate validates the request according to Anthropic API specifications
@@ -61,6 +61,10 @@ func (r *MessagesRequest) validateToolChoice() error {
 		if choice.Type != "any" {
 			return fmt.Errorf("tool_choice type must be 'any'")
 		}
+	case ToolChoiceNone:
+		if choice.Type != "none" {
+			return fmt.Errorf("tool_choice type must be 'none'")
+		}
 	case ToolChoiceTool:
 		if choice.Type != "tool" {
 			return fmt.Errorf("tool_choice type must be 'tool'")
@@ -72,7 +76,7 @@ func (r *MessagesRequest) validateToolChoice() error {
 		// Handle JSON unmarshaled interface
 		if t, ok := choice["type"].(string); ok {
 			switch t {
-			case "auto", "any":
+			case "auto", "any", "none":
 				// Valid types without additional validation
 			case "tool":
 				if name, ok := choice["name"].(string); !ok || name == "" {
@@ -99,9 +103,10 @@ type AnthropicMessage struct {
 
 // ContentBlock represents a content block
 type ContentBlock struct {
-	Type      string       `json:"type"` // text, image, tool_use, tool_result
+	Type      string       `json:"type"` // text, image, document, tool_use, tool_result
 	Text      string       `json:"text,omitempty"`
-	Source    *ImageSource `json:"source,omitempty"`      // For image blocks
+	Source    *ImageSource `json:"source,omitempty"`      // For image and document blocks
+	Title     string       `json:"title,omitempty"`       // For document blocks
 	ID        string       `json:"id,omitempty"`          // For tool_use blocks
 	Name      string       `json:"name,omitempty"`        // For tool_use blocks
 	Input     interface{}  `json:"input,omitempty"`       // For tool_use blocks (object)
@@ -110,11 +115,12 @@ type ContentBlock struct {
 	IsError   bool         `json:"is_error,omitempty"`    // For tool_result blocks
 }
 
-// ImageSource represents an image source
+// ImageSource represents the source of an image or document block
 type ImageSource struct {
-	Type      string `json:"type"`       // base64
-	MediaType string `json:"media_type"` // image/jpeg, image/png, etc.
-	Data      string `json:"data"`
+	Type      string `json:"type"`                 // base64, url, text
+	MediaType string `json:"media_type,omitempty"` // image/jpeg, image/png, application/pdf, text/plain, etc.
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"` // For url sources
 }
 
 // SystemBlock represents a system content block
@@ -138,7 +144,8 @@ type Metadata struct {
 type AnthropicTool struct {
 	Name        string      `json:"name"`
 	Description string      `json:"description,omitempty"`
-	InputSchema interface{} `json:"input_schema"` // JSON Schema object
+	InputSchema interface{} `json:"input_schema"`     // JSON Schema object
+	Strict      *bool       `json:"strict,omitempty"` // enforce the schema exactly
 }
 
 // ValidateInputSchema validates the input schema is a proper dictionary
@@ -163,18 +170,26 @@ func (t *AnthropicTool) ValidateInputSchema() error {
 
 // ToolChoiceAuto represents auto tool choice
 type ToolChoiceAuto struct {
-	Type string `json:"type"` // auto
+	Type                   string `json:"type"` // auto
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
 }
 
 // ToolChoiceAny represents any tool choice
 type ToolChoiceAny struct {
-	Type string `json:"type"` // any
+	Type                   string `json:"type"` // any
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
 }
 
 // ToolChoiceTool represents a specific tool choice
 type ToolChoiceTool struct {
-	Type string `json:"type"` // tool
-	Name string `json:"name"`
+	Type                   string `json:"type"` // tool
+	Name                   string `json:"name"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
+// ToolChoiceNone represents a tool choice that forbids tool use
+type ToolChoiceNone struct {
+	Type string `json:"type"` // none
 }
 
 // MessagesResponse represents an Anthropic messages response
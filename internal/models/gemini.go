@@ -24,6 +24,7 @@ type GeminiContent struct {
 type GeminiPart struct {
 	Text             string            `json:"text,omitempty"`
 	InlineData       *InlineData       `json:"inlineData,omitempty"`
+	FileData         *FileData         `json:"fileData,omitempty"`
 	FunctionCall     *GeminiFunctionCall `json:"functionCall,omitempty"`
 	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
 }
@@ -34,6 +35,12 @@ type InlineData struct {
 	Data     string `json:"data"` // base64 encoded
 }
 
+// FileData represents data referenced by URI (uploaded files, remote images)
+type FileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
 // GeminiFunctionCall represents a function call from Gemini
 type GeminiFunctionCall struct {
 	Name string                 `json:"name"`
@@ -71,13 +78,15 @@ type FunctionCallingConfig struct {
 
 // GenerationConfig represents generation configuration
 type GenerationConfig struct {
-	Temperature     *float64 `json:"temperature,omitempty"`
-	TopP            *float64 `json:"topP,omitempty"`
-	TopK            *int     `json:"topK,omitempty"`
-	MaxOutputTokens *int     `json:"maxOutputTokens,omitempty"`
-	StopSequences   []string `json:"stopSequences,omitempty"`
-	CandidateCount  *int     `json:"candidateCount,omitempty"`
-	ResponseMimeType string  `json:"responseMimeType,omitempty"` // text/plain, application/json
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"topP,omitempty"`
+	TopK             *int     `json:"topK,omitempty"`
+	MaxOutputTokens  *int     `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	CandidateCount   *int     `json:"candidateCount,omitempty"`
+	PresencePenalty  *float64 `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequencyPenalty,omitempty"`
+	ResponseMimeType string   `json:"responseMimeType,omitempty"` // text/plain, application/json
 }
 
 // SafetySetting represents a safety setting
@@ -25,22 +25,67 @@ type ChatCompletionRequest struct {
 	Seed             *int                   `json:"seed,omitempty"`
 	LogProbs         *bool                  `json:"logprobs,omitempty"`
 	TopLogProbs      *int                   `json:"top_logprobs,omitempty"`
+
+	// ParallelToolCalls allows several tool calls in one turn; upstreams default to true
+	ParallelToolCalls *bool `json:"parallel_tool_calls,omitempty"`
+
+	// Reasoning models: effort spent reasoning and verbosity of the answer
+	ReasoningEffort string `json:"reasoning_effort,omitempty"` // minimal, low, medium, high
+	Verbosity       string `json:"verbosity,omitempty"`        // low, medium, high
+
+	// ConversationID is a gateway extension: prepend the stored history of this
+	// conversation and persist the new turns. Never forwarded upstream.
+	ConversationID string `json:"conversation_id,omitempty"`
+
+	// WebSearch is a gateway extension: offer the model a web_search tool that
+	// the gateway executes. Never forwarded upstream.
+	WebSearch bool `json:"web_search,omitempty"`
+
+	// MaxCost is a gateway extension: the most this request may cost in
+	// credits, like the X-Max-Cost header. Never forwarded upstream.
+	MaxCost *float64 `json:"max_cost,omitempty"`
 }
 
 // ChatMessage represents a message in a chat conversation
 type ChatMessage struct {
-	Role       string      `json:"role"` // system, user, assistant, tool
-	Content    interface{} `json:"content,omitempty"` // string or []ContentPart
-	Name       string      `json:"name,omitempty"`
-	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`
-	ToolCallID string      `json:"tool_call_id,omitempty"`
+	Role        string       `json:"role"` // system, user, assistant, tool
+	Content     interface{}  `json:"content,omitempty"` // string or []ContentPart
+	Refusal     string       `json:"refusal,omitempty"` // assistant refusal instead of content
+	Name        string       `json:"name,omitempty"`
+	ToolCalls   []ToolCall   `json:"tool_calls,omitempty"`
+	ToolCallID  string       `json:"tool_call_id,omitempty"`
+	Annotations []Annotation `json:"annotations,omitempty"` // citations in assistant content
+}
+
+// Annotation represents a citation attached to assistant message content
+type Annotation struct {
+	Type         string        `json:"type"` // url_citation, file_citation
+	URLCitation  *URLCitation  `json:"url_citation,omitempty"`
+	FileCitation *FileCitation `json:"file_citation,omitempty"`
+}
+
+// URLCitation cites a web page for the content between two character indices
+type URLCitation struct {
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
+	URL        string `json:"url"`
+	Title      string `json:"title,omitempty"`
+}
+
+// FileCitation cites an uploaded file at a character index of the content
+type FileCitation struct {
+	FileID   string `json:"file_id"`
+	Filename string `json:"filename,omitempty"`
+	Index    int    `json:"index"`
 }
 
 // ContentPart represents a part of message content (for multimodal)
 type ContentPart struct {
-	Type     string    `json:"type"` // text, image_url
-	Text     string    `json:"text,omitempty"`
-	ImageURL *ImageURL `json:"image_url,omitempty"`
+	Type       string      `json:"type"` // text, image_url, file, input_audio
+	Text       string      `json:"text,omitempty"`
+	ImageURL   *ImageURL   `json:"image_url,omitempty"`
+	File       *FilePart   `json:"file,omitempty"`
+	InputAudio *InputAudio `json:"input_audio,omitempty"`
 }
 
 // ImageURL represents an image URL in message content
@@ -49,6 +94,20 @@ type ImageURL struct {
 	Detail string `json:"detail,omitempty"` // auto, low, high
 }
 
+// FilePart represents a file (e.g. a PDF) in message content, either inline
+// as a data URI or as an uploaded file ID
+type FilePart struct {
+	FileData string `json:"file_data,omitempty"` // data:application/pdf;base64,...
+	FileID   string `json:"file_id,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// InputAudio represents base64 audio in message content
+type InputAudio struct {
+	Data   string `json:"data"`   // base64 encoded
+	Format string `json:"format"` // wav, mp3
+}
+
 // Tool represents a tool/function definition
 type Tool struct {
 	Type     string   `json:"type"` // function
@@ -60,6 +119,7 @@ type Function struct {
 	Name        string      `json:"name"`
 	Description string      `json:"description,omitempty"`
 	Parameters  interface{} `json:"parameters,omitempty"` // JSON Schema object
+	Strict      *bool       `json:"strict,omitempty"`     // enforce the schema exactly
 }
 
 // ToolCall represents a tool call from the assistant
@@ -88,7 +148,16 @@ type ToolChoiceFunction struct {
 
 // ResponseFormat represents the response format
 type ResponseFormat struct {
-	Type string `json:"type"` // text, json_object
+	Type       string            `json:"type"` // text, json_object, json_schema
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat represents the schema of a json_schema response format
+type JSONSchemaFormat struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Schema      interface{} `json:"schema,omitempty"`
+	Strict      *bool       `json:"strict,omitempty"`
 }
 
 // ChatCompletionResponse represents an OpenAI chat completion response
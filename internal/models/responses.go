@@ -0,0 +1,86 @@
+package models
+
+// ResponsesResponse represents an OpenAI Responses API response
+type ResponsesResponse struct {
+	ID                string                      `json:"id"`
+	Object            string                      `json:"object,omitempty"` // response
+	CreatedAt         int64                       `json:"created_at,omitempty"`
+	Model             string                      `json:"model"`
+	Status            string                      `json:"status"` // in_progress, completed, incomplete, failed
+	Output            []ResponsesOutputItem       `json:"output,omitempty"`
+	Usage             *ResponsesUsage             `json:"usage,omitempty"`
+	IncompleteDetails *ResponsesIncompleteDetails `json:"incomplete_details,omitempty"`
+}
+
+// ResponsesOutputItem represents an item of a Responses output
+type ResponsesOutputItem struct {
+	ID        string                  `json:"id,omitempty"`
+	Type      string                  `json:"type"` // message, function_call, reasoning
+	Role      string                  `json:"role,omitempty"`
+	Status    string                  `json:"status,omitempty"`
+	Content   *[]ResponsesContentPart `json:"content,omitempty"` // set for messages, possibly empty
+	CallID    string                  `json:"call_id,omitempty"`
+	Name      string                  `json:"name,omitempty"`
+	Arguments *string                 `json:"arguments,omitempty"` // set for function calls, possibly empty
+}
+
+// ResponsesContentPart represents a content part of a Responses message
+type ResponsesContentPart struct {
+	Type    string `json:"type"` // output_text, refusal
+	Text    string `json:"text"`
+	Refusal string `json:"refusal,omitempty"` // set for refusal parts
+}
+
+// ResponsesAnnotation represents a citation in Responses output_text. Indices
+// count characters of the content part's text.
+type ResponsesAnnotation struct {
+	Type       string `json:"type"` // url_citation, file_citation
+	StartIndex int    `json:"start_index,omitempty"`
+	EndIndex   int    `json:"end_index,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Title      string `json:"title,omitempty"`
+	FileID     string `json:"file_id,omitempty"`
+	Filename   string `json:"filename,omitempty"`
+	Index      int    `json:"index,omitempty"`
+}
+
+// ResponsesUsage represents Responses token usage
+type ResponsesUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// ResponsesIncompleteDetails explains why a response is incomplete
+type ResponsesIncompleteDetails struct {
+	Reason string `json:"reason"` // max_output_tokens, content_filter
+}
+
+// ResponsesStreamEvent represents a Responses API stream event. Fields are set
+// according to the event type.
+type ResponsesStreamEvent struct {
+	Type         string                `json:"type"`
+	Response     *ResponsesResponse    `json:"response,omitempty"`      // response.created, response.completed
+	OutputIndex  *int                  `json:"output_index,omitempty"`  // output item events
+	ContentIndex *int                  `json:"content_index,omitempty"` // content part and text events
+	Item         *ResponsesOutputItem  `json:"item,omitempty"`          // response.output_item.added/done
+	Part         *ResponsesContentPart `json:"part,omitempty"`          // response.content_part.added/done
+	Delta        string                `json:"delta,omitempty"`         // text and arguments deltas
+	Annotation   *ResponsesAnnotation  `json:"annotation,omitempty"`    // response.output_text.annotation.added
+}
+
+// Index returns the output index of an event, 0 when it has none
+func (e *ResponsesStreamEvent) Index() int {
+	if e.OutputIndex == nil {
+		return 0
+	}
+	return *e.OutputIndex
+}
+
+// PartIndex returns the content index of an event, 0 when it has none
+func (e *ResponsesStreamEvent) PartIndex() int {
+	if e.ContentIndex == nil {
+		return 0
+	}
+	return *e.ContentIndex
+}
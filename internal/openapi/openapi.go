@@ -0,0 +1,334 @@
+// Package openapi builds OpenAPI 3 documents from route declarations, deriving
+// the request and response schemas from the Go types handlers bind and return
+// so that the document can't drift from the code.
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the OpenAPI version of the documents built
+const Version = "3.0.3"
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"` // path -> lowercase method -> operation
+	Components Components                       `json:"components"`
+
+	types map[string]reflect.Type // component schema name -> its type
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Components holds the schemas and security schemes operations refer to
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme is a way to authenticate requests
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// Operation is one method of a path
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security"` // empty for public operations
+}
+
+// Parameter is a path or query parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"` // path or query
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is the body of a request
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response is a response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema of a body in one content type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON schema, in the OpenAPI 3.0 dialect
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+}
+
+// Route declares an operation. Request and Response are values of the types
+// the handler binds and returns, or nil for none; Response may also be a
+// slice value for a list.
+type Route struct {
+	Method      string // e.g. http.MethodGet
+	Path        string // in Echo syntax, e.g. /api/keys/:id
+	OperationID string
+	Summary     string
+	Tag         string
+	Params      []Parameter // query parameters, and path parameters replacing the defaults
+	Request     interface{}
+	Response    interface{}
+	Status      int    // of a successful response, http.StatusOK if 0
+	ContentType string // of the response, application/json if empty
+	Public      bool   // needs no bearer token
+}
+
+// BearerAuth names the security scheme of authenticated operations
+const BearerAuth = "bearerAuth"
+
+// New creates a Document with a bearer token security scheme
+func New(info Info) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]map[string]*Operation),
+		Components: Components{
+			Schemas: make(map[string]*Schema),
+			SecuritySchemes: map[string]*SecurityScheme{
+				BearerAuth: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+		types: make(map[string]reflect.Type),
+	}
+}
+
+// Add adds the operation a route declares
+func (d *Document) Add(r Route) {
+	path, params := convertPath(r.Path)
+	for _, param := range r.Params {
+		replaced := false
+		for i := range params {
+			if params[i].In == param.In && params[i].Name == param.Name {
+				params[i], replaced = param, true
+			}
+		}
+		if !replaced {
+			params = append(params, param)
+		}
+	}
+	op := &Operation{
+		OperationID: r.OperationID,
+		Summary:     r.Summary,
+		Parameters:  params,
+		Responses:   make(map[string]*Response),
+		Security:    []map[string][]string{},
+	}
+	if r.Tag != "" {
+		op.Tags = []string{r.Tag}
+	}
+	if !r.Public {
+		op.Security = []map[string][]string{{BearerAuth: {}}}
+	}
+	if r.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{"application/json": {Schema: d.schema(reflect.TypeOf(r.Request))}},
+		}
+	}
+
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := &Response{Description: http.StatusText(status)}
+	if r.Response != nil {
+		contentType := r.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		response.Content = map[string]MediaType{contentType: {Schema: d.schema(reflect.TypeOf(r.Response))}}
+	}
+	op.Responses[strconv.Itoa(status)] = response
+	op.Responses["default"] = &Response{
+		Description: "Error",
+		Content:     map[string]MediaType{"application/json": {Schema: d.schema(reflect.TypeOf(Error{}))}},
+	}
+
+	if d.Paths[path] == nil {
+		d.Paths[path] = make(map[string]*Operation)
+	}
+	d.Paths[path][strings.ToLower(r.Method)] = op
+}
+
+// Error is the body of the error responses of the management API
+type Error struct {
+	Message string `json:"message"`
+}
+
+// convertPath turns Echo path parameters into OpenAPI ones; parameters named
+// id are integers, others strings
+func convertPath(path string) (string, []Parameter) {
+	var params []Parameter
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		name := segment[1:]
+		schema := &Schema{Type: "string"}
+		if name == "id" {
+			schema = &Schema{Type: "integer"}
+		}
+		params = append(params, Parameter{Name: name, In: "path", Required: true, Schema: schema})
+		segments[i] = "{" + name + "}"
+	}
+	return strings.Join(segments, "/"), params
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the schema of a type, adding named structs to the components
+// and referring to them
+func (d *Document) schema(t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+
+	var s *Schema
+	switch {
+	case t == timeType:
+		s = &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		return d.ref(t, nullable)
+	case t.Kind() == reflect.Struct:
+		s = d.object(t)
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		s = &Schema{Type: "string", Format: "byte"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		// Nil slices are encoded as null
+		s = &Schema{Type: "array", Items: d.schema(t.Elem()), Nullable: t.Kind() == reflect.Slice}
+	case t.Kind() == reflect.Map:
+		s = &Schema{Type: "object", AdditionalProperties: d.schema(t.Elem())}
+	case t.Kind() == reflect.Bool:
+		s = &Schema{Type: "boolean"}
+	case t.Kind() == reflect.String:
+		s = &Schema{Type: "string"}
+	case t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64:
+		s = &Schema{Type: "integer", Format: "int64"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		s = &Schema{Type: "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		s = &Schema{Type: "number"}
+	default:
+		// interface{} and the like hold any value
+		s = &Schema{}
+	}
+	s.Nullable = s.Nullable || nullable
+	return s
+}
+
+// ref returns a reference to the component schema of a named struct type,
+// adding it first. Names taken by a type of another package are qualified.
+func (d *Document) ref(t reflect.Type, nullable bool) *Schema {
+	name := t.Name()
+	if other, ok := d.types[name]; ok && other != t {
+		name = pkgName(t) + name
+	}
+	if _, ok := d.types[name]; !ok {
+		d.types[name] = t
+		d.Components.Schemas[name] = &Schema{} // placeholder for recursive types
+		d.Components.Schemas[name] = d.object(t)
+	}
+	s := &Schema{Ref: "#/components/schemas/" + name}
+	if nullable {
+		// Siblings of $ref are ignored in OpenAPI 3.0, so a nullable reference
+		// wraps it
+		return &Schema{Nullable: true, AllOf: []*Schema{s}}
+	}
+	return s
+}
+
+// pkgName returns the capitalized last element of a type's package path
+func pkgName(t reflect.Type) string {
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	if pkg == "" {
+		return ""
+	}
+	return strings.ToUpper(pkg[:1]) + pkg[1:]
+}
+
+// object returns the schema of a struct's JSON encoding. Embedded structs
+// without a JSON name are flattened into it, as encoding/json does, and fields
+// tagged validate:"required" are required.
+func (d *Document) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				inner := d.object(embedded)
+				for prop, schema := range inner.Properties {
+					s.Properties[prop] = schema
+				}
+				s.Required = append(s.Required, inner.Required...)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = d.schema(field.Type)
+		if validate := field.Tag.Get("validate"); strings.Contains(","+validate+",", ",required,") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	sort.Strings(s.Required)
+	return s
+}
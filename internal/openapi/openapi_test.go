@@ -0,0 +1,105 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testBase struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type testItem struct {
+	testBase
+	Name     string            `json:"name" validate:"required,min=1"`
+	Secret   string            `json:"-"`
+	Note     *string           `json:"note,omitempty"`
+	Tags     []string          `json:"tags"`
+	Labels   map[string]int    `json:"labels"`
+	Parent   *testItem         `json:"parent"`
+	Extra    interface{}       `json:"extra"`
+	Cost     int64             `json:"cost"`
+	Meta     struct{ A bool }  `json:"meta"`
+	Children []testItem        `json:"children"`
+	Raw      map[string]string `json:"raw,omitempty"`
+}
+
+func TestAddDerivesSchemas(t *testing.T) {
+	d := New(Info{Title: "test", Version: "1"})
+	d.Add(Route{Method: http.MethodPut, Path: "/items/:id/tags/:tag", OperationID: "putItem", Request: testItem{}, Response: []testItem{}})
+
+	op := d.Paths["/items/{id}/tags/{tag}"]["put"]
+	if op == nil {
+		t.Fatalf("operation not added, paths: %v", d.Paths)
+	}
+	if len(op.Parameters) != 2 || op.Parameters[0].Schema.Type != "integer" || op.Parameters[1].Schema.Type != "string" {
+		t.Errorf("path parameters = %+v", op.Parameters)
+	}
+	if len(op.Security) != 1 {
+		t.Errorf("security = %v, want bearer auth", op.Security)
+	}
+	if ref := op.RequestBody.Content["application/json"].Schema.Ref; ref != "#/components/schemas/testItem" {
+		t.Errorf("request schema ref = %q", ref)
+	}
+	if items := op.Responses["200"].Content["application/json"].Schema.Items; items == nil || items.Ref != "#/components/schemas/testItem" {
+		t.Errorf("response schema items = %+v", items)
+	}
+
+	item := d.Components.Schemas["testItem"]
+	var names []string
+	for name := range item.Properties {
+		names = append(names, name)
+	}
+	for _, name := range []string{"id", "created_at", "name", "note", "tags", "labels", "parent", "extra", "cost", "meta", "children", "raw"} {
+		if item.Properties[name] == nil {
+			t.Errorf("property %q missing, have %v", name, names)
+		}
+	}
+	if len(item.Properties) != 12 {
+		t.Errorf("properties = %v, want 12", names)
+	}
+	if !reflect.DeepEqual(item.Required, []string{"name"}) {
+		t.Errorf("required = %v", item.Required)
+	}
+	if p := item.Properties["created_at"]; p.Type != "string" || p.Format != "date-time" {
+		t.Errorf("created_at = %+v", p)
+	}
+	if p := item.Properties["note"]; p.Type != "string" || !p.Nullable {
+		t.Errorf("note = %+v", p)
+	}
+	if p := item.Properties["parent"]; !p.Nullable || len(p.AllOf) != 1 || p.AllOf[0].Ref != "#/components/schemas/testItem" {
+		t.Errorf("parent = %+v", p)
+	}
+	if p := item.Properties["labels"]; p.Type != "object" || p.AdditionalProperties.Type != "integer" {
+		t.Errorf("labels = %+v", p)
+	}
+	if p := item.Properties["cost"]; p.Format != "int64" {
+		t.Errorf("cost = %+v", p)
+	}
+	if p := item.Properties["meta"]; p.Type != "object" || p.Properties["A"].Type != "boolean" {
+		t.Errorf("meta = %+v", p)
+	}
+}
+
+func TestPathParamOverride(t *testing.T) {
+	d := New(Info{Title: "test", Version: "1"})
+	d.Add(Route{
+		Method: http.MethodDelete, Path: "/sessions/:id", OperationID: "deleteSession", Public: true,
+		Params: []Parameter{{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "string"}}, {Name: "all", In: "query", Schema: &Schema{Type: "boolean"}}},
+		Status: http.StatusNoContent,
+	})
+
+	op := d.Paths["/sessions/{id}"]["delete"]
+	if len(op.Parameters) != 2 || op.Parameters[0].Schema.Type != "string" || op.Parameters[1].Name != "all" {
+		t.Errorf("parameters = %+v", op.Parameters)
+	}
+	if len(op.Security) != 0 {
+		t.Errorf("security = %v, want none for a public operation", op.Security)
+	}
+	if resp := op.Responses["204"]; resp == nil || resp.Content != nil {
+		t.Errorf("responses = %+v", op.Responses)
+	}
+}
@@ -0,0 +1,382 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+
+	"gorm.io/gorm"
+)
+
+// accountPurgeInterval is how often accounts past their deletion date are purged
+const accountPurgeInterval = time.Hour
+
+// ConversationExport is a conversation with its stored messages
+type ConversationExport struct {
+	ID        string            `json:"id"`
+	Scope     string            `json:"scope"`
+	Title     string            `json:"title"`
+	Model     string            `json:"model"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	Messages  []json.RawMessage `json:"messages"`
+}
+
+// AccountExport contains all data stored about a user. Secrets (password hash,
+// provider keys, API key hashes) are never included.
+type AccountExport struct {
+	ExportedAt              time.Time                         `json:"exported_at"`
+	User                    database.User                     `json:"user"`
+	NotificationPreference  *database.NotificationPreference  `json:"notification_preference,omitempty"`
+	ProviderConfigs         []database.ProviderConfig         `json:"provider_configs"`
+	ProviderConfigRevisions []database.ProviderConfigRevision `json:"provider_config_revisions"`
+	ProviderSLOs            []database.ProviderSLO            `json:"provider_slos"`
+	ProviderUsageSyncs      []database.ProviderUsageSync      `json:"provider_usage_syncs"`
+	ProviderUsageDays       []database.ProviderUsageDay       `json:"provider_usage_days"`
+	APIKeys                 []database.APIKey                 `json:"api_keys"`
+	UsageRecords            []database.UsageRecord            `json:"usage_records"`
+	Conversations           []ConversationExport              `json:"conversations"`
+	AssistantObjects        []database.AssistantObject        `json:"assistant_objects"`
+	StoredResponses         []database.StoredResponse         `json:"stored_responses"`
+	ArchiveObjects          []database.ArchiveObject          `json:"archive_objects"`
+	RequestLogs             []database.RequestLog             `json:"request_logs"`
+	HedgePolicies           []database.HedgePolicy            `json:"hedge_policies"`
+	CascadePolicies         []database.CascadePolicy          `json:"cascade_policies"`
+	RoutingPolicies         []database.RoutingPolicy          `json:"routing_policies"`
+	ContextUpgradePolicies  []database.ContextUpgradePolicy   `json:"context_upgrade_policies"`
+	MCPServers              []database.MCPServer              `json:"mcp_servers"`
+	ContentFilterRules      []database.ContentFilterRule      `json:"content_filter_rules"`
+	EndUsers                []database.EndUser                `json:"end_users"`
+	CanaryHits              []database.CanaryHit              `json:"canary_hits"` // uses of the user's canary keys
+	StripeBillings          []database.StripeBilling          `json:"stripe_billings"`
+	StripeUsageExports      []database.StripeUsageExport      `json:"stripe_usage_exports"`
+	CreditAccount           *database.CreditAccount           `json:"credit_account,omitempty"`
+	CreditLedger            []database.CreditLedgerEntry      `json:"credit_ledger"`
+	AuditLog                []database.AuditLogEntry          `json:"audit_log"` // actions taken by the user
+	Sessions                []database.Session                `json:"sessions"`
+}
+
+// AccountService handles privacy requests: data export and account deletion
+type AccountService struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	events  *events.Bus
+	archive *ArchiveService
+}
+
+// NewAccountService creates a new AccountService publishing the deletion of
+// purged keys and provider configs on bus
+func NewAccountService(db *gorm.DB, cfg *config.Config, bus *events.Bus, archive *ArchiveService) *AccountService {
+	return &AccountService{db: db, cfg: cfg, events: bus, archive: archive}
+}
+
+// accountScopes returns the user scope and the scopes of all the user's API keys
+func accountScopes(userID uint, keyIDs []uint) []string {
+	scopes := []string{fmt.Sprintf("user:%d", userID)}
+	for _, id := range keyIDs {
+		scopes = append(scopes, fmt.Sprintf("key:%d", id))
+	}
+	return scopes
+}
+
+// Export collects all data stored about a user
+func (s *AccountService) Export(userID uint) (*AccountExport, error) {
+	export := &AccountExport{ExportedAt: time.Now()}
+	if err := s.db.First(&export.User, userID).Error; err != nil {
+		return nil, err
+	}
+
+	var pref database.NotificationPreference
+	if err := s.db.Where("user_id = ?", userID).First(&pref).Error; err == nil {
+		export.NotificationPreference = &pref
+	}
+
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.ProviderConfigs).Error; err != nil {
+		return nil, err
+	}
+	configIDs := make([]uint, len(export.ProviderConfigs))
+	for i, cfg := range export.ProviderConfigs {
+		configIDs[i] = cfg.ID
+	}
+	if err := s.db.Where("provider_config_id IN ?", configIDs).Order("id").Find(&export.ProviderConfigRevisions).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("provider_config_id IN ?", configIDs).Order("id").Find(&export.ProviderSLOs).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.ProviderUsageSyncs).Error; err != nil {
+		return nil, err
+	}
+	syncIDs := make([]uint, len(export.ProviderUsageSyncs))
+	for i, sync := range export.ProviderUsageSyncs {
+		syncIDs[i] = sync.ID
+	}
+	if err := s.db.Where("sync_id IN ?", syncIDs).Order("id").Find(&export.ProviderUsageDays).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.APIKeys).Error; err != nil {
+		return nil, err
+	}
+	keyIDs := make([]uint, len(export.APIKeys))
+	for i, key := range export.APIKeys {
+		keyIDs[i] = key.ID
+	}
+	if err := s.db.Where("api_key_id IN ?", keyIDs).Order("id").Find(&export.UsageRecords).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("api_key_id IN ?", keyIDs).Order("id").Find(&export.EndUsers).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.CanaryHits).Error; err != nil {
+		return nil, err
+	}
+
+	scopes := accountScopes(userID, keyIDs)
+	var convs []database.Conversation
+	err := s.db.Preload("Messages", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("scope IN ?", scopes).Order("id").Find(&convs).Error
+	if err != nil {
+		return nil, err
+	}
+	export.Conversations = make([]ConversationExport, len(convs))
+	for i, conv := range convs {
+		messages := make([]json.RawMessage, len(conv.Messages))
+		for j, msg := range conv.Messages {
+			messages[j] = json.RawMessage(msg.Message)
+		}
+		export.Conversations[i] = ConversationExport{
+			ID:        conv.PublicID,
+			Scope:     conv.Scope,
+			Title:     conv.Title,
+			Model:     conv.Model,
+			CreatedAt: conv.CreatedAt,
+			UpdatedAt: conv.UpdatedAt,
+			Messages:  messages,
+		}
+	}
+
+	if err := s.db.Where("scope IN ?", scopes).Order("id").Find(&export.AssistantObjects).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("scope IN ?", scopes).Order("id").Find(&export.StoredResponses).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.ArchiveObjects).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.RequestLogs).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.HedgePolicies).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.CascadePolicies).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.RoutingPolicies).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.ContextUpgradePolicies).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.MCPServers).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.ContentFilterRules).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.StripeBillings).Error; err != nil {
+		return nil, err
+	}
+	billingIDs := make([]uint, len(export.StripeBillings))
+	for i, billing := range export.StripeBillings {
+		billingIDs[i] = billing.ID
+	}
+	if err := s.db.Where("billing_id IN ?", billingIDs).Order("id").Find(&export.StripeUsageExports).Error; err != nil {
+		return nil, err
+	}
+	var account database.CreditAccount
+	if err := s.db.Where("user_id = ?", userID).First(&account).Error; err == nil {
+		export.CreditAccount = &account
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.CreditLedger).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("actor_id = ?", userID).Order("id").Find(&export.AuditLog).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.Sessions).Error; err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// ScheduleDeletion marks a user's account for permanent deletion after the grace period
+func (s *AccountService) ScheduleDeletion(userID uint) (time.Time, error) {
+	due := time.Now().AddDate(0, 0, s.cfg.AccountDeletionGraceDays)
+	err := s.db.Model(&database.User{}).Where("id = ?", userID).Update("deletion_due_at", due).Error
+	return due, err
+}
+
+// CancelDeletion withdraws a pending account deletion
+func (s *AccountService) CancelDeletion(userID uint) error {
+	result := s.db.Model(&database.User{}).
+		Where("id = ? AND deletion_due_at IS NOT NULL", userID).
+		Update("deletion_due_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("no deletion is scheduled")
+	}
+	return nil
+}
+
+// PurgeUser permanently deletes a user and all data linked to the account,
+// including archived request/response bodies in object storage. The audit log
+// is append-only and keeps the entries of the user's actions. The deletion of
+// every key and provider config is published so that caches drop them.
+func (s *AccountService) PurgeUser(ctx context.Context, userID uint) error {
+	// Scrub object storage first so a failure leaves the account purgeable later
+	if err := s.archive.DeleteForUser(ctx, userID); err != nil {
+		return err
+	}
+
+	var keyIDs, configIDs []uint
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&database.APIKey{}).Where("user_id = ?", userID).Pluck("id", &keyIDs).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&database.ProviderConfig{}).Where("user_id = ?", userID).Pluck("id", &configIDs).Error; err != nil {
+			return err
+		}
+		scopes := accountScopes(userID, keyIDs)
+
+		var convIDs []uint
+		if err := tx.Model(&database.Conversation{}).Where("scope IN ?", scopes).Pluck("id", &convIDs).Error; err != nil {
+			return err
+		}
+		var billingIDs []uint
+		if err := tx.Model(&database.StripeBilling{}).Where("user_id = ?", userID).Pluck("id", &billingIDs).Error; err != nil {
+			return err
+		}
+
+		var usageSyncIDs []uint
+		if err := tx.Model(&database.ProviderUsageSync{}).Where("user_id = ?", userID).Pluck("id", &usageSyncIDs).Error; err != nil {
+			return err
+		}
+
+		steps := []struct {
+			model interface{}
+			query string
+			arg   interface{}
+		}{
+			{&database.ConversationMessage{}, "conversation_id IN ?", convIDs},
+			{&database.Conversation{}, "scope IN ?", scopes},
+			{&database.AssistantObject{}, "scope IN ?", scopes},
+			{&database.StoredResponse{}, "scope IN ?", scopes},
+			{&database.IdempotencyRecord{}, "scope IN ?", scopes},
+			{&database.UsageRecord{}, "api_key_id IN ?", keyIDs},
+			{&database.EndUser{}, "api_key_id IN ?", keyIDs},
+			{&database.RequestSignature{}, "api_key_id IN ?", keyIDs},
+			{&database.CanaryHit{}, "user_id = ?", userID},
+			{&database.ArchiveObject{}, "user_id = ?", userID},
+			{&database.RequestLog{}, "user_id = ?", userID},
+			{&database.ProviderSLO{}, "provider_config_id IN ?", configIDs},
+			{&database.ProviderConfigRevision{}, "provider_config_id IN ?", configIDs},
+			{&database.Maintenance{}, "provider_config_id IN ?", configIDs},
+			{&database.NotificationPreference{}, "user_id = ?", userID},
+			{&database.HedgePolicy{}, "user_id = ?", userID},
+			{&database.CascadePolicy{}, "user_id = ?", userID},
+			{&database.RoutingPolicy{}, "user_id = ?", userID},
+			{&database.ContextUpgradePolicy{}, "user_id = ?", userID},
+			{&database.MCPServer{}, "user_id = ?", userID},
+			{&database.ContentFilterRule{}, "user_id = ?", userID},
+			{&database.StripeUsageExport{}, "billing_id IN ?", billingIDs},
+			{&database.StripeBilling{}, "user_id = ?", userID},
+			{&database.ProviderUsageDay{}, "sync_id IN ?", usageSyncIDs},
+			{&database.ProviderUsageSync{}, "user_id = ?", userID},
+			{&database.CreditLedgerEntry{}, "user_id = ?", userID},
+			{&database.CreditAccount{}, "user_id = ?", userID},
+			{&database.Session{}, "user_id = ?", userID},
+		}
+		for _, step := range steps {
+			if err := tx.Where(step.query, step.arg).Delete(step.model).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(keyIDs) > 0 {
+			if err := tx.Exec("DELETE FROM api_key_providers WHERE api_key_id IN ?", keyIDs).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&database.APIKey{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&database.ProviderConfig{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&database.User{}, userID).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range keyIDs {
+		s.events.Publish(events.Event{Type: events.KeyChanged, UserID: userID, Data: events.KeyChange{APIKeyID: id, Action: "delete"}})
+	}
+	for _, id := range configIDs {
+		s.events.Publish(events.Event{Type: events.ConfigChanged, UserID: userID, Data: events.ConfigChange{ProviderConfigID: id, Action: "delete"}})
+	}
+	return nil
+}
+
+// PurgeDueAccounts deletes every account whose deletion grace period ended before now
+func (s *AccountService) PurgeDueAccounts(ctx context.Context, now time.Time) (int, error) {
+	var userIDs []uint
+	err := s.db.Model(&database.User{}).
+		Where("deletion_due_at IS NOT NULL AND deletion_due_at <= ?", now).
+		Pluck("id", &userIDs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, id := range userIDs {
+		if err := s.PurgeUser(ctx, id); err != nil {
+			log.Printf("[Account] Failed to purge user=%d: %v", id, err)
+			continue
+		}
+		log.Printf("[Account] Purged user=%d", id)
+		purged++
+	}
+	return purged, nil
+}
+
+// Run purges accounts due for deletion periodically until ctx is cancelled
+func (s *AccountService) Run(ctx context.Context) {
+	ticker := time.NewTicker(accountPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.PurgeDueAccounts(ctx, time.Now()); err != nil {
+			log.Printf("[Account] Failed to purge accounts: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+)
+
+func TestAccount_PurgeUserDropsCredentials(t *testing.T) {
+	db := testDB(t)
+	cfg := testConfig()
+	bus := events.NewBus()
+	changes, unsubscribe := bus.Subscribe(events.KeyChanged, events.ConfigChanged)
+	defer unsubscribe()
+	accounts := NewAccountService(db, cfg, bus, NewArchiveService(db, cfg))
+
+	user := &database.User{Username: "u", Email: "u@example.com", HashedPassword: "x", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	providerConfig := &database.ProviderConfig{UserID: user.ID, Provider: "openai", Name: "main", EncryptedKey: "x", IsActive: true}
+	if err := db.Create(providerConfig).Error; err != nil {
+		t.Fatal(err)
+	}
+	key := &database.APIKey{UserID: user.ID, Name: "k", KeyHash: "hash", IsActive: true, ProviderConfigs: []database.ProviderConfig{*providerConfig}}
+	if err := db.Create(key).Error; err != nil {
+		t.Fatal(err)
+	}
+	configID := providerConfig.ID
+	rows := []interface{}{
+		&database.RequestSignature{APIKeyID: key.ID, Signature: "sig", ExpiresAt: time.Now().Add(time.Minute)},
+		&database.Maintenance{ProviderConfigID: &configID, Message: "rotating keys"},
+		&database.Maintenance{Message: "gateway upgrade"},
+	}
+	for _, row := range rows {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := accounts.PurgeUser(context.Background(), user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{
+		"key.changed delete":    true,
+		"config.changed delete": true,
+	}
+	for len(want) > 0 {
+		select {
+		case event := <-changes:
+			switch change := event.Data.(type) {
+			case events.KeyChange:
+				if change.APIKeyID == key.ID {
+					delete(want, event.Type+" "+change.Action)
+				}
+			case events.ConfigChange:
+				if change.ProviderConfigID == providerConfig.ID {
+					delete(want, event.Type+" "+change.Action)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("events not published: %v", want)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		model interface{}
+		want  int64
+	}{
+		{"request signatures", &database.RequestSignature{}, 0},
+		{"maintenance windows", &database.Maintenance{}, 1}, // the gateway's own window is kept
+		{"api keys", &database.APIKey{}, 0},
+		{"provider configs", &database.ProviderConfig{}, 0},
+	}
+	for _, tt := range tests {
+		var n int64
+		if err := db.Model(tt.model).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		if n != tt.want {
+			t.Errorf("%s left after purge: %d, want %d", tt.name, n, tt.want)
+		}
+	}
+}
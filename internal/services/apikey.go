@@ -4,22 +4,49 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"ai_gateway/internal/config"
 	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
 	"ai_gateway/internal/utils"
 
 	"gorm.io/gorm"
 )
 
+// ErrAPIKeyNotFound is returned when a user has no such API key
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// Bounds of the per-key guardrail settings
+const (
+	MaxSchemaRepairRetries = 5
+	MaxLatencyBudgetMs     = 600000 // 10 minutes
+	MinCompressionTokens   = 1000
+	MaxAutoContinueTokens  = 200000
+)
+
 // APIKeyService handles API key operations
 type APIKeyService struct {
-	db *gorm.DB
+	db     *gorm.DB
+	loc    *time.Location   // timezone of usage resets
+	events *events.Bus      // receives usage, key limit and key change events
+	cache  *CredentialCache // kept in step with recorded usage
 }
 
-// NewAPIKeyService creates a new APIKeyService
-func NewAPIKeyService(db *gorm.DB) *APIKeyService {
-	return &APIKeyService{db: db}
+// NewAPIKeyService creates a new APIKeyService publishing usage and key changes
+// on bus
+func NewAPIKeyService(db *gorm.DB, cfg *config.Config, bus *events.Bus, cache *CredentialCache) *APIKeyService {
+	return &APIKeyService{db: db, loc: cfg.UsageResetLocation(), events: bus, cache: cache}
+}
+
+// publishChange announces a change to an API key on the event bus
+func (s *APIKeyService) publishChange(userID, keyID uint, action string) {
+	s.events.Publish(events.Event{
+		Type:   events.KeyChanged,
+		UserID: userID,
+		Data:   events.KeyChange{APIKeyID: keyID, Action: action},
+	})
 }
 
 // APIKeyCreate represents a request to create an API key
@@ -31,6 +58,24 @@ type APIKeyCreate struct {
 	MonthlyRequestLimit *int       `json:"monthly_request_limit"`
 	DailyTokenLimit     *int       `json:"daily_token_limit"`
 	MonthlyTokenLimit   *int       `json:"monthly_token_limit"`
+	ArchiveEnabled      bool       `json:"archive_enabled"`
+	BodyLogDisabled     bool       `json:"body_log_disabled"`
+	RoutingStrategy     string     `json:"routing_strategy"`
+	SchemaRepairRetries int        `json:"schema_repair_retries"`
+	LatencyBudgetMs     int        `json:"latency_budget_ms"`
+	AllowConfigOverride bool       `json:"allow_config_override"`
+	CompressionTokens   int        `json:"compression_tokens"`
+	CompressionModel    string     `json:"compression_model"`
+	KeepRequestedModel  bool       `json:"keep_requested_model"`
+	StrictConversion    bool       `json:"strict_conversion"`
+	ValidateToolArgs    bool       `json:"validate_tool_args"`
+	MaxResponseTokens   int        `json:"max_response_tokens"`
+	ForbiddenLanguages  []string   `json:"forbidden_languages"`
+	ResponseSuffix      string     `json:"response_suffix"`
+	InjectionDetection  bool       `json:"injection_detection"`
+	InjectionThreshold  float64    `json:"injection_threshold"`
+	SalvageStreams      bool       `json:"salvage_streams"`
+	AutoContinueTokens  int        `json:"auto_continue_tokens"`
 }
 
 // APIKeyUpdate represents a request to update an API key
@@ -43,6 +88,26 @@ type APIKeyUpdate struct {
 	MonthlyRequestLimit *int       `json:"monthly_request_limit"`
 	DailyTokenLimit     *int       `json:"daily_token_limit"`
 	MonthlyTokenLimit   *int       `json:"monthly_token_limit"`
+	ArchiveEnabled      *bool      `json:"archive_enabled"`
+	BodyLogDisabled     *bool      `json:"body_log_disabled"`
+	RoutingStrategy     *string    `json:"routing_strategy"`
+	SchemaRepairRetries *int       `json:"schema_repair_retries"`
+	LatencyBudgetMs     *int       `json:"latency_budget_ms"`
+	AllowConfigOverride *bool      `json:"allow_config_override"`
+	CompressionTokens   *int       `json:"compression_tokens"`
+	CompressionModel    *string    `json:"compression_model"`
+	KeepRequestedModel  *bool      `json:"keep_requested_model"`
+	StrictConversion    *bool      `json:"strict_conversion"`
+	ValidateToolArgs    *bool      `json:"validate_tool_args"`
+	MaxResponseTokens   *int       `json:"max_response_tokens"`
+	ForbiddenLanguages  []string   `json:"forbidden_languages"` // nil leaves them, empty clears them
+	ResponseSuffix      *string    `json:"response_suffix"`
+	InjectionDetection  *bool      `json:"injection_detection"`
+	InjectionThreshold  *float64   `json:"injection_threshold"`
+	SalvageStreams      *bool      `json:"salvage_streams"`
+	AutoContinueTokens  *int       `json:"auto_continue_tokens"`
+	CanaryWebhookURL    *string    `json:"canary_webhook_url"`
+	CanaryEmail         *bool      `json:"canary_email"`
 }
 
 // APIKeyRotate represents a request to rotate an API key
@@ -63,6 +128,9 @@ type APIKeyUsageStats struct {
 	DailyResetAt        time.Time              `json:"daily_reset_at"`
 	MonthlyResetAt      time.Time              `json:"monthly_reset_at"`
 	RecentRecords       []database.UsageRecord `json:"recent_records"`
+	Tag                 *TagUsage              `json:"tag,omitempty"`
+
+	TotalRecords int64 `json:"total_records"` // usage records matching the query, of which RecentRecords is a page
 }
 
 // GenerateAPIKey generates a new API key
@@ -97,6 +165,34 @@ func (s *APIKeyService) CreateAPIKey(userID uint, req *APIKeyCreate) (*database.
 		return nil, "", errors.New("one or more provider configs not found")
 	}
 
+	routingStrategy, err := normalizeRoutingStrategy(req.RoutingStrategy)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := validateSchemaRepairRetries(req.SchemaRepairRetries); err != nil {
+		return nil, "", err
+	}
+	if err := validateLatencyBudget(req.LatencyBudgetMs); err != nil {
+		return nil, "", err
+	}
+	compressionModel := strings.TrimSpace(req.CompressionModel)
+	if err := validateCompression(req.CompressionTokens, compressionModel); err != nil {
+		return nil, "", err
+	}
+	if err := validateResponsePolicy(req.MaxResponseTokens, req.ResponseSuffix); err != nil {
+		return nil, "", err
+	}
+	forbiddenLanguages, err := NormalizeLanguages(req.ForbiddenLanguages)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := validateInjectionThreshold(req.InjectionThreshold); err != nil {
+		return nil, "", err
+	}
+	if err := validateAutoContinueTokens(req.AutoContinueTokens); err != nil {
+		return nil, "", err
+	}
+
 	// Generate API key
 	fullKey, keyHash, keyPrefix, err := s.GenerateAPIKey()
 	if err != nil {
@@ -116,8 +212,26 @@ func (s *APIKeyService) CreateAPIKey(userID uint, req *APIKeyCreate) (*database.
 		MonthlyRequestLimit: req.MonthlyRequestLimit,
 		DailyTokenLimit:     req.DailyTokenLimit,
 		MonthlyTokenLimit:   req.MonthlyTokenLimit,
-		DailyResetAt:        now.Add(24 * time.Hour),
-		MonthlyResetAt:      now.AddDate(0, 1, 0),
+		ArchiveEnabled:      req.ArchiveEnabled,
+		BodyLogDisabled:     req.BodyLogDisabled,
+		RoutingStrategy:     routingStrategy,
+		SchemaRepairRetries: req.SchemaRepairRetries,
+		LatencyBudgetMs:     req.LatencyBudgetMs,
+		AllowConfigOverride: req.AllowConfigOverride,
+		CompressionTokens:   req.CompressionTokens,
+		CompressionModel:    compressionModel,
+		KeepRequestedModel:  req.KeepRequestedModel,
+		StrictConversion:    req.StrictConversion,
+		ValidateToolArgs:    req.ValidateToolArgs,
+		MaxResponseTokens:   req.MaxResponseTokens,
+		ForbiddenLanguages:  forbiddenLanguages,
+		ResponseSuffix:      req.ResponseSuffix,
+		InjectionDetection:  req.InjectionDetection,
+		InjectionThreshold:  req.InjectionThreshold,
+		SalvageStreams:      req.SalvageStreams,
+		AutoContinueTokens:  req.AutoContinueTokens,
+		DailyResetAt:        nextDailyReset(now, s.loc),
+		MonthlyResetAt:      nextMonthlyReset(now, s.loc),
 		ProviderConfigs:     configs,
 	}
 
@@ -128,11 +242,26 @@ func (s *APIKeyService) CreateAPIKey(userID uint, req *APIKeyCreate) (*database.
 	return apiKey, fullKey, nil
 }
 
-// GetAPIKeys returns all API keys for a user
-func (s *APIKeyService) GetAPIKeys(userID uint) ([]database.APIKey, error) {
+// ListAPIKeys returns a page of a user's API keys, newest first, and how many
+// match. Search matches the name; Status is active, inactive or expired.
+func (s *APIKeyService) ListAPIKeys(userID uint, q *ListQuery) ([]database.APIKey, int64, error) {
+	query := q.filter(s.db.Model(&database.APIKey{}).Where("user_id = ?", userID), "name")
+	now := time.Now()
+	switch q.Status {
+	case "":
+	case "active":
+		query = query.Where("is_active = ? AND (expires_at IS NULL OR expires_at > ?)", true, now)
+	case "inactive":
+		query = query.Where("is_active = ?", false)
+	case "expired":
+		query = query.Where("expires_at <= ?", now)
+	default:
+		return nil, 0, ErrInvalidListStatus
+	}
+
 	var keys []database.APIKey
-	err := s.db.Where("user_id = ?", userID).Preload("ProviderConfigs").Order("created_at DESC").Find(&keys).Error
-	return keys, err
+	total, err := q.find(query, "created_at DESC", &keys, "ProviderConfigs")
+	return keys, total, err
 }
 
 // GetAPIKeyByID returns an API key by ID
@@ -175,6 +304,103 @@ func (s *APIKeyService) UpdateAPIKey(userID, keyID uint, req *APIKeyUpdate) (*da
 	if req.MonthlyTokenLimit != nil {
 		updates["monthly_token_limit"] = *req.MonthlyTokenLimit
 	}
+	if req.ArchiveEnabled != nil {
+		updates["archive_enabled"] = *req.ArchiveEnabled
+	}
+	if req.BodyLogDisabled != nil {
+		updates["body_log_disabled"] = *req.BodyLogDisabled
+	}
+	if req.RoutingStrategy != nil {
+		strategy, err := normalizeRoutingStrategy(*req.RoutingStrategy)
+		if err != nil {
+			return nil, err
+		}
+		updates["routing_strategy"] = strategy
+	}
+	if req.SchemaRepairRetries != nil {
+		if err := validateSchemaRepairRetries(*req.SchemaRepairRetries); err != nil {
+			return nil, err
+		}
+		updates["schema_repair_retries"] = *req.SchemaRepairRetries
+	}
+	if req.LatencyBudgetMs != nil {
+		if err := validateLatencyBudget(*req.LatencyBudgetMs); err != nil {
+			return nil, err
+		}
+		updates["latency_budget_ms"] = *req.LatencyBudgetMs
+	}
+	if req.AllowConfigOverride != nil {
+		updates["allow_config_override"] = *req.AllowConfigOverride
+	}
+	if req.KeepRequestedModel != nil {
+		updates["keep_requested_model"] = *req.KeepRequestedModel
+	}
+	if req.StrictConversion != nil {
+		updates["strict_conversion"] = *req.StrictConversion
+	}
+	if req.ValidateToolArgs != nil {
+		updates["validate_tool_args"] = *req.ValidateToolArgs
+	}
+	if req.MaxResponseTokens != nil {
+		if err := validateResponsePolicy(*req.MaxResponseTokens, ""); err != nil {
+			return nil, err
+		}
+		updates["max_response_tokens"] = *req.MaxResponseTokens
+	}
+	if req.ForbiddenLanguages != nil {
+		languages, err := NormalizeLanguages(req.ForbiddenLanguages)
+		if err != nil {
+			return nil, err
+		}
+		updates["forbidden_languages"] = languages
+	}
+	if req.ResponseSuffix != nil {
+		if err := validateResponsePolicy(0, *req.ResponseSuffix); err != nil {
+			return nil, err
+		}
+		updates["response_suffix"] = *req.ResponseSuffix
+	}
+	if req.InjectionDetection != nil {
+		updates["injection_detection"] = *req.InjectionDetection
+	}
+	if req.InjectionThreshold != nil {
+		if err := validateInjectionThreshold(*req.InjectionThreshold); err != nil {
+			return nil, err
+		}
+		updates["injection_threshold"] = *req.InjectionThreshold
+	}
+	if req.SalvageStreams != nil {
+		updates["salvage_streams"] = *req.SalvageStreams
+	}
+	if req.AutoContinueTokens != nil {
+		if err := validateAutoContinueTokens(*req.AutoContinueTokens); err != nil {
+			return nil, err
+		}
+		updates["auto_continue_tokens"] = *req.AutoContinueTokens
+	}
+	if req.CanaryWebhookURL != nil {
+		if err := validateWebhookURL(*req.CanaryWebhookURL); err != nil {
+			return nil, err
+		}
+		updates["canary_webhook_url"] = *req.CanaryWebhookURL
+	}
+	if req.CanaryEmail != nil {
+		updates["canary_email"] = *req.CanaryEmail
+	}
+	if req.CompressionTokens != nil || req.CompressionModel != nil {
+		tokens, model := key.CompressionTokens, key.CompressionModel
+		if req.CompressionTokens != nil {
+			tokens = *req.CompressionTokens
+		}
+		if req.CompressionModel != nil {
+			model = strings.TrimSpace(*req.CompressionModel)
+		}
+		if err := validateCompression(tokens, model); err != nil {
+			return nil, err
+		}
+		updates["compression_tokens"] = tokens
+		updates["compression_model"] = model
+	}
 
 	if len(updates) > 0 {
 		if err := s.db.Model(key).Updates(updates).Error; err != nil {
@@ -195,6 +421,7 @@ func (s *APIKeyService) UpdateAPIKey(userID, keyID uint, req *APIKeyUpdate) (*da
 			return nil, err
 		}
 	}
+	s.publishChange(userID, keyID, "update")
 
 	return s.GetAPIKeyByID(userID, keyID)
 }
@@ -226,9 +453,33 @@ func (s *APIKeyService) RotateAPIKey(userID, keyID uint, req *APIKeyRotate) (*da
 		MonthlyRequestLimit: oldKey.MonthlyRequestLimit,
 		DailyTokenLimit:     oldKey.DailyTokenLimit,
 		MonthlyTokenLimit:   oldKey.MonthlyTokenLimit,
-		DailyResetAt:        now.Add(24 * time.Hour),
-		MonthlyResetAt:      now.AddDate(0, 1, 0),
+		ArchiveEnabled:      oldKey.ArchiveEnabled,
+		BodyLogDisabled:     oldKey.BodyLogDisabled,
+		RoutingStrategy:     oldKey.RoutingStrategy,
+		SchemaRepairRetries: oldKey.SchemaRepairRetries,
+		LatencyBudgetMs:     oldKey.LatencyBudgetMs,
+		AllowConfigOverride: oldKey.AllowConfigOverride,
+		CompressionTokens:   oldKey.CompressionTokens,
+		CompressionModel:    oldKey.CompressionModel,
+		KeepRequestedModel:  oldKey.KeepRequestedModel,
+		StrictConversion:    oldKey.StrictConversion,
+		ValidateToolArgs:    oldKey.ValidateToolArgs,
+		MaxResponseTokens:   oldKey.MaxResponseTokens,
+		ForbiddenLanguages:  oldKey.ForbiddenLanguages,
+		ResponseSuffix:      oldKey.ResponseSuffix,
+		InjectionDetection:  oldKey.InjectionDetection,
+		InjectionThreshold:  oldKey.InjectionThreshold,
+		SalvageStreams:      oldKey.SalvageStreams,
+		AutoContinueTokens:  oldKey.AutoContinueTokens,
+		DailyResetAt:        nextDailyReset(now, s.loc),
+		MonthlyResetAt:      nextMonthlyReset(now, s.loc),
 		ProviderConfigs:     oldKey.ProviderConfigs,
+
+		EncryptedSigningSecret: oldKey.EncryptedSigningSecret,
+
+		Canary:           oldKey.Canary,
+		CanaryWebhookURL: oldKey.CanaryWebhookURL,
+		CanaryEmail:      oldKey.CanaryEmail,
 	}
 
 	// Create the new key
@@ -241,6 +492,11 @@ func (s *APIKeyService) RotateAPIKey(userID, keyID uint, req *APIKeyRotate) (*da
 		if err := s.db.Model(oldKey).Update("is_active", false).Error; err != nil {
 			return nil, "", err
 		}
+		s.publishChange(userID, oldKey.ID, "rotate")
+		// End users and their quotas move with the traffic to the new key
+		if err := s.db.Model(&database.EndUser{}).Where("api_key_id = ?", oldKey.ID).Update("api_key_id", newKey.ID).Error; err != nil {
+			return nil, "", err
+		}
 	}
 
 	// Reload provider configs for the new key
@@ -258,6 +514,7 @@ func (s *APIKeyService) DeleteAPIKey(userID, keyID uint) error {
 	if result.RowsAffected == 0 {
 		return errors.New("API key not found")
 	}
+	s.publishChange(userID, keyID, "delete")
 	return nil
 }
 
@@ -292,14 +549,36 @@ func (s *APIKeyService) GetProviderConfigForProvider(apiKey *database.APIKey, pr
 
 // CheckUsageLimits checks if an API key has exceeded its usage limits
 func (s *APIKeyService) CheckUsageLimits(key *database.APIKey) error {
-	now := time.Now()
+	s.resetExpiredUsage(key, time.Now())
+
+	// Check request limits
+	if key.DailyRequestLimit != nil && key.DailyRequestsUsed >= *key.DailyRequestLimit {
+		return errors.New("daily request limit exceeded")
+	}
+	if key.MonthlyRequestLimit != nil && key.MonthlyRequestsUsed >= *key.MonthlyRequestLimit {
+		return errors.New("monthly request limit exceeded")
+	}
+
+	// Check token limits
+	if key.DailyTokenLimit != nil && key.DailyTokensUsed >= *key.DailyTokenLimit {
+		return errors.New("daily token limit exceeded")
+	}
+	if key.MonthlyTokenLimit != nil && key.MonthlyTokensUsed >= *key.MonthlyTokenLimit {
+		return errors.New("monthly token limit exceeded")
+	}
 
+	return nil
+}
+
+// resetExpiredUsage resets the counters of a key whose period has ended, ahead
+// of the UsageResetService
+func (s *APIKeyService) resetExpiredUsage(key *database.APIKey, now time.Time) {
 	// Reset daily counters if needed
 	if key.DailyResetAt.Before(now) {
 		s.db.Model(key).Updates(map[string]interface{}{
 			"daily_requests_used": 0,
 			"daily_tokens_used":   0,
-			"daily_reset_at":      now.Add(24 * time.Hour),
+			"daily_reset_at":      nextDailyReset(now, s.loc),
 		})
 		key.DailyRequestsUsed = 0
 		key.DailyTokensUsed = 0
@@ -310,33 +589,81 @@ func (s *APIKeyService) CheckUsageLimits(key *database.APIKey) error {
 		s.db.Model(key).Updates(map[string]interface{}{
 			"monthly_requests_used": 0,
 			"monthly_tokens_used":   0,
-			"monthly_reset_at":      now.AddDate(0, 1, 0),
+			"monthly_reset_at":      nextMonthlyReset(now, s.loc),
 		})
 		key.MonthlyRequestsUsed = 0
 		key.MonthlyTokensUsed = 0
 	}
+}
 
-	// Check request limits
-	if key.DailyRequestLimit != nil && key.DailyRequestsUsed >= *key.DailyRequestLimit {
-		return errors.New("daily request limit exceeded")
+// LimitWindow is the state of one usage limit of an API key
+type LimitWindow struct {
+	Limit     int
+	Remaining int
+	ResetAt   time.Time
+}
+
+// TightestLimits returns the daily or monthly request and token limits of a key
+// with the fewest units remaining, or nil for a kind the key does not limit.
+// Counters whose period has ended count as reset, until the next boundary in loc.
+func TightestLimits(key *database.APIKey, now time.Time, loc *time.Location) (requests, tokens *LimitWindow) {
+	daily := func(limit *int, used int) *LimitWindow {
+		if key.DailyResetAt.Before(now) {
+			return limitWindow(limit, 0, nextDailyReset(now, loc))
+		}
+		return limitWindow(limit, used, key.DailyResetAt)
 	}
-	if key.MonthlyRequestLimit != nil && key.MonthlyRequestsUsed >= *key.MonthlyRequestLimit {
-		return errors.New("monthly request limit exceeded")
+	monthly := func(limit *int, used int) *LimitWindow {
+		if key.MonthlyResetAt.Before(now) {
+			return limitWindow(limit, 0, nextMonthlyReset(now, loc))
+		}
+		return limitWindow(limit, used, key.MonthlyResetAt)
 	}
+	requests = tighter(daily(key.DailyRequestLimit, key.DailyRequestsUsed), monthly(key.MonthlyRequestLimit, key.MonthlyRequestsUsed))
+	tokens = tighter(daily(key.DailyTokenLimit, key.DailyTokensUsed), monthly(key.MonthlyTokenLimit, key.MonthlyTokensUsed))
+	return requests, tokens
+}
 
-	// Check token limits
-	if key.DailyTokenLimit != nil && key.DailyTokensUsed >= *key.DailyTokenLimit {
-		return errors.New("daily token limit exceeded")
+func limitWindow(limit *int, used int, resetAt time.Time) *LimitWindow {
+	if limit == nil {
+		return nil
 	}
-	if key.MonthlyTokenLimit != nil && key.MonthlyTokensUsed >= *key.MonthlyTokenLimit {
-		return errors.New("monthly token limit exceeded")
+	remaining := *limit - used
+	if remaining < 0 {
+		remaining = 0
 	}
+	return &LimitWindow{Limit: *limit, Remaining: remaining, ResetAt: resetAt}
+}
 
-	return nil
+func tighter(a, b *LimitWindow) *LimitWindow {
+	if a == nil || (b != nil && b.Remaining < a.Remaining) {
+		return b
+	}
+	return a
+}
+
+// UsageAttribution identifies what a request's usage is spent on within an API key
+type UsageAttribution struct {
+	Tags             []string
+	EndUserID        *uint
+	ProviderConfigID *uint     // provider config that served the request
+	RequestID        string    // gateway trace ID of the request
+	Started          time.Time // arrival of the request, for its latency
 }
 
-// RecordUsage records API usage for an API key
-func (s *APIKeyService) RecordUsage(keyID uint, endpoint, model string, promptTokens, completionTokens, statusCode int) error {
+// RecordUsage records API usage for an API key and the end user it was made for,
+// and charges its cost to the key owner's credits
+func (s *APIKeyService) RecordUsage(keyID uint, endpoint, model string, promptTokens, completionTokens, statusCode int, attr UsageAttribution) error {
+	return s.recordUsage(keyID, endpoint, model, promptTokens, completionTokens, statusCode, attr, false)
+}
+
+// RecordEstimatedUsage records API usage like RecordUsage, for token counts the
+// gateway estimated because the upstream reported none
+func (s *APIKeyService) RecordEstimatedUsage(keyID uint, endpoint, model string, promptTokens, completionTokens, statusCode int, attr UsageAttribution) error {
+	return s.recordUsage(keyID, endpoint, model, promptTokens, completionTokens, statusCode, attr, true)
+}
+
+func (s *APIKeyService) recordUsage(keyID uint, endpoint, model string, promptTokens, completionTokens, statusCode int, attr UsageAttribution, estimated bool) error {
 	totalTokens := promptTokens + completionTokens
 
 	// Create usage record
@@ -348,31 +675,152 @@ func (s *APIKeyService) RecordUsage(keyID uint, endpoint, model string, promptTo
 		CompletionTokens: completionTokens,
 		TotalTokens:      totalTokens,
 		StatusCode:       statusCode,
+		Tags:             strings.Join(attr.Tags, ","),
+		EndUserID:        attr.EndUserID,
+		ProviderConfigID: attr.ProviderConfigID,
+		RequestID:        attr.RequestID,
+		Estimated:        estimated,
 	}
 
 	if err := s.db.Create(record).Error; err != nil {
 		return err
 	}
 
+	if attr.EndUserID != nil {
+		err := s.db.Model(&database.EndUser{}).Where("id = ?", *attr.EndUserID).Updates(map[string]interface{}{
+			"daily_requests_used":   gorm.Expr("daily_requests_used + 1"),
+			"monthly_requests_used": gorm.Expr("monthly_requests_used + 1"),
+			"daily_tokens_used":     gorm.Expr("daily_tokens_used + ?", totalTokens),
+			"monthly_tokens_used":   gorm.Expr("monthly_tokens_used + ?", totalTokens),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
 	// Update counters
-	return s.db.Model(&database.APIKey{}).Where("id = ?", keyID).Updates(map[string]interface{}{
+	err := s.db.Model(&database.APIKey{}).Where("id = ?", keyID).Updates(map[string]interface{}{
 		"daily_requests_used":   gorm.Expr("daily_requests_used + 1"),
 		"monthly_requests_used": gorm.Expr("monthly_requests_used + 1"),
 		"daily_tokens_used":     gorm.Expr("daily_tokens_used + ?", totalTokens),
 		"monthly_tokens_used":   gorm.Expr("monthly_tokens_used + ?", totalTokens),
 	}).Error
+	if err != nil {
+		return err
+	}
+	cost, err := chargeProviderConfig(s.db, record)
+	if err != nil {
+		return err
+	}
+	s.cache.AddUsage(keyID, record.ProviderConfigID, totalTokens, cost)
+	if err := chargeCredits(s.db, record); err != nil {
+		return err
+	}
+
+	s.publishUsage(record, attr)
+	return nil
+}
+
+// publishUsage announces a recorded usage record on the event bus, along with
+// the limits of its key that the usage used up
+func (s *APIKeyService) publishUsage(record *database.UsageRecord, attr UsageAttribution) {
+	publishUsage := s.events.HasSubscribers(events.RequestCompleted)
+	publishLimits := s.events.HasSubscribers(events.KeyLimitReached)
+	if !publishUsage && !publishLimits {
+		return
+	}
+	var key database.APIKey
+	if err := s.db.First(&key, record.APIKeyID).Error; err != nil {
+		return
+	}
+	if publishUsage {
+		usage := events.Usage{
+			RequestID:        record.RequestID,
+			APIKeyID:         key.ID,
+			APIKeyName:       key.Name,
+			Endpoint:         record.Endpoint,
+			Model:            record.Model,
+			PromptTokens:     record.PromptTokens,
+			CompletionTokens: record.CompletionTokens,
+			TotalTokens:      record.TotalTokens,
+			StatusCode:       record.StatusCode,
+			Estimated:        record.Estimated,
+			ProviderConfigID: record.ProviderConfigID,
+		}
+		if !attr.Started.IsZero() {
+			usage.LatencyMs = time.Since(attr.Started).Milliseconds()
+		}
+		s.events.Publish(events.Event{Type: events.RequestCompleted, UserID: key.UserID, Time: record.CreatedAt, Data: usage})
+	}
+	if publishLimits {
+		for _, limit := range reachedLimits(&key, record.TotalTokens) {
+			s.events.Publish(events.Event{Type: events.KeyLimitReached, UserID: key.UserID, Data: limit})
+		}
+	}
 }
 
-// GetUsageStats returns usage statistics for an API key
-func (s *APIKeyService) GetUsageStats(userID, keyID uint) (*APIKeyUsageStats, error) {
+// reachedLimits returns the limits of a key that its latest request, of the
+// given tokens, used up
+func reachedLimits(key *database.APIKey, tokens int) []events.KeyLimit {
+	var reached []events.KeyLimit
+	check := func(name string, limit *int, used, added int) {
+		if limit != nil && used >= *limit && used-added < *limit {
+			reached = append(reached, events.KeyLimit{
+				APIKeyID:   key.ID,
+				APIKeyName: key.Name,
+				Limit:      name,
+				Value:      *limit,
+				Used:       used,
+			})
+		}
+	}
+	check("daily_requests", key.DailyRequestLimit, key.DailyRequestsUsed, 1)
+	check("monthly_requests", key.MonthlyRequestLimit, key.MonthlyRequestsUsed, 1)
+	check("daily_tokens", key.DailyTokenLimit, key.DailyTokensUsed, tokens)
+	check("monthly_tokens", key.MonthlyTokenLimit, key.MonthlyTokensUsed, tokens)
+	return reached
+}
+
+// GetUsageStats returns usage statistics for an API key with a page of its usage
+// records, newest first. A non-empty tag limits the records to those attributed
+// to it and adds the tag's totals. Search matches the model; Status is success
+// or error.
+func (s *APIKeyService) GetUsageStats(userID, keyID uint, tag string, q *ListQuery) (*APIKeyUsageStats, error) {
 	key, err := s.GetAPIKeyByID(userID, keyID)
 	if err != nil {
 		return nil, err
 	}
+	s.resetExpiredUsage(key, time.Now())
 
 	// Get recent usage records
+	query := q.filter(s.db.Model(&database.UsageRecord{}).Where("api_key_id = ?", keyID), "model")
+	switch q.Status {
+	case "":
+	case "success":
+		query = query.Where("status_code < ?", 400)
+	case "error":
+		query = query.Where("status_code >= ?", 400)
+	default:
+		return nil, ErrInvalidListStatus
+	}
+	var tagUsage *TagUsage
+	if tag != "" {
+		query = whereTagged(query, tag)
+		tagUsage = &TagUsage{Tag: tag}
+		err := whereTagged(s.db.Model(&database.UsageRecord{}), tag).
+			Where("api_key_id = ?", keyID).
+			Select("COUNT(*) AS requests, COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens, " +
+				"COALESCE(SUM(completion_tokens), 0) AS completion_tokens, COALESCE(SUM(total_tokens), 0) AS total_tokens").
+			Scan(tagUsage).Error
+		if err != nil {
+			return nil, err
+		}
+	}
 	var records []database.UsageRecord
-	s.db.Where("api_key_id = ?", keyID).Order("created_at DESC").Limit(100).Find(&records)
+	total, err := q.find(query, "created_at DESC", &records)
+	if err != nil {
+		return nil, err
+	}
 
 	return &APIKeyUsageStats{
 		DailyRequestsUsed:   key.DailyRequestsUsed,
@@ -386,5 +834,58 @@ func (s *APIKeyService) GetUsageStats(userID, keyID uint) (*APIKeyUsageStats, er
 		DailyResetAt:        key.DailyResetAt,
 		MonthlyResetAt:      key.MonthlyResetAt,
 		RecentRecords:       records,
+		Tag:                 tagUsage,
+
+		TotalRecords: total,
 	}, nil
 }
+
+// normalizeRoutingStrategy validates a routing strategy, defaulting to ordered
+func normalizeRoutingStrategy(strategy string) (string, error) {
+	switch strategy {
+	case "", RoutingOrdered:
+		return RoutingOrdered, nil
+	case RoutingLatency:
+		return RoutingLatency, nil
+	default:
+		return "", errors.New("routing_strategy must be ordered or latency")
+	}
+}
+
+// validateSchemaRepairRetries bounds the schema repair attempts of a key
+func validateSchemaRepairRetries(retries int) error {
+	if retries < 0 || retries > MaxSchemaRepairRetries {
+		return fmt.Errorf("schema_repair_retries must be between 0 and %d", MaxSchemaRepairRetries)
+	}
+	return nil
+}
+
+// validateLatencyBudget bounds the latency budget of a key
+func validateLatencyBudget(ms int) error {
+	if ms < 0 || ms > MaxLatencyBudgetMs {
+		return fmt.Errorf("latency_budget_ms must be between 0 and %d", MaxLatencyBudgetMs)
+	}
+	return nil
+}
+
+// validateAutoContinueTokens bounds the auto-continue budget of a key
+func validateAutoContinueTokens(tokens int) error {
+	if tokens < 0 || tokens > MaxAutoContinueTokens {
+		return fmt.Errorf("auto_continue_tokens must be between 0 and %d", MaxAutoContinueTokens)
+	}
+	return nil
+}
+
+// validateCompression checks the context compression settings of a key
+func validateCompression(tokens int, model string) error {
+	if tokens == 0 {
+		return nil
+	}
+	if tokens < MinCompressionTokens {
+		return fmt.Errorf("compression_tokens must be 0 or at least %d", MinCompressionTokens)
+	}
+	if model == "" || len(model) > 100 {
+		return errors.New("compression_model is required when compression_tokens is set")
+	}
+	return nil
+}
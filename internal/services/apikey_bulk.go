@@ -0,0 +1,117 @@
+package services
+
+import (
+	"errors"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// Errors of bulk API key operations
+var (
+	ErrEmptyKeySelection = errors.New("select keys by ids or provider_config_id")
+	ErrNoLimitsToUpdate  = errors.New("no limits to update")
+)
+
+// APIKeySelection selects a user's API keys for a bulk operation: by ID, by a
+// provider config they are bound to (e.g. one whose upstream key leaked), or by
+// both, in which case keys must match both
+type APIKeySelection struct {
+	IDs              []uint
+	ProviderConfigID *uint
+}
+
+// APIKeyLimits are the usage limits a bulk update sets; nil leaves a limit as is
+type APIKeyLimits struct {
+	DailyRequestLimit   *int
+	MonthlyRequestLimit *int
+	DailyTokenLimit     *int
+	MonthlyTokenLimit   *int
+}
+
+// BulkDeactivateAPIKeys deactivates the selected API keys and returns their IDs
+func (s *APIKeyService) BulkDeactivateAPIKeys(userID uint, sel *APIKeySelection) ([]uint, error) {
+	return s.bulkUpdateAPIKeys(userID, sel, map[string]interface{}{"is_active": false})
+}
+
+// BulkUpdateAPIKeyLimits sets usage limits on the selected API keys and returns their IDs
+func (s *APIKeyService) BulkUpdateAPIKeyLimits(userID uint, sel *APIKeySelection, limits *APIKeyLimits) ([]uint, error) {
+	updates := map[string]interface{}{}
+	if limits.DailyRequestLimit != nil {
+		updates["daily_request_limit"] = *limits.DailyRequestLimit
+	}
+	if limits.MonthlyRequestLimit != nil {
+		updates["monthly_request_limit"] = *limits.MonthlyRequestLimit
+	}
+	if limits.DailyTokenLimit != nil {
+		updates["daily_token_limit"] = *limits.DailyTokenLimit
+	}
+	if limits.MonthlyTokenLimit != nil {
+		updates["monthly_token_limit"] = *limits.MonthlyTokenLimit
+	}
+	if len(updates) == 0 {
+		return nil, ErrNoLimitsToUpdate
+	}
+	return s.bulkUpdateAPIKeys(userID, sel, updates)
+}
+
+// BulkDeleteAPIKeys deletes the selected API keys and returns their IDs
+func (s *APIKeyService) BulkDeleteAPIKeys(userID uint, sel *APIKeySelection) ([]uint, error) {
+	var ids []uint
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if ids, err = selectAPIKeys(tx, userID, sel); err != nil || len(ids) == 0 {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM api_key_providers WHERE api_key_id IN ?", ids).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Delete(&database.APIKey{}).Error
+	})
+	if err == nil {
+		s.publishChanges(userID, ids, "delete")
+	}
+	return ids, err
+}
+
+// bulkUpdateAPIKeys applies updates to the selected API keys and returns their IDs
+func (s *APIKeyService) bulkUpdateAPIKeys(userID uint, sel *APIKeySelection, updates map[string]interface{}) ([]uint, error) {
+	var ids []uint
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if ids, err = selectAPIKeys(tx, userID, sel); err != nil || len(ids) == 0 {
+			return err
+		}
+		return tx.Model(&database.APIKey{}).Where("id IN ?", ids).Updates(updates).Error
+	})
+	if err == nil {
+		s.publishChanges(userID, ids, "update")
+	}
+	return ids, err
+}
+
+// publishChanges announces a bulk change to API keys on the event bus
+func (s *APIKeyService) publishChanges(userID uint, ids []uint, action string) {
+	for _, id := range ids {
+		s.publishChange(userID, id, action)
+	}
+}
+
+// selectAPIKeys returns the IDs of a user's API keys matching sel
+func selectAPIKeys(tx *gorm.DB, userID uint, sel *APIKeySelection) ([]uint, error) {
+	if len(sel.IDs) == 0 && sel.ProviderConfigID == nil {
+		return nil, ErrEmptyKeySelection
+	}
+	query := tx.Model(&database.APIKey{}).Where("user_id = ?", userID)
+	if len(sel.IDs) > 0 {
+		query = query.Where("id IN ?", sel.IDs)
+	}
+	if sel.ProviderConfigID != nil {
+		bound := tx.Table("api_key_providers").Select("api_key_id").Where("provider_config_id = ?", *sel.ProviderConfigID)
+		query = query.Where("id IN (?)", bound)
+	}
+	ids := []uint{}
+	err := query.Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	archiveQueueSize     = 256
+	archivePurgeInterval = time.Hour
+	archivePurgeBatch    = 500
+)
+
+// ArchiveEntry is one archived request/response exchange
+type ArchiveEntry struct {
+	TraceID             string    `json:"trace_id"`
+	APIKeyID            uint      `json:"api_key_id"`
+	UserID              uint      `json:"user_id"`
+	Method              string    `json:"method"`
+	Path                string    `json:"path"`
+	Query               string    `json:"query,omitempty"`
+	StatusCode          int       `json:"status_code"`
+	StartedAt           time.Time `json:"started_at"`
+	DurationMs          int64     `json:"duration_ms"`
+	RequestContentType  string    `json:"request_content_type,omitempty"`
+	RequestBody         string    `json:"request_body"`
+	RequestTruncated    bool      `json:"request_truncated,omitempty"`
+	ResponseContentType string    `json:"response_content_type,omitempty"`
+	ResponseBody        string    `json:"response_body"`
+	ResponseTruncated   bool      `json:"response_truncated,omitempty"`
+	AssembledOutput     string    `json:"assembled_output,omitempty"` // text assembled from a streamed response
+
+	Injection *InjectionResult `json:"prompt_injection,omitempty"` // prompt injection score of the user content
+}
+
+// ArchiveService uploads encrypted request/response archives to object storage
+// and deletes them once their retention period has passed
+type ArchiveService struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	storage ObjectStorage
+	queue   chan *ArchiveEntry
+}
+
+// NewArchiveService creates a new ArchiveService. Archiving is disabled when no
+// bucket is configured.
+func NewArchiveService(db *gorm.DB, cfg *config.Config) *ArchiveService {
+	s := &ArchiveService{db: db, cfg: cfg, queue: make(chan *ArchiveEntry, archiveQueueSize)}
+	if cfg.ArchiveBucket != "" {
+		s.storage = NewS3Storage(cfg)
+	}
+	return s
+}
+
+// Enabled reports whether an archive destination is configured
+func (s *ArchiveService) Enabled() bool {
+	return s.storage != nil
+}
+
+// MaxBodyBytes is the largest request or response body kept in an archive entry
+func (s *ArchiveService) MaxBodyBytes() int {
+	return s.cfg.ArchiveMaxBodyBytes
+}
+
+// Submit queues an entry for upload without blocking; entries are dropped when the queue is full
+func (s *ArchiveService) Submit(entry *ArchiveEntry) {
+	if !s.Enabled() {
+		return
+	}
+	select {
+	case s.queue <- entry:
+	default:
+		log.Printf("[Archive] Queue full, dropping archive for trace=%s key=%d", entry.TraceID, entry.APIKeyID)
+	}
+}
+
+// objectKey returns the storage key for an entry
+func (s *ArchiveService) objectKey(entry *ArchiveEntry) string {
+	ts := entry.StartedAt.UTC()
+	return fmt.Sprintf("%s%s/key-%d/%s-%s.json.enc",
+		s.cfg.ArchivePrefix, ts.Format("2006/01/02"), entry.APIKeyID, ts.Format("150405.000000"), entry.TraceID)
+}
+
+// store encrypts and uploads one entry and records it for retention
+func (s *ArchiveService) store(ctx context.Context, entry *ArchiveEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	encKey, err := s.cfg.GetEncryptionKeyBytes()
+	if err != nil {
+		return err
+	}
+	encrypted, err := utils.EncryptBytes(data, encKey)
+	if err != nil {
+		return err
+	}
+
+	key := s.objectKey(entry)
+	if err := s.storage.Put(ctx, key, encrypted, "application/octet-stream"); err != nil {
+		return err
+	}
+
+	return s.db.Create(&database.ArchiveObject{
+		APIKeyID:  entry.APIKeyID,
+		UserID:    entry.UserID,
+		ObjectKey: key,
+		TraceID:   entry.TraceID,
+		SizeBytes: int64(len(encrypted)),
+		ExpiresAt: entry.StartedAt.AddDate(0, 0, s.cfg.ArchiveRetentionDays),
+	}).Error
+}
+
+// deleteObjects removes archived objects from storage and their tracking rows
+func (s *ArchiveService) deleteObjects(ctx context.Context, objects []database.ArchiveObject) int {
+	deleted := 0
+	for i := range objects {
+		if err := s.storage.Delete(ctx, objects[i].ObjectKey); err != nil {
+			log.Printf("[Archive] Failed to delete %s: %v", objects[i].ObjectKey, err)
+			continue
+		}
+		s.db.Delete(&objects[i])
+		deleted++
+	}
+	return deleted
+}
+
+// PurgeExpired deletes archives whose retention period ended before now
+func (s *ArchiveService) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	if !s.Enabled() {
+		return 0, nil
+	}
+
+	var objects []database.ArchiveObject
+	if err := s.db.Where("expires_at < ?", now).Limit(archivePurgeBatch).Find(&objects).Error; err != nil {
+		return 0, err
+	}
+	return s.deleteObjects(ctx, objects), nil
+}
+
+// DeleteForUser removes every archive belonging to a user from storage
+func (s *ArchiveService) DeleteForUser(ctx context.Context, userID uint) error {
+	var objects []database.ArchiveObject
+	if err := s.db.Where("user_id = ?", userID).Find(&objects).Error; err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return nil
+	}
+	if !s.Enabled() {
+		return fmt.Errorf("%d archived objects remain but archive storage is not configured", len(objects))
+	}
+	if deleted := s.deleteObjects(ctx, objects); deleted < len(objects) {
+		return fmt.Errorf("failed to delete %d of %d archived objects", len(objects)-deleted, len(objects))
+	}
+	return nil
+}
+
+// Run uploads queued entries and purges expired archives until ctx is cancelled
+func (s *ArchiveService) Run(ctx context.Context) {
+	if !s.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(archivePurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case entry := <-s.queue:
+			if err := s.store(ctx, entry); err != nil {
+				log.Printf("[Archive] Failed to archive trace=%s key=%d: %v", entry.TraceID, entry.APIKeyID, err)
+			}
+		case <-ticker.C:
+			if purged, err := s.PurgeExpired(ctx, time.Now()); err != nil {
+				log.Printf("[Archive] Failed to purge expired archives: %v", err)
+			} else if purged > 0 {
+				log.Printf("[Archive] Purged %d expired archives", purged)
+			}
+		}
+	}
+}
+
+// AssembleStreamText concatenates the generated text in an SSE response body.
+// It understands OpenAI chat and Responses, Anthropic Messages and Gemini streams.
+func AssembleStreamText(body []byte) string {
+	transcript := NewStreamTranscript(0)
+	transcript.Write(body)
+	return transcript.Text()
+}
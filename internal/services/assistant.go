@@ -0,0 +1,60 @@
+package services
+
+import (
+	"errors"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// AssistantService tracks which caller owns upstream Assistants API objects so
+// keys sharing a provider account cannot see each other's assistants and threads
+type AssistantService struct {
+	db *gorm.DB
+}
+
+// NewAssistantService creates a new AssistantService
+func NewAssistantService(db *gorm.DB) *AssistantService {
+	return &AssistantService{db: db}
+}
+
+// Track records objectID as owned by scope
+func (s *AssistantService) Track(scope, objectType, objectID string) error {
+	return s.db.Where(database.AssistantObject{ObjectID: objectID}).
+		Assign(database.AssistantObject{Scope: scope, ObjectType: objectType}).
+		FirstOrCreate(&database.AssistantObject{}).Error
+}
+
+// Owns reports whether scope created objectID through the gateway
+func (s *AssistantService) Owns(scope, objectID string) (bool, error) {
+	var obj database.AssistantObject
+	err := s.db.Where("object_id = ?", objectID).First(&obj).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return obj.Scope == scope, nil
+}
+
+// OwnedIDs returns the set of object IDs of a type owned by scope
+func (s *AssistantService) OwnedIDs(scope, objectType string) (map[string]bool, error) {
+	var ids []string
+	if err := s.db.Model(&database.AssistantObject{}).
+		Where("scope = ? AND object_type = ?", scope, objectType).
+		Pluck("object_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		owned[id] = true
+	}
+	return owned, nil
+}
+
+// Untrack forgets objectID after it has been deleted upstream
+func (s *AssistantService) Untrack(objectID string) error {
+	return s.db.Where("object_id = ?", objectID).Delete(&database.AssistantObject{}).Error
+}
@@ -0,0 +1,61 @@
+package services
+
+import "testing"
+
+func TestAssistant_Ownership(t *testing.T) {
+	svc := NewAssistantService(testDB(t))
+	for _, obj := range []struct{ scope, objectType, id string }{
+		{"key:1", "assistant", "asst_a"},
+		{"key:1", "thread", "thread_a"},
+		{"key:2", "assistant", "asst_b"},
+	} {
+		if err := svc.Track(obj.scope, obj.objectType, obj.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		scope string
+		id    string
+		want  bool
+	}{
+		{"key:1", "asst_a", true},
+		{"key:1", "thread_a", true},
+		{"key:2", "asst_a", false},
+		{"user:1", "asst_a", false},
+		{"key:2", "asst_b", true},
+		{"key:1", "asst_unknown", false},
+	}
+	for _, tt := range tests {
+		got, err := svc.Owns(tt.scope, tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Owns(%q, %q) = %v, want %v", tt.scope, tt.id, got, tt.want)
+		}
+	}
+
+	owned, err := svc.OwnedIDs("key:1", "assistant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owned) != 1 || !owned["asst_a"] {
+		t.Errorf("OwnedIDs(key:1, assistant) = %v, want only asst_a", owned)
+	}
+
+	// Tracking an ID again moves it to the new scope
+	if err := svc.Track("key:2", "assistant", "asst_a"); err != nil {
+		t.Fatal(err)
+	}
+	if owns, _ := svc.Owns("key:1", "asst_a"); owns {
+		t.Error("a re-tracked object must leave its previous scope")
+	}
+
+	if err := svc.Untrack("asst_a"); err != nil {
+		t.Fatal(err)
+	}
+	if owns, _ := svc.Owns("key:2", "asst_a"); owns {
+		t.Error("an untracked object must not be owned")
+	}
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// auditExportBatchSize is how many entries are loaded at a time when exporting or verifying
+const auditExportBatchSize = 500
+
+// errAuditChainBroken stops verification at the first mismatching entry
+var errAuditChainBroken = errors.New("audit log hash chain broken")
+
+// Formats of an audit log export
+const (
+	AuditExportJSONL = "jsonl"
+	AuditExportCSV   = "csv"
+)
+
+// AuditLogFilter selects audit log entries. Zero values match everything.
+type AuditLogFilter struct {
+	ActorID      *uint
+	Action       string
+	ResourceType string
+	Since        *time.Time
+	Until        *time.Time
+	BeforeID     uint // only entries older than this ID, for paging
+	Limit        int
+}
+
+// AuditVerification is the result of checking the audit log's hash chain
+type AuditVerification struct {
+	Valid     bool   `json:"valid"`
+	Entries   int    `json:"entries"`
+	BrokenAt  *uint  `json:"broken_at,omitempty"` // first entry whose hash does not match
+	LastHash  string `json:"last_hash"`
+	CheckedAt string `json:"checked_at"`
+}
+
+// AuditService appends management actions to the audit log and reads them back
+type AuditService struct {
+	db *gorm.DB
+	mu sync.Mutex // serializes appends so the hash chain stays linear
+}
+
+// NewAuditService creates a new AuditService
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// auditHash returns the chained hash of an entry
+func auditHash(entry *database.AuditLogEntry) string {
+	content, _ := json.Marshal([]interface{}{
+		entry.PrevHash,
+		entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+		entry.ActorID,
+		entry.ActorName,
+		entry.IP,
+		entry.Action,
+		entry.Path,
+		entry.ResourceType,
+		entry.ResourceID,
+		entry.StatusCode,
+		entry.Request,
+		entry.Before,
+		entry.After,
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Record appends an entry to the audit log, chaining it to the previous entry
+func (s *AuditService) Record(entry *database.AuditLogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var last database.AuditLogEntry
+		err := tx.Select("hash").Order("id DESC").First(&last).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		entry.ID = 0
+		entry.PrevHash = last.Hash
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		entry.Hash = auditHash(entry)
+		return tx.Create(entry).Error
+	})
+}
+
+// query applies a filter to an audit log query
+func (s *AuditService) query(filter *AuditLogFilter) *gorm.DB {
+	query := s.db.Model(&database.AuditLogEntry{})
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.ResourceType != "" {
+		query = query.Where("resource_type = ?", filter.ResourceType)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", *filter.Until)
+	}
+	if filter.BeforeID > 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+	return query
+}
+
+// List returns the newest audit log entries matching a filter
+func (s *AuditService) List(filter *AuditLogFilter) ([]database.AuditLogEntry, error) {
+	var entries []database.AuditLogEntry
+	err := s.query(filter).Order("id DESC").Limit(filter.Limit).Find(&entries).Error
+	return entries, err
+}
+
+// Export writes all audit log entries matching a filter to w, oldest first, as
+// JSON lines or CSV
+func (s *AuditService) Export(w io.Writer, filter *AuditLogFilter, format string) error {
+	var csvWriter *csv.Writer
+	encoder := json.NewEncoder(w)
+	if format == AuditExportCSV {
+		csvWriter = csv.NewWriter(w)
+		header := []string{"id", "created_at", "actor_id", "actor_name", "ip", "action", "path",
+			"resource_type", "resource_id", "status_code", "request", "before", "after", "prev_hash", "hash"}
+		if err := csvWriter.Write(header); err != nil {
+			return err
+		}
+	}
+
+	var entries []database.AuditLogEntry
+	var writeErr error
+	result := s.query(filter).Order("id").FindInBatches(&entries, auditExportBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, entry := range entries {
+			if csvWriter == nil {
+				if writeErr = encoder.Encode(entry); writeErr != nil {
+					return writeErr
+				}
+				continue
+			}
+			actorID := ""
+			if entry.ActorID != nil {
+				actorID = strconv.FormatUint(uint64(*entry.ActorID), 10)
+			}
+			writeErr = csvWriter.Write([]string{
+				strconv.FormatUint(uint64(entry.ID), 10),
+				entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+				actorID,
+				entry.ActorName,
+				entry.IP,
+				entry.Action,
+				entry.Path,
+				entry.ResourceType,
+				entry.ResourceID,
+				strconv.Itoa(entry.StatusCode),
+				entry.Request,
+				entry.Before,
+				entry.After,
+				entry.PrevHash,
+				entry.Hash,
+			})
+			if writeErr != nil {
+				return writeErr
+			}
+		}
+		if csvWriter != nil {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+		return nil
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+	return writeErr
+}
+
+// Verify recomputes the hash chain of the whole audit log to detect entries
+// that were altered, removed or inserted outside the gateway
+func (s *AuditService) Verify() (*AuditVerification, error) {
+	result := &AuditVerification{Valid: true, CheckedAt: time.Now().UTC().Format(time.RFC3339)}
+
+	var entries []database.AuditLogEntry
+	err := s.db.Order("id").FindInBatches(&entries, auditExportBatchSize, func(tx *gorm.DB, batch int) error {
+		for i := range entries {
+			entry := &entries[i]
+			if entry.PrevHash != result.LastHash || auditHash(entry) != entry.Hash {
+				id := entry.ID
+				result.Valid = false
+				result.BrokenAt = &id
+				return errAuditChainBroken
+			}
+			result.LastHash = entry.Hash
+			result.Entries++
+		}
+		return nil
+	}).Error
+	if err != nil && !errors.Is(err, errAuditChainBroken) {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// auditFixture records a key creation and deletion by user 1 and a config
+// change by user 2
+func auditFixture(t *testing.T) (*AuditService, *gorm.DB, []database.AuditLogEntry) {
+	db := testDB(t)
+	svc := NewAuditService(db)
+	alice, bob := uint(1), uint(2)
+	entries := []database.AuditLogEntry{
+		{ActorID: &alice, ActorName: "alice", Action: "POST /api/keys", ResourceType: "keys", StatusCode: 201, After: `{"id":1}`},
+		{ActorID: &bob, ActorName: "bob", Action: "PUT /api/config/:id", ResourceType: "config", ResourceID: "3", StatusCode: 200, Before: `{"name":"a"}`, After: `{"name":"b"}`},
+		{ActorID: &alice, ActorName: "alice", Action: "DELETE /api/keys/:id", ResourceType: "keys", ResourceID: "1", StatusCode: 200},
+	}
+	for i := range entries {
+		if err := svc.Record(&entries[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return svc, db, entries
+}
+
+func TestAudit_RecordChainsEntries(t *testing.T) {
+	_, _, entries := auditFixture(t)
+	for i, entry := range entries {
+		prev := ""
+		if i > 0 {
+			prev = entries[i-1].Hash
+		}
+		if entry.PrevHash != prev {
+			t.Errorf("entry %d: prev_hash = %q, want %q", i, entry.PrevHash, prev)
+		}
+		if entry.Hash != auditHash(&entry) {
+			t.Errorf("entry %d: hash does not match its content", i)
+		}
+	}
+}
+
+func TestAudit_List(t *testing.T) {
+	svc, _, entries := auditFixture(t)
+	alice := uint(1)
+
+	tests := []struct {
+		name   string
+		filter AuditLogFilter
+		want   []uint // entry IDs, newest first
+	}{
+		{"all", AuditLogFilter{}, []uint{entries[2].ID, entries[1].ID, entries[0].ID}},
+		{"by actor", AuditLogFilter{ActorID: &alice}, []uint{entries[2].ID, entries[0].ID}},
+		{"by action", AuditLogFilter{Action: "PUT /api/config/:id"}, []uint{entries[1].ID}},
+		{"by resource type", AuditLogFilter{ResourceType: "keys"}, []uint{entries[2].ID, entries[0].ID}},
+		{"page", AuditLogFilter{BeforeID: entries[2].ID, Limit: 1}, []uint{entries[1].ID}},
+	}
+	for _, tt := range tests {
+		if tt.filter.Limit == 0 {
+			tt.filter.Limit = 50
+		}
+		got, err := svc.List(&tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []uint
+		for _, entry := range got {
+			ids = append(ids, entry.ID)
+		}
+		if len(ids) != len(tt.want) {
+			t.Errorf("%s: got entries %v, want %v", tt.name, ids, tt.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.want[i] {
+				t.Errorf("%s: got entries %v, want %v", tt.name, ids, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestAudit_Export(t *testing.T) {
+	svc, _, entries := auditFixture(t)
+
+	var jsonl bytes.Buffer
+	if err := svc.Export(&jsonl, &AuditLogFilter{ResourceType: "keys"}, AuditExportJSONL); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(jsonl.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("exported %d JSON lines, want 2", len(lines))
+	}
+	var first database.AuditLogEntry
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil || first.ID != entries[0].ID || first.Hash != entries[0].Hash {
+		t.Errorf("first JSON line = %s, want the oldest entry", lines[0])
+	}
+
+	var csvOut bytes.Buffer
+	if err := svc.Export(&csvOut, &AuditLogFilter{}, AuditExportCSV); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&csvOut).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(entries)+1 || records[0][0] != "id" {
+		t.Fatalf("exported %d CSV records, want a header and %d entries", len(records), len(entries))
+	}
+	if got := records[2][12]; got != `{"name":"b"}` {
+		t.Errorf("after column of the config change = %q", got)
+	}
+}
+
+func TestAudit_EntriesAreImmutable(t *testing.T) {
+	_, db, entries := auditFixture(t)
+	if err := db.Model(&entries[0]).Update("actor_name", "mallory").Error; err == nil {
+		t.Error("updating an audit log entry must fail")
+	}
+	if err := db.Delete(&entries[0]).Error; err == nil {
+		t.Error("deleting an audit log entry must fail")
+	}
+}
+
+// TestAudit_VerifyDetectsTampering changes the audit log outside the gateway
+func TestAudit_VerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(db *gorm.DB, entries []database.AuditLogEntry) error
+		broken int // index of the first entry reported broken, -1 for none
+	}{
+		{"untouched", func(db *gorm.DB, entries []database.AuditLogEntry) error { return nil }, -1},
+		{"altered entry", func(db *gorm.DB, entries []database.AuditLogEntry) error {
+			return db.Exec("UPDATE audit_log_entries SET after = ? WHERE id = ?", `{"name":"c"}`, entries[1].ID).Error
+		}, 1},
+		{"removed entry", func(db *gorm.DB, entries []database.AuditLogEntry) error {
+			return db.Exec("DELETE FROM audit_log_entries WHERE id = ?", entries[1].ID).Error
+		}, 2},
+		{"rehashed entry", func(db *gorm.DB, entries []database.AuditLogEntry) error {
+			entry := entries[0]
+			entry.ActorName = "mallory"
+			return db.Exec("UPDATE audit_log_entries SET actor_name = ?, hash = ? WHERE id = ?", entry.ActorName, auditHash(&entry), entry.ID).Error
+		}, 1},
+	}
+	for _, tt := range tests {
+		svc, db, entries := auditFixture(t)
+		if err := tt.tamper(db, entries); err != nil {
+			t.Fatal(err)
+		}
+		result, err := svc.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.broken < 0 {
+			if !result.Valid || result.Entries != len(entries) || result.LastHash != entries[len(entries)-1].Hash {
+				t.Errorf("%s: got %+v, want a valid chain of %d entries", tt.name, result, len(entries))
+			}
+			continue
+		}
+		if result.Valid || result.BrokenAt == nil || *result.BrokenAt != entries[tt.broken].ID {
+			t.Errorf("%s: got %+v, want broken at entry %d", tt.name, result, entries[tt.broken].ID)
+		}
+	}
+}
@@ -87,11 +87,6 @@ func (s *AuthService) Authenticate(email, password string) (*database.User, erro
 	return &user, nil
 }
 
-// CreateToken creates a JWT token for a user
-func (s *AuthService) CreateToken(user *database.User) (string, error) {
-	return utils.CreateAccessToken(user.ID, s.cfg.JWTSecret, s.cfg.JWTExpiration)
-}
-
 // GetUserByID gets a user by ID
 func (s *AuthService) GetUserByID(userID uint) (*database.User, error) {
 	var user database.User
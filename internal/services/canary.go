@@ -0,0 +1,191 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+
+	"gorm.io/gorm"
+)
+
+const (
+	canaryWebhookTimeout = 10 * time.Second
+	// canaryAlertInterval is how often a canary key alerts at most; a scanner
+	// retrying the key is recorded on every request but alerts once a minute
+	canaryAlertInterval = time.Minute
+	// canaryHitsLimit is how many of the latest hits of a canary key are listed
+	canaryHitsLimit = 100
+)
+
+// CanaryKeyCreate represents a request to create a canary API key
+type CanaryKeyCreate struct {
+	Name       string `json:"name" validate:"required,min=1,max=100"`
+	WebhookURL string `json:"webhook_url"`
+	Email      bool   `json:"email"` // email the owner when the key is used
+}
+
+// CanaryService creates canary API keys, and records and alerts on their use.
+// The gateway announces each use with a key.canary_used event, handled by
+// Handle away from the request.
+type CanaryService struct {
+	db     *gorm.DB
+	bus    *events.Bus
+	keys   *APIKeyService
+	mailer Mailer
+	client *http.Client
+
+	alertedAt map[uint]time.Time // by API key ID; only touched by Handle
+}
+
+// NewCanaryService creates a new CanaryService
+func NewCanaryService(db *gorm.DB, bus *events.Bus, keys *APIKeyService, mailer Mailer) *CanaryService {
+	return &CanaryService{
+		db:        db,
+		bus:       bus,
+		keys:      keys,
+		mailer:    mailer,
+		client:    &http.Client{Timeout: canaryWebhookTimeout},
+		alertedAt: make(map[uint]time.Time),
+	}
+}
+
+// CreateCanaryKey creates a canary API key, returning the full key. It looks
+// like any other key of the gateway but has no provider configs.
+func (s *CanaryService) CreateCanaryKey(userID uint, req *CanaryKeyCreate) (*database.APIKey, string, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		return nil, "", errors.New("name must be 1-100 characters")
+	}
+	if err := validateWebhookURL(req.WebhookURL); err != nil {
+		return nil, "", err
+	}
+
+	fullKey, keyHash, keyPrefix, err := s.keys.GenerateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	key := &database.APIKey{
+		UserID:           userID,
+		Name:             name,
+		KeyHash:          keyHash,
+		KeyPrefix:        keyPrefix,
+		IsActive:         true,
+		RoutingStrategy:  RoutingOrdered,
+		DailyResetAt:     nextDailyReset(now, s.keys.loc),
+		MonthlyResetAt:   nextMonthlyReset(now, s.keys.loc),
+		Canary:           true,
+		CanaryWebhookURL: req.WebhookURL,
+		CanaryEmail:      req.Email,
+	}
+	if err := s.db.Create(key).Error; err != nil {
+		return nil, "", err
+	}
+	return key, fullKey, nil
+}
+
+// ListHits returns the latest requests made with a canary key of a user
+func (s *CanaryService) ListHits(userID, keyID uint) ([]database.CanaryHit, error) {
+	if _, err := s.keys.GetAPIKeyByID(userID, keyID); err != nil {
+		return nil, err
+	}
+	var hits []database.CanaryHit
+	err := s.db.Where("api_key_id = ?", keyID).Order("created_at DESC").Limit(canaryHitsLimit).Find(&hits).Error
+	return hits, err
+}
+
+// Trigger announces a request made with a canary key
+func (s *CanaryService) Trigger(key *database.APIKey, use events.CanaryUse) {
+	use.APIKeyID, use.APIKeyName, use.KeyPrefix = key.ID, key.Name, key.KeyPrefix
+	s.bus.Publish(events.Event{Type: events.CanaryUsed, UserID: key.UserID, Data: use})
+}
+
+// Handle records a canary key use and alerts the key's owner by webhook and
+// email. It is registered as the handler of key.canary_used events.
+func (s *CanaryService) Handle(event events.Event) {
+	use, ok := event.Data.(events.CanaryUse)
+	if !ok {
+		return
+	}
+	hit := &database.CanaryHit{
+		APIKeyID:  use.APIKeyID,
+		UserID:    event.UserID,
+		IP:        use.IP,
+		UserAgent: TruncateText(use.UserAgent, 255),
+		Method:    use.Method,
+		Path:      TruncateText(use.Path, 255),
+		CreatedAt: event.Time,
+	}
+	if err := s.db.Create(hit).Error; err != nil {
+		log.Printf("[Canary] Failed to record use of key=%d: %v", use.APIKeyID, err)
+	}
+
+	if last, ok := s.alertedAt[use.APIKeyID]; ok && event.Time.Sub(last) < canaryAlertInterval {
+		return
+	}
+	s.alertedAt[use.APIKeyID] = event.Time
+
+	var key database.APIKey
+	if err := s.db.Preload("User").First(&key, use.APIKeyID).Error; err != nil {
+		log.Printf("[Canary] Failed to load key=%d for alerting: %v", use.APIKeyID, err)
+		return
+	}
+	if key.CanaryWebhookURL != "" {
+		s.sendWebhook(key.CanaryWebhookURL, event.Time, use)
+	}
+	if key.CanaryEmail && key.User.Email != "" {
+		subject := fmt.Sprintf("Canary API key %q was used", key.Name)
+		body := fmt.Sprintf("The canary API key %q (%s...) was used at %s, which suggests it has leaked.\n\n"+
+			"IP: %s\nUser agent: %s\nRequest: %s %s\n\n"+
+			"The request was rejected. Check where the key was planted to find the leak.\n",
+			key.Name, key.KeyPrefix, event.Time.UTC().Format(time.RFC3339), use.IP, use.UserAgent, use.Method, use.Path)
+		if err := s.mailer.Send(key.User.Email, subject, body); err != nil {
+			log.Printf("[Canary] Failed to email alert for key=%d: %v", use.APIKeyID, err)
+		}
+	}
+}
+
+// canaryAlert is the JSON payload posted to a canary key's webhook
+type canaryAlert struct {
+	Event string `json:"event"` // canary_key_used
+	events.CanaryUse
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// sendWebhook posts a canary alert to a webhook, logging failures
+func (s *CanaryService) sendWebhook(webhookURL string, at time.Time, use events.CanaryUse) {
+	body, err := json.Marshal(canaryAlert{Event: "canary_key_used", CanaryUse: use, Timestamp: at})
+	if err != nil {
+		return
+	}
+	resp, err := s.client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[Canary] Failed to deliver alert for key=%d: %v", use.APIKeyID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[Canary] Webhook for key=%d returned status %d", use.APIKeyID, resp.StatusCode)
+	}
+}
+
+// validateWebhookURL checks that a webhook URL, when set, is an http or https URL
+func validateWebhookURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook_url must be an http or https URL")
+	}
+	return nil
+}
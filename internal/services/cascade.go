@@ -0,0 +1,143 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// CascadeConfidencePrompt asks the draft model to rate its own answer
+const CascadeConfidencePrompt = "After your answer, add a final line of the form \"CONFIDENCE: <number between 0 and 1>\" " +
+	"rating how confident you are that the answer is correct and complete."
+
+// confidenceLine matches the self-rating the draft model appends to its answer
+var confidenceLine = regexp.MustCompile(`(?i)\n?[ \t]*\**confidence\**:\**[ \t]*\**([0-9]*\.?[0-9]+)\**[ \t]*$`)
+
+// ErrCascadePolicyNotFound is returned when a user has no such cascade policy
+var ErrCascadePolicyNotFound = errors.New("cascade policy not found")
+
+// CascadePolicyUpdate represents the draft/verify settings of a model alias
+type CascadePolicyUpdate struct {
+	Model         string  `json:"model"`
+	DraftModel    string  `json:"draft_model"`
+	VerifyModel   string  `json:"verify_model"`
+	MinLength     int     `json:"min_length"`
+	RequireJSON   bool    `json:"require_json"`
+	MinConfidence float64 `json:"min_confidence"`
+	IsActive      *bool   `json:"is_active"`
+}
+
+// CascadeService manages cascade policies and checks drafts against them
+type CascadeService struct {
+	db *gorm.DB
+}
+
+// NewCascadeService creates a new CascadeService
+func NewCascadeService(db *gorm.DB) *CascadeService {
+	return &CascadeService{db: db}
+}
+
+// GetPolicies returns all cascade policies of a user
+func (s *CascadeService) GetPolicies(userID uint) ([]database.CascadePolicy, error) {
+	var policies []database.CascadePolicy
+	err := s.db.Where("user_id = ?", userID).Order("model").Find(&policies).Error
+	return policies, err
+}
+
+// SetPolicy creates or replaces the cascade policy of a model alias
+func (s *CascadeService) SetPolicy(userID uint, req *CascadePolicyUpdate) (*database.CascadePolicy, error) {
+	model := strings.TrimSpace(req.Model)
+	draft := strings.TrimSpace(req.DraftModel)
+	verify := strings.TrimSpace(req.VerifyModel)
+	if model == "" || draft == "" || verify == "" {
+		return nil, errors.New("model, draft_model and verify_model are required")
+	}
+	if model == draft || model == verify {
+		return nil, errors.New("model must be an alias distinct from draft_model and verify_model")
+	}
+	if req.MinLength < 0 {
+		return nil, errors.New("min_length must not be negative")
+	}
+	if req.MinConfidence < 0 || req.MinConfidence > 1 {
+		return nil, errors.New("min_confidence must be between 0 and 1")
+	}
+
+	var policy database.CascadePolicy
+	err := s.db.Where("user_id = ? AND model = ?", userID, model).First(&policy).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	policy.UserID = userID
+	policy.Model = model
+	policy.DraftModel = draft
+	policy.VerifyModel = verify
+	policy.MinLength = req.MinLength
+	policy.RequireJSON = req.RequireJSON
+	policy.MinConfidence = req.MinConfidence
+	policy.IsActive = true
+	if req.IsActive != nil {
+		policy.IsActive = *req.IsActive
+	}
+	if err := s.db.Save(&policy).Error; err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// DeletePolicy removes a cascade policy
+func (s *CascadeService) DeletePolicy(userID, id uint) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&database.CascadePolicy{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCascadePolicyNotFound
+	}
+	return nil
+}
+
+// PolicyFor returns the active cascade policy for a user's model alias, or nil
+func (s *CascadeService) PolicyFor(userID uint, model string) *database.CascadePolicy {
+	var policy database.CascadePolicy
+	err := s.db.Where("user_id = ? AND model = ? AND is_active = ?", userID, model, true).First(&policy).Error
+	if err != nil {
+		return nil
+	}
+	return &policy
+}
+
+// SplitConfidence removes the self-rating line from a draft, returning the answer
+// and the rating; found is false when the draft has no rating
+func SplitConfidence(content string) (answer string, confidence float64, found bool) {
+	m := confidenceLine.FindStringSubmatchIndex(content)
+	if m == nil {
+		return content, 0, false
+	}
+	confidence, err := strconv.ParseFloat(content[m[2]:m[3]], 64)
+	if err != nil {
+		return content, 0, false
+	}
+	return strings.TrimRight(content[:m[0]], " \t\n"), confidence, true
+}
+
+// CheckDraft reports why a draft answer fails the policy's checks, or "" when it passes
+func CheckDraft(policy *database.CascadePolicy, answer string, confidence float64) string {
+	if policy.MinLength > 0 && len([]rune(strings.TrimSpace(answer))) < policy.MinLength {
+		return fmt.Sprintf("shorter than %d characters", policy.MinLength)
+	}
+	if policy.RequireJSON && !json.Valid([]byte(strings.TrimSpace(answer))) {
+		return "not valid JSON"
+	}
+	if policy.MinConfidence > 0 && confidence < policy.MinConfidence {
+		return fmt.Sprintf("confidence %.2f below %.2f", confidence, policy.MinConfidence)
+	}
+	return ""
+}
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"log"
 	"strings"
+	"time"
 
 	"ai_gateway/internal/config"
 	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
 	"ai_gateway/internal/utils"
 
 	"gorm.io/gorm"
@@ -15,13 +17,24 @@ import (
 
 // ConfigService handles provider configuration operations
 type ConfigService struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db     *gorm.DB
+	cfg    *config.Config
+	events *events.Bus      // receives config change events
+	cache  *CredentialCache // holds decrypted API keys
 }
 
-// NewConfigService creates a new ConfigService
-func NewConfigService(db *gorm.DB, cfg *config.Config) *ConfigService {
-	return &ConfigService{db: db, cfg: cfg}
+// NewConfigService creates a new ConfigService publishing changes on bus
+func NewConfigService(db *gorm.DB, cfg *config.Config, bus *events.Bus, cache *CredentialCache) *ConfigService {
+	return &ConfigService{db: db, cfg: cfg, events: bus, cache: cache}
+}
+
+// publishChange announces a change to a provider config on the event bus
+func (s *ConfigService) publishChange(userID, configID uint, action string) {
+	s.events.Publish(events.Event{
+		Type:   events.ConfigChanged,
+		UserID: userID,
+		Data:   events.ConfigChange{ProviderConfigID: configID, Action: action},
+	})
 }
 
 // ProviderConfigCreate represents a request to create a provider config
@@ -30,8 +43,24 @@ type ProviderConfigCreate struct {
 	Name       string   `json:"name" validate:"required,min=1,max=100"`
 	BaseURL    string   `json:"base_url"`
 	Protocol   string   `json:"protocol" validate:"oneof=anthropic openai_chat openai_code gemini"`
+	Region     string   `json:"region"`
 	APIKey     string   `json:"api_key" validate:"required"`
 	ModelCodes []string `json:"model_codes"`
+
+	ReasoningEffort   string `json:"reasoning_effort"`
+	ReasoningSummary  string `json:"reasoning_summary"`
+	ReasoningOverride bool   `json:"reasoning_override"`
+
+	// MaxOutputTokens overrides the output ceiling of the config's models; nil or 0 uses their published limits
+	MaxOutputTokens *int `json:"max_output_tokens"`
+
+	// Monthly spend caps across all API keys using the config; nil or 0 is unlimited
+	MonthlyTokenCap *int     `json:"monthly_token_cap"`
+	MonthlyCostCap  *float64 `json:"monthly_cost_cap"`
+
+	// Activation schedule, a condition on hour, weekday and time; empty for none
+	ActiveSchedule   string `json:"active_schedule"`
+	ScheduleTimezone string `json:"schedule_timezone"` // UTC by default
 }
 
 // ProviderConfigUpdate represents a request to update a provider config
@@ -39,8 +68,24 @@ type ProviderConfigUpdate struct {
 	Name       *string  `json:"name"`
 	BaseURL    *string  `json:"base_url"`
 	Protocol   *string  `json:"protocol"`
+	Region     *string  `json:"region"`
 	APIKey     *string  `json:"api_key"`
 	ModelCodes []string `json:"model_codes"`
+
+	ReasoningEffort   *string `json:"reasoning_effort"`
+	ReasoningSummary  *string `json:"reasoning_summary"`
+	ReasoningOverride *bool   `json:"reasoning_override"`
+
+	// MaxOutputTokens overrides the output ceiling of the config's models; 0 removes the override
+	MaxOutputTokens *int `json:"max_output_tokens"`
+
+	// Monthly spend caps; 0 removes a cap
+	MonthlyTokenCap *int     `json:"monthly_token_cap"`
+	MonthlyCostCap  *float64 `json:"monthly_cost_cap"`
+
+	// Activation schedule; an empty schedule removes it
+	ActiveSchedule   *string `json:"active_schedule"`
+	ScheduleTimezone *string `json:"schedule_timezone"`
 }
 
 // GetConfigs returns all provider configs for a user
@@ -50,11 +95,27 @@ func (s *ConfigService) GetConfigs(userID uint) ([]database.ProviderConfig, erro
 	return configs, err
 }
 
-// GetConfigsByProvider returns provider configs by provider type
-func (s *ConfigService) GetConfigsByProvider(userID uint, provider string) ([]database.ProviderConfig, error) {
+// ListConfigs returns a page of a user's provider configs, newest first, and
+// how many match. A non-empty provider limits them to that provider type.
+// Search matches the name; Status is active or inactive.
+func (s *ConfigService) ListConfigs(userID uint, provider string, q *ListQuery) ([]database.ProviderConfig, int64, error) {
+	query := q.filter(s.db.Model(&database.ProviderConfig{}).Where("user_id = ?", userID), "name")
+	if provider != "" {
+		query = query.Where("provider = ?", provider)
+	}
+	switch q.Status {
+	case "":
+	case "active":
+		query = query.Where("is_active = ?", true)
+	case "inactive":
+		query = query.Where("is_active = ?", false)
+	default:
+		return nil, 0, ErrInvalidListStatus
+	}
+
 	var configs []database.ProviderConfig
-	err := s.db.Where("user_id = ? AND provider = ?", userID, provider).Order("created_at DESC").Find(&configs).Error
-	return configs, err
+	total, err := q.find(query, "created_at DESC", &configs)
+	return configs, total, err
 }
 
 // GetConfigByID returns a provider config by ID
@@ -86,26 +147,39 @@ func (s *ConfigService) CreateConfig(userID uint, req *ProviderConfigCreate) (*d
 		return nil, err
 	}
 
-	// Set default base URL if not provided for known providers
-	baseURL := req.BaseURL
-	if baseURL == "" {
-		switch req.Provider {
-		case "openai":
-			baseURL = s.cfg.OpenAIBaseURL
-		case "anthropic":
-			baseURL = s.cfg.AnthropicBaseURL
-		case "gemini":
-			baseURL = s.cfg.GeminiBaseURL
-		default:
-			// For any custom provider name, base URL is required
-			return nil, errors.New("base_url is required for this provider")
-		}
+	baseURL, err := s.baseURLFor(req.Provider, req.BaseURL)
+	if err != nil {
+		return nil, err
 	}
 
 	protocol := normalizeProtocol(strings.TrimSpace(req.Protocol))
 	if err := validateProtocol(protocol); err != nil {
 		return nil, err
 	}
+	if err := validateReasoning(req.ReasoningEffort, req.ReasoningSummary); err != nil {
+		return nil, err
+	}
+	if err := validateSpendCaps(req.MonthlyTokenCap, req.MonthlyCostCap); err != nil {
+		return nil, err
+	}
+	if req.MaxOutputTokens != nil && *req.MaxOutputTokens < 0 {
+		return nil, errors.New("max_output_tokens must not be negative")
+	}
+	schedule, timezone := strings.TrimSpace(req.ActiveSchedule), strings.TrimSpace(req.ScheduleTimezone)
+	if err := validateSchedule(schedule, timezone); err != nil {
+		return nil, err
+	}
+	maxOutputTokens := req.MaxOutputTokens
+	if maxOutputTokens != nil && *maxOutputTokens == 0 {
+		maxOutputTokens = nil
+	}
+	tokenCap, costCap := req.MonthlyTokenCap, req.MonthlyCostCap
+	if tokenCap != nil && *tokenCap == 0 {
+		tokenCap = nil
+	}
+	if costCap != nil && *costCap == 0 {
+		costCap = nil
+	}
 
 	// Process model codes
 	modelCodesJSON := ""
@@ -125,23 +199,62 @@ func (s *ConfigService) CreateConfig(userID uint, req *ProviderConfigCreate) (*d
 	cfg := &database.ProviderConfig{
 		UserID:       userID,
 		Provider:     req.Provider,
-		Name:         req.Name,
+		Name:         strings.TrimSpace(req.Name),
 		BaseURL:      baseURL,
 		Protocol:     protocol,
+		Region:       strings.TrimSpace(req.Region),
 		EncryptedKey: encryptedKey,
 		KeyHint:      utils.GetAPIKeyHint(req.APIKey),
 		ModelCodes:   modelCodesJSON,
 		IsDefault:    isDefault,
 		IsActive:     true,
+
+		ReasoningEffort:   req.ReasoningEffort,
+		ReasoningSummary:  req.ReasoningSummary,
+		ReasoningOverride: req.ReasoningOverride,
+		MaxOutputTokens:   maxOutputTokens,
+
+		MonthlyTokenCap: tokenCap,
+		MonthlyCostCap:  costCap,
+		MonthlyResetAt:  nextMonthlyReset(time.Now(), s.cfg.UsageResetLocation()),
+
+		ActiveSchedule:   schedule,
+		ScheduleTimezone: timezone,
 	}
 
-	if err := s.db.Create(cfg).Error; err != nil {
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(cfg).Error; err != nil {
+			return err
+		}
+		return s.recordRevision(tx, cfg, userID, "create")
+	})
+	if err != nil {
 		return nil, err
 	}
+	s.publishChange(userID, cfg.ID, "create")
 
 	return cfg, nil
 }
 
+// baseURLFor returns the base URL of a config of a provider: the given one,
+// trimmed, or the default of a known provider when it is empty
+func (s *ConfigService) baseURLFor(provider, baseURL string) (string, error) {
+	if baseURL = strings.TrimSpace(baseURL); baseURL != "" {
+		return baseURL, nil
+	}
+	switch provider {
+	case "openai":
+		return s.cfg.OpenAIBaseURL, nil
+	case "anthropic":
+		return s.cfg.AnthropicBaseURL, nil
+	case "gemini":
+		return s.cfg.GeminiBaseURL, nil
+	default:
+		// For any custom provider name, base URL is required
+		return "", errors.New("base_url is required for this provider")
+	}
+}
+
 // UpdateConfig updates a provider config
 func (s *ConfigService) UpdateConfig(userID, configID uint, req *ProviderConfigUpdate) (*database.ProviderConfig, error) {
 	cfg, err := s.GetConfigByID(userID, configID)
@@ -152,11 +265,11 @@ func (s *ConfigService) UpdateConfig(userID, configID uint, req *ProviderConfigU
 	updates := map[string]interface{}{}
 
 	if req.Name != nil {
-		updates["name"] = *req.Name
+		updates["name"] = strings.TrimSpace(*req.Name)
 	}
 
 	if req.BaseURL != nil {
-		updates["base_url"] = *req.BaseURL
+		updates["base_url"] = strings.TrimSpace(*req.BaseURL)
 	}
 
 	if req.Protocol != nil {
@@ -167,6 +280,74 @@ func (s *ConfigService) UpdateConfig(userID, configID uint, req *ProviderConfigU
 		updates["protocol"] = protocol
 	}
 
+	if req.Region != nil {
+		updates["region"] = strings.TrimSpace(*req.Region)
+	}
+
+	if req.ReasoningEffort != nil {
+		if err := validateReasoning(*req.ReasoningEffort, ""); err != nil {
+			return nil, err
+		}
+		updates["reasoning_effort"] = *req.ReasoningEffort
+	}
+
+	if req.ReasoningSummary != nil {
+		if err := validateReasoning("", *req.ReasoningSummary); err != nil {
+			return nil, err
+		}
+		updates["reasoning_summary"] = *req.ReasoningSummary
+	}
+
+	if req.ReasoningOverride != nil {
+		updates["reasoning_override"] = *req.ReasoningOverride
+	}
+
+	if req.MaxOutputTokens != nil {
+		switch {
+		case *req.MaxOutputTokens < 0:
+			return nil, errors.New("max_output_tokens must not be negative")
+		case *req.MaxOutputTokens == 0:
+			updates["max_output_tokens"] = nil
+		default:
+			updates["max_output_tokens"] = *req.MaxOutputTokens
+		}
+	}
+
+	if err := validateSpendCaps(req.MonthlyTokenCap, req.MonthlyCostCap); err != nil {
+		return nil, err
+	}
+	if req.MonthlyTokenCap != nil {
+		if *req.MonthlyTokenCap == 0 {
+			updates["monthly_token_cap"] = nil
+		} else {
+			updates["monthly_token_cap"] = *req.MonthlyTokenCap
+		}
+	}
+	if req.MonthlyCostCap != nil {
+		if *req.MonthlyCostCap == 0 {
+			updates["monthly_cost_cap"] = nil
+		} else {
+			updates["monthly_cost_cap"] = *req.MonthlyCostCap
+		}
+	}
+
+	if req.ActiveSchedule != nil || req.ScheduleTimezone != nil {
+		schedule, timezone := cfg.ActiveSchedule, cfg.ScheduleTimezone
+		if req.ActiveSchedule != nil {
+			schedule = strings.TrimSpace(*req.ActiveSchedule)
+		}
+		if req.ScheduleTimezone != nil {
+			timezone = strings.TrimSpace(*req.ScheduleTimezone)
+		}
+		if err := validateSchedule(schedule, timezone); err != nil {
+			return nil, err
+		}
+		updates["active_schedule"] = schedule
+		updates["schedule_timezone"] = timezone
+		// The scheduler applies the new schedule on its next run
+		updates["scheduled_active"] = nil
+	}
+
 	if req.APIKey != nil {
 		encKey, err := s.cfg.GetEncryptionKeyBytes()
 		if err != nil {
@@ -193,9 +374,19 @@ func (s *ConfigService) UpdateConfig(userID, configID uint, req *ProviderConfigU
 	}
 
 	if len(updates) > 0 {
-		if err := s.db.Model(cfg).Updates(updates).Error; err != nil {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(cfg).Updates(updates).Error; err != nil {
+				return err
+			}
+			if err := tx.First(cfg, cfg.ID).Error; err != nil {
+				return err
+			}
+			return s.recordRevision(tx, cfg, userID, "update")
+		})
+		if err != nil {
 			return nil, err
 		}
+		s.publishChange(userID, configID, "update")
 	}
 
 	return s.GetConfigByID(userID, configID)
@@ -210,6 +401,14 @@ func (s *ConfigService) DeleteConfig(userID, configID uint) error {
 	if result.RowsAffected == 0 {
 		return errors.New("config not found")
 	}
+	s.db.Where("provider_config_id = ?", configID).Delete(&database.ProviderSLO{})
+	var syncIDs []uint
+	s.db.Model(&database.ProviderUsageSync{}).Where("provider_config_id = ?", configID).Pluck("id", &syncIDs)
+	if len(syncIDs) > 0 {
+		s.db.Where("sync_id IN ?", syncIDs).Delete(&database.ProviderUsageDay{})
+		s.db.Where("id IN ?", syncIDs).Delete(&database.ProviderUsageSync{})
+	}
+	s.publishChange(userID, configID, "delete")
 	return nil
 }
 
@@ -227,6 +426,7 @@ func (s *ConfigService) SetDefault(userID, configID uint) (*database.ProviderCon
 
 	// Set this as default
 	s.db.Model(cfg).Update("is_default", true)
+	s.publishChange(userID, configID, "default")
 
 	return s.GetConfigByID(userID, configID)
 }
@@ -238,7 +438,19 @@ func (s *ConfigService) ToggleActive(userID, configID uint) (*database.ProviderC
 		return nil, err
 	}
 
-	s.db.Model(cfg).Update("is_active", !cfg.IsActive)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(cfg).Update("is_active", !cfg.IsActive).Error; err != nil {
+			return err
+		}
+		if err := tx.First(cfg, cfg.ID).Error; err != nil {
+			return err
+		}
+		return s.recordRevision(tx, cfg, userID, "toggle")
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.publishChange(userID, configID, "toggle")
 
 	return s.GetConfigByID(userID, configID)
 }
@@ -259,6 +471,9 @@ func (s *ConfigService) GetDefaultConfig(userID uint, provider string) (*databas
 
 // DecryptAPIKey decrypts the API key from a provider config
 func (s *ConfigService) DecryptAPIKey(cfg *database.ProviderConfig) (string, error) {
+	if apiKey, ok := s.cache.Secret(cfg); ok {
+		return apiKey, nil
+	}
 	encKey, err := s.cfg.GetEncryptionKeyBytes()
 	if err != nil {
 		log.Printf("[DECRYPT] Failed to get encryption key bytes: %v", err)
@@ -274,6 +489,7 @@ func (s *ConfigService) DecryptAPIKey(cfg *database.ProviderConfig) (string, err
 		return "", err
 	}
 	log.Printf("[DECRYPT] Decryption successful, key length: %d", len(result))
+	s.cache.PutSecret(cfg, result)
 	return result, nil
 }
 
@@ -326,3 +542,19 @@ func validateProtocol(protocol string) error {
 		return errors.New("unsupported protocol")
 	}
 }
+
+// validateReasoning checks the reasoning effort and summary of a provider config;
+// empty values are valid and leave the setting to the client
+func validateReasoning(effort, summary string) error {
+	switch effort {
+	case "", "minimal", "low", "medium", "high":
+	default:
+		return errors.New("reasoning_effort must be minimal, low, medium or high")
+	}
+	switch summary {
+	case "", "auto", "concise", "detailed":
+	default:
+		return errors.New("reasoning_summary must be auto, concise or detailed")
+	}
+	return nil
+}
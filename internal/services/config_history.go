@@ -0,0 +1,159 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// recordRevision snapshots cfg after a change and notes which tracked fields differ
+// from the previous snapshot
+func (s *ConfigService) recordRevision(tx *gorm.DB, cfg *database.ProviderConfig, userID uint, action string) error {
+	revision := database.ProviderConfigRevision{
+		ProviderConfigID: cfg.ID,
+		ChangedBy:        userID,
+		Action:           action,
+		Name:             cfg.Name,
+		BaseURL:          cfg.BaseURL,
+		Protocol:         cfg.Protocol,
+		Region:           cfg.Region,
+		EncryptedKey:     cfg.EncryptedKey,
+		KeyHint:          cfg.KeyHint,
+		ModelCodes:       cfg.ModelCodes,
+		IsActive:         cfg.IsActive,
+
+		ReasoningEffort:   cfg.ReasoningEffort,
+		ReasoningSummary:  cfg.ReasoningSummary,
+		ReasoningOverride: cfg.ReasoningOverride,
+		MaxOutputTokens:   cfg.MaxOutputTokens,
+
+		MonthlyTokenCap: cfg.MonthlyTokenCap,
+		MonthlyCostCap:  cfg.MonthlyCostCap,
+
+		ActiveSchedule:   cfg.ActiveSchedule,
+		ScheduleTimezone: cfg.ScheduleTimezone,
+	}
+
+	var previous database.ProviderConfigRevision
+	err := tx.Where("provider_config_id = ?", cfg.ID).Order("id DESC").First(&previous).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		revision.ChangedFields = "name,base_url,protocol,region,reasoning,max_output_tokens,spend_caps,schedule,api_key,model_codes,is_active"
+	case err != nil:
+		return err
+	default:
+		revision.ChangedFields = strings.Join(revisionDiff(&previous, &revision), ",")
+	}
+
+	return tx.Create(&revision).Error
+}
+
+// revisionDiff lists the fields that differ between two snapshots
+func revisionDiff(a, b *database.ProviderConfigRevision) []string {
+	var changed []string
+	if a.Name != b.Name {
+		changed = append(changed, "name")
+	}
+	if a.BaseURL != b.BaseURL {
+		changed = append(changed, "base_url")
+	}
+	if a.Protocol != b.Protocol {
+		changed = append(changed, "protocol")
+	}
+	if a.Region != b.Region {
+		changed = append(changed, "region")
+	}
+	if a.ReasoningEffort != b.ReasoningEffort || a.ReasoningSummary != b.ReasoningSummary || a.ReasoningOverride != b.ReasoningOverride {
+		changed = append(changed, "reasoning")
+	}
+	if !sameIntPtr(a.MaxOutputTokens, b.MaxOutputTokens) {
+		changed = append(changed, "max_output_tokens")
+	}
+	if !sameIntPtr(a.MonthlyTokenCap, b.MonthlyTokenCap) || !sameFloatPtr(a.MonthlyCostCap, b.MonthlyCostCap) {
+		changed = append(changed, "spend_caps")
+	}
+	if a.ActiveSchedule != b.ActiveSchedule || a.ScheduleTimezone != b.ScheduleTimezone {
+		changed = append(changed, "schedule")
+	}
+	if a.EncryptedKey != b.EncryptedKey {
+		changed = append(changed, "api_key")
+	}
+	if a.ModelCodes != b.ModelCodes {
+		changed = append(changed, "model_codes")
+	}
+	if a.IsActive != b.IsActive {
+		changed = append(changed, "is_active")
+	}
+	return changed
+}
+
+func sameFloatPtr(a, b *float64) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+// GetConfigHistory returns the revisions of a provider config, newest first
+func (s *ConfigService) GetConfigHistory(userID, configID uint) ([]database.ProviderConfigRevision, error) {
+	if _, err := s.GetConfigByID(userID, configID); err != nil {
+		return nil, err
+	}
+
+	var revisions []database.ProviderConfigRevision
+	err := s.db.Where("provider_config_id = ?", configID).Order("id DESC").Find(&revisions).Error
+	return revisions, err
+}
+
+// RollbackConfig restores a provider config to the state captured in a revision
+func (s *ConfigService) RollbackConfig(userID, configID, revisionID uint) (*database.ProviderConfig, error) {
+	cfg, err := s.GetConfigByID(userID, configID)
+	if err != nil {
+		return nil, err
+	}
+
+	var revision database.ProviderConfigRevision
+	if err := s.db.Where("id = ? AND provider_config_id = ?", revisionID, configID).First(&revision).Error; err != nil {
+		return nil, errors.New("revision not found")
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"name":          revision.Name,
+			"base_url":      revision.BaseURL,
+			"protocol":      revision.Protocol,
+			"region":        revision.Region,
+			"encrypted_key": revision.EncryptedKey,
+			"key_hint":      revision.KeyHint,
+			"model_codes":   revision.ModelCodes,
+			"is_active":     revision.IsActive,
+
+			"reasoning_effort":   revision.ReasoningEffort,
+			"reasoning_summary":  revision.ReasoningSummary,
+			"reasoning_override": revision.ReasoningOverride,
+			"max_output_tokens":  revision.MaxOutputTokens,
+
+			"monthly_token_cap": revision.MonthlyTokenCap,
+			"monthly_cost_cap":  revision.MonthlyCostCap,
+
+			"active_schedule":   revision.ActiveSchedule,
+			"schedule_timezone": revision.ScheduleTimezone,
+		}
+		// The scheduler applies a restored schedule on its next run
+		if revision.ActiveSchedule != cfg.ActiveSchedule || revision.ScheduleTimezone != cfg.ScheduleTimezone {
+			updates["scheduled_active"] = nil
+		}
+		if err := tx.Model(cfg).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := tx.First(cfg, cfg.ID).Error; err != nil {
+			return err
+		}
+		return s.recordRevision(tx, cfg, userID, "rollback")
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.publishChange(userID, configID, "rollback")
+	return cfg, nil
+}
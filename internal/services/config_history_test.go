@@ -0,0 +1,102 @@
+package services
+
+import (
+	"testing"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+)
+
+func TestConfigHistory_TracksAndRollsBackChanges(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	floatPtr := func(v float64) *float64 { return &v }
+	strPtr := func(v string) *string { return &v }
+
+	tests := []struct {
+		name        string
+		update      ProviderConfigUpdate
+		wantChanged string
+		check       func(cfg *database.ProviderConfig) bool // holds after the update, not after the rollback
+	}{
+		{
+			name:        "max output tokens",
+			update:      ProviderConfigUpdate{MaxOutputTokens: intPtr(2048)},
+			wantChanged: "max_output_tokens",
+			check: func(cfg *database.ProviderConfig) bool {
+				return cfg.MaxOutputTokens != nil && *cfg.MaxOutputTokens == 2048
+			},
+		},
+		{
+			name:        "monthly token cap",
+			update:      ProviderConfigUpdate{MonthlyTokenCap: intPtr(1000)},
+			wantChanged: "spend_caps",
+			check: func(cfg *database.ProviderConfig) bool {
+				return cfg.MonthlyTokenCap != nil && *cfg.MonthlyTokenCap == 1000
+			},
+		},
+		{
+			name:        "monthly cost cap",
+			update:      ProviderConfigUpdate{MonthlyCostCap: floatPtr(25)},
+			wantChanged: "spend_caps",
+			check:       func(cfg *database.ProviderConfig) bool { return cfg.MonthlyCostCap != nil && *cfg.MonthlyCostCap == 25 },
+		},
+		{
+			name:        "activation schedule",
+			update:      ProviderConfigUpdate{ActiveSchedule: strPtr("hour >= 9"), ScheduleTimezone: strPtr("Europe/Berlin")},
+			wantChanged: "schedule",
+			check: func(cfg *database.ProviderConfig) bool {
+				return cfg.ActiveSchedule == "hour >= 9" && cfg.ScheduleTimezone == "Europe/Berlin"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDB(t)
+			cfg := testConfig()
+			bus := events.NewBus()
+			svc := NewConfigService(db, cfg, bus, NewCredentialCache(cfg, bus))
+			user := &database.User{Username: "u", Email: "u@example.com", HashedPassword: "x", IsActive: true}
+			if err := db.Create(user).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			created, err := svc.CreateConfig(user.ID, &ProviderConfigCreate{Provider: "openai", Name: "main", APIKey: "sk-test"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			updated, err := svc.UpdateConfig(user.ID, created.ID, &tt.update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(updated) {
+				t.Fatalf("update not applied: %+v", updated)
+			}
+
+			history, err := svc.GetConfigHistory(user.ID, created.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) != 2 {
+				t.Fatalf("got %d revisions, want 2", len(history))
+			}
+			if history[0].ChangedFields != tt.wantChanged {
+				t.Errorf("changed_fields = %q, want %q", history[0].ChangedFields, tt.wantChanged)
+			}
+
+			restored, err := svc.RollbackConfig(user.ID, created.ID, history[1].ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.check(restored) {
+				t.Errorf("rollback did not undo the update: %+v", restored)
+			}
+			history, err = svc.GetConfigHistory(user.ID, created.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if history[0].Action != "rollback" || history[0].ChangedFields != tt.wantChanged {
+				t.Errorf("rollback revision = %s %q, want rollback %q", history[0].Action, history[0].ChangedFields, tt.wantChanged)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// Content filter rule types
+const (
+	FilterTypeRegex      = "regex"
+	FilterTypeKeywords   = "keywords"
+	FilterTypeModeration = "moderation"
+)
+
+// Content filter actions
+const (
+	FilterActionRedact   = "redact"
+	FilterActionBlock    = "block"
+	FilterActionAnnotate = "annotate"
+)
+
+// FilterRedaction replaces output removed by a redact rule
+const FilterRedaction = "[REDACTED]"
+
+const moderationTimeout = 15 * time.Second
+
+// ErrContentFilterRuleNotFound is returned when a user has no such content filter rule
+var ErrContentFilterRuleNotFound = errors.New("content filter rule not found")
+
+// ContentFilterRuleRequest represents the settings of a content filter rule
+type ContentFilterRuleRequest struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Pattern  string `json:"pattern"`
+	Action   string `json:"action"`
+	IsActive *bool  `json:"is_active"`
+}
+
+// FilterAnnotation reports a rule that matched model output
+type FilterAnnotation struct {
+	Rule       string   `json:"rule"`
+	Type       string   `json:"type"`
+	Action     string   `json:"action"`
+	Matches    int      `json:"matches,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+}
+
+// ContentFilterService manages content filter rules and the moderation model
+type ContentFilterService struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	client *http.Client
+}
+
+// NewContentFilterService creates a new ContentFilterService
+func NewContentFilterService(db *gorm.DB, cfg *config.Config) *ContentFilterService {
+	return &ContentFilterService{db: db, cfg: cfg, client: &http.Client{Timeout: moderationTimeout}}
+}
+
+// ListRules returns all content filter rules of a user
+func (s *ContentFilterService) ListRules(userID uint) ([]database.ContentFilterRule, error) {
+	var rules []database.ContentFilterRule
+	err := s.db.Where("user_id = ?", userID).Order("id").Find(&rules).Error
+	return rules, err
+}
+
+// apply validates req and copies it onto rule
+func (s *ContentFilterService) apply(rule *database.ContentFilterRule, req *ContentFilterRuleRequest) error {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 64 {
+		return errors.New("name must be 1-64 characters")
+	}
+	switch req.Action {
+	case FilterActionRedact, FilterActionBlock, FilterActionAnnotate:
+	default:
+		return errors.New("action must be redact, block or annotate")
+	}
+	switch req.Type {
+	case FilterTypeRegex, FilterTypeKeywords:
+		if _, err := compileFilterPattern(req.Type, req.Pattern); err != nil {
+			return err
+		}
+	case FilterTypeModeration:
+		if s.cfg.ModerationURL == "" {
+			return errors.New("no moderation model is configured on this gateway")
+		}
+	default:
+		return errors.New("type must be regex, keywords or moderation")
+	}
+
+	rule.Name = name
+	rule.Type = req.Type
+	rule.Pattern = req.Pattern
+	rule.Action = req.Action
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+	return nil
+}
+
+// CreateRule adds a content filter rule for a user
+func (s *ContentFilterService) CreateRule(userID uint, req *ContentFilterRuleRequest) (*database.ContentFilterRule, error) {
+	rule := &database.ContentFilterRule{UserID: userID, IsActive: true}
+	if err := s.apply(rule, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(rule).Error; err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// UpdateRule changes a user's content filter rule
+func (s *ContentFilterService) UpdateRule(userID, id uint, req *ContentFilterRuleRequest) (*database.ContentFilterRule, error) {
+	var rule database.ContentFilterRule
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&rule).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrContentFilterRuleNotFound
+		}
+		return nil, err
+	}
+	if err := s.apply(&rule, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(&rule).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// DeleteRule removes a user's content filter rule
+func (s *ContentFilterService) DeleteRule(userID, id uint) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&database.ContentFilterRule{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrContentFilterRuleNotFound
+	}
+	return nil
+}
+
+// compileFilterPattern compiles the pattern of a regex or keywords rule.
+// Keywords are matched case-insensitively as whole words.
+func compileFilterPattern(ruleType, pattern string) (*regexp.Regexp, error) {
+	if ruleType == FilterTypeRegex {
+		if strings.TrimSpace(pattern) == "" {
+			return nil, errors.New("pattern is required")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		return re, nil
+	}
+
+	var alternatives []string
+	for _, keyword := range strings.Split(pattern, "\n") {
+		keyword = strings.TrimSpace(keyword)
+		if keyword == "" {
+			continue
+		}
+		alt := regexp.QuoteMeta(keyword)
+		if first, _ := utf8.DecodeRuneInString(keyword); isWordRune(first) {
+			alt = `\b` + alt
+		}
+		if last, _ := utf8.DecodeLastRuneInString(keyword); isWordRune(last) {
+			alt += `\b`
+		}
+		alternatives = append(alternatives, alt)
+	}
+	if len(alternatives) == 0 {
+		return nil, errors.New("pattern must list at least one keyword, one per line")
+	}
+	return regexp.MustCompile(`(?i)(?:` + strings.Join(alternatives, "|") + `)`), nil
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// ContentFilter is the compiled set of a user's active content filter rules
+type ContentFilter struct {
+	svc        *ContentFilterService
+	patterns   []compiledFilterRule
+	moderation []database.ContentFilterRule
+}
+
+type compiledFilterRule struct {
+	rule database.ContentFilterRule
+	re   *regexp.Regexp
+}
+
+// FilterFor returns the active content filter of a user, or nil when the user has no active rules
+func (s *ContentFilterService) FilterFor(userID uint) *ContentFilter {
+	var rules []database.ContentFilterRule
+	if err := s.db.Where("user_id = ? AND is_active = ?", userID, true).Order("id").Find(&rules).Error; err != nil || len(rules) == 0 {
+		return nil
+	}
+
+	f := &ContentFilter{svc: s}
+	for _, rule := range rules {
+		if rule.Type == FilterTypeModeration {
+			if s.cfg.ModerationURL != "" {
+				f.moderation = append(f.moderation, rule)
+			}
+			continue
+		}
+		re, err := compileFilterPattern(rule.Type, rule.Pattern)
+		if err != nil {
+			continue
+		}
+		f.patterns = append(f.patterns, compiledFilterRule{rule: rule, re: re})
+	}
+	if len(f.patterns) == 0 && len(f.moderation) == 0 {
+		return nil
+	}
+	return f
+}
+
+// Apply runs the regex and keyword rules over text. It returns the text with
+// redactions applied, the rules that matched and whether a block rule matched.
+func (f *ContentFilter) Apply(text string) (string, []FilterAnnotation, bool) {
+	var annotations []FilterAnnotation
+	blocked := false
+	for _, p := range f.patterns {
+		matches := len(p.re.FindAllStringIndex(text, -1))
+		if matches == 0 {
+			continue
+		}
+		annotations = append(annotations, FilterAnnotation{Rule: p.rule.Name, Type: p.rule.Type, Action: p.rule.Action, Matches: matches})
+		switch p.rule.Action {
+		case FilterActionRedact:
+			text = p.re.ReplaceAllLiteralString(text, FilterRedaction)
+		case FilterActionBlock:
+			blocked = true
+		}
+	}
+	return text, annotations, blocked
+}
+
+// Moderates reports whether the filter has moderation rules
+func (f *ContentFilter) Moderates() bool {
+	return len(f.moderation) > 0
+}
+
+// Moderate checks text with the moderation model. When the model flags it, a
+// redact rule replaces the whole text and a block rule blocks it.
+func (f *ContentFilter) Moderate(ctx context.Context, text string) (string, []FilterAnnotation, bool, error) {
+	if len(f.moderation) == 0 || strings.TrimSpace(text) == "" {
+		return text, nil, false, nil
+	}
+	categories, err := f.svc.moderate(ctx, text)
+	if err != nil || len(categories) == 0 {
+		return text, nil, false, err
+	}
+
+	var annotations []FilterAnnotation
+	blocked := false
+	for _, rule := range f.moderation {
+		annotations = append(annotations, FilterAnnotation{Rule: rule.Name, Type: rule.Type, Action: rule.Action, Categories: categories})
+		switch rule.Action {
+		case FilterActionRedact:
+			text = FilterRedaction
+		case FilterActionBlock:
+			blocked = true
+		}
+	}
+	return text, annotations, blocked, nil
+}
+
+// moderate returns the categories the moderation model flags text for
+func (s *ContentFilterService) moderate(ctx context.Context, text string) ([]string, error) {
+	body, _ := json.Marshal(map[string]interface{}{"model": s.cfg.ModerationModel, "input": text})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.ModerationURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.ModerationAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.ModerationAPIKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("moderation API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var payload struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("invalid moderation API response: %w", err)
+	}
+
+	var categories []string
+	flagged := false
+	for _, result := range payload.Results {
+		if !result.Flagged {
+			continue
+		}
+		flagged = true
+		for category, hit := range result.Categories {
+			if hit {
+				categories = append(categories, category)
+			}
+		}
+	}
+	if flagged && len(categories) == 0 {
+		categories = append(categories, "flagged")
+	}
+	sort.Strings(categories)
+	return categories, nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"strings"
+
+	"ai_gateway/internal/models"
+)
+
+// compressionPrompt instructs the compression model how to summarize history
+const compressionPrompt = "You compress conversation history for an AI assistant. Summarize the conversation below " +
+	"so that the assistant can continue it without the original messages. Keep facts, decisions, names, numbers, " +
+	"code identifiers, open questions and the user's preferences and instructions. Leave out pleasantries. " +
+	"Answer with the summary only."
+
+// CompressionSummaryPrefix introduces the summary that replaces older turns
+const CompressionSummaryPrefix = "Summary of the earlier conversation:\n"
+
+// HistoryMessage is the text of one message of a conversation considered for compression
+type HistoryMessage struct {
+	Role      string
+	Text      string
+	TurnStart bool // the message opens a user turn, so history may be cut before it
+}
+
+// CompressionCut returns how many leading messages of history to replace with a
+// summary once it exceeds threshold estimated tokens, or 0 when it does not or
+// no earlier turn can be dropped. Recent turns are kept up to about half the
+// threshold, and the newest turn is always kept.
+func CompressionCut(history []HistoryMessage, threshold int) int {
+	tokens := make([]int, len(history))
+	total := 0
+	for i, msg := range history {
+		tokens[i] = EstimateTokens(len(msg.Text))
+		total += tokens[i]
+	}
+	if threshold <= 0 || total <= threshold {
+		return 0
+	}
+
+	// Keep the longest suffix within budget, then move the cut forward to a turn start
+	budget := threshold / 2
+	start := len(history)
+	for kept := 0; start > 0 && kept+tokens[start-1] <= budget; start-- {
+		kept += tokens[start-1]
+	}
+	lastTurn := 0
+	for i, msg := range history {
+		if msg.TurnStart {
+			lastTurn = i
+		}
+	}
+	for start < lastTurn && !history[start].TurnStart {
+		start++
+	}
+	if start > lastTurn {
+		start = lastTurn
+	}
+	return start
+}
+
+// CompressionRequest builds the request asking model to summarize history
+func CompressionRequest(model string, history []HistoryMessage) *models.ChatCompletionRequest {
+	var transcript strings.Builder
+	for _, msg := range history {
+		if msg.Text == "" {
+			continue
+		}
+		transcript.WriteString(msg.Role)
+		transcript.WriteString(": ")
+		transcript.WriteString(msg.Text)
+		transcript.WriteString("\n\n")
+	}
+	return &models.ChatCompletionRequest{
+		Model: model,
+		Messages: []models.ChatMessage{
+			{Role: "system", Content: compressionPrompt},
+			{Role: "user", Content: strings.TrimSpace(transcript.String())},
+		},
+	}
+}
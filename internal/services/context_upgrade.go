@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// ErrContextUpgradePolicyNotFound is returned when a user has no such context upgrade policy
+var ErrContextUpgradePolicyNotFound = errors.New("context upgrade policy not found")
+
+// ContextUpgradePolicyUpdate represents the larger-context variant of a model
+type ContextUpgradePolicyUpdate struct {
+	Model         string `json:"model"`
+	UpgradeModel  string `json:"upgrade_model"`
+	ContextWindow int    `json:"context_window"`
+	IsActive      *bool  `json:"is_active"`
+}
+
+// ContextUpgradeService manages context upgrade policies
+type ContextUpgradeService struct {
+	db *gorm.DB
+}
+
+// NewContextUpgradeService creates a new ContextUpgradeService
+func NewContextUpgradeService(db *gorm.DB) *ContextUpgradeService {
+	return &ContextUpgradeService{db: db}
+}
+
+// GetPolicies returns all context upgrade policies of a user
+func (s *ContextUpgradeService) GetPolicies(userID uint) ([]database.ContextUpgradePolicy, error) {
+	var policies []database.ContextUpgradePolicy
+	err := s.db.Where("user_id = ?", userID).Order("model").Find(&policies).Error
+	return policies, err
+}
+
+// SetPolicy creates or replaces the context upgrade policy of a model. Without
+// a context window the model must be one whose window the gateway knows.
+func (s *ContextUpgradeService) SetPolicy(userID uint, req *ContextUpgradePolicyUpdate) (*database.ContextUpgradePolicy, error) {
+	model := strings.TrimSpace(req.Model)
+	upgrade := strings.TrimSpace(req.UpgradeModel)
+	if model == "" || upgrade == "" {
+		return nil, errors.New("model and upgrade_model are required")
+	}
+	if model == upgrade {
+		return nil, errors.New("upgrade_model must differ from model")
+	}
+	if req.ContextWindow < 0 {
+		return nil, errors.New("context_window must not be negative")
+	}
+	if spec, _ := LookupModelSpec(model); req.ContextWindow == 0 && spec.ContextWindow == 0 {
+		return nil, errors.New("context_window is required for models of unknown context window")
+	}
+
+	var policy database.ContextUpgradePolicy
+	err := s.db.Where("user_id = ? AND model = ?", userID, model).First(&policy).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	policy.UserID = userID
+	policy.Model = model
+	policy.UpgradeModel = upgrade
+	policy.ContextWindow = req.ContextWindow
+	policy.IsActive = true
+	if req.IsActive != nil {
+		policy.IsActive = *req.IsActive
+	}
+	if err := s.db.Save(&policy).Error; err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// DeletePolicy removes a context upgrade policy
+func (s *ContextUpgradeService) DeletePolicy(userID, id uint) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&database.ContextUpgradePolicy{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrContextUpgradePolicyNotFound
+	}
+	return nil
+}
+
+// UpgradeFor returns the active context upgrade policy of a user's model when
+// a prompt of promptTokens exceeds the window it sets, with that window, or
+// nil when the request fits
+func (s *ContextUpgradeService) UpgradeFor(userID uint, model string, promptTokens int) (*database.ContextUpgradePolicy, int) {
+	if promptTokens <= 0 {
+		return nil, 0
+	}
+	var policy database.ContextUpgradePolicy
+	err := s.db.Where("user_id = ? AND model = ? AND is_active = ?", userID, model, true).First(&policy).Error
+	if err != nil {
+		return nil, 0
+	}
+	window := policy.ContextWindow
+	if window == 0 {
+		spec, _ := LookupModelSpec(model)
+		window = spec.ContextWindow
+	}
+	if window == 0 || promptTokens <= window {
+		return nil, 0
+	}
+	return &policy, window
+}
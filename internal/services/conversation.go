@@ -0,0 +1,141 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrConversationNotFound is returned when a conversation does not exist for the caller
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// ConversationService stores chat histories server-side so clients can send only new turns
+type ConversationService struct {
+	db *gorm.DB
+}
+
+// NewConversationService creates a new ConversationService
+func NewConversationService(db *gorm.DB) *ConversationService {
+	return &ConversationService{db: db}
+}
+
+// ConversationCreate represents a request to create a conversation
+type ConversationCreate struct {
+	Title    string               `json:"title"`
+	Model    string               `json:"model"`
+	Messages []models.ChatMessage `json:"messages"` // optional seed turns, e.g. a system prompt
+}
+
+// generateConversationID returns a new public conversation ID
+func generateConversationID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "conv_" + hex.EncodeToString(b), nil
+}
+
+// CreateConversation creates a conversation owned by scope
+func (s *ConversationService) CreateConversation(scope string, req *ConversationCreate) (*database.Conversation, error) {
+	publicID, err := generateConversationID()
+	if err != nil {
+		return nil, err
+	}
+
+	conv := &database.Conversation{
+		PublicID: publicID,
+		Scope:    scope,
+		Title:    req.Title,
+		Model:    req.Model,
+	}
+	if err := s.db.Create(conv).Error; err != nil {
+		return nil, err
+	}
+	if len(req.Messages) > 0 {
+		if err := s.AppendMessages(conv, req.Messages); err != nil {
+			return nil, err
+		}
+	}
+	return conv, nil
+}
+
+// GetConversations returns all conversations owned by scope, newest first
+func (s *ConversationService) GetConversations(scope string) ([]database.Conversation, error) {
+	var convs []database.Conversation
+	err := s.db.Where("scope = ?", scope).Order("updated_at DESC").Find(&convs).Error
+	return convs, err
+}
+
+// GetConversation returns a conversation with its messages
+func (s *ConversationService) GetConversation(scope, publicID string) (*database.Conversation, error) {
+	var conv database.Conversation
+	err := s.db.Where("public_id = ? AND scope = ?", publicID, scope).
+		Preload("Messages", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		First(&conv).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conv, nil
+}
+
+// DeleteConversation deletes a conversation and its messages
+func (s *ConversationService) DeleteConversation(scope, publicID string) error {
+	conv, err := s.GetConversation(scope, publicID)
+	if err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("conversation_id = ?", conv.ID).Delete(&database.ConversationMessage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(conv).Error
+	})
+}
+
+// History decodes the stored turns of a conversation in order
+func (s *ConversationService) History(conv *database.Conversation) ([]models.ChatMessage, error) {
+	history := make([]models.ChatMessage, 0, len(conv.Messages))
+	for _, stored := range conv.Messages {
+		var msg models.ChatMessage
+		if err := json.Unmarshal([]byte(stored.Message), &msg); err != nil {
+			return nil, err
+		}
+		history = append(history, msg)
+	}
+	return history, nil
+}
+
+// AppendMessages stores new turns at the end of a conversation
+func (s *ConversationService) AppendMessages(conv *database.Conversation, messages []models.ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	records := make([]database.ConversationMessage, 0, len(messages))
+	for _, msg := range messages {
+		encoded, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		records = append(records, database.ConversationMessage{
+			ConversationID: conv.ID,
+			Role:           msg.Role,
+			Message:        string(encoded),
+		})
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&records).Error; err != nil {
+			return err
+		}
+		return tx.Model(conv).Update("updated_at", time.Now()).Error
+	})
+}
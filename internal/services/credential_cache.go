@@ -0,0 +1,271 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+)
+
+// Bounds of the negative cache of key hashes that matched no API key
+const (
+	invalidKeyTTL     = time.Minute
+	invalidKeyEntries = 10000
+)
+
+// CredentialCache keeps API key records, with their user and provider configs,
+// and decrypted provider credentials in memory for a short TTL, so that gateway
+// requests skip the database lookup and decryption. Entries are dropped when a
+// key.changed or config.changed event reports a change; the TTL bounds how
+// stale they get through changes made without one. Hashes of unknown keys are
+// remembered too, so that clients scanning for keys don't reach the database
+// on every retry. A nil *CredentialCache caches nothing.
+type CredentialCache struct {
+	ttl time.Duration
+
+	mu         sync.Mutex
+	generation uint64                // bumped by every invalidation
+	keys       map[uint]*cachedKey   // by API key ID
+	hashes     map[string]uint       // key hash -> API key ID
+	secrets    map[uint]cachedSecret // by provider config ID
+	invalid    map[string]time.Time  // hashes of unknown keys -> expiry
+	sweptAt    time.Time
+}
+
+type cachedKey struct {
+	key     database.APIKey
+	expires time.Time
+}
+
+type cachedSecret struct {
+	encrypted string // the ciphertext the secret was decrypted from
+	plain     string
+	expires   time.Time
+}
+
+// NewCredentialCache creates a CredentialCache invalidated by the events on bus,
+// or returns nil when caching is disabled
+func NewCredentialCache(cfg *config.Config, bus *events.Bus) *CredentialCache {
+	if cfg.CredentialCacheTTL <= 0 {
+		return nil
+	}
+	c := &CredentialCache{
+		ttl:     time.Duration(cfg.CredentialCacheTTL) * time.Second,
+		keys:    make(map[uint]*cachedKey),
+		hashes:  make(map[string]uint),
+		secrets: make(map[uint]cachedSecret),
+		invalid: make(map[string]time.Time),
+	}
+	bus.Handle(c.invalidate, events.KeyChanged, events.ConfigChanged)
+	return c
+}
+
+// Generation returns a token to take before loading a key from the database
+// and hand to PutKey, so that a record loaded before an invalidation is not
+// cached after it
+func (c *CredentialCache) Generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// KeyByHash returns a copy of the cached API key with the hash, if any
+func (c *CredentialCache) KeyByHash(keyHash string) (*database.APIKey, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.hashes[keyHash]
+	if !ok {
+		return nil, false
+	}
+	return c.keyLocked(id)
+}
+
+// KeyByID returns a copy of the cached API key with the ID, if any
+func (c *CredentialCache) KeyByID(id uint) (*database.APIKey, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.keyLocked(id)
+}
+
+func (c *CredentialCache) keyLocked(id uint) (*database.APIKey, bool) {
+	entry, ok := c.keys[id]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		c.dropKeyLocked(id)
+		return nil, false
+	}
+	return copyAPIKey(&entry.key), true
+}
+
+// PutKey caches an API key loaded with its user and provider configs, unless
+// the cache was invalidated since generation
+func (c *CredentialCache) PutKey(key *database.APIKey, generation uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	now := time.Now()
+	c.sweepLocked(now)
+	c.keys[key.ID] = &cachedKey{key: *copyAPIKey(key), expires: now.Add(c.ttl)}
+	c.hashes[key.KeyHash] = key.ID
+}
+
+// KnownInvalid reports whether a key hash recently matched no API key
+func (c *CredentialCache) KnownInvalid(keyHash string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires, ok := c.invalid[keyHash]
+	return ok && time.Now().Before(expires)
+}
+
+// PutInvalid remembers that a key hash matched no API key. When the negative
+// cache is full of live entries it starts over rather than growing.
+func (c *CredentialCache) PutInvalid(keyHash string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.invalid) >= invalidKeyEntries {
+		for hash, expires := range c.invalid {
+			if now.After(expires) {
+				delete(c.invalid, hash)
+			}
+		}
+		if len(c.invalid) >= invalidKeyEntries {
+			c.invalid = make(map[string]time.Time)
+		}
+	}
+	c.invalid[keyHash] = now.Add(invalidKeyTTL)
+}
+
+// Secret returns the cached decrypted API key of a provider config, if it was
+// decrypted from the config's current ciphertext
+func (c *CredentialCache) Secret(cfg *database.ProviderConfig) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.secrets[cfg.ID]
+	if !ok || entry.encrypted != cfg.EncryptedKey || time.Now().After(entry.expires) {
+		return "", false
+	}
+	return entry.plain, true
+}
+
+// PutSecret caches the decrypted API key of a provider config
+func (c *CredentialCache) PutSecret(cfg *database.ProviderConfig, plain string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.sweepLocked(now)
+	c.secrets[cfg.ID] = cachedSecret{encrypted: cfg.EncryptedKey, plain: plain, expires: now.Add(c.ttl)}
+}
+
+// AddUsage applies a recorded request to the usage counters of the cached key
+// and of the provider config that served it, as RecordUsage does in the
+// database, so that limits and spend caps are checked against current counts
+func (c *CredentialCache) AddUsage(keyID uint, configID *uint, tokens int, costMicros int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.keys[keyID]; ok {
+		entry.key.DailyRequestsUsed++
+		entry.key.MonthlyRequestsUsed++
+		entry.key.DailyTokensUsed += tokens
+		entry.key.MonthlyTokensUsed += tokens
+	}
+	if configID == nil {
+		return
+	}
+	for _, entry := range c.keys {
+		for i := range entry.key.ProviderConfigs {
+			if cfg := &entry.key.ProviderConfigs[i]; cfg.ID == *configID {
+				cfg.MonthlyTokensUsed += tokens
+				cfg.MonthlyCostMicros += costMicros
+			}
+		}
+	}
+}
+
+// invalidate drops the entries an event reports a change to. A provider
+// config change also drops the keys holding a copy of the config.
+func (c *CredentialCache) invalidate(event events.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	switch change := event.Data.(type) {
+	case events.KeyChange:
+		c.dropKeyLocked(change.APIKeyID)
+	case events.ConfigChange:
+		delete(c.secrets, change.ProviderConfigID)
+		for id, entry := range c.keys {
+			for _, cfg := range entry.key.ProviderConfigs {
+				if cfg.ID == change.ProviderConfigID {
+					c.dropKeyLocked(id)
+					break
+				}
+			}
+		}
+	}
+}
+
+func (c *CredentialCache) dropKeyLocked(id uint) {
+	if entry, ok := c.keys[id]; ok {
+		delete(c.hashes, entry.key.KeyHash)
+		delete(c.keys, id)
+	}
+}
+
+// sweepLocked drops expired entries, at most once per TTL
+func (c *CredentialCache) sweepLocked(now time.Time) {
+	if now.Sub(c.sweptAt) < c.ttl {
+		return
+	}
+	c.sweptAt = now
+	for id, entry := range c.keys {
+		if now.After(entry.expires) {
+			c.dropKeyLocked(id)
+		}
+	}
+	for id, entry := range c.secrets {
+		if now.After(entry.expires) {
+			delete(c.secrets, id)
+		}
+	}
+}
+
+// copyAPIKey copies a key and its provider configs, so that callers may modify
+// their copy
+func copyAPIKey(key *database.APIKey) *database.APIKey {
+	dup := *key
+	dup.ProviderConfigs = append([]database.ProviderConfig(nil), key.ProviderConfigs...)
+	return &dup
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MicrosPerCredit is the number of ledger units in one credit
+const MicrosPerCredit = 1000000
+
+// Types of credit ledger entries
+const (
+	CreditEntryGrant      = "grant"      // credits added by an admin
+	CreditEntryAdjustment = "adjustment" // credits removed by an admin
+	CreditEntryCharge     = "charge"     // cost of a request
+)
+
+var (
+	// ErrInsufficientCredits is returned when a metered user has no credits left
+	ErrInsufficientCredits = errors.New("insufficient credits")
+
+	// ErrModelPriceNotFound is returned when there is no such model price
+	ErrModelPriceNotFound = errors.New("model price not found")
+)
+
+// CreditBalance is the credit state of a user
+type CreditBalance struct {
+	UserID        uint    `json:"user_id"`
+	Metered       bool    `json:"metered"` // false until credits are first granted
+	Balance       float64 `json:"balance"`
+	BalanceMicros int64   `json:"balance_micros"`
+}
+
+// CreditGrantRequest represents credits granted to (or, when negative, removed from) a user
+type CreditGrantRequest struct {
+	Amount      float64 `json:"amount"` // credits
+	Description string  `json:"description"`
+}
+
+// ModelPriceRequest represents the credit price of a model
+type ModelPriceRequest struct {
+	Model            string  `json:"model"`
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+}
+
+// CreditService manages prepaid credits and the model prices requests are charged at
+type CreditService struct {
+	db *gorm.DB
+}
+
+// NewCreditService creates a new CreditService
+func NewCreditService(db *gorm.DB) *CreditService {
+	return &CreditService{db: db}
+}
+
+// Balance returns the credit balance of a user
+func (s *CreditService) Balance(userID uint) (*CreditBalance, error) {
+	balance := &CreditBalance{UserID: userID}
+	var account database.CreditAccount
+	err := s.db.Where("user_id = ?", userID).First(&account).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return balance, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	balance.Metered = true
+	balance.BalanceMicros = account.BalanceMicros
+	balance.Balance = float64(account.BalanceMicros) / MicrosPerCredit
+	return balance, nil
+}
+
+// CheckCredits returns ErrInsufficientCredits when a metered user's balance is not positive
+func (s *CreditService) CheckCredits(userID uint) error {
+	balance, err := s.Balance(userID)
+	if err != nil {
+		return err
+	}
+	if balance.Metered && balance.BalanceMicros <= 0 {
+		return ErrInsufficientCredits
+	}
+	return nil
+}
+
+// Ledger returns the most recent credit ledger entries of a user
+func (s *CreditService) Ledger(userID uint, limit int) ([]database.CreditLedgerEntry, error) {
+	var entries []database.CreditLedgerEntry
+	err := s.db.Where("user_id = ?", userID).Order("id DESC").Limit(limit).Find(&entries).Error
+	return entries, err
+}
+
+// Grant adds credits to a user's balance, starting to meter the user on the first grant.
+// A negative amount removes credits.
+func (s *CreditService) Grant(userID, grantedBy uint, req *CreditGrantRequest) (*database.CreditLedgerEntry, error) {
+	amount := int64(math.Round(req.Amount * MicrosPerCredit))
+	if amount == 0 {
+		return nil, errors.New("amount must not be zero")
+	}
+	var count int64
+	if err := s.db.Model(&database.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, errors.New("user not found")
+	}
+
+	entry := &database.CreditLedgerEntry{
+		UserID:       userID,
+		Type:         CreditEntryGrant,
+		AmountMicros: amount,
+		Description:  strings.TrimSpace(req.Description),
+		GrantedBy:    &grantedBy,
+	}
+	if amount < 0 {
+		entry.Type = CreditEntryAdjustment
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		balance, err := addCredits(tx, userID, amount, true)
+		if err != nil {
+			return err
+		}
+		entry.BalanceAfterMicros = balance
+		return tx.Create(entry).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// addCredits changes a user's balance and returns the new balance. With create,
+// the credit account is opened if the user has none.
+func addCredits(tx *gorm.DB, userID uint, amount int64, create bool) (int64, error) {
+	if create {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&database.CreditAccount{UserID: userID}).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	result := tx.Model(&database.CreditAccount{}).Where("user_id = ?", userID).
+		Update("balance_micros", gorm.Expr("balance_micros + ?", amount))
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, gorm.ErrRecordNotFound
+	}
+	var account database.CreditAccount
+	if err := tx.Where("user_id = ?", userID).First(&account).Error; err != nil {
+		return 0, err
+	}
+	return account.BalanceMicros, nil
+}
+
+// chargeCredits deducts the cost of a recorded request from the balance of the
+// key owner, if the owner is metered. The request was already served, so the
+// balance may drop below zero; the next request is then refused.
+func chargeCredits(db *gorm.DB, record *database.UsageRecord) error {
+	var key database.APIKey
+	if err := db.Select("id", "user_id").First(&key, record.APIKeyID).Error; err != nil {
+		return err
+	}
+	var metered int64
+	if err := db.Model(&database.CreditAccount{}).Where("user_id = ?", key.UserID).Count(&metered).Error; err != nil {
+		return err
+	}
+	if metered == 0 {
+		return nil
+	}
+
+	var prices []database.ModelPrice
+	if err := db.Find(&prices).Error; err != nil {
+		return err
+	}
+	cost := requestCost(prices, record.Model, record.PromptTokens, record.CompletionTokens)
+	if cost == 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		balance, err := addCredits(tx, key.UserID, -cost, false)
+		if err != nil {
+			return err
+		}
+		return tx.Create(&database.CreditLedgerEntry{
+			UserID:             key.UserID,
+			Type:               CreditEntryCharge,
+			AmountMicros:       -cost,
+			BalanceAfterMicros: balance,
+			Description:        fmt.Sprintf("%s %s: %d prompt + %d completion tokens", record.Endpoint, record.Model, record.PromptTokens, record.CompletionTokens),
+			UsageRecordID:      &record.ID,
+		}).Error
+	})
+}
+
+// matchModelPrice returns the price of a model: an exact match, else the
+// longest matching prefix pattern, else the "*" default. Unpriced models are free.
+func matchModelPrice(prices []database.ModelPrice, model string) *database.ModelPrice {
+	var best *database.ModelPrice
+	bestLen := -1
+	for i := range prices {
+		pattern := prices[i].Model
+		if pattern == model {
+			return &prices[i]
+		}
+		prefix, ok := strings.CutSuffix(pattern, "*")
+		if ok && strings.HasPrefix(model, prefix) && len(prefix) > bestLen {
+			best = &prices[i]
+			bestLen = len(prefix)
+		}
+	}
+	return best
+}
+
+// requestCost returns the cost of a request in millionths of a credit. A price
+// per million tokens makes the cost in micros one token times the price.
+func requestCost(prices []database.ModelPrice, model string, promptTokens, completionTokens int) int64 {
+	price := matchModelPrice(prices, model)
+	if price == nil {
+		return 0
+	}
+	return int64(math.Ceil(float64(promptTokens)*price.InputPerMillion + float64(completionTokens)*price.OutputPerMillion))
+}
+
+// PriceOf returns the price charged for a model's tokens, or nil when it is free
+func (s *CreditService) PriceOf(model string) (*database.ModelPrice, error) {
+	var prices []database.ModelPrice
+	if err := s.db.Find(&prices).Error; err != nil {
+		return nil, err
+	}
+	return matchModelPrice(prices, model), nil
+}
+
+// ListModelPrices returns all model prices
+func (s *CreditService) ListModelPrices() ([]database.ModelPrice, error) {
+	var prices []database.ModelPrice
+	err := s.db.Order("model").Find(&prices).Error
+	return prices, err
+}
+
+// SetModelPrice creates or replaces the price of a model pattern
+func (s *CreditService) SetModelPrice(req *ModelPriceRequest) (*database.ModelPrice, error) {
+	model := strings.TrimSpace(req.Model)
+	if model == "" || len(model) > 100 {
+		return nil, errors.New("model must be 1-100 characters")
+	}
+	if strings.Contains(strings.TrimSuffix(model, "*"), "*") {
+		return nil, errors.New("model may only contain \"*\" at the end")
+	}
+	if req.InputPerMillion < 0 || req.OutputPerMillion < 0 {
+		return nil, errors.New("prices must not be negative")
+	}
+
+	price := &database.ModelPrice{Model: model}
+	if err := s.db.Where("model = ?", model).FirstOrInit(price).Error; err != nil {
+		return nil, err
+	}
+	price.InputPerMillion = req.InputPerMillion
+	price.OutputPerMillion = req.OutputPerMillion
+	if err := s.db.Save(price).Error; err != nil {
+		return nil, err
+	}
+	return price, nil
+}
+
+// DeleteModelPrice removes a model price
+func (s *CreditService) DeleteModelPrice(id uint) error {
+	result := s.db.Delete(&database.ModelPrice{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrModelPriceNotFound
+	}
+	return nil
+}
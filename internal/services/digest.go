@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+const (
+	digestInterval      = 7 * 24 * time.Hour
+	digestCheckInterval = time.Hour
+	digestTopModels     = 5
+)
+
+// DigestService builds and emails weekly usage digests to opted-in users
+type DigestService struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	mailer Mailer
+}
+
+// NewDigestService creates a new DigestService
+func NewDigestService(db *gorm.DB, cfg *config.Config, mailer Mailer) *DigestService {
+	return &DigestService{db: db, cfg: cfg, mailer: mailer}
+}
+
+// ModelUsage summarizes usage of one model
+type ModelUsage struct {
+	Model       string `json:"model"`
+	Requests    int    `json:"requests"`
+	TotalTokens int    `json:"total_tokens"`
+}
+
+// KeyUsage summarizes usage of one API key
+type KeyUsage struct {
+	APIKeyID    uint    `json:"api_key_id"`
+	Name        string  `json:"name"`
+	Requests    int     `json:"requests"`
+	TotalTokens int     `json:"total_tokens"`
+	Errors      int     `json:"errors"`
+	ErrorRate   float64 `json:"error_rate"`
+}
+
+// UsageDigest is a usage summary across all of a user's keys for a period
+type UsageDigest struct {
+	Since            time.Time    `json:"since"`
+	Until            time.Time    `json:"until"`
+	Requests         int          `json:"requests"`
+	PromptTokens     int          `json:"prompt_tokens"`
+	CompletionTokens int          `json:"completion_tokens"`
+	TotalTokens      int          `json:"total_tokens"`
+	Errors           int          `json:"errors"`
+	ErrorRate        float64      `json:"error_rate"`
+	TopModels        []ModelUsage `json:"top_models"`
+	Keys             []KeyUsage   `json:"keys"`
+}
+
+// generateUnsubscribeToken returns a random token for one-click unsubscribe links
+func generateUnsubscribeToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GetPreferences returns the user's notification preferences, creating defaults if missing
+func (s *DigestService) GetPreferences(userID uint) (*database.NotificationPreference, error) {
+	var pref database.NotificationPreference
+	err := s.db.Where("user_id = ?", userID).First(&pref).Error
+	if err == nil {
+		return &pref, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	token, err := generateUnsubscribeToken()
+	if err != nil {
+		return nil, err
+	}
+	pref = database.NotificationPreference{UserID: userID, UnsubscribeToken: token}
+	if err := s.db.Create(&pref).Error; err != nil {
+		return nil, err
+	}
+	return &pref, nil
+}
+
+// UpdatePreferences sets the user's weekly digest opt-in
+func (s *DigestService) UpdatePreferences(userID uint, weeklyDigest bool) (*database.NotificationPreference, error) {
+	pref, err := s.GetPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(pref).Update("weekly_digest", weeklyDigest).Error; err != nil {
+		return nil, err
+	}
+	return pref, nil
+}
+
+// Unsubscribe disables all digests for the holder of token
+func (s *DigestService) Unsubscribe(token string) error {
+	if token == "" {
+		return errors.New("invalid unsubscribe token")
+	}
+	result := s.db.Model(&database.NotificationPreference{}).
+		Where("unsubscribe_token = ?", token).
+		Update("weekly_digest", false)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("invalid unsubscribe token")
+	}
+	return nil
+}
+
+// BuildDigest summarizes usage across all of a user's keys between since and until
+func (s *DigestService) BuildDigest(userID uint, since, until time.Time) (*UsageDigest, error) {
+	digest := &UsageDigest{Since: since, Until: until}
+
+	base := func() *gorm.DB {
+		return s.db.Table("usage_records").
+			Joins("JOIN api_keys ON api_keys.id = usage_records.api_key_id").
+			Where("api_keys.user_id = ? AND usage_records.created_at >= ? AND usage_records.created_at < ?", userID, since, until)
+	}
+
+	var keyRows []struct {
+		APIKeyID    uint
+		Name        string
+		Requests    int
+		Prompt      int
+		Completion  int
+		TotalTokens int
+		Errors      int
+	}
+	err := base().
+		Select("usage_records.api_key_id AS api_key_id, api_keys.name AS name, COUNT(*) AS requests, " +
+			"COALESCE(SUM(usage_records.prompt_tokens), 0) AS prompt, " +
+			"COALESCE(SUM(usage_records.completion_tokens), 0) AS completion, " +
+			"COALESCE(SUM(usage_records.total_tokens), 0) AS total_tokens, " +
+			"SUM(CASE WHEN usage_records.status_code >= 400 THEN 1 ELSE 0 END) AS errors").
+		Group("usage_records.api_key_id, api_keys.name").
+		Order("requests DESC").
+		Scan(&keyRows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range keyRows {
+		digest.Requests += row.Requests
+		digest.PromptTokens += row.Prompt
+		digest.CompletionTokens += row.Completion
+		digest.TotalTokens += row.TotalTokens
+		digest.Errors += row.Errors
+		digest.Keys = append(digest.Keys, KeyUsage{
+			APIKeyID:    row.APIKeyID,
+			Name:        row.Name,
+			Requests:    row.Requests,
+			TotalTokens: row.TotalTokens,
+			Errors:      row.Errors,
+			ErrorRate:   ratio(row.Errors, row.Requests),
+		})
+	}
+	digest.ErrorRate = ratio(digest.Errors, digest.Requests)
+
+	err = base().
+		Select("usage_records.model AS model, COUNT(*) AS requests, COALESCE(SUM(usage_records.total_tokens), 0) AS total_tokens").
+		Group("usage_records.model").
+		Order("total_tokens DESC").
+		Limit(digestTopModels).
+		Scan(&digest.TopModels).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return digest, nil
+}
+
+// ratio returns part/total, or 0 when total is 0
+func ratio(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
+
+// formatDigest renders a digest as a plain-text email body
+func (s *DigestService) formatDigest(user *database.User, digest *UsageDigest, unsubscribeToken string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\n", user.Username)
+	fmt.Fprintf(&b, "Here is your AI Gateway usage from %s to %s.\n\n", digest.Since.Format("2006-01-02"), digest.Until.Format("2006-01-02"))
+	fmt.Fprintf(&b, "Requests:          %d\n", digest.Requests)
+	fmt.Fprintf(&b, "Prompt tokens:     %d\n", digest.PromptTokens)
+	fmt.Fprintf(&b, "Completion tokens: %d\n", digest.CompletionTokens)
+	fmt.Fprintf(&b, "Total tokens:      %d\n", digest.TotalTokens)
+	fmt.Fprintf(&b, "Error rate:        %.1f%% (%d errors)\n", digest.ErrorRate*100, digest.Errors)
+
+	if len(digest.TopModels) > 0 {
+		b.WriteString("\nTop models:\n")
+		for _, m := range digest.TopModels {
+			fmt.Fprintf(&b, "  %-32s %8d requests %12d tokens\n", m.Model, m.Requests, m.TotalTokens)
+		}
+	}
+	if len(digest.Keys) > 0 {
+		b.WriteString("\nBy API key:\n")
+		for _, k := range digest.Keys {
+			fmt.Fprintf(&b, "  %-32s %8d requests %12d tokens  %.1f%% errors\n", k.Name, k.Requests, k.TotalTokens, k.ErrorRate*100)
+		}
+	}
+
+	fmt.Fprintf(&b, "\nTo stop receiving these emails, visit %s/api/notifications/unsubscribe?token=%s\n",
+		strings.TrimRight(s.cfg.PublicBaseURL, "/"), unsubscribeToken)
+	return b.String()
+}
+
+// SendDueDigests emails every opted-in user whose last digest is at least a week old
+func (s *DigestService) SendDueDigests(now time.Time) (int, error) {
+	var prefs []database.NotificationPreference
+	err := s.db.Preload("User").
+		Where("weekly_digest = ? AND (last_digest_at IS NULL OR last_digest_at <= ?)", true, now.Add(-digestInterval)).
+		Find(&prefs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for i := range prefs {
+		pref := &prefs[i]
+		if !pref.User.IsActive || pref.User.Email == "" {
+			continue
+		}
+
+		digest, err := s.BuildDigest(pref.UserID, now.Add(-digestInterval), now)
+		if err != nil {
+			log.Printf("[Digest] Failed to build digest for user=%d: %v", pref.UserID, err)
+			continue
+		}
+
+		body := s.formatDigest(&pref.User, digest, pref.UnsubscribeToken)
+		if err := s.mailer.Send(pref.User.Email, "Your weekly AI Gateway usage", body); err != nil {
+			log.Printf("[Digest] Failed to send digest to user=%d: %v", pref.UserID, err)
+			continue
+		}
+
+		s.db.Model(pref).Update("last_digest_at", now)
+		sent++
+	}
+	return sent, nil
+}
+
+// Run sends due digests periodically until ctx is cancelled
+func (s *DigestService) Run(ctx context.Context) {
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if sent, err := s.SendDueDigests(time.Now()); err != nil {
+			log.Printf("[Digest] Failed to send digests: %v", err)
+		} else if sent > 0 {
+			log.Printf("[Digest] Sent %d weekly digests", sent)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
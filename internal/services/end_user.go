@@ -0,0 +1,218 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxEndUserIDLength bounds the end-user identifiers clients may send
+const maxEndUserIDLength = 255
+
+var (
+	// ErrEndUserNotFound is returned when an API key has no such end user
+	ErrEndUserNotFound = errors.New("end user not found")
+
+	// ErrEndUserBlocked is returned for requests made for a blocked end user
+	ErrEndUserBlocked = errors.New("end user is blocked")
+)
+
+// EndUserUpdate represents the quota settings of an end user. Nil limits are unlimited.
+type EndUserUpdate struct {
+	IsBlocked           bool `json:"is_blocked"`
+	DailyRequestLimit   *int `json:"daily_request_limit"`
+	MonthlyRequestLimit *int `json:"monthly_request_limit"`
+	DailyTokenLimit     *int `json:"daily_token_limit"`
+	MonthlyTokenLimit   *int `json:"monthly_token_limit"`
+}
+
+// EndUserService tracks the end users of API keys and enforces their quotas
+type EndUserService struct {
+	db  *gorm.DB
+	loc *time.Location // timezone of usage resets
+}
+
+// NewEndUserService creates a new EndUserService
+func NewEndUserService(db *gorm.DB, cfg *config.Config) *EndUserService {
+	return &EndUserService{db: db, loc: cfg.UsageResetLocation()}
+}
+
+// validateEndUserID checks an end-user identifier sent by a client
+func validateEndUserID(externalID string) error {
+	if externalID == "" || len(externalID) > maxEndUserIDLength {
+		return errors.New("end user ID must be 1-255 characters")
+	}
+	return nil
+}
+
+// Resolve returns the end user of an API key, creating it on first sight, and
+// notes that it was just seen
+func (s *EndUserService) Resolve(keyID uint, externalID string) (*database.EndUser, error) {
+	externalID = strings.TrimSpace(externalID)
+	if err := validateEndUserID(externalID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	endUser := &database.EndUser{
+		APIKeyID:       keyID,
+		ExternalID:     externalID,
+		DailyResetAt:   nextDailyReset(now, s.loc),
+		MonthlyResetAt: nextMonthlyReset(now, s.loc),
+		LastSeenAt:     now,
+	}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "api_key_id"}, {Name: "external_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"last_seen_at": now}),
+	}).Create(endUser).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("api_key_id = ? AND external_id = ?", keyID, externalID).First(endUser).Error; err != nil {
+		return nil, err
+	}
+	return endUser, nil
+}
+
+// CheckLimits checks if an end user is blocked or has exceeded its quotas
+func (s *EndUserService) CheckLimits(endUser *database.EndUser) error {
+	if endUser.IsBlocked {
+		return ErrEndUserBlocked
+	}
+
+	now := time.Now()
+
+	// Reset daily counters if needed
+	if endUser.DailyResetAt.Before(now) {
+		s.db.Model(endUser).Updates(map[string]interface{}{
+			"daily_requests_used": 0,
+			"daily_tokens_used":   0,
+			"daily_reset_at":      nextDailyReset(now, s.loc),
+		})
+		endUser.DailyRequestsUsed = 0
+		endUser.DailyTokensUsed = 0
+	}
+
+	// Reset monthly counters if needed
+	if endUser.MonthlyResetAt.Before(now) {
+		s.db.Model(endUser).Updates(map[string]interface{}{
+			"monthly_requests_used": 0,
+			"monthly_tokens_used":   0,
+			"monthly_reset_at":      nextMonthlyReset(now, s.loc),
+		})
+		endUser.MonthlyRequestsUsed = 0
+		endUser.MonthlyTokensUsed = 0
+	}
+
+	if endUser.DailyRequestLimit != nil && endUser.DailyRequestsUsed >= *endUser.DailyRequestLimit {
+		return errors.New("end user daily request limit exceeded")
+	}
+	if endUser.MonthlyRequestLimit != nil && endUser.MonthlyRequestsUsed >= *endUser.MonthlyRequestLimit {
+		return errors.New("end user monthly request limit exceeded")
+	}
+	if endUser.DailyTokenLimit != nil && endUser.DailyTokensUsed >= *endUser.DailyTokenLimit {
+		return errors.New("end user daily token limit exceeded")
+	}
+	if endUser.MonthlyTokenLimit != nil && endUser.MonthlyTokensUsed >= *endUser.MonthlyTokenLimit {
+		return errors.New("end user monthly token limit exceeded")
+	}
+	return nil
+}
+
+// ownsKey reports whether an API key belongs to a user
+func (s *EndUserService) ownsKey(userID, keyID uint) error {
+	var count int64
+	if err := s.db.Model(&database.APIKey{}).Where("id = ? AND user_id = ?", keyID, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// ListEndUsers returns a page of the end users of a user's API key, most
+// recently seen first, and how many match. Search matches the end-user ID;
+// Status is active or blocked.
+func (s *EndUserService) ListEndUsers(userID, keyID uint, q *ListQuery) ([]database.EndUser, int64, error) {
+	if err := s.ownsKey(userID, keyID); err != nil {
+		return nil, 0, err
+	}
+	query := q.filter(s.db.Model(&database.EndUser{}).Where("api_key_id = ?", keyID), "external_id")
+	switch q.Status {
+	case "":
+	case "active":
+		query = query.Where("is_blocked = ?", false)
+	case "blocked":
+		query = query.Where("is_blocked = ?", true)
+	default:
+		return nil, 0, ErrInvalidListStatus
+	}
+
+	var endUsers []database.EndUser
+	total, err := q.find(query, "last_seen_at DESC", &endUsers)
+	return endUsers, total, err
+}
+
+// GetEndUser returns an end user of a user's API key
+func (s *EndUserService) GetEndUser(userID, keyID uint, externalID string) (*database.EndUser, error) {
+	if err := s.ownsKey(userID, keyID); err != nil {
+		return nil, err
+	}
+	var endUser database.EndUser
+	if err := s.db.Where("api_key_id = ? AND external_id = ?", keyID, externalID).First(&endUser).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrEndUserNotFound
+		}
+		return nil, err
+	}
+	return &endUser, nil
+}
+
+// SetEndUser sets the quotas of an end user, creating it so quotas can be
+// provisioned before its first request
+func (s *EndUserService) SetEndUser(userID, keyID uint, externalID string, req *EndUserUpdate) (*database.EndUser, error) {
+	if err := s.ownsKey(userID, keyID); err != nil {
+		return nil, err
+	}
+	for _, limit := range []*int{req.DailyRequestLimit, req.MonthlyRequestLimit, req.DailyTokenLimit, req.MonthlyTokenLimit} {
+		if limit != nil && *limit < 0 {
+			return nil, errors.New("limits must not be negative")
+		}
+	}
+	endUser, err := s.Resolve(keyID, externalID)
+	if err != nil {
+		return nil, err
+	}
+
+	endUser.IsBlocked = req.IsBlocked
+	endUser.DailyRequestLimit = req.DailyRequestLimit
+	endUser.MonthlyRequestLimit = req.MonthlyRequestLimit
+	endUser.DailyTokenLimit = req.DailyTokenLimit
+	endUser.MonthlyTokenLimit = req.MonthlyTokenLimit
+	if err := s.db.Save(endUser).Error; err != nil {
+		return nil, err
+	}
+	return endUser, nil
+}
+
+// DeleteEndUser removes an end user and its quotas; its usage records are kept
+func (s *EndUserService) DeleteEndUser(userID, keyID uint, externalID string) error {
+	if err := s.ownsKey(userID, keyID); err != nil {
+		return err
+	}
+	result := s.db.Where("api_key_id = ? AND external_id = ?", keyID, externalID).Delete(&database.EndUser{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrEndUserNotFound
+	}
+	return nil
+}
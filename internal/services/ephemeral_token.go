@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/utils"
+)
+
+// EphemeralTokenPrefix marks ephemeral gateway tokens, as sk- marks API keys
+const EphemeralTokenPrefix = "ek-"
+
+// Lifetime of ephemeral tokens
+const (
+	DefaultEphemeralTokenMinutes = 10
+	MaxEphemeralTokenMinutes     = 60
+)
+
+// ErrInvalidEphemeralToken is returned for an ephemeral token request that
+// cannot be honoured
+var ErrInvalidEphemeralToken = errors.New("invalid ephemeral token request")
+
+// EphemeralTokenRequest represents a request to mint an ephemeral token. Empty
+// Models and Endpoints leave the token as unrestricted as its API key.
+type EphemeralTokenRequest struct {
+	TTLMinutes int      `json:"ttl_minutes"`
+	Models     []string `json:"models"`
+	Endpoints  []string `json:"endpoints"` // request paths, e.g. /v1/chat/completions
+}
+
+// EphemeralToken is a minted ephemeral token
+type EphemeralToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Models    []string  `json:"models,omitempty"`
+	Endpoints []string  `json:"endpoints,omitempty"`
+}
+
+// CreateEphemeralToken mints a short-lived token acting as key, for clients such
+// as browsers and mobile apps that must not hold the key itself. The token
+// never outlives the key and stops working when the key is deactivated or
+// deleted.
+func (s *AuthService) CreateEphemeralToken(key *database.APIKey, req *EphemeralTokenRequest) (*EphemeralToken, error) {
+	minutes := req.TTLMinutes
+	if minutes == 0 {
+		minutes = DefaultEphemeralTokenMinutes
+	}
+	if minutes < 0 || minutes > MaxEphemeralTokenMinutes {
+		return nil, fmt.Errorf("%w: ttl_minutes must be between 1 and %d", ErrInvalidEphemeralToken, MaxEphemeralTokenMinutes)
+	}
+	expiresAt := time.Now().Add(time.Duration(minutes) * time.Minute)
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(expiresAt) {
+		expiresAt = *key.ExpiresAt
+	}
+
+	models := compactStrings(req.Models)
+	endpoints := compactStrings(req.Endpoints)
+	for _, endpoint := range endpoints {
+		if !strings.HasPrefix(endpoint, "/") {
+			return nil, fmt.Errorf("%w: endpoint %q must be a path", ErrInvalidEphemeralToken, endpoint)
+		}
+	}
+
+	token, err := utils.CreateEphemeralToken(key.ID, models, endpoints, s.cfg.JWTSecret, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &EphemeralToken{
+		Token:     EphemeralTokenPrefix + token,
+		ExpiresAt: expiresAt,
+		Models:    models,
+		Endpoints: endpoints,
+	}, nil
+}
+
+// compactStrings trims values and drops empty and repeated ones
+func compactStrings(values []string) []string {
+	var result []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		result = append(result, v)
+	}
+	return result
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+
+	"gorm.io/gorm"
+)
+
+// Routing strategies for API keys with several provider configs serving a model
+const (
+	RoutingOrdered = "ordered" // first matching config in the key's order
+	RoutingLatency = "latency" // fastest healthy region, measured by health checks
+)
+
+const (
+	healthProbeTimeout    = 10 * time.Second
+	healthLatencyAlpha    = 0.3 // EWMA weight of the newest probe
+	healthFailThreshold   = 2   // consecutive failed probes before a config is unhealthy
+	healthStickyTolerance = 0.2 // keep the previous region unless another is >20% faster
+)
+
+// UpstreamHealth is the health-check state of one provider config
+type UpstreamHealth struct {
+	ProviderConfigID    uint      `json:"provider_config_id"`
+	Region              string    `json:"region"`
+	Healthy             bool      `json:"healthy"`
+	LatencyMs           float64   `json:"latency_ms"` // exponentially weighted moving average
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastCheckedAt       time.Time `json:"last_checked_at"`
+}
+
+// HealthService probes upstream provider configs and ranks them for latency-based routing
+type HealthService struct {
+	db            *gorm.DB
+	cfg           *config.Config
+	configService *ConfigService
+	client        *http.Client
+	events        *events.Bus // receives provider.unhealthy events
+
+	mu     sync.RWMutex
+	health map[uint]*UpstreamHealth
+	sticky map[string]uint // routing key -> last selected config
+}
+
+// NewHealthService creates a new HealthService publishing health changes on bus
+func NewHealthService(db *gorm.DB, cfg *config.Config, bus *events.Bus, cache *CredentialCache) *HealthService {
+	return &HealthService{
+		db:            db,
+		cfg:           cfg,
+		configService: NewConfigService(db, cfg, bus, cache),
+		client:        &http.Client{Timeout: healthProbeTimeout},
+		events:        bus,
+		health:        make(map[uint]*UpstreamHealth),
+		sticky:        make(map[string]uint),
+	}
+}
+
+// Get returns the health of a provider config, if it has been probed
+func (s *HealthService) Get(configID uint) (UpstreamHealth, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, ok := s.health[configID]
+	if !ok {
+		return UpstreamHealth{}, false
+	}
+	return *h, true
+}
+
+// record folds one probe result into the config's health
+func (s *HealthService) record(cfg *database.ProviderConfig, latency time.Duration, probeErr error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.health[cfg.ID]
+	if !ok {
+		h = &UpstreamHealth{ProviderConfigID: cfg.ID}
+		s.health[cfg.ID] = h
+	}
+	h.Region = cfg.Region
+	h.LastCheckedAt = time.Now()
+
+	if probeErr != nil {
+		h.ConsecutiveFailures++
+		h.LastError = probeErr.Error()
+		if h.ConsecutiveFailures >= healthFailThreshold {
+			h.Healthy = false
+		}
+		// Announce each run of failures once, as it crosses the threshold
+		if h.ConsecutiveFailures == healthFailThreshold {
+			s.events.Publish(events.Event{
+				Type:   events.ProviderUnhealthy,
+				UserID: cfg.UserID,
+				Data: events.ProviderHealth{
+					ProviderConfigID:    cfg.ID,
+					Provider:            cfg.Provider,
+					Name:                cfg.Name,
+					ConsecutiveFailures: h.ConsecutiveFailures,
+					LastError:           h.LastError,
+				},
+			})
+		}
+		return
+	}
+
+	ms := float64(latency) / float64(time.Millisecond)
+	if h.LatencyMs == 0 {
+		h.LatencyMs = ms
+	} else {
+		h.LatencyMs = healthLatencyAlpha*ms + (1-healthLatencyAlpha)*h.LatencyMs
+	}
+	h.Healthy = true
+	h.ConsecutiveFailures = 0
+	h.LastError = ""
+}
+
+// Probe measures one authenticated round trip (listing models) to a provider config
+func (s *HealthService) Probe(ctx context.Context, cfg *database.ProviderConfig) {
+	latency, err := s.probe(ctx, cfg)
+	s.record(cfg, latency, err)
+}
+
+func (s *HealthService) probe(ctx context.Context, cfg *database.ProviderConfig) (time.Duration, error) {
+	apiKey, err := s.configService.DecryptAPIKey(cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	protocol := normalizeProtocol(cfg.Protocol)
+	endpoint := strings.TrimRight(cfg.BaseURL, "/") + "/models"
+	if protocol == "gemini" {
+		endpoint += "?key=" + url.QueryEscape(apiKey)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	switch protocol {
+	case "anthropic":
+		req.Header.Set("x-api-key", apiKey)
+		req.Header.Set("anthropic-version", "2023-06-01")
+	case "gemini":
+	default:
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("upstream unreachable")
+	}
+	latency := time.Since(start)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return latency, fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return latency, fmt.Errorf("upstream rejected the api_key (status %d)", resp.StatusCode)
+	}
+	return latency, nil
+}
+
+// CheckAll probes every active provider config used by an API key with latency routing
+func (s *HealthService) CheckAll(ctx context.Context) error {
+	var configs []database.ProviderConfig
+	err := s.db.Distinct("provider_configs.*").
+		Joins("JOIN api_key_providers ON api_key_providers.provider_config_id = provider_configs.id").
+		Joins("JOIN api_keys ON api_keys.id = api_key_providers.api_key_id").
+		Where("provider_configs.is_active = ? AND api_keys.is_active = ? AND api_keys.routing_strategy = ?", true, true, RoutingLatency).
+		Find(&configs).Error
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for i := range configs {
+		wg.Add(1)
+		go func(cfg *database.ProviderConfig) {
+			defer wg.Done()
+			s.Probe(ctx, cfg)
+		}(&configs[i])
+	}
+	wg.Wait()
+	return nil
+}
+
+// regionRank groups candidate configs that share a region
+type regionRank struct {
+	region    string
+	configs   []*database.ProviderConfig
+	healthy   bool
+	measured  bool
+	latencyMs float64
+}
+
+// Rank orders candidates for a request: healthy regions fastest first, then regions
+// not yet probed, then unhealthy ones, each group keeping the configured order. The
+// region chosen last time for stickyKey is kept while it stays healthy and within
+// healthStickyTolerance of the fastest region.
+func (s *HealthService) Rank(stickyKey string, candidates []*database.ProviderConfig) []*database.ProviderConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var regions []*regionRank
+	byRegion := map[string]*regionRank{}
+	for _, cfg := range candidates {
+		r, ok := byRegion[cfg.Region]
+		if !ok {
+			r = &regionRank{region: cfg.Region}
+			byRegion[cfg.Region] = r
+			regions = append(regions, r)
+		}
+		r.configs = append(r.configs, cfg)
+
+		h, probed := s.health[cfg.ID]
+		if !probed {
+			continue
+		}
+		r.measured = true
+		if h.Healthy && (!r.healthy || h.LatencyMs < r.latencyMs) {
+			r.latencyMs = h.LatencyMs
+		}
+		if h.Healthy {
+			r.healthy = true
+		}
+	}
+
+	group := func(r *regionRank) int {
+		switch {
+		case r.healthy:
+			return 0
+		case !r.measured:
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(regions, func(i, j int) bool {
+		gi, gj := group(regions[i]), group(regions[j])
+		if gi != gj {
+			return gi < gj
+		}
+		return gi == 0 && regions[i].latencyMs < regions[j].latencyMs
+	})
+
+	// Stay on the previous region unless it degraded or another is clearly faster
+	if prevID, ok := s.sticky[stickyKey]; ok && len(regions) > 1 && group(regions[0]) == 0 {
+		for i, r := range regions {
+			if group(r) != 0 || r.latencyMs > regions[0].latencyMs*(1+healthStickyTolerance) {
+				continue
+			}
+			if containsConfig(r.configs, prevID) && i > 0 {
+				regions = append([]*regionRank{r}, append(regions[:i:i], regions[i+1:]...)...)
+				break
+			}
+		}
+	}
+
+	ranked := make([]*database.ProviderConfig, 0, len(candidates))
+	for _, r := range regions {
+		// Within a region, healthy configs go first, fastest first
+		configs := append([]*database.ProviderConfig(nil), r.configs...)
+		sort.SliceStable(configs, func(i, j int) bool {
+			hi, iok := s.health[configs[i].ID]
+			hj, jok := s.health[configs[j].ID]
+			iHealthy := iok && hi.Healthy
+			jHealthy := jok && hj.Healthy
+			if iHealthy != jHealthy {
+				return iHealthy
+			}
+			return iHealthy && hi.LatencyMs < hj.LatencyMs
+		})
+		ranked = append(ranked, configs...)
+	}
+
+	if len(ranked) > 0 {
+		s.sticky[stickyKey] = ranked[0].ID
+	}
+	return ranked
+}
+
+func containsConfig(configs []*database.ProviderConfig, id uint) bool {
+	for _, cfg := range configs {
+		if cfg.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Run probes latency-routed upstreams periodically until ctx is cancelled
+func (s *HealthService) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.cfg.HealthCheckInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := s.CheckAll(ctx); err != nil {
+			log.Printf("[Health] Failed to run health checks: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
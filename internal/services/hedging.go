@@ -0,0 +1,143 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// hedgeBudgetBurst is how many hedges a policy can spend in a row before it is
+// limited to its MaxHedgePercent share of requests
+const hedgeBudgetBurst = 10.0
+
+// ErrHedgePolicyNotFound is returned when a user has no such hedge policy
+var ErrHedgePolicyNotFound = errors.New("hedge policy not found")
+
+// HedgePolicyUpdate represents the hedging settings for a model
+type HedgePolicyUpdate struct {
+	Model           string `json:"model"`
+	DelayMs         int    `json:"delay_ms"`
+	MaxHedgePercent int    `json:"max_hedge_percent"`
+	IsActive        *bool  `json:"is_active"`
+}
+
+// HedgeService manages hedge policies and the hedge budget of each policy.
+// Every hedge may bill a second upstream request, so each policy earns
+// MaxHedgePercent/100 of a hedge per request and a hedge is only sent while
+// the policy has budget left.
+type HedgeService struct {
+	db *gorm.DB
+
+	mu     sync.Mutex
+	budget map[uint]float64 // policy ID -> hedges available
+}
+
+// NewHedgeService creates a new HedgeService
+func NewHedgeService(db *gorm.DB) *HedgeService {
+	return &HedgeService{db: db, budget: make(map[uint]float64)}
+}
+
+// GetPolicies returns all hedge policies of a user
+func (s *HedgeService) GetPolicies(userID uint) ([]database.HedgePolicy, error) {
+	var policies []database.HedgePolicy
+	err := s.db.Where("user_id = ?", userID).Order("model").Find(&policies).Error
+	return policies, err
+}
+
+// SetPolicy creates or replaces the hedge policy of a model
+func (s *HedgeService) SetPolicy(userID uint, req *HedgePolicyUpdate) (*database.HedgePolicy, error) {
+	model := strings.TrimSpace(req.Model)
+	if model == "" {
+		return nil, errors.New("model is required")
+	}
+	if req.DelayMs <= 0 {
+		return nil, errors.New("delay_ms must be positive")
+	}
+	if req.MaxHedgePercent == 0 {
+		req.MaxHedgePercent = 10
+	}
+	if req.MaxHedgePercent < 0 || req.MaxHedgePercent > 100 {
+		return nil, errors.New("max_hedge_percent must be between 1 and 100")
+	}
+
+	var policy database.HedgePolicy
+	err := s.db.Where("user_id = ? AND model = ?", userID, model).First(&policy).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	policy.UserID = userID
+	policy.Model = model
+	policy.DelayMs = req.DelayMs
+	policy.MaxHedgePercent = req.MaxHedgePercent
+	policy.IsActive = true
+	if req.IsActive != nil {
+		policy.IsActive = *req.IsActive
+	}
+	if err := s.db.Save(&policy).Error; err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// DeletePolicy removes a hedge policy
+func (s *HedgeService) DeletePolicy(userID, id uint) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&database.HedgePolicy{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrHedgePolicyNotFound
+	}
+
+	s.mu.Lock()
+	delete(s.budget, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// PolicyFor returns the active hedge policy for a user's model, or nil
+func (s *HedgeService) PolicyFor(userID uint, model string) *database.HedgePolicy {
+	var policy database.HedgePolicy
+	err := s.db.Where("user_id = ? AND model = ? AND is_active = ?", userID, model, true).First(&policy).Error
+	if err != nil {
+		return nil
+	}
+	return &policy
+}
+
+// Earn credits a policy with its share of a hedge for one request
+func (s *HedgeService) Earn(policy *database.HedgePolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	budget, ok := s.budget[policy.ID]
+	if !ok {
+		budget = hedgeBudgetBurst
+	}
+	budget += float64(policy.MaxHedgePercent) / 100
+	if budget > hedgeBudgetBurst {
+		budget = hedgeBudgetBurst
+	}
+	s.budget[policy.ID] = budget
+}
+
+// Spend takes one hedge from a policy's budget, reporting whether one was available
+func (s *HedgeService) Spend(policy *database.HedgePolicy) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	budget, ok := s.budget[policy.ID]
+	if !ok {
+		budget = hedgeBudgetBurst
+	}
+	if budget < 1 {
+		return false
+	}
+	s.budget[policy.ID] = budget - 1
+	return true
+}
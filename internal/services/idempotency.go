@@ -0,0 +1,106 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrIdempotencyInFlight is returned when the same key is already being processed
+	ErrIdempotencyInFlight = errors.New("a request with this Idempotency-Key is already in progress")
+	// ErrIdempotencyMismatch is returned when a key is reused with a different request body
+	ErrIdempotencyMismatch = errors.New("Idempotency-Key was already used with a different request")
+)
+
+// IdempotencyService stores and replays responses keyed by Idempotency-Key
+type IdempotencyService struct {
+	db       *gorm.DB
+	ttl      time.Duration
+	inFlight sync.Map
+
+	lastPurge atomic.Int64
+}
+
+// idempotencyPurgeInterval bounds how often Save sweeps expired records
+const idempotencyPurgeInterval = 10 * time.Minute
+
+// NewIdempotencyService creates a new IdempotencyService
+func NewIdempotencyService(db *gorm.DB, cfg *config.Config) *IdempotencyService {
+	return &IdempotencyService{
+		db:  db,
+		ttl: time.Duration(cfg.IdempotencyTTL) * time.Second,
+	}
+}
+
+// HashRequest returns the fingerprint stored alongside a key to detect reuse with a different payload
+func HashRequest(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Lookup returns the stored record for scope/key if it has not expired.
+// A nil record with a nil error means the key has not been seen.
+func (s *IdempotencyService) Lookup(scope, key, requestHash string) (*database.IdempotencyRecord, error) {
+	var record database.IdempotencyRecord
+	err := s.db.Where("scope = ? AND idempotency_key = ? AND expires_at > ?", scope, key, time.Now()).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.RequestHash != requestHash {
+		return nil, ErrIdempotencyMismatch
+	}
+	return &record, nil
+}
+
+// Acquire marks scope/key as in flight. The returned release func must be called when done.
+func (s *IdempotencyService) Acquire(scope, key string) (func(), error) {
+	lockKey := scope + "|" + key
+	if _, loaded := s.inFlight.LoadOrStore(lockKey, struct{}{}); loaded {
+		return nil, ErrIdempotencyInFlight
+	}
+	return func() { s.inFlight.Delete(lockKey) }, nil
+}
+
+// Save stores a completed response, replacing any expired record for the same key
+func (s *IdempotencyService) Save(record *database.IdempotencyRecord) error {
+	record.ExpiresAt = time.Now().Add(s.ttl)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("scope = ? AND idempotency_key = ?", record.Scope, record.IdempotencyKey).Delete(&database.IdempotencyRecord{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(record).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	last := s.lastPurge.Load()
+	if now-last >= int64(idempotencyPurgeInterval/time.Second) && s.lastPurge.CompareAndSwap(last, now) {
+		s.PurgeExpired()
+	}
+	return nil
+}
+
+// PurgeExpired removes records whose replay window has passed
+func (s *IdempotencyService) PurgeExpired() (int64, error) {
+	result := s.db.Where("expires_at <= ?", time.Now()).Delete(&database.IdempotencyRecord{})
+	return result.RowsAffected, result.Error
+}
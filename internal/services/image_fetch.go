@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"ai_gateway/internal/config"
+)
+
+// maxImageFetchRedirects bounds the redirects followed for one image URL
+const maxImageFetchRedirects = 3
+
+// errPrivateAddress is returned for image URLs resolving to a non-public address
+var errPrivateAddress = errors.New("refusing to fetch from a private address")
+
+// FetchedImage is a downloaded image
+type FetchedImage struct {
+	Data     []byte
+	MimeType string
+}
+
+// ImageFetchService downloads the image URLs of requests for upstreams that
+// only take inline images. Only https URLs of allowed hosts are fetched, never
+// from private addresses, within a size cap and a timeout; downloads are
+// cached by URL.
+type ImageFetchService struct {
+	enabled  bool
+	allowed  []string
+	maxBytes int64
+	client   *http.Client
+
+	ttl        time.Duration
+	cacheBytes int
+	mu         sync.Mutex
+	cache      map[string]cachedImage
+	order      []string // cached URLs, oldest first
+	size       int
+}
+
+type cachedImage struct {
+	image   FetchedImage
+	expires time.Time
+}
+
+// NewImageFetchService creates a new ImageFetchService
+func NewImageFetchService(cfg *config.Config) *ImageFetchService {
+	timeout := time.Duration(cfg.ImageFetchTimeout) * time.Millisecond
+	dialer := &net.Dialer{Timeout: timeout, Control: refusePrivateAddress}
+	s := &ImageFetchService{
+		enabled:    cfg.ImageFetchURLs,
+		maxBytes:   int64(cfg.ImageFetchMaxBytes),
+		ttl:        time.Duration(cfg.ImageFetchCacheTTL) * time.Second,
+		cacheBytes: cfg.ImageFetchCacheBytes,
+		cache:      make(map[string]cachedImage),
+	}
+	for _, host := range cfg.ImageFetchAllowedHosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			s.allowed = append(s.allowed, host)
+		}
+	}
+	s.client = &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxImageFetchRedirects {
+				return fmt.Errorf("more than %d redirects", maxImageFetchRedirects)
+			}
+			return s.checkURL(req.URL)
+		},
+	}
+	return s
+}
+
+// Enabled reports whether image URLs are downloaded for upstreams
+func (s *ImageFetchService) Enabled() bool {
+	return s.enabled
+}
+
+// MaxBytes is the size of the images fetched for one request
+func (s *ImageFetchService) MaxBytes() int64 {
+	return s.maxBytes
+}
+
+// Fetch downloads an image of at most maxBytes, or returns it from the cache
+func (s *ImageFetchService) Fetch(ctx context.Context, rawURL string, maxBytes int64) (*FetchedImage, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL")
+	}
+	if err := s.checkURL(parsed); err != nil {
+		return nil, err
+	}
+	if image, ok := s.cached(rawURL); ok {
+		if int64(len(image.Data)) > maxBytes {
+			return nil, fmt.Errorf("images exceed %d bytes", maxBytes)
+		}
+		return image, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("images exceed %d bytes", maxBytes)
+	}
+
+	mimeType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return nil, fmt.Errorf("not an image (%s)", mimeType)
+	}
+	image := &FetchedImage{Data: data, MimeType: mimeType}
+	s.store(rawURL, image)
+	return image, nil
+}
+
+// checkURL refuses URLs that are not https or whose host is not allowed
+func (s *ImageFetchService) checkURL(u *url.URL) error {
+	if u.Scheme != "https" {
+		return fmt.Errorf("only https image URLs are fetched")
+	}
+	if len(s.allowed) == 0 {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range s.allowed {
+		if host == pattern || strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %s is not allowed", host)
+}
+
+// refusePrivateAddress is a dialer control refusing connections to loopback,
+// private, link-local and unspecified addresses, checked on the address
+// actually dialed so that DNS cannot redirect a fetch inside the network
+func refusePrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// cached returns the unexpired download of a URL
+func (s *ImageFetchService) cached(rawURL string) (*FetchedImage, bool) {
+	if s.ttl <= 0 {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.cache[rawURL]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	image := entry.image
+	return &image, true
+}
+
+// store caches a download, evicting the oldest ones beyond the byte budget
+func (s *ImageFetchService) store(rawURL string, image *FetchedImage) {
+	if s.ttl <= 0 || len(image.Data) > s.cacheBytes {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.cache[rawURL]; ok {
+		s.size -= len(old.image.Data)
+		s.removeLocked(rawURL)
+	}
+	s.cache[rawURL] = cachedImage{image: *image, expires: time.Now().Add(s.ttl)}
+	s.order = append(s.order, rawURL)
+	s.size += len(image.Data)
+
+	now := time.Now()
+	for len(s.order) > 0 {
+		oldest := s.order[0]
+		entry := s.cache[oldest]
+		if s.size <= s.cacheBytes && now.Before(entry.expires) {
+			break
+		}
+		s.order = s.order[1:]
+		delete(s.cache, oldest)
+		s.size -= len(entry.image.Data)
+	}
+}
+
+// removeLocked drops a URL from the eviction order; s.mu must be held
+func (s *ImageFetchService) removeLocked(rawURL string) {
+	for i, cachedURL := range s.order {
+		if cachedURL == rawURL {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			return
+		}
+	}
+}
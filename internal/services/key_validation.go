@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// keyCheckTimeout bounds the upstream auth check performed when validating a key
+const keyCheckTimeout = 10 * time.Second
+
+// ValidateKeyFormat returns warnings when apiKey does not look like a key for provider.
+// Custom providers only get the generic checks since their key formats are unknown.
+func ValidateKeyFormat(provider, apiKey string) []string {
+	var warnings []string
+
+	if strings.TrimSpace(apiKey) != apiKey {
+		warnings = append(warnings, "api_key has leading or trailing whitespace")
+	}
+	if strings.ContainsAny(strings.TrimSpace(apiKey), " \t\r\n") {
+		warnings = append(warnings, "api_key contains whitespace")
+	}
+
+	key := strings.TrimSpace(apiKey)
+	switch provider {
+	case "openai":
+		if strings.HasPrefix(key, "sk-ant-") {
+			warnings = append(warnings, "api_key looks like an Anthropic key (sk-ant-) but provider is openai")
+		} else if !strings.HasPrefix(key, "sk-") {
+			warnings = append(warnings, "OpenAI keys usually start with sk-")
+		}
+	case "anthropic":
+		if !strings.HasPrefix(key, "sk-ant-") {
+			warnings = append(warnings, "Anthropic keys usually start with sk-ant-")
+		}
+	case "gemini":
+		if !strings.HasPrefix(key, "AIza") {
+			warnings = append(warnings, "Gemini API keys usually start with AIza")
+		} else if len(key) != 39 {
+			warnings = append(warnings, fmt.Sprintf("Gemini API keys are usually 39 characters, got %d", len(key)))
+		}
+	}
+
+	return warnings
+}
+
+// CheckUpstreamAuth performs a cheap authenticated call (listing models) against the
+// upstream and returns a warning describing the failure, or "" when the key is accepted
+func (s *ConfigService) CheckUpstreamAuth(ctx context.Context, protocol, baseURL, apiKey string) string {
+	ctx, cancel := context.WithTimeout(ctx, keyCheckTimeout)
+	defer cancel()
+
+	baseURL = strings.TrimRight(baseURL, "/")
+	endpoint := baseURL + "/models"
+	if protocol == "gemini" {
+		endpoint += "?key=" + url.QueryEscape(apiKey)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Sprintf("upstream auth check skipped: %v", err)
+	}
+	switch protocol {
+	case "anthropic":
+		req.Header.Set("x-api-key", apiKey)
+		req.Header.Set("anthropic-version", "2023-06-01")
+	case "gemini":
+	default:
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Sprintf("upstream auth check failed: could not reach %s", baseURL)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Sprintf("upstream rejected the api_key (status %d)", resp.StatusCode)
+	case resp.StatusCode == http.StatusBadRequest && protocol == "gemini":
+		// Gemini reports invalid keys as 400 API_KEY_INVALID
+		return "upstream rejected the api_key (status 400)"
+	case resp.StatusCode >= 500:
+		return fmt.Sprintf("upstream auth check inconclusive: upstream returned status %d", resp.StatusCode)
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidListStatus is returned for a status filter a list does not support
+var ErrInvalidListStatus = errors.New("invalid status filter")
+
+// likeEscaper escapes the wildcards of a LIKE pattern using '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ListQuery pages and filters a management list. Each list decides which
+// column Search matches and which Status values it accepts. A Limit of 0
+// returns every row.
+type ListQuery struct {
+	Limit  int
+	Offset int
+	Search string     // case-insensitive substring
+	Status string     // e.g. active, inactive
+	Since  *time.Time // created at or after
+	Until  *time.Time // created before
+}
+
+// filter adds the creation time filters of q, and its search on column, to query
+func (q *ListQuery) filter(query *gorm.DB, column string) *gorm.DB {
+	if q.Since != nil {
+		query = query.Where("created_at >= ?", *q.Since)
+	}
+	if q.Until != nil {
+		query = query.Where("created_at < ?", *q.Until)
+	}
+	if q.Search != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(q.Search)) + "%"
+		query = query.Where("LOWER("+column+`) LIKE ? ESCAPE '\'`, pattern)
+	}
+	return query
+}
+
+// find counts the rows matching query and loads the page of q, in order and
+// with the given associations preloaded, into dest
+func (q *ListQuery) find(query *gorm.DB, order string, dest interface{}, preloads ...string) (int64, error) {
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return 0, err
+	}
+	page := query.Session(&gorm.Session{}).Order(order)
+	for _, preload := range preloads {
+		page = page.Preload(preload)
+	}
+	if q.Limit > 0 {
+		page = page.Limit(q.Limit)
+	}
+	if q.Offset > 0 {
+		page = page.Offset(q.Offset)
+	}
+	return total, page.Find(dest).Error
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// maintenanceTTL bounds how long maintenance windows are served from cache,
+	// so that windows set through another gateway instance take effect
+	maintenanceTTL = 10 * time.Second
+	// defaultMaintenanceRetryAfter is the Retry-After of open-ended windows
+	defaultMaintenanceRetryAfter = 5 * time.Minute
+	maxMaintenanceMessageLength  = 500
+)
+
+// Errors of maintenance windows
+var (
+	ErrMaintenanceNotFound    = errors.New("maintenance window not found")
+	ErrProviderConfigNotFound = errors.New("provider config not found")
+)
+
+// MaintenanceError is the error of a request refused during a maintenance
+// window, to be answered with 503 and a Retry-After header
+type MaintenanceError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *MaintenanceError) Error() string {
+	return e.Message
+}
+
+// RetryAfterSeconds returns the Retry-After header value of the error
+func (e *MaintenanceError) RetryAfterSeconds() string {
+	return fmt.Sprint(int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// MaintenanceRequest represents the settings of a maintenance window
+type MaintenanceRequest struct {
+	Message    string     `json:"message"`
+	RetryAfter int        `json:"retry_after"` // seconds; 0 derives it from until
+	Failover   bool       `json:"failover"`    // provider configs only
+	Until      *time.Time `json:"until"`
+}
+
+// MaintenanceService manages maintenance windows: the whole gateway, or single
+// provider configs, paused during upstream key rotation or an incident. The
+// current windows are kept in memory for maintenanceTTL.
+type MaintenanceService struct {
+	db *gorm.DB
+
+	mu       sync.Mutex
+	gateway  *database.Maintenance
+	configs  map[uint]*database.Maintenance // by provider config ID
+	loadedAt time.Time
+}
+
+// NewMaintenanceService creates a new MaintenanceService
+func NewMaintenanceService(db *gorm.DB) *MaintenanceService {
+	return &MaintenanceService{db: db}
+}
+
+// List returns the maintenance windows that have not ended, the gateway's first
+func (s *MaintenanceService) List() ([]database.Maintenance, error) {
+	var windows []database.Maintenance
+	err := s.db.Where("until IS NULL OR until > ?", time.Now()).
+		Order("provider_config_id IS NOT NULL, provider_config_id").Find(&windows).Error
+	return windows, err
+}
+
+// SetGateway puts the whole gateway into maintenance, or changes the current window
+func (s *MaintenanceService) SetGateway(adminID uint, req *MaintenanceRequest) (*database.Maintenance, error) {
+	if req.Failover {
+		return nil, errors.New("failover only applies to provider configs")
+	}
+	return s.set(adminID, nil, req)
+}
+
+// PauseProviderConfig takes a provider config out of service, or changes its current window
+func (s *MaintenanceService) PauseProviderConfig(adminID, configID uint, req *MaintenanceRequest) (*database.Maintenance, error) {
+	var count int64
+	if err := s.db.Model(&database.ProviderConfig{}).Where("id = ?", configID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrProviderConfigNotFound
+	}
+	return s.set(adminID, &configID, req)
+}
+
+func (s *MaintenanceService) set(adminID uint, configID *uint, req *MaintenanceRequest) (*database.Maintenance, error) {
+	message := strings.TrimSpace(req.Message)
+	if len(message) > maxMaintenanceMessageLength {
+		return nil, fmt.Errorf("message must be at most %d characters", maxMaintenanceMessageLength)
+	}
+	if req.RetryAfter < 0 {
+		return nil, errors.New("retry_after must not be negative")
+	}
+	if req.Until != nil && !req.Until.After(time.Now()) {
+		return nil, errors.New("until must be in the future")
+	}
+
+	window := &database.Maintenance{}
+	query := s.db.Where("provider_config_id IS NULL")
+	if configID != nil {
+		query = s.db.Where("provider_config_id = ?", *configID)
+	}
+	if err := query.FirstOrInit(window).Error; err != nil {
+		return nil, err
+	}
+	window.ProviderConfigID = configID
+	window.Message = message
+	window.RetryAfter = req.RetryAfter
+	window.Failover = req.Failover
+	window.Until = req.Until
+	window.CreatedBy = adminID
+	if err := s.db.Save(window).Error; err != nil {
+		return nil, err
+	}
+	s.invalidate()
+	return window, nil
+}
+
+// ClearGateway ends the maintenance of the whole gateway
+func (s *MaintenanceService) ClearGateway() error {
+	return s.clear(s.db.Where("provider_config_id IS NULL"))
+}
+
+// ResumeProviderConfig puts a paused provider config back into service
+func (s *MaintenanceService) ResumeProviderConfig(configID uint) error {
+	return s.clear(s.db.Where("provider_config_id = ?", configID))
+}
+
+func (s *MaintenanceService) clear(query *gorm.DB) error {
+	result := query.Delete(&database.Maintenance{})
+	if result.Error != nil {
+		return result.Error
+	}
+	s.invalidate()
+	if result.RowsAffected == 0 {
+		return ErrMaintenanceNotFound
+	}
+	return nil
+}
+
+// Gateway returns the error of requests while the whole gateway is in
+// maintenance, or nil
+func (s *MaintenanceService) Gateway() *MaintenanceError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	return maintenanceError(s.gateway, "the gateway is down for maintenance", time.Now())
+}
+
+// ProviderConfig returns the error of requests to a paused provider config, or
+// nil, and whether the config's requests may fail over to other configs
+func (s *MaintenanceService) ProviderConfig(configID uint) (*MaintenanceError, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	window := s.configs[configID]
+	err := maintenanceError(window, "the provider is paused for maintenance", time.Now())
+	return err, err != nil && window.Failover
+}
+
+// load refreshes the cached windows once they are older than maintenanceTTL.
+// Failures keep the windows loaded last. Callers hold s.mu.
+func (s *MaintenanceService) load() {
+	now := time.Now()
+	if now.Sub(s.loadedAt) < maintenanceTTL {
+		return
+	}
+	windows, err := s.List()
+	if err != nil {
+		return
+	}
+	s.gateway, s.configs, s.loadedAt = nil, make(map[uint]*database.Maintenance, len(windows)), now
+	for i := range windows {
+		if windows[i].ProviderConfigID == nil {
+			s.gateway = &windows[i]
+		} else {
+			s.configs[*windows[i].ProviderConfigID] = &windows[i]
+		}
+	}
+}
+
+func (s *MaintenanceService) invalidate() {
+	s.mu.Lock()
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+}
+
+// maintenanceError returns the error of requests during a window that has not
+// ended at now, or nil. Without a Retry-After of its own, a window with an end
+// has clients retry at its end.
+func maintenanceError(window *database.Maintenance, fallback string, now time.Time) *MaintenanceError {
+	if window == nil || (window.Until != nil && !window.Until.After(now)) {
+		return nil
+	}
+	err := &MaintenanceError{Message: window.Message, RetryAfter: time.Duration(window.RetryAfter) * time.Second}
+	if err.Message == "" {
+		err.Message = fallback
+	}
+	if err.RetryAfter == 0 {
+		err.RetryAfter = defaultMaintenanceRetryAfter
+		if window.Until != nil {
+			err.RetryAfter = window.Until.Sub(now)
+		}
+	}
+	return err
+}
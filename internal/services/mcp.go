@@ -0,0 +1,537 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	mcpProtocolVersion = "2025-06-18"
+	mcpRequestTimeout  = 60 * time.Second
+	mcpListTimeout     = 10 * time.Second
+	mcpToolsTTL        = 5 * time.Minute
+	mcpToolSeparator   = "__" // between the server name and the tool name
+)
+
+var (
+	mcpServerName   = regexp.MustCompile(`^[a-zA-Z0-9-]{1,32}$`)
+	mcpToolNameChar = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+	// ErrMCPServerNotFound is returned when a user has no such MCP server
+	ErrMCPServerNotFound = errors.New("MCP server not found")
+
+	errMCPSessionExpired = errors.New("MCP session expired")
+)
+
+// MCPServerRequest represents the settings of an MCP server
+type MCPServerRequest struct {
+	Name     string  `json:"name"`
+	URL      string  `json:"url"`
+	Token    *string `json:"token"` // nil keeps the current token on update
+	IsActive *bool   `json:"is_active"`
+}
+
+// MCPTool is a tool of an MCP server, named as advertised to models
+type MCPTool struct {
+	Name        string          `json:"name"` // <server>__<tool>
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+
+	serverID uint
+	toolName string
+}
+
+// mcpToolList caches the tools of one server
+type mcpToolList struct {
+	tools     []MCPTool
+	fetchedAt time.Time
+}
+
+// MCPService manages users' MCP servers and calls their tools
+type MCPService struct {
+	db  *gorm.DB
+	cfg *config.Config
+
+	mu      sync.Mutex
+	clients map[uint]*mcpClient
+	tools   map[uint]*mcpToolList
+}
+
+// NewMCPService creates a new MCPService
+func NewMCPService(db *gorm.DB, cfg *config.Config) *MCPService {
+	return &MCPService{
+		db:      db,
+		cfg:     cfg,
+		clients: make(map[uint]*mcpClient),
+		tools:   make(map[uint]*mcpToolList),
+	}
+}
+
+// ListServers returns all MCP servers of a user
+func (s *MCPService) ListServers(userID uint) ([]database.MCPServer, error) {
+	var servers []database.MCPServer
+	err := s.db.Where("user_id = ?", userID).Order("name").Find(&servers).Error
+	return servers, err
+}
+
+// getServer returns a user's MCP server
+func (s *MCPService) getServer(userID, id uint) (*database.MCPServer, error) {
+	var server database.MCPServer
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&server).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMCPServerNotFound
+		}
+		return nil, err
+	}
+	return &server, nil
+}
+
+// apply validates req and copies it onto server
+func (s *MCPService) apply(server *database.MCPServer, req *MCPServerRequest) error {
+	name := strings.TrimSpace(req.Name)
+	if !mcpServerName.MatchString(name) {
+		return errors.New("name must be 1-32 letters, digits or hyphens")
+	}
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+
+	server.Name = name
+	server.URL = u.String()
+	if req.Token != nil {
+		server.EncryptedToken = ""
+		if *req.Token != "" {
+			encKey, err := s.cfg.GetEncryptionKeyBytes()
+			if err != nil {
+				return err
+			}
+			encrypted, err := utils.EncryptAPIKey(*req.Token, encKey)
+			if err != nil {
+				return err
+			}
+			server.EncryptedToken = encrypted
+		}
+	}
+	if req.IsActive != nil {
+		server.IsActive = *req.IsActive
+	}
+	return nil
+}
+
+// CreateServer adds an MCP server for a user
+func (s *MCPService) CreateServer(userID uint, req *MCPServerRequest) (*database.MCPServer, error) {
+	server := &database.MCPServer{UserID: userID, IsActive: true}
+	if err := s.apply(server, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(server).Error; err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// UpdateServer changes the settings of a user's MCP server
+func (s *MCPService) UpdateServer(userID, id uint, req *MCPServerRequest) (*database.MCPServer, error) {
+	server, err := s.getServer(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.apply(server, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(server).Error; err != nil {
+		return nil, err
+	}
+	s.forget(server.ID)
+	return server, nil
+}
+
+// DeleteServer removes a user's MCP server
+func (s *MCPService) DeleteServer(userID, id uint) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&database.MCPServer{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMCPServerNotFound
+	}
+	s.forget(id)
+	return nil
+}
+
+// forget drops the session and cached tools of a server
+func (s *MCPService) forget(serverID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, serverID)
+	delete(s.tools, serverID)
+}
+
+// client returns the session with a server, creating it if needed
+func (s *MCPService) client(server *database.MCPServer) (*mcpClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.clients[server.ID]; ok {
+		return c, nil
+	}
+
+	token := ""
+	if server.EncryptedToken != "" {
+		encKey, err := s.cfg.GetEncryptionKeyBytes()
+		if err != nil {
+			return nil, err
+		}
+		if token, err = utils.DecryptAPIKey(server.EncryptedToken, encKey); err != nil {
+			return nil, err
+		}
+	}
+	c := &mcpClient{url: server.URL, token: token, http: &http.Client{Timeout: mcpRequestTimeout}}
+	s.clients[server.ID] = c
+	return c, nil
+}
+
+// serverTools returns the tools of a server, from cache while fresh
+func (s *MCPService) serverTools(ctx context.Context, server *database.MCPServer) ([]MCPTool, error) {
+	s.mu.Lock()
+	cached, ok := s.tools[server.ID]
+	s.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < mcpToolsTTL {
+		return cached.tools, nil
+	}
+
+	c, err := s.client(server)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, mcpListTimeout)
+	defer cancel()
+
+	var tools []MCPTool
+	cursor := ""
+	for {
+		params := map[string]interface{}{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var result struct {
+			Tools []struct {
+				Name        string          `json:"name"`
+				Description string          `json:"description"`
+				InputSchema json.RawMessage `json:"inputSchema"`
+			} `json:"tools"`
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := c.call(ctx, "tools/list", params, &result); err != nil {
+			return nil, err
+		}
+		for _, t := range result.Tools {
+			tools = append(tools, MCPTool{
+				Name:        qualifiedToolName(server.Name, t.Name),
+				Description: t.Description,
+				InputSchema: t.InputSchema,
+				serverID:    server.ID,
+				toolName:    t.Name,
+			})
+		}
+		if result.NextCursor == "" {
+			break
+		}
+		cursor = result.NextCursor
+	}
+
+	s.mu.Lock()
+	s.tools[server.ID] = &mcpToolList{tools: tools, fetchedAt: time.Now()}
+	s.mu.Unlock()
+	return tools, nil
+}
+
+// qualifiedToolName prefixes a tool with its server so tools of different
+// servers can't collide, keeping to the characters function names allow
+func qualifiedToolName(server, tool string) string {
+	name := server + mcpToolSeparator + mcpToolNameChar.ReplaceAllString(tool, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// ServerTools lists the tools of one of a user's MCP servers
+func (s *MCPService) ServerTools(ctx context.Context, userID, id uint) ([]MCPTool, error) {
+	server, err := s.getServer(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	s.forget(server.ID)
+	return s.serverTools(ctx, server)
+}
+
+// Tools returns the tools of all of a user's active MCP servers. Servers that
+// can't be reached are skipped.
+func (s *MCPService) Tools(ctx context.Context, userID uint) []MCPTool {
+	var servers []database.MCPServer
+	if err := s.db.Where("user_id = ? AND is_active = ?", userID, true).Order("name").Find(&servers).Error; err != nil {
+		log.Printf("[MCP] Failed to load servers of user=%d: %v", userID, err)
+		return nil
+	}
+
+	var tools []MCPTool
+	for i := range servers {
+		serverTools, err := s.serverTools(ctx, &servers[i])
+		if err != nil {
+			log.Printf("[MCP] Failed to list tools of server %s (user=%d): %v", servers[i].Name, userID, err)
+			continue
+		}
+		tools = append(tools, serverTools...)
+	}
+	return tools
+}
+
+// CallTool runs a tool with JSON-encoded arguments and returns its output as text.
+// Failures reported by the tool are returned as output for the model to see.
+func (s *MCPService) CallTool(ctx context.Context, userID uint, tool *MCPTool, arguments string) (string, error) {
+	server, err := s.getServer(userID, tool.serverID)
+	if err != nil {
+		return "", err
+	}
+	c, err := s.client(server)
+	if err != nil {
+		return "", err
+	}
+
+	var args map[string]interface{}
+	if strings.TrimSpace(arguments) != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "Error: tool arguments are not a valid JSON object", nil
+		}
+	}
+
+	var result struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		StructuredContent json.RawMessage `json:"structuredContent"`
+		IsError           bool            `json:"isError"`
+	}
+	params := map[string]interface{}{"name": tool.toolName, "arguments": args}
+	if err := c.call(ctx, "tools/call", params, &result); err != nil {
+		return "", err
+	}
+
+	var out []string
+	for _, part := range result.Content {
+		if part.Type == "text" {
+			out = append(out, part.Text)
+		} else {
+			out = append(out, fmt.Sprintf("[%s content omitted]", part.Type))
+		}
+	}
+	if len(out) == 0 && len(result.StructuredContent) > 0 {
+		out = append(out, string(result.StructuredContent))
+	}
+	text := strings.Join(out, "\n")
+	if result.IsError {
+		text = "Error: " + text
+	}
+	return text, nil
+}
+
+// mcpClient is a JSON-RPC session with an MCP server over the Streamable HTTP transport
+type mcpClient struct {
+	url   string
+	token string
+	http  *http.Client
+
+	mu          sync.Mutex
+	sessionID   string
+	initialized bool
+	nextID      int64
+}
+
+// call sends a request, initializing the session first and once more if it expired
+func (c *mcpClient) call(ctx context.Context, method string, params, result interface{}) error {
+	for attempt := 0; ; attempt++ {
+		if err := c.initialize(ctx); err != nil {
+			return err
+		}
+		err := c.rpc(ctx, method, params, result)
+		if errors.Is(err, errMCPSessionExpired) && attempt == 0 {
+			c.mu.Lock()
+			c.initialized = false
+			c.sessionID = ""
+			c.mu.Unlock()
+			continue
+		}
+		return err
+	}
+}
+
+// initialize performs the MCP handshake unless the session is already open
+func (c *mcpClient) initialize(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.initialized {
+		return nil
+	}
+
+	params := map[string]interface{}{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]string{"name": "ai_gateway", "version": "1.0.0"},
+	}
+	var result json.RawMessage
+	if err := c.send(ctx, "initialize", params, &result, true); err != nil {
+		return fmt.Errorf("initialize: %w", err)
+	}
+	if err := c.send(ctx, "notifications/initialized", nil, nil, false); err != nil {
+		return fmt.Errorf("initialized notification: %w", err)
+	}
+	c.initialized = true
+	return nil
+}
+
+// rpc sends a request on the open session
+func (c *mcpClient) rpc(ctx context.Context, method string, params, result interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.send(ctx, method, params, result, true)
+}
+
+// send posts one JSON-RPC message; c.mu must be held. Notifications (hasID
+// false) don't wait for a result.
+func (c *mcpClient) send(ctx context.Context, method string, params, result interface{}, hasID bool) error {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method}
+	if params != nil {
+		msg["params"] = params
+	}
+	var id int64
+	if hasID {
+		c.nextID++
+		id = c.nextID
+		msg["id"] = id
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if method != "initialize" {
+		req.Header.Set("MCP-Protocol-Version", mcpProtocolVersion)
+	}
+	if c.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", c.sessionID)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && c.sessionID != "" {
+		return errMCPSessionExpired
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: status %d: %s", method, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if sid := resp.Header.Get("Mcp-Session-Id"); sid != "" {
+		c.sessionID = sid
+	}
+	if !hasID {
+		return nil
+	}
+
+	raw, err := readRPCResponse(resp, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", method, err)
+	}
+	if envelope.Error != nil {
+		return fmt.Errorf("%s: error %d: %s", method, envelope.Error.Code, envelope.Error.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(envelope.Result, result)
+}
+
+// readRPCResponse returns the JSON-RPC response with the given id from a JSON
+// body or an SSE stream
+func readRPCResponse(resp *http.Response, id int64) ([]byte, error) {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return io.ReadAll(resp.Body)
+	}
+
+	answers := func(event []byte) bool {
+		var msg struct {
+			ID json.RawMessage `json:"id"`
+		}
+		return json.Unmarshal(event, &msg) == nil && string(msg.ID) == fmt.Sprint(id)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data:") {
+			data.WriteString(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue
+		}
+
+		// End of an event: keep it if it answers our request
+		event := []byte(data.String())
+		data.Reset()
+		if answers(event) {
+			return event, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if event := []byte(data.String()); answers(event) {
+		return event, nil
+	}
+	return nil, errors.New("stream ended without a response")
+}
@@ -0,0 +1,366 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+)
+
+// metricsWindowMinutes is how much per-minute history is kept for SLO windows
+const metricsWindowMinutes = 60
+
+// latencyBuckets are the upper bounds, in seconds, of the upstream latency histogram
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// minuteBucket holds the requests observed during one wall-clock minute
+type minuteBucket struct {
+	minute   int64
+	requests uint64
+	errors   uint64
+	latency  []uint64 // per latency bucket, plus one for +Inf
+}
+
+// upstreamSeries holds all metrics for one provider config
+type upstreamSeries struct {
+	configID   uint
+	provider   string
+	name       string
+	requests   uint64
+	errors     uint64
+	latency    []uint64 // per latency bucket, plus one for +Inf
+	latencySum float64
+	minutes    [metricsWindowMinutes]minuteBucket
+}
+
+// WindowStats summarizes requests to one provider config over a trailing window
+type WindowStats struct {
+	Requests uint64 `json:"requests"`
+	Errors   uint64 `json:"errors"`
+	latency  []uint64
+}
+
+// ErrorRate returns the fraction of failed requests in the window
+func (w *WindowStats) ErrorRate() float64 {
+	if w.Requests == 0 {
+		return 0
+	}
+	return float64(w.Errors) / float64(w.Requests)
+}
+
+// FractionSlowerThan estimates the fraction of requests slower than threshold,
+// interpolating linearly inside the histogram bucket containing it
+func (w *WindowStats) FractionSlowerThan(threshold time.Duration) float64 {
+	if w.Requests == 0 {
+		return 0
+	}
+	limit := threshold.Seconds()
+	var slow float64
+	lower := 0.0
+	for i, count := range w.latency {
+		if count == 0 {
+			if i < len(latencyBuckets) {
+				lower = latencyBuckets[i]
+			}
+			continue
+		}
+		if i == len(latencyBuckets) {
+			// Requests beyond the last bucket are treated as slow
+			slow += float64(count)
+			break
+		}
+		upper := latencyBuckets[i]
+		switch {
+		case lower >= limit:
+			slow += float64(count)
+		case upper > limit:
+			slow += float64(count) * (upper - limit) / (upper - lower)
+		}
+		lower = upper
+	}
+	return slow / float64(w.Requests)
+}
+
+// Quantile estimates the q-quantile latency of the window from the histogram
+func (w *WindowStats) Quantile(q float64) time.Duration {
+	if w.Requests == 0 {
+		return 0
+	}
+	rank := q * float64(w.Requests)
+	var seen float64
+	lower := 0.0
+	for i, count := range w.latency {
+		if i == len(latencyBuckets) {
+			return time.Duration(lower * float64(time.Second))
+		}
+		upper := latencyBuckets[i]
+		if seen+float64(count) >= rank && count > 0 {
+			frac := (rank - seen) / float64(count)
+			return time.Duration((lower + frac*(upper-lower)) * float64(time.Second))
+		}
+		seen += float64(count)
+		lower = upper
+	}
+	return time.Duration(lower * float64(time.Second))
+}
+
+// MetricsCollector aggregates upstream request outcomes per provider config
+type MetricsCollector struct {
+	mu     sync.Mutex
+	series map[uint]*upstreamSeries
+
+	// output speed of successful requests per provider config and model
+	throughput map[throughputKey]*throughputSeries
+
+	// schema guardrail outcomes by result (valid, repaired, failed) and repair attempts made
+	schemaResults map[string]uint64
+	schemaRepairs uint64
+
+	// panics recovered in gateway handlers by route
+	panics map[string]uint64
+
+	// lifecycle events published on the event bus by type
+	events map[string]uint64
+
+	// gateway requests rejected for their credentials by client IP, the IPs
+	// beyond authFailureIPs counted together
+	authFailures map[string]uint64
+}
+
+// authFailureIPs bounds the client IPs auth failures are counted for separately
+const authFailureIPs = 1000
+
+// authFailureOtherIP labels the auth failures of IPs beyond authFailureIPs
+const authFailureOtherIP = "other"
+
+// Results of validating model output against a declared JSON schema
+const (
+	SchemaResultValid    = "valid"
+	SchemaResultRepaired = "repaired"
+	SchemaResultFailed   = "failed"
+)
+
+// NewMetricsCollector creates a new MetricsCollector
+func NewMetricsCollector() *MetricsCollector {
+	return &MetricsCollector{
+		series:        make(map[uint]*upstreamSeries),
+		throughput:    make(map[throughputKey]*throughputSeries),
+		schemaResults: make(map[string]uint64),
+		panics:        make(map[string]uint64),
+		events:        make(map[string]uint64),
+		authFailures:  make(map[string]uint64),
+	}
+}
+
+// ObserveSchemaValidation records the outcome of a schema-guarded completion and
+// the number of repair attempts it took
+func (m *MetricsCollector) ObserveSchemaValidation(result string, repairs int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.schemaResults[result]++
+	m.schemaRepairs += uint64(repairs)
+}
+
+// ObservePanic records a panic recovered while serving a gateway route
+func (m *MetricsCollector) ObservePanic(route string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.panics[route]++
+}
+
+// ObserveAuthFailure counts a gateway request from ip rejected for its credentials
+func (m *MetricsCollector) ObserveAuthFailure(ip string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.authFailures[ip]; !ok && len(m.authFailures) >= authFailureIPs {
+		ip = authFailureOtherIP
+	}
+	m.authFailures[ip]++
+}
+
+// ObserveEvent counts a lifecycle event; it is registered as a handler on the
+// event bus
+func (m *MetricsCollector) ObserveEvent(event events.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events[event.Type]++
+}
+
+// latencyBucketIndex returns the histogram bucket for a latency
+func latencyBucketIndex(latency time.Duration) int {
+	seconds := latency.Seconds()
+	for i, upper := range latencyBuckets {
+		if seconds <= upper {
+			return i
+		}
+	}
+	return len(latencyBuckets)
+}
+
+// Observe records one upstream request. Responses with status >= 500 count as errors.
+func (m *MetricsCollector) Observe(cfg *database.ProviderConfig, statusCode int, latency time.Duration) {
+	m.ObserveAt(cfg, statusCode, latency, time.Now())
+}
+
+// ObserveAt records one upstream request as if it completed at now
+func (m *MetricsCollector) ObserveAt(cfg *database.ProviderConfig, statusCode int, latency time.Duration, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.series[cfg.ID]
+	if !ok {
+		s = &upstreamSeries{configID: cfg.ID, latency: make([]uint64, len(latencyBuckets)+1)}
+		m.series[cfg.ID] = s
+	}
+	s.provider = cfg.Provider
+	s.name = cfg.Name
+
+	isError := statusCode >= 500
+	idx := latencyBucketIndex(latency)
+
+	s.requests++
+	s.latency[idx]++
+	s.latencySum += latency.Seconds()
+	if isError {
+		s.errors++
+	}
+
+	minute := now.Unix() / 60
+	b := &s.minutes[minute%metricsWindowMinutes]
+	if b.minute != minute || b.latency == nil {
+		*b = minuteBucket{minute: minute, latency: make([]uint64, len(latencyBuckets)+1)}
+	}
+	b.requests++
+	b.latency[idx]++
+	if isError {
+		b.errors++
+	}
+}
+
+// Window returns the stats of a provider config over the trailing window ending at now
+func (m *MetricsCollector) Window(configID uint, window time.Duration, now time.Time) WindowStats {
+	stats := WindowStats{latency: make([]uint64, len(latencyBuckets)+1)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.series[configID]
+	if !ok {
+		return stats
+	}
+
+	current := now.Unix() / 60
+	oldest := current - int64(window/time.Minute) + 1
+	for i := range s.minutes {
+		b := &s.minutes[i]
+		if b.latency == nil || b.minute < oldest || b.minute > current {
+			continue
+		}
+		stats.Requests += b.requests
+		stats.Errors += b.errors
+		for j, count := range b.latency {
+			stats.latency[j] += count
+		}
+	}
+	return stats
+}
+
+// promLabel escapes a Prometheus label value
+func promLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// upstreamLabels renders the identifying labels of a provider config
+func upstreamLabels(configID uint, provider, name string) string {
+	return fmt.Sprintf(`provider_config_id="%d",provider="%s",name="%s"`, configID, promLabel(provider), promLabel(name))
+}
+
+// WritePrometheus writes the collected metrics in the Prometheus text exposition format
+func (m *MetricsCollector) WritePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]uint, 0, len(m.series))
+	for id := range m.series {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	fmt.Fprintln(w, "# HELP ai_gateway_upstream_requests_total Requests sent to an upstream provider config.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_upstream_requests_total counter")
+	for _, id := range ids {
+		s := m.series[id]
+		fmt.Fprintf(w, "ai_gateway_upstream_requests_total{%s} %d\n", upstreamLabels(s.configID, s.provider, s.name), s.requests)
+	}
+
+	fmt.Fprintln(w, "# HELP ai_gateway_upstream_errors_total Upstream requests that failed with a 5xx status.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_upstream_errors_total counter")
+	for _, id := range ids {
+		s := m.series[id]
+		fmt.Fprintf(w, "ai_gateway_upstream_errors_total{%s} %d\n", upstreamLabels(s.configID, s.provider, s.name), s.errors)
+	}
+
+	fmt.Fprintln(w, "# HELP ai_gateway_upstream_latency_seconds Time until the first response byte from an upstream provider config.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_upstream_latency_seconds histogram")
+	for _, id := range ids {
+		s := m.series[id]
+		labels := upstreamLabels(s.configID, s.provider, s.name)
+		var cumulative uint64
+		for i, upper := range latencyBuckets {
+			cumulative += s.latency[i]
+			fmt.Fprintf(w, "ai_gateway_upstream_latency_seconds_bucket{%s,le=\"%s\"} %d\n", labels, strconv.FormatFloat(upper, 'g', -1, 64), cumulative)
+		}
+		cumulative += s.latency[len(latencyBuckets)]
+		fmt.Fprintf(w, "ai_gateway_upstream_latency_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, cumulative)
+		fmt.Fprintf(w, "ai_gateway_upstream_latency_seconds_sum{%s} %g\n", labels, s.latencySum)
+		fmt.Fprintf(w, "ai_gateway_upstream_latency_seconds_count{%s} %d\n", labels, s.requests)
+	}
+
+	fmt.Fprintln(w, "# HELP ai_gateway_schema_validations_total Completions checked against a declared JSON schema, by result.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_schema_validations_total counter")
+	for _, result := range []string{SchemaResultValid, SchemaResultRepaired, SchemaResultFailed} {
+		fmt.Fprintf(w, "ai_gateway_schema_validations_total{result=\"%s\"} %d\n", result, m.schemaResults[result])
+	}
+
+	fmt.Fprintln(w, "# HELP ai_gateway_schema_repair_attempts_total Repair retries sent after output failed schema validation.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_schema_repair_attempts_total counter")
+	fmt.Fprintf(w, "ai_gateway_schema_repair_attempts_total %d\n", m.schemaRepairs)
+
+	routes := make([]string, 0, len(m.panics))
+	for route := range m.panics {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	fmt.Fprintln(w, "# HELP ai_gateway_panics_total Panics recovered while serving a gateway route.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_panics_total counter")
+	for _, route := range routes {
+		fmt.Fprintf(w, "ai_gateway_panics_total{route=\"%s\"} %d\n", promLabel(route), m.panics[route])
+	}
+
+	ips := make([]string, 0, len(m.authFailures))
+	for ip := range m.authFailures {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	fmt.Fprintln(w, "# HELP ai_gateway_auth_failures_total Gateway requests rejected for missing or invalid credentials, by client IP.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_auth_failures_total counter")
+	for _, ip := range ips {
+		fmt.Fprintf(w, "ai_gateway_auth_failures_total{ip=\"%s\"} %d\n", promLabel(ip), m.authFailures[ip])
+	}
+
+	fmt.Fprintln(w, "# HELP ai_gateway_events_total Lifecycle events published on the internal event bus, by type.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_events_total counter")
+	for _, eventType := range []string{events.RequestCompleted, events.KeyLimitReached, events.ProviderUnhealthy, events.ConfigChanged, events.KeyChanged, events.CanaryUsed} {
+		fmt.Fprintf(w, "ai_gateway_events_total{type=\"%s\"} %d\n", eventType, m.events[eventType])
+	}
+}
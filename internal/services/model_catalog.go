@@ -0,0 +1,69 @@
+package services
+
+import (
+	"strings"
+
+	"ai_gateway/internal/database"
+)
+
+// ModelSpec describes what a model family accepts, as published by its provider
+type ModelSpec struct {
+	ContextWindow   int  // tokens of prompt and completion together
+	MaxOutputTokens int  // most tokens one completion may have
+	Tools           bool // function calling
+	Vision          bool // image input
+}
+
+// modelCatalog holds the specs of well-known model families by model name
+// prefix; the longest matching prefix wins
+var modelCatalog = map[string]ModelSpec{
+	"gpt-3.5-turbo":     {ContextWindow: 16385, MaxOutputTokens: 4096, Tools: true},
+	"gpt-4-turbo":       {ContextWindow: 128000, MaxOutputTokens: 4096, Tools: true, Vision: true},
+	"gpt-4o":            {ContextWindow: 128000, MaxOutputTokens: 16384, Tools: true, Vision: true},
+	"gpt-4.1":           {ContextWindow: 1047576, MaxOutputTokens: 32768, Tools: true, Vision: true},
+	"gpt-5":             {ContextWindow: 400000, MaxOutputTokens: 128000, Tools: true, Vision: true},
+	"o1":                {ContextWindow: 200000, MaxOutputTokens: 100000, Tools: true, Vision: true},
+	"o3":                {ContextWindow: 200000, MaxOutputTokens: 100000, Tools: true, Vision: true},
+	"o4-mini":           {ContextWindow: 200000, MaxOutputTokens: 100000, Tools: true, Vision: true},
+	"claude-":           {ContextWindow: 200000, Tools: true, Vision: true},
+	"claude-3-haiku":    {ContextWindow: 200000, MaxOutputTokens: 4096, Tools: true, Vision: true},
+	"claude-3-opus":     {ContextWindow: 200000, MaxOutputTokens: 4096, Tools: true, Vision: true},
+	"claude-3-sonnet":   {ContextWindow: 200000, MaxOutputTokens: 4096, Tools: true, Vision: true},
+	"claude-3-5-haiku":  {ContextWindow: 200000, MaxOutputTokens: 8192, Tools: true, Vision: true},
+	"claude-3-5-sonnet": {ContextWindow: 200000, MaxOutputTokens: 8192, Tools: true, Vision: true},
+	"claude-3-7-sonnet": {ContextWindow: 200000, MaxOutputTokens: 64000, Tools: true, Vision: true},
+	"claude-haiku-4":    {ContextWindow: 200000, MaxOutputTokens: 64000, Tools: true, Vision: true},
+	"claude-sonnet-4":   {ContextWindow: 200000, MaxOutputTokens: 64000, Tools: true, Vision: true},
+	"claude-opus-4":     {ContextWindow: 200000, MaxOutputTokens: 32000, Tools: true, Vision: true},
+	"gemini-1.5-flash":  {ContextWindow: 1048576, MaxOutputTokens: 8192, Tools: true, Vision: true},
+	"gemini-1.5-pro":    {ContextWindow: 2097152, MaxOutputTokens: 8192, Tools: true, Vision: true},
+	"gemini-2.0":        {ContextWindow: 1048576, MaxOutputTokens: 8192, Tools: true, Vision: true},
+	"gemini-2.5":        {ContextWindow: 1048576, MaxOutputTokens: 65536, Tools: true, Vision: true},
+}
+
+// LookupModelSpec returns the spec of a model's family, ignoring a
+// "provider/" prefix, or false for models the gateway does not know
+func LookupModelSpec(model string) (ModelSpec, bool) {
+	if _, name, found := strings.Cut(model, "/"); found {
+		model = name
+	}
+	var best ModelSpec
+	bestLen := 0
+	for prefix, spec := range modelCatalog {
+		if strings.HasPrefix(model, prefix) && len(prefix) > bestLen {
+			best, bestLen = spec, len(prefix)
+		}
+	}
+	return best, bestLen > 0
+}
+
+// MaxOutputTokens returns the most tokens a completion of model may have on a
+// provider config: the config's override, else the model's published limit,
+// else 0 when neither is known
+func MaxOutputTokens(cfg *database.ProviderConfig, model string) int {
+	if cfg != nil && cfg.MaxOutputTokens != nil {
+		return *cfg.MaxOutputTokens
+	}
+	spec, _ := LookupModelSpec(model)
+	return spec.MaxOutputTokens
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+
+	"gorm.io/gorm"
+)
+
+// providerScheduleInterval is how often activation schedules are evaluated;
+// schedules name times to the minute
+const providerScheduleInterval = time.Minute
+
+// compiledSchedule is the activation schedule of a provider config ready for
+// evaluation
+type compiledSchedule struct {
+	source    string
+	timezone  string
+	condition routingExpr
+	location  *time.Location
+}
+
+// compileSchedule compiles an activation schedule in its timezone
+func compileSchedule(schedule, timezone string) (*compiledSchedule, error) {
+	condition, err := compileScheduleCondition(schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid active_schedule: %w", err)
+	}
+	location, err := time.LoadLocation(strings.TrimSpace(timezone))
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule_timezone %q", timezone)
+	}
+	return &compiledSchedule{source: schedule, timezone: timezone, condition: condition, location: location}, nil
+}
+
+// validateSchedule checks the activation schedule of a provider config and its timezone
+func validateSchedule(schedule, timezone string) error {
+	_, err := compileSchedule(schedule, timezone)
+	return err
+}
+
+// holds reports whether the schedule holds at now
+func (s *compiledSchedule) holds(now time.Time) bool {
+	return s.condition.eval(&RoutingFacts{Time: now}, s.location).(bool)
+}
+
+// ProviderScheduleService activates and deactivates provider configs as their
+// activation schedules start and stop holding, e.g. to route to a contract key
+// during business hours and to an overflow key after. A config toggled by hand
+// keeps its state until its schedule next changes.
+type ProviderScheduleService struct {
+	db      *gorm.DB
+	configs *ConfigService
+
+	mu        sync.Mutex
+	schedules map[uint]*compiledSchedule // by config ID, compiled when first seen or changed
+}
+
+// NewProviderScheduleService creates a new ProviderScheduleService publishing
+// the changes it makes on bus
+func NewProviderScheduleService(db *gorm.DB, cfg *config.Config, bus *events.Bus, cache *CredentialCache) *ProviderScheduleService {
+	return &ProviderScheduleService{
+		db:        db,
+		configs:   NewConfigService(db, cfg, bus, cache),
+		schedules: make(map[uint]*compiledSchedule),
+	}
+}
+
+// ApplyDue evaluates the schedules of all scheduled configs at now and applies
+// those whose state changed since they were last evaluated. A config that
+// fails to apply is logged and retried on the next run.
+func (s *ProviderScheduleService) ApplyDue(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var configs []database.ProviderConfig
+	if err := s.db.Where("active_schedule <> ?", "").Find(&configs).Error; err != nil {
+		return err
+	}
+	schedules := make(map[uint]*compiledSchedule, len(configs))
+	defer func() { s.schedules = schedules }()
+	for i := range configs {
+		cfg := &configs[i]
+		schedule, err := s.schedule(cfg)
+		if err != nil {
+			log.Printf("[Schedule] Skipping config ID=%d: %v", cfg.ID, err)
+			continue
+		}
+		schedules[cfg.ID] = schedule
+		holds := schedule.holds(now)
+		if cfg.ScheduledActive != nil && *cfg.ScheduledActive == holds {
+			continue
+		}
+		if err := s.apply(cfg, holds); err != nil {
+			log.Printf("[Schedule] Failed to apply the schedule of config ID=%d: %v", cfg.ID, err)
+		}
+	}
+	return nil
+}
+
+// schedule returns the compiled activation schedule of a config, compiling it
+// only when it changed since it was last evaluated
+func (s *ProviderScheduleService) schedule(cfg *database.ProviderConfig) (*compiledSchedule, error) {
+	if schedule, ok := s.schedules[cfg.ID]; ok && schedule.source == cfg.ActiveSchedule && schedule.timezone == cfg.ScheduleTimezone {
+		return schedule, nil
+	}
+	return compileSchedule(cfg.ActiveSchedule, cfg.ScheduleTimezone)
+}
+
+// apply sets the active state of a config to its schedule's
+func (s *ProviderScheduleService) apply(cfg *database.ProviderConfig, active bool) error {
+	toggled := cfg.IsActive != active
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(cfg).UpdateColumns(map[string]interface{}{
+			"is_active":        active,
+			"scheduled_active": active,
+		}).Error
+		if err != nil || !toggled {
+			return err
+		}
+		cfg.IsActive = active
+		return s.configs.recordRevision(tx, cfg, cfg.UserID, "schedule")
+	})
+	if err != nil {
+		return err
+	}
+	if toggled {
+		log.Printf("[Schedule] Config ID=%d is now active=%v", cfg.ID, active)
+		s.configs.publishChange(cfg.UserID, cfg.ID, "schedule")
+	}
+	return nil
+}
+
+// Run applies schedules at the start of every minute until ctx is cancelled
+func (s *ProviderScheduleService) Run(ctx context.Context) {
+	for {
+		if err := s.ApplyDue(time.Now()); err != nil {
+			log.Printf("[Schedule] Failed to apply provider config schedules: %v", err)
+		}
+
+		now := time.Now()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(now.Truncate(providerScheduleInterval).Add(providerScheduleInterval).Sub(now)):
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+
+	"gorm.io/gorm"
+)
+
+func TestCompileSchedule_TimezonesAndDST(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		timezone string
+		at       time.Time
+		want     bool
+	}{
+		// New York business hours, UTC-5 before and UTC-4 after the switch
+		// to daylight saving time on 2024-03-10
+		{"before opening in EST", "hour >= 9 && hour < 17", "America/New_York", time.Date(2024, 3, 8, 13, 59, 0, 0, time.UTC), false},
+		{"opening in EST", "hour >= 9 && hour < 17", "America/New_York", time.Date(2024, 3, 8, 14, 0, 0, 0, time.UTC), true},
+		{"closing in EST", "hour >= 9 && hour < 17", "America/New_York", time.Date(2024, 3, 8, 22, 0, 0, 0, time.UTC), false},
+		{"opening in EDT", "hour >= 9 && hour < 17", "America/New_York", time.Date(2024, 3, 11, 13, 0, 0, 0, time.UTC), true},
+		{"before opening in EDT", "hour >= 9 && hour < 17", "America/New_York", time.Date(2024, 3, 11, 12, 59, 0, 0, time.UTC), false},
+		{"closing in EDT", "hour >= 9 && hour < 17", "America/New_York", time.Date(2024, 3, 11, 21, 0, 0, 0, time.UTC), false},
+
+		// The skipped hour: 01:59 EST is followed by 03:00 EDT
+		{"before the skipped hour", `time >= "02:00" && time < "03:00"`, "America/New_York", time.Date(2024, 3, 10, 6, 59, 0, 0, time.UTC), false},
+		{"after the skipped hour", `hour == 3 && time == "03:00"`, "America/New_York", time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC), true},
+		// The repeated hour: 01:30 occurs in EDT and again in EST
+		{"repeated hour in EDT", `time == "01:30"`, "America/New_York", time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), true},
+		{"repeated hour in EST", `time == "01:30"`, "America/New_York", time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC), true},
+
+		// Weekdays follow the schedule's timezone, not UTC
+		{"Monday in Shanghai, Sunday in UTC", `weekday == "mon"`, "Asia/Shanghai", time.Date(2024, 6, 16, 16, 0, 0, 0, time.UTC), true},
+		{"Sunday in Shanghai", `weekday == "mon"`, "Asia/Shanghai", time.Date(2024, 6, 16, 15, 59, 0, 0, time.UTC), false},
+		{"weekend in UTC", `weekday in ["sat", "sun"]`, "UTC", time.Date(2024, 6, 16, 16, 0, 0, 0, time.UTC), true},
+		{"empty timezone is UTC", `hour == 16`, "", time.Date(2024, 6, 16, 16, 0, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		schedule, err := compileSchedule(tt.schedule, tt.timezone)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := schedule.holds(tt.at); got != tt.want {
+			t.Errorf("%s: %q in %q at %s = %v, want %v", tt.name, tt.schedule, tt.timezone, tt.at.Format(time.RFC3339), got, tt.want)
+		}
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	if err := validateSchedule(`weekday in ["mon", "fri"]`, "Europe/Berlin"); err != nil {
+		t.Errorf("valid schedule: %v", err)
+	}
+	if err := validateSchedule("hour >", "UTC"); err == nil || !strings.HasPrefix(err.Error(), "invalid active_schedule") {
+		t.Errorf("invalid condition: %v", err)
+	}
+	if err := validateSchedule("hour < 9", "Mars/Olympus"); err == nil || err.Error() != `invalid schedule_timezone "Mars/Olympus"` {
+		t.Errorf("invalid timezone: %v", err)
+	}
+}
+
+func TestProviderSchedule_ApplyDue(t *testing.T) {
+	db := testDB(t)
+	cfg := testConfig()
+	bus := events.NewBus()
+	svc := NewProviderScheduleService(db, cfg, bus, NewCredentialCache(cfg, bus))
+
+	user := &database.User{Username: "u", Email: "u@example.com", HashedPassword: "x", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	providerConfig := &database.ProviderConfig{
+		UserID:           user.ID,
+		Provider:         "openai",
+		Name:             "contract",
+		EncryptedKey:     "x",
+		IsActive:         true,
+		ActiveSchedule:   "hour >= 9 && hour < 17",
+		ScheduleTimezone: "America/New_York",
+	}
+	if err := db.Create(providerConfig).Error; err != nil {
+		t.Fatal(err)
+	}
+	active := func() bool {
+		var got database.ProviderConfig
+		if err := db.First(&got, providerConfig.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return got.IsActive
+	}
+	revisions := func() int64 {
+		var n int64
+		db.Model(&database.ProviderConfigRevision{}).Where("provider_config_id = ? AND action = ?", providerConfig.ID, "schedule").Count(&n)
+		return n
+	}
+
+	// 08:00 EDT, before opening
+	if err := svc.ApplyDue(time.Date(2024, 3, 11, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if active() || revisions() != 1 {
+		t.Errorf("before opening: active=%v revisions=%d, want deactivated once", active(), revisions())
+	}
+	compiled := svc.schedules[providerConfig.ID]
+
+	// 09:00 EDT activates the config; the schedule is not compiled again
+	if err := svc.ApplyDue(time.Date(2024, 3, 11, 13, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if !active() || revisions() != 2 {
+		t.Errorf("at opening: active=%v revisions=%d, want activated", active(), revisions())
+	}
+	if svc.schedules[providerConfig.ID] != compiled {
+		t.Error("an unchanged schedule must not be compiled again")
+	}
+
+	// A config deactivated by hand keeps its state while the schedule holds
+	if err := db.Model(providerConfig).Update("is_active", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.ApplyDue(time.Date(2024, 3, 11, 14, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if active() {
+		t.Error("a config toggled by hand must keep its state until its schedule next changes")
+	}
+
+	// A changed schedule is compiled and applied on the next run
+	if err := db.Model(providerConfig).Updates(map[string]interface{}{
+		"active_schedule":   "hour >= 10",
+		"schedule_timezone": "Asia/Shanghai",
+		"scheduled_active":  nil,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 22:00 in Shanghai
+	if err := svc.ApplyDue(time.Date(2024, 3, 11, 14, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if !active() {
+		t.Error("the changed schedule must be applied")
+	}
+	if svc.schedules[providerConfig.ID] == compiled {
+		t.Error("a changed schedule must be compiled again")
+	}
+
+	// Configs no longer scheduled are dropped from the compiled schedules
+	if err := db.Model(providerConfig).Update("active_schedule", "").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.ApplyDue(time.Date(2024, 3, 11, 15, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := svc.schedules[providerConfig.ID]; ok {
+		t.Error("an unscheduled config must be dropped from the compiled schedules")
+	}
+}
+
+func TestProviderSchedule_ApplyDueContinuesPastFailures(t *testing.T) {
+	db := testDB(t)
+	cfg := testConfig()
+	bus := events.NewBus()
+	svc := NewProviderScheduleService(db, cfg, bus, NewCredentialCache(cfg, bus))
+
+	user := &database.User{Username: "u", Email: "u@example.com", HashedPassword: "x", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	var configs []*database.ProviderConfig
+	for _, name := range []string{"broken", "healthy"} {
+		providerConfig := &database.ProviderConfig{UserID: user.ID, Provider: "openai", Name: name, EncryptedKey: "x", IsActive: true, ActiveSchedule: "hour >= 9"}
+		if err := db.Create(providerConfig).Error; err != nil {
+			t.Fatal(err)
+		}
+		configs = append(configs, providerConfig)
+	}
+	// Updates of the first config fail
+	broken := configs[0].ID
+	err := db.Callback().Update().Before("gorm:update").Register("test:fail_broken", func(tx *gorm.DB) {
+		if c, ok := tx.Statement.Model.(*database.ProviderConfig); ok && c.ID == broken {
+			tx.AddError(errors.New("update failed"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.ApplyDue(time.Date(2024, 3, 11, 6, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	var healthy database.ProviderConfig
+	if err := db.First(&healthy, configs[1].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if healthy.IsActive {
+		t.Error("a config after one that failed to apply must still be applied")
+	}
+	if len(svc.schedules) != 2 {
+		t.Errorf("got %d compiled schedules, want both", len(svc.schedules))
+	}
+}
@@ -0,0 +1,495 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	providerUsageRequestTimeout = 30 * time.Second
+	providerUsageMaxPages       = 20 // bounds the pages read from a usage API per pull
+)
+
+// ErrProviderUsageSyncNotFound is returned when a user has no such provider usage sync
+var ErrProviderUsageSyncNotFound = errors.New("provider usage sync not found")
+
+// ProviderUsageSyncRequest represents the settings of a provider usage sync
+type ProviderUsageSyncRequest struct {
+	ProviderConfigID uint    `json:"provider_config_id"`
+	AdminKey         *string `json:"admin_key"` // nil keeps the current key on update
+	UpstreamKeyID    string  `json:"upstream_key_id"`
+	IsActive         *bool   `json:"is_active"`
+}
+
+// ProviderUsageDrift compares the usage a provider reported with the usage the
+// gateway recorded for a provider config, on one day or in total
+type ProviderUsageDrift struct {
+	Date *time.Time `json:"date,omitempty"`
+
+	UpstreamInputTokens  int64  `json:"upstream_input_tokens"`
+	UpstreamOutputTokens int64  `json:"upstream_output_tokens"`
+	UpstreamRequests     *int64 `json:"upstream_requests"`
+	GatewayInputTokens   int64  `json:"gateway_input_tokens"`
+	GatewayOutputTokens  int64  `json:"gateway_output_tokens"`
+	GatewayRequests      int64  `json:"gateway_requests"`
+
+	// Drift is upstream minus gateway; positive drift is usage billed by the
+	// provider that the gateway did not record
+	InputTokenDrift  int64  `json:"input_token_drift"`
+	OutputTokenDrift int64  `json:"output_token_drift"`
+	RequestDrift     *int64 `json:"request_drift"`
+	Synced           bool   `json:"synced"` // whether the provider's usage of the day was pulled
+}
+
+// ProviderUsageReconciliation reports the drift of a provider usage sync per UTC day
+type ProviderUsageReconciliation struct {
+	SyncID           uint                 `json:"sync_id"`
+	ProviderConfigID uint                 `json:"provider_config_id"`
+	Provider         string               `json:"provider"`
+	Start            time.Time            `json:"start"`
+	End              time.Time            `json:"end"`
+	Total            ProviderUsageDrift   `json:"total"`
+	Days             []ProviderUsageDrift `json:"days"`
+}
+
+// ProviderUsageSyncService pulls the usage providers bill from their admin usage
+// APIs (OpenAI's organization usage API, Anthropic's usage report) and reports
+// its drift from the usage recorded by the gateway
+type ProviderUsageSyncService struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	client *http.Client
+}
+
+// NewProviderUsageSyncService creates a new ProviderUsageSyncService
+func NewProviderUsageSyncService(db *gorm.DB, cfg *config.Config) *ProviderUsageSyncService {
+	return &ProviderUsageSyncService{db: db, cfg: cfg, client: &http.Client{Timeout: providerUsageRequestTimeout}}
+}
+
+// ListSyncs returns all provider usage syncs of a user
+func (s *ProviderUsageSyncService) ListSyncs(userID uint) ([]database.ProviderUsageSync, error) {
+	var syncs []database.ProviderUsageSync
+	err := s.db.Where("user_id = ?", userID).Order("id").Find(&syncs).Error
+	return syncs, err
+}
+
+// GetSync returns a user's provider usage sync
+func (s *ProviderUsageSyncService) GetSync(userID, id uint) (*database.ProviderUsageSync, error) {
+	var sync database.ProviderUsageSync
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&sync).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProviderUsageSyncNotFound
+		}
+		return nil, err
+	}
+	return &sync, nil
+}
+
+// apply validates req and copies it onto sync
+func (s *ProviderUsageSyncService) apply(sync *database.ProviderUsageSync, req *ProviderUsageSyncRequest) error {
+	var providerConfig database.ProviderConfig
+	if err := s.db.Where("id = ? AND user_id = ?", req.ProviderConfigID, sync.UserID).First(&providerConfig).Error; err != nil {
+		return errors.New("provider config not found")
+	}
+	if providerConfig.Provider != "openai" && providerConfig.Provider != "anthropic" {
+		return fmt.Errorf("usage sync is not supported for %s provider configs", providerConfig.Provider)
+	}
+
+	if req.AdminKey != nil {
+		adminKey := strings.TrimSpace(*req.AdminKey)
+		if adminKey == "" {
+			return errors.New("admin_key must not be empty")
+		}
+		encKey, err := s.cfg.GetEncryptionKeyBytes()
+		if err != nil {
+			return err
+		}
+		encrypted, err := utils.EncryptAPIKey(adminKey, encKey)
+		if err != nil {
+			return err
+		}
+		sync.EncryptedAdminKey = encrypted
+	}
+	if sync.EncryptedAdminKey == "" {
+		return errors.New("admin_key is required")
+	}
+
+	sync.ProviderConfigID = req.ProviderConfigID
+	sync.UpstreamKeyID = strings.TrimSpace(req.UpstreamKeyID)
+	if req.IsActive != nil {
+		sync.IsActive = *req.IsActive
+	}
+	return nil
+}
+
+// CreateSync starts pulling the provider's usage for a provider config
+func (s *ProviderUsageSyncService) CreateSync(userID uint, req *ProviderUsageSyncRequest) (*database.ProviderUsageSync, error) {
+	sync := &database.ProviderUsageSync{UserID: userID, IsActive: true}
+	if err := s.apply(sync, req); err != nil {
+		return nil, err
+	}
+	var count int64
+	if err := s.db.Model(&database.ProviderUsageSync{}).Where("provider_config_id = ?", req.ProviderConfigID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("the provider config already has a usage sync")
+	}
+	if err := s.db.Create(sync).Error; err != nil {
+		return nil, err
+	}
+	return sync, nil
+}
+
+// UpdateSync changes the settings of a user's provider usage sync
+func (s *ProviderUsageSyncService) UpdateSync(userID, id uint, req *ProviderUsageSyncRequest) (*database.ProviderUsageSync, error) {
+	sync, err := s.GetSync(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if req.ProviderConfigID != sync.ProviderConfigID {
+		return nil, errors.New("provider_config_id cannot be changed")
+	}
+	if err := s.apply(sync, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(sync).Error; err != nil {
+		return nil, err
+	}
+	return sync, nil
+}
+
+// DeleteSync stops a provider usage sync and removes the usage it pulled
+func (s *ProviderUsageSyncService) DeleteSync(userID, id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&database.ProviderUsageSync{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrProviderUsageSyncNotFound
+		}
+		return tx.Where("sync_id = ?", id).Delete(&database.ProviderUsageDay{}).Error
+	})
+}
+
+// Sync pulls the provider's usage for the trailing days of the configured
+// window, up to now, replacing what earlier pulls stored for those days
+func (s *ProviderUsageSyncService) Sync(ctx context.Context, sync *database.ProviderUsageSync) error {
+	var providerConfig database.ProviderConfig
+	if err := s.db.First(&providerConfig, sync.ProviderConfigID).Error; err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	end := now.Truncate(24*time.Hour).AddDate(0, 0, 1)
+	start := end.AddDate(0, 0, -max(s.cfg.ProviderUsageSyncDays, 1))
+
+	var days []database.ProviderUsageDay
+	var err error
+	switch providerConfig.Provider {
+	case "openai":
+		days, err = s.fetchOpenAI(ctx, sync, start, end)
+	case "anthropic":
+		days, err = s.fetchAnthropic(ctx, sync, start, end)
+	default:
+		err = fmt.Errorf("usage sync is not supported for %s provider configs", providerConfig.Provider)
+	}
+	if err == nil && len(days) > 0 {
+		err = s.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "sync_id"}, {Name: "date"}},
+			DoUpdates: clause.AssignmentColumns([]string{"input_tokens", "output_tokens", "requests", "updated_at"}),
+		}).Create(&days).Error
+	}
+
+	updates := map[string]interface{}{"last_error": ""}
+	if err != nil {
+		updates["last_error"] = truncateError(err.Error())
+		log.Printf("[ProviderUsage] Failed to sync usage %d of provider config %d: %v", sync.ID, sync.ProviderConfigID, err)
+	} else {
+		updates["last_synced_at"] = now
+	}
+	if saveErr := s.db.Model(sync).Updates(updates).Error; saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// SyncAll pulls the usage of all active provider usage syncs
+func (s *ProviderUsageSyncService) SyncAll(ctx context.Context) {
+	var syncs []database.ProviderUsageSync
+	if err := s.db.Where("is_active = ?", true).Find(&syncs).Error; err != nil {
+		log.Printf("[ProviderUsage] Failed to load provider usage syncs: %v", err)
+		return
+	}
+	for i := range syncs {
+		if ctx.Err() != nil {
+			return
+		}
+		s.Sync(ctx, &syncs[i])
+	}
+}
+
+// Run pulls provider usage periodically until ctx is cancelled
+func (s *ProviderUsageSyncService) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.cfg.ProviderUsageSyncInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.SyncAll(ctx)
+		}
+	}
+}
+
+// Reconcile reports, per UTC day since the given time, the drift between the
+// usage pulled from the provider and the usage the gateway recorded for the
+// provider config
+func (s *ProviderUsageSyncService) Reconcile(userID, id uint, since time.Time) (*ProviderUsageReconciliation, error) {
+	sync, err := s.GetSync(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	var providerConfig database.ProviderConfig
+	if err := s.db.First(&providerConfig, sync.ProviderConfigID).Error; err != nil {
+		return nil, err
+	}
+
+	report := &ProviderUsageReconciliation{
+		SyncID:           sync.ID,
+		ProviderConfigID: sync.ProviderConfigID,
+		Provider:         providerConfig.Provider,
+		Start:            since.UTC().Truncate(24 * time.Hour),
+		End:              time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1),
+	}
+
+	var pulled []database.ProviderUsageDay
+	if err := s.db.Where("sync_id = ? AND date >= ? AND date < ?", sync.ID, report.Start, report.End).Find(&pulled).Error; err != nil {
+		return nil, err
+	}
+	byDate := make(map[string]database.ProviderUsageDay, len(pulled))
+	for _, day := range pulled {
+		byDate[day.Date.UTC().Format(time.DateOnly)] = day
+	}
+
+	var totalRequests, totalRequestDrift int64
+	requestsReported := false
+	for date := report.Start; date.Before(report.End); date = date.AddDate(0, 0, 1) {
+		date := date
+		var gateway struct {
+			Requests     int64
+			InputTokens  int64
+			OutputTokens int64
+		}
+		err := s.db.Model(&database.UsageRecord{}).
+			Select("COUNT(*) AS requests, COALESCE(SUM(prompt_tokens), 0) AS input_tokens, COALESCE(SUM(completion_tokens), 0) AS output_tokens").
+			Where("provider_config_id = ? AND created_at >= ? AND created_at < ?", sync.ProviderConfigID, date, date.AddDate(0, 0, 1)).
+			Scan(&gateway).Error
+		if err != nil {
+			return nil, err
+		}
+
+		day := ProviderUsageDrift{
+			Date:                &date,
+			GatewayInputTokens:  gateway.InputTokens,
+			GatewayOutputTokens: gateway.OutputTokens,
+			GatewayRequests:     gateway.Requests,
+		}
+		if upstream, ok := byDate[date.Format(time.DateOnly)]; ok {
+			day.Synced = true
+			day.UpstreamInputTokens = upstream.InputTokens
+			day.UpstreamOutputTokens = upstream.OutputTokens
+			day.UpstreamRequests = upstream.Requests
+			day.InputTokenDrift = upstream.InputTokens - gateway.InputTokens
+			day.OutputTokenDrift = upstream.OutputTokens - gateway.OutputTokens
+			if upstream.Requests != nil {
+				drift := *upstream.Requests - gateway.Requests
+				day.RequestDrift = &drift
+				requestsReported = true
+				totalRequests += *upstream.Requests
+				totalRequestDrift += drift
+			}
+
+			report.Total.UpstreamInputTokens += day.UpstreamInputTokens
+			report.Total.UpstreamOutputTokens += day.UpstreamOutputTokens
+			report.Total.InputTokenDrift += day.InputTokenDrift
+			report.Total.OutputTokenDrift += day.OutputTokenDrift
+		}
+		report.Total.GatewayInputTokens += day.GatewayInputTokens
+		report.Total.GatewayOutputTokens += day.GatewayOutputTokens
+		report.Total.GatewayRequests += day.GatewayRequests
+		report.Days = append(report.Days, day)
+	}
+	report.Total.Synced = len(pulled) > 0
+	if requestsReported {
+		report.Total.UpstreamRequests = &totalRequests
+		report.Total.RequestDrift = &totalRequestDrift
+	}
+	return report, nil
+}
+
+// fetchOpenAI reads daily completions usage from OpenAI's organization usage API
+func (s *ProviderUsageSyncService) fetchOpenAI(ctx context.Context, sync *database.ProviderUsageSync, start, end time.Time) ([]database.ProviderUsageDay, error) {
+	params := url.Values{
+		"start_time":   {strconv.FormatInt(start.Unix(), 10)},
+		"end_time":     {strconv.FormatInt(end.Unix(), 10)},
+		"bucket_width": {"1d"},
+		"limit":        {"31"},
+	}
+	if sync.UpstreamKeyID != "" {
+		params.Set("api_key_ids", sync.UpstreamKeyID)
+	}
+
+	var days []database.ProviderUsageDay
+	for page := 0; page < providerUsageMaxPages; page++ {
+		var resp struct {
+			Data []struct {
+				StartTime int64 `json:"start_time"`
+				Results   []struct {
+					InputTokens      int64 `json:"input_tokens"`
+					OutputTokens     int64 `json:"output_tokens"`
+					NumModelRequests int64 `json:"num_model_requests"`
+				} `json:"results"`
+			} `json:"data"`
+			HasMore  bool   `json:"has_more"`
+			NextPage string `json:"next_page"`
+		}
+		endpoint := strings.TrimRight(s.cfg.OpenAIBaseURL, "/") + "/organization/usage/completions?" + params.Encode()
+		if err := s.call(ctx, sync, "openai", endpoint, &resp); err != nil {
+			return nil, err
+		}
+		for _, bucket := range resp.Data {
+			day := database.ProviderUsageDay{SyncID: sync.ID, Date: time.Unix(bucket.StartTime, 0).UTC()}
+			var requests int64
+			for _, result := range bucket.Results {
+				day.InputTokens += result.InputTokens
+				day.OutputTokens += result.OutputTokens
+				requests += result.NumModelRequests
+			}
+			day.Requests = &requests
+			days = append(days, day)
+		}
+		if !resp.HasMore || resp.NextPage == "" {
+			break
+		}
+		params.Set("page", resp.NextPage)
+	}
+	return days, nil
+}
+
+// fetchAnthropic reads daily messages usage from Anthropic's usage report,
+// which has no request counts
+func (s *ProviderUsageSyncService) fetchAnthropic(ctx context.Context, sync *database.ProviderUsageSync, start, end time.Time) ([]database.ProviderUsageDay, error) {
+	params := url.Values{
+		"starting_at":  {start.Format(time.RFC3339)},
+		"ending_at":    {end.Format(time.RFC3339)},
+		"bucket_width": {"1d"},
+		"limit":        {"31"},
+	}
+	if sync.UpstreamKeyID != "" {
+		params.Set("api_key_ids[]", sync.UpstreamKeyID)
+	}
+
+	var days []database.ProviderUsageDay
+	for page := 0; page < providerUsageMaxPages; page++ {
+		var resp struct {
+			Data []struct {
+				StartingAt time.Time `json:"starting_at"`
+				Results    []struct {
+					UncachedInputTokens  int64 `json:"uncached_input_tokens"`
+					CacheReadInputTokens int64 `json:"cache_read_input_tokens"`
+					CacheCreation        struct {
+						Ephemeral1hInputTokens int64 `json:"ephemeral_1h_input_tokens"`
+						Ephemeral5mInputTokens int64 `json:"ephemeral_5m_input_tokens"`
+					} `json:"cache_creation"`
+					OutputTokens int64 `json:"output_tokens"`
+				} `json:"results"`
+			} `json:"data"`
+			HasMore  bool   `json:"has_more"`
+			NextPage string `json:"next_page"`
+		}
+		endpoint := strings.TrimRight(s.cfg.AnthropicBaseURL, "/") + "/organizations/usage_report/messages?" + params.Encode()
+		if err := s.call(ctx, sync, "anthropic", endpoint, &resp); err != nil {
+			return nil, err
+		}
+		for _, bucket := range resp.Data {
+			day := database.ProviderUsageDay{SyncID: sync.ID, Date: bucket.StartingAt.UTC()}
+			for _, result := range bucket.Results {
+				day.InputTokens += result.UncachedInputTokens + result.CacheReadInputTokens +
+					result.CacheCreation.Ephemeral1hInputTokens + result.CacheCreation.Ephemeral5mInputTokens
+				day.OutputTokens += result.OutputTokens
+			}
+			days = append(days, day)
+		}
+		if !resp.HasMore || resp.NextPage == "" {
+			break
+		}
+		params.Set("page", resp.NextPage)
+	}
+	return days, nil
+}
+
+// call sends a GET request to a provider's admin API with the sync's admin key
+// and decodes the response into out
+func (s *ProviderUsageSyncService) call(ctx context.Context, sync *database.ProviderUsageSync, provider, endpoint string, out interface{}) error {
+	encKey, err := s.cfg.GetEncryptionKeyBytes()
+	if err != nil {
+		return err
+	}
+	adminKey, err := utils.DecryptAPIKey(sync.EncryptedAdminKey, encKey)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if provider == "anthropic" {
+		req.Header.Set("x-api-key", adminKey)
+		req.Header.Set("anthropic-version", "2023-06-01")
+	} else {
+		req.Header.Set("Authorization", "Bearer "+adminKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("usage API error (%d): %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return fmt.Errorf("usage API error (%d)", resp.StatusCode)
+	}
+	return json.Unmarshal(data, out)
+}
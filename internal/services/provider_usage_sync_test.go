@@ -0,0 +1,291 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+
+	"gorm.io/gorm"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	db, err := database.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		JWTSecret:     "secret",
+		EncryptionKey: base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")),
+	}
+}
+
+// usageSyncFixture creates a user with a provider config of provider and a
+// usage sync of it with the admin key "admin-key"
+func usageSyncFixture(t *testing.T, db *gorm.DB, svc *ProviderUsageSyncService, provider string) (*database.User, *database.ProviderUsageSync) {
+	user := &database.User{Username: "u", Email: "u@example.com", HashedPassword: "x", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	providerConfig := &database.ProviderConfig{UserID: user.ID, Provider: provider, Name: provider, EncryptedKey: "x"}
+	if err := db.Create(providerConfig).Error; err != nil {
+		t.Fatal(err)
+	}
+	adminKey := "admin-key"
+	sync, err := svc.CreateSync(user.ID, &ProviderUsageSyncRequest{ProviderConfigID: providerConfig.ID, AdminKey: &adminKey, UpstreamKeyID: " key_1 "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, sync
+}
+
+func utcDay(daysAgo int) time.Time {
+	return time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -daysAgo)
+}
+
+func TestProviderUsageSync_OpenAIPagesAndReconcile(t *testing.T) {
+	var pages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/organization/usage/completions" || r.Header.Get("Authorization") != "Bearer admin-key" ||
+			r.URL.Query().Get("api_key_ids") != "key_1" || r.URL.Query().Get("bucket_width") != "1d" {
+			t.Errorf("unexpected request %s %v", r.URL, r.Header)
+		}
+		page := r.URL.Query().Get("page")
+		pages = append(pages, page)
+		if page == "" {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": []interface{}{map[string]interface{}{
+					"start_time": utcDay(1).Unix(),
+					"results": []interface{}{
+						map[string]interface{}{"input_tokens": 100, "output_tokens": 10, "num_model_requests": 2},
+						map[string]interface{}{"input_tokens": 50, "output_tokens": 5, "num_model_requests": 1},
+					},
+				}},
+				"has_more":  true,
+				"next_page": "page_2",
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": []interface{}{map[string]interface{}{
+				"start_time": utcDay(0).Unix(),
+				"results":    []interface{}{map[string]interface{}{"input_tokens": 40, "output_tokens": 4, "num_model_requests": 1}},
+			}},
+			"has_more": false,
+		})
+	}))
+	defer server.Close()
+
+	db := testDB(t)
+	cfg := testConfig()
+	cfg.OpenAIBaseURL = server.URL + "/"
+	cfg.ProviderUsageSyncDays = 2
+	svc := NewProviderUsageSyncService(db, cfg)
+	user, sync := usageSyncFixture(t, db, svc, "openai")
+
+	// The gateway recorded two of the three requests of yesterday
+	key := &database.APIKey{UserID: user.ID, Name: "k", KeyHash: "hash", KeyPrefix: "sk-k", IsActive: true}
+	if err := db.Create(key).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		record := &database.UsageRecord{APIKeyID: key.ID, PromptTokens: 60, CompletionTokens: 6, ProviderConfigID: &sync.ProviderConfigID, CreatedAt: utcDay(1).Add(time.Hour)}
+		if err := db.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := svc.Sync(context.Background(), sync); err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 || pages[1] != "page_2" {
+		t.Fatalf("pages requested = %q", pages)
+	}
+	stored, _ := svc.GetSync(user.ID, sync.ID)
+	if stored.LastSyncedAt == nil || stored.LastError != "" {
+		t.Fatalf("sync state after a pull = %+v", stored)
+	}
+
+	report, err := svc.Reconcile(user.ID, sync.ID, utcDay(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Days) != 2 || report.Provider != "openai" {
+		t.Fatalf("report = %+v", report)
+	}
+	yesterday := report.Days[0]
+	if !yesterday.Synced || yesterday.UpstreamInputTokens != 150 || yesterday.GatewayInputTokens != 120 ||
+		yesterday.InputTokenDrift != 30 || yesterday.OutputTokenDrift != 3 ||
+		yesterday.RequestDrift == nil || *yesterday.RequestDrift != 1 {
+		t.Errorf("yesterday's drift = %+v", yesterday)
+	}
+	if report.Total.InputTokenDrift != 70 || report.Total.RequestDrift == nil || *report.Total.RequestDrift != 2 {
+		t.Errorf("total drift = %+v", report.Total)
+	}
+}
+
+func TestProviderUsageSync_AnthropicCountsCacheTokens(t *testing.T) {
+	calls := 0
+	input := int64(100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/organizations/usage_report/messages" || r.Header.Get("x-api-key") != "admin-key" ||
+			r.URL.Query().Get("api_key_ids[]") != "key_1" {
+			t.Errorf("unexpected request %s %v", r.URL, r.Header)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": []interface{}{map[string]interface{}{
+				"starting_at": utcDay(0).Format(time.RFC3339),
+				"results": []interface{}{map[string]interface{}{
+					"uncached_input_tokens":   input,
+					"cache_read_input_tokens": 20,
+					"cache_creation":          map[string]interface{}{"ephemeral_1h_input_tokens": 3, "ephemeral_5m_input_tokens": 2},
+					"output_tokens":           7,
+				}},
+			}},
+		})
+	}))
+	defer server.Close()
+
+	db := testDB(t)
+	cfg := testConfig()
+	cfg.AnthropicBaseURL = server.URL
+	svc := NewProviderUsageSyncService(db, cfg)
+	_, sync := usageSyncFixture(t, db, svc, "anthropic")
+
+	// A second pull replaces the day stored by the first
+	for _, uncached := range []int64{100, 200} {
+		input = uncached
+		if err := svc.Sync(context.Background(), sync); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var days []database.ProviderUsageDay
+	db.Where("sync_id = ?", sync.ID).Find(&days)
+	if calls != 2 || len(days) != 1 {
+		t.Fatalf("calls = %d, stored days = %d", calls, len(days))
+	}
+	if days[0].InputTokens != 225 || days[0].OutputTokens != 7 || days[0].Requests != nil {
+		t.Errorf("stored day = %+v", days[0])
+	}
+}
+
+func TestProviderUsageSync_RecordsUpstreamErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"message":"invalid admin key"}}`))
+	}))
+	defer server.Close()
+
+	db := testDB(t)
+	cfg := testConfig()
+	cfg.OpenAIBaseURL = server.URL
+	svc := NewProviderUsageSyncService(db, cfg)
+	user, sync := usageSyncFixture(t, db, svc, "openai")
+
+	err := svc.Sync(context.Background(), sync)
+	if err == nil || !strings.Contains(err.Error(), "invalid admin key") {
+		t.Fatalf("Sync() = %v", err)
+	}
+	stored, _ := svc.GetSync(user.ID, sync.ID)
+	if stored.LastSyncedAt != nil || !strings.Contains(stored.LastError, "(401)") {
+		t.Errorf("sync state after a failed pull = %+v", stored)
+	}
+}
+
+func TestProviderUsageSync_Settings(t *testing.T) {
+	db := testDB(t)
+	svc := NewProviderUsageSyncService(db, testConfig())
+	user, sync := usageSyncFixture(t, db, svc, "openai")
+	if sync.UpstreamKeyID != "key_1" || sync.EncryptedAdminKey == "" || sync.EncryptedAdminKey == "admin-key" {
+		t.Fatalf("created sync = %+v", sync)
+	}
+
+	gemini := &database.ProviderConfig{UserID: user.ID, Provider: "gemini", Name: "gemini", EncryptedKey: "x"}
+	other := &database.ProviderConfig{UserID: user.ID + 1, Provider: "openai", Name: "other", EncryptedKey: "x"}
+	db.Create(gemini)
+	db.Create(other)
+	adminKey, blank := "admin-key", " "
+	tests := []struct {
+		name string
+		req  ProviderUsageSyncRequest
+		want string
+	}{
+		{"unsupported provider", ProviderUsageSyncRequest{ProviderConfigID: gemini.ID, AdminKey: &adminKey}, "not supported for gemini"},
+		{"another user's config", ProviderUsageSyncRequest{ProviderConfigID: other.ID, AdminKey: &adminKey}, "provider config not found"},
+		{"blank admin key", ProviderUsageSyncRequest{ProviderConfigID: sync.ProviderConfigID, AdminKey: &blank}, "must not be empty"},
+		{"missing admin key", ProviderUsageSyncRequest{ProviderConfigID: sync.ProviderConfigID}, "admin_key is required"},
+		{"config already synced", ProviderUsageSyncRequest{ProviderConfigID: sync.ProviderConfigID, AdminKey: &adminKey}, "already has a usage sync"},
+	}
+	for _, tt := range tests {
+		if _, err := svc.CreateSync(user.ID, &tt.req); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: CreateSync() = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// Updates keep the admin key unless one is given, and never move the sync
+	inactive := false
+	updated, err := svc.UpdateSync(user.ID, sync.ID, &ProviderUsageSyncRequest{ProviderConfigID: sync.ProviderConfigID, IsActive: &inactive})
+	if err != nil || updated.IsActive || updated.EncryptedAdminKey != sync.EncryptedAdminKey || updated.UpstreamKeyID != "" {
+		t.Fatalf("UpdateSync() = %+v, %v", updated, err)
+	}
+	if _, err := svc.UpdateSync(user.ID, sync.ID, &ProviderUsageSyncRequest{ProviderConfigID: gemini.ID}); err == nil {
+		t.Error("UpdateSync() must refuse to change the provider config")
+	}
+
+	db.Create(&database.ProviderUsageDay{SyncID: sync.ID, Date: utcDay(0), InputTokens: 1})
+	if err := svc.DeleteSync(user.ID+1, sync.ID); err != ErrProviderUsageSyncNotFound {
+		t.Errorf("DeleteSync() of another user = %v", err)
+	}
+	if err := svc.DeleteSync(user.ID, sync.ID); err != nil {
+		t.Fatal(err)
+	}
+	var days int64
+	db.Model(&database.ProviderUsageDay{}).Where("sync_id = ?", sync.ID).Count(&days)
+	if days != 0 {
+		t.Errorf("pulled days left after DeleteSync() = %d", days)
+	}
+}
+
+func TestProviderUsageSync_AccountExportAndPurge(t *testing.T) {
+	db := testDB(t)
+	cfg := testConfig()
+	svc := NewProviderUsageSyncService(db, cfg)
+	user, sync := usageSyncFixture(t, db, svc, "anthropic")
+	db.Create(&database.ProviderUsageDay{SyncID: sync.ID, Date: utcDay(0), InputTokens: 1})
+	accounts := NewAccountService(db, cfg, events.NewBus(), NewArchiveService(db, cfg))
+
+	export, err := accounts.Export(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(export.ProviderUsageSyncs) != 1 || len(export.ProviderUsageDays) != 1 {
+		t.Fatalf("exported %d syncs and %d days", len(export.ProviderUsageSyncs), len(export.ProviderUsageDays))
+	}
+	if data, _ := json.Marshal(export); strings.Contains(string(data), sync.EncryptedAdminKey) {
+		t.Error("the export must not contain the admin key")
+	}
+
+	if err := accounts.PurgeUser(context.Background(), user.ID); err != nil {
+		t.Fatal(err)
+	}
+	var syncs, days int64
+	db.Model(&database.ProviderUsageSync{}).Count(&syncs)
+	db.Model(&database.ProviderUsageDay{}).Count(&days)
+	if syncs != 0 || days != 0 {
+		t.Errorf("left after purge: %d syncs, %d days", syncs, days)
+	}
+}
@@ -0,0 +1,382 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// Outcomes of provisioning a resource by name: provisioning tools PUT the
+// desired state of a resource under its name, and it is created, updated to
+// match, or left alone when it already does
+const (
+	ProvisionCreated   = "created"
+	ProvisionUpdated   = "updated"
+	ProvisionUnchanged = "unchanged"
+)
+
+// ErrNameNotUnique is returned when provisioning by a name more than one of a
+// user's resources has
+var ErrNameNotUnique = errors.New("more than one resource has this name; rename or delete the others first")
+
+// ErrProviderImmutable is returned when provisioning a provider config under
+// the name of a config of another provider
+var ErrProviderImmutable = errors.New("a provider config with this name exists for another provider")
+
+// findByName loads the one resource of a user with a name into dest, reporting
+// false when there is none
+func findByName(db *gorm.DB, userID uint, name string, dest interface{}) (bool, error) {
+	result := db.Where("user_id = ? AND name = ?", userID, name).Limit(2).Find(dest)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	switch result.RowsAffected {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	default:
+		return false, ErrNameNotUnique
+	}
+}
+
+// ProvisionConfig creates the provider config named req.Name, or updates the
+// existing one to match req. An existing config keeps its ID, default and
+// active flags and spend counters.
+func (s *ConfigService) ProvisionConfig(userID uint, req *ProviderConfigCreate) (*database.ProviderConfig, string, error) {
+	var configs []database.ProviderConfig
+	found, err := findByName(s.db, userID, strings.TrimSpace(req.Name), &configs)
+	if err != nil {
+		return nil, "", err
+	}
+	if !found {
+		cfg, err := s.CreateConfig(userID, req)
+		return cfg, ProvisionCreated, err
+	}
+
+	cfg := &configs[0]
+	if cfg.Provider != req.Provider {
+		return nil, "", ErrProviderImmutable
+	}
+	update, err := s.configDiff(cfg, req)
+	if err != nil {
+		return nil, "", err
+	}
+	if update == nil {
+		return cfg, ProvisionUnchanged, nil
+	}
+	cfg, err = s.UpdateConfig(userID, cfg.ID, update)
+	return cfg, ProvisionUpdated, err
+}
+
+// configDiff returns the update bringing cfg to the state req describes, or
+// nil when it is already there
+func (s *ConfigService) configDiff(cfg *database.ProviderConfig, req *ProviderConfigCreate) (*ProviderConfigUpdate, error) {
+	update := &ProviderConfigUpdate{}
+	changed := false
+
+	baseURL, err := s.baseURLFor(req.Provider, req.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if baseURL != cfg.BaseURL {
+		update.BaseURL, changed = &baseURL, true
+	}
+	if protocol := normalizeProtocol(strings.TrimSpace(req.Protocol)); protocol != normalizeProtocol(cfg.Protocol) {
+		update.Protocol, changed = &protocol, true
+	}
+	if region := strings.TrimSpace(req.Region); region != cfg.Region {
+		update.Region, changed = &region, true
+	}
+	if apiKey, err := s.DecryptAPIKey(cfg); err != nil || apiKey != req.APIKey {
+		update.APIKey, changed = &req.APIKey, true
+	}
+	if current, _ := s.GetModelCodes(cfg); !sameStrings(current, req.ModelCodes) {
+		update.ModelCodes, changed = append([]string{}, req.ModelCodes...), true
+	}
+	if req.ReasoningEffort != cfg.ReasoningEffort {
+		update.ReasoningEffort, changed = &req.ReasoningEffort, true
+	}
+	if req.ReasoningSummary != cfg.ReasoningSummary {
+		update.ReasoningSummary, changed = &req.ReasoningSummary, true
+	}
+	if req.ReasoningOverride != cfg.ReasoningOverride {
+		update.ReasoningOverride, changed = &req.ReasoningOverride, true
+	}
+
+	// Unset and 0 both mean no override or cap, which updates express as 0
+	if want := intOrZero(req.MaxOutputTokens); want != intOrZero(cfg.MaxOutputTokens) {
+		update.MaxOutputTokens, changed = &want, true
+	}
+	if want := intOrZero(req.MonthlyTokenCap); want != intOrZero(cfg.MonthlyTokenCap) {
+		update.MonthlyTokenCap, changed = &want, true
+	}
+	if want := floatOrZero(req.MonthlyCostCap); want != floatOrZero(cfg.MonthlyCostCap) {
+		update.MonthlyCostCap, changed = &want, true
+	}
+	if schedule := strings.TrimSpace(req.ActiveSchedule); schedule != cfg.ActiveSchedule {
+		update.ActiveSchedule, changed = &schedule, true
+	}
+	if timezone := strings.TrimSpace(req.ScheduleTimezone); timezone != cfg.ScheduleTimezone {
+		update.ScheduleTimezone, changed = &timezone, true
+	}
+
+	if !changed {
+		return nil, nil
+	}
+	return update, nil
+}
+
+// ProvisionAPIKey creates the API key named req.Name, returning the full key,
+// or updates the existing one to match req. An existing key keeps its ID,
+// secret, active flag and usage counters.
+func (s *APIKeyService) ProvisionAPIKey(userID uint, req *APIKeyCreate) (*database.APIKey, string, string, error) {
+	var keys []database.APIKey
+	found, err := findByName(s.db.Preload("ProviderConfigs"), userID, req.Name, &keys)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if !found {
+		key, fullKey, err := s.CreateAPIKey(userID, req)
+		return key, fullKey, ProvisionCreated, err
+	}
+
+	key := &keys[0]
+	var configs []database.ProviderConfig
+	if err := s.db.Where("id IN ? AND user_id = ?", req.ProviderConfigIDs, userID).Find(&configs).Error; err != nil {
+		return nil, "", "", err
+	}
+	if len(configs) != len(req.ProviderConfigIDs) {
+		return nil, "", "", errors.New("one or more provider configs not found")
+	}
+	updates, err := apiKeyDiff(key, req)
+	if err != nil {
+		return nil, "", "", err
+	}
+	configsChanged := !sameConfigIDs(key.ProviderConfigs, configs)
+	if len(updates) == 0 && !configsChanged {
+		return key, "", ProvisionUnchanged, nil
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			if err := tx.Model(key).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if configsChanged {
+			return tx.Model(key).Association("ProviderConfigs").Replace(configs)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", "", err
+	}
+	s.publishChange(userID, key.ID, "update")
+
+	key, err = s.GetAPIKeyByID(userID, key.ID)
+	return key, "", ProvisionUpdated, err
+}
+
+// apiKeyDiff returns the column updates bringing key to the state req
+// describes; unset limits and expiry are cleared
+func apiKeyDiff(key *database.APIKey, req *APIKeyCreate) (map[string]interface{}, error) {
+	routingStrategy, err := normalizeRoutingStrategy(req.RoutingStrategy)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSchemaRepairRetries(req.SchemaRepairRetries); err != nil {
+		return nil, err
+	}
+	if err := validateLatencyBudget(req.LatencyBudgetMs); err != nil {
+		return nil, err
+	}
+	compressionModel := strings.TrimSpace(req.CompressionModel)
+	if err := validateCompression(req.CompressionTokens, compressionModel); err != nil {
+		return nil, err
+	}
+	if err := validateResponsePolicy(req.MaxResponseTokens, req.ResponseSuffix); err != nil {
+		return nil, err
+	}
+	forbiddenLanguages, err := NormalizeLanguages(req.ForbiddenLanguages)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateInjectionThreshold(req.InjectionThreshold); err != nil {
+		return nil, err
+	}
+	if err := validateAutoContinueTokens(req.AutoContinueTokens); err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if !sameTimePtr(key.ExpiresAt, req.ExpiresAt) {
+		updates["expires_at"] = req.ExpiresAt
+	}
+	for column, limits := range map[string][2]*int{
+		"daily_request_limit":   {key.DailyRequestLimit, req.DailyRequestLimit},
+		"monthly_request_limit": {key.MonthlyRequestLimit, req.MonthlyRequestLimit},
+		"daily_token_limit":     {key.DailyTokenLimit, req.DailyTokenLimit},
+		"monthly_token_limit":   {key.MonthlyTokenLimit, req.MonthlyTokenLimit},
+	} {
+		if !sameIntPtr(limits[0], limits[1]) {
+			updates[column] = limits[1]
+		}
+	}
+	for column, values := range map[string][2]bool{
+		"archive_enabled":       {key.ArchiveEnabled, req.ArchiveEnabled},
+		"body_log_disabled":     {key.BodyLogDisabled, req.BodyLogDisabled},
+		"allow_config_override": {key.AllowConfigOverride, req.AllowConfigOverride},
+		"keep_requested_model":  {key.KeepRequestedModel, req.KeepRequestedModel},
+		"strict_conversion":     {key.StrictConversion, req.StrictConversion},
+		"validate_tool_args":    {key.ValidateToolArgs, req.ValidateToolArgs},
+		"injection_detection":   {key.InjectionDetection, req.InjectionDetection},
+		"salvage_streams":       {key.SalvageStreams, req.SalvageStreams},
+	} {
+		if values[0] != values[1] {
+			updates[column] = values[1]
+		}
+	}
+	if routingStrategy != key.RoutingStrategy {
+		updates["routing_strategy"] = routingStrategy
+	}
+	if req.SchemaRepairRetries != key.SchemaRepairRetries {
+		updates["schema_repair_retries"] = req.SchemaRepairRetries
+	}
+	if req.LatencyBudgetMs != key.LatencyBudgetMs {
+		updates["latency_budget_ms"] = req.LatencyBudgetMs
+	}
+	if req.CompressionTokens != key.CompressionTokens {
+		updates["compression_tokens"] = req.CompressionTokens
+	}
+	if compressionModel != key.CompressionModel {
+		updates["compression_model"] = compressionModel
+	}
+	if req.MaxResponseTokens != key.MaxResponseTokens {
+		updates["max_response_tokens"] = req.MaxResponseTokens
+	}
+	if forbiddenLanguages != key.ForbiddenLanguages {
+		updates["forbidden_languages"] = forbiddenLanguages
+	}
+	if req.ResponseSuffix != key.ResponseSuffix {
+		updates["response_suffix"] = req.ResponseSuffix
+	}
+	if req.InjectionThreshold != key.InjectionThreshold {
+		updates["injection_threshold"] = req.InjectionThreshold
+	}
+	if req.AutoContinueTokens != key.AutoContinueTokens {
+		updates["auto_continue_tokens"] = req.AutoContinueTokens
+	}
+	return updates, nil
+}
+
+// ProvisionPolicy creates the routing policy named req.Name, or updates the
+// existing one to match req
+func (s *RoutingPolicyService) ProvisionPolicy(userID uint, req *RoutingPolicyRequest) (*database.RoutingPolicy, string, error) {
+	var policies []database.RoutingPolicy
+	found, err := findByName(s.db, userID, strings.TrimSpace(req.Name), &policies)
+	if err != nil {
+		return nil, "", err
+	}
+	if !found {
+		policy, err := s.CreatePolicy(userID, req)
+		return policy, ProvisionCreated, err
+	}
+
+	policy := policies[0]
+	desired := policy
+	if err := s.apply(&desired, req); err != nil {
+		return nil, "", err
+	}
+	if sameRoutingPolicy(&policy, &desired) {
+		return &policy, ProvisionUnchanged, nil
+	}
+	if err := s.db.Save(&desired).Error; err != nil {
+		return nil, "", err
+	}
+	s.invalidate(userID)
+	return &desired, ProvisionUpdated, nil
+}
+
+// sameRoutingPolicy reports whether two versions of a policy have the same
+// settings
+func sameRoutingPolicy(a, b *database.RoutingPolicy) bool {
+	return a.Name == b.Name &&
+		a.Priority == b.Priority &&
+		a.Condition == b.Condition &&
+		a.Timezone == b.Timezone &&
+		a.Action == b.Action &&
+		sameUintPtr(a.ProviderConfigID, b.ProviderConfigID) &&
+		a.Model == b.Model &&
+		a.KeepRequestedModel == b.KeepRequestedModel &&
+		a.Message == b.Message &&
+		a.IsActive == b.IsActive
+}
+
+// sameStrings reports whether two lists hold the same strings in the same
+// order; nil and empty lists are the same
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameConfigIDs reports whether two lists hold the same provider configs in
+// any order
+func sameConfigIDs(a, b []database.ProviderConfig) bool {
+	ids := func(configs []database.ProviderConfig) []int {
+		out := make([]int, len(configs))
+		for i, cfg := range configs {
+			out[i] = int(cfg.ID)
+		}
+		sort.Ints(out)
+		return out
+	}
+	x, y := ids(a), ids(b)
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameTimePtr(a, b *time.Time) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && a.Equal(*b))
+}
+
+func sameIntPtr(a, b *int) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func sameUintPtr(a, b *uint) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func intOrZero(v *int) int {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+func floatOrZero(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
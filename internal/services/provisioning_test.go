@@ -0,0 +1,200 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+)
+
+// provisioningFixture returns the config and API key services of a new user
+func provisioningFixture(t *testing.T) (*ConfigService, *APIKeyService, *database.User) {
+	db := testDB(t)
+	cfg := testConfig()
+	bus := events.NewBus()
+	cache := NewCredentialCache(cfg, bus)
+	user := &database.User{Username: "u", Email: "u@example.com", HashedPassword: "x", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return NewConfigService(db, cfg, bus, cache), NewAPIKeyService(db, cfg, bus, cache), user
+}
+
+func TestProvisioning_Config(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	floatPtr := func(v float64) *float64 { return &v }
+	svc, _, user := provisioningFixture(t)
+
+	// Each step provisions "main" on the state the previous steps left
+	steps := []struct {
+		name    string
+		req     ProviderConfigCreate
+		want    string
+		wantErr error
+	}{
+		{"create", ProviderConfigCreate{Provider: "openai", APIKey: "sk-a", ModelCodes: []string{"gpt-4o"}}, ProvisionCreated, nil},
+		{"same state", ProviderConfigCreate{Provider: "openai", APIKey: "sk-a", ModelCodes: []string{"gpt-4o"}}, ProvisionUnchanged, nil},
+		{"zero caps are unset", ProviderConfigCreate{Provider: "openai", APIKey: "sk-a", ModelCodes: []string{"gpt-4o"},
+			MaxOutputTokens: intPtr(0), MonthlyCostCap: floatPtr(0)}, ProvisionUnchanged, nil},
+		{"new models", ProviderConfigCreate{Provider: "openai", APIKey: "sk-a", ModelCodes: []string{"gpt-4o", "gpt-4o-mini"}}, ProvisionUpdated, nil},
+		{"new key and cap", ProviderConfigCreate{Provider: "openai", APIKey: "sk-b", ModelCodes: []string{"gpt-4o", "gpt-4o-mini"},
+			MonthlyTokenCap: intPtr(1000)}, ProvisionUpdated, nil},
+		{"cap removed", ProviderConfigCreate{Provider: "openai", APIKey: "sk-b", ModelCodes: []string{"gpt-4o", "gpt-4o-mini"}}, ProvisionUpdated, nil},
+		{"other provider", ProviderConfigCreate{Provider: "anthropic", APIKey: "sk-b"}, "", ErrProviderImmutable},
+	}
+	var id uint
+	for _, step := range steps {
+		step.req.Name = "main"
+		cfg, result, err := svc.ProvisionConfig(user.ID, &step.req)
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: err = %v, want %v", step.name, err, step.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if result != step.want {
+			t.Errorf("%s: result = %q, want %q", step.name, result, step.want)
+		}
+		if id == 0 {
+			id = cfg.ID
+		} else if cfg.ID != id {
+			t.Errorf("%s: provisioned config %d, want the existing config %d", step.name, cfg.ID, id)
+		}
+		apiKey, _ := svc.DecryptAPIKey(cfg)
+		modelCodes, _ := svc.GetModelCodes(cfg)
+		if apiKey != step.req.APIKey || !sameStrings(modelCodes, step.req.ModelCodes) || !sameIntPtr(cfg.MonthlyTokenCap, step.req.MonthlyTokenCap) {
+			t.Errorf("%s: config holds key %q, models %v and cap %v, want the requested ones", step.name, apiKey, modelCodes, cfg.MonthlyTokenCap)
+		}
+	}
+}
+
+func TestProvisioning_ConfigRepeatedIsUnchanged(t *testing.T) {
+	tests := []struct {
+		name string
+		req  ProviderConfigCreate
+	}{
+		{"plain", ProviderConfigCreate{Provider: "openai", Name: "main", Region: "us-east-1", APIKey: "sk-a"}},
+		{"padded", ProviderConfigCreate{Provider: "openai", Name: " main ", Region: " us-east-1 ",
+			BaseURL: " https://api.example.com/v1 ", Protocol: " openai_chat ", ActiveSchedule: " hour >= 9 ", APIKey: "sk-a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _, user := provisioningFixture(t)
+			created, result, err := svc.ProvisionConfig(user.ID, &tt.req)
+			if err != nil || result != ProvisionCreated {
+				t.Fatalf("first provisioning: %q, %v", result, err)
+			}
+			if created.Name != "main" || created.Region != "us-east-1" {
+				t.Errorf("created config named %q in region %q, want them trimmed", created.Name, created.Region)
+			}
+			again, result, err := svc.ProvisionConfig(user.ID, &tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != ProvisionUnchanged || again.ID != created.ID {
+				t.Errorf("repeated provisioning: %q of config %d, want config %d unchanged", result, again.ID, created.ID)
+			}
+		})
+	}
+}
+
+func TestProvisioning_ConfigNameNotUnique(t *testing.T) {
+	svc, _, user := provisioningFixture(t)
+	for i := 0; i < 2; i++ {
+		if err := svc.db.Create(&database.ProviderConfig{UserID: user.ID, Provider: "openai", Name: "main", EncryptedKey: "x"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _, err := svc.ProvisionConfig(user.ID, &ProviderConfigCreate{Provider: "openai", Name: "main", APIKey: "sk-a"})
+	if !errors.Is(err, ErrNameNotUnique) {
+		t.Errorf("err = %v, want ErrNameNotUnique", err)
+	}
+}
+
+func TestProvisioning_APIKey(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	configs, keys, user := provisioningFixture(t)
+	var configIDs []uint
+	for _, name := range []string{"a", "b"} {
+		cfg, err := configs.CreateConfig(user.ID, &ProviderConfigCreate{Provider: "openai", Name: name, APIKey: "sk-" + name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		configIDs = append(configIDs, cfg.ID)
+	}
+
+	steps := []struct {
+		name    string
+		req     APIKeyCreate
+		want    string
+		wantKey bool // the full key is returned
+		wantErr bool
+	}{
+		{"create", APIKeyCreate{ProviderConfigIDs: configIDs[:1], DailyRequestLimit: intPtr(10)}, ProvisionCreated, true, false},
+		{"same state", APIKeyCreate{ProviderConfigIDs: configIDs[:1], DailyRequestLimit: intPtr(10)}, ProvisionUnchanged, false, false},
+		{"new limit", APIKeyCreate{ProviderConfigIDs: configIDs[:1], DailyRequestLimit: intPtr(20)}, ProvisionUpdated, false, false},
+		{"limit removed", APIKeyCreate{ProviderConfigIDs: configIDs[:1]}, ProvisionUpdated, false, false},
+		{"more configs", APIKeyCreate{ProviderConfigIDs: configIDs}, ProvisionUpdated, false, false},
+		{"configs reordered", APIKeyCreate{ProviderConfigIDs: []uint{configIDs[1], configIDs[0]}}, ProvisionUnchanged, false, false},
+		{"unknown config", APIKeyCreate{ProviderConfigIDs: []uint{configIDs[0], 999}}, "", false, true},
+		{"invalid setting", APIKeyCreate{ProviderConfigIDs: configIDs, RoutingStrategy: "random"}, "", false, true},
+	}
+	var id uint
+	var keyHash string
+	for _, step := range steps {
+		step.req.Name = "ci"
+		key, fullKey, result, err := keys.ProvisionAPIKey(user.ID, &step.req)
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s: err = %v, want error %v", step.name, err, step.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if result != step.want || (fullKey != "") != step.wantKey {
+			t.Errorf("%s: result %q with key %q, want %q with key %v", step.name, result, fullKey, step.want, step.wantKey)
+		}
+		if id == 0 {
+			id, keyHash = key.ID, key.KeyHash
+		} else if key.ID != id || key.KeyHash != keyHash {
+			t.Errorf("%s: provisioned key %d, want the existing key %d with its secret", step.name, key.ID, id)
+		}
+		if !sameIntPtr(key.DailyRequestLimit, step.req.DailyRequestLimit) || len(key.ProviderConfigs) != len(step.req.ProviderConfigIDs) {
+			t.Errorf("%s: key has limit %v and %d configs, want the requested ones", step.name, key.DailyRequestLimit, len(key.ProviderConfigs))
+		}
+	}
+}
+
+func TestProvisioning_RoutingPolicy(t *testing.T) {
+	configs, _, user := provisioningFixture(t)
+	svc := NewRoutingPolicyService(configs.db)
+
+	steps := []struct {
+		name string
+		req  RoutingPolicyRequest
+		want string
+	}{
+		{"create", RoutingPolicyRequest{Priority: 1, Condition: `model == "gpt-4o"`, Action: RoutingActionReject}, ProvisionCreated},
+		{"same state", RoutingPolicyRequest{Priority: 1, Condition: `model == "gpt-4o"`, Action: RoutingActionReject}, ProvisionUnchanged},
+		{"new message", RoutingPolicyRequest{Priority: 1, Condition: `model == "gpt-4o"`, Action: RoutingActionReject, Message: "no"}, ProvisionUpdated},
+	}
+	var id uint
+	for _, step := range steps {
+		step.req.Name = " block "
+		policy, result, err := svc.ProvisionPolicy(user.ID, &step.req)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if result != step.want {
+			t.Errorf("%s: result = %q, want %q", step.name, result, step.want)
+		}
+		if id == 0 {
+			id = policy.ID
+		} else if policy.ID != id {
+			t.Errorf("%s: provisioned policy %d, want the existing policy %d", step.name, policy.ID, id)
+		}
+		if policy.Name != "block" || policy.Message != step.req.Message {
+			t.Errorf("%s: policy %+v does not match the request", step.name, policy)
+		}
+	}
+}
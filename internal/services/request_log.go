@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// ErrRequestLogNotFound is returned for a request log record the user does not own
+var ErrRequestLogNotFound = errors.New("request log not found")
+
+// requestLogPurgeInterval is how often expired request log records are deleted
+const requestLogPurgeInterval = time.Hour
+
+// RequestLogService stores the request log records of gateway requests and
+// deletes them once their retention period has passed
+type RequestLogService struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+// NewRequestLogService creates a new RequestLogService
+func NewRequestLogService(db *gorm.DB, cfg *config.Config) *RequestLogService {
+	return &RequestLogService{db: db, cfg: cfg}
+}
+
+// Enabled reports whether request log records are kept
+func (s *RequestLogService) Enabled() bool {
+	return s.cfg.RequestLogRetentionDays > 0
+}
+
+// MaxBodyBytes is the largest request or response body kept in a record
+func (s *RequestLogService) MaxBodyBytes() int {
+	return s.cfg.RequestLogMaxBodyBytes
+}
+
+// Record stores a request log record
+func (s *RequestLogService) Record(entry *database.RequestLog) {
+	if err := s.db.Create(entry).Error; err != nil {
+		log.Printf("[RequestLog] Failed to store request log for trace=%s: %v", entry.TraceID, err)
+	}
+}
+
+// ListRequestLogs returns a page of a user's request log records, newest
+// first, and how many match. Search matches the model; Status is success or
+// error.
+func (s *RequestLogService) ListRequestLogs(userID uint, q *ListQuery) ([]database.RequestLog, int64, error) {
+	query := q.filter(s.db.Model(&database.RequestLog{}).Where("user_id = ?", userID), "model")
+	switch q.Status {
+	case "":
+	case "success":
+		query = query.Where("status_code < ?", 400)
+	case "error":
+		query = query.Where("status_code >= ?", 400)
+	default:
+		return nil, 0, ErrInvalidListStatus
+	}
+
+	var logs []database.RequestLog
+	total, err := q.find(query, "id DESC", &logs)
+	return logs, total, err
+}
+
+// GetRequestLog returns a request log record of a user
+func (s *RequestLogService) GetRequestLog(userID, id uint) (*database.RequestLog, error) {
+	var entry database.RequestLog
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&entry).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRequestLogNotFound
+		}
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// PurgeExpired deletes records older than the retention period at now
+func (s *RequestLogService) PurgeExpired(now time.Time) (int64, error) {
+	cutoff := now.AddDate(0, 0, -s.cfg.RequestLogRetentionDays)
+	result := s.db.Where("created_at < ?", cutoff).Delete(&database.RequestLog{})
+	return result.RowsAffected, result.Error
+}
+
+// Run purges expired records until ctx is cancelled
+func (s *RequestLogService) Run(ctx context.Context) {
+	if !s.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(requestLogPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if purged, err := s.PurgeExpired(time.Now()); err != nil {
+				log.Printf("[RequestLog] Failed to purge expired request logs: %v", err)
+			} else if purged > 0 {
+				log.Printf("[RequestLog] Purged %d expired request logs", purged)
+			}
+		}
+	}
+}
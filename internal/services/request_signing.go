@@ -0,0 +1,173 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/events"
+	"ai_gateway/internal/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Errors of signed request verification
+var (
+	ErrSignatureInvalid  = errors.New("invalid request signature")
+	ErrSignatureExpired  = errors.New("request timestamp outside the accepted window")
+	ErrSignatureReplayed = errors.New("request signature already used")
+)
+
+// signaturePurgeInterval bounds how often Verify sweeps expired signatures
+const signaturePurgeInterval = 10 * time.Minute
+
+// RequestSigningService manages the signing secrets of API keys and verifies
+// HMAC-signed requests, which server-to-server clients send instead of the key
+type RequestSigningService struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	maxSkew time.Duration
+	events  *events.Bus // receives key change events
+
+	lastPurge atomic.Int64
+}
+
+// NewRequestSigningService creates a new RequestSigningService publishing
+// signing changes on bus
+func NewRequestSigningService(db *gorm.DB, cfg *config.Config, bus *events.Bus) *RequestSigningService {
+	return &RequestSigningService{
+		db:      db,
+		cfg:     cfg,
+		maxSkew: time.Duration(cfg.SignatureMaxSkew) * time.Second,
+		events:  bus,
+	}
+}
+
+// SignRequest returns the signature of a request: the hex HMAC-SHA256, keyed
+// with the signing secret, of the timestamp (Unix seconds), the method, the
+// path followed by "?" and the canonical query when it has one, and the body,
+// joined by dots
+func SignRequest(secret, timestamp, method, path, rawQuery string, body []byte) string {
+	if query := CanonicalQuery(rawQuery); query != "" {
+		path += "?" + query
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + method + "." + path + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CanonicalQuery returns a query string with its parameters sorted by name and
+// then value, and escaped as by url.QueryEscape, so that clients and the
+// gateway sign the same string however the query was written
+func CanonicalQuery(rawQuery string) string {
+	values, _ := url.ParseQuery(rawQuery)
+	for _, v := range values {
+		sort.Strings(v)
+	}
+	return values.Encode()
+}
+
+// EnableSigning generates a new signing secret for a user's API key, replacing
+// any previous one, and returns it. From then on the key only authenticates
+// signed requests.
+func (s *RequestSigningService) EnableSigning(userID, keyID uint) (string, error) {
+	encKey, err := s.cfg.GetEncryptionKeyBytes()
+	if err != nil {
+		return "", err
+	}
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(bytes)
+	encrypted, err := utils.EncryptAPIKey(secret, encKey)
+	if err != nil {
+		return "", err
+	}
+	if err := s.setSigningSecret(userID, keyID, encrypted); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// DisableSigning removes the signing secret of a user's API key, so that it
+// authenticates by sending the key again
+func (s *RequestSigningService) DisableSigning(userID, keyID uint) error {
+	return s.setSigningSecret(userID, keyID, "")
+}
+
+func (s *RequestSigningService) setSigningSecret(userID, keyID uint, encrypted string) error {
+	result := s.db.Model(&database.APIKey{}).Where("id = ? AND user_id = ?", keyID, userID).Update("encrypted_signing_secret", encrypted)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	s.events.Publish(events.Event{
+		Type:   events.KeyChanged,
+		UserID: userID,
+		Data:   events.KeyChange{APIKeyID: keyID, Action: "signing"},
+	})
+	return nil
+}
+
+// Verify checks the signature of a request to method, path and query made with key at
+// timestamp, and that neither the timestamp is outside the accepted window nor
+// the signature was seen before
+func (s *RequestSigningService) Verify(key *database.APIKey, method, path, rawQuery, timestamp, signature string, body []byte, now time.Time) error {
+	if key.EncryptedSigningSecret == "" {
+		return ErrSignatureInvalid
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-s.maxSkew)) || signedAt.After(now.Add(s.maxSkew)) {
+		return ErrSignatureExpired
+	}
+
+	encKey, err := s.cfg.GetEncryptionKeyBytes()
+	if err != nil {
+		return err
+	}
+	secret, err := utils.DecryptAPIKey(key.EncryptedSigningSecret, encKey)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(SignRequest(secret, timestamp, method, path, rawQuery, body)), []byte(signature)) {
+		return ErrSignatureInvalid
+	}
+
+	// The signature is remembered until its timestamp leaves the window
+	seen := &database.RequestSignature{
+		APIKeyID:  key.ID,
+		Signature: signature,
+		ExpiresAt: signedAt.Add(s.maxSkew),
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(seen)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSignatureReplayed
+	}
+
+	last := s.lastPurge.Load()
+	if now.Unix()-last >= int64(signaturePurgeInterval/time.Second) && s.lastPurge.CompareAndSwap(last, now.Unix()) {
+		s.db.Where("expires_at <= ?", now).Delete(&database.RequestSignature{})
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"ai_gateway/internal/database"
+)
+
+// MaxResponseSuffixLength bounds the disclaimer an API key appends to responses
+const MaxResponseSuffixLength = 1000
+
+// ResponsePolicy holds the post-conditions an API key sets on the responses it
+// receives, for compliance-driven deployments: a cap on the completion tokens
+// returned, languages responses must not be in, and a suffix such as a
+// disclaimer appended to every response
+type ResponsePolicy struct {
+	MaxTokens          int // 0 for no cap
+	ForbiddenLanguages []string
+	Suffix             string
+}
+
+// ResponsePolicyFor returns the response policy of an API key, or nil when it
+// sets none
+func ResponsePolicyFor(key *database.APIKey) *ResponsePolicy {
+	if key == nil || (key.MaxResponseTokens == 0 && key.ForbiddenLanguages == "" && key.ResponseSuffix == "") {
+		return nil
+	}
+	return &ResponsePolicy{
+		MaxTokens:          key.MaxResponseTokens,
+		ForbiddenLanguages: SplitLanguages(key.ForbiddenLanguages),
+		Suffix:             key.ResponseSuffix,
+	}
+}
+
+// MaxChars returns how much completion text the token cap allows, or -1 when
+// there is no cap
+func (p *ResponsePolicy) MaxChars() int {
+	if p.MaxTokens <= 0 {
+		return -1
+	}
+	return p.MaxTokens * charsPerToken
+}
+
+// Forbidden returns the language of text when it is a forbidden one, or ""
+func (p *ResponsePolicy) Forbidden(text string) string {
+	if len(p.ForbiddenLanguages) == 0 {
+		return ""
+	}
+	lang := DetectLanguage(text)
+	for _, forbidden := range p.ForbiddenLanguages {
+		if lang == forbidden {
+			return lang
+		}
+	}
+	return ""
+}
+
+// TruncateText cuts text to at most n bytes without splitting a character
+func TruncateText(text string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
+
+// validateResponsePolicy checks the response policy settings of an API key
+func validateResponsePolicy(maxTokens int, suffix string) error {
+	if maxTokens < 0 {
+		return fmt.Errorf("max_response_tokens must not be negative")
+	}
+	if len(suffix) > MaxResponseSuffixLength {
+		return fmt.Errorf("response_suffix must be at most %d bytes", MaxResponseSuffixLength)
+	}
+	return nil
+}
+
+// NormalizeLanguages validates a list of ISO 639-1 language codes and joins
+// them for storage
+func NormalizeLanguages(languages []string) (string, error) {
+	seen := make(map[string]bool)
+	var codes []string
+	for _, lang := range languages {
+		code := strings.ToLower(strings.TrimSpace(lang))
+		if code == "" || seen[code] {
+			continue
+		}
+		if !supportedLanguages[code] {
+			return "", fmt.Errorf("unsupported language %q; supported: %s", lang, strings.Join(SupportedLanguages(), ", "))
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return strings.Join(codes, ","), nil
+}
+
+// SplitLanguages splits stored language codes
+func SplitLanguages(stored string) []string {
+	if stored == "" {
+		return nil
+	}
+	return strings.Split(stored, ",")
+}
+
+// Languages DetectLanguage recognizes: those written in their own script, and
+// Latin-script languages told apart by their most common words
+var (
+	scriptLanguages = map[string]*unicode.RangeTable{
+		"ar": unicode.Arabic,
+		"el": unicode.Greek,
+		"he": unicode.Hebrew,
+		"hi": unicode.Devanagari,
+		"ko": unicode.Hangul,
+		"th": unicode.Thai,
+	}
+	stopwords = map[string][]string{
+		"en": {"the", "and", "is", "are", "of", "to", "in", "that", "it", "you", "for", "with", "this", "was", "not", "be", "on", "have"},
+		"fr": {"le", "la", "les", "et", "est", "des", "une", "un", "du", "que", "pour", "dans", "pas", "vous", "sur", "au", "avec", "ce"},
+		"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "zu", "den", "mit", "sie", "ich", "auf", "für", "sich", "dem", "auch"},
+		"es": {"el", "la", "los", "las", "y", "es", "de", "que", "en", "un", "una", "por", "para", "con", "no", "se", "del", "lo"},
+		"it": {"il", "la", "gli", "e", "è", "di", "che", "un", "una", "per", "non", "con", "sono", "del", "della", "lo", "si", "anche"},
+		"pt": {"o", "a", "os", "as", "e", "é", "de", "que", "um", "uma", "para", "com", "não", "do", "da", "em", "se", "por"},
+		"nl": {"de", "het", "een", "en", "is", "van", "dat", "niet", "op", "te", "met", "zijn", "voor", "ik", "je", "die", "ook", "er"},
+	}
+	supportedLanguages = func() map[string]bool {
+		langs := map[string]bool{"zh": true, "ja": true, "ru": true, "uk": true, "fa": true}
+		for lang := range scriptLanguages {
+			langs[lang] = true
+		}
+		for lang := range stopwords {
+			langs[lang] = true
+		}
+		return langs
+	}()
+)
+
+// SupportedLanguages lists the language codes DetectLanguage recognizes
+func SupportedLanguages() []string {
+	langs := make([]string, 0, len(supportedLanguages))
+	for lang := range supportedLanguages {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// minLanguageLetters is how many letters text needs for its language to be told
+const minLanguageLetters = 20
+
+// DetectLanguage returns the ISO 639-1 code of the main language of text, or ""
+// when it is too short or not recognized. The script most letters are written
+// in decides, and for Latin script the most frequent common words.
+func DetectLanguage(text string) string {
+	counts := make(map[string]int)
+	letters, kana, han, latin, cyrillic := 0, 0, 0, 0, 0
+	ukrainian, persian := 0, 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			kana++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+			if strings.ContainsRune("іїєґІЇЄҐ", r) {
+				ukrainian++
+			}
+		default:
+			for lang, table := range scriptLanguages {
+				if unicode.Is(table, r) {
+					counts[lang]++
+					if lang == "ar" && strings.ContainsRune("پچژگکی", r) {
+						persian++
+					}
+					break
+				}
+			}
+		}
+	}
+	if letters < minLanguageLetters {
+		return ""
+	}
+
+	// Japanese mixes kana with Han characters
+	if kana > 0 && kana+han > letters/2 {
+		return "ja"
+	}
+	if han > letters/2 {
+		return "zh"
+	}
+	if cyrillic > letters/2 {
+		if ukrainian > 0 {
+			return "uk"
+		}
+		return "ru"
+	}
+	for lang, n := range counts {
+		if n > letters/2 {
+			if lang == "ar" && persian > 0 {
+				return "fa"
+			}
+			return lang
+		}
+	}
+	if latin > letters/2 {
+		return latinLanguage(text)
+	}
+	return ""
+}
+
+// latinLanguage tells Latin-script languages apart by their common words
+func latinLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	scores := make(map[string]int)
+	for lang, list := range stopwords {
+		set := make(map[string]bool, len(list))
+		for _, w := range list {
+			set[w] = true
+		}
+		for _, w := range words {
+			if set[w] {
+				scores[lang]++
+			}
+		}
+	}
+	best, bestScore, tie := "", 0, false
+	for lang, score := range scores {
+		switch {
+		case score > bestScore:
+			best, bestScore, tie = lang, score, false
+		case score == bestScore:
+			tie = true
+		}
+	}
+	// Too few common words, or as many of two languages, is no evidence
+	if bestScore < 2 || tie {
+		return ""
+	}
+	return best
+}
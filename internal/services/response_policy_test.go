@@ -0,0 +1,110 @@
+package services
+
+import (
+	"testing"
+
+	"ai_gateway/internal/database"
+)
+
+func TestResponsePolicy_DetectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"english", "The weather is nice today and you have to go out with this dog.", "en"},
+		{"french", "Le chat est sur la table et les enfants sont dans le jardin avec une balle.", "fr"},
+		{"german", "Der Hund ist nicht in dem Haus und die Katze schläft auf dem Sofa.", "de"},
+		{"chinese", "今天天气很好，我们一起去公园散步吧，顺便买一些水果回家。", "zh"},
+		{"japanese", "今日はとても良い天気ですね。一緒に公園へ散歩に行きましょう。", "ja"},
+		{"russian", "Сегодня хорошая погода, давайте пойдём гулять в парк вместе.", "ru"},
+		{"ukrainian", "Сьогодні гарна погода, давайте підемо гуляти в парк разом.", "uk"},
+		{"korean", "오늘은 날씨가 정말 좋네요 함께 공원에 산책하러 가요", "ko"},
+		{"too short", "Hello there", ""},
+		{"no common words", "Lorem ipsum dolor sit amet consectetur adipiscing elit", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectLanguage(tt.text); got != tt.want {
+				t.Errorf("DetectLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponsePolicy_NormalizeLanguages(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []string
+		want    string
+		wantErr bool
+	}{
+		{"empty", nil, "", false},
+		{"sorted and deduplicated", []string{" ZH", "en", "zh", ""}, "en,zh", false},
+		{"unsupported", []string{"en", "xx"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeLanguages(tt.in)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("NormalizeLanguages(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResponsePolicy_TruncateText(t *testing.T) {
+	tests := []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 3, "hel"},
+		{"hello", 0, ""},
+		{"héllo", 2, "h"}, // é takes two bytes
+		{"你好", 4, "你"},
+	}
+	for _, tt := range tests {
+		if got := TruncateText(tt.text, tt.n); got != tt.want {
+			t.Errorf("TruncateText(%q, %d) = %q, want %q", tt.text, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestResponsePolicy_For(t *testing.T) {
+	tests := []struct {
+		name         string
+		key          *database.APIKey
+		wantNil      bool
+		wantMaxChars int
+		text         string
+		wantBlocked  string
+	}{
+		{name: "no key", key: nil, wantNil: true},
+		{name: "no policy", key: &database.APIKey{}, wantNil: true},
+		{name: "token cap", key: &database.APIKey{MaxResponseTokens: 10}, wantMaxChars: 10 * charsPerToken},
+		{name: "suffix only", key: &database.APIKey{ResponseSuffix: "\n-- AI generated"}, wantMaxChars: -1},
+		{name: "forbidden language", key: &database.APIKey{ForbiddenLanguages: "fr,zh"}, wantMaxChars: -1,
+			text: "今天天气很好，我们一起去公园散步吧，顺便买一些水果回家。", wantBlocked: "zh"},
+		{name: "allowed language", key: &database.APIKey{ForbiddenLanguages: "fr,zh"}, wantMaxChars: -1,
+			text: "The weather is nice today and you have to go out with this dog."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := ResponsePolicyFor(tt.key)
+			if (policy == nil) != tt.wantNil {
+				t.Fatalf("policy = %+v, want nil %v", policy, tt.wantNil)
+			}
+			if policy == nil {
+				return
+			}
+			if got := policy.MaxChars(); got != tt.wantMaxChars {
+				t.Errorf("MaxChars() = %d, want %d", got, tt.wantMaxChars)
+			}
+			if got := policy.Forbidden(tt.text); got != tt.wantBlocked {
+				t.Errorf("Forbidden() = %q, want %q", got, tt.wantBlocked)
+			}
+		})
+	}
+}
@@ -0,0 +1,495 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// RoutingFacts are the properties of a request that routing policy conditions
+// can test
+type RoutingFacts struct {
+	Model        string    // model as requested
+	Endpoint     string    // request path, e.g. /v1/chat/completions
+	KeyID        uint      // API key the request is made with
+	KeyName      string    // name of the API key
+	Tags         []string  // usage attribution tags of the request
+	PromptTokens int       // estimated prompt tokens, 0 when unknown
+	Time         time.Time // time of the request
+}
+
+// exprKind is the static type of a condition expression
+type exprKind int
+
+const (
+	kindBool exprKind = iota
+	kindNumber
+	kindString
+	kindList
+)
+
+func (k exprKind) String() string {
+	return [...]string{"boolean", "number", "string", "list"}[k]
+}
+
+// routingField is a request property available to conditions
+type routingField struct {
+	kind exprKind
+	get  func(f *RoutingFacts, loc *time.Location) interface{}
+}
+
+// routingFields are the identifiers of the condition language
+var routingFields = map[string]routingField{
+	"model":    {kindString, func(f *RoutingFacts, _ *time.Location) interface{} { return f.Model }},
+	"endpoint": {kindString, func(f *RoutingFacts, _ *time.Location) interface{} { return f.Endpoint }},
+	"key":      {kindString, func(f *RoutingFacts, _ *time.Location) interface{} { return f.KeyName }},
+	"key_id":   {kindNumber, func(f *RoutingFacts, _ *time.Location) interface{} { return float64(f.KeyID) }},
+	"tags": {kindList, func(f *RoutingFacts, _ *time.Location) interface{} {
+		tags := make([]interface{}, len(f.Tags))
+		for i, tag := range f.Tags {
+			tags[i] = tag
+		}
+		return tags
+	}},
+	"prompt_tokens": {kindNumber, func(f *RoutingFacts, _ *time.Location) interface{} { return float64(f.PromptTokens) }},
+	"hour":          {kindNumber, func(f *RoutingFacts, loc *time.Location) interface{} { return float64(f.Time.In(loc).Hour()) }},
+	"weekday": {kindString, func(f *RoutingFacts, loc *time.Location) interface{} {
+		return strings.ToLower(f.Time.In(loc).Weekday().String()[:3])
+	}},
+	"time": {kindString, func(f *RoutingFacts, loc *time.Location) interface{} { return f.Time.In(loc).Format("15:04") }},
+}
+
+// scheduleFields are the identifiers of activation schedules, which are
+// conditions on the time alone
+var scheduleFields = map[string]routingField{
+	"hour":    routingFields["hour"],
+	"weekday": routingFields["weekday"],
+	"time":    routingFields["time"],
+}
+
+// maxConditionLength bounds the source of a condition
+const maxConditionLength = 2000
+
+// routingExpr is a compiled condition expression
+type routingExpr interface {
+	kind() exprKind
+	eval(f *RoutingFacts, loc *time.Location) interface{}
+}
+
+type literalExpr struct {
+	k     exprKind
+	value interface{}
+}
+
+func (e *literalExpr) kind() exprKind                                 { return e.k }
+func (e *literalExpr) eval(*RoutingFacts, *time.Location) interface{} { return e.value }
+
+type fieldExpr struct{ field routingField }
+
+func (e *fieldExpr) kind() exprKind { return e.field.kind }
+func (e *fieldExpr) eval(f *RoutingFacts, loc *time.Location) interface{} {
+	return e.field.get(f, loc)
+}
+
+type notExpr struct{ operand routingExpr }
+
+func (e *notExpr) kind() exprKind { return kindBool }
+func (e *notExpr) eval(f *RoutingFacts, loc *time.Location) interface{} {
+	return !e.operand.eval(f, loc).(bool)
+}
+
+type logicalExpr struct {
+	and         bool
+	left, right routingExpr
+}
+
+func (e *logicalExpr) kind() exprKind { return kindBool }
+func (e *logicalExpr) eval(f *RoutingFacts, loc *time.Location) interface{} {
+	if e.left.eval(f, loc).(bool) != e.and {
+		return !e.and
+	}
+	return e.right.eval(f, loc).(bool)
+}
+
+type compareExpr struct {
+	op          string
+	left, right routingExpr
+}
+
+func (e *compareExpr) kind() exprKind { return kindBool }
+func (e *compareExpr) eval(f *RoutingFacts, loc *time.Location) interface{} {
+	left, right := e.left.eval(f, loc), e.right.eval(f, loc)
+	if e.op == "==" || e.op == "!=" {
+		return (left == right) == (e.op == "==")
+	}
+	var cmp int
+	if e.left.kind() == kindNumber {
+		cmp = compareNumbers(left.(float64), right.(float64))
+	} else {
+		cmp = strings.Compare(left.(string), right.(string))
+	}
+	switch e.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func compareNumbers(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+type inExpr struct{ item, list routingExpr }
+
+func (e *inExpr) kind() exprKind { return kindBool }
+func (e *inExpr) eval(f *RoutingFacts, loc *time.Location) interface{} {
+	item := e.item.eval(f, loc)
+	for _, v := range e.list.eval(f, loc).([]interface{}) {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+type matchesExpr struct {
+	operand routingExpr
+	pattern *regexp.Regexp
+}
+
+func (e *matchesExpr) kind() exprKind { return kindBool }
+func (e *matchesExpr) eval(f *RoutingFacts, loc *time.Location) interface{} {
+	return e.pattern.MatchString(e.operand.eval(f, loc).(string))
+}
+
+// compileRoutingCondition parses a routing policy condition, e.g.
+//
+//	model matches "^claude-" && (prompt_tokens > 50000 || "batch" in tags)
+//
+// Conditions combine comparisons (==, !=, <, <=, >, >=), membership (in) and
+// regular expression matches (matches) of the request fields with &&, || and !.
+// An empty condition matches every request.
+func compileRoutingCondition(source string) (routingExpr, error) {
+	return compileCondition(source, routingFields)
+}
+
+// compileScheduleCondition parses the activation schedule of a provider
+// config, a condition on hour, weekday and time, e.g.
+//
+//	weekday in ["mon", "tue", "wed", "thu", "fri"] && time >= "09:00" && time < "18:00"
+func compileScheduleCondition(source string) (routingExpr, error) {
+	return compileCondition(source, scheduleFields)
+}
+
+// compileCondition parses a condition over fields
+func compileCondition(source string, fields map[string]routingField) (routingExpr, error) {
+	if strings.TrimSpace(source) == "" {
+		return &literalExpr{kindBool, true}, nil
+	}
+	if utf8.RuneCountInString(source) > maxConditionLength {
+		return nil, fmt.Errorf("condition exceeds %d characters", maxConditionLength)
+	}
+	tokens, err := tokenizeCondition(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens, fields: fields}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+	}
+	if expr.kind() != kindBool {
+		return nil, fmt.Errorf("condition must be a boolean expression, not a %s", expr.kind())
+	}
+	return expr, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+)
+
+type conditionToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t conditionToken) String() string {
+	if t.kind == tokenEOF {
+		return "end of condition"
+	}
+	return strconv.Quote(t.text)
+}
+
+// conditionOps are the operators and punctuation of conditions, longest first
+var conditionOps = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ","}
+
+// tokenizeCondition splits a condition into tokens; positions count characters
+func tokenizeCondition(source string) ([]conditionToken, error) {
+	src := []rune(source)
+	var tokens []conditionToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			text, err := strconv.Unquote(string(src[i : end+1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d", i)
+			}
+			tokens = append(tokens, conditionToken{tokenString, text, i})
+			i = end + 1
+		case c >= '0' && c <= '9' || c == '-' || c == '.':
+			end := i + 1
+			for end < len(src) && (src[end] >= '0' && src[end] <= '9' || src[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, conditionToken{tokenNumber, string(src[i:end]), i})
+			i = end
+		case c == '_' || unicode.IsLetter(c):
+			end := i + 1
+			for end < len(src) && (src[end] == '_' || unicode.IsLetter(src[end]) || unicode.IsDigit(src[end])) {
+				end++
+			}
+			tokens = append(tokens, conditionToken{tokenIdent, string(src[i:end]), i})
+			i = end
+		default:
+			matched := false
+			for _, op := range conditionOps {
+				if strings.HasPrefix(string(src[i:min(i+len(op), len(src))]), op) {
+					tokens = append(tokens, conditionToken{tokenOp, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	return append(tokens, conditionToken{kind: tokenEOF, pos: len(src)}), nil
+}
+
+// comparisonOps are the binary operators comparing two values of the same type
+var comparisonOps = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// conditionParser is a recursive descent parser of conditions; && binds
+// tighter than ||, and comparisons tighter than both
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+	fields map[string]routingField
+}
+
+func (p *conditionParser) peek() conditionToken { return p.tokens[p.pos] }
+
+func (p *conditionParser) next() conditionToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the given operator or keyword
+func (p *conditionParser) accept(text string) bool {
+	tok := p.peek()
+	if (tok.kind == tokenOp || tok.kind == tokenIdent) && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *conditionParser) expect(text string) error {
+	if !p.accept(text) {
+		tok := p.peek()
+		return fmt.Errorf("expected %q but found %s at position %d", text, tok, tok.pos)
+	}
+	return nil
+}
+
+func (p *conditionParser) parseOr() (routingExpr, error) {
+	return p.parseLogical("||", p.parseAnd)
+}
+
+func (p *conditionParser) parseAnd() (routingExpr, error) {
+	return p.parseLogical("&&", p.parseUnary)
+}
+
+func (p *conditionParser) parseLogical(op string, operand func() (routingExpr, error)) (routingExpr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if !p.accept(op) {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if left.kind() != kindBool || right.kind() != kindBool {
+			return nil, fmt.Errorf("operands of %s at position %d must be boolean", op, pos)
+		}
+		left = &logicalExpr{and: op == "&&", left: left, right: right}
+	}
+}
+
+func (p *conditionParser) parseUnary() (routingExpr, error) {
+	pos := p.peek().pos
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if operand.kind() != kindBool {
+			return nil, fmt.Errorf("operand of ! at position %d must be boolean", pos)
+		}
+		return &notExpr{operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *conditionParser) parseComparison() (routingExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	switch {
+	case tok.kind == tokenOp && comparisonOps[tok.text]:
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if left.kind() != right.kind() || left.kind() == kindList {
+			return nil, fmt.Errorf("cannot compare %s with %s at position %d", left.kind(), right.kind(), tok.pos)
+		}
+		if left.kind() == kindBool && tok.text != "==" && tok.text != "!=" {
+			return nil, fmt.Errorf("cannot order booleans at position %d", tok.pos)
+		}
+		return &compareExpr{op: tok.text, left: left, right: right}, nil
+
+	case tok.kind == tokenIdent && tok.text == "in":
+		p.next()
+		list, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if list.kind() != kindList || (left.kind() != kindString && left.kind() != kindNumber) {
+			return nil, fmt.Errorf("in at position %d needs a string or number and a list", tok.pos)
+		}
+		return &inExpr{item: left, list: list}, nil
+
+	case tok.kind == tokenIdent && tok.text == "matches":
+		p.next()
+		patternTok := p.next()
+		if patternTok.kind != tokenString || left.kind() != kindString {
+			return nil, fmt.Errorf("matches at position %d needs a string and a pattern literal", tok.pos)
+		}
+		pattern, err := regexp.Compile(patternTok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern at position %d: %v", patternTok.pos, err)
+		}
+		return &matchesExpr{operand: left, pattern: pattern}, nil
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseOperand() (routingExpr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenString:
+		return &literalExpr{kindString, tok.text}, nil
+	case tokenNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+		}
+		return &literalExpr{kindNumber, n}, nil
+	case tokenIdent:
+		switch tok.text {
+		case "true", "false":
+			return &literalExpr{kindBool, tok.text == "true"}, nil
+		}
+		field, ok := p.fields[tok.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q at position %d", tok.text, tok.pos)
+		}
+		return &fieldExpr{field}, nil
+	case tokenOp:
+		switch tok.text {
+		case "(":
+			expr, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expect(")")
+		case "[":
+			return p.parseList(tok.pos)
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+}
+
+// parseList parses a list literal of strings or numbers
+func (p *conditionParser) parseList(pos int) (routingExpr, error) {
+	var items []interface{}
+	for !p.accept("]") {
+		if len(items) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		item, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		literal, ok := item.(*literalExpr)
+		if !ok || (literal.k != kindString && literal.k != kindNumber) {
+			return nil, fmt.Errorf("list at position %d may only hold string and number literals", pos)
+		}
+		items = append(items, literal.value)
+	}
+	return &literalExpr{kindList, items}, nil
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileRoutingCondition_Evaluates(t *testing.T) {
+	// Saturday 2024-06-15 23:30 UTC, Sunday 07:30 in Asia/Shanghai
+	facts := &RoutingFacts{
+		Model:        "gpt-4o-mini",
+		Endpoint:     "/v1/chat/completions",
+		KeyID:        7,
+		KeyName:      "批处理",
+		Tags:         []string{"batch", "夜间"},
+		PromptTokens: 60000,
+		Time:         time.Date(2024, 6, 15, 23, 30, 0, 0, time.UTC),
+	}
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		condition string
+		want      bool
+	}{
+		{"", true},
+		{"true", true},
+		{`model == "gpt-4o-mini"`, true},
+		{`model != "gpt-4o-mini"`, false},
+		{"key_id == 7 && prompt_tokens >= 60000", true},
+		{"prompt_tokens > 60000", false},
+		{"prompt_tokens <= 60000 && prompt_tokens < 60001", true},
+		{`endpoint < "/v1/d" && endpoint > "/v1/a"`, true},
+
+		// && binds tighter than ||, ! tighter than both
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!false && false", false},
+		{"!(false && false)", true},
+		{"!!true", true},
+		{`false || !(model == "gpt-4o") && key_id == 7`, true},
+
+		{`"batch" in tags`, true},
+		{`"interactive" in tags`, false},
+		{`key_id in [1, 7, 9]`, true},
+		{`model in ["gpt-4o", "claude"]`, false},
+		{`"x" in []`, false},
+
+		{`model matches "^gpt-4o"`, true},
+		{`model matches "^claude-"`, false},
+		{`model matches "(?i)GPT" && !(endpoint matches "embeddings$")`, true},
+
+		// Non-ASCII strings and fields
+		{`key == "批处理"`, true},
+		{`"夜间" in tags && key matches "^批"`, true},
+		{`key == "批处理" && model == "gpt-4o-mini"`, true},
+
+		{`hour == 7 && weekday == "sun" && time == "07:30"`, true},
+		{`weekday in ["sat", "sun"] && time >= "07:00" && time < "08:00"`, true},
+	}
+	for _, tt := range tests {
+		expr, err := compileRoutingCondition(tt.condition)
+		if err != nil {
+			t.Errorf("compileRoutingCondition(%q) = %v", tt.condition, err)
+			continue
+		}
+		if got := expr.eval(facts, shanghai).(bool); got != tt.want {
+			t.Errorf("%q = %v, want %v", tt.condition, got, tt.want)
+		}
+	}
+}
+
+func TestCompileRoutingCondition_Errors(t *testing.T) {
+	tests := []struct {
+		condition string
+		want      string
+	}{
+		// Type errors
+		{"model", "must be a boolean expression, not a string"},
+		{"prompt_tokens + 1", `unexpected character '+' at position 14`},
+		{`model == 1`, "cannot compare string with number at position 6"},
+		{`tags == tags`, "cannot compare list with list at position 5"},
+		{`true < false`, "cannot order booleans at position 5"},
+		{`model && true`, "operands of && at position 6 must be boolean"},
+		{`true || key_id`, "operands of || at position 5 must be boolean"},
+		{`!model`, "operand of ! at position 0 must be boolean"},
+		{`"a" in model`, "in at position 4 needs a string or number and a list"},
+		{`tags in tags`, "in at position 5 needs a string or number and a list"},
+		{`key_id matches "7"`, "matches at position 7 needs a string and a pattern literal"},
+		{`model matches endpoint`, "matches at position 6 needs a string and a pattern literal"},
+		{`model in [model]`, "list at position 9 may only hold string and number literals"},
+
+		// Malformed input
+		{`unknown == "x"`, `unknown field "unknown" at position 0`},
+		{`model == "gpt`, "unterminated string at position 9"},
+		{`model == "a\q"`, "invalid string at position 9"},
+		{`model matches "("`, "invalid pattern at position 14"},
+		{`(model == "a"`, `expected ")" but found end of condition at position 13`},
+		{`model == "a")`, `unexpected ")" at position 12`},
+		{`model ==`, "unexpected end of condition at position 8"},
+		{`key_id in [1 2]`, `expected "," but found "2" at position 13`},
+		{`key_id == 1.2.3`, `invalid number "1.2.3" at position 10`},
+		{`model = "a"`, `unexpected character '=' at position 6`},
+		{`model == "a" & true`, `unexpected character '&' at position 13`},
+
+		// Positions count characters, not bytes
+		{`key == "批处理" && 模型 == "x"`, `unknown field "模型" at position 16`},
+		{`key == "批处理" @`, `unexpected character '@' at position 13`},
+		{`"批" in tags && key ==`, "unexpected end of condition at position 21"},
+
+		{strings.Repeat(" ", maxConditionLength) + "true", "condition exceeds 2000 characters"},
+	}
+	for _, tt := range tests {
+		_, err := compileRoutingCondition(tt.condition)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("compileRoutingCondition(%q) = %v, want %q", tt.condition, err, tt.want)
+		}
+	}
+}
+
+func TestCompileScheduleCondition_OnlyTimeFields(t *testing.T) {
+	if _, err := compileScheduleCondition(`weekday in ["mon", "fri"] && hour < 18`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compileScheduleCondition(`model == "gpt-4o"`); err == nil || !strings.Contains(err.Error(), `unknown field "model"`) {
+		t.Errorf("schedule conditions must not see request fields, got %v", err)
+	}
+}
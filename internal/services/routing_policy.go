@@ -0,0 +1,249 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// Routing policy actions
+const (
+	RoutingActionRoute  = "route"
+	RoutingActionReject = "reject"
+)
+
+// routingPolicyTTL bounds how long compiled policies are served from cache,
+// so that changes made through another gateway instance take effect
+const routingPolicyTTL = 30 * time.Second
+
+// ErrRoutingPolicyNotFound is returned when a user has no such routing policy
+var ErrRoutingPolicyNotFound = errors.New("routing policy not found")
+
+// RoutingRejection is the error of a request rejected by a routing policy
+type RoutingRejection struct {
+	Policy  string
+	Message string
+}
+
+func (e *RoutingRejection) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("request rejected by routing policy %q", e.Policy)
+}
+
+// RoutingPolicyRequest represents the settings of a routing policy
+type RoutingPolicyRequest struct {
+	Name               string `json:"name"`
+	Priority           int    `json:"priority"`
+	Condition          string `json:"condition"`
+	Timezone           string `json:"timezone"`
+	Action             string `json:"action"`
+	ProviderConfigID   *uint  `json:"provider_config_id"`
+	Model              string `json:"model"`
+	KeepRequestedModel bool   `json:"keep_requested_model"`
+	Message            string `json:"message"`
+	IsActive           *bool  `json:"is_active"`
+}
+
+// compiledRoutingPolicy is a policy ready for evaluation
+type compiledRoutingPolicy struct {
+	policy    database.RoutingPolicy
+	condition routingExpr
+	location  *time.Location
+}
+
+type cachedRoutingPolicies struct {
+	policies []compiledRoutingPolicy
+	loadedAt time.Time
+}
+
+// RoutingPolicyService manages routing policies and evaluates them against
+// requests, keeping each user's policies compiled in memory
+type RoutingPolicyService struct {
+	db *gorm.DB
+
+	mu    sync.Mutex
+	cache map[uint]*cachedRoutingPolicies
+}
+
+// NewRoutingPolicyService creates a new RoutingPolicyService
+func NewRoutingPolicyService(db *gorm.DB) *RoutingPolicyService {
+	return &RoutingPolicyService{db: db, cache: make(map[uint]*cachedRoutingPolicies)}
+}
+
+// ListPolicies returns all routing policies of a user in evaluation order
+func (s *RoutingPolicyService) ListPolicies(userID uint) ([]database.RoutingPolicy, error) {
+	var policies []database.RoutingPolicy
+	err := s.db.Where("user_id = ?", userID).Order("priority, id").Find(&policies).Error
+	return policies, err
+}
+
+// apply validates req and copies it onto policy
+func (s *RoutingPolicyService) apply(policy *database.RoutingPolicy, req *RoutingPolicyRequest) error {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 64 {
+		return errors.New("name must be 1-64 characters")
+	}
+	if _, err := compileRoutingCondition(req.Condition); err != nil {
+		return fmt.Errorf("invalid condition: %w", err)
+	}
+	timezone := strings.TrimSpace(req.Timezone)
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q", timezone)
+	}
+	model := strings.TrimSpace(req.Model)
+	if len(model) > 100 {
+		return errors.New("model must be at most 100 characters")
+	}
+	if len(req.Message) > 500 {
+		return errors.New("message must be at most 500 characters")
+	}
+
+	switch req.Action {
+	case RoutingActionRoute:
+		if req.ProviderConfigID == nil && model == "" {
+			return errors.New("a route policy needs a provider_config_id, a model or both")
+		}
+		if req.ProviderConfigID != nil {
+			var count int64
+			s.db.Model(&database.ProviderConfig{}).Where("id = ? AND user_id = ?", *req.ProviderConfigID, policy.UserID).Count(&count)
+			if count == 0 {
+				return errors.New("provider config not found")
+			}
+		}
+	case RoutingActionReject:
+	default:
+		return errors.New("action must be route or reject")
+	}
+
+	policy.Name = name
+	policy.Priority = req.Priority
+	policy.Condition = strings.TrimSpace(req.Condition)
+	policy.Timezone = timezone
+	policy.Action = req.Action
+	policy.ProviderConfigID = nil
+	policy.Model = ""
+	policy.KeepRequestedModel = false
+	policy.Message = ""
+	if req.Action == RoutingActionRoute {
+		policy.ProviderConfigID = req.ProviderConfigID
+		policy.Model = model
+		policy.KeepRequestedModel = req.KeepRequestedModel
+	} else {
+		policy.Message = strings.TrimSpace(req.Message)
+	}
+	if req.IsActive != nil {
+		policy.IsActive = *req.IsActive
+	}
+	return nil
+}
+
+// CreatePolicy adds a routing policy for a user
+func (s *RoutingPolicyService) CreatePolicy(userID uint, req *RoutingPolicyRequest) (*database.RoutingPolicy, error) {
+	policy := &database.RoutingPolicy{UserID: userID, IsActive: true}
+	if err := s.apply(policy, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(policy).Error; err != nil {
+		return nil, err
+	}
+	s.invalidate(userID)
+	return policy, nil
+}
+
+// UpdatePolicy changes a user's routing policy
+func (s *RoutingPolicyService) UpdatePolicy(userID, id uint, req *RoutingPolicyRequest) (*database.RoutingPolicy, error) {
+	var policy database.RoutingPolicy
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&policy).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRoutingPolicyNotFound
+		}
+		return nil, err
+	}
+	if err := s.apply(&policy, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(&policy).Error; err != nil {
+		return nil, err
+	}
+	s.invalidate(userID)
+	return &policy, nil
+}
+
+// DeletePolicy removes a user's routing policy
+func (s *RoutingPolicyService) DeletePolicy(userID, id uint) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&database.RoutingPolicy{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRoutingPolicyNotFound
+	}
+	s.invalidate(userID)
+	return nil
+}
+
+// Evaluate returns the first active policy of a user whose condition matches
+// the request, or nil when none does
+func (s *RoutingPolicyService) Evaluate(userID uint, facts *RoutingFacts) (*database.RoutingPolicy, error) {
+	policies, err := s.compiled(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range policies {
+		p := &policies[i]
+		if p.condition.eval(facts, p.location).(bool) {
+			policy := p.policy
+			return &policy, nil
+		}
+	}
+	return nil, nil
+}
+
+// compiled returns the compiled active policies of a user in evaluation
+// order, from cache while fresh. Policies that no longer compile are skipped.
+func (s *RoutingPolicyService) compiled(userID uint) ([]compiledRoutingPolicy, error) {
+	s.mu.Lock()
+	cached, ok := s.cache[userID]
+	s.mu.Unlock()
+	if ok && time.Since(cached.loadedAt) < routingPolicyTTL {
+		return cached.policies, nil
+	}
+
+	var policies []database.RoutingPolicy
+	if err := s.db.Where("user_id = ? AND is_active = ?", userID, true).Order("priority, id").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+
+	compiled := make([]compiledRoutingPolicy, 0, len(policies))
+	for _, policy := range policies {
+		condition, err := compileRoutingCondition(policy.Condition)
+		if err != nil {
+			continue
+		}
+		location, err := time.LoadLocation(policy.Timezone)
+		if err != nil {
+			location = time.UTC
+		}
+		compiled = append(compiled, compiledRoutingPolicy{policy: policy, condition: condition, location: location})
+	}
+
+	s.mu.Lock()
+	s.cache[userID] = &cachedRoutingPolicies{policies: compiled, loadedAt: time.Now()}
+	s.mu.Unlock()
+	return compiled, nil
+}
+
+// invalidate drops the cached policies of a user
+func (s *RoutingPolicyService) invalidate(userID uint) {
+	s.mu.Lock()
+	delete(s.cache, userID)
+	s.mu.Unlock()
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	sessionTouchInterval = time.Minute         // how often a session's last-seen time is written
+	sessionRetention     = 30 * 24 * time.Hour // ended sessions are kept this long for review
+)
+
+var (
+	// ErrSessionNotFound is returned when a user has no such active session
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrSessionInvalid is returned for tokens whose session was revoked or has expired
+	ErrSessionInvalid = errors.New("session has been revoked or has expired")
+)
+
+// CreateToken starts a dashboard session for a user and returns its JWT
+func (s *AuthService) CreateToken(user *database.User, ip, userAgent string) (string, error) {
+	suffix, err := utils.GenerateRandomString(24)
+	if err != nil {
+		return "", err
+	}
+
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	now := time.Now()
+	session := &database.Session{
+		PublicID:   "sess_" + suffix,
+		UserID:     user.ID,
+		IP:         ip,
+		UserAgent:  userAgent,
+		Device:     deviceLabel(userAgent),
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Duration(s.cfg.JWTExpiration) * time.Minute),
+	}
+	if err := s.db.Create(session).Error; err != nil {
+		return "", err
+	}
+
+	// Forget sessions that ended long ago
+	s.db.Where("user_id = ? AND expires_at < ?", user.ID, now.Add(-sessionRetention)).Delete(&database.Session{})
+
+	return utils.CreateAccessToken(user.ID, session.PublicID, s.cfg.JWTSecret, s.cfg.JWTExpiration)
+}
+
+// ValidateSession checks that the session of a decoded token is still active
+// and notes that it was just used from ip
+func (s *AuthService) ValidateSession(claims *utils.JWTClaims, ip string) (*database.Session, error) {
+	if claims.ID == "" {
+		return nil, ErrSessionInvalid
+	}
+	var session database.Session
+	if err := s.db.Where("public_id = ? AND user_id = ?", claims.ID, claims.UserID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionInvalid
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if session.RevokedAt != nil || session.ExpiresAt.Before(now) {
+		return nil, ErrSessionInvalid
+	}
+
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval || session.IP != ip {
+		session.LastSeenAt = now
+		session.IP = ip
+		s.db.Model(&session).Updates(map[string]interface{}{"last_seen_at": now, "ip": ip})
+	}
+	return &session, nil
+}
+
+// ListSessions returns the active sessions of a user, most recently used first
+func (s *AuthService) ListSessions(userID uint) ([]database.Session, error) {
+	var sessions []database.Session
+	err := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession ends an active session of a user
+func (s *AuthService) RevokeSession(userID uint, publicID string) error {
+	result := s.db.Model(&database.Session{}).
+		Where("public_id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", publicID, userID, time.Now()).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeOtherSessions ends every active session of a user except keep and
+// returns how many were ended
+func (s *AuthService) RevokeOtherSessions(userID uint, keep string) (int64, error) {
+	result := s.db.Model(&database.Session{}).
+		Where("user_id = ? AND public_id <> ? AND revoked_at IS NULL AND expires_at > ?", userID, keep, time.Now()).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// deviceLabel describes the browser and operating system of a user agent
+func deviceLabel(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		// Order matters: Edge and Opera also claim Chrome, Chrome also claims Safari
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"PostmanRuntime/", "Postman"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, o := range []struct{ token, name string }{
+		{"Windows", "Windows"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Android", "Android"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			return browser + " on " + o.name
+		}
+	}
+	return browser
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/utils"
+)
+
+// sessionFixture signs a user in from two browsers and returns the claims of
+// both tokens
+func sessionFixture(t *testing.T) (*AuthService, *database.User, []*utils.JWTClaims) {
+	db := testDB(t)
+	cfg := testConfig()
+	cfg.JWTExpiration = 60
+	svc := NewAuthService(db, cfg)
+
+	user := &database.User{Username: "u", Email: "u@example.com", HashedPassword: "x", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	var claims []*utils.JWTClaims
+	for _, userAgent := range []string{
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) AppleWebKit/605.1.15 Chrome/120.0 Safari/605.1.15",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0",
+	} {
+		token, err := svc.CreateToken(user, "10.0.0.1", userAgent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := utils.DecodeAccessToken(token, cfg.JWTSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims = append(claims, decoded)
+	}
+	return svc, user, claims
+}
+
+func TestSession_ValidateSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(svc *AuthService, user *database.User, claims []*utils.JWTClaims) *utils.JWTClaims
+		wantErr bool
+	}{
+		{"active", func(svc *AuthService, user *database.User, claims []*utils.JWTClaims) *utils.JWTClaims {
+			return claims[0]
+		}, false},
+		{"revoked", func(svc *AuthService, user *database.User, claims []*utils.JWTClaims) *utils.JWTClaims {
+			svc.RevokeSession(user.ID, claims[0].ID)
+			return claims[0]
+		}, true},
+		{"expired", func(svc *AuthService, user *database.User, claims []*utils.JWTClaims) *utils.JWTClaims {
+			svc.db.Model(&database.Session{}).Where("public_id = ?", claims[0].ID).Update("expires_at", time.Now().Add(-time.Minute))
+			return claims[0]
+		}, true},
+		{"other user", func(svc *AuthService, user *database.User, claims []*utils.JWTClaims) *utils.JWTClaims {
+			other := *claims[0]
+			other.UserID = user.ID + 1
+			return &other
+		}, true},
+		{"no session", func(svc *AuthService, user *database.User, claims []*utils.JWTClaims) *utils.JWTClaims {
+			return &utils.JWTClaims{UserID: user.ID}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, user, claims := sessionFixture(t)
+			session, err := svc.ValidateSession(tt.prepare(svc, user, claims), "10.0.0.2")
+			if tt.wantErr {
+				if !errors.Is(err, ErrSessionInvalid) {
+					t.Fatalf("err = %v, want ErrSessionInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if session.IP != "10.0.0.2" || session.Device != "Chrome on macOS" {
+				t.Errorf("session = %+v, want the new IP and the device of its user agent", session)
+			}
+			var stored database.Session
+			svc.db.Where("public_id = ?", session.PublicID).First(&stored)
+			if stored.IP != "10.0.0.2" {
+				t.Errorf("stored IP = %q, want the IP of the latest request", stored.IP)
+			}
+		})
+	}
+}
+
+func TestSession_Revocation(t *testing.T) {
+	svc, user, claims := sessionFixture(t)
+
+	if err := svc.RevokeSession(user.ID+1, claims[1].ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("revoking another user's session: err = %v, want ErrSessionNotFound", err)
+	}
+	if err := svc.RevokeSession(user.ID, claims[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.RevokeSession(user.ID, claims[1].ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("revoking a session twice: err = %v, want ErrSessionNotFound", err)
+	}
+
+	// A third sign-in is ended along with the first when keeping the second
+	token, err := svc.CreateToken(user, "10.0.0.3", "curl/8.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, _ := utils.DecodeAccessToken(token, svc.cfg.JWTSecret)
+	sessions, err := svc.ListSessions(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("listed %d sessions, want the 2 that were not revoked", len(sessions))
+	}
+	n, err := svc.RevokeOtherSessions(user.ID, third.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("revoked %d other sessions, want 1", n)
+	}
+	if _, err := svc.ValidateSession(claims[0], "10.0.0.1"); !errors.Is(err, ErrSessionInvalid) {
+		t.Errorf("first session: err = %v, want ErrSessionInvalid", err)
+	}
+	if _, err := svc.ValidateSession(third, "10.0.0.3"); err != nil {
+		t.Errorf("kept session: %v", err)
+	}
+}
+
+func TestSession_DeviceLabel(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"", "Unknown device"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36 Edg/120.0", "Edge on Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Version/17.0 Mobile Safari/604.1", "Safari on iOS"},
+		{"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"curl/8.0", "curl"},
+		{"something", "Unknown browser"},
+	}
+	for _, tt := range tests {
+		if got := deviceLabel(tt.userAgent); got != tt.want {
+			t.Errorf("deviceLabel(%q) = %q, want %q", tt.userAgent, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+const (
+	sloEvaluationInterval = time.Minute
+	sloShortWindow        = 5 * time.Minute
+	sloLongWindow         = time.Hour
+	sloMinRequests        = 10   // minimum requests in the long window before alerting
+	sloLatencyBudget      = 0.05 // a p95 objective allows 5% of requests to be slower
+	sloWebhookTimeout     = 10 * time.Second
+)
+
+// ErrSLONotFound is returned when a provider config has no SLO defined
+var ErrSLONotFound = errors.New("slo not found")
+
+// SLOUpdate represents the objectives set on a provider config
+type SLOUpdate struct {
+	AvailabilityTarget float64 `json:"availability_target"`
+	LatencyP95Ms       int     `json:"latency_p95_ms"`
+	WebhookURL         string  `json:"webhook_url"`
+}
+
+// BurnRate is how fast an SLO's error budget is being spent; 1 means exactly on budget
+type BurnRate struct {
+	SLO       string  `json:"slo"` // availability, latency
+	Target    float64 `json:"target"`
+	Short     float64 `json:"burn_rate_5m"`
+	Long      float64 `json:"burn_rate_1h"`
+	Threshold float64 `json:"threshold"`
+	Firing    bool    `json:"firing"`
+}
+
+// SLOStatus is the current state of a provider config's SLOs
+type SLOStatus struct {
+	ProviderConfigID uint       `json:"provider_config_id"`
+	Requests1h       uint64     `json:"requests_1h"`
+	Errors1h         uint64     `json:"errors_1h"`
+	P95LatencyMs1h   int64      `json:"p95_latency_ms_1h"`
+	BurnRates        []BurnRate `json:"burn_rates"`
+}
+
+// SLOService evaluates per-provider-config SLOs against collected upstream metrics
+type SLOService struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	metrics *MetricsCollector
+	client  *http.Client
+}
+
+// NewSLOService creates a new SLOService
+func NewSLOService(db *gorm.DB, cfg *config.Config, metrics *MetricsCollector) *SLOService {
+	return &SLOService{
+		db:      db,
+		cfg:     cfg,
+		metrics: metrics,
+		client:  &http.Client{Timeout: sloWebhookTimeout},
+	}
+}
+
+// ownedConfig loads a provider config belonging to userID
+func (s *SLOService) ownedConfig(userID, configID uint) (*database.ProviderConfig, error) {
+	var cfg database.ProviderConfig
+	if err := s.db.Where("id = ? AND user_id = ?", configID, userID).First(&cfg).Error; err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// GetSLO returns the SLO of a provider config
+func (s *SLOService) GetSLO(userID, configID uint) (*database.ProviderSLO, error) {
+	if _, err := s.ownedConfig(userID, configID); err != nil {
+		return nil, err
+	}
+
+	var slo database.ProviderSLO
+	err := s.db.Where("provider_config_id = ?", configID).First(&slo).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSLONotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &slo, nil
+}
+
+// SetSLO creates or replaces the SLO of a provider config
+func (s *SLOService) SetSLO(userID, configID uint, req *SLOUpdate) (*database.ProviderSLO, error) {
+	if req.AvailabilityTarget <= 0 || req.AvailabilityTarget >= 1 {
+		return nil, errors.New("availability_target must be between 0 and 1, e.g. 0.995")
+	}
+	if req.LatencyP95Ms < 0 {
+		return nil, errors.New("latency_p95_ms must not be negative")
+	}
+	if err := validateWebhookURL(req.WebhookURL); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.ownedConfig(userID, configID); err != nil {
+		return nil, err
+	}
+
+	var slo database.ProviderSLO
+	err := s.db.Where("provider_config_id = ?", configID).First(&slo).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	slo.ProviderConfigID = configID
+	slo.AvailabilityTarget = req.AvailabilityTarget
+	slo.LatencyP95Ms = req.LatencyP95Ms
+	slo.WebhookURL = req.WebhookURL
+	if slo.LatencyP95Ms == 0 {
+		slo.LatencyFiring = false
+	}
+	if err := s.db.Save(&slo).Error; err != nil {
+		return nil, err
+	}
+	return &slo, nil
+}
+
+// DeleteSLO removes the SLO of a provider config
+func (s *SLOService) DeleteSLO(userID, configID uint) error {
+	if _, err := s.ownedConfig(userID, configID); err != nil {
+		return err
+	}
+
+	result := s.db.Where("provider_config_id = ?", configID).Delete(&database.ProviderSLO{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSLONotFound
+	}
+	return nil
+}
+
+// burnRates computes the availability and latency burn rates of slo at now
+func (s *SLOService) burnRates(slo *database.ProviderSLO, now time.Time) (WindowStats, []BurnRate) {
+	short := s.metrics.Window(slo.ProviderConfigID, sloShortWindow, now)
+	long := s.metrics.Window(slo.ProviderConfigID, sloLongWindow, now)
+	threshold := s.cfg.SLOBurnAlertThreshold
+
+	breached := func(shortBurn, longBurn float64) bool {
+		return long.Requests >= sloMinRequests && shortBurn > threshold && longBurn > threshold
+	}
+
+	budget := 1 - slo.AvailabilityTarget
+	availability := BurnRate{
+		SLO:       "availability",
+		Target:    slo.AvailabilityTarget,
+		Short:     short.ErrorRate() / budget,
+		Long:      long.ErrorRate() / budget,
+		Threshold: threshold,
+	}
+	availability.Firing = breached(availability.Short, availability.Long)
+	rates := []BurnRate{availability}
+
+	if slo.LatencyP95Ms > 0 {
+		target := time.Duration(slo.LatencyP95Ms) * time.Millisecond
+		latency := BurnRate{
+			SLO:       "latency",
+			Target:    float64(slo.LatencyP95Ms),
+			Short:     short.FractionSlowerThan(target) / sloLatencyBudget,
+			Long:      long.FractionSlowerThan(target) / sloLatencyBudget,
+			Threshold: threshold,
+		}
+		latency.Firing = breached(latency.Short, latency.Long)
+		rates = append(rates, latency)
+	}
+	return long, rates
+}
+
+// Status returns the current burn rates of a provider config's SLO
+func (s *SLOService) Status(userID, configID uint) (*SLOStatus, error) {
+	slo, err := s.GetSLO(userID, configID)
+	if err != nil {
+		return nil, err
+	}
+
+	long, rates := s.burnRates(slo, time.Now())
+	return &SLOStatus{
+		ProviderConfigID: configID,
+		Requests1h:       long.Requests,
+		Errors1h:         long.Errors,
+		P95LatencyMs1h:   long.Quantile(0.95).Milliseconds(),
+		BurnRates:        rates,
+	}, nil
+}
+
+// sloAlert is the JSON payload posted to an SLO webhook
+type sloAlert struct {
+	Status             string    `json:"status"` // firing, resolved
+	ProviderConfigID   uint      `json:"provider_config_id"`
+	ProviderConfigName string    `json:"provider_config_name"`
+	Provider           string    `json:"provider"`
+	SLO                string    `json:"slo"`
+	Target             float64   `json:"target"`
+	BurnRate5m         float64   `json:"burn_rate_5m"`
+	BurnRate1h         float64   `json:"burn_rate_1h"`
+	Threshold          float64   `json:"threshold"`
+	Timestamp          time.Time `json:"timestamp"`
+}
+
+// Evaluate recomputes every SLO and notifies webhooks of alerts that started or resolved
+func (s *SLOService) Evaluate(now time.Time) error {
+	var slos []database.ProviderSLO
+	if err := s.db.Preload("ProviderConfig").Find(&slos).Error; err != nil {
+		return err
+	}
+
+	for i := range slos {
+		slo := &slos[i]
+		if slo.ProviderConfig.ID == 0 {
+			continue
+		}
+
+		_, rates := s.burnRates(slo, now)
+		for _, rate := range rates {
+			column, wasFiring := "availability_firing", slo.AvailabilityFiring
+			if rate.SLO == "latency" {
+				column, wasFiring = "latency_firing", slo.LatencyFiring
+			}
+			if rate.Firing == wasFiring {
+				continue
+			}
+
+			if err := s.db.Model(slo).Update(column, rate.Firing).Error; err != nil {
+				log.Printf("[SLO] Failed to update alert state for config=%d: %v", slo.ProviderConfigID, err)
+				continue
+			}
+
+			status := "resolved"
+			if rate.Firing {
+				status = "firing"
+			}
+			log.Printf("[SLO] %s SLO %s for config=%d (burn 5m=%.2f 1h=%.2f)", rate.SLO, status, slo.ProviderConfigID, rate.Short, rate.Long)
+
+			if slo.WebhookURL != "" {
+				s.sendAlert(slo.WebhookURL, sloAlert{
+					Status:             status,
+					ProviderConfigID:   slo.ProviderConfigID,
+					ProviderConfigName: slo.ProviderConfig.Name,
+					Provider:           slo.ProviderConfig.Provider,
+					SLO:                rate.SLO,
+					Target:             rate.Target,
+					BurnRate5m:         rate.Short,
+					BurnRate1h:         rate.Long,
+					Threshold:          rate.Threshold,
+					Timestamp:          now,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// sendAlert posts an alert to a webhook, logging failures
+func (s *SLOService) sendAlert(webhookURL string, alert sloAlert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+
+	resp, err := s.client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[SLO] Failed to deliver alert for config=%d: %v", alert.ProviderConfigID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[SLO] Webhook for config=%d returned status %d", alert.ProviderConfigID, resp.StatusCode)
+	}
+}
+
+// WritePrometheus writes SLO targets, burn rates and alert states in the Prometheus text format
+func (s *SLOService) WritePrometheus(w io.Writer) error {
+	var slos []database.ProviderSLO
+	if err := s.db.Preload("ProviderConfig").Order("provider_config_id").Find(&slos).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	type sample struct {
+		labels string
+		rate   BurnRate
+	}
+	var samples []sample
+	for i := range slos {
+		slo := &slos[i]
+		if slo.ProviderConfig.ID == 0 {
+			continue
+		}
+		labels := upstreamLabels(slo.ProviderConfigID, slo.ProviderConfig.Provider, slo.ProviderConfig.Name)
+		_, rates := s.burnRates(slo, now)
+		for _, rate := range rates {
+			samples = append(samples, sample{labels: labels + fmt.Sprintf(`,slo="%s"`, rate.SLO), rate: rate})
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP ai_gateway_slo_target SLO objective: availability ratio, or p95 latency in milliseconds.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_slo_target gauge")
+	for _, smp := range samples {
+		fmt.Fprintf(w, "ai_gateway_slo_target{%s} %g\n", smp.labels, smp.rate.Target)
+	}
+
+	fmt.Fprintln(w, "# HELP ai_gateway_slo_burn_rate Error budget burn rate over a trailing window.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_slo_burn_rate gauge")
+	for _, smp := range samples {
+		fmt.Fprintf(w, "ai_gateway_slo_burn_rate{%s,window=\"5m\"} %g\n", smp.labels, smp.rate.Short)
+		fmt.Fprintf(w, "ai_gateway_slo_burn_rate{%s,window=\"1h\"} %g\n", smp.labels, smp.rate.Long)
+	}
+
+	fmt.Fprintln(w, "# HELP ai_gateway_slo_alert_firing Whether both burn rate windows exceed the alert threshold.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_slo_alert_firing gauge")
+	for _, smp := range samples {
+		firing := 0
+		if smp.rate.Firing {
+			firing = 1
+		}
+		fmt.Fprintf(w, "ai_gateway_slo_alert_firing{%s} %d\n", smp.labels, firing)
+	}
+	return nil
+}
+
+// Run evaluates SLOs periodically until ctx is cancelled
+func (s *SLOService) Run(ctx context.Context) {
+	ticker := time.NewTicker(sloEvaluationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Evaluate(time.Now()); err != nil {
+				log.Printf("[SLO] Failed to evaluate SLOs: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/database"
+)
+
+func TestSLOBurnRates(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 30, 0, time.UTC)
+	type observations struct {
+		ago     time.Duration
+		count   int
+		status  int
+		latency time.Duration
+	}
+	ok := func(ago time.Duration, count int) observations {
+		return observations{ago, count, 200, 100 * time.Millisecond}
+	}
+	failed := func(ago time.Duration, count int) observations {
+		return observations{ago, count, 502, 100 * time.Millisecond}
+	}
+
+	tests := []struct {
+		name      string
+		slo       database.ProviderSLO
+		records   []observations
+		want      []BurnRate // SLO, Short, Long and Firing are compared
+		wantTotal uint64
+	}{
+		{
+			name: "no traffic",
+			slo:  database.ProviderSLO{AvailabilityTarget: 0.99},
+			want: []BurnRate{{SLO: "availability"}},
+		},
+		{
+			// 1 error in 100 requests spends a 1% budget exactly
+			name:      "on budget",
+			slo:       database.ProviderSLO{AvailabilityTarget: 0.99},
+			records:   []observations{ok(40*time.Minute, 99), failed(40*time.Minute, 1)},
+			want:      []BurnRate{{SLO: "availability", Short: 0, Long: 1}},
+			wantTotal: 100,
+		},
+		{
+			// 8 errors in the last 20 of 40 requests burn both windows past 14.4
+			name:      "fast burn fires",
+			slo:       database.ProviderSLO{AvailabilityTarget: 0.99},
+			records:   []observations{ok(30*time.Minute, 20), ok(2*time.Minute, 12), failed(2*time.Minute, 8)},
+			want:      []BurnRate{{SLO: "availability", Short: 40, Long: 20, Firing: true}},
+			wantTotal: 40,
+		},
+		{
+			// A short spike diluted by the hour does not fire
+			name:      "short spike only",
+			slo:       database.ProviderSLO{AvailabilityTarget: 0.99},
+			records:   []observations{ok(30*time.Minute, 190), ok(2*time.Minute, 5), failed(2*time.Minute, 5)},
+			want:      []BurnRate{{SLO: "availability", Short: 50, Long: 2.5}},
+			wantTotal: 200,
+		},
+		{
+			name:      "too few requests to alert",
+			slo:       database.ProviderSLO{AvailabilityTarget: 0.99},
+			records:   []observations{failed(time.Minute, 5)},
+			want:      []BurnRate{{SLO: "availability", Short: 100, Long: 100}},
+			wantTotal: 5,
+		},
+		{
+			name:      "errors before the window",
+			slo:       database.ProviderSLO{AvailabilityTarget: 0.99},
+			records:   []observations{failed(2*time.Hour, 50), ok(time.Minute, 20)},
+			want:      []BurnRate{{SLO: "availability"}},
+			wantTotal: 20,
+		},
+		{
+			name:      "client errors spend no budget",
+			slo:       database.ProviderSLO{AvailabilityTarget: 0.99},
+			records:   []observations{{time.Minute, 20, 429, 100 * time.Millisecond}},
+			want:      []BurnRate{{SLO: "availability"}},
+			wantTotal: 20,
+		},
+		{
+			// Every request is slower than the 1s objective, 20 times the 5% allowed
+			name:      "latency fires",
+			slo:       database.ProviderSLO{AvailabilityTarget: 0.99, LatencyP95Ms: 1000},
+			records:   []observations{{2 * time.Minute, 20, 200, 2 * time.Second}},
+			want:      []BurnRate{{SLO: "availability"}, {SLO: "latency", Short: 20, Long: 20, Firing: true}},
+			wantTotal: 20,
+		},
+		{
+			// 2 of 40 requests in the (1s, 2.5s] bucket, half of it above 1.75s:
+			// 2.5% slow is half the latency budget
+			name: "latency within budget",
+			slo:  database.ProviderSLO{AvailabilityTarget: 0.99, LatencyP95Ms: 1750},
+			records: []observations{
+				ok(2*time.Minute, 18), {2 * time.Minute, 2, 200, 2 * time.Second},
+				ok(20*time.Minute, 20),
+			},
+			want:      []BurnRate{{SLO: "availability"}, {SLO: "latency", Short: 1, Long: 0.5}},
+			wantTotal: 40,
+		},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.SLOBurnAlertThreshold = 14.4
+		metrics := NewMetricsCollector()
+		svc := NewSLOService(nil, cfg, metrics)
+
+		providerConfig := &database.ProviderConfig{ID: 1, Provider: "openai", Name: "primary"}
+		for _, r := range tt.records {
+			for i := 0; i < r.count; i++ {
+				metrics.ObserveAt(providerConfig, r.status, r.latency, now.Add(-r.ago))
+			}
+		}
+		tt.slo.ProviderConfigID = providerConfig.ID
+
+		long, rates := svc.burnRates(&tt.slo, now)
+		if long.Requests != tt.wantTotal {
+			t.Errorf("%s: requests in the long window = %d, want %d", tt.name, long.Requests, tt.wantTotal)
+		}
+		if len(rates) != len(tt.want) {
+			t.Fatalf("%s: burn rates = %+v, want %+v", tt.name, rates, tt.want)
+		}
+		for i, want := range tt.want {
+			got := rates[i]
+			if got.SLO != want.SLO || math.Abs(got.Short-want.Short) > 1e-9 || math.Abs(got.Long-want.Long) > 1e-9 || got.Firing != want.Firing {
+				t.Errorf("%s: %s burn rate = 5m %g, 1h %g, firing %v; want 5m %g, 1h %g, firing %v",
+					tt.name, want.SLO, got.Short, got.Long, got.Firing, want.Short, want.Long, want.Firing)
+			}
+			if got.Threshold != 14.4 {
+				t.Errorf("%s: threshold = %g", tt.name, got.Threshold)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// ErrSpendCapReached is returned when the provider configs a request could be
+// routed to have all reached a monthly spend cap
+var ErrSpendCapReached = errors.New("monthly spend cap of the provider config reached")
+
+// SpendCapReached reports whether a provider config has reached its monthly
+// token or cost cap. Counters whose period has ended count as reset.
+func SpendCapReached(cfg *database.ProviderConfig, now time.Time) bool {
+	if !cfg.MonthlyResetAt.After(now) {
+		return false
+	}
+	if cfg.MonthlyTokenCap != nil && cfg.MonthlyTokensUsed >= *cfg.MonthlyTokenCap {
+		return true
+	}
+	if cfg.MonthlyCostCap != nil && cfg.MonthlyCostMicros >= creditsToMicros(*cfg.MonthlyCostCap) {
+		return true
+	}
+	return false
+}
+
+// creditsToMicros converts an amount of credits to ledger units
+func creditsToMicros(credits float64) int64 {
+	return int64(math.Round(credits * MicrosPerCredit))
+}
+
+// validateSpendCaps checks the monthly caps of a provider config
+func validateSpendCaps(tokenCap *int, costCap *float64) error {
+	if tokenCap != nil && *tokenCap < 0 {
+		return errors.New("monthly_token_cap must not be negative")
+	}
+	if costCap != nil && (*costCap < 0 || math.IsNaN(*costCap) || math.IsInf(*costCap, 0)) {
+		return errors.New("monthly_cost_cap must not be negative")
+	}
+	return nil
+}
+
+// chargeProviderConfig adds the tokens and cost of a recorded request to the
+// monthly spend of the provider config that served it, and returns the cost.
+// Requests to unpriced models only count towards the token cap.
+func chargeProviderConfig(db *gorm.DB, record *database.UsageRecord) (int64, error) {
+	if record.ProviderConfigID == nil {
+		return 0, nil
+	}
+	var prices []database.ModelPrice
+	if err := db.Find(&prices).Error; err != nil {
+		return 0, err
+	}
+	cost := requestCost(prices, record.Model, record.PromptTokens, record.CompletionTokens)
+	err := db.Model(&database.ProviderConfig{}).Where("id = ?", *record.ProviderConfigID).UpdateColumns(map[string]interface{}{
+		"monthly_tokens_used": gorm.Expr("monthly_tokens_used + ?", record.TotalTokens),
+		"monthly_cost_micros": gorm.Expr("monthly_cost_micros + ?", cost),
+	}).Error
+	return cost, err
+}
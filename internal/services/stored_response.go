@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// Response statuses, as reported by the Responses API
+const (
+	ResponseStatusQueued     = "queued"
+	ResponseStatusInProgress = "in_progress"
+	ResponseStatusCompleted  = "completed"
+	ResponseStatusFailed     = "failed"
+	ResponseStatusCancelled  = "cancelled"
+)
+
+// pendingResponseStatuses are the statuses of responses that have not finished
+var pendingResponseStatuses = []string{ResponseStatusQueued, ResponseStatusInProgress}
+
+var (
+	// ErrStoredResponseNotFound is returned when the caller has no such stored response
+	ErrStoredResponseNotFound = errors.New("stored response not found")
+	// ErrBackgroundResponseFinished is returned when cancelling a response that already finished
+	ErrBackgroundResponseFinished = errors.New("only queued or in-progress responses can be cancelled")
+)
+
+// StoredResponseService tracks the Responses API responses that callers can
+// retrieve by ID. For background requests to upstreams without background mode
+// it hands out response IDs and keeps the cancel funcs of the requests the
+// gateway is running.
+type StoredResponseService struct {
+	db *gorm.DB
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+// NewStoredResponseService creates a new StoredResponseService. Gateway-run
+// responses left pending by a previous process can no longer finish and are failed.
+func NewStoredResponseService(db *gorm.DB) *StoredResponseService {
+	err := db.Model(&database.StoredResponse{}).
+		Where("emulated = ? AND status IN ?", true, pendingResponseStatuses).
+		Updates(map[string]interface{}{"status": ResponseStatusFailed, "error": "the gateway restarted before the response finished"}).Error
+	if err != nil {
+		log.Printf("[StoredResponses] Failed to fail interrupted responses: %v", err)
+	}
+	return &StoredResponseService{db: db, running: make(map[string]context.CancelFunc)}
+}
+
+// IsResponseStatusFinal reports whether a response with the status has finished
+func IsResponseStatusFinal(status string) bool {
+	return status != "" && status != ResponseStatusQueued && status != ResponseStatusInProgress
+}
+
+// Track records a response stored on the upstream of configID. The usage of a
+// background response is recorded once it is seen finished, see Observe.
+func (s *StoredResponseService) Track(scope, responseID string, configID *uint, model, status string, background bool) error {
+	return s.db.Create(&database.StoredResponse{
+		ResponseID:       responseID,
+		Scope:            scope,
+		ProviderConfigID: configID,
+		Background:       background,
+		Model:            model,
+		Status:           status,
+		UsageRecorded:    !background,
+	}).Error
+}
+
+// Observe stores the status of a tracked upstream response. It reports true the
+// first time a background response is seen finished, when its usage is to be
+// recorded.
+func (s *StoredResponseService) Observe(responseID, status string) (bool, error) {
+	err := s.db.Model(&database.StoredResponse{}).Where("response_id = ?", responseID).Update("status", status).Error
+	if err != nil || !IsResponseStatusFinal(status) {
+		return false, err
+	}
+	result := s.db.Model(&database.StoredResponse{}).
+		Where("response_id = ? AND usage_recorded = ?", responseID, false).
+		Update("usage_recorded", true)
+	return result.RowsAffected == 1, result.Error
+}
+
+// Start records a background response run by the gateway. The returned context
+// is cancelled when the response is cancelled and must be released with Finish.
+func (s *StoredResponseService) Start(scope, model string) (*database.StoredResponse, context.Context, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, nil, err
+	}
+	resp := &database.StoredResponse{
+		ResponseID:    "resp_" + hex.EncodeToString(b),
+		Scope:         scope,
+		Background:    true,
+		Emulated:      true,
+		Model:         model,
+		Status:        ResponseStatusQueued,
+		UsageRecorded: true,
+	}
+	if err := s.db.Create(resp).Error; err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.running[resp.ResponseID] = cancel
+	s.mu.Unlock()
+	return resp, ctx, nil
+}
+
+// MarkInProgress moves a queued gateway-run response to in_progress
+func (s *StoredResponseService) MarkInProgress(responseID string) error {
+	return s.db.Model(&database.StoredResponse{}).
+		Where("response_id = ? AND status = ?", responseID, ResponseStatusQueued).
+		Update("status", ResponseStatusInProgress).Error
+}
+
+// Finish stores the outcome of a gateway-run response, unless it was cancelled
+func (s *StoredResponseService) Finish(responseID, status, response, errMsg string) error {
+	s.mu.Lock()
+	if cancel, ok := s.running[responseID]; ok {
+		cancel()
+		delete(s.running, responseID)
+	}
+	s.mu.Unlock()
+
+	if len(errMsg) > 500 {
+		errMsg = errMsg[:500]
+	}
+	return s.db.Model(&database.StoredResponse{}).
+		Where("response_id = ? AND status IN ?", responseID, pendingResponseStatuses).
+		Updates(map[string]interface{}{"status": status, "response": response, "error": errMsg}).Error
+}
+
+// Get returns a background response owned by scope
+func (s *StoredResponseService) Get(scope, responseID string) (*database.StoredResponse, error) {
+	var resp database.StoredResponse
+	err := s.db.Where("response_id = ? AND scope = ?", responseID, scope).First(&resp).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrStoredResponseNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Cancel stops a gateway-run response that has not finished. Cancelling an
+// already cancelled response is a no-op.
+func (s *StoredResponseService) Cancel(scope, responseID string) (*database.StoredResponse, error) {
+	resp, err := s.Get(scope, responseID)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status == ResponseStatusCancelled {
+		return resp, nil
+	}
+	if IsResponseStatusFinal(resp.Status) {
+		return nil, ErrBackgroundResponseFinished
+	}
+
+	result := s.db.Model(&database.StoredResponse{}).
+		Where("id = ? AND status IN ?", resp.ID, pendingResponseStatuses).
+		Update("status", ResponseStatusCancelled)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// It finished in the meantime
+		return s.Cancel(scope, responseID)
+	}
+
+	s.mu.Lock()
+	if cancel, ok := s.running[responseID]; ok {
+		cancel()
+		delete(s.running, responseID)
+	}
+	s.mu.Unlock()
+	resp.Status = ResponseStatusCancelled
+	return resp, nil
+}
+
+// Delete forgets a response of scope, stopping it first when the gateway is running it
+func (s *StoredResponseService) Delete(scope, responseID string) error {
+	result := s.db.Where("response_id = ? AND scope = ?", responseID, scope).Delete(&database.StoredResponse{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStoredResponseNotFound
+	}
+
+	s.mu.Lock()
+	if cancel, ok := s.running[responseID]; ok {
+		cancel()
+		delete(s.running, responseID)
+	}
+	s.mu.Unlock()
+	return nil
+}
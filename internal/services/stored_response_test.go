@@ -0,0 +1,170 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"ai_gateway/internal/database"
+)
+
+func TestStoredResponse_BackgroundLifecycle(t *testing.T) {
+	tests := []struct {
+		name         string
+		run          func(svc *StoredResponseService, id string) error
+		wantStatus   string
+		wantCancel   bool // the context of the run was cancelled
+		wantResponse string
+	}{
+		{"queued", func(svc *StoredResponseService, id string) error {
+			return nil
+		}, ResponseStatusQueued, false, ""},
+		{"in progress", func(svc *StoredResponseService, id string) error {
+			return svc.MarkInProgress(id)
+		}, ResponseStatusInProgress, false, ""},
+		{"completed", func(svc *StoredResponseService, id string) error {
+			svc.MarkInProgress(id)
+			return svc.Finish(id, ResponseStatusCompleted, `{"id":"`+id+`"}`, "")
+		}, ResponseStatusCompleted, true, "completed"},
+		{"cancelled while queued", func(svc *StoredResponseService, id string) error {
+			_, err := svc.Cancel("key:1", id)
+			return err
+		}, ResponseStatusCancelled, true, ""},
+		{"finished after cancel", func(svc *StoredResponseService, id string) error {
+			svc.MarkInProgress(id)
+			if _, err := svc.Cancel("key:1", id); err != nil {
+				return err
+			}
+			return svc.Finish(id, ResponseStatusCompleted, `{"id":"`+id+`"}`, "")
+		}, ResponseStatusCancelled, true, ""},
+		{"not started after cancel", func(svc *StoredResponseService, id string) error {
+			svc.Cancel("key:1", id)
+			return svc.MarkInProgress(id)
+		}, ResponseStatusCancelled, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewStoredResponseService(testDB(t))
+			record, ctx, err := svc.Start("key:1", "gpt-4o")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !record.Emulated || !record.Background || record.Status != ResponseStatusQueued {
+				t.Fatalf("started %+v, want a queued gateway-run background response", record)
+			}
+			if err := tt.run(svc, record.ResponseID); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := svc.Get("key:1", record.ResponseID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", got.Status, tt.wantStatus)
+			}
+			if (got.Response != "") != (tt.wantResponse != "") {
+				t.Errorf("response = %q, want it stored only once completed", got.Response)
+			}
+			if cancelled := ctx.Err() != nil; cancelled != tt.wantCancel {
+				t.Errorf("context cancelled = %v, want %v", cancelled, tt.wantCancel)
+			}
+		})
+	}
+}
+
+func TestStoredResponse_CancelFinished(t *testing.T) {
+	svc := NewStoredResponseService(testDB(t))
+	record, _, err := svc.Start("key:1", "gpt-4o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Finish(record.ResponseID, ResponseStatusFailed, "", "upstream error")
+
+	if _, err := svc.Cancel("key:1", record.ResponseID); !errors.Is(err, ErrBackgroundResponseFinished) {
+		t.Errorf("cancelling a failed response: err = %v, want ErrBackgroundResponseFinished", err)
+	}
+	if _, err := svc.Cancel("key:2", record.ResponseID); !errors.Is(err, ErrStoredResponseNotFound) {
+		t.Errorf("cancelling another scope's response: err = %v, want ErrStoredResponseNotFound", err)
+	}
+}
+
+func TestStoredResponse_ObserveRecordsUsageOnce(t *testing.T) {
+	tests := []struct {
+		name       string
+		background bool
+		statuses   []string
+		want       []bool // usage to record after each observed status
+	}{
+		{"background", true, []string{ResponseStatusQueued, ResponseStatusInProgress, ResponseStatusCompleted, ResponseStatusCompleted},
+			[]bool{false, false, true, false}},
+		{"background failed", true, []string{ResponseStatusFailed}, []bool{true}},
+		{"foreground", false, []string{ResponseStatusCompleted}, []bool{false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewStoredResponseService(testDB(t))
+			configID := uint(1)
+			if err := svc.Track("key:1", "resp_up", &configID, "gpt-4o", ResponseStatusQueued, tt.background); err != nil {
+				t.Fatal(err)
+			}
+			for i, status := range tt.statuses {
+				record, err := svc.Observe("resp_up", status)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if record != tt.want[i] {
+					t.Errorf("observing %s: record usage = %v, want %v", status, record, tt.want[i])
+				}
+			}
+			got, _ := svc.Get("key:1", "resp_up")
+			if want := tt.statuses[len(tt.statuses)-1]; got.Status != want {
+				t.Errorf("status = %q, want %q", got.Status, want)
+			}
+		})
+	}
+}
+
+func TestStoredResponse_RestartFailsInterruptedRuns(t *testing.T) {
+	db := testDB(t)
+	svc := NewStoredResponseService(db)
+	emulated, _, err := svc.Start("key:1", "gpt-4o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configID := uint(1)
+	if err := svc.Track("key:1", "resp_up", &configID, "gpt-4o", ResponseStatusInProgress, true); err != nil {
+		t.Fatal(err)
+	}
+
+	NewStoredResponseService(db)
+	for id, want := range map[string]string{
+		emulated.ResponseID: ResponseStatusFailed,     // its process is gone
+		"resp_up":           ResponseStatusInProgress, // still running upstream
+	} {
+		var got database.StoredResponse
+		db.Where("response_id = ?", id).First(&got)
+		if got.Status != want {
+			t.Errorf("%s: status = %q, want %q", id, got.Status, want)
+		}
+	}
+}
+
+func TestStoredResponse_DeleteStopsRun(t *testing.T) {
+	svc := NewStoredResponseService(testDB(t))
+	record, ctx, err := svc.Start("key:1", "gpt-4o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Delete("key:2", record.ResponseID); !errors.Is(err, ErrStoredResponseNotFound) {
+		t.Errorf("deleting another scope's response: err = %v, want ErrStoredResponseNotFound", err)
+	}
+	if err := svc.Delete("key:1", record.ResponseID); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() == nil {
+		t.Error("deleting a running response did not cancel it")
+	}
+	if _, err := svc.Get("key:1", record.ResponseID); !errors.Is(err, ErrStoredResponseNotFound) {
+		t.Errorf("getting a deleted response: err = %v, want ErrStoredResponseNotFound", err)
+	}
+}
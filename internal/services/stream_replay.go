@@ -0,0 +1,142 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/utils"
+)
+
+// StreamReplayService keeps the events of streamed responses in memory so
+// that a client whose connection dropped can resume the stream where it left
+// off. Streams are kept until they have been finished for the configured TTL.
+type StreamReplayService struct {
+	ttl      time.Duration
+	maxBytes int
+
+	mu      sync.Mutex
+	streams map[string]*ReplayStream
+}
+
+// ReplayStream is the buffered event sequence of one streamed response
+type ReplayStream struct {
+	ID    string
+	scope string
+
+	mu       sync.Mutex
+	events   [][]byte
+	size     int
+	overflow bool // grew beyond the limit; cannot be resumed
+	done     bool
+	finished time.Time
+	changed  chan struct{} // closed and replaced on every change
+}
+
+// NewStreamReplayService creates a new StreamReplayService
+func NewStreamReplayService(cfg *config.Config) *StreamReplayService {
+	return &StreamReplayService{
+		ttl:      time.Duration(cfg.StreamReplayTTL) * time.Second,
+		maxBytes: cfg.StreamReplayMaxBytes,
+		streams:  make(map[string]*ReplayStream),
+	}
+}
+
+// Enabled reports whether streams are buffered for replay
+func (s *StreamReplayService) Enabled() bool {
+	return s.ttl > 0
+}
+
+// Start registers a new stream of the given auth scope
+func (s *StreamReplayService) Start(scope string) (*ReplayStream, error) {
+	id, err := utils.GenerateRandomString(16)
+	if err != nil {
+		return nil, err
+	}
+	stream := &ReplayStream{ID: "str_" + id, scope: scope, changed: make(chan struct{})}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeLocked(time.Now())
+	s.streams[stream.ID] = stream
+	return stream, nil
+}
+
+// Get returns a stream of the scope that can still be resumed, or nil
+func (s *StreamReplayService) Get(scope, id string) *ReplayStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeLocked(time.Now())
+	stream, ok := s.streams[id]
+	if !ok || stream.scope != scope {
+		return nil
+	}
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.overflow {
+		return nil
+	}
+	return stream
+}
+
+// purgeLocked drops the streams finished longer than the TTL ago
+func (s *StreamReplayService) purgeLocked(now time.Time) {
+	for id, stream := range s.streams {
+		stream.mu.Lock()
+		expired := stream.done && now.Sub(stream.finished) > s.ttl
+		stream.mu.Unlock()
+		if expired {
+			delete(s.streams, id)
+		}
+	}
+}
+
+// Append adds the next event of the stream. Once the stream outgrows the
+// service's limit its events are dropped and it can no longer be resumed.
+func (s *StreamReplayService) Append(stream *ReplayStream, event []byte) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.overflow || stream.done {
+		return
+	}
+	stream.size += len(event)
+	if s.maxBytes > 0 && stream.size > s.maxBytes {
+		stream.overflow = true
+		stream.events = nil
+	} else {
+		stream.events = append(stream.events, append([]byte(nil), event...))
+	}
+	stream.notifyLocked()
+}
+
+// Finish marks the stream complete, starting its TTL
+func (s *StreamReplayService) Finish(stream *ReplayStream) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.done {
+		return
+	}
+	stream.done = true
+	stream.finished = time.Now()
+	stream.notifyLocked()
+}
+
+func (r *ReplayStream) notifyLocked() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Events returns the events following the first after, whether the stream is
+// complete, and a channel closed once more events arrive. ok is false when the
+// stream can no longer be resumed.
+func (r *ReplayStream) Events(after int) (events [][]byte, done bool, changed <-chan struct{}, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.overflow {
+		return nil, true, nil, false
+	}
+	if after < len(r.events) {
+		events = r.events[after:]
+	}
+	return events, r.done, r.changed, true
+}
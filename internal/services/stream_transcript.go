@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxStreamLineBytes bounds an SSE line a StreamTranscript buffers; longer
+// lines are skipped
+const maxStreamLineBytes = 4 * 1024 * 1024
+
+// StreamTranscript assembles the generated text of an SSE response as it is
+// written, so that streams can be reviewed as one message without keeping
+// their chunks. It understands OpenAI chat and Responses, Anthropic Messages
+// and Gemini streams, i.e. the output of every conversion path.
+type StreamTranscript struct {
+	Chunks    int  // data events seen
+	Truncated bool // text beyond the limit was dropped
+
+	max      int // text bytes kept, 0 for no limit
+	pending  []byte
+	skipping bool // inside a line longer than maxStreamLineBytes
+	text     strings.Builder
+}
+
+// NewStreamTranscript creates a StreamTranscript keeping at most maxBytes of
+// text, or all of it when maxBytes is 0
+func NewStreamTranscript(maxBytes int) *StreamTranscript {
+	return &StreamTranscript{max: maxBytes}
+}
+
+// Write feeds the next part of the stream
+func (t *StreamTranscript) Write(p []byte) (int, error) {
+	data := p
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			t.buffer(data)
+			break
+		}
+		t.buffer(data[:i])
+		if !t.skipping {
+			t.addLine(string(t.pending))
+		}
+		t.pending = t.pending[:0]
+		t.skipping = false
+		data = data[i+1:]
+	}
+	return len(p), nil
+}
+
+// buffer appends part of a line, giving up on lines that grow too long
+func (t *StreamTranscript) buffer(part []byte) {
+	if t.skipping {
+		return
+	}
+	if len(t.pending)+len(part) > maxStreamLineBytes {
+		t.pending = t.pending[:0]
+		t.skipping = true
+		return
+	}
+	t.pending = append(t.pending, part...)
+}
+
+// Text returns the text assembled so far, including a final unterminated line
+func (t *StreamTranscript) Text() string {
+	if len(t.pending) > 0 && !t.skipping {
+		t.addLine(string(t.pending))
+		t.pending = t.pending[:0]
+	}
+	return t.text.String()
+}
+
+// addLine adds the text of an SSE data line
+func (t *StreamTranscript) addLine(line string) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "" || data == "[DONE]" {
+		return
+	}
+	t.Chunks++
+
+	text := streamEventText(data)
+	if t.max > 0 && t.text.Len()+len(text) > t.max {
+		cut := t.max - t.text.Len()
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+		t.Truncated = true
+	}
+	t.text.WriteString(text)
+}
+
+// streamEventText returns the generated text of an SSE event
+func streamEventText(data string) string {
+	var event struct {
+		Type    string `json:"type"`
+		Choices []struct {
+			Delta struct {
+				Content string `json:"content"`
+			} `json:"delta"`
+		} `json:"choices"`
+		Delta json.RawMessage `json:"delta"`
+		// Gemini
+		Candidates []struct {
+			Content struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"content"`
+		} `json:"candidates"`
+	}
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return ""
+	}
+
+	switch {
+	case len(event.Choices) > 0:
+		return event.Choices[0].Delta.Content
+	case event.Type == "response.output_text.delta":
+		var delta string
+		if json.Unmarshal(event.Delta, &delta) == nil {
+			return delta
+		}
+	case event.Type == "content_block_delta":
+		var delta struct {
+			Text string `json:"text"`
+		}
+		if json.Unmarshal(event.Delta, &delta) == nil {
+			return delta.Text
+		}
+	case len(event.Candidates) > 0:
+		var out strings.Builder
+		for _, part := range event.Candidates[0].Content.Parts {
+			out.WriteString(part.Text)
+		}
+		return out.String()
+	}
+	return ""
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+)
+
+// streamWatchdogInterval is how often open streams are checked against their max lifetime
+const streamWatchdogInterval = time.Minute
+
+// StreamTracker keeps the upstream streams that are currently open per provider
+// config and force-closes streams that outlive the configured max lifetime
+type StreamTracker struct {
+	cfg *config.Config
+
+	mu      sync.Mutex
+	nextID  uint64
+	streams map[uint64]*openStream
+	series  map[uint]*streamSeries
+}
+
+// openStream is an upstream stream that has not been closed yet
+type openStream struct {
+	configID uint
+	opened   time.Time
+	closer   io.Closer
+}
+
+// streamSeries holds the stream counts of a provider config
+type streamSeries struct {
+	configID    uint
+	provider    string
+	name        string
+	open        int
+	peak        int
+	forceClosed uint64
+}
+
+// NewStreamTracker creates a new StreamTracker
+func NewStreamTracker(cfg *config.Config) *StreamTracker {
+	return &StreamTracker{
+		cfg:     cfg,
+		streams: make(map[uint64]*openStream),
+		series:  make(map[uint]*streamSeries),
+	}
+}
+
+// Open registers a stream opened against cfg, which may be nil when the request
+// was not routed to a provider config. The returned release func must be called
+// once the stream is closed; closer is used to force-close it.
+func (t *StreamTracker) Open(cfg *database.ProviderConfig, closer io.Closer) (release func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var configID uint
+	provider, name := "", ""
+	if cfg != nil {
+		configID, provider, name = cfg.ID, cfg.Provider, cfg.Name
+	}
+	s, ok := t.series[configID]
+	if !ok {
+		s = &streamSeries{configID: configID}
+		t.series[configID] = s
+	}
+	s.provider, s.name = provider, name
+	s.open++
+	if s.open > s.peak {
+		s.peak = s.open
+	}
+
+	t.nextID++
+	id := t.nextID
+	t.streams[id] = &openStream{configID: configID, opened: time.Now(), closer: closer}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if _, ok := t.streams[id]; ok {
+				delete(t.streams, id)
+				t.series[configID].open--
+			}
+		})
+	}
+}
+
+// CloseExpired force-closes the streams opened before now minus maxLifetime and
+// returns how many were closed
+func (t *StreamTracker) CloseExpired(maxLifetime time.Duration, now time.Time) int {
+	t.mu.Lock()
+	var expired []*openStream
+	for id, stream := range t.streams {
+		if now.Sub(stream.opened) < maxLifetime {
+			continue
+		}
+		delete(t.streams, id)
+		s := t.series[stream.configID]
+		s.open--
+		s.forceClosed++
+		expired = append(expired, stream)
+	}
+	t.mu.Unlock()
+
+	for _, stream := range expired {
+		log.Printf("[Streams] Force-closing stream of provider config %d open for %s", stream.configID, now.Sub(stream.opened).Round(time.Second))
+		if err := stream.closer.Close(); err != nil {
+			log.Printf("[Streams] Failed to close stream of provider config %d: %v", stream.configID, err)
+		}
+	}
+	return len(expired)
+}
+
+// Run force-closes streams exceeding STREAM_MAX_LIFETIME_SECONDS periodically
+// until ctx is cancelled
+func (t *StreamTracker) Run(ctx context.Context) {
+	if t.cfg.StreamMaxLifetime <= 0 {
+		return
+	}
+	maxLifetime := time.Duration(t.cfg.StreamMaxLifetime) * time.Second
+
+	ticker := time.NewTicker(streamWatchdogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if closed := t.CloseExpired(maxLifetime, now); closed > 0 {
+				log.Printf("[Streams] Force-closed %d streams exceeding %s", closed, maxLifetime)
+			}
+		}
+	}
+}
+
+// WritePrometheus writes the stream gauges in the Prometheus text exposition format
+func (t *StreamTracker) WritePrometheus(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ids := make([]uint, 0, len(t.series))
+	for id := range t.series {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	fmt.Fprintln(w, "# HELP ai_gateway_upstream_open_streams Upstream streams currently open per provider config.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_upstream_open_streams gauge")
+	for _, id := range ids {
+		s := t.series[id]
+		fmt.Fprintf(w, "ai_gateway_upstream_open_streams{%s} %d\n", upstreamLabels(s.configID, s.provider, s.name), s.open)
+	}
+
+	fmt.Fprintln(w, "# HELP ai_gateway_upstream_open_streams_max Most upstream streams open at once per provider config.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_upstream_open_streams_max gauge")
+	for _, id := range ids {
+		s := t.series[id]
+		fmt.Fprintf(w, "ai_gateway_upstream_open_streams_max{%s} %d\n", upstreamLabels(s.configID, s.provider, s.name), s.peak)
+	}
+
+	fmt.Fprintln(w, "# HELP ai_gateway_upstream_streams_force_closed_total Upstream streams closed for exceeding the max stream lifetime.")
+	fmt.Fprintln(w, "# TYPE ai_gateway_upstream_streams_force_closed_total counter")
+	for _, id := range ids {
+		s := t.series[id]
+		fmt.Fprintf(w, "ai_gateway_upstream_streams_force_closed_total{%s} %d\n", upstreamLabels(s.configID, s.provider, s.name), s.forceClosed)
+	}
+}
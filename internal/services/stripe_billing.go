@@ -0,0 +1,546 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+	"ai_gateway/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	stripeRequestTimeout = 30 * time.Second
+	stripeMaxAttempts    = 5           // sends of an export before it is marked failed
+	stripeSyncLag        = time.Minute // lets in-flight usage records land before a period is closed
+)
+
+// Statuses of a StripeUsageExport
+const (
+	StripeExportPending = "pending"
+	StripeExportSent    = "sent"
+	StripeExportFailed  = "failed"
+)
+
+// stripeBillingMetrics maps the exportable metrics to their aggregate over usage records
+var stripeBillingMetrics = map[string]string{
+	"requests":          "COUNT(*)",
+	"prompt_tokens":     "COALESCE(SUM(prompt_tokens), 0)",
+	"completion_tokens": "COALESCE(SUM(completion_tokens), 0)",
+	"total_tokens":      "COALESCE(SUM(total_tokens), 0)",
+}
+
+// ErrStripeBillingNotFound is returned when a user has no such Stripe billing export
+var ErrStripeBillingNotFound = errors.New("Stripe billing export not found")
+
+// StripeBillingRequest represents the settings of a Stripe billing export
+type StripeBillingRequest struct {
+	APIKeyID   uint    `json:"api_key_id"`
+	EndUser    string  `json:"end_user"`   // end-user ID; empty exports all usage of the key
+	SecretKey  *string `json:"secret_key"` // nil keeps the current key on update
+	CustomerID string  `json:"customer_id"`
+	PriceID    string  `json:"price_id"`
+	Metric     string  `json:"metric"`
+	UnitSize   int     `json:"unit_size"`
+	IsActive   *bool   `json:"is_active"`
+}
+
+// StripeReconciliation compares the usage recorded by the gateway with what
+// was exported to Stripe and what the Stripe meter aggregated for the customer
+type StripeReconciliation struct {
+	BillingID uint      `json:"billing_id"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Metric    string    `json:"metric"`
+	UnitSize  int       `json:"unit_size"`
+
+	GatewayUsage     int64 `json:"gateway_usage"`     // metric total recorded by the gateway in the window
+	ExpectedQuantity int64 `json:"expected_quantity"` // gateway usage in billed units
+	UnsyncedUsage    int64 `json:"unsynced_usage"`    // usage not exported yet, including the carried remainder
+
+	SentQuantity    int64 `json:"sent_quantity"`    // units accepted by Stripe
+	PendingQuantity int64 `json:"pending_quantity"` // units awaiting a retry
+	FailedQuantity  int64 `json:"failed_quantity"`  // units given up on after repeated failures
+
+	// StripeQuantity is the meter's aggregate for the customer. It includes events
+	// from other sources on the same meter and lags a few minutes behind.
+	StripeQuantity *int64 `json:"stripe_quantity"`
+	Discrepancy    *int64 `json:"discrepancy"` // stripe_quantity - sent_quantity
+	StripeError    string `json:"stripe_error,omitempty"`
+
+	Exports []database.StripeUsageExport `json:"exports"`
+}
+
+// StripeBillingService exports metered usage to Stripe for usage-based billing
+type StripeBillingService struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	client *http.Client
+}
+
+// NewStripeBillingService creates a new StripeBillingService
+func NewStripeBillingService(db *gorm.DB, cfg *config.Config) *StripeBillingService {
+	return &StripeBillingService{db: db, cfg: cfg, client: &http.Client{Timeout: stripeRequestTimeout}}
+}
+
+// ListBillings returns all Stripe billing exports of a user
+func (s *StripeBillingService) ListBillings(userID uint) ([]database.StripeBilling, error) {
+	var billings []database.StripeBilling
+	err := s.db.Where("user_id = ?", userID).Order("id").Find(&billings).Error
+	return billings, err
+}
+
+// GetBilling returns a user's Stripe billing export
+func (s *StripeBillingService) GetBilling(userID, id uint) (*database.StripeBilling, error) {
+	var billing database.StripeBilling
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&billing).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrStripeBillingNotFound
+		}
+		return nil, err
+	}
+	return &billing, nil
+}
+
+// apply validates req and copies it onto billing, resolving the meter behind the price
+func (s *StripeBillingService) apply(ctx context.Context, billing *database.StripeBilling, req *StripeBillingRequest) error {
+	var count int64
+	if err := s.db.Model(&database.APIKey{}).Where("id = ? AND user_id = ?", req.APIKeyID, billing.UserID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrAPIKeyNotFound
+	}
+	if _, ok := stripeBillingMetrics[req.Metric]; !ok {
+		return errors.New("metric must be requests, prompt_tokens, completion_tokens or total_tokens")
+	}
+	if req.UnitSize < 1 {
+		return errors.New("unit_size must be at least 1")
+	}
+	customerID := strings.TrimSpace(req.CustomerID)
+	priceID := strings.TrimSpace(req.PriceID)
+	if !strings.HasPrefix(customerID, "cus_") {
+		return errors.New("customer_id must be a Stripe customer ID (cus_...)")
+	}
+	if !strings.HasPrefix(priceID, "price_") {
+		return errors.New("price_id must be a Stripe price ID (price_...)")
+	}
+
+	billing.EndUserID = nil
+	if endUser := strings.TrimSpace(req.EndUser); endUser != "" {
+		tracked, err := NewEndUserService(s.db, s.cfg).Resolve(req.APIKeyID, endUser)
+		if err != nil {
+			return err
+		}
+		billing.EndUserID = &tracked.ID
+	}
+
+	if req.SecretKey != nil {
+		secretKey := strings.TrimSpace(*req.SecretKey)
+		if !strings.HasPrefix(secretKey, "sk_") && !strings.HasPrefix(secretKey, "rk_") {
+			return errors.New("secret_key must be a Stripe secret or restricted key")
+		}
+		encKey, err := s.cfg.GetEncryptionKeyBytes()
+		if err != nil {
+			return err
+		}
+		encrypted, err := utils.EncryptAPIKey(secretKey, encKey)
+		if err != nil {
+			return err
+		}
+		billing.EncryptedSecretKey = encrypted
+	}
+	if billing.EncryptedSecretKey == "" {
+		return errors.New("secret_key is required")
+	}
+
+	billing.APIKeyID = req.APIKeyID
+	billing.CustomerID = customerID
+	billing.Metric = req.Metric
+	billing.UnitSize = req.UnitSize
+	if req.IsActive != nil {
+		billing.IsActive = *req.IsActive
+	}
+	if priceID != billing.PriceID || req.SecretKey != nil {
+		billing.PriceID = priceID
+		return s.resolveMeter(ctx, billing)
+	}
+	return nil
+}
+
+// CreateBilling starts exporting usage to Stripe. Only usage recorded from now on is exported.
+func (s *StripeBillingService) CreateBilling(ctx context.Context, userID uint, req *StripeBillingRequest) (*database.StripeBilling, error) {
+	billing := &database.StripeBilling{
+		UserID:        userID,
+		IsActive:      true,
+		SyncedThrough: time.Now().Truncate(time.Minute),
+	}
+	if err := s.apply(ctx, billing, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(billing).Error; err != nil {
+		return nil, err
+	}
+	return billing, nil
+}
+
+// UpdateBilling changes the settings of a user's Stripe billing export
+func (s *StripeBillingService) UpdateBilling(ctx context.Context, userID, id uint, req *StripeBillingRequest) (*database.StripeBilling, error) {
+	billing, err := s.GetBilling(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.apply(ctx, billing, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(billing).Error; err != nil {
+		return nil, err
+	}
+	return billing, nil
+}
+
+// DeleteBilling stops a Stripe billing export and removes its export history
+func (s *StripeBillingService) DeleteBilling(userID, id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&database.StripeBilling{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrStripeBillingNotFound
+		}
+		return tx.Where("billing_id = ?", id).Delete(&database.StripeUsageExport{}).Error
+	})
+}
+
+// usage returns the metric total of a billing's usage records in [start, end)
+func (s *StripeBillingService) usage(billing *database.StripeBilling, start, end time.Time) (int64, error) {
+	query := s.db.Model(&database.UsageRecord{}).
+		Select(stripeBillingMetrics[billing.Metric]).
+		Where("api_key_id = ? AND created_at >= ? AND created_at < ?", billing.APIKeyID, start, end)
+	if billing.EndUserID != nil {
+		query = query.Where("end_user_id = ?", *billing.EndUserID)
+	}
+	var total int64
+	err := query.Scan(&total).Error
+	return total, err
+}
+
+// Sync closes the period since the last export and sends every unsent export
+// of a billing to Stripe. Usage short of a whole unit is carried to the next period.
+func (s *StripeBillingService) Sync(ctx context.Context, billing *database.StripeBilling) error {
+	end := time.Now().Truncate(time.Minute).Add(-stripeSyncLag)
+	if end.After(billing.SyncedThrough) {
+		usage, err := s.usage(billing, billing.SyncedThrough, end)
+		if err != nil {
+			return err
+		}
+		carry := billing.CarryUsage + usage
+		unit := int64(billing.UnitSize)
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			// The condition on synced_through keeps concurrent syncs from exporting a period twice
+			result := tx.Model(&database.StripeBilling{}).
+				Where("id = ? AND synced_through = ?", billing.ID, billing.SyncedThrough).
+				Updates(map[string]interface{}{"synced_through": end, "carry_usage": carry % unit})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return nil
+			}
+			if carry < unit {
+				return nil
+			}
+			return tx.Create(&database.StripeUsageExport{
+				BillingID:   billing.ID,
+				PeriodStart: billing.SyncedThrough,
+				PeriodEnd:   end,
+				Usage:       usage,
+				Quantity:    carry / unit,
+				Status:      StripeExportPending,
+			}).Error
+		})
+		if err != nil {
+			return err
+		}
+		billing.SyncedThrough = end
+		billing.CarryUsage = carry % unit
+	}
+
+	var exports []database.StripeUsageExport
+	if err := s.db.Where("billing_id = ? AND status = ?", billing.ID, StripeExportPending).Order("id").Find(&exports).Error; err != nil {
+		return err
+	}
+	var lastErr error
+	for i := range exports {
+		if err := s.send(ctx, billing, &exports[i]); err != nil {
+			lastErr = err
+		}
+	}
+
+	lastError := ""
+	if lastErr != nil {
+		lastError = truncateError(lastErr.Error())
+	}
+	if err := s.db.Model(billing).Update("last_error", lastError).Error; err != nil {
+		return err
+	}
+	return lastErr
+}
+
+// send reports one export to Stripe as a meter event. The export ID is the event
+// identifier, so Stripe discards a retry of an event it already received.
+func (s *StripeBillingService) send(ctx context.Context, billing *database.StripeBilling, export *database.StripeUsageExport) error {
+	form := url.Values{
+		"event_name": {billing.EventName},
+		"identifier": {fmt.Sprintf("ai-gateway-export-%d", export.ID)},
+		"timestamp":  {strconv.FormatInt(export.PeriodEnd.Add(-time.Second).Unix(), 10)},
+		"payload[" + billing.CustomerPayloadKey + "]": {billing.CustomerID},
+		"payload[" + billing.ValuePayloadKey + "]":    {strconv.FormatInt(export.Quantity, 10)},
+	}
+	err := s.call(ctx, billing, http.MethodPost, "/v1/billing/meter_events", form, nil)
+
+	export.Attempts++
+	export.Status = StripeExportSent
+	export.Error = ""
+	if err != nil {
+		export.Error = truncateError(err.Error())
+		export.Status = StripeExportPending
+		if export.Attempts >= stripeMaxAttempts {
+			export.Status = StripeExportFailed
+		}
+		log.Printf("[Stripe] Failed to export usage %d of billing %d: %v", export.ID, billing.ID, err)
+	}
+	if saveErr := s.db.Save(export).Error; saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// SyncAll exports the usage of all active billings
+func (s *StripeBillingService) SyncAll(ctx context.Context) {
+	var billings []database.StripeBilling
+	if err := s.db.Where("is_active = ?", true).Find(&billings).Error; err != nil {
+		log.Printf("[Stripe] Failed to load billing exports: %v", err)
+		return
+	}
+	for i := range billings {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.Sync(ctx, &billings[i]); err != nil {
+			log.Printf("[Stripe] Failed to sync billing %d: %v", billings[i].ID, err)
+		}
+	}
+}
+
+// Run exports usage to Stripe periodically until ctx is cancelled
+func (s *StripeBillingService) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.cfg.StripeSyncInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.SyncAll(ctx)
+		}
+	}
+}
+
+// Reconcile reports the usage and exports of a user's billing since the given time
+func (s *StripeBillingService) Reconcile(ctx context.Context, userID, id uint, since time.Time) (*StripeReconciliation, error) {
+	billing, err := s.GetBilling(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &StripeReconciliation{
+		BillingID: billing.ID,
+		Start:     since.Truncate(time.Minute),
+		End:       time.Now().Truncate(time.Minute),
+		Metric:    billing.Metric,
+		UnitSize:  billing.UnitSize,
+	}
+	if report.GatewayUsage, err = s.usage(billing, report.Start, report.End); err != nil {
+		return nil, err
+	}
+	report.ExpectedQuantity = report.GatewayUsage / int64(billing.UnitSize)
+	unsynced, err := s.usage(billing, billing.SyncedThrough, time.Now().Add(time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	report.UnsyncedUsage = unsynced + billing.CarryUsage
+
+	// Exports are timestamped one second before the end of their period
+	err = s.db.Where("billing_id = ? AND period_end > ? AND period_end <= ?", billing.ID, report.Start, report.End).
+		Order("id").Find(&report.Exports).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, export := range report.Exports {
+		switch export.Status {
+		case StripeExportSent:
+			report.SentQuantity += export.Quantity
+		case StripeExportPending:
+			report.PendingQuantity += export.Quantity
+		case StripeExportFailed:
+			report.FailedQuantity += export.Quantity
+		}
+	}
+
+	if quantity, err := s.meterTotal(ctx, billing, report.Start, report.End); err != nil {
+		report.StripeError = err.Error()
+	} else {
+		discrepancy := quantity - report.SentQuantity
+		report.StripeQuantity = &quantity
+		report.Discrepancy = &discrepancy
+	}
+	return report, nil
+}
+
+// meterTotal returns the value aggregated by the billing's meter for its customer in [start, end)
+func (s *StripeBillingService) meterTotal(ctx context.Context, billing *database.StripeBilling, start, end time.Time) (int64, error) {
+	params := url.Values{
+		"customer":   {billing.CustomerID},
+		"start_time": {strconv.FormatInt(start.Unix(), 10)},
+		"end_time":   {strconv.FormatInt(end.Unix(), 10)},
+		"limit":      {"100"},
+	}
+	var total float64
+	for {
+		var page struct {
+			Data []struct {
+				ID              string  `json:"id"`
+				AggregatedValue float64 `json:"aggregated_value"`
+			} `json:"data"`
+			HasMore bool `json:"has_more"`
+		}
+		path := "/v1/billing/meters/" + url.PathEscape(billing.MeterID) + "/event_summaries?" + params.Encode()
+		if err := s.call(ctx, billing, http.MethodGet, path, nil, &page); err != nil {
+			return 0, err
+		}
+		for _, summary := range page.Data {
+			total += summary.AggregatedValue
+		}
+		if !page.HasMore || len(page.Data) == 0 {
+			return int64(total), nil
+		}
+		params.Set("starting_after", page.Data[len(page.Data)-1].ID)
+	}
+}
+
+// resolveMeter looks up the billing meter behind a billing's price
+func (s *StripeBillingService) resolveMeter(ctx context.Context, billing *database.StripeBilling) error {
+	var price struct {
+		Recurring *struct {
+			Meter     string `json:"meter"`
+			UsageType string `json:"usage_type"`
+		} `json:"recurring"`
+	}
+	if err := s.call(ctx, billing, http.MethodGet, "/v1/prices/"+url.PathEscape(billing.PriceID), nil, &price); err != nil {
+		return fmt.Errorf("failed to look up price: %w", err)
+	}
+	if price.Recurring == nil || price.Recurring.UsageType != "metered" || price.Recurring.Meter == "" {
+		return errors.New("price must be a metered price backed by a billing meter")
+	}
+
+	var meter struct {
+		EventName       string `json:"event_name"`
+		Status          string `json:"status"`
+		CustomerMapping struct {
+			EventPayloadKey string `json:"event_payload_key"`
+		} `json:"customer_mapping"`
+		ValueSettings struct {
+			EventPayloadKey string `json:"event_payload_key"`
+		} `json:"value_settings"`
+	}
+	if err := s.call(ctx, billing, http.MethodGet, "/v1/billing/meters/"+url.PathEscape(price.Recurring.Meter), nil, &meter); err != nil {
+		return fmt.Errorf("failed to look up meter: %w", err)
+	}
+	if meter.Status != "" && meter.Status != "active" {
+		return fmt.Errorf("meter %s is %s", price.Recurring.Meter, meter.Status)
+	}
+
+	billing.MeterID = price.Recurring.Meter
+	billing.EventName = meter.EventName
+	billing.CustomerPayloadKey = meter.CustomerMapping.EventPayloadKey
+	if billing.CustomerPayloadKey == "" {
+		billing.CustomerPayloadKey = "stripe_customer_id"
+	}
+	billing.ValuePayloadKey = meter.ValueSettings.EventPayloadKey
+	if billing.ValuePayloadKey == "" {
+		billing.ValuePayloadKey = "value"
+	}
+	return nil
+}
+
+// call sends a request to the Stripe API with a billing's secret key and decodes the response into out
+func (s *StripeBillingService) call(ctx context.Context, billing *database.StripeBilling, method, path string, form url.Values, out interface{}) error {
+	encKey, err := s.cfg.GetEncryptionKeyBytes()
+	if err != nil {
+		return err
+	}
+	secretKey, err := utils.DecryptAPIKey(billing.EncryptedSecretKey, encKey)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(s.cfg.StripeAPIURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+secretKey)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var stripeErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &stripeErr) == nil && stripeErr.Error.Message != "" {
+			return fmt.Errorf("Stripe API error (%d): %s", resp.StatusCode, stripeErr.Error.Message)
+		}
+		return fmt.Errorf("Stripe API error (%d)", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// truncateError shortens an error message to fit its column
+func truncateError(msg string) string {
+	if len(msg) > 500 {
+		return msg[:500]
+	}
+	return msg
+}
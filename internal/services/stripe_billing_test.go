@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// fakeStripe serves the prices, meters and meter events the billing export
+// uses, authenticating requests with secretKey
+type fakeStripe struct {
+	*httptest.Server
+	secretKey string
+
+	mu          sync.Mutex
+	failEvents  int          // meter event requests to fail before accepting
+	identifiers []string     // identifiers of all meter event requests
+	events      []url.Values // accepted meter events
+}
+
+func newFakeStripe(t *testing.T, secretKey string) *fakeStripe {
+	s := &fakeStripe{secretKey: secretKey}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "Bearer "+s.secretKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"Invalid API Key provided"}}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/prices/price_metered":
+			w.Write([]byte(`{"id":"price_metered","recurring":{"usage_type":"metered","meter":"mtr_tokens"}}`))
+		case "/v1/prices/price_licensed":
+			w.Write([]byte(`{"id":"price_licensed","recurring":{"usage_type":"licensed"}}`))
+		case "/v1/prices/price_inactive":
+			w.Write([]byte(`{"id":"price_inactive","recurring":{"usage_type":"metered","meter":"mtr_inactive"}}`))
+		case "/v1/billing/meters/mtr_tokens":
+			w.Write([]byte(`{"id":"mtr_tokens","status":"active","event_name":"api_tokens","customer_mapping":{"event_payload_key":"customer"},"value_settings":{"event_payload_key":"tokens"}}`))
+		case "/v1/billing/meters/mtr_inactive":
+			w.Write([]byte(`{"id":"mtr_inactive","status":"inactive","event_name":"old"}`))
+		case "/v1/billing/meter_events":
+			r.ParseForm()
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.identifiers = append(s.identifiers, r.PostForm.Get("identifier"))
+			if s.failEvents > 0 {
+				s.failEvents--
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error":{"message":"try again"}}`))
+				return
+			}
+			s.events = append(s.events, r.PostForm)
+			w.Write([]byte(`{"object":"billing.meter_event"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"message":"No such resource"}}`))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// received returns the meter events Stripe accepted
+func (s *fakeStripe) received() []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]url.Values(nil), s.events...)
+}
+
+// stripeFixture returns a billing service talking to stripe and a user's API key
+func stripeFixture(t *testing.T, stripe *fakeStripe) (*StripeBillingService, *gorm.DB, *database.APIKey) {
+	db := testDB(t)
+	cfg := testConfig()
+	cfg.StripeAPIURL = stripe.URL
+	user := &database.User{Username: "u", Email: "u@example.com", HashedPassword: "x", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	apiKey := &database.APIKey{UserID: user.ID, Name: "billed", KeyHash: "hash", KeyPrefix: "sk-"}
+	if err := db.Create(apiKey).Error; err != nil {
+		t.Fatal(err)
+	}
+	return NewStripeBillingService(db, cfg), db, apiKey
+}
+
+func stripeBillingRequest(apiKey *database.APIKey, secretKey, priceID, metric string) *StripeBillingRequest {
+	return &StripeBillingRequest{
+		APIKeyID:   apiKey.ID,
+		SecretKey:  &secretKey,
+		CustomerID: "cus_123",
+		PriceID:    priceID,
+		Metric:     metric,
+		UnitSize:   1000,
+	}
+}
+
+func TestStripeBilling_AuthenticatesWithSecretKey(t *testing.T) {
+	stripe := newFakeStripe(t, "sk_test_valid")
+	svc, db, apiKey := stripeFixture(t, stripe)
+	ctx := context.Background()
+
+	if _, err := svc.CreateBilling(ctx, apiKey.UserID, stripeBillingRequest(apiKey, "pk_test_public", "price_metered", "total_tokens")); err == nil || !strings.Contains(err.Error(), "secret or restricted key") {
+		t.Errorf("publishable key: %v", err)
+	}
+	if _, err := svc.CreateBilling(ctx, apiKey.UserID, stripeBillingRequest(apiKey, "sk_test_wrong", "price_metered", "total_tokens")); err == nil || !strings.Contains(err.Error(), "Stripe API error (401): Invalid API Key provided") {
+		t.Errorf("key Stripe refuses: %v", err)
+	}
+
+	billing, err := svc.CreateBilling(ctx, apiKey.UserID, stripeBillingRequest(apiKey, " sk_test_valid ", "price_metered", "total_tokens"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if billing.MeterID != "mtr_tokens" || billing.EventName != "api_tokens" || billing.CustomerPayloadKey != "customer" || billing.ValuePayloadKey != "tokens" {
+		t.Errorf("resolved meter = %+v", billing)
+	}
+	var stored database.StripeBilling
+	db.First(&stored, billing.ID)
+	if stored.EncryptedSecretKey == "" || strings.Contains(stored.EncryptedSecretKey, "sk_test_valid") {
+		t.Error("the secret key must be stored encrypted")
+	}
+
+	// Updates without a secret key keep the stored one
+	req := stripeBillingRequest(apiKey, "", "price_metered", "prompt_tokens")
+	req.SecretKey = nil
+	if _, err := svc.UpdateBilling(ctx, apiKey.UserID, billing.ID, req); err != nil {
+		t.Errorf("update keeping the secret key: %v", err)
+	}
+}
+
+func TestStripeBilling_SyncExportsPeriodOnce(t *testing.T) {
+	stripe := newFakeStripe(t, "sk_test_valid")
+	svc, db, apiKey := stripeFixture(t, stripe)
+	ctx := context.Background()
+
+	billing, err := svc.CreateBilling(ctx, apiKey.UserID, stripeBillingRequest(apiKey, "sk_test_valid", "price_metered", "total_tokens"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Truncate(time.Minute).Add(-time.Hour)
+	db.Model(billing).Update("synced_through", start)
+	billing.SyncedThrough = start
+	for _, tokens := range []int{1200, 1300} {
+		record := &database.UsageRecord{APIKeyID: apiKey.ID, Model: "gpt-4o", TotalTokens: tokens, StatusCode: 200, CreatedAt: start.Add(10 * time.Minute)}
+		if err := db.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first send fails and is retried by the next sync under the same identifier
+	stripe.failEvents = 1
+	stale := *billing
+	if err := svc.Sync(ctx, billing); err == nil {
+		t.Error("a failed send must be reported")
+	}
+	if err := svc.Sync(ctx, billing); err != nil {
+		t.Fatal(err)
+	}
+	// A concurrent sync still holding the old period exports nothing
+	if err := svc.Sync(ctx, &stale); err != nil {
+		t.Fatal(err)
+	}
+
+	var exports []database.StripeUsageExport
+	db.Where("billing_id = ?", billing.ID).Find(&exports)
+	if len(exports) != 1 {
+		t.Fatalf("exports = %+v, want one for the period", exports)
+	}
+	if export := exports[0]; export.Usage != 2500 || export.Quantity != 2 || export.Status != StripeExportSent || export.Attempts != 2 {
+		t.Errorf("export = %+v", export)
+	}
+	var stored database.StripeBilling
+	db.First(&stored, billing.ID)
+	if stored.CarryUsage != 500 || stored.LastError != "" {
+		t.Errorf("carry = %d, last error = %q; want the 500 tokens short of a unit carried", stored.CarryUsage, stored.LastError)
+	}
+
+	events := stripe.received()
+	if len(events) != 1 {
+		t.Fatalf("Stripe accepted %d meter events, want 1", len(events))
+	}
+	event := events[0]
+	if event.Get("event_name") != "api_tokens" || event.Get("payload[customer]") != "cus_123" || event.Get("payload[tokens]") != "2" {
+		t.Errorf("meter event = %v", event)
+	}
+	want := "ai-gateway-export-" + strconv.Itoa(int(exports[0].ID))
+	if len(stripe.identifiers) != 2 || stripe.identifiers[0] != want || stripe.identifiers[1] != want {
+		t.Errorf("identifiers = %q, want both sends as %q so that Stripe drops resent events", stripe.identifiers, want)
+	}
+}
+
+func TestStripeBilling_GivesUpAfterRepeatedFailures(t *testing.T) {
+	stripe := newFakeStripe(t, "sk_test_valid")
+	svc, db, apiKey := stripeFixture(t, stripe)
+	ctx := context.Background()
+
+	billing, err := svc.CreateBilling(ctx, apiKey.UserID, stripeBillingRequest(apiKey, "sk_test_valid", "price_metered", "requests"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	export := &database.StripeUsageExport{BillingID: billing.ID, PeriodEnd: time.Now().Add(-time.Hour), Usage: 3, Quantity: 3, Status: StripeExportPending}
+	db.Create(export)
+
+	stripe.failEvents = stripeMaxAttempts + 1
+	for i := 0; i < stripeMaxAttempts+1; i++ {
+		svc.Sync(ctx, billing)
+	}
+	db.First(export, export.ID)
+	if export.Status != StripeExportFailed || export.Attempts != stripeMaxAttempts || export.Error == "" {
+		t.Errorf("export = %+v, want failed after %d attempts", export, stripeMaxAttempts)
+	}
+}
+
+func TestStripeBilling_RejectsUnknownMetricsAndPrices(t *testing.T) {
+	stripe := newFakeStripe(t, "sk_test_valid")
+	svc, _, apiKey := stripeFixture(t, stripe)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		req     *StripeBillingRequest
+		wantErr string
+	}{
+		{"unknown metric", stripeBillingRequest(apiKey, "sk_test_valid", "price_metered", "cost"), "metric must be"},
+		{"licensed price", stripeBillingRequest(apiKey, "sk_test_valid", "price_licensed", "requests"), "must be a metered price"},
+		{"inactive meter", stripeBillingRequest(apiKey, "sk_test_valid", "price_inactive", "requests"), "meter mtr_inactive is inactive"},
+		{"unknown price", stripeBillingRequest(apiKey, "sk_test_valid", "price_missing", "requests"), "No such resource"},
+		{"not a price ID", stripeBillingRequest(apiKey, "sk_test_valid", "prod_123", "requests"), "price_id must be"},
+		{"another user's key", &StripeBillingRequest{APIKeyID: apiKey.ID + 1, Metric: "requests", UnitSize: 1}, ErrAPIKeyNotFound.Error()},
+	}
+	for _, tt := range tests {
+		if _, err := svc.CreateBilling(ctx, apiKey.UserID, tt.req); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+	if billings, _ := svc.ListBillings(apiKey.UserID); len(billings) != 0 {
+		t.Errorf("refused billings were stored: %+v", billings)
+	}
+}
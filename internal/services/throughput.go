@@ -0,0 +1,185 @@
+package services
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"ai_gateway/internal/database"
+)
+
+// charsPerToken estimates output tokens from streamed text when the stream
+// carries no usage counts
+const charsPerToken = 4
+
+// throughputKey identifies the throughput series of a model on a provider config
+type throughputKey struct {
+	configID uint
+	model    string
+}
+
+// throughputSeries accumulates the successful requests of one model on one provider config
+type throughputSeries struct {
+	provider string
+	name     string
+
+	requests     uint64
+	streamed     uint64
+	ttfb         []uint64 // per latency bucket, plus one for +Inf
+	ttfbSum      float64
+	outputTokens uint64
+	totalSeconds float64 // end-to-end time of all requests
+
+	streamedTokens  uint64
+	streamedSeconds float64 // time from the first to the last streamed byte
+}
+
+// UpstreamOutput describes the output of one successful upstream request
+type UpstreamOutput struct {
+	TTFB         time.Duration // time until the first response byte
+	Duration     time.Duration // end-to-end time of the request
+	Streamed     bool
+	OutputTokens int
+}
+
+// ThroughputStats summarizes the measured speed of a model on a provider config
+type ThroughputStats struct {
+	ProviderConfigID uint    `json:"provider_config_id"`
+	Provider         string  `json:"provider"`
+	Name             string  `json:"name"`
+	Model            string  `json:"model"`
+	Requests         uint64  `json:"requests"`
+	StreamedRequests uint64  `json:"streamed_requests"`
+	AvgTTFBMs        float64 `json:"avg_ttfb_ms"`
+	P50TTFBMs        float64 `json:"p50_ttfb_ms"`
+	P95TTFBMs        float64 `json:"p95_ttfb_ms"`
+	OutputTokens     uint64  `json:"output_tokens"`
+	// TokensPerSecond is the streaming speed once the first byte arrived
+	TokensPerSecond float64 `json:"tokens_per_second"`
+	// EndToEndTokensPerSecond divides all output tokens by the full request time
+	EndToEndTokensPerSecond float64 `json:"end_to_end_tokens_per_second"`
+}
+
+// ObserveThroughput records the output of a successful upstream request
+func (m *MetricsCollector) ObserveThroughput(cfg *database.ProviderConfig, model string, out UpstreamOutput) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := throughputKey{configID: cfg.ID, model: model}
+	s, ok := m.throughput[key]
+	if !ok {
+		s = &throughputSeries{ttfb: make([]uint64, len(latencyBuckets)+1)}
+		m.throughput[key] = s
+	}
+	s.provider = cfg.Provider
+	s.name = cfg.Name
+
+	s.requests++
+	s.ttfb[latencyBucketIndex(out.TTFB)]++
+	s.ttfbSum += out.TTFB.Seconds()
+	s.outputTokens += uint64(out.OutputTokens)
+	s.totalSeconds += out.Duration.Seconds()
+	if out.Streamed {
+		s.streamed++
+		s.streamedTokens += uint64(out.OutputTokens)
+		s.streamedSeconds += (out.Duration - out.TTFB).Seconds()
+	}
+}
+
+// Throughput returns the throughput of every model observed on the given provider configs
+func (m *MetricsCollector) Throughput(configIDs []uint) []ThroughputStats {
+	wanted := make(map[uint]bool, len(configIDs))
+	for _, id := range configIDs {
+		wanted[id] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := []ThroughputStats{}
+	for key, s := range m.throughput {
+		if !wanted[key.configID] || s.requests == 0 {
+			continue
+		}
+		window := WindowStats{Requests: s.requests, latency: s.ttfb}
+		st := ThroughputStats{
+			ProviderConfigID: key.configID,
+			Provider:         s.provider,
+			Name:             s.name,
+			Model:            key.model,
+			Requests:         s.requests,
+			StreamedRequests: s.streamed,
+			AvgTTFBMs:        s.ttfbSum / float64(s.requests) * 1000,
+			P50TTFBMs:        float64(window.Quantile(0.5)) / float64(time.Millisecond),
+			P95TTFBMs:        float64(window.Quantile(0.95)) / float64(time.Millisecond),
+			OutputTokens:     s.outputTokens,
+		}
+		if s.streamedSeconds > 0 {
+			st.TokensPerSecond = float64(s.streamedTokens) / s.streamedSeconds
+		}
+		if s.totalSeconds > 0 {
+			st.EndToEndTokensPerSecond = float64(s.outputTokens) / s.totalSeconds
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].ProviderConfigID != stats[j].ProviderConfigID {
+			return stats[i].ProviderConfigID < stats[j].ProviderConfigID
+		}
+		return stats[i].Model < stats[j].Model
+	})
+	return stats
+}
+
+// tokenUsage holds the output token counts of the supported API formats
+type tokenUsage struct {
+	CompletionTokens int `json:"completion_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+}
+
+func (u *tokenUsage) output() int {
+	if u == nil {
+		return 0
+	}
+	if u.CompletionTokens > 0 {
+		return u.CompletionTokens
+	}
+	return u.OutputTokens
+}
+
+// UsageOutputTokens returns the output token count reported by a response body
+// or stream event of the OpenAI, Anthropic or Gemini APIs, or 0 when it has none.
+// Streams report cumulative counts, so the largest value seen is the total.
+func UsageOutputTokens(data []byte) int {
+	var payload struct {
+		Usage   *tokenUsage `json:"usage"`
+		Message *struct {
+			Usage *tokenUsage `json:"usage"`
+		} `json:"message"` // Anthropic message_start
+		Response *struct {
+			Usage *tokenUsage `json:"usage"`
+		} `json:"response"` // Responses API response.completed
+		UsageMetadata *struct {
+			CandidatesTokenCount int `json:"candidatesTokenCount"`
+		} `json:"usageMetadata"`
+	}
+	if json.Unmarshal(data, &payload) != nil {
+		return 0
+	}
+	switch {
+	case payload.Usage.output() > 0:
+		return payload.Usage.output()
+	case payload.Message != nil && payload.Message.Usage.output() > 0:
+		return payload.Message.Usage.output()
+	case payload.Response != nil && payload.Response.Usage.output() > 0:
+		return payload.Response.Usage.output()
+	case payload.UsageMetadata != nil:
+		return payload.UsageMetadata.CandidatesTokenCount
+	}
+	return 0
+}
+
+// EstimateTokens approximates the token count of generated text of the given length in characters
+func EstimateTokens(chars int) int {
+	return (chars + charsPerToken - 1) / charsPerToken
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ai_gateway/internal/config"
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// usageResetInterval is how often counters whose period has ended are reset
+const usageResetInterval = time.Minute
+
+// nextDailyReset returns the first midnight in loc after now
+func nextDailyReset(now time.Time, loc *time.Location) time.Time {
+	local := now.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc).UTC()
+}
+
+// nextMonthlyReset returns the start of the first month in loc after now
+func nextMonthlyReset(now time.Time, loc *time.Location) time.Time {
+	local := now.In(loc)
+	return time.Date(local.Year(), local.Month()+1, 1, 0, 0, 0, 0, loc).UTC()
+}
+
+// UsageResetService resets the daily and monthly usage counters of API keys and
+// end users, and the monthly spend of provider configs, at calendar boundaries
+// in the configured timezone
+type UsageResetService struct {
+	db  *gorm.DB
+	loc *time.Location
+}
+
+// NewUsageResetService creates a new UsageResetService
+func NewUsageResetService(db *gorm.DB, cfg *config.Config) *UsageResetService {
+	return &UsageResetService{db: db, loc: cfg.UsageResetLocation()}
+}
+
+// usageCounters are the tables holding daily and monthly usage counters
+var usageCounters = []interface{}{&database.APIKey{}, &database.EndUser{}}
+
+// ResetDue zeroes the counters whose period ended before now and schedules
+// their next reset
+func (s *UsageResetService) ResetDue(now time.Time) error {
+	for _, model := range usageCounters {
+		err := s.db.Model(model).Where("daily_reset_at <= ?", now).Updates(map[string]interface{}{
+			"daily_requests_used": 0,
+			"daily_tokens_used":   0,
+			"daily_reset_at":      nextDailyReset(now, s.loc),
+		}).Error
+		if err != nil {
+			return err
+		}
+		err = s.db.Model(model).Where("monthly_reset_at <= ?", now).Updates(map[string]interface{}{
+			"monthly_requests_used": 0,
+			"monthly_tokens_used":   0,
+			"monthly_reset_at":      nextMonthlyReset(now, s.loc),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return s.db.Model(&database.ProviderConfig{}).Where("monthly_reset_at <= ?", now).UpdateColumns(map[string]interface{}{
+		"monthly_tokens_used": 0,
+		"monthly_cost_micros": 0,
+		"monthly_reset_at":    nextMonthlyReset(now, s.loc),
+	}).Error
+}
+
+// Align moves reset times that are not on a calendar boundary, such as those of
+// keys created when periods ran from the moment of creation or before a
+// timezone change, to the next boundary. Counters are kept.
+func (s *UsageResetService) Align(now time.Time) (int64, error) {
+	if err := s.ResetDue(now); err != nil {
+		return 0, err
+	}
+	daily, monthly := nextDailyReset(now, s.loc), nextMonthlyReset(now, s.loc)
+	var aligned int64
+	for _, model := range usageCounters {
+		result := s.db.Model(model).Where("daily_reset_at <> ?", daily).Update("daily_reset_at", daily)
+		if result.Error != nil {
+			return aligned, result.Error
+		}
+		aligned += result.RowsAffected
+		result = s.db.Model(model).Where("monthly_reset_at <> ?", monthly).Update("monthly_reset_at", monthly)
+		if result.Error != nil {
+			return aligned, result.Error
+		}
+		aligned += result.RowsAffected
+	}
+	result := s.db.Model(&database.ProviderConfig{}).Where("monthly_reset_at <> ?", monthly).UpdateColumn("monthly_reset_at", monthly)
+	if result.Error != nil {
+		return aligned, result.Error
+	}
+	return aligned + result.RowsAffected, nil
+}
+
+// Run aligns existing reset times, then resets counters as their periods end
+// until ctx is cancelled
+func (s *UsageResetService) Run(ctx context.Context) {
+	if aligned, err := s.Align(time.Now()); err != nil {
+		log.Printf("[UsageReset] Failed to align reset times: %v", err)
+	} else if aligned > 0 {
+		log.Printf("[UsageReset] Aligned %d reset times to %s calendar boundaries", aligned, s.loc)
+	}
+
+	ticker := time.NewTicker(usageResetInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.ResetDue(now); err != nil {
+				log.Printf("[UsageReset] Failed to reset usage counters: %v", err)
+			}
+		}
+	}
+}
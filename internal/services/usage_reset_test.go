@@ -0,0 +1,204 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"ai_gateway/internal/database"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("timezone %s unavailable: %v", name, err)
+	}
+	return loc
+}
+
+func TestUsageReset_CalendarBoundaries(t *testing.T) {
+	tests := []struct {
+		name        string
+		timezone    string
+		now         string // RFC 3339
+		wantDaily   string
+		wantMonthly string
+	}{
+		{"utc", "UTC", "2024-03-15T12:00:00Z", "2024-03-16T00:00:00Z", "2024-04-01T00:00:00Z"},
+		{"utc at midnight", "UTC", "2024-03-16T00:00:00Z", "2024-03-17T00:00:00Z", "2024-04-01T00:00:00Z"},
+		{"end of year", "UTC", "2024-12-31T23:59:59Z", "2025-01-01T00:00:00Z", "2025-01-01T00:00:00Z"},
+		{"ahead of utc", "Asia/Shanghai", "2024-03-31T17:00:00Z", "2024-04-01T16:00:00Z", "2024-04-30T16:00:00Z"},
+		{"behind utc", "America/New_York", "2024-04-01T02:00:00Z", "2024-04-01T04:00:00Z", "2024-04-01T04:00:00Z"},
+		{"daylight saving starts", "America/New_York", "2024-03-10T12:00:00Z", "2024-03-11T04:00:00Z", "2024-04-01T04:00:00Z"},
+		{"daylight saving ends", "America/New_York", "2024-11-03T12:00:00Z", "2024-11-04T05:00:00Z", "2024-12-01T05:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := mustLoadLocation(t, tt.timezone)
+			now, _ := time.Parse(time.RFC3339, tt.now)
+			if got := nextDailyReset(now, loc).Format(time.RFC3339); got != tt.wantDaily {
+				t.Errorf("daily reset = %s, want %s", got, tt.wantDaily)
+			}
+			if got := nextMonthlyReset(now, loc).Format(time.RFC3339); got != tt.wantMonthly {
+				t.Errorf("monthly reset = %s, want %s", got, tt.wantMonthly)
+			}
+		})
+	}
+}
+
+// usageResetFixture creates a key, an end user of it and a provider config,
+// each having used 5 units in the day and 50 in the month
+func usageResetFixture(t *testing.T, dailyResetAt, monthlyResetAt time.Time) (*UsageResetService, *database.APIKey, *database.EndUser, *database.ProviderConfig) {
+	db := testDB(t)
+	svc := &UsageResetService{db: db, loc: mustLoadLocation(t, "Asia/Shanghai")}
+
+	user := &database.User{Username: "u", Email: "u@example.com", HashedPassword: "x", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	key := &database.APIKey{
+		UserID: user.ID, Name: "k", KeyHash: "hash", IsActive: true,
+		DailyRequestsUsed: 5, DailyTokensUsed: 5, MonthlyRequestsUsed: 50, MonthlyTokensUsed: 50,
+		DailyResetAt: dailyResetAt, MonthlyResetAt: monthlyResetAt,
+	}
+	if err := db.Create(key).Error; err != nil {
+		t.Fatal(err)
+	}
+	endUser := &database.EndUser{
+		APIKeyID: key.ID, ExternalID: "e",
+		DailyRequestsUsed: 5, DailyTokensUsed: 5, MonthlyRequestsUsed: 50, MonthlyTokensUsed: 50,
+		DailyResetAt: dailyResetAt, MonthlyResetAt: monthlyResetAt,
+	}
+	if err := db.Create(endUser).Error; err != nil {
+		t.Fatal(err)
+	}
+	providerConfig := &database.ProviderConfig{
+		UserID: user.ID, Provider: "openai", Name: "p", EncryptedKey: "x",
+		MonthlyTokensUsed: 50, MonthlyCostMicros: 50, MonthlyResetAt: monthlyResetAt,
+	}
+	if err := db.Create(providerConfig).Error; err != nil {
+		t.Fatal(err)
+	}
+	return svc, key, endUser, providerConfig
+}
+
+func TestUsageReset_ResetDue(t *testing.T) {
+	// 2024-03-31 23:30 in Shanghai
+	now := time.Date(2024, 3, 31, 15, 30, 0, 0, time.UTC)
+	nextDay := time.Date(2024, 3, 31, 16, 0, 0, 0, time.UTC)
+	nextMonth := nextDay
+
+	tests := []struct {
+		name                     string
+		dailyResetAt             time.Time
+		monthlyResetAt           time.Time
+		wantDaily, wantMonthly   int
+		wantDailyAt, wantMonthAt time.Time
+	}{
+		{"nothing due", nextDay, nextMonth, 5, 50, nextDay, nextMonth},
+		{"day ended", now.Add(-time.Minute), nextMonth, 0, 50, nextDay, nextMonth},
+		{"day and month ended", now.Add(-time.Minute), now, 0, 0, nextDay, nextMonth},
+		{"long idle", now.AddDate(0, -2, 0), now.AddDate(0, -2, 0), 0, 0, nextDay, nextMonth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, key, endUser, providerConfig := usageResetFixture(t, tt.dailyResetAt, tt.monthlyResetAt)
+			if err := svc.ResetDue(now); err != nil {
+				t.Fatal(err)
+			}
+			svc.db.First(key, key.ID)
+			svc.db.First(endUser, endUser.ID)
+			svc.db.First(providerConfig, providerConfig.ID)
+
+			if key.DailyRequestsUsed != tt.wantDaily || key.DailyTokensUsed != tt.wantDaily ||
+				key.MonthlyRequestsUsed != tt.wantMonthly || key.MonthlyTokensUsed != tt.wantMonthly {
+				t.Errorf("key counters = %d/%d/%d/%d, want daily %d and monthly %d", key.DailyRequestsUsed, key.DailyTokensUsed,
+					key.MonthlyRequestsUsed, key.MonthlyTokensUsed, tt.wantDaily, tt.wantMonthly)
+			}
+			if endUser.DailyRequestsUsed != tt.wantDaily || endUser.MonthlyTokensUsed != tt.wantMonthly {
+				t.Errorf("end user counters = %d/%d, want daily %d and monthly %d", endUser.DailyRequestsUsed,
+					endUser.MonthlyTokensUsed, tt.wantDaily, tt.wantMonthly)
+			}
+			if providerConfig.MonthlyTokensUsed != tt.wantMonthly || providerConfig.MonthlyCostMicros != int64(tt.wantMonthly) {
+				t.Errorf("provider config spend = %d/%d, want %d", providerConfig.MonthlyTokensUsed,
+					providerConfig.MonthlyCostMicros, tt.wantMonthly)
+			}
+			if !key.DailyResetAt.Equal(tt.wantDailyAt) || !key.MonthlyResetAt.Equal(tt.wantMonthAt) {
+				t.Errorf("key resets at %s and %s, want %s and %s", key.DailyResetAt, key.MonthlyResetAt, tt.wantDailyAt, tt.wantMonthAt)
+			}
+			if !providerConfig.MonthlyResetAt.Equal(tt.wantMonthAt) {
+				t.Errorf("provider config resets at %s, want %s", providerConfig.MonthlyResetAt, tt.wantMonthAt)
+			}
+		})
+	}
+}
+
+func TestUsageReset_AlignKeepsCounters(t *testing.T) {
+	// 2024-03-15 20:00 in Shanghai, with resets left over from rolling periods
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	svc, key, endUser, providerConfig := usageResetFixture(t, now.Add(7*time.Hour), now.AddDate(0, 0, 10))
+
+	aligned, err := svc.Align(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aligned != 5 {
+		t.Errorf("aligned %d reset times, want 5", aligned)
+	}
+	svc.db.First(key, key.ID)
+	svc.db.First(endUser, endUser.ID)
+	svc.db.First(providerConfig, providerConfig.ID)
+
+	wantDaily := time.Date(2024, 3, 15, 16, 0, 0, 0, time.UTC)
+	wantMonthly := time.Date(2024, 3, 31, 16, 0, 0, 0, time.UTC)
+	for name, resets := range map[string][]time.Time{
+		"key":      {key.DailyResetAt, key.MonthlyResetAt},
+		"end user": {endUser.DailyResetAt, endUser.MonthlyResetAt},
+	} {
+		if !resets[0].Equal(wantDaily) || !resets[1].Equal(wantMonthly) {
+			t.Errorf("%s resets at %s and %s, want %s and %s", name, resets[0], resets[1], wantDaily, wantMonthly)
+		}
+	}
+	if !providerConfig.MonthlyResetAt.Equal(wantMonthly) {
+		t.Errorf("provider config resets at %s, want %s", providerConfig.MonthlyResetAt, wantMonthly)
+	}
+	if key.DailyRequestsUsed != 5 || key.MonthlyTokensUsed != 50 || providerConfig.MonthlyCostMicros != 50 {
+		t.Errorf("aligning reset counters: key %d/%d, provider config %d", key.DailyRequestsUsed,
+			key.MonthlyTokensUsed, providerConfig.MonthlyCostMicros)
+	}
+
+	if aligned, err := svc.Align(now); err != nil || aligned != 0 {
+		t.Errorf("aligning again: aligned %d, err %v, want nothing to align", aligned, err)
+	}
+}
+
+func TestUsageReset_TightestLimitsCountEndedPeriodsAsReset(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	nextDay := nextDailyReset(now, time.UTC)
+	nextMonth := nextMonthlyReset(now, time.UTC)
+	ten, hundred := 10, 100
+
+	tests := []struct {
+		name          string
+		dailyResetAt  time.Time
+		wantRemaining int
+		wantResetAt   time.Time
+	}{
+		{"day running", nextDay, 4, nextDay},
+		{"day ended", now.Add(-time.Hour), 10, nextDay},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := &database.APIKey{
+				DailyRequestLimit: &ten, MonthlyRequestLimit: &hundred, DailyRequestsUsed: 6, MonthlyRequestsUsed: 20,
+				DailyResetAt: tt.dailyResetAt, MonthlyResetAt: nextMonth,
+			}
+			requests, tokens := TightestLimits(key, now, time.UTC)
+			if tokens != nil {
+				t.Errorf("tokens = %+v, want nil for a key without token limits", tokens)
+			}
+			if requests == nil || requests.Limit != 10 || requests.Remaining != tt.wantRemaining || !requests.ResetAt.Equal(tt.wantResetAt) {
+				t.Errorf("requests = %+v, want the daily limit with %d remaining until %s", requests, tt.wantRemaining, tt.wantResetAt)
+			}
+		})
+	}
+}
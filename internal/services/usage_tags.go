@@ -0,0 +1,119 @@
+package services
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// Limits on the attribution tags of one request
+const (
+	maxUsageTags      = 10
+	maxUsageTagLength = 64
+)
+
+// usageTagChars are the characters kept in a usage tag
+var usageTagChars = regexp.MustCompile(`[^a-zA-Z0-9_.:@/=-]`)
+
+// TagUsage is the usage attributed to one tag
+type TagUsage struct {
+	Tag              string `json:"tag"`
+	Requests         int    `json:"requests"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+}
+
+// NormalizeUsageTags cleans client-supplied tags: invalid characters are
+// dropped, tags are lowercased, deduplicated, sorted and capped in number and length
+func NormalizeUsageTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	var out []string
+	for _, tag := range tags {
+		tag = usageTagChars.ReplaceAllString(strings.ToLower(strings.TrimSpace(tag)), "")
+		if len(tag) > maxUsageTagLength {
+			tag = tag[:maxUsageTagLength]
+		}
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	if len(out) > maxUsageTags {
+		out = out[:maxUsageTags]
+	}
+	return out
+}
+
+// whereTagged limits a usage record query to records attributed to tag
+func whereTagged(query *gorm.DB, tag string) *gorm.DB {
+	escaped := likeEscaper.Replace(tag)
+	return query.Where(`(',' || tags || ',') LIKE ? ESCAPE '\'`, "%,"+escaped+",%")
+}
+
+// GetTagUsage splits the usage of an API key since the given time by tag,
+// ordered by total tokens. Untagged usage is reported under the empty tag.
+func (s *APIKeyService) GetTagUsage(userID, keyID uint, since time.Time) ([]TagUsage, error) {
+	if _, err := s.GetAPIKeyByID(userID, keyID); err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Tags             string
+		Requests         int
+		PromptTokens     int
+		CompletionTokens int
+		TotalTokens      int
+	}
+	err := s.db.Model(&database.UsageRecord{}).
+		Select("tags, COUNT(*) AS requests, SUM(prompt_tokens) AS prompt_tokens, "+
+			"SUM(completion_tokens) AS completion_tokens, SUM(total_tokens) AS total_tokens").
+		Where("api_key_id = ? AND created_at >= ?", keyID, since).
+		Group("tags").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]*TagUsage)
+	add := func(tag string, row *TagUsage) {
+		t, ok := totals[tag]
+		if !ok {
+			t = &TagUsage{Tag: tag}
+			totals[tag] = t
+		}
+		t.Requests += row.Requests
+		t.PromptTokens += row.PromptTokens
+		t.CompletionTokens += row.CompletionTokens
+		t.TotalTokens += row.TotalTokens
+	}
+	for _, row := range rows {
+		usage := &TagUsage{Requests: row.Requests, PromptTokens: row.PromptTokens, CompletionTokens: row.CompletionTokens, TotalTokens: row.TotalTokens}
+		if row.Tags == "" {
+			add("", usage)
+			continue
+		}
+		for _, tag := range strings.Split(row.Tags, ",") {
+			add(tag, usage)
+		}
+	}
+
+	result := make([]TagUsage, 0, len(totals))
+	for _, t := range totals {
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TotalTokens != result[j].TotalTokens {
+			return result[i].TotalTokens > result[j].TotalTokens
+		}
+		return result[i].Tag < result[j].Tag
+	})
+	return result, nil
+}
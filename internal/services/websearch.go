@@ -0,0 +1,142 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ai_gateway/internal/config"
+)
+
+const webSearchTimeout = 15 * time.Second
+
+// Default endpoints of the supported search APIs
+const (
+	braveSearchURL  = "https://api.search.brave.com/res/v1/web/search"
+	tavilySearchURL = "https://api.tavily.com/search"
+)
+
+// SearchResult is one web search hit
+type SearchResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet"`
+}
+
+// WebSearchService queries the configured search API for the web_search tool
+type WebSearchService struct {
+	cfg    *config.Config
+	client *http.Client
+}
+
+// NewWebSearchService creates a new WebSearchService
+func NewWebSearchService(cfg *config.Config) *WebSearchService {
+	return &WebSearchService{cfg: cfg, client: &http.Client{Timeout: webSearchTimeout}}
+}
+
+// Enabled reports whether a search API is configured
+func (s *WebSearchService) Enabled() bool {
+	return s.cfg.WebSearchProvider != ""
+}
+
+// Search returns the top results for query
+func (s *WebSearchService) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("empty search query")
+	}
+
+	endpoint := s.cfg.WebSearchURL
+	var req *http.Request
+	var err error
+	switch s.cfg.WebSearchProvider {
+	case "brave":
+		if endpoint == "" {
+			endpoint = braveSearchURL
+		}
+		params := url.Values{"q": {query}, "count": {strconv.Itoa(s.cfg.WebSearchMaxResults)}}
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+params.Encode(), nil)
+		if err == nil {
+			req.Header.Set("X-Subscription-Token", s.cfg.WebSearchAPIKey)
+		}
+	case "tavily":
+		if endpoint == "" {
+			endpoint = tavilySearchURL
+		}
+		body, _ := json.Marshal(map[string]interface{}{"query": query, "max_results": s.cfg.WebSearchMaxResults})
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+s.cfg.WebSearchAPIKey)
+		}
+	case "searxng":
+		params := url.Values{"q": {query}, "format": {"json"}}
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(endpoint, "/")+"/search?"+params.Encode(), nil)
+	default:
+		return nil, fmt.Errorf("unsupported web search provider %q", s.cfg.WebSearchProvider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("search API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	// Brave nests results under "web"; Tavily and SearXNG use "content" for the snippet
+	var payload struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("invalid search API response: %w", err)
+	}
+
+	var results []SearchResult
+	for _, r := range payload.Web.Results {
+		results = append(results, SearchResult{Title: r.Title, URL: r.URL, Snippet: r.Description})
+	}
+	for _, r := range payload.Results {
+		results = append(results, SearchResult{Title: r.Title, URL: r.URL, Snippet: r.Content})
+	}
+	if len(results) > s.cfg.WebSearchMaxResults {
+		results = results[:s.cfg.WebSearchMaxResults]
+	}
+	return results, nil
+}
+
+// FormatSearchResults renders results as text for a model
+func FormatSearchResults(results []SearchResult) string {
+	if len(results) == 0 {
+		return "No results found."
+	}
+	var b strings.Builder
+	for i, r := range results {
+		fmt.Fprintf(&b, "[%d] %s\n%s\n%s\n\n", i+1, r.Title, r.URL, strings.TrimSpace(r.Snippet))
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -97,6 +97,54 @@ func DecryptAPIKey(encryptedKey string, encryptionKey []byte) (string, error) {
 	return string(plaintext), nil
 }
 
+// EncryptBytes encrypts data using AES-256-GCM, prefixing the output with the nonce
+func EncryptBytes(data []byte, encryptionKey []byte) ([]byte, error) {
+	if len(encryptionKey) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+// DecryptBytes decrypts data produced by EncryptBytes
+func DecryptBytes(data []byte, encryptionKey []byte) ([]byte, error) {
+	if len(encryptionKey) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	return gcm.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+}
+
 // GetAPIKeyHint returns a hint for an API key (e.g., "sk-...Hx4f")
 func GetAPIKeyHint(apiKey string) string {
 	if len(apiKey) <= 8 {
@@ -0,0 +1,75 @@
+package utils
+
+import "strings"
+
+// maxDiffLines bounds the lines of each side DiffLines aligns; longer texts
+// are diffed as a whole replacement
+const maxDiffLines = 2000
+
+// DiffLines returns a line diff of two texts: lines only in a are prefixed
+// with "-", lines only in b with "+" and lines in both with a space. It is
+// empty when the texts are equal.
+func DiffLines(a, b string) string {
+	if a == b {
+		return ""
+	}
+	oldLines := strings.Split(a, "\n")
+	newLines := strings.Split(b, "\n")
+
+	var out strings.Builder
+	write := func(prefix, line string) {
+		out.WriteString(prefix)
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if len(oldLines) > maxDiffLines || len(newLines) > maxDiffLines {
+		for _, line := range oldLines {
+			write("-", line)
+		}
+		for _, line := range newLines {
+			write("+", line)
+		}
+		return out.String()
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// oldLines[i:] and newLines[j:]
+	lcs := make([][]int, len(oldLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(oldLines) && j < len(newLines) {
+		switch {
+		case oldLines[i] == newLines[j]:
+			write(" ", oldLines[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			write("-", oldLines[i])
+			i++
+		default:
+			write("+", newLines[j])
+			j++
+		}
+	}
+	for ; i < len(oldLines); i++ {
+		write("-", oldLines[i])
+	}
+	for ; j < len(newLines); j++ {
+		write("+", newLines[j])
+	}
+	return out.String()
+}
@@ -0,0 +1,309 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSchemaErrors bounds the number of violations ValidateJSONSchema reports
+const maxSchemaErrors = 20
+
+// ValidateJSONSchema checks a decoded JSON value against a JSON Schema and returns
+// the violations found, each prefixed with the JSON pointer of the offending value.
+// It supports the subset of JSON Schema used for structured model output: type,
+// enum, const, properties, required, additionalProperties, items, prefixItems,
+// string/number/array bounds, pattern, allOf/anyOf/oneOf/not and local $refs.
+func ValidateJSONSchema(schema, value interface{}) []string {
+	v := &schemaValidator{root: schema}
+	v.validate(schema, value, "")
+	return v.errors
+}
+
+type schemaValidator struct {
+	root   interface{}
+	errors []string
+	depth  int
+}
+
+func (v *schemaValidator) fail(path, format string, args ...interface{}) {
+	if len(v.errors) >= maxSchemaErrors {
+		return
+	}
+	if path == "" {
+		path = "/"
+	}
+	v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+// resolve follows a local "#/..." reference
+func (v *schemaValidator) resolve(ref string) (interface{}, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+	node := v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// check reports whether value matches schema without recording errors
+func (v *schemaValidator) check(schema, value interface{}, path string) bool {
+	sub := &schemaValidator{root: v.root, depth: v.depth}
+	sub.validate(schema, value, path)
+	return len(sub.errors) == 0
+}
+
+func (v *schemaValidator) validate(schema, value interface{}, path string) {
+	if b, ok := schema.(bool); ok {
+		if !b {
+			v.fail(path, "no value is allowed here")
+		}
+		return
+	}
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	v.depth++
+	defer func() { v.depth-- }()
+	if v.depth > 64 {
+		v.fail(path, "schema nesting too deep")
+		return
+	}
+
+	if ref, ok := s["$ref"].(string); ok {
+		target, found := v.resolve(ref)
+		if !found {
+			v.fail(path, "unresolvable $ref %q", ref)
+			return
+		}
+		v.validate(target, value, path)
+	}
+
+	if t, ok := s["type"]; ok && !matchesType(t, value) {
+		v.fail(path, "expected %s, got %s", typeNames(t), jsonTypeOf(value))
+		return
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "value is not one of the allowed values")
+		}
+	}
+	if c, ok := s["const"]; ok && !jsonEqual(c, value) {
+		v.fail(path, "value does not equal the required constant")
+	}
+
+	switch val := value.(type) {
+	case string:
+		n := float64(utf8.RuneCountInString(val))
+		if min, ok := number(s["minLength"]); ok && n < min {
+			v.fail(path, "string shorter than %v", min)
+		}
+		if max, ok := number(s["maxLength"]); ok && n > max {
+			v.fail(path, "string longer than %v", max)
+		}
+		if p, ok := s["pattern"].(string); ok {
+			if re, err := regexp.Compile(p); err == nil && !re.MatchString(val) {
+				v.fail(path, "string does not match pattern %q", p)
+			}
+		}
+	case float64:
+		if min, ok := number(s["minimum"]); ok && val < min {
+			v.fail(path, "value below minimum %v", min)
+		}
+		if max, ok := number(s["maximum"]); ok && val > max {
+			v.fail(path, "value above maximum %v", max)
+		}
+		if min, ok := number(s["exclusiveMinimum"]); ok && val <= min {
+			v.fail(path, "value must be greater than %v", min)
+		}
+		if max, ok := number(s["exclusiveMaximum"]); ok && val >= max {
+			v.fail(path, "value must be less than %v", max)
+		}
+		if m, ok := number(s["multipleOf"]); ok && m > 0 {
+			if q := val / m; math.Abs(q-math.Round(q)) > 1e-9 {
+				v.fail(path, "value is not a multiple of %v", m)
+			}
+		}
+	case []interface{}:
+		n := float64(len(val))
+		if min, ok := number(s["minItems"]); ok && n < min {
+			v.fail(path, "array has fewer than %v items", min)
+		}
+		if max, ok := number(s["maxItems"]); ok && n > max {
+			v.fail(path, "array has more than %v items", max)
+		}
+		prefix, _ := s["prefixItems"].([]interface{})
+		for i, item := range val {
+			itemPath := fmt.Sprintf("%s/%d", path, i)
+			if i < len(prefix) {
+				v.validate(prefix[i], item, itemPath)
+			} else if items, ok := s["items"]; ok {
+				v.validate(items, item, itemPath)
+			}
+		}
+		if unique, _ := s["uniqueItems"].(bool); unique {
+			for i := range val {
+				for j := i + 1; j < len(val); j++ {
+					if jsonEqual(val[i], val[j]) {
+						v.fail(path, "array items %d and %d are equal", i, j)
+					}
+				}
+			}
+		}
+	case map[string]interface{}:
+		if required, ok := s["required"].([]interface{}); ok {
+			for _, r := range required {
+				if name, ok := r.(string); ok {
+					if _, present := val[name]; !present {
+						v.fail(path, "missing required property %q", name)
+					}
+				}
+			}
+		}
+		props, _ := s["properties"].(map[string]interface{})
+		for name, item := range val {
+			itemPath := path + "/" + strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+			if propSchema, ok := props[name]; ok {
+				v.validate(propSchema, item, itemPath)
+				continue
+			}
+			if additional, ok := s["additionalProperties"]; ok {
+				if b, isBool := additional.(bool); isBool && !b {
+					v.fail(path, "unexpected property %q", name)
+				} else {
+					v.validate(additional, item, itemPath)
+				}
+			}
+		}
+		if min, ok := number(s["minProperties"]); ok && float64(len(val)) < min {
+			v.fail(path, "object has fewer than %v properties", min)
+		}
+		if max, ok := number(s["maxProperties"]); ok && float64(len(val)) > max {
+			v.fail(path, "object has more than %v properties", max)
+		}
+	}
+
+	if all, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			v.validate(sub, value, path)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if v.check(sub, value, path) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "value matches none of the anyOf schemas")
+		}
+	}
+	if one, ok := s["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, sub := range one {
+			if v.check(sub, value, path) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			v.fail(path, "value matches %d of the oneOf schemas, expected exactly one", matches)
+		}
+	}
+	if not, ok := s["not"]; ok && v.check(not, value, path) {
+		v.fail(path, "value matches a schema it must not match")
+	}
+}
+
+func number(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return "unknown"
+	}
+}
+
+func matchesType(t, value interface{}) bool {
+	actual := jsonTypeOf(value)
+	matches := func(name string) bool {
+		return name == actual || (name == "number" && actual == "integer")
+	}
+	switch tt := t.(type) {
+	case string:
+		return matches(tt)
+	case []interface{}:
+		for _, name := range tt {
+			if s, ok := name.(string); ok && matches(s) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func typeNames(t interface{}) string {
+	if list, ok := t.([]interface{}); ok {
+		names := make([]string, 0, len(list))
+		for _, name := range list {
+			names = append(names, fmt.Sprint(name))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+// jsonEqual compares two decoded JSON values
+func jsonEqual(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
@@ -13,14 +13,19 @@ type JWTClaims struct {
 	jwt.RegisteredClaims
 }
 
-// CreateAccessToken creates a new JWT access token
-func CreateAccessToken(userID uint, secret string, expirationMinutes int) (string, error) {
+// accessTokenSubject distinguishes login session access tokens from other
+// tokens signed with the same secret
+const accessTokenSubject = "access_token"
+
+// CreateAccessToken creates a new JWT access token for a login session
+func CreateAccessToken(userID uint, sessionID string, secret string, expirationMinutes int) (string, error) {
 	claims := JWTClaims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(expirationMinutes) * time.Minute)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Subject:   "access_token",
+			Subject:   accessTokenSubject,
 		},
 	}
 
@@ -35,7 +40,7 @@ func DecodeAccessToken(tokenString string, secret string) (*JWTClaims, error) {
 			return nil, errors.New("invalid signing method")
 		}
 		return []byte(secret), nil
-	})
+	}, jwt.WithSubject(accessTokenSubject))
 
 	if err != nil {
 		return nil, err
@@ -47,3 +52,52 @@ func DecodeAccessToken(tokenString string, secret string) (*JWTClaims, error) {
 
 	return nil, errors.New("invalid token")
 }
+
+// ephemeralTokenSubject distinguishes ephemeral gateway tokens from access tokens
+const ephemeralTokenSubject = "ephemeral_token"
+
+// EphemeralClaims represents the claims of a short-lived gateway token minted
+// from an API key, optionally limited to some models and endpoints
+type EphemeralClaims struct {
+	APIKeyID  uint     `json:"api_key_id"`
+	Models    []string `json:"models,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// CreateEphemeralToken creates a signed ephemeral gateway token expiring at expiresAt
+func CreateEphemeralToken(apiKeyID uint, models, endpoints []string, secret string, expiresAt time.Time) (string, error) {
+	claims := EphemeralClaims{
+		APIKeyID:  apiKeyID,
+		Models:    models,
+		Endpoints: endpoints,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Subject:   ephemeralTokenSubject,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+// DecodeEphemeralToken decodes and validates an ephemeral gateway token
+func DecodeEphemeralToken(tokenString string, secret string) (*EphemeralClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &EphemeralClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("invalid signing method")
+		}
+		return []byte(secret), nil
+	}, jwt.WithSubject(ephemeralTokenSubject))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*EphemeralClaims); ok && token.Valid {
+		return claims, nil
+	}
+
+	return nil, errors.New("invalid token")
+}
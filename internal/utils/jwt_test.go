@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeAccessToken_RequiresAccessTokenSubject(t *testing.T) {
+	access, err := CreateAccessToken(7, "session", "secret", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := DecodeAccessToken(access, "secret")
+	if err != nil || claims.UserID != 7 || claims.ID != "session" {
+		t.Fatalf("DecodeAccessToken = %+v, %v", claims, err)
+	}
+
+	// An ephemeral token signed with the same secret is no access token
+	ephemeral, err := CreateEphemeralToken(7, nil, nil, "secret", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeAccessToken(ephemeral, "secret"); err == nil {
+		t.Error("an ephemeral token must not decode as an access token")
+	}
+	if _, err := DecodeEphemeralToken(access, "secret"); err == nil {
+		t.Error("an access token must not decode as an ephemeral token")
+	}
+}